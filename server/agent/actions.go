@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"github.com/lute/agent/actions"
+	"github.com/lute/agent/logging"
+
+	pb "github.com/lute/agent/proto/agent"
+)
+
+// keptResults is how many results of finished actions are remembered, so a
+// request resent after a reconnect is answered without running it again.
+const keptResults = 256
+
+// actionRunner carries out the actions the server requests, each in its own
+// goroutine, and queues their results until they are sent. Actions keep
+// running across reconnects; their results go out on the next stream.
+type actionRunner struct {
+	ctx    context.Context
+	policy actions.Policy
+
+	mu      sync.Mutex
+	running map[string]bool
+	done    map[string]*pb.ActionResult
+	doneIDs []string // oldest first
+	queue   []*pb.ActionResult
+	ready   chan struct{}
+}
+
+func newActionRunner(ctx context.Context, policy actions.Policy) *actionRunner {
+	return &actionRunner{
+		ctx:     ctx,
+		policy:  policy,
+		running: make(map[string]bool),
+		done:    make(map[string]*pb.ActionResult),
+		ready:   make(chan struct{}, 1),
+	}
+}
+
+// handle starts req unless it is already running or done; for a finished
+// request the result is queued again.
+func (r *actionRunner) handle(req *pb.ActionRequest) {
+	id := req.GetId()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.running[id] {
+		return
+	}
+	if res, ok := r.done[id]; ok {
+		r.enqueue(res)
+		return
+	}
+	r.running[id] = true
+	log.Printf("Action %s requested (%s)", req.GetAction(), id)
+	go func() {
+		res := actions.Run(r.ctx, req, r.policy)
+		if res.GetSuccess() {
+			log.Printf("Action %s (%s) succeeded", req.GetAction(), id)
+		} else {
+			logging.Warnf("Action %s (%s) failed: %s", req.GetAction(), id, res.GetError())
+		}
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		delete(r.running, id)
+		r.done[id] = res
+		r.doneIDs = append(r.doneIDs, id)
+		if len(r.doneIDs) > keptResults {
+			delete(r.done, r.doneIDs[0])
+			r.doneIDs = r.doneIDs[1:]
+		}
+		r.enqueue(res)
+	}()
+}
+
+// enqueue must be called with mu held.
+func (r *actionRunner) enqueue(res *pb.ActionResult) {
+	r.queue = append(r.queue, res)
+	select {
+	case r.ready <- struct{}{}:
+	default:
+	}
+}
+
+// deliver sends queued results on stream until ctx ends or a send fails;
+// unsent results stay queued for the next stream.
+func (r *actionRunner) deliver(ctx context.Context, stream pb.AgentService_ConnectClient, machineID string) {
+	for {
+		r.mu.Lock()
+		pending := r.queue
+		r.queue = nil
+		r.mu.Unlock()
+		for i, res := range pending {
+			err := stream.Send(&pb.AgentMessage{
+				MachineId: machineID,
+				Payload:   &pb.AgentMessage_ActionResult{ActionResult: res},
+			})
+			if err != nil {
+				r.mu.Lock()
+				r.queue = append(pending[i:], r.queue...)
+				r.mu.Unlock()
+				return
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.ready:
+		}
+	}
+}
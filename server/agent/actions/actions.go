@@ -0,0 +1,262 @@
+// Package actions carries out the typed actions the server requests: starting,
+// stopping and restarting systemd units, rebooting and running the scripts
+// an administrator put in the scripts directory. Each action must be
+// enabled in the agent's policy; nothing is enabled by default.
+package actions
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"time"
+
+	pb "github.com/lute/agent/proto/agent"
+)
+
+// Action names, as used in the policy, on the stream and in the API.
+const (
+	ServiceStart   = "service-start"
+	ServiceStop    = "service-stop"
+	ServiceRestart = "service-restart"
+	Reboot         = "reboot"
+	RunScript      = "run-script"
+)
+
+// Names lists every action the agent knows.
+var Names = []string{ServiceStart, ServiceStop, ServiceRestart, Reboot, RunScript}
+
+const (
+	defaultTimeout = 5 * time.Minute
+	maxTimeout     = time.Hour
+	// minRebootDelay leaves time to report the result before going down.
+	minRebootDelay = 5 * time.Second
+	maxRebootDelay = 24 * time.Hour
+	// maxOutput caps the output kept of a command.
+	maxOutput = 64 << 10
+)
+
+var (
+	// unitName matches systemd unit names, e.g. "nginx.service" or
+	// "postgresql@16-main.service".
+	unitName = regexp.MustCompile(`^[A-Za-z0-9:_.@-]+\.(service|socket|target|timer|mount|path)$`)
+	// scriptName matches the file names accepted for run-script; they
+	// cannot leave the scripts directory.
+	scriptName = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*$`)
+)
+
+// The polkit files "lute-agent service install" writes so the service user
+// may manage units and reboot without root: a rule for polkit 0.106 and
+// later, a local authority file for older versions.
+const (
+	PolkitRulePath = "/etc/polkit-1/rules.d/50-lute-agent.rules"
+	PolkitPKLAPath = "/etc/polkit-1/localauthority/50-local.d/50-lute-agent.pkla"
+)
+
+// Usable splits enabled into the actions this process can carry out and
+// those it cannot. Without root, starting, stopping and restarting units
+// and rebooting need systemd and one of the polkit files that grant them.
+func Usable(enabled []string) (usable, denied []string) {
+	if runtime.GOOS == "windows" || os.Geteuid() == 0 || polkitGranted() {
+		return enabled, nil
+	}
+	for _, action := range enabled {
+		if action == RunScript {
+			usable = append(usable, action)
+		} else {
+			denied = append(denied, action)
+		}
+	}
+	return usable, denied
+}
+
+func polkitGranted() bool {
+	if runtime.GOOS != "linux" {
+		return false
+	}
+	if _, err := os.Stat("/run/systemd/system"); err != nil {
+		return false
+	}
+	for _, path := range []string{PolkitRulePath, PolkitPKLAPath} {
+		if _, err := os.Stat(path); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Policy decides which actions the agent carries out.
+type Policy struct {
+	Enabled    []string
+	ScriptsDir string
+}
+
+// Allows reports whether the policy enables action.
+func (p Policy) Allows(action string) bool {
+	return slices.Contains(p.Enabled, action)
+}
+
+// Run carries out req and describes the outcome. A reboot is only scheduled;
+// it happens after delay_seconds (at least a few seconds, so the result can
+// still be reported).
+func Run(ctx context.Context, req *pb.ActionRequest, p Policy) *pb.ActionResult {
+	res := &pb.ActionResult{Id: req.GetId(), StartedAt: time.Now().Unix()}
+	defer func() { res.FinishedAt = time.Now().Unix() }()
+
+	if !p.Allows(req.GetAction()) {
+		res.Error = fmt.Sprintf("action %q is not enabled by the agent's policy", req.GetAction())
+		return res
+	}
+	timeout := defaultTimeout
+	if t := req.GetTimeoutSeconds(); t > 0 {
+		timeout = min(time.Duration(t)*time.Second, maxTimeout)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	params := req.GetParams()
+	var err error
+	switch req.GetAction() {
+	case ServiceStart, ServiceStop, ServiceRestart:
+		verb := req.GetAction()[len("service-"):]
+		unit := params["unit"]
+		if !unitName.MatchString(unit) {
+			err = fmt.Errorf("invalid unit name %q", unit)
+			break
+		}
+		err = run(ctx, res, "", "systemctl", verb, "--", unit)
+	case Reboot:
+		err = scheduleReboot(res, params["delay_seconds"])
+	case RunScript:
+		var path string
+		if path, err = script(p.ScriptsDir, params["script"]); err == nil {
+			err = run(ctx, res, p.ScriptsDir, path, req.GetArgs()...)
+		}
+	default:
+		err = fmt.Errorf("unknown action %q", req.GetAction())
+	}
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	res.Success = true
+	return res
+}
+
+// run executes name with args and records its exit code and output in res.
+func run(ctx context.Context, res *pb.ActionResult, dir, name string, args ...string) error {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "LUTE_ACTION_ID="+res.GetId())
+	// A child that keeps the output open must not hold up the result.
+	cmd.WaitDelay = 5 * time.Second
+	out := &limitedBuffer{max: maxOutput}
+	cmd.Stdout, cmd.Stderr = out, out
+	err := cmd.Run()
+	res.Output = out.String()
+	var exitErr *exec.ExitError
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		res.ExitCode = -1
+		return errors.New("timed out")
+	case errors.As(err, &exitErr):
+		res.ExitCode = int32(exitErr.ExitCode())
+		return fmt.Errorf("exited with status %d", exitErr.ExitCode())
+	case err != nil:
+		res.ExitCode = -1
+		return err
+	}
+	return nil
+}
+
+// script returns the path of the named script in dir, which must be an
+// executable regular file.
+func script(dir, name string) (string, error) {
+	if dir == "" {
+		return "", errors.New("no scripts directory is configured")
+	}
+	if !scriptName.MatchString(name) {
+		return "", fmt.Errorf("invalid script name %q", name)
+	}
+	path := filepath.Join(dir, name)
+	info, err := os.Lstat(path)
+	if err != nil {
+		return "", fmt.Errorf("script %q: %w", name, err)
+	}
+	if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("script %q is not a regular file", name)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0o111 == 0 {
+		return "", fmt.Errorf("script %q is not executable", name)
+	}
+	return path, nil
+}
+
+// scheduleReboot reboots the host after the delay given in seconds.
+func scheduleReboot(res *pb.ActionResult, delaySeconds string) error {
+	delay := minRebootDelay
+	if delaySeconds != "" {
+		n, err := strconv.Atoi(delaySeconds)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid delay_seconds %q", delaySeconds)
+		}
+		delay = min(max(time.Duration(n)*time.Second, minRebootDelay), maxRebootDelay)
+	}
+	name, args := rebootCommand()
+	if _, err := exec.LookPath(name); err != nil {
+		return err
+	}
+	time.AfterFunc(delay, func() {
+		if out, err := exec.Command(name, args...).CombinedOutput(); err != nil {
+			log.Printf("Reboot failed: %v: %s", err, out)
+		}
+	})
+	res.Output = fmt.Sprintf("rebooting in %s", delay)
+	return nil
+}
+
+func rebootCommand() (string, []string) {
+	switch runtime.GOOS {
+	case "windows":
+		return "shutdown", []string{"/r", "/t", "0"}
+	case "linux":
+		if _, err := os.Stat("/run/systemd/system"); err == nil {
+			return "systemctl", []string{"reboot"}
+		}
+		return "reboot", nil
+	default:
+		return "shutdown", []string{"-r", "now"}
+	}
+}
+
+// limitedBuffer keeps the first max bytes written to it.
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.buf.Len(); room < len(p) {
+		b.truncated = true
+		b.buf.Write(p[:max(room, 0)])
+	} else {
+		b.buf.Write(p)
+	}
+	return len(p), nil
+}
+
+func (b *limitedBuffer) String() string {
+	if b.truncated {
+		return b.buf.String() + "\n[output truncated]"
+	}
+	return b.buf.String()
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/lute/agent/config"
+	"github.com/lute/agent/logging"
+	"github.com/lute/agent/metrics"
+	"github.com/lute/agent/state"
+	"github.com/lute/agent/wal"
+
+	pb "github.com/lute/agent/proto/agent"
+)
+
+// backfillBatchSize is the number of buffered samples sent per Backfill.
+const backfillBatchSize = 500
+
+// telemetryBuffer samples metrics into an on-disk log while the agent is not
+// connected and replays them to the server after it reconnects. A nil
+// buffer (buffering disabled) does nothing.
+type telemetryBuffer struct {
+	log        *wal.WAL
+	collectors atomic.Pointer[[]string]
+	interval   *tickInterval
+	connected  atomic.Bool
+}
+
+// openBuffer opens the buffer in the state directory. Buffering is turned
+// off when it is disabled or the directory cannot be used.
+func openBuffer(cfg *config.Config) *telemetryBuffer {
+	if cfg.Buffer.Disabled {
+		return nil
+	}
+	dir := state.BufferDir(cfg.StateDir)
+	l, err := wal.Open(dir, cfg.Buffer.MaxSizeMB<<20)
+	if err != nil {
+		log.Printf("Offline buffering disabled: %v", err)
+		return nil
+	}
+	if n := l.Pending(); n > 0 {
+		log.Printf("%d buffered samples waiting to be sent", n)
+	}
+	b := &telemetryBuffer{log: l, interval: newTickInterval(cfg.Intervals.OfflineSample)}
+	b.collectors.Store(&cfg.Collectors)
+	return b
+}
+
+// run samples metrics every interval while the agent is disconnected.
+func (b *telemetryBuffer) run(ctx context.Context) {
+	if b == nil {
+		return
+	}
+	defer b.log.Close()
+	ticker := time.NewTicker(b.interval.get())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-b.interval.changed:
+			ticker.Reset(b.interval.get())
+		case <-ticker.C:
+			if b.connected.Load() {
+				continue
+			}
+			sample := &pb.Sample{
+				Timestamp: time.Now().Unix(),
+				Status:    "running",
+				Metrics:   metricsToProto(metrics.Collect(*b.collectors.Load())),
+			}
+			data, err := proto.Marshal(sample)
+			if err == nil {
+				_, err = b.log.Append(data)
+			}
+			if err != nil {
+				logging.Warnf("Failed to buffer metrics sample: %v", err)
+			}
+		}
+	}
+}
+
+// setCollectors changes the metrics sampled.
+func (b *telemetryBuffer) setCollectors(collectors []string) {
+	if b != nil {
+		b.collectors.Store(&collectors)
+	}
+}
+
+// setInterval changes how often metrics are sampled.
+func (b *telemetryBuffer) setInterval(d time.Duration) {
+	if b != nil {
+		b.interval.set(d)
+	}
+}
+
+// setConnected stops (true) or resumes (false) sampling.
+func (b *telemetryBuffer) setConnected(connected bool) {
+	if b != nil {
+		b.connected.Store(connected)
+	}
+}
+
+// nextBatch returns a Backfill with the oldest buffered samples, or nil when
+// there are none.
+func (b *telemetryBuffer) nextBatch(machineID string) *pb.AgentMessage {
+	if b == nil {
+		return nil
+	}
+	records, err := b.log.Read(backfillBatchSize)
+	if err != nil {
+		logging.Warnf("Failed to read buffered samples: %v", err)
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	samples := make([]*pb.Sample, 0, len(records))
+	for _, rec := range records {
+		sample := &pb.Sample{}
+		if err := proto.Unmarshal(rec.Data, sample); err != nil {
+			log.Printf("Skipping unreadable buffered sample %d: %v", rec.Seq, err)
+			sample = &pb.Sample{} // still sent so the ack covers it
+		}
+		sample.Seq = rec.Seq
+		samples = append(samples, sample)
+	}
+	return &pb.AgentMessage{
+		MachineId: machineID,
+		Payload:   &pb.AgentMessage_Backfill{Backfill: &pb.Backfill{Samples: samples}},
+	}
+}
+
+// ack drops the samples the server has stored.
+func (b *telemetryBuffer) ack(lastSeq uint64) {
+	if b == nil {
+		return
+	}
+	if err := b.log.Commit(lastSeq); err != nil {
+		logging.Warnf("Failed to commit buffered samples: %v", err)
+	}
+}
@@ -0,0 +1,178 @@
+// Package certs manages the agent's credentials: its private key, the client
+// certificate the API issued for its machine ID, the CA that signed the gRPC
+// server certificate, the server name to verify, and the agent token.
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	keyFile        = "agent.key"
+	certFile       = "agent.crt"
+	caFile         = "ca.crt"
+	serverNameFile = "server-name"
+	tokenFile      = "agent-token"
+)
+
+// ErrNotEnrolled is returned when no certificate has been stored for a machine.
+var ErrNotEnrolled = errors.New("no client certificate for this machine; run the agent with --claim-code to register")
+
+// Store holds the TLS files of one machine in its own directory, so several
+// agents on a host do not overwrite each other.
+type Store struct {
+	Dir string
+}
+
+// ForMachine returns the store for machineID under baseDir.
+func ForMachine(baseDir, machineID string) *Store {
+	return &Store{Dir: filepath.Join(baseDir, machineID)}
+}
+
+// Exists reports whether a key, certificate and agent token have been stored.
+func (s *Store) Exists() bool {
+	for _, name := range []string{keyFile, certFile, caFile, tokenFile} {
+		if _, err := os.Stat(filepath.Join(s.Dir, name)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// NewKey generates an ECDSA P-256 key and a CSR for it. The server sets the
+// certificate subject, so the CSR only carries the hostname for reference.
+func NewKey(hostname string) (*ecdsa.PrivateKey, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: hostname},
+	}, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}), nil
+}
+
+// Save writes the key, certificate and CA certificate. serverName is kept
+// from the previous save when empty (e.g. on renewal).
+func (s *Store) Save(key *ecdsa.PrivateKey, certPEM, caPEM []byte, serverName string) error {
+	if err := os.MkdirAll(s.Dir, 0o700); err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if _, err := tls.X509KeyPair(certPEM, keyPEM); err != nil {
+		return fmt.Errorf("certificate does not match key: %w", err)
+	}
+
+	files := []struct {
+		name string
+		data []byte
+		mode os.FileMode
+	}{
+		{keyFile, keyPEM, 0o600},
+		{certFile, certPEM, 0o644},
+		{caFile, caPEM, 0o644},
+	}
+	if serverName != "" {
+		files = append(files, struct {
+			name string
+			data []byte
+			mode os.FileMode
+		}{serverNameFile, []byte(serverName + "\n"), 0o644})
+	}
+	// Write everything to temp files first so a failure leaves the old set intact
+	for _, f := range files {
+		if err := os.WriteFile(filepath.Join(s.Dir, f.name+".tmp"), f.data, f.mode); err != nil {
+			return err
+		}
+	}
+	for _, f := range files {
+		if err := os.Rename(filepath.Join(s.Dir, f.name+".tmp"), filepath.Join(s.Dir, f.name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SaveToken stores the agent token the server expects on every Connect.
+func (s *Store) SaveToken(token string) error {
+	if err := os.MkdirAll(s.Dir, 0o700); err != nil {
+		return err
+	}
+	tmp := filepath.Join(s.Dir, tokenFile+".tmp")
+	if err := os.WriteFile(tmp, []byte(token+"\n"), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(s.Dir, tokenFile))
+}
+
+// Token returns the stored agent token.
+func (s *Store) Token() (string, error) {
+	b, err := os.ReadFile(filepath.Join(s.Dir, tokenFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", ErrNotEnrolled
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// TLSConfig loads the stored files and returns the client TLS config along
+// with the parsed client certificate.
+func (s *Store) TLSConfig() (*tls.Config, *x509.Certificate, error) {
+	if !s.Exists() {
+		return nil, nil, ErrNotEnrolled
+	}
+	pair, err := tls.LoadX509KeyPair(filepath.Join(s.Dir, certFile), filepath.Join(s.Dir, keyFile))
+	if err != nil {
+		return nil, nil, fmt.Errorf("load client certificate: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse client certificate: %w", err)
+	}
+	caPEM, err := os.ReadFile(filepath.Join(s.Dir, caFile))
+	if err != nil {
+		return nil, nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, nil, fmt.Errorf("no CA certificate found in %s", filepath.Join(s.Dir, caFile))
+	}
+	serverName := "lute-grpc"
+	if b, err := os.ReadFile(filepath.Join(s.Dir, serverNameFile)); err == nil && strings.TrimSpace(string(b)) != "" {
+		serverName = strings.TrimSpace(string(b))
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{pair},
+		RootCAs:      pool,
+		ServerName:   serverName,
+		MinVersion:   tls.VersionTLS12,
+	}, leaf, nil
+}
+
+// RenewAt returns when the agent should reconnect so the server renews its
+// certificate: after two thirds of the certificate's lifetime.
+func RenewAt(cert *x509.Certificate) time.Time {
+	lifetime := cert.NotAfter.Sub(cert.NotBefore)
+	return cert.NotBefore.Add(lifetime * 2 / 3)
+}
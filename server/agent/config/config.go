@@ -0,0 +1,360 @@
+// Package config loads the agent configuration. Each setting is taken from,
+// in order of precedence, a command-line flag, a LUTE_* environment variable,
+// the YAML config file, and finally a built-in default.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/lute/agent/actions"
+	"github.com/lute/agent/logging"
+	"github.com/lute/agent/metrics"
+	"github.com/lute/agent/state"
+	"github.com/lute/agent/utils"
+)
+
+// Config is the agent configuration. A sample file is in config.example.yaml.
+type Config struct {
+	// Server is the gRPC address. When empty, the address returned at
+	// registration (kept in the state directory) is used.
+	Server   string `yaml:"server"`
+	API      string `yaml:"api"`
+	StateDir string `yaml:"state_dir"`
+	// CertDir overrides where credentials are kept (default <state_dir>/certs).
+	CertDir         string            `yaml:"cert_dir"`
+	Labels          map[string]string `yaml:"labels"`
+	EnrollmentToken string            `yaml:"enrollment_token"`
+	// Collectors selects the metrics sent with each heartbeat
+	// ("cpu", "memory", "disk"); empty means all.
+	Collectors []string  `yaml:"collectors"`
+	Intervals  Intervals `yaml:"intervals"`
+	// LogLevel is "debug", "info" (default), "warn" or "error".
+	LogLevel string `yaml:"log_level"`
+	// Buffer keeps metric samples on disk while the server is unreachable.
+	Buffer Buffer `yaml:"buffer"`
+	// DisableUpdates makes the agent ignore version updates from the server.
+	DisableUpdates bool `yaml:"disable_updates"`
+	// UpdatePublicKey is the ed25519 key (base64 or PEM) updates must be
+	// signed with. It overrides the key built into the binary.
+	UpdatePublicKey string `yaml:"update_public_key"`
+	// DisableInventory stops the agent from reporting installed packages and
+	// hardware.
+	DisableInventory bool `yaml:"disable_inventory"`
+	// FileIntegrity reports changes to watched files.
+	FileIntegrity FileIntegrity `yaml:"file_integrity"`
+	// Units reports the state of systemd units.
+	Units Units `yaml:"units"`
+	// Actions decides which typed actions the server may request.
+	Actions Actions `yaml:"actions"`
+	// Files decides which files the server may copy to and from the machine.
+	Files Files `yaml:"files"`
+
+	// Path is the file the config was loaded from ("" when none was found).
+	Path string `yaml:"-"`
+}
+
+// Intervals are the agent's timing settings, written as Go durations ("30s").
+type Intervals struct {
+	ReconnectMin time.Duration `yaml:"reconnect_min"` // first delay after a dropped stream
+	ReconnectMax time.Duration `yaml:"reconnect_max"` // cap of the exponential backoff
+	// OfflineSample is how often metrics are buffered while disconnected.
+	OfflineSample time.Duration `yaml:"offline_sample"`
+	// Inventory is how often the inventory is re-collected; it is only sent
+	// when it changed.
+	Inventory time.Duration `yaml:"inventory"`
+	// Units is how often systemd units are checked; their state is only sent
+	// when it changed.
+	Units time.Duration `yaml:"units"`
+}
+
+// Buffer configures the on-disk telemetry buffer. Samples taken while the
+// server is unreachable are sent with their original timestamps after the
+// agent reconnects; the oldest are dropped once MaxSizeMB is reached.
+type Buffer struct {
+	Disabled  bool  `yaml:"disabled"`
+	MaxSizeMB int64 `yaml:"max_size_mb"`
+}
+
+// Units selects the systemd units whose state is reported: those matching
+// Watch (unit names or globs such as "postgresql@*.service") and every
+// failed unit.
+type Units struct {
+	Disabled bool     `yaml:"disabled"`
+	Watch    []string `yaml:"watch"`
+}
+
+// Actions is the policy for the typed actions the server may request
+// (service-start, service-stop, service-restart, reboot, run-script). Only
+// the Enabled ones are carried out; none are by default.
+type Actions struct {
+	Enabled []string `yaml:"enabled"`
+	// ScriptsDir holds the scripts run-script may run, by file name
+	// (default: scripts next to the default config file).
+	ScriptsDir string `yaml:"scripts_dir"`
+}
+
+// Files is the policy for copying files between the server and the
+// machine. Read and Write list directories (everything below them) or glob
+// patterns; with none, no files can be copied in that direction.
+type Files struct {
+	Read      []string `yaml:"read"`
+	Write     []string `yaml:"write"`
+	MaxSizeMB int64    `yaml:"max_size_mb"`
+}
+
+// FileIntegrity configures file integrity monitoring: the agent keeps a
+// baseline of the SHA-256, permissions and owner of every file under Paths
+// and reports files added, removed or modified, found by a full scan every
+// ScanInterval and, on Linux, as they happen through inotify.
+type FileIntegrity struct {
+	Paths []string `yaml:"paths"` // files and directories to watch; none turns monitoring off
+	// Exclude holds shell glob patterns matched against the
+	// full path and the base name of each file, e.g. "/etc/mtab" or "*.swp".
+	Exclude      []string      `yaml:"exclude"`
+	ScanInterval time.Duration `yaml:"scan_interval"`
+	// MaxFileSizeMB caps the files that are hashed; larger ones are compared
+	// by size and modification time.
+	MaxFileSizeMB int64 `yaml:"max_file_size_mb"`
+}
+
+const (
+	DefaultAPI          = "http://localhost:8080"
+	DefaultServer       = "localhost:50051"
+	defaultReconnectMin = time.Second
+	defaultReconnectMax = 30 * time.Second
+)
+
+const (
+	defaultLogLevel      = "info"
+	defaultOfflineSample = 30 * time.Second
+	defaultBufferSizeMB  = 16
+	defaultInventory     = 15 * time.Minute
+	defaultUnits         = 30 * time.Second
+	defaultFilesMaxMB    = 100
+	defaultFIMScan       = time.Hour
+	defaultFIMMaxFileMB  = 64
+)
+
+// DefaultPath returns the config file used when --config and LUTE_CONFIG are
+// not set: /etc/lute-agent/config.yaml for root on Unix, otherwise
+// config.yaml in the user's config directory.
+func DefaultPath() string {
+	if runtime.GOOS != "windows" && os.Geteuid() == 0 {
+		return "/etc/lute-agent/config.yaml"
+	}
+	if dir, err := os.UserConfigDir(); err == nil {
+		return filepath.Join(dir, "lute-agent", "config.yaml")
+	}
+	return "lute-agent.yaml"
+}
+
+// Load reads the config file at path. A missing file is only an error when
+// the path was given explicitly.
+func Load(path string, explicit bool) (*Config, error) {
+	cfg := &Config{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && !explicit {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("parse config %s: %w", path, err)
+	}
+	cfg.Path = path
+	return cfg, nil
+}
+
+// ApplyEnv overrides file settings with LUTE_* environment variables.
+func (c *Config) ApplyEnv() error {
+	if v, ok := os.LookupEnv("LUTE_SERVER"); ok {
+		c.Server = v
+	}
+	if v, ok := os.LookupEnv("LUTE_API_URL"); ok {
+		c.API = v
+	}
+	if v, ok := os.LookupEnv("LUTE_STATE_DIR"); ok {
+		c.StateDir = v
+	}
+	if v, ok := os.LookupEnv("LUTE_CERT_DIR"); ok {
+		c.CertDir = v
+	}
+	if v, ok := os.LookupEnv("LUTE_LABELS"); ok {
+		labels, err := utils.ParseLabels(v)
+		if err != nil {
+			return fmt.Errorf("LUTE_LABELS: %w", err)
+		}
+		c.Labels = labels
+	}
+	if v, ok := os.LookupEnv("LUTE_ENROLLMENT_TOKEN"); ok {
+		c.EnrollmentToken = v
+	}
+	if v, ok := os.LookupEnv("LUTE_COLLECTORS"); ok {
+		c.Collectors = splitList(v)
+	}
+	if v, ok := os.LookupEnv("LUTE_LOG_LEVEL"); ok {
+		c.LogLevel = v
+	}
+	if v, ok := os.LookupEnv("LUTE_DISABLE_UPDATES"); ok {
+		disable, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("LUTE_DISABLE_UPDATES: %w", err)
+		}
+		c.DisableUpdates = disable
+	}
+	if v, ok := os.LookupEnv("LUTE_UPDATE_PUBLIC_KEY"); ok {
+		c.UpdatePublicKey = v
+	}
+	if v, ok := os.LookupEnv("LUTE_DISABLE_INVENTORY"); ok {
+		disable, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("LUTE_DISABLE_INVENTORY: %w", err)
+		}
+		c.DisableInventory = disable
+	}
+	if v, ok := os.LookupEnv("LUTE_UNITS"); ok {
+		c.Units.Watch = splitList(v)
+	}
+	if v, ok := os.LookupEnv("LUTE_DISABLE_UNITS"); ok {
+		disable, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("LUTE_DISABLE_UNITS: %w", err)
+		}
+		c.Units.Disabled = disable
+	}
+	if v, ok := os.LookupEnv("LUTE_ACTIONS"); ok {
+		c.Actions.Enabled = splitList(v)
+	}
+	if v, ok := os.LookupEnv("LUTE_SCRIPTS_DIR"); ok {
+		c.Actions.ScriptsDir = v
+	}
+	if v, ok := os.LookupEnv("LUTE_FILES_READ"); ok {
+		c.Files.Read = splitList(v)
+	}
+	if v, ok := os.LookupEnv("LUTE_FILES_WRITE"); ok {
+		c.Files.Write = splitList(v)
+	}
+	if v, ok := os.LookupEnv("LUTE_FIM_PATHS"); ok {
+		c.FileIntegrity.Paths = splitList(v)
+	}
+	if v, ok := os.LookupEnv("LUTE_FIM_EXCLUDE"); ok {
+		c.FileIntegrity.Exclude = splitList(v)
+	}
+	if v, ok := os.LookupEnv("LUTE_DISABLE_BUFFER"); ok {
+		disable, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("LUTE_DISABLE_BUFFER: %w", err)
+		}
+		c.Buffer.Disabled = disable
+	}
+	if v, ok := os.LookupEnv("LUTE_BUFFER_MAX_SIZE_MB"); ok {
+		size, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("LUTE_BUFFER_MAX_SIZE_MB: %w", err)
+		}
+		c.Buffer.MaxSizeMB = size
+	}
+	return nil
+}
+
+// Finalize fills in defaults and validates the result.
+func (c *Config) Finalize() error {
+	if c.API == "" {
+		c.API = DefaultAPI
+	}
+	if c.StateDir == "" {
+		c.StateDir = state.DefaultDir()
+	}
+	if c.CertDir == "" {
+		c.CertDir = state.CertDir(c.StateDir)
+	}
+	if c.Intervals.ReconnectMin <= 0 {
+		c.Intervals.ReconnectMin = defaultReconnectMin
+	}
+	if c.Intervals.ReconnectMax <= 0 {
+		c.Intervals.ReconnectMax = defaultReconnectMax
+	}
+	if c.Intervals.OfflineSample <= 0 {
+		c.Intervals.OfflineSample = defaultOfflineSample
+	}
+	if c.Intervals.Inventory <= 0 {
+		c.Intervals.Inventory = defaultInventory
+	}
+	if c.Intervals.Units <= 0 {
+		c.Intervals.Units = defaultUnits
+	}
+	if c.LogLevel == "" {
+		c.LogLevel = defaultLogLevel
+	}
+	if c.Buffer.MaxSizeMB <= 0 {
+		c.Buffer.MaxSizeMB = defaultBufferSizeMB
+	}
+	if c.Actions.ScriptsDir == "" {
+		c.Actions.ScriptsDir = filepath.Join(filepath.Dir(DefaultPath()), "scripts")
+	}
+	if c.Files.MaxSizeMB <= 0 {
+		c.Files.MaxSizeMB = defaultFilesMaxMB
+	}
+	if c.FileIntegrity.ScanInterval <= 0 {
+		c.FileIntegrity.ScanInterval = defaultFIMScan
+	}
+	if c.FileIntegrity.MaxFileSizeMB <= 0 {
+		c.FileIntegrity.MaxFileSizeMB = defaultFIMMaxFileMB
+	}
+	for _, p := range c.FileIntegrity.Paths {
+		if !filepath.IsAbs(p) {
+			return fmt.Errorf("file_integrity.paths: %q is not an absolute path", p)
+		}
+	}
+	for _, pattern := range c.FileIntegrity.Exclude {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("file_integrity.exclude: %q: %w", pattern, err)
+		}
+	}
+	if c.Intervals.ReconnectMax < c.Intervals.ReconnectMin {
+		return fmt.Errorf("intervals.reconnect_max (%s) is shorter than reconnect_min (%s)", c.Intervals.ReconnectMax, c.Intervals.ReconnectMin)
+	}
+	for _, p := range append(slices.Clone(c.Files.Read), c.Files.Write...) {
+		if !filepath.IsAbs(p) {
+			return fmt.Errorf("files: %q is not an absolute path", p)
+		}
+		if _, err := filepath.Match(p, ""); err != nil {
+			return fmt.Errorf("files: %q: %w", p, err)
+		}
+	}
+	for _, name := range c.Actions.Enabled {
+		if !slices.Contains(actions.Names, name) {
+			return fmt.Errorf("actions.enabled: unknown action %q (valid: %s)", name, strings.Join(actions.Names, ", "))
+		}
+	}
+	if _, err := logging.ParseLevel(c.LogLevel); err != nil {
+		return fmt.Errorf("log_level: %w", err)
+	}
+	for _, name := range c.Collectors {
+		if !metrics.ValidCollector(name) {
+			return fmt.Errorf("unknown collector %q (valid: %s)", name, strings.Join(metrics.Collectors, ", "))
+		}
+	}
+	return nil
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/lute/agent/config"
+	"github.com/lute/agent/fim"
+	"github.com/lute/agent/logging"
+	"github.com/lute/agent/state"
+	"github.com/lute/agent/wal"
+
+	pb "github.com/lute/agent/proto/agent"
+)
+
+const (
+	// fileEventBatchSize is the number of file events sent per FileEvents.
+	fileEventBatchSize = 200
+	// fileEventQueueSize bounds the events kept until the server stores
+	// them; the oldest are dropped beyond it.
+	fileEventQueueSize = 16 << 20
+)
+
+// fileIntegrity runs the file integrity monitor and queues its events on
+// disk until the server acknowledges them, so changes made while the agent
+// is disconnected are not lost. A nil fileIntegrity (no watch paths) does
+// nothing.
+type fileIntegrity struct {
+	monitor *fim.Monitor
+	queue   *wal.WAL
+}
+
+// openFileIntegrity sets up monitoring of the configured paths, or returns
+// nil when there are none or the state directory cannot be used.
+func openFileIntegrity(cfg *config.Config) *fileIntegrity {
+	fic := cfg.FileIntegrity
+	if len(fic.Paths) == 0 {
+		return nil
+	}
+	dir := state.FIMDir(cfg.StateDir)
+	queue, err := wal.Open(filepath.Join(dir, "events"), fileEventQueueSize)
+	if err != nil {
+		log.Printf("File integrity monitoring disabled: %v", err)
+		return nil
+	}
+	if n := queue.Pending(); n > 0 {
+		log.Printf("%d file events waiting to be sent", n)
+	}
+	f := &fileIntegrity{queue: queue}
+	f.monitor = fim.New(fim.Config{
+		Paths:        fic.Paths,
+		Exclude:      fic.Exclude,
+		ScanInterval: fic.ScanInterval,
+		MaxFileSize:  fic.MaxFileSizeMB << 20,
+	}, filepath.Join(dir, "baseline.json"), f.enqueue)
+	return f
+}
+
+func (f *fileIntegrity) run(ctx context.Context) {
+	if f == nil {
+		return
+	}
+	defer f.queue.Close()
+	f.monitor.Run(ctx)
+}
+
+// enqueue stores an event until the server has it.
+func (f *fileIntegrity) enqueue(ev *pb.FileEvent) {
+	log.Printf("File integrity: %s %s", strings.ToLower(strings.TrimPrefix(ev.GetChange().String(), "FILE_CHANGE_")), ev.GetPath())
+	data, err := proto.Marshal(ev)
+	if err == nil {
+		_, err = f.queue.Append(data)
+	}
+	if err != nil {
+		logging.Warnf("Failed to queue file event for %s: %v", ev.GetPath(), err)
+	}
+}
+
+// nextBatch returns a FileEvents with the oldest queued events, or nil when
+// there are none.
+func (f *fileIntegrity) nextBatch(machineID string) *pb.AgentMessage {
+	if f == nil {
+		return nil
+	}
+	records, err := f.queue.Read(fileEventBatchSize)
+	if err != nil {
+		log.Printf("Failed to read queued file events: %v", err)
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	events := make([]*pb.FileEvent, 0, len(records))
+	for _, rec := range records {
+		ev := &pb.FileEvent{}
+		if err := proto.Unmarshal(rec.Data, ev); err != nil {
+			log.Printf("Skipping unreadable file event %d: %v", rec.Seq, err)
+			ev = &pb.FileEvent{} // still sent so the ack covers it
+		}
+		ev.Seq = rec.Seq
+		events = append(events, ev)
+	}
+	return &pb.AgentMessage{
+		MachineId: machineID,
+		Payload:   &pb.AgentMessage_FileEvents{FileEvents: &pb.FileEvents{Events: events}},
+	}
+}
+
+// ack drops the events the server has stored.
+func (f *fileIntegrity) ack(lastSeq uint64) {
+	if f == nil {
+		return
+	}
+	if err := f.queue.Commit(lastSeq); err != nil {
+		log.Printf("Failed to commit file events: %v", err)
+	}
+}
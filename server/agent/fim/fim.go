@@ -0,0 +1,481 @@
+// Package fim monitors files for unexpected changes (file integrity
+// monitoring).
+//
+// A Monitor keeps a baseline of every file under its root paths: SHA-256 of
+// the content, permission bits, owner and group, and the target of symbolic
+// links. Full scans compare the file system with the baseline; on Linux,
+// inotify reports changes between scans. Every difference is passed to the
+// emit function as a FileEvent and the baseline is updated, so each change
+// is reported once.
+//
+// The baseline is kept in a file so changes made while the agent was not
+// running are reported by the first scan after it starts. Files under a
+// root added to the configuration are baselined without events.
+package fim
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	pb "github.com/lute/agent/proto/agent"
+)
+
+// settleDelay is how long the watcher waits for a burst of changes (e.g. a
+// package upgrade) to end before comparing the files.
+const settleDelay = 2 * time.Second
+
+// Config selects the files to monitor.
+type Config struct {
+	Paths        []string      // files and directories to watch
+	Exclude      []string      // glob patterns of full paths or base names to skip
+	ScanInterval time.Duration // time between full scans
+	MaxFileSize  int64         // larger files are not hashed
+}
+
+// Entry is what the baseline records about one file.
+type Entry struct {
+	SHA256 string `json:"sha256,omitempty"`
+	Mode   uint32 `json:"mode"`
+	UID    uint32 `json:"uid"`
+	GID    uint32 `json:"gid"`
+	Size   int64  `json:"size"`
+	MTime  int64  `json:"mtime"`
+	Link   string `json:"link,omitempty"`
+	// CTime (status change, in nanoseconds) and Inode let scans skip hashing
+	// files that cannot have changed; neither can be set from user space.
+	CTime int64  `json:"ctime,omitempty"`
+	Inode uint64 `json:"inode,omitempty"`
+}
+
+func (e *Entry) isDir() bool { return fs.FileMode(e.Mode).IsDir() }
+
+// differs reports whether o is a reported change from e. Directories only
+// change by permissions and owner; files too large to hash by size and
+// modification time.
+func (e *Entry) differs(o *Entry) bool {
+	if e.Mode != o.Mode || e.UID != o.UID || e.GID != o.GID || e.Link != o.Link {
+		return true
+	}
+	if e.isDir() {
+		return false
+	}
+	if e.SHA256 == "" || o.SHA256 == "" {
+		return e.SHA256 != o.SHA256 || e.Size != o.Size || e.MTime != o.MTime
+	}
+	return e.SHA256 != o.SHA256
+}
+
+// baselineFile is the on-disk baseline.
+type baselineFile struct {
+	Roots []string          `json:"roots"`
+	Files map[string]*Entry `json:"files"`
+}
+
+// Monitor watches the files of a Config. It is not safe for concurrent use;
+// Run does all the work.
+type Monitor struct {
+	cfg          Config
+	roots        []string
+	baselinePath string
+	emit         func(*pb.FileEvent)
+	files        map[string]*Entry
+	watch        *watcher
+	owners       map[uint32]string
+	groups       map[uint32]string
+}
+
+// New creates a monitor whose baseline is kept at baselinePath. emit is
+// called, from Run's goroutine, with each change found.
+func New(cfg Config, baselinePath string, emit func(*pb.FileEvent)) *Monitor {
+	m := &Monitor{cfg: cfg, baselinePath: baselinePath, emit: emit}
+	for _, p := range cfg.Paths {
+		root := filepath.Clean(p)
+		// Watch what a symbolic link points to (/bin on merged-/usr systems).
+		if resolved, err := filepath.EvalSymlinks(root); err == nil && resolved != root {
+			log.Printf("File integrity: watching %s for %s", resolved, root)
+			root = resolved
+		}
+		if !slices.Contains(m.roots, root) {
+			m.roots = append(m.roots, root)
+		}
+	}
+	return m
+}
+
+// Run builds or loads the baseline, then reports changes until ctx is
+// cancelled.
+func (m *Monitor) Run(ctx context.Context) {
+	m.start()
+	m.watch = newWatcher()
+	defer m.watch.close()
+	for path, e := range m.files {
+		if e.isDir() {
+			m.watch.add(path)
+		}
+	}
+
+	ticker := time.NewTicker(m.cfg.ScanInterval)
+	defer ticker.Stop()
+	settle := time.NewTimer(settleDelay)
+	settle.Stop()
+	pending := make(map[string]bool)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.scan()
+		case path, ok := <-m.watch.events():
+			if !ok {
+				m.watch = nil // the watcher failed; scans continue
+				continue
+			}
+			if path == "" { // events were lost
+				m.scan()
+				clear(pending)
+				continue
+			}
+			pending[path] = true
+			settle.Reset(settleDelay)
+		case <-settle.C:
+			paths := make([]string, 0, len(pending))
+			for p := range pending {
+				paths = append(paths, p)
+			}
+			clear(pending)
+			sort.Strings(paths)
+			for _, p := range paths {
+				m.check(p)
+			}
+			m.save()
+		}
+	}
+}
+
+// start loads the baseline and reports what changed since it was saved, or
+// builds the first baseline without reporting anything.
+func (m *Monitor) start() {
+	var saved baselineFile
+	data, err := os.ReadFile(m.baselinePath)
+	if err == nil {
+		err = json.Unmarshal(data, &saved)
+	}
+	if err != nil || saved.Files == nil {
+		if !errors.Is(err, os.ErrNotExist) && err != nil {
+			log.Printf("File integrity: unreadable baseline, building a new one: %v", err)
+		}
+		m.files = m.walk(m.roots, nil)
+		m.save()
+		log.Printf("File integrity: baseline of %d files under %s", len(m.files), strings.Join(m.roots, ", "))
+		return
+	}
+
+	// Roots no longer configured are forgotten and new ones baselined
+	// silently; only the roots watched before are compared.
+	m.files = make(map[string]*Entry, len(saved.Files))
+	var added []string
+	for _, root := range m.roots {
+		if slices.Contains(saved.Roots, root) {
+			continue
+		}
+		added = append(added, root)
+	}
+	for path, e := range saved.Files {
+		if m.underRoot(path) && !underAny(path, added) {
+			m.files[path] = e
+		}
+	}
+	for path, e := range m.walk(added, nil) {
+		m.files[path] = e
+	}
+	m.scan()
+}
+
+// scan compares every file with the baseline.
+func (m *Monitor) scan() {
+	current := m.walk(m.roots, m.files)
+	paths := make([]string, 0, len(current)+len(m.files))
+	for p := range current {
+		paths = append(paths, p)
+	}
+	for p := range m.files {
+		if _, ok := current[p]; !ok {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		m.report(p, m.files[p], current[p], false)
+		if e := current[p]; e != nil && e.isDir() && m.files[p] == nil {
+			m.watch.add(p)
+		}
+	}
+	m.files = current
+	m.save()
+}
+
+// check compares one path reported by the watcher, and everything under it
+// when it is a directory that appeared or disappeared.
+func (m *Monitor) check(path string) {
+	if !m.underRoot(path) || m.excluded(path) {
+		return
+	}
+	prev := m.files[path]
+	cur, ok := m.stat(path, prev)
+	switch {
+	case !ok:
+		// Removed, with everything below it.
+		var gone []string
+		for p := range m.files {
+			if p == path || strings.HasPrefix(p, path+string(filepath.Separator)) {
+				gone = append(gone, p)
+			}
+		}
+		sort.Strings(gone)
+		for _, p := range gone {
+			m.report(p, m.files[p], nil, true)
+			delete(m.files, p)
+		}
+	case cur.isDir() && prev == nil:
+		// A new directory: watch it before listing it so nothing created
+		// meanwhile is missed.
+		m.watch.add(path)
+		found := m.walk([]string{path}, nil)
+		paths := make([]string, 0, len(found))
+		for p := range found {
+			paths = append(paths, p)
+		}
+		sort.Strings(paths)
+		for _, p := range paths {
+			if m.files[p] == nil {
+				m.report(p, nil, found[p], true)
+			}
+			if found[p].isDir() {
+				m.watch.add(p)
+			}
+			m.files[p] = found[p]
+		}
+	default:
+		m.report(path, prev, cur, true)
+		m.files[path] = cur
+	}
+}
+
+// report emits the event for a change of path from prev to cur (nil when
+// the file did not exist), if there is one.
+func (m *Monitor) report(path string, prev, cur *Entry, realtime bool) {
+	ev := &pb.FileEvent{Path: path, DetectedAt: time.Now().Unix(), Realtime: realtime}
+	switch {
+	case prev == nil && cur == nil:
+		return
+	case prev == nil:
+		ev.Change = pb.FileChange_FILE_CHANGE_ADDED
+	case cur == nil:
+		ev.Change = pb.FileChange_FILE_CHANGE_REMOVED
+	case prev.differs(cur):
+		ev.Change = pb.FileChange_FILE_CHANGE_MODIFIED
+	default:
+		return
+	}
+	ev.Before = m.state(prev)
+	ev.After = m.state(cur)
+	m.emit(ev)
+}
+
+// walk stats every file under roots. prev supplies the hashes of files that
+// have not changed since.
+func (m *Monitor) walk(roots []string, prev map[string]*Entry) map[string]*Entry {
+	out := make(map[string]*Entry)
+	for _, root := range roots {
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil // missing root or unreadable directory: skip it
+			}
+			if m.excluded(path) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if e, ok := m.stat(path, prev[path]); ok {
+				out[path] = e
+			}
+			return nil
+		})
+		if err != nil {
+			log.Printf("File integrity: scan of %s: %v", root, err)
+		}
+	}
+	return out
+}
+
+// stat returns the entry of path, reusing the hash in prev when the file
+// is unchanged. ok is false if it does not exist or is not a regular file,
+// directory or symbolic link.
+func (m *Monitor) stat(path string, prev *Entry) (*Entry, bool) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil, false
+	}
+	mode := info.Mode()
+	if !mode.IsRegular() && !mode.IsDir() && mode&fs.ModeSymlink == 0 {
+		return nil, false
+	}
+	e := &Entry{Mode: uint32(mode), Size: info.Size(), MTime: info.ModTime().Unix()}
+	e.UID, e.GID, e.CTime, e.Inode = sysStat(info)
+	switch {
+	case mode&fs.ModeSymlink != 0:
+		e.Link, _ = os.Readlink(path)
+	case mode.IsRegular() && info.Size() <= m.cfg.MaxFileSize:
+		if prev != nil && prev.SHA256 != "" && prev.CTime != 0 && prev.CTime == e.CTime &&
+			prev.Inode == e.Inode && prev.Size == e.Size && prev.MTime == e.MTime {
+			e.SHA256 = prev.SHA256
+		} else {
+			e.SHA256 = hashFile(path)
+		}
+	}
+	return e, true
+}
+
+func hashFile(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// excluded reports whether path or its base name matches an exclude pattern.
+func (m *Monitor) excluded(path string) bool {
+	base := filepath.Base(path)
+	for _, pattern := range m.cfg.Exclude {
+		if ok, _ := filepath.Match(pattern, path); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(pattern, base); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Monitor) underRoot(path string) bool {
+	return underAny(path, m.roots)
+}
+
+func underAny(path string, roots []string) bool {
+	for _, root := range roots {
+		if path == root || strings.HasPrefix(path, strings.TrimSuffix(root, string(filepath.Separator))+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// state converts a baseline entry for an event, naming its owner and group.
+func (m *Monitor) state(e *Entry) *pb.FileState {
+	if e == nil {
+		return nil
+	}
+	return &pb.FileState{
+		Sha256:     e.SHA256,
+		Mode:       unixMode(fs.FileMode(e.Mode)),
+		Uid:        e.UID,
+		Gid:        e.GID,
+		Owner:      m.lookup(&m.owners, e.UID, userName),
+		Group:      m.lookup(&m.groups, e.GID, groupName),
+		Size:       e.Size,
+		Mtime:      e.MTime,
+		LinkTarget: e.Link,
+	}
+}
+
+// unixMode converts a FileMode to the st_mode bits of stat(2).
+func unixMode(mode fs.FileMode) uint32 {
+	bits := uint32(mode.Perm())
+	if mode&fs.ModeSetuid != 0 {
+		bits |= 0o4000
+	}
+	if mode&fs.ModeSetgid != 0 {
+		bits |= 0o2000
+	}
+	if mode&fs.ModeSticky != 0 {
+		bits |= 0o1000
+	}
+	switch {
+	case mode.IsDir():
+		bits |= 0o040000
+	case mode&fs.ModeSymlink != 0:
+		bits |= 0o120000
+	default:
+		bits |= 0o100000
+	}
+	return bits
+}
+
+func (m *Monitor) lookup(cache *map[uint32]string, id uint32, resolve func(string) string) string {
+	if *cache == nil {
+		*cache = make(map[uint32]string)
+	}
+	name, ok := (*cache)[id]
+	if !ok {
+		name = resolve(strconv.FormatUint(uint64(id), 10))
+		(*cache)[id] = name
+	}
+	return name
+}
+
+func userName(id string) string {
+	if u, err := user.LookupId(id); err == nil {
+		return u.Username
+	}
+	return ""
+}
+
+func groupName(id string) string {
+	if g, err := user.LookupGroupId(id); err == nil {
+		return g.Name
+	}
+	return ""
+}
+
+// save writes the baseline atomically. Owner names are looked up again
+// after each save, so renamed users show up.
+func (m *Monitor) save() {
+	m.owners, m.groups = nil, nil
+	data, err := json.Marshal(baselineFile{Roots: m.roots, Files: m.files})
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(m.baselinePath), 0o700); err != nil {
+		log.Printf("File integrity: failed to save baseline: %v", err)
+		return
+	}
+	tmp := m.baselinePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		log.Printf("File integrity: failed to save baseline: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, m.baselinePath); err != nil {
+		log.Printf("File integrity: failed to save baseline: %v", err)
+	}
+}
@@ -0,0 +1,15 @@
+package fim
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// sysStat returns the owner, group, status change time and inode of a file.
+func sysStat(info fs.FileInfo) (uid, gid uint32, ctime int64, inode uint64) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, 0, 0
+	}
+	return st.Uid, st.Gid, st.Ctim.Nano(), st.Ino
+}
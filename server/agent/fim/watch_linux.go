@@ -0,0 +1,140 @@
+package fim
+
+import (
+	"encoding/binary"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+// watchMask selects the inotify events that can change a baseline entry.
+const watchMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MODIFY |
+	syscall.IN_CLOSE_WRITE | syscall.IN_ATTRIB | syscall.IN_MOVED_FROM |
+	syscall.IN_MOVED_TO | syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF |
+	syscall.IN_DONT_FOLLOW
+
+// watcher reports the paths inotify sees change in the watched
+// directories. An empty path means events were lost. A nil watcher (inotify
+// unavailable) watches nothing.
+type watcher struct {
+	fd   int
+	file *os.File
+	out  chan string
+	done chan struct{}
+
+	mu      sync.Mutex
+	dirs    map[int]string // watch descriptor to directory
+	limited bool           // the watch limit was hit and logged
+}
+
+// newWatcher starts an inotify watcher, or returns nil if inotify cannot be
+// used; scans then find every change.
+func newWatcher() *watcher {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		log.Printf("File integrity: inotify unavailable, relying on scans: %v", err)
+		return nil
+	}
+	w := &watcher{
+		fd: fd,
+		// A non-blocking descriptor wrapped in an os.File uses the runtime
+		// poller, so closing it ends a pending read.
+		file: os.NewFile(uintptr(fd), "inotify"),
+		out:  make(chan string, 1024),
+		done: make(chan struct{}),
+		dirs: make(map[int]string),
+	}
+	go w.read()
+	return w
+}
+
+// add watches the directory path.
+func (w *watcher) add(path string) {
+	if w == nil {
+		return
+	}
+	wd, err := syscall.InotifyAddWatch(w.fd, path, watchMask|syscall.IN_ONLYDIR)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err != nil {
+		if errors.Is(err, syscall.ENOSPC) && !w.limited {
+			w.limited = true
+			log.Printf("File integrity: inotify watch limit reached (fs.inotify.max_user_watches); changes below %s are found by scans only", path)
+		}
+		return
+	}
+	w.dirs[wd] = path
+}
+
+// events returns the channel of changed paths. It is closed when the watcher
+// fails or is closed.
+func (w *watcher) events() <-chan string {
+	if w == nil {
+		return nil
+	}
+	return w.out
+}
+
+func (w *watcher) close() {
+	if w == nil {
+		return
+	}
+	close(w.done)
+	w.file.Close()
+}
+
+func (w *watcher) read() {
+	defer close(w.out)
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) {
+				log.Printf("File integrity: inotify read failed, relying on scans: %v", err)
+			}
+			return
+		}
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+			wd := int(int32(binary.NativeEndian.Uint32(buf[off:])))
+			mask := binary.NativeEndian.Uint32(buf[off+4:])
+			nameLen := int(binary.NativeEndian.Uint32(buf[off+12:]))
+			name := buf[off+syscall.SizeofInotifyEvent : off+syscall.SizeofInotifyEvent+nameLen]
+			off += syscall.SizeofInotifyEvent + nameLen
+
+			var path string
+			if mask&syscall.IN_Q_OVERFLOW == 0 {
+				w.mu.Lock()
+				dir, ok := w.dirs[wd]
+				if mask&syscall.IN_IGNORED != 0 {
+					delete(w.dirs, wd)
+				}
+				w.mu.Unlock()
+				if !ok {
+					continue
+				}
+				path = dir // the directory itself
+				if nameLen > 0 {
+					path = filepath.Join(dir, cString(name))
+				}
+			}
+			select {
+			case w.out <- path:
+			case <-w.done:
+				return
+			}
+		}
+	}
+}
+
+// cString returns the NUL-padded name of an inotify event.
+func cString(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package fim
+
+// Without inotify every change is found by the periodic scans.
+
+type watcher struct{}
+
+func newWatcher() *watcher { return nil }
+
+func (w *watcher) add(path string)       {}
+func (w *watcher) events() <-chan string { return nil }
+func (w *watcher) close()                {}
@@ -5,6 +5,7 @@ go 1.24.12
 require (
 	google.golang.org/grpc v1.79.1
 	google.golang.org/protobuf v1.36.10
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"slices"
+
+	"github.com/lute/agent/host"
+
+	pb "github.com/lute/agent/proto/agent"
+)
+
+// protocolVersion is the stream protocol this agent speaks. Bump it when a
+// change cannot be expressed as a new Feature.
+const protocolVersion = 1
+
+// agentFeatures lists the optional protocol features this agent implements.
+var agentFeatures = []pb.Feature{
+	pb.Feature_FEATURE_CERTIFICATE_RENEWAL,
+	pb.Feature_FEATURE_TOKEN_ROTATION,
+	pb.Feature_FEATURE_SELF_UPDATE,
+	pb.Feature_FEATURE_BACKFILL,
+	pb.Feature_FEATURE_INVENTORY,
+	pb.Feature_FEATURE_FILE_INTEGRITY,
+	pb.Feature_FEATURE_UNITS,
+	pb.Feature_FEATURE_ACTIONS,
+	pb.Feature_FEATURE_FILE_TRANSFER,
+	pb.Feature_FEATURE_CONFIG,
+}
+
+// legacyFeatures is what a server that predates Hello uses; it never sends
+// a Welcome.
+var legacyFeatures = []pb.Feature{
+	pb.Feature_FEATURE_CERTIFICATE_RENEWAL,
+	pb.Feature_FEATURE_TOKEN_ROTATION,
+	pb.Feature_FEATURE_SELF_UPDATE,
+	pb.Feature_FEATURE_BACKFILL,
+}
+
+// newHello describes this agent and its host for the first message of a
+// stream. It is collected on every connect so upgrades, address changes and
+// reboots reach the server. enabledActions are those the agent's policy
+// allows.
+func newHello(enabledActions []string) *pb.Hello {
+	info := host.Collect()
+	return &pb.Hello{
+		AgentVersion:    Version,
+		ProtocolVersion: protocolVersion,
+		Features:        agentFeatures,
+		Os:              info.OS,
+		Arch:            info.Arch,
+		OsRelease:       info.OSRelease,
+		Kernel:          info.Kernel,
+		UptimeSeconds:   int64(info.Uptime.Seconds()),
+		IpAddresses:     info.IPs,
+		BootId:          info.BootID,
+		Hostname:        info.Hostname,
+		Actions:         enabledActions,
+	}
+}
+
+// featureSet holds the features negotiated for a stream.
+type featureSet []pb.Feature
+
+func (s featureSet) has(f pb.Feature) bool {
+	return slices.Contains(s, f)
+}
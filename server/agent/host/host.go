@@ -0,0 +1,66 @@
+// Package host reports facts about the machine the agent runs on. They are
+// sent to the server in the Hello that opens every stream.
+package host
+
+import (
+	"net"
+	"runtime"
+	"time"
+
+	"github.com/lute/agent/utils"
+)
+
+// Info describes the running system. Fields that cannot be determined on
+// the current platform are left empty.
+type Info struct {
+	Hostname  string
+	OS        string
+	Arch      string
+	OSRelease string // distribution name and version
+	OSID      string // os-release ID, e.g. "debian"
+	OSVersion string // os-release VERSION_ID, e.g. "12"
+	Kernel    string // kernel release
+	Uptime    time.Duration
+	BootID    string
+	IPs       []string // non-loopback addresses, IPv4 first
+}
+
+// Collect gathers the current host facts.
+func Collect() Info {
+	release, id, version := osRelease()
+	return Info{
+		Hostname:  utils.MustHostname(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+		OSRelease: release,
+		OSID:      id,
+		OSVersion: version,
+		Kernel:    kernelRelease(),
+		Uptime:    uptime(),
+		BootID:    bootID(),
+		IPs:       addresses(),
+	}
+}
+
+// addresses lists global unicast addresses, IPv4 before IPv6, in interface
+// order. Link-local addresses are skipped; they say nothing about where the
+// machine can be reached.
+func addresses() []string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	var v4, v6 []string
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || !ipnet.IP.IsGlobalUnicast() {
+			continue
+		}
+		if ipnet.IP.To4() != nil {
+			v4 = append(v4, ipnet.IP.String())
+		} else {
+			v6 = append(v6, ipnet.IP.String())
+		}
+	}
+	return append(v4, v6...)
+}
@@ -0,0 +1,68 @@
+package host
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// osRelease reads os-release(5). name is PRETTY_NAME, or NAME and VERSION
+// if it is missing; id and versionID are ID and VERSION_ID (e.g. "debian"
+// and "12").
+func osRelease() (name, id, versionID string) {
+	data, err := os.ReadFile("/etc/os-release")
+	if err != nil {
+		data, err = os.ReadFile("/usr/lib/os-release")
+		if err != nil {
+			return "", "", ""
+		}
+	}
+	fields := make(map[string]string)
+	for _, line := range strings.Split(string(data), "\n") {
+		k, v, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok {
+			continue
+		}
+		if unquoted, err := strconv.Unquote(v); err == nil {
+			v = unquoted
+		} else {
+			v = strings.Trim(v, `'`)
+		}
+		fields[k] = v
+	}
+	name = fields["PRETTY_NAME"]
+	if name == "" {
+		name = strings.TrimSpace(fields["NAME"] + " " + fields["VERSION"])
+	}
+	return name, fields["ID"], fields["VERSION_ID"]
+}
+
+func kernelRelease() string {
+	return readTrimmed("/proc/sys/kernel/osrelease")
+}
+
+// uptime reads the first field of /proc/uptime (seconds since boot).
+func uptime() time.Duration {
+	fields := strings.Fields(readTrimmed("/proc/uptime"))
+	if len(fields) == 0 {
+		return 0
+	}
+	secs, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0
+	}
+	return time.Duration(secs * float64(time.Second))
+}
+
+func bootID() string {
+	return readTrimmed("/proc/sys/kernel/random/boot_id")
+}
+
+func readTrimmed(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package host
+
+import "time"
+
+// Only the portable facts are reported outside Linux.
+
+func osRelease() (name, id, versionID string) { return "", "", "" }
+
+func kernelRelease() string { return "" }
+
+func uptime() time.Duration { return 0 }
+
+func bootID() string { return "" }
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/lute/agent/inventory"
+
+	pb "github.com/lute/agent/proto/agent"
+)
+
+// inventoryCollector re-collects the machine inventory periodically in the
+// background, so the stream loop only compares hashes. A nil collector
+// (inventory disabled) has nothing to report.
+type inventoryCollector struct {
+	interval *tickInterval
+	current  atomic.Pointer[pb.Inventory]
+}
+
+func newInventoryCollector(interval time.Duration) *inventoryCollector {
+	return &inventoryCollector{interval: newTickInterval(interval)}
+}
+
+func (c *inventoryCollector) run(ctx context.Context) {
+	if c == nil {
+		return
+	}
+	ticker := time.NewTicker(c.interval.get())
+	defer ticker.Stop()
+	for {
+		c.current.Store(inventory.Collect())
+		if !c.interval.wait(ctx, ticker) {
+			return
+		}
+	}
+}
+
+// setInterval changes how often the inventory is collected.
+func (c *inventoryCollector) setInterval(d time.Duration) {
+	if c != nil {
+		c.interval.set(d)
+	}
+}
+
+// changedSince returns the latest inventory if its hash differs from hash,
+// or nil.
+func (c *inventoryCollector) changedSince(hash string) *pb.Inventory {
+	if c == nil {
+		return nil
+	}
+	inv := c.current.Load()
+	if inv == nil || inv.GetHash() == hash {
+		return nil
+	}
+	return inv
+}
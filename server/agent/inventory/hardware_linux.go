@@ -0,0 +1,118 @@
+package inventory
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	pb "github.com/lute/agent/proto/agent"
+)
+
+const dmiDir = "/sys/class/dmi/id"
+
+// hardware reads the CPU model from /proc/cpuinfo, total memory from
+// /proc/meminfo and the world-readable DMI fields.
+func hardware() *pb.Hardware {
+	return &pb.Hardware{
+		CpuModel:       cpuModel(),
+		MemoryBytes:    memTotal(),
+		SystemVendor:   readTrimmed(filepath.Join(dmiDir, "sys_vendor")),
+		ProductName:    readTrimmed(filepath.Join(dmiDir, "product_name")),
+		ProductVersion: readTrimmed(filepath.Join(dmiDir, "product_version")),
+		BiosVendor:     readTrimmed(filepath.Join(dmiDir, "bios_vendor")),
+		BiosVersion:    readTrimmed(filepath.Join(dmiDir, "bios_version")),
+		BoardVendor:    readTrimmed(filepath.Join(dmiDir, "board_vendor")),
+		BoardName:      readTrimmed(filepath.Join(dmiDir, "board_name")),
+	}
+}
+
+// cpuModel returns the first "model name" in /proc/cpuinfo. ARM kernels
+// use "Hardware" or "Model" instead.
+func cpuModel() string {
+	f, err := os.Open("/proc/cpuinfo")
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	found := make(map[string]string)
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		k, v, ok := strings.Cut(sc.Text(), ":")
+		if !ok {
+			continue
+		}
+		k = strings.TrimSpace(k)
+		if _, seen := found[k]; !seen {
+			found[k] = strings.TrimSpace(v)
+		}
+	}
+	for _, k := range []string{"model name", "Hardware", "Model", "cpu model"} {
+		if v := found[k]; v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// memTotal returns MemTotal from /proc/meminfo in bytes.
+func memTotal() uint64 {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kb, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return 0
+			}
+			return kb * 1024
+		}
+	}
+	return 0
+}
+
+// virtualBlockPrefixes are kernel block devices that are not disks.
+var virtualBlockPrefixes = []string{"loop", "ram", "zram", "dm-", "nbd"}
+
+// blockDevices lists the whole disks in /sys/block (partitions are not
+// listed there).
+func blockDevices() []*pb.BlockDevice {
+	entries, err := os.ReadDir("/sys/block")
+	if err != nil {
+		return nil
+	}
+	var out []*pb.BlockDevice
+next:
+	for _, e := range entries {
+		name := e.Name()
+		for _, prefix := range virtualBlockPrefixes {
+			if strings.HasPrefix(name, prefix) {
+				continue next
+			}
+		}
+		dir := filepath.Join("/sys/block", name)
+		sectors, _ := strconv.ParseUint(readTrimmed(filepath.Join(dir, "size")), 10, 64)
+		out = append(out, &pb.BlockDevice{
+			Name:       name,
+			SizeBytes:  sectors * 512, // always in 512-byte units, whatever the device's sector size
+			Model:      readTrimmed(filepath.Join(dir, "device", "model")),
+			Rotational: readTrimmed(filepath.Join(dir, "queue", "rotational")) == "1",
+			Removable:  readTrimmed(filepath.Join(dir, "removable")) == "1",
+		})
+	}
+	return out
+}
+
+func readTrimmed(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package inventory
+
+import pb "github.com/lute/agent/proto/agent"
+
+// Only the CPU count (set by Collect) is reported outside Linux.
+
+func hardware() *pb.Hardware { return &pb.Hardware{} }
+
+func blockDevices() []*pb.BlockDevice { return nil }
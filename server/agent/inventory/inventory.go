@@ -0,0 +1,94 @@
+// Package inventory collects the software and hardware inventory of the
+// machine: installed packages, OS and kernel release, CPU, memory, DMI
+// system information, network interfaces and block devices.
+//
+// Collection reads files and runs no commands except rpm(8) on RPM-based
+// systems, so it is cheap enough to repeat every few minutes; the hash lets
+// the caller send the result only when something changed.
+package inventory
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"runtime"
+	"sort"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/lute/agent/host"
+
+	pb "github.com/lute/agent/proto/agent"
+)
+
+// Collect gathers the current inventory and sets its hash.
+func Collect() *pb.Inventory {
+	info := host.Collect()
+	hw := hardware()
+	hw.CpuCount = uint32(runtime.NumCPU())
+
+	packages := installedPackages()
+	sort.Slice(packages, func(i, j int) bool {
+		if packages[i].GetName() != packages[j].GetName() {
+			return packages[i].GetName() < packages[j].GetName()
+		}
+		return packages[i].GetArch() < packages[j].GetArch()
+	})
+
+	inv := &pb.Inventory{
+		CollectedAt:       time.Now().Unix(),
+		OsRelease:         info.OSRelease,
+		OsId:              info.OSID,
+		OsVersionId:       info.OSVersion,
+		Kernel:            info.Kernel,
+		Hardware:          hw,
+		Packages:          packages,
+		NetworkInterfaces: networkInterfaces(),
+		BlockDevices:      blockDevices(),
+	}
+	inv.Hash = Hash(inv)
+	return inv
+}
+
+// Hash returns the hex SHA-256 of the inventory's content. The hash and
+// collection time are left out, so two collections of an unchanged machine
+// hash the same.
+func Hash(inv *pb.Inventory) string {
+	content := proto.Clone(inv).(*pb.Inventory)
+	content.Hash = ""
+	content.CollectedAt = 0
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(content)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// networkInterfaces lists interfaces that have a hardware address, with
+// their addresses in CIDR notation.
+func networkInterfaces() []*pb.NetworkInterface {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	var out []*pb.NetworkInterface
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 || len(iface.HardwareAddr) == 0 {
+			continue
+		}
+		nic := &pb.NetworkInterface{
+			Name: iface.Name,
+			Mac:  iface.HardwareAddr.String(),
+			Mtu:  uint32(iface.MTU),
+		}
+		if addrs, err := iface.Addrs(); err == nil {
+			for _, addr := range addrs {
+				nic.Addresses = append(nic.Addresses, addr.String())
+			}
+		}
+		out = append(out, nic)
+	}
+	return out
+}
@@ -0,0 +1,190 @@
+package inventory
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	pb "github.com/lute/agent/proto/agent"
+)
+
+// Package formats, which also select the version comparison the server uses.
+const (
+	FormatDeb = "deb"
+	FormatRPM = "rpm"
+	FormatApk = "apk"
+)
+
+const (
+	dpkgStatusPath = "/var/lib/dpkg/status"
+	apkInstalled   = "/lib/apk/db/installed"
+)
+
+// installedPackages returns the packages of every package manager found on
+// the system. Machines with more than one (e.g. rpm on a Debian host) report
+// both.
+func installedPackages() []*pb.Package {
+	var out []*pb.Package
+	if f, err := os.Open(dpkgStatusPath); err == nil {
+		out = append(out, parseDpkgStatus(f)...)
+		f.Close()
+	}
+	if f, err := os.Open(apkInstalled); err == nil {
+		out = append(out, parseApkInstalled(f)...)
+		f.Close()
+	}
+	out = append(out, rpmPackages()...)
+	return out
+}
+
+// parseDpkgStatus reads dpkg's status file: one stanza of "Field: value"
+// lines per package, separated by blank lines. Only packages whose status
+// is "installed" are returned, not those removed with their config kept.
+func parseDpkgStatus(r io.Reader) []*pb.Package {
+	var out []*pb.Package
+	fields := make(map[string]string)
+	flush := func() {
+		if fields["Package"] != "" && strings.HasSuffix(fields["Status"], " installed") {
+			out = append(out, &pb.Package{
+				Name:    fields["Package"],
+				Version: fields["Version"],
+				Arch:    fields["Architecture"],
+				Format:  FormatDeb,
+				Source:  dpkgSource(fields["Source"], fields["Package"]),
+			})
+		}
+		clear(fields)
+	}
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		line := sc.Text()
+		if line == "" {
+			flush()
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			continue // continuation of a multi-line field
+		}
+		if k, v, ok := strings.Cut(line, ":"); ok {
+			fields[k] = strings.TrimSpace(v)
+		}
+	}
+	flush()
+	return out
+}
+
+// dpkgSource returns the source package name from a "Source" field, which
+// may carry the source version in parentheses ("openssl (3.0.11-1)"). It is
+// empty when the source is named like the package.
+func dpkgSource(field, name string) string {
+	source, _, _ := strings.Cut(field, " ")
+	if source == name {
+		return ""
+	}
+	return source
+}
+
+// parseApkInstalled reads apk's installed database: one record of
+// single-letter "K:value" lines per package, separated by blank lines.
+func parseApkInstalled(r io.Reader) []*pb.Package {
+	var out []*pb.Package
+	var cur *pb.Package
+	flush := func() {
+		if cur != nil && cur.Name != "" {
+			if cur.Source == cur.Name {
+				cur.Source = ""
+			}
+			out = append(out, cur)
+		}
+		cur = nil
+	}
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		line := sc.Text()
+		if line == "" {
+			flush()
+			continue
+		}
+		if len(line) < 2 || line[1] != ':' {
+			continue
+		}
+		if cur == nil {
+			cur = &pb.Package{Format: FormatApk}
+		}
+		switch line[0] {
+		case 'P':
+			cur.Name = line[2:]
+		case 'V':
+			cur.Version = line[2:]
+		case 'A':
+			cur.Arch = line[2:]
+		case 'o':
+			cur.Source = line[2:] // origin: the APKBUILD it was built from
+		}
+	}
+	flush()
+	return out
+}
+
+// rpmQueryFormat prints name, [epoch:]version-release, arch and source RPM
+// per package.
+const rpmQueryFormat = `%{NAME}\t%|EPOCH?{%{EPOCH}:}:{}|%{VERSION}-%{RELEASE}\t%{ARCH}\t%{SOURCERPM}\n`
+
+// rpmPackages asks rpm(8) for the installed packages. The database itself
+// (Berkeley DB, NDB or SQLite, depending on the release) has no stable
+// on-disk format, so it is not read directly.
+func rpmPackages() []*pb.Package {
+	path, err := exec.LookPath("rpm")
+	if err != nil {
+		return nil
+	}
+	out, err := exec.Command(path, "-qa", "--qf", rpmQueryFormat).Output()
+	if err != nil {
+		return nil
+	}
+	var pkgs []*pb.Package
+	for _, line := range bytes.Split(out, []byte("\n")) {
+		parts := strings.Split(string(line), "\t")
+		if len(parts) != 4 || parts[0] == "" || parts[0] == "gpg-pubkey" {
+			continue
+		}
+		arch := parts[2]
+		if arch == "(none)" {
+			arch = ""
+		}
+		pkgs = append(pkgs, &pb.Package{
+			Name:    parts[0],
+			Version: parts[1],
+			Arch:    arch,
+			Format:  FormatRPM,
+			Source:  rpmSource(parts[3], parts[0]),
+		})
+	}
+	return pkgs
+}
+
+// rpmSource returns the name in a source RPM file name
+// ("openssl-3.0.7-24.el9.src.rpm" is "openssl"), or "" if it is the
+// package's own name or cannot be parsed.
+func rpmSource(srpm, name string) string {
+	base := strings.TrimSuffix(srpm, ".src.rpm")
+	if base == srpm {
+		return ""
+	}
+	for range 2 { // drop -release, then -version
+		i := strings.LastIndexByte(base, '-')
+		if i <= 0 {
+			return ""
+		}
+		base = base[:i]
+	}
+	if base == name {
+		return ""
+	}
+	return base
+}
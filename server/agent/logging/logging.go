@@ -0,0 +1,97 @@
+// Package logging adds levels to the agent's log. Messages written with the
+// standard log package are at info level; Debugf, Warnf and Errorf write at
+// theirs. The level can be changed while the agent runs, e.g. by the
+// configuration the server pushes.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// Level is the severity of a message. Messages below the current level are
+// dropped.
+type Level int32
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+// Names are the valid level names, from the most to the least verbose.
+var Names = []string{"debug", "info", "warn", "error"}
+
+var (
+	current atomic.Int32
+	out     = log.New(os.Stderr, "", log.LstdFlags)
+)
+
+func init() {
+	current.Store(int32(LevelInfo))
+}
+
+// Install routes the standard logger through the level filter, so its
+// messages are dropped while the level is above info. Call it once, after
+// the standard logger's output is set up.
+func Install() {
+	out.SetOutput(log.Writer())
+	out.SetFlags(log.Flags())
+	out.SetPrefix(log.Prefix())
+	log.SetOutput(infoFilter{w: log.Writer()})
+}
+
+// ParseLevel returns the level with the given name.
+func ParseLevel(name string) (Level, error) {
+	for i, n := range Names {
+		if strings.EqualFold(name, n) {
+			return Level(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q (valid: %s)", name, strings.Join(Names, ", "))
+}
+
+func (l Level) String() string {
+	if l < 0 || int(l) >= len(Names) {
+		return fmt.Sprintf("level(%d)", int32(l))
+	}
+	return Names[l]
+}
+
+// SetLevel changes the level.
+func SetLevel(l Level) {
+	current.Store(int32(l))
+}
+
+// CurrentLevel returns the level.
+func CurrentLevel() Level {
+	return Level(current.Load())
+}
+
+func Debugf(format string, args ...any) { logf(LevelDebug, format, args...) }
+func Warnf(format string, args ...any)  { logf(LevelWarn, format, args...) }
+func Errorf(format string, args ...any) { logf(LevelError, format, args...) }
+
+func logf(l Level, format string, args ...any) {
+	if l < CurrentLevel() {
+		return
+	}
+	out.Output(3, fmt.Sprintf(format, args...))
+}
+
+// infoFilter drops the standard logger's messages above info level.
+type infoFilter struct {
+	w io.Writer
+}
+
+func (f infoFilter) Write(p []byte) (int, error) {
+	if CurrentLevel() > LevelInfo {
+		return len(p), nil
+	}
+	return f.w.Write(p)
+}
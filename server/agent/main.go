@@ -1,27 +1,34 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
+	"crypto/ecdsa"
 	"flag"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
 	"os"
 	"os/signal"
 	"runtime"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
 
+	"github.com/lute/agent/actions"
+	"github.com/lute/agent/certs"
+	"github.com/lute/agent/config"
+	"github.com/lute/agent/logging"
 	"github.com/lute/agent/metrics"
+	"github.com/lute/agent/service"
 	"github.com/lute/agent/setup"
 	"github.com/lute/agent/setup/types"
+	"github.com/lute/agent/state"
+	"github.com/lute/agent/transfer"
+	"github.com/lute/agent/update"
 	"github.com/lute/agent/utils"
 
 	pb "github.com/lute/agent/proto/agent"
@@ -33,15 +40,30 @@ var (
 )
 
 type Flags struct {
+	configPath string
 	serverAddr string
 	apiURL     string
 	machineID  string
 	claimCode  string
+	enrollment string
+	labels     string
+	stateDir   string
+	certDir    string
 	version    bool
 	setupMode  bool
+
+	set map[string]bool // flags given on the command line
 }
 
 func main() {
+	logging.Install()
+	if len(os.Args) > 1 && os.Args[1] == "service" {
+		if err := service.Main(os.Args[2:]); err != nil {
+			log.Fatalf("service: %v", err)
+		}
+		return
+	}
+
 	flags := parseFlags()
 
 	if flags.version {
@@ -49,43 +71,121 @@ func main() {
 		os.Exit(0)
 	}
 
+	cfg, err := loadConfig(flags)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
 	if flags.setupMode {
-		setup.Run(flags.apiURL, Version, BuildTime, flags.claimCode)
+		setup.Run(cfg, Version, BuildTime, flags.claimCode)
 		return
 	}
 
-	runAgent(flags)
+	runAgent(cfg, flags)
 }
 
 func parseFlags() *Flags {
 	f := &Flags{}
-	flag.StringVar(&f.serverAddr, "server", "localhost:50051", "gRPC server address")
-	flag.StringVar(&f.apiURL, "api", "http://localhost:8080", "HTTP API base URL")
+	flag.StringVar(&f.configPath, "config", "", "Config file (default $LUTE_CONFIG or "+config.DefaultPath()+")")
+	flag.StringVar(&f.serverAddr, "server", "", "gRPC server address (default: the address returned at registration, else "+config.DefaultServer+")")
+	flag.StringVar(&f.apiURL, "api", "", "HTTP API base URL (default "+config.DefaultAPI+")")
 	flag.StringVar(&f.machineID, "machine-id", "", "Machine ID (skip REST registration if provided)")
-	flag.StringVar(&f.claimCode, "claim-code", "", "Claim code from UI to link this machine to your account")
+	flag.StringVar(&f.claimCode, "claim-code", os.Getenv("LUTE_CLAIM_CODE"), "Claim code from UI to link this machine to your account")
+	flag.StringVar(&f.enrollment, "enrollment-token", "", "Reusable enrollment token for automated provisioning (instead of --claim-code)")
+	flag.StringVar(&f.labels, "labels", "", "Comma-separated key=value labels sent at registration (e.g. env=prod,role=db)")
+	flag.StringVar(&f.stateDir, "state-dir", "", "Directory for the machine ID and credentials (default "+state.DefaultDir()+")")
+	flag.StringVar(&f.certDir, "cert-dir", "", "Directory for the mTLS key and certificates (default <state-dir>/certs)")
 	flag.BoolVar(&f.version, "version", false, "Print version and exit")
 	flag.BoolVar(&f.setupMode, "setup", false, "Run interactive setup")
 	flag.Parse()
+
+	f.set = make(map[string]bool)
+	flag.Visit(func(fl *flag.Flag) { f.set[fl.Name] = true })
 	return f
 }
 
-func runAgent(flags *Flags) {
+// loadConfig merges the config file, LUTE_* environment variables and flags,
+// in increasing order of precedence.
+func loadConfig(flags *Flags) (*config.Config, error) {
+	path, explicit := flags.configPath, flags.set["config"]
+	if !explicit {
+		if env, ok := os.LookupEnv("LUTE_CONFIG"); ok {
+			path, explicit = env, true
+		} else {
+			path = config.DefaultPath()
+		}
+	}
+	cfg, err := config.Load(path, explicit)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.ApplyEnv(); err != nil {
+		return nil, err
+	}
+
+	if flags.set["server"] {
+		cfg.Server = flags.serverAddr
+	}
+	if flags.set["api"] {
+		cfg.API = flags.apiURL
+	}
+	if flags.set["state-dir"] {
+		cfg.StateDir = flags.stateDir
+	}
+	if flags.set["cert-dir"] {
+		cfg.CertDir = flags.certDir
+	}
+	if flags.set["enrollment-token"] {
+		cfg.EnrollmentToken = flags.enrollment
+	}
+	if flags.set["labels"] {
+		labels, err := utils.ParseLabels(flags.labels)
+		if err != nil {
+			return nil, fmt.Errorf("--labels: %w", err)
+		}
+		cfg.Labels = labels
+	}
+	return cfg, cfg.Finalize()
+}
+
+func runAgent(cfg *config.Config, flags *Flags) {
 	log.Printf("Lute Agent %s starting (build: %s)", Version, BuildTime)
+	if cfg.Path != "" {
+		log.Printf("  Config:     %s", cfg.Path)
+	}
 
-	machineID := flags.machineID
-	serverAddr := flags.serverAddr
+	st, err := state.Load(cfg.StateDir)
+	if err != nil {
+		log.Fatalf("Failed to read agent state in %s: %v", cfg.StateDir, err)
+	}
 
-	// If no machine-id, register via REST and obtain one.
+	// An explicit --machine-id wins over the stored registration; otherwise
+	// reuse the stored one and only register when there is none.
+	machineID := flags.machineID
+	if machineID == "" && st != nil {
+		machineID = st.MachineID
+	}
 	if machineID == "" {
-		var grpcAddr string
-		machineID, grpcAddr = registerViaREST(flags.apiURL)
-		if grpcAddr != "" {
-			serverAddr = grpcAddr
-		}
+		st = registerViaREST(cfg, flags.claimCode)
+		machineID = st.MachineID
+	}
+
+	serverAddr := cfg.Server
+	if serverAddr == "" && st != nil && st.MachineID == machineID {
+		serverAddr = st.GRPCAddress
+	}
+	if serverAddr == "" {
+		serverAddr = config.DefaultServer
+	}
+
+	store := certs.ForMachine(cfg.CertDir, machineID)
+	if !store.Exists() {
+		log.Fatalf("%v (looked in %s)", certs.ErrNotEnrolled, store.Dir)
 	}
 
 	log.Printf("  Machine ID: %s", machineID)
 	log.Printf("  Server:     %s", serverAddr)
+	log.Printf("  State:      %s", cfg.StateDir)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -99,76 +199,117 @@ func runAgent(flags *Flags) {
 		cancel()
 	}()
 
+	// Finish (or roll back) an update left by the previous process.
+	publicKey, err := update.PinnedKey(cfg.UpdatePublicKey)
+	if err != nil {
+		log.Fatalf("Invalid update public key: %v", err)
+	}
+	updater := update.New(cfg.API, Version, cfg.StateDir, publicKey, cfg.DisableUpdates)
+	if err := updater.Resume(); err != nil {
+		logging.Warnf("Failed to resume agent update: %v", err)
+	}
+
+	// Metrics sampled while the server is unreachable are sent on reconnect.
+	buffer := openBuffer(cfg)
+	go buffer.run(ctx)
+
+	// The inventory is sent whenever it changes.
+	var inv *inventoryCollector
+	if !cfg.DisableInventory {
+		inv = newInventoryCollector(cfg.Intervals.Inventory)
+		go inv.run(ctx)
+	}
+
+	// The state of systemd units is sent whenever it changes.
+	var unitStates *unitCollector
+	if !cfg.Units.Disabled {
+		unitStates = newUnitCollector(cfg.Units.Watch, cfg.Intervals.Units)
+		go unitStates.run(ctx)
+	}
+
+	// Changes to watched files are queued until the server stores them.
+	fileEvents := openFileIntegrity(cfg)
+	go fileEvents.run(ctx)
+
+	// Actions requested by the server run in the background; their results
+	// are sent once they finish, on whichever stream is open by then.
+	// Actions this process lacks the privileges for are neither run nor
+	// offered to the server.
+	enabled, denied := actions.Usable(cfg.Actions.Enabled)
+	if len(denied) > 0 {
+		logging.Warnf("Actions disabled, not running as root and not granted by polkit: %s", strings.Join(denied, ", "))
+	}
+	cfg.Actions.Enabled = enabled
+	actionRunner := newActionRunner(ctx, actions.Policy{Enabled: cfg.Actions.Enabled, ScriptsDir: cfg.Actions.ScriptsDir})
+	if len(cfg.Actions.Enabled) > 0 {
+		log.Printf("Actions enabled: %s", strings.Join(cfg.Actions.Enabled, ", "))
+	}
+
+	// Files are copied to and from the paths the policy allows.
+	transfers := transfer.NewManager(transfer.Policy{
+		Read:    cfg.Files.Read,
+		Write:   cfg.Files.Write,
+		MaxSize: cfg.Files.MaxSizeMB << 20,
+		Dir:     state.TransferDir(cfg.StateDir),
+	})
+
+	// Collectors, intervals and the log level may be changed by the server.
+	settings := newSettings(cfg, buffer, inv, unitStates)
+	level, _ := logging.ParseLevel(cfg.LogLevel)
+	logging.SetLevel(level)
+
 	// Persistent connection loop with reconnection.
-	connectLoop(ctx, serverAddr, machineID)
+	connectLoop(ctx, cfg, serverAddr, machineID, store, updater, buffer, inv, unitStates, fileEvents, actionRunner, transfers, settings)
 	log.Println("Agent stopped")
 }
 
-// registerViaREST calls POST /api/v1/agent/register and returns
-// (machine_id, grpc_address).
-func registerViaREST(apiURL string) (string, string) {
+// registerViaREST registers the machine with the server through
+// setup.Register, which stores the issued credentials under cfg.CertDir and
+// saves the registration in cfg.StateDir.
+func registerViaREST(cfg *config.Config, claimCode string) *state.State {
 	hostname := utils.MustHostname()
 	localIP := utils.GetLocalIP()
 
-	body := types.SetupRequest{
-		Name:     fmt.Sprintf("%s:%s", hostname, localIP),
-		Hostname: hostname,
-		OS:       runtime.GOOS,
-		Arch:     runtime.GOARCH,
-		CPUs:     runtime.NumCPU(),
-		IP:       localIP,
-		Version:  Version,
+	result, st, err := setup.Register(cfg, &types.SetupRequest{
+		Name:            fmt.Sprintf("%s:%s", hostname, localIP),
+		Hostname:        hostname,
+		OS:              runtime.GOOS,
+		Arch:            runtime.GOARCH,
+		CPUs:            runtime.NumCPU(),
+		IP:              localIP,
+		Version:         Version,
+		ClaimCode:       claimCode,
+		Labels:          cfg.Labels,
+		EnrollmentToken: cfg.EnrollmentToken,
 		Metadata: map[string]string{
 			"go_version": runtime.Version(),
 			"build_time": BuildTime,
 		},
-	}
-
-	data, err := json.Marshal(body)
-	if err != nil {
-		log.Fatalf("Failed to marshal registration request: %v", err)
-	}
-
-	url := strings.TrimRight(apiURL, "/") + "/api/v1/agent/register"
-	resp, err := http.Post(url, "application/json", bytes.NewReader(data))
+	})
 	if err != nil {
 		log.Fatalf("REST registration failed: %v", err)
 	}
-	defer resp.Body.Close()
-
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Fatalf("Failed to read registration response: %v", err)
-	}
-	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
-		log.Fatalf("Registration error %d: %s", resp.StatusCode, string(respBody))
-	}
-
-	var result types.SetupResponse
-	if err := json.Unmarshal(respBody, &result); err != nil {
-		log.Fatalf("Failed to parse registration response: %v", err)
-	}
 
 	log.Printf("Registered: machine_id=%s grpc=%s", result.MachineID, result.GRPCAddress)
-	return result.MachineID, result.GRPCAddress
+	return st
 }
 
 // connectLoop keeps the bidirectional stream alive, reconnecting with
 // exponential backoff on failure.
-func connectLoop(ctx context.Context, serverAddr, machineID string) {
-	backoff := time.Second
+func connectLoop(ctx context.Context, cfg *config.Config, serverAddr, machineID string, store *certs.Store, updater *update.Updater, buffer *telemetryBuffer, inv *inventoryCollector, unitStates *unitCollector, fileEvents *fileIntegrity, actionRunner *actionRunner, transfers *transfer.Manager, settings *settings) {
+	backoff := cfg.Intervals.ReconnectMin
 
 	for {
 		if ctx.Err() != nil {
 			return
 		}
 
-		err := runStream(ctx, serverAddr, machineID)
+		err := runStream(ctx, cfg, serverAddr, machineID, store, updater, buffer, inv, unitStates, fileEvents, actionRunner, transfers, settings)
 		if ctx.Err() != nil {
 			return
 		}
 
-		log.Printf("Stream disconnected: %v — reconnecting in %s", err, backoff)
+		logging.Warnf("Stream disconnected: %v — reconnecting in %s", err, backoff)
 		select {
 		case <-ctx.Done():
 			return
@@ -176,17 +317,51 @@ func connectLoop(ctx context.Context, serverAddr, machineID string) {
 		}
 
 		backoff *= 2
-		if backoff > 30*time.Second {
-			backoff = 30 * time.Second
+		if backoff > cfg.Intervals.ReconnectMax {
+			backoff = cfg.Intervals.ReconnectMax
 		}
 	}
 }
 
-// runStream opens a single Connect stream and processes heartbeat pings
-// until the stream breaks or the context is cancelled.
-func runStream(ctx context.Context, serverAddr, machineID string) error {
+// agentTokenMetadataKey carries the agent token on Connect.
+const agentTokenMetadataKey = "x-lute-agent-token"
+
+// runStream opens a single Connect stream over mutual TLS and processes
+// heartbeat pings until the stream breaks or the context is cancelled.
+// The stream is ended once the certificate is due for renewal so the next
+// connection gets a fresh one. Any message from the server confirms a
+// pending agent update. The stream opens with a Hello; the server's Welcome
+// names the features to use. After the first ping (the server has set up the
+// stream), buffered samples are sent one batch per acknowledgement if
+// backfill was agreed on. The inventory is sent after a ping whenever it
+// differs from what the server last received, and the state of systemd
+// units whenever it changed since the last one sent on this stream. Queued
+// file events are sent after a ping, one batch at a time, each
+// acknowledgement releasing the next. Requested actions run in the
+// background and their results are sent as they finish. File transfers
+// advance one chunk per message from the server. A pushed AgentConfig is
+// applied at once and acknowledged.
+func runStream(ctx context.Context, cfg *config.Config, serverAddr, machineID string, store *certs.Store, updater *update.Updater, buffer *telemetryBuffer, inv *inventoryCollector, unitStates *unitCollector, fileEvents *fileIntegrity, actionRunner *actionRunner, transfers *transfer.Manager, settings *settings) error {
+	tlsConfig, leaf, err := store.TLSConfig()
+	if err != nil {
+		return err
+	}
+	token, err := store.Token()
+	if err != nil {
+		return err
+	}
+	ctx = metadata.AppendToOutgoingContext(ctx, agentTokenMetadataKey, token)
+	if renewAt := certs.RenewAt(leaf); renewAt.After(time.Now()) {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, renewAt)
+		defer cancel()
+	}
+	// Stops the goroutines bound to this stream.
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+
 	conn, err := grpc.NewClient(serverAddr,
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)),
 	)
 	if err != nil {
 		return fmt.Errorf("dial: %w", err)
@@ -194,28 +369,48 @@ func runStream(ctx context.Context, serverAddr, machineID string) error {
 	defer conn.Close()
 
 	client := pb.NewAgentServiceClient(conn)
-	stream, err := client.Connect(ctx)
+	raw, err := client.Connect(ctx)
 	if err != nil {
 		return fmt.Errorf("open stream: %w", err)
 	}
-
-	// Send initial message with machine_id.
-	if err := stream.Send(&pb.AgentMessage{MachineId: machineID}); err != nil {
-		return fmt.Errorf("send initial: %w", err)
+	stream := &lockedStream{AgentService_ConnectClient: raw}
+
+	// Introduce ourselves; the server answers with the features to use.
+	if err := stream.Send(&pb.AgentMessage{
+		MachineId: machineID,
+		Payload:   &pb.AgentMessage_Hello{Hello: newHello(cfg.Actions.Enabled)},
+	}); err != nil {
+		return fmt.Errorf("send hello: %w", err)
 	}
 
 	log.Printf("Connected to %s", serverAddr)
 
+	features := featureSet(legacyFeatures)
+	// Hash of the inventory the server has; set from the Welcome.
+	inventoryHash := ""
+	// Hash of the unit report last sent on this stream.
+	unitsHash := ""
+
+	// Key generated for a pending certificate renewal.
+	var pendingKey *ecdsa.PrivateKey
+
+	defer buffer.setConnected(false)
+	streaming := false
+	// A batch of file events awaits its acknowledgement.
+	fileEventsInFlight := false
+
 	// Reset backoff on successful connect (caller handles backoff).
 	for {
 		msg, err := stream.Recv()
 		if err != nil {
 			return fmt.Errorf("recv: %w", err)
 		}
+		updater.Healthy()
 
-		if ping := msg.GetHeartbeatPing(); ping != nil {
-			log.Printf("Heartbeat ping received")
-			raw := metrics.Collect()
+		switch {
+		case msg.GetHeartbeatPing() != nil:
+			logging.Debugf("Heartbeat ping received")
+			raw := metrics.Collect(settings.Collectors())
 			pong := &pb.HeartbeatPong{
 				Status:    "running",
 				Metrics:   metricsToProto(raw),
@@ -227,10 +422,198 @@ func runStream(ctx context.Context, serverAddr, machineID string) error {
 			}); err != nil {
 				return fmt.Errorf("send pong: %w", err)
 			}
+			if features.has(pb.Feature_FEATURE_INVENTORY) {
+				if changed := inv.changedSince(inventoryHash); changed != nil {
+					if err := stream.Send(&pb.AgentMessage{
+						MachineId: machineID,
+						Payload:   &pb.AgentMessage_Inventory{Inventory: changed},
+					}); err != nil {
+						return fmt.Errorf("send inventory: %w", err)
+					}
+					log.Printf("Inventory sent (%d packages)", len(changed.GetPackages()))
+					inventoryHash = changed.GetHash()
+				}
+			}
+			if features.has(pb.Feature_FEATURE_UNITS) {
+				if changed := unitStates.changedSince(unitsHash); changed != nil {
+					if err := stream.Send(&pb.AgentMessage{
+						MachineId: machineID,
+						Payload:   &pb.AgentMessage_Units{Units: changed},
+					}); err != nil {
+						return fmt.Errorf("send units: %w", err)
+					}
+					unitsHash = changed.GetHash()
+				}
+			}
+			if features.has(pb.Feature_FEATURE_FILE_INTEGRITY) && !fileEventsInFlight {
+				sent, err := sendFileEvents(stream, fileEvents, machineID)
+				if err != nil {
+					return err
+				}
+				fileEventsInFlight = sent
+			}
+			if !streaming {
+				streaming = true
+				buffer.setConnected(true)
+				if features.has(pb.Feature_FEATURE_BACKFILL) {
+					if err := sendBackfill(stream, buffer, machineID); err != nil {
+						return err
+					}
+				}
+				if features.has(pb.Feature_FEATURE_ACTIONS) {
+					go actionRunner.deliver(ctx, stream, machineID)
+				}
+			}
+
+		case msg.GetWelcome() != nil:
+			welcome := msg.GetWelcome()
+			features = welcome.GetFeatures()
+			inventoryHash = welcome.GetInventoryHash()
+			log.Printf("Server speaks protocol %d, features: %v", welcome.GetProtocolVersion(), features)
+
+		case msg.GetBackfillAck() != nil:
+			buffer.ack(msg.GetBackfillAck().GetLastSeq())
+			if err := sendBackfill(stream, buffer, machineID); err != nil {
+				return err
+			}
+
+		case msg.GetFileEventsAck() != nil:
+			fileEvents.ack(msg.GetFileEventsAck().GetLastSeq())
+			sent, err := sendFileEvents(stream, fileEvents, machineID)
+			if err != nil {
+				return err
+			}
+			fileEventsInFlight = sent
+
+		case msg.GetCertificateRenewal() != nil:
+			log.Printf("Server requested certificate renewal")
+			key, csrPEM, err := certs.NewKey(utils.MustHostname())
+			if err != nil {
+				return fmt.Errorf("renew: generate key: %w", err)
+			}
+			pendingKey = key
+			if err := stream.Send(&pb.AgentMessage{
+				MachineId: machineID,
+				Payload: &pb.AgentMessage_CertificateSigningRequest{
+					CertificateSigningRequest: &pb.CertificateSigningRequest{CsrPem: csrPEM},
+				},
+			}); err != nil {
+				return fmt.Errorf("send csr: %w", err)
+			}
+
+		case msg.GetIssuedCertificate() != nil:
+			issued := msg.GetIssuedCertificate()
+			if pendingKey == nil {
+				log.Printf("Ignoring unsolicited certificate")
+				continue
+			}
+			if err := store.Save(pendingKey, issued.GetCertificatePem(), issued.GetCaCertificatePem(), ""); err != nil {
+				// Keep using the current certificate; the server retries on the next connect
+				logging.Warnf("Failed to store renewed certificate: %v", err)
+			} else {
+				log.Printf("Certificate renewed (expires %s)", time.Unix(issued.GetExpiresAt(), 0).UTC().Format(time.RFC3339))
+			}
+			pendingKey = nil
+
+		case msg.GetAgentToken() != nil:
+			// The server keeps accepting the old token until the new one is
+			// presented, so a failed write only delays the rotation.
+			if err := store.SaveToken(msg.GetAgentToken().GetToken()); err != nil {
+				logging.Warnf("Failed to store rotated agent token: %v", err)
+			} else {
+				log.Printf("Agent token rotated")
+			}
+
+		case msg.GetAgentUpdate() != nil:
+			updater.Handle(msg.GetAgentUpdate())
+
+		case msg.GetAgentConfig() != nil:
+			if err := stream.Send(&pb.AgentMessage{
+				MachineId: machineID,
+				Payload:   &pb.AgentMessage_ConfigApplied{ConfigApplied: settings.apply(msg.GetAgentConfig())},
+			}); err != nil {
+				return fmt.Errorf("send config applied: %w", err)
+			}
+
+		case msg.GetActionRequest() != nil:
+			actionRunner.handle(msg.GetActionRequest())
+
+		case msg.GetFileTransfer() != nil:
+			chunk, status := transfers.Start(msg.GetFileTransfer())
+			if err := sendTransfer(stream, machineID, chunk, status); err != nil {
+				return err
+			}
+
+		case msg.GetFileChunk() != nil:
+			if err := sendTransfer(stream, machineID, nil, transfers.Receive(msg.GetFileChunk())); err != nil {
+				return err
+			}
+
+		case msg.GetFileTransferStatus() != nil:
+			chunk, status := transfers.Acknowledged(msg.GetFileTransferStatus())
+			if err := sendTransfer(stream, machineID, chunk, status); err != nil {
+				return err
+			}
 		}
 	}
 }
 
+// lockedStream lets the action results be sent from another goroutine;
+// gRPC does not allow concurrent Sends on a stream.
+type lockedStream struct {
+	pb.AgentService_ConnectClient
+	mu sync.Mutex
+}
+
+func (s *lockedStream) Send(msg *pb.AgentMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.AgentService_ConnectClient.Send(msg)
+}
+
+// sendTransfer sends the chunk or status of a file transfer, if any.
+func sendTransfer(stream pb.AgentService_ConnectClient, machineID string, chunk *pb.FileChunk, status *pb.FileTransferStatus) error {
+	msg := &pb.AgentMessage{MachineId: machineID}
+	switch {
+	case chunk != nil:
+		msg.Payload = &pb.AgentMessage_FileChunk{FileChunk: chunk}
+	case status != nil:
+		msg.Payload = &pb.AgentMessage_FileTransferStatus{FileTransferStatus: status}
+	default:
+		return nil
+	}
+	if err := stream.Send(msg); err != nil {
+		return fmt.Errorf("send file transfer: %w", err)
+	}
+	return nil
+}
+
+// sendBackfill sends the next batch of buffered samples, if any.
+func sendBackfill(stream pb.AgentService_ConnectClient, buffer *telemetryBuffer, machineID string) error {
+	msg := buffer.nextBatch(machineID)
+	if msg == nil {
+		return nil
+	}
+	log.Printf("Sending %d buffered samples", len(msg.GetBackfill().GetSamples()))
+	if err := stream.Send(msg); err != nil {
+		return fmt.Errorf("send backfill: %w", err)
+	}
+	return nil
+}
+
+// sendFileEvents sends the next batch of queued file events, if any, and
+// reports whether it did.
+func sendFileEvents(stream pb.AgentService_ConnectClient, fileEvents *fileIntegrity, machineID string) (bool, error) {
+	msg := fileEvents.nextBatch(machineID)
+	if msg == nil {
+		return false, nil
+	}
+	if err := stream.Send(msg); err != nil {
+		return false, fmt.Errorf("send file events: %w", err)
+	}
+	return true, nil
+}
+
 // metricsToProto converts map[string]interface{} (int64, float64, string) to proto MetricValue map.
 func metricsToProto(raw map[string]interface{}) map[string]*pb.MetricValue {
 	out := make(map[string]*pb.MetricValue, len(raw))
@@ -3,6 +3,7 @@ package metrics
 import (
 	"os"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 )
@@ -15,26 +16,51 @@ const (
 	KeyDiskTotalGb = "disk_total_gb"
 )
 
+// Collector names accepted in the agent config.
+const (
+	CollectorCPU    = "cpu"
+	CollectorMemory = "memory"
+	CollectorDisk   = "disk"
+)
+
+// Collectors lists every collector.
+var Collectors = []string{CollectorCPU, CollectorMemory, CollectorDisk}
+
+// ValidCollector reports whether name is a known collector.
+func ValidCollector(name string) bool {
+	return slices.Contains(Collectors, name)
+}
+
 // Collect returns only canonical metrics: cpu_load, mem_usage_mb, disk_used_gb, disk_total_gb (all float64).
-func Collect() map[string]interface{} {
+// enabled selects the collectors to run; nil or empty runs all of them.
+func Collect(enabled []string) map[string]interface{} {
 	m := make(map[string]interface{})
+	on := func(name string) bool {
+		return len(enabled) == 0 || slices.Contains(enabled, name)
+	}
 
 	// cpu_load: 1-min load average (Linux) or 0
-	if load1, _, _ := readLoadAvg(); load1 >= 0 {
-		m[KeyCpuLoad] = load1
-	} else {
-		m[KeyCpuLoad] = 0.0
+	if on(CollectorCPU) {
+		if load1, _, _ := readLoadAvg(); load1 >= 0 {
+			m[KeyCpuLoad] = load1
+		} else {
+			m[KeyCpuLoad] = 0.0
+		}
 	}
 
 	// mem_usage_mb: process memory in MB (e.g. Sys)
-	var mem runtime.MemStats
-	runtime.ReadMemStats(&mem)
-	m[KeyMemUsageMb] = float64(mem.Sys) / (1024 * 1024)
+	if on(CollectorMemory) {
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+		m[KeyMemUsageMb] = float64(mem.Sys) / (1024 * 1024)
+	}
 
 	// disk_used_gb, disk_total_gb: root filesystem in GB
-	usedGb, totalGb := readRootDiskGB()
-	m[KeyDiskUsedGb] = usedGb
-	m[KeyDiskTotalGb] = totalGb
+	if on(CollectorDisk) {
+		usedGb, totalGb := readRootDiskGB()
+		m[KeyDiskUsedGb] = usedGb
+		m[KeyDiskTotalGb] = totalGb
+	}
 
 	return m
 }
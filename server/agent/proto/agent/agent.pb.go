@@ -21,32 +21,2680 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
+// Feature is an optional part of the protocol. Each side lists the features
+// it implements; only those both list are used on the stream. Unknown values
+// from a newer peer are ignored.
+type Feature int32
+
+const (
+	Feature_FEATURE_UNSPECIFIED         Feature = 0
+	Feature_FEATURE_CERTIFICATE_RENEWAL Feature = 1
+	Feature_FEATURE_TOKEN_ROTATION      Feature = 2
+	Feature_FEATURE_SELF_UPDATE         Feature = 3
+	Feature_FEATURE_BACKFILL            Feature = 4
+	Feature_FEATURE_INVENTORY           Feature = 5
+	Feature_FEATURE_FILE_INTEGRITY      Feature = 6
+	Feature_FEATURE_UNITS               Feature = 7
+	Feature_FEATURE_ACTIONS             Feature = 8
+	Feature_FEATURE_FILE_TRANSFER       Feature = 9
+	Feature_FEATURE_CONFIG              Feature = 10
+)
+
+// Enum value maps for Feature.
+var (
+	Feature_name = map[int32]string{
+		0:  "FEATURE_UNSPECIFIED",
+		1:  "FEATURE_CERTIFICATE_RENEWAL",
+		2:  "FEATURE_TOKEN_ROTATION",
+		3:  "FEATURE_SELF_UPDATE",
+		4:  "FEATURE_BACKFILL",
+		5:  "FEATURE_INVENTORY",
+		6:  "FEATURE_FILE_INTEGRITY",
+		7:  "FEATURE_UNITS",
+		8:  "FEATURE_ACTIONS",
+		9:  "FEATURE_FILE_TRANSFER",
+		10: "FEATURE_CONFIG",
+	}
+	Feature_value = map[string]int32{
+		"FEATURE_UNSPECIFIED":         0,
+		"FEATURE_CERTIFICATE_RENEWAL": 1,
+		"FEATURE_TOKEN_ROTATION":      2,
+		"FEATURE_SELF_UPDATE":         3,
+		"FEATURE_BACKFILL":            4,
+		"FEATURE_INVENTORY":           5,
+		"FEATURE_FILE_INTEGRITY":      6,
+		"FEATURE_UNITS":               7,
+		"FEATURE_ACTIONS":             8,
+		"FEATURE_FILE_TRANSFER":       9,
+		"FEATURE_CONFIG":              10,
+	}
+)
+
+func (x Feature) Enum() *Feature {
+	p := new(Feature)
+	*p = x
+	return p
+}
+
+func (x Feature) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Feature) Descriptor() protoreflect.EnumDescriptor {
+	return file_agent_proto_enumTypes[0].Descriptor()
+}
+
+func (Feature) Type() protoreflect.EnumType {
+	return &file_agent_proto_enumTypes[0]
+}
+
+func (x Feature) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Feature.Descriptor instead.
+func (Feature) EnumDescriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{0}
+}
+
+type FileChange int32
+
+const (
+	FileChange_FILE_CHANGE_UNSPECIFIED FileChange = 0
+	FileChange_FILE_CHANGE_ADDED       FileChange = 1
+	FileChange_FILE_CHANGE_REMOVED     FileChange = 2
+	FileChange_FILE_CHANGE_MODIFIED    FileChange = 3 // content, permissions, owner or link target
+)
+
+// Enum value maps for FileChange.
+var (
+	FileChange_name = map[int32]string{
+		0: "FILE_CHANGE_UNSPECIFIED",
+		1: "FILE_CHANGE_ADDED",
+		2: "FILE_CHANGE_REMOVED",
+		3: "FILE_CHANGE_MODIFIED",
+	}
+	FileChange_value = map[string]int32{
+		"FILE_CHANGE_UNSPECIFIED": 0,
+		"FILE_CHANGE_ADDED":       1,
+		"FILE_CHANGE_REMOVED":     2,
+		"FILE_CHANGE_MODIFIED":    3,
+	}
+)
+
+func (x FileChange) Enum() *FileChange {
+	p := new(FileChange)
+	*p = x
+	return p
+}
+
+func (x FileChange) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (FileChange) Descriptor() protoreflect.EnumDescriptor {
+	return file_agent_proto_enumTypes[1].Descriptor()
+}
+
+func (FileChange) Type() protoreflect.EnumType {
+	return &file_agent_proto_enumTypes[1]
+}
+
+func (x FileChange) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use FileChange.Descriptor instead.
+func (FileChange) EnumDescriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{1}
+}
+
+// FileDirection is which way a file is copied.
+type FileDirection int32
+
+const (
+	FileDirection_FILE_DIRECTION_UNSPECIFIED FileDirection = 0
+	FileDirection_FILE_DIRECTION_TO_AGENT    FileDirection = 1 // the server writes a file on the machine
+	FileDirection_FILE_DIRECTION_FROM_AGENT  FileDirection = 2 // the server reads a file from the machine
+)
+
+// Enum value maps for FileDirection.
+var (
+	FileDirection_name = map[int32]string{
+		0: "FILE_DIRECTION_UNSPECIFIED",
+		1: "FILE_DIRECTION_TO_AGENT",
+		2: "FILE_DIRECTION_FROM_AGENT",
+	}
+	FileDirection_value = map[string]int32{
+		"FILE_DIRECTION_UNSPECIFIED": 0,
+		"FILE_DIRECTION_TO_AGENT":    1,
+		"FILE_DIRECTION_FROM_AGENT":  2,
+	}
+)
+
+func (x FileDirection) Enum() *FileDirection {
+	p := new(FileDirection)
+	*p = x
+	return p
+}
+
+func (x FileDirection) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (FileDirection) Descriptor() protoreflect.EnumDescriptor {
+	return file_agent_proto_enumTypes[2].Descriptor()
+}
+
+func (FileDirection) Type() protoreflect.EnumType {
+	return &file_agent_proto_enumTypes[2]
+}
+
+func (x FileDirection) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use FileDirection.Descriptor instead.
+func (FileDirection) EnumDescriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{2}
+}
+
 type AgentMessage struct {
-	state     protoimpl.MessageState `protogen:"open.v1"`
-	MachineId string                 `protobuf:"bytes,1,opt,name=machine_id,json=machineId,proto3" json:"machine_id,omitempty"`
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Informational only; the server takes the machine identity from the
+	// client certificate and rejects a mismatching machine_id.
+	MachineId string `protobuf:"bytes,1,opt,name=machine_id,json=machineId,proto3" json:"machine_id,omitempty"`
 	// Types that are valid to be assigned to Payload:
 	//
 	//	*AgentMessage_HeartbeatPong
+	//	*AgentMessage_CertificateSigningRequest
+	//	*AgentMessage_Backfill
+	//	*AgentMessage_Hello
+	//	*AgentMessage_Inventory
+	//	*AgentMessage_FileEvents
+	//	*AgentMessage_Units
+	//	*AgentMessage_ActionResult
+	//	*AgentMessage_FileChunk
+	//	*AgentMessage_FileTransferStatus
+	//	*AgentMessage_ConfigApplied
 	Payload       isAgentMessage_Payload `protobuf_oneof:"payload"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *AgentMessage) Reset() {
-	*x = AgentMessage{}
-	mi := &file_agent_proto_msgTypes[0]
+func (x *AgentMessage) Reset() {
+	*x = AgentMessage{}
+	mi := &file_agent_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AgentMessage) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AgentMessage) ProtoMessage() {}
+
+func (x *AgentMessage) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AgentMessage.ProtoReflect.Descriptor instead.
+func (*AgentMessage) Descriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *AgentMessage) GetMachineId() string {
+	if x != nil {
+		return x.MachineId
+	}
+	return ""
+}
+
+func (x *AgentMessage) GetPayload() isAgentMessage_Payload {
+	if x != nil {
+		return x.Payload
+	}
+	return nil
+}
+
+func (x *AgentMessage) GetHeartbeatPong() *HeartbeatPong {
+	if x != nil {
+		if x, ok := x.Payload.(*AgentMessage_HeartbeatPong); ok {
+			return x.HeartbeatPong
+		}
+	}
+	return nil
+}
+
+func (x *AgentMessage) GetCertificateSigningRequest() *CertificateSigningRequest {
+	if x != nil {
+		if x, ok := x.Payload.(*AgentMessage_CertificateSigningRequest); ok {
+			return x.CertificateSigningRequest
+		}
+	}
+	return nil
+}
+
+func (x *AgentMessage) GetBackfill() *Backfill {
+	if x != nil {
+		if x, ok := x.Payload.(*AgentMessage_Backfill); ok {
+			return x.Backfill
+		}
+	}
+	return nil
+}
+
+func (x *AgentMessage) GetHello() *Hello {
+	if x != nil {
+		if x, ok := x.Payload.(*AgentMessage_Hello); ok {
+			return x.Hello
+		}
+	}
+	return nil
+}
+
+func (x *AgentMessage) GetInventory() *Inventory {
+	if x != nil {
+		if x, ok := x.Payload.(*AgentMessage_Inventory); ok {
+			return x.Inventory
+		}
+	}
+	return nil
+}
+
+func (x *AgentMessage) GetFileEvents() *FileEvents {
+	if x != nil {
+		if x, ok := x.Payload.(*AgentMessage_FileEvents); ok {
+			return x.FileEvents
+		}
+	}
+	return nil
+}
+
+func (x *AgentMessage) GetUnits() *UnitReport {
+	if x != nil {
+		if x, ok := x.Payload.(*AgentMessage_Units); ok {
+			return x.Units
+		}
+	}
+	return nil
+}
+
+func (x *AgentMessage) GetActionResult() *ActionResult {
+	if x != nil {
+		if x, ok := x.Payload.(*AgentMessage_ActionResult); ok {
+			return x.ActionResult
+		}
+	}
+	return nil
+}
+
+func (x *AgentMessage) GetFileChunk() *FileChunk {
+	if x != nil {
+		if x, ok := x.Payload.(*AgentMessage_FileChunk); ok {
+			return x.FileChunk
+		}
+	}
+	return nil
+}
+
+func (x *AgentMessage) GetFileTransferStatus() *FileTransferStatus {
+	if x != nil {
+		if x, ok := x.Payload.(*AgentMessage_FileTransferStatus); ok {
+			return x.FileTransferStatus
+		}
+	}
+	return nil
+}
+
+func (x *AgentMessage) GetConfigApplied() *ConfigApplied {
+	if x != nil {
+		if x, ok := x.Payload.(*AgentMessage_ConfigApplied); ok {
+			return x.ConfigApplied
+		}
+	}
+	return nil
+}
+
+type isAgentMessage_Payload interface {
+	isAgentMessage_Payload()
+}
+
+type AgentMessage_HeartbeatPong struct {
+	HeartbeatPong *HeartbeatPong `protobuf:"bytes,2,opt,name=heartbeat_pong,json=heartbeatPong,proto3,oneof"`
+}
+
+type AgentMessage_CertificateSigningRequest struct {
+	CertificateSigningRequest *CertificateSigningRequest `protobuf:"bytes,3,opt,name=certificate_signing_request,json=certificateSigningRequest,proto3,oneof"`
+}
+
+type AgentMessage_Backfill struct {
+	Backfill *Backfill `protobuf:"bytes,4,opt,name=backfill,proto3,oneof"`
+}
+
+type AgentMessage_Hello struct {
+	Hello *Hello `protobuf:"bytes,5,opt,name=hello,proto3,oneof"`
+}
+
+type AgentMessage_Inventory struct {
+	Inventory *Inventory `protobuf:"bytes,6,opt,name=inventory,proto3,oneof"`
+}
+
+type AgentMessage_FileEvents struct {
+	FileEvents *FileEvents `protobuf:"bytes,7,opt,name=file_events,json=fileEvents,proto3,oneof"`
+}
+
+type AgentMessage_Units struct {
+	Units *UnitReport `protobuf:"bytes,8,opt,name=units,proto3,oneof"`
+}
+
+type AgentMessage_ActionResult struct {
+	ActionResult *ActionResult `protobuf:"bytes,9,opt,name=action_result,json=actionResult,proto3,oneof"`
+}
+
+type AgentMessage_FileChunk struct {
+	FileChunk *FileChunk `protobuf:"bytes,10,opt,name=file_chunk,json=fileChunk,proto3,oneof"`
+}
+
+type AgentMessage_FileTransferStatus struct {
+	FileTransferStatus *FileTransferStatus `protobuf:"bytes,11,opt,name=file_transfer_status,json=fileTransferStatus,proto3,oneof"`
+}
+
+type AgentMessage_ConfigApplied struct {
+	ConfigApplied *ConfigApplied `protobuf:"bytes,12,opt,name=config_applied,json=configApplied,proto3,oneof"`
+}
+
+func (*AgentMessage_HeartbeatPong) isAgentMessage_Payload() {}
+
+func (*AgentMessage_CertificateSigningRequest) isAgentMessage_Payload() {}
+
+func (*AgentMessage_Backfill) isAgentMessage_Payload() {}
+
+func (*AgentMessage_Hello) isAgentMessage_Payload() {}
+
+func (*AgentMessage_Inventory) isAgentMessage_Payload() {}
+
+func (*AgentMessage_FileEvents) isAgentMessage_Payload() {}
+
+func (*AgentMessage_Units) isAgentMessage_Payload() {}
+
+func (*AgentMessage_ActionResult) isAgentMessage_Payload() {}
+
+func (*AgentMessage_FileChunk) isAgentMessage_Payload() {}
+
+func (*AgentMessage_FileTransferStatus) isAgentMessage_Payload() {}
+
+func (*AgentMessage_ConfigApplied) isAgentMessage_Payload() {}
+
+type ServerMessage struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Payload:
+	//
+	//	*ServerMessage_HeartbeatPing
+	//	*ServerMessage_CertificateRenewal
+	//	*ServerMessage_IssuedCertificate
+	//	*ServerMessage_AgentToken
+	//	*ServerMessage_AgentUpdate
+	//	*ServerMessage_BackfillAck
+	//	*ServerMessage_Welcome
+	//	*ServerMessage_FileEventsAck
+	//	*ServerMessage_ActionRequest
+	//	*ServerMessage_FileTransfer
+	//	*ServerMessage_FileChunk
+	//	*ServerMessage_FileTransferStatus
+	//	*ServerMessage_AgentConfig
+	Payload       isServerMessage_Payload `protobuf_oneof:"payload"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ServerMessage) Reset() {
+	*x = ServerMessage{}
+	mi := &file_agent_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ServerMessage) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ServerMessage) ProtoMessage() {}
+
+func (x *ServerMessage) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ServerMessage.ProtoReflect.Descriptor instead.
+func (*ServerMessage) Descriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ServerMessage) GetPayload() isServerMessage_Payload {
+	if x != nil {
+		return x.Payload
+	}
+	return nil
+}
+
+func (x *ServerMessage) GetHeartbeatPing() *HeartbeatPing {
+	if x != nil {
+		if x, ok := x.Payload.(*ServerMessage_HeartbeatPing); ok {
+			return x.HeartbeatPing
+		}
+	}
+	return nil
+}
+
+func (x *ServerMessage) GetCertificateRenewal() *CertificateRenewal {
+	if x != nil {
+		if x, ok := x.Payload.(*ServerMessage_CertificateRenewal); ok {
+			return x.CertificateRenewal
+		}
+	}
+	return nil
+}
+
+func (x *ServerMessage) GetIssuedCertificate() *IssuedCertificate {
+	if x != nil {
+		if x, ok := x.Payload.(*ServerMessage_IssuedCertificate); ok {
+			return x.IssuedCertificate
+		}
+	}
+	return nil
+}
+
+func (x *ServerMessage) GetAgentToken() *AgentToken {
+	if x != nil {
+		if x, ok := x.Payload.(*ServerMessage_AgentToken); ok {
+			return x.AgentToken
+		}
+	}
+	return nil
+}
+
+func (x *ServerMessage) GetAgentUpdate() *AgentUpdate {
+	if x != nil {
+		if x, ok := x.Payload.(*ServerMessage_AgentUpdate); ok {
+			return x.AgentUpdate
+		}
+	}
+	return nil
+}
+
+func (x *ServerMessage) GetBackfillAck() *BackfillAck {
+	if x != nil {
+		if x, ok := x.Payload.(*ServerMessage_BackfillAck); ok {
+			return x.BackfillAck
+		}
+	}
+	return nil
+}
+
+func (x *ServerMessage) GetWelcome() *Welcome {
+	if x != nil {
+		if x, ok := x.Payload.(*ServerMessage_Welcome); ok {
+			return x.Welcome
+		}
+	}
+	return nil
+}
+
+func (x *ServerMessage) GetFileEventsAck() *FileEventsAck {
+	if x != nil {
+		if x, ok := x.Payload.(*ServerMessage_FileEventsAck); ok {
+			return x.FileEventsAck
+		}
+	}
+	return nil
+}
+
+func (x *ServerMessage) GetActionRequest() *ActionRequest {
+	if x != nil {
+		if x, ok := x.Payload.(*ServerMessage_ActionRequest); ok {
+			return x.ActionRequest
+		}
+	}
+	return nil
+}
+
+func (x *ServerMessage) GetFileTransfer() *FileTransfer {
+	if x != nil {
+		if x, ok := x.Payload.(*ServerMessage_FileTransfer); ok {
+			return x.FileTransfer
+		}
+	}
+	return nil
+}
+
+func (x *ServerMessage) GetFileChunk() *FileChunk {
+	if x != nil {
+		if x, ok := x.Payload.(*ServerMessage_FileChunk); ok {
+			return x.FileChunk
+		}
+	}
+	return nil
+}
+
+func (x *ServerMessage) GetFileTransferStatus() *FileTransferStatus {
+	if x != nil {
+		if x, ok := x.Payload.(*ServerMessage_FileTransferStatus); ok {
+			return x.FileTransferStatus
+		}
+	}
+	return nil
+}
+
+func (x *ServerMessage) GetAgentConfig() *AgentConfig {
+	if x != nil {
+		if x, ok := x.Payload.(*ServerMessage_AgentConfig); ok {
+			return x.AgentConfig
+		}
+	}
+	return nil
+}
+
+type isServerMessage_Payload interface {
+	isServerMessage_Payload()
+}
+
+type ServerMessage_HeartbeatPing struct {
+	HeartbeatPing *HeartbeatPing `protobuf:"bytes,1,opt,name=heartbeat_ping,json=heartbeatPing,proto3,oneof"`
+}
+
+type ServerMessage_CertificateRenewal struct {
+	CertificateRenewal *CertificateRenewal `protobuf:"bytes,2,opt,name=certificate_renewal,json=certificateRenewal,proto3,oneof"`
+}
+
+type ServerMessage_IssuedCertificate struct {
+	IssuedCertificate *IssuedCertificate `protobuf:"bytes,3,opt,name=issued_certificate,json=issuedCertificate,proto3,oneof"`
+}
+
+type ServerMessage_AgentToken struct {
+	AgentToken *AgentToken `protobuf:"bytes,4,opt,name=agent_token,json=agentToken,proto3,oneof"`
+}
+
+type ServerMessage_AgentUpdate struct {
+	AgentUpdate *AgentUpdate `protobuf:"bytes,5,opt,name=agent_update,json=agentUpdate,proto3,oneof"`
+}
+
+type ServerMessage_BackfillAck struct {
+	BackfillAck *BackfillAck `protobuf:"bytes,6,opt,name=backfill_ack,json=backfillAck,proto3,oneof"`
+}
+
+type ServerMessage_Welcome struct {
+	Welcome *Welcome `protobuf:"bytes,7,opt,name=welcome,proto3,oneof"`
+}
+
+type ServerMessage_FileEventsAck struct {
+	FileEventsAck *FileEventsAck `protobuf:"bytes,8,opt,name=file_events_ack,json=fileEventsAck,proto3,oneof"`
+}
+
+type ServerMessage_ActionRequest struct {
+	ActionRequest *ActionRequest `protobuf:"bytes,9,opt,name=action_request,json=actionRequest,proto3,oneof"`
+}
+
+type ServerMessage_FileTransfer struct {
+	FileTransfer *FileTransfer `protobuf:"bytes,10,opt,name=file_transfer,json=fileTransfer,proto3,oneof"`
+}
+
+type ServerMessage_FileChunk struct {
+	FileChunk *FileChunk `protobuf:"bytes,11,opt,name=file_chunk,json=fileChunk,proto3,oneof"`
+}
+
+type ServerMessage_FileTransferStatus struct {
+	FileTransferStatus *FileTransferStatus `protobuf:"bytes,12,opt,name=file_transfer_status,json=fileTransferStatus,proto3,oneof"`
+}
+
+type ServerMessage_AgentConfig struct {
+	AgentConfig *AgentConfig `protobuf:"bytes,13,opt,name=agent_config,json=agentConfig,proto3,oneof"`
+}
+
+func (*ServerMessage_HeartbeatPing) isServerMessage_Payload() {}
+
+func (*ServerMessage_CertificateRenewal) isServerMessage_Payload() {}
+
+func (*ServerMessage_IssuedCertificate) isServerMessage_Payload() {}
+
+func (*ServerMessage_AgentToken) isServerMessage_Payload() {}
+
+func (*ServerMessage_AgentUpdate) isServerMessage_Payload() {}
+
+func (*ServerMessage_BackfillAck) isServerMessage_Payload() {}
+
+func (*ServerMessage_Welcome) isServerMessage_Payload() {}
+
+func (*ServerMessage_FileEventsAck) isServerMessage_Payload() {}
+
+func (*ServerMessage_ActionRequest) isServerMessage_Payload() {}
+
+func (*ServerMessage_FileTransfer) isServerMessage_Payload() {}
+
+func (*ServerMessage_FileChunk) isServerMessage_Payload() {}
+
+func (*ServerMessage_FileTransferStatus) isServerMessage_Payload() {}
+
+func (*ServerMessage_AgentConfig) isServerMessage_Payload() {}
+
+// Hello is the first message of every stream. It reports what the agent is
+// and where it runs, so the server's view stays current across upgrades,
+// address changes and reboots. Agents predating Hello send an empty first
+// message and are assumed to implement the features of protocol version 0.
+type Hello struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	AgentVersion    string                 `protobuf:"bytes,1,opt,name=agent_version,json=agentVersion,proto3" json:"agent_version,omitempty"`
+	ProtocolVersion uint32                 `protobuf:"varint,2,opt,name=protocol_version,json=protocolVersion,proto3" json:"protocol_version,omitempty"`
+	Features        []Feature              `protobuf:"varint,3,rep,packed,name=features,proto3,enum=agent.Feature" json:"features,omitempty"`
+	Os              string                 `protobuf:"bytes,4,opt,name=os,proto3" json:"os,omitempty"`                                // GOOS
+	Arch            string                 `protobuf:"bytes,5,opt,name=arch,proto3" json:"arch,omitempty"`                            // GOARCH
+	OsRelease       string                 `protobuf:"bytes,6,opt,name=os_release,json=osRelease,proto3" json:"os_release,omitempty"` // e.g. "Ubuntu 24.04.1 LTS"
+	Kernel          string                 `protobuf:"bytes,7,opt,name=kernel,proto3" json:"kernel,omitempty"`                        // kernel release, e.g. "6.8.0-45-generic"
+	UptimeSeconds   int64                  `protobuf:"varint,8,opt,name=uptime_seconds,json=uptimeSeconds,proto3" json:"uptime_seconds,omitempty"`
+	IpAddresses     []string               `protobuf:"bytes,9,rep,name=ip_addresses,json=ipAddresses,proto3" json:"ip_addresses,omitempty"` // non-loopback addresses, preferred first
+	BootId          string                 `protobuf:"bytes,10,opt,name=boot_id,json=bootId,proto3" json:"boot_id,omitempty"`               // changes on every boot; empty if unknown
+	Hostname        string                 `protobuf:"bytes,11,opt,name=hostname,proto3" json:"hostname,omitempty"`
+	Actions         []string               `protobuf:"bytes,12,rep,name=actions,proto3" json:"actions,omitempty"` // actions the agent's policy enables, e.g. "service-restart"
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *Hello) Reset() {
+	*x = Hello{}
+	mi := &file_agent_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Hello) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Hello) ProtoMessage() {}
+
+func (x *Hello) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Hello.ProtoReflect.Descriptor instead.
+func (*Hello) Descriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Hello) GetAgentVersion() string {
+	if x != nil {
+		return x.AgentVersion
+	}
+	return ""
+}
+
+func (x *Hello) GetProtocolVersion() uint32 {
+	if x != nil {
+		return x.ProtocolVersion
+	}
+	return 0
+}
+
+func (x *Hello) GetFeatures() []Feature {
+	if x != nil {
+		return x.Features
+	}
+	return nil
+}
+
+func (x *Hello) GetOs() string {
+	if x != nil {
+		return x.Os
+	}
+	return ""
+}
+
+func (x *Hello) GetArch() string {
+	if x != nil {
+		return x.Arch
+	}
+	return ""
+}
+
+func (x *Hello) GetOsRelease() string {
+	if x != nil {
+		return x.OsRelease
+	}
+	return ""
+}
+
+func (x *Hello) GetKernel() string {
+	if x != nil {
+		return x.Kernel
+	}
+	return ""
+}
+
+func (x *Hello) GetUptimeSeconds() int64 {
+	if x != nil {
+		return x.UptimeSeconds
+	}
+	return 0
+}
+
+func (x *Hello) GetIpAddresses() []string {
+	if x != nil {
+		return x.IpAddresses
+	}
+	return nil
+}
+
+func (x *Hello) GetBootId() string {
+	if x != nil {
+		return x.BootId
+	}
+	return ""
+}
+
+func (x *Hello) GetHostname() string {
+	if x != nil {
+		return x.Hostname
+	}
+	return ""
+}
+
+func (x *Hello) GetActions() []string {
+	if x != nil {
+		return x.Actions
+	}
+	return nil
+}
+
+// Welcome answers a Hello with the protocol version and features the server
+// will use on this stream.
+type Welcome struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	ProtocolVersion uint32                 `protobuf:"varint,1,opt,name=protocol_version,json=protocolVersion,proto3" json:"protocol_version,omitempty"`
+	Features        []Feature              `protobuf:"varint,2,rep,packed,name=features,proto3,enum=agent.Feature" json:"features,omitempty"`
+	InventoryHash   string                 `protobuf:"bytes,3,opt,name=inventory_hash,json=inventoryHash,proto3" json:"inventory_hash,omitempty"` // hash of the stored Inventory; empty if none
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *Welcome) Reset() {
+	*x = Welcome{}
+	mi := &file_agent_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Welcome) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Welcome) ProtoMessage() {}
+
+func (x *Welcome) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Welcome.ProtoReflect.Descriptor instead.
+func (*Welcome) Descriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *Welcome) GetProtocolVersion() uint32 {
+	if x != nil {
+		return x.ProtocolVersion
+	}
+	return 0
+}
+
+func (x *Welcome) GetFeatures() []Feature {
+	if x != nil {
+		return x.Features
+	}
+	return nil
+}
+
+func (x *Welcome) GetInventoryHash() string {
+	if x != nil {
+		return x.InventoryHash
+	}
+	return ""
+}
+
+// Inventory describes the software and hardware of the machine. The agent
+// collects it periodically but only sends it when its hash differs from the
+// last one sent on the stream or, after connecting, from the hash in the
+// Welcome. It is not acknowledged; a lost inventory is sent again on the
+// next connection.
+type Inventory struct {
+	state             protoimpl.MessageState `protogen:"open.v1"`
+	Hash              string                 `protobuf:"bytes,1,opt,name=hash,proto3" json:"hash,omitempty"`                                   // hex SHA-256 of the inventory with hash and collected_at unset
+	CollectedAt       int64                  `protobuf:"varint,2,opt,name=collected_at,json=collectedAt,proto3" json:"collected_at,omitempty"` // unix seconds
+	OsRelease         string                 `protobuf:"bytes,3,opt,name=os_release,json=osRelease,proto3" json:"os_release,omitempty"`
+	Kernel            string                 `protobuf:"bytes,4,opt,name=kernel,proto3" json:"kernel,omitempty"`
+	Hardware          *Hardware              `protobuf:"bytes,5,opt,name=hardware,proto3" json:"hardware,omitempty"`
+	Packages          []*Package             `protobuf:"bytes,6,rep,name=packages,proto3" json:"packages,omitempty"` // sorted by name, then arch
+	NetworkInterfaces []*NetworkInterface    `protobuf:"bytes,7,rep,name=network_interfaces,json=networkInterfaces,proto3" json:"network_interfaces,omitempty"`
+	BlockDevices      []*BlockDevice         `protobuf:"bytes,8,rep,name=block_devices,json=blockDevices,proto3" json:"block_devices,omitempty"`
+	OsId              string                 `protobuf:"bytes,9,opt,name=os_id,json=osId,proto3" json:"os_id,omitempty"`                         // os-release ID, e.g. "debian", "ubuntu", "alpine", "rocky"
+	OsVersionId       string                 `protobuf:"bytes,10,opt,name=os_version_id,json=osVersionId,proto3" json:"os_version_id,omitempty"` // os-release VERSION_ID, e.g. "12", "22.04", "3.19.1"
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
+}
+
+func (x *Inventory) Reset() {
+	*x = Inventory{}
+	mi := &file_agent_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Inventory) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Inventory) ProtoMessage() {}
+
+func (x *Inventory) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Inventory.ProtoReflect.Descriptor instead.
+func (*Inventory) Descriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Inventory) GetHash() string {
+	if x != nil {
+		return x.Hash
+	}
+	return ""
+}
+
+func (x *Inventory) GetCollectedAt() int64 {
+	if x != nil {
+		return x.CollectedAt
+	}
+	return 0
+}
+
+func (x *Inventory) GetOsRelease() string {
+	if x != nil {
+		return x.OsRelease
+	}
+	return ""
+}
+
+func (x *Inventory) GetKernel() string {
+	if x != nil {
+		return x.Kernel
+	}
+	return ""
+}
+
+func (x *Inventory) GetHardware() *Hardware {
+	if x != nil {
+		return x.Hardware
+	}
+	return nil
+}
+
+func (x *Inventory) GetPackages() []*Package {
+	if x != nil {
+		return x.Packages
+	}
+	return nil
+}
+
+func (x *Inventory) GetNetworkInterfaces() []*NetworkInterface {
+	if x != nil {
+		return x.NetworkInterfaces
+	}
+	return nil
+}
+
+func (x *Inventory) GetBlockDevices() []*BlockDevice {
+	if x != nil {
+		return x.BlockDevices
+	}
+	return nil
+}
+
+func (x *Inventory) GetOsId() string {
+	if x != nil {
+		return x.OsId
+	}
+	return ""
+}
+
+func (x *Inventory) GetOsVersionId() string {
+	if x != nil {
+		return x.OsVersionId
+	}
+	return ""
+}
+
+// Package is an installed package as recorded by the system package manager.
+type Package struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Version       string                 `protobuf:"bytes,2,opt,name=version,proto3" json:"version,omitempty"` // as the package manager writes it, e.g. "1:3.0.2-0ubuntu1.15"
+	Arch          string                 `protobuf:"bytes,3,opt,name=arch,proto3" json:"arch,omitempty"`
+	Format        string                 `protobuf:"bytes,4,opt,name=format,proto3" json:"format,omitempty"` // "deb", "rpm" or "apk"
+	Source        string                 `protobuf:"bytes,5,opt,name=source,proto3" json:"source,omitempty"` // source package it was built from, when the name differs
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Package) Reset() {
+	*x = Package{}
+	mi := &file_agent_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Package) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Package) ProtoMessage() {}
+
+func (x *Package) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Package.ProtoReflect.Descriptor instead.
+func (*Package) Descriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *Package) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Package) GetVersion() string {
+	if x != nil {
+		return x.Version
+	}
+	return ""
+}
+
+func (x *Package) GetArch() string {
+	if x != nil {
+		return x.Arch
+	}
+	return ""
+}
+
+func (x *Package) GetFormat() string {
+	if x != nil {
+		return x.Format
+	}
+	return ""
+}
+
+func (x *Package) GetSource() string {
+	if x != nil {
+		return x.Source
+	}
+	return ""
+}
+
+// Hardware holds the CPU, memory and DMI system information. DMI fields are
+// empty where the firmware does not provide them (e.g. most ARM boards).
+type Hardware struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	CpuModel       string                 `protobuf:"bytes,1,opt,name=cpu_model,json=cpuModel,proto3" json:"cpu_model,omitempty"`
+	CpuCount       uint32                 `protobuf:"varint,2,opt,name=cpu_count,json=cpuCount,proto3" json:"cpu_count,omitempty"` // logical CPUs
+	MemoryBytes    uint64                 `protobuf:"varint,3,opt,name=memory_bytes,json=memoryBytes,proto3" json:"memory_bytes,omitempty"`
+	SystemVendor   string                 `protobuf:"bytes,4,opt,name=system_vendor,json=systemVendor,proto3" json:"system_vendor,omitempty"`
+	ProductName    string                 `protobuf:"bytes,5,opt,name=product_name,json=productName,proto3" json:"product_name,omitempty"`
+	ProductVersion string                 `protobuf:"bytes,6,opt,name=product_version,json=productVersion,proto3" json:"product_version,omitempty"`
+	BiosVendor     string                 `protobuf:"bytes,7,opt,name=bios_vendor,json=biosVendor,proto3" json:"bios_vendor,omitempty"`
+	BiosVersion    string                 `protobuf:"bytes,8,opt,name=bios_version,json=biosVersion,proto3" json:"bios_version,omitempty"`
+	BoardVendor    string                 `protobuf:"bytes,9,opt,name=board_vendor,json=boardVendor,proto3" json:"board_vendor,omitempty"`
+	BoardName      string                 `protobuf:"bytes,10,opt,name=board_name,json=boardName,proto3" json:"board_name,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *Hardware) Reset() {
+	*x = Hardware{}
+	mi := &file_agent_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Hardware) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Hardware) ProtoMessage() {}
+
+func (x *Hardware) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Hardware.ProtoReflect.Descriptor instead.
+func (*Hardware) Descriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *Hardware) GetCpuModel() string {
+	if x != nil {
+		return x.CpuModel
+	}
+	return ""
+}
+
+func (x *Hardware) GetCpuCount() uint32 {
+	if x != nil {
+		return x.CpuCount
+	}
+	return 0
+}
+
+func (x *Hardware) GetMemoryBytes() uint64 {
+	if x != nil {
+		return x.MemoryBytes
+	}
+	return 0
+}
+
+func (x *Hardware) GetSystemVendor() string {
+	if x != nil {
+		return x.SystemVendor
+	}
+	return ""
+}
+
+func (x *Hardware) GetProductName() string {
+	if x != nil {
+		return x.ProductName
+	}
+	return ""
+}
+
+func (x *Hardware) GetProductVersion() string {
+	if x != nil {
+		return x.ProductVersion
+	}
+	return ""
+}
+
+func (x *Hardware) GetBiosVendor() string {
+	if x != nil {
+		return x.BiosVendor
+	}
+	return ""
+}
+
+func (x *Hardware) GetBiosVersion() string {
+	if x != nil {
+		return x.BiosVersion
+	}
+	return ""
+}
+
+func (x *Hardware) GetBoardVendor() string {
+	if x != nil {
+		return x.BoardVendor
+	}
+	return ""
+}
+
+func (x *Hardware) GetBoardName() string {
+	if x != nil {
+		return x.BoardName
+	}
+	return ""
+}
+
+type NetworkInterface struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Mac           string                 `protobuf:"bytes,2,opt,name=mac,proto3" json:"mac,omitempty"`
+	Mtu           uint32                 `protobuf:"varint,3,opt,name=mtu,proto3" json:"mtu,omitempty"`
+	Addresses     []string               `protobuf:"bytes,4,rep,name=addresses,proto3" json:"addresses,omitempty"` // CIDR notation
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *NetworkInterface) Reset() {
+	*x = NetworkInterface{}
+	mi := &file_agent_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *NetworkInterface) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*NetworkInterface) ProtoMessage() {}
+
+func (x *NetworkInterface) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use NetworkInterface.ProtoReflect.Descriptor instead.
+func (*NetworkInterface) Descriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *NetworkInterface) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *NetworkInterface) GetMac() string {
+	if x != nil {
+		return x.Mac
+	}
+	return ""
+}
+
+func (x *NetworkInterface) GetMtu() uint32 {
+	if x != nil {
+		return x.Mtu
+	}
+	return 0
+}
+
+func (x *NetworkInterface) GetAddresses() []string {
+	if x != nil {
+		return x.Addresses
+	}
+	return nil
+}
+
+type BlockDevice struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"` // e.g. "sda", "nvme0n1"
+	SizeBytes     uint64                 `protobuf:"varint,2,opt,name=size_bytes,json=sizeBytes,proto3" json:"size_bytes,omitempty"`
+	Model         string                 `protobuf:"bytes,3,opt,name=model,proto3" json:"model,omitempty"`
+	Rotational    bool                   `protobuf:"varint,4,opt,name=rotational,proto3" json:"rotational,omitempty"`
+	Removable     bool                   `protobuf:"varint,5,opt,name=removable,proto3" json:"removable,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BlockDevice) Reset() {
+	*x = BlockDevice{}
+	mi := &file_agent_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BlockDevice) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BlockDevice) ProtoMessage() {}
+
+func (x *BlockDevice) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BlockDevice.ProtoReflect.Descriptor instead.
+func (*BlockDevice) Descriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *BlockDevice) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *BlockDevice) GetSizeBytes() uint64 {
+	if x != nil {
+		return x.SizeBytes
+	}
+	return 0
+}
+
+func (x *BlockDevice) GetModel() string {
+	if x != nil {
+		return x.Model
+	}
+	return ""
+}
+
+func (x *BlockDevice) GetRotational() bool {
+	if x != nil {
+		return x.Rotational
+	}
+	return false
+}
+
+func (x *BlockDevice) GetRemovable() bool {
+	if x != nil {
+		return x.Removable
+	}
+	return false
+}
+
+// CertificateRenewal asks the agent for a CSR because its client certificate
+// is close to expiry. Sent right after the stream is opened.
+type CertificateRenewal struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ExpiresAt     int64                  `protobuf:"varint,1,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CertificateRenewal) Reset() {
+	*x = CertificateRenewal{}
+	mi := &file_agent_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CertificateRenewal) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CertificateRenewal) ProtoMessage() {}
+
+func (x *CertificateRenewal) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CertificateRenewal.ProtoReflect.Descriptor instead.
+func (*CertificateRenewal) Descriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *CertificateRenewal) GetExpiresAt() int64 {
+	if x != nil {
+		return x.ExpiresAt
+	}
+	return 0
+}
+
+// CertificateSigningRequest answers a CertificateRenewal with a PEM-encoded
+// CSR for a freshly generated key.
+type CertificateSigningRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	CsrPem        []byte                 `protobuf:"bytes,1,opt,name=csr_pem,json=csrPem,proto3" json:"csr_pem,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CertificateSigningRequest) Reset() {
+	*x = CertificateSigningRequest{}
+	mi := &file_agent_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CertificateSigningRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CertificateSigningRequest) ProtoMessage() {}
+
+func (x *CertificateSigningRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CertificateSigningRequest.ProtoReflect.Descriptor instead.
+func (*CertificateSigningRequest) Descriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *CertificateSigningRequest) GetCsrPem() []byte {
+	if x != nil {
+		return x.CsrPem
+	}
+	return nil
+}
+
+// IssuedCertificate carries the renewed client certificate; the agent uses it
+// from its next connection on.
+type IssuedCertificate struct {
+	state            protoimpl.MessageState `protogen:"open.v1"`
+	CertificatePem   []byte                 `protobuf:"bytes,1,opt,name=certificate_pem,json=certificatePem,proto3" json:"certificate_pem,omitempty"`
+	CaCertificatePem []byte                 `protobuf:"bytes,2,opt,name=ca_certificate_pem,json=caCertificatePem,proto3" json:"ca_certificate_pem,omitempty"`
+	ExpiresAt        int64                  `protobuf:"varint,3,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at,omitempty"`
+	unknownFields    protoimpl.UnknownFields
+	sizeCache        protoimpl.SizeCache
+}
+
+func (x *IssuedCertificate) Reset() {
+	*x = IssuedCertificate{}
+	mi := &file_agent_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *IssuedCertificate) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*IssuedCertificate) ProtoMessage() {}
+
+func (x *IssuedCertificate) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use IssuedCertificate.ProtoReflect.Descriptor instead.
+func (*IssuedCertificate) Descriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *IssuedCertificate) GetCertificatePem() []byte {
+	if x != nil {
+		return x.CertificatePem
+	}
+	return nil
+}
+
+func (x *IssuedCertificate) GetCaCertificatePem() []byte {
+	if x != nil {
+		return x.CaCertificatePem
+	}
+	return nil
+}
+
+func (x *IssuedCertificate) GetExpiresAt() int64 {
+	if x != nil {
+		return x.ExpiresAt
+	}
+	return 0
+}
+
+type HeartbeatPing struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Timestamp     int64                  `protobuf:"varint,1,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *HeartbeatPing) Reset() {
+	*x = HeartbeatPing{}
+	mi := &file_agent_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *HeartbeatPing) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HeartbeatPing) ProtoMessage() {}
+
+func (x *HeartbeatPing) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HeartbeatPing.ProtoReflect.Descriptor instead.
+func (*HeartbeatPing) Descriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *HeartbeatPing) GetTimestamp() int64 {
+	if x != nil {
+		return x.Timestamp
+	}
+	return 0
+}
+
+// MetricValue holds a single metric as an integer, float, or string.
+type MetricValue struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Kind:
+	//
+	//	*MetricValue_I
+	//	*MetricValue_F
+	//	*MetricValue_S
+	Kind          isMetricValue_Kind `protobuf_oneof:"kind"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MetricValue) Reset() {
+	*x = MetricValue{}
+	mi := &file_agent_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MetricValue) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MetricValue) ProtoMessage() {}
+
+func (x *MetricValue) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MetricValue.ProtoReflect.Descriptor instead.
+func (*MetricValue) Descriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *MetricValue) GetKind() isMetricValue_Kind {
+	if x != nil {
+		return x.Kind
+	}
+	return nil
+}
+
+func (x *MetricValue) GetI() int64 {
+	if x != nil {
+		if x, ok := x.Kind.(*MetricValue_I); ok {
+			return x.I
+		}
+	}
+	return 0
+}
+
+func (x *MetricValue) GetF() float64 {
+	if x != nil {
+		if x, ok := x.Kind.(*MetricValue_F); ok {
+			return x.F
+		}
+	}
+	return 0
+}
+
+func (x *MetricValue) GetS() string {
+	if x != nil {
+		if x, ok := x.Kind.(*MetricValue_S); ok {
+			return x.S
+		}
+	}
+	return ""
+}
+
+type isMetricValue_Kind interface {
+	isMetricValue_Kind()
+}
+
+type MetricValue_I struct {
+	I int64 `protobuf:"varint,1,opt,name=i,proto3,oneof"`
+}
+
+type MetricValue_F struct {
+	F float64 `protobuf:"fixed64,2,opt,name=f,proto3,oneof"`
+}
+
+type MetricValue_S struct {
+	S string `protobuf:"bytes,3,opt,name=s,proto3,oneof"`
+}
+
+func (*MetricValue_I) isMetricValue_Kind() {}
+
+func (*MetricValue_F) isMetricValue_Kind() {}
+
+func (*MetricValue_S) isMetricValue_Kind() {}
+
+type HeartbeatPong struct {
+	state         protoimpl.MessageState  `protogen:"open.v1"`
+	Status        string                  `protobuf:"bytes,1,opt,name=status,proto3" json:"status,omitempty"`
+	Metrics       map[string]*MetricValue `protobuf:"bytes,2,rep,name=metrics,proto3" json:"metrics,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Timestamp     int64                   `protobuf:"varint,3,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *HeartbeatPong) Reset() {
+	*x = HeartbeatPong{}
+	mi := &file_agent_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *HeartbeatPong) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HeartbeatPong) ProtoMessage() {}
+
+func (x *HeartbeatPong) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HeartbeatPong.ProtoReflect.Descriptor instead.
+func (*HeartbeatPong) Descriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *HeartbeatPong) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *HeartbeatPong) GetMetrics() map[string]*MetricValue {
+	if x != nil {
+		return x.Metrics
+	}
+	return nil
+}
+
+func (x *HeartbeatPong) GetTimestamp() int64 {
+	if x != nil {
+		return x.Timestamp
+	}
+	return 0
+}
+
+// AgentToken replaces the agent token. The previous token keeps working until
+// the agent connects with the new one.
+type AgentToken struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Token         string                 `protobuf:"bytes,1,opt,name=token,proto3" json:"token,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AgentToken) Reset() {
+	*x = AgentToken{}
+	mi := &file_agent_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AgentToken) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AgentToken) ProtoMessage() {}
+
+func (x *AgentToken) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AgentToken.ProtoReflect.Descriptor instead.
+func (*AgentToken) Descriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *AgentToken) GetToken() string {
+	if x != nil {
+		return x.Token
+	}
+	return ""
+}
+
+// AgentUpdate tells the agent which version it should run. Sent on connect and
+// whenever a rollout changes. An agent already running that version ignores
+// it; otherwise it downloads the binary from the API, checks its SHA-256 and
+// ed25519 signature and replaces itself, rolling back if the new version fails
+// to connect.
+type AgentUpdate struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Version       string                 `protobuf:"bytes,1,opt,name=version,proto3" json:"version,omitempty"`
+	Sha256        string                 `protobuf:"bytes,2,opt,name=sha256,proto3" json:"sha256,omitempty"`
+	Size          int64                  `protobuf:"varint,3,opt,name=size,proto3" json:"size,omitempty"`
+	DownloadPath  string                 `protobuf:"bytes,4,opt,name=download_path,json=downloadPath,proto3" json:"download_path,omitempty"` // relative to the API base URL
+	Signature     []byte                 `protobuf:"bytes,5,opt,name=signature,proto3" json:"signature,omitempty"`                           // detached ed25519 signature of the binary
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AgentUpdate) Reset() {
+	*x = AgentUpdate{}
+	mi := &file_agent_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AgentUpdate) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AgentUpdate) ProtoMessage() {}
+
+func (x *AgentUpdate) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AgentUpdate.ProtoReflect.Descriptor instead.
+func (*AgentUpdate) Descriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *AgentUpdate) GetVersion() string {
+	if x != nil {
+		return x.Version
+	}
+	return ""
+}
+
+func (x *AgentUpdate) GetSha256() string {
+	if x != nil {
+		return x.Sha256
+	}
+	return ""
+}
+
+func (x *AgentUpdate) GetSize() int64 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+func (x *AgentUpdate) GetDownloadPath() string {
+	if x != nil {
+		return x.DownloadPath
+	}
+	return ""
+}
+
+func (x *AgentUpdate) GetSignature() []byte {
+	if x != nil {
+		return x.Signature
+	}
+	return nil
+}
+
+// Sample is a reading the agent took on its own while it was disconnected.
+type Sample struct {
+	state         protoimpl.MessageState  `protogen:"open.v1"`
+	Seq           uint64                  `protobuf:"varint,1,opt,name=seq,proto3" json:"seq,omitempty"`             // position in the agent's buffer
+	Timestamp     int64                   `protobuf:"varint,2,opt,name=timestamp,proto3" json:"timestamp,omitempty"` // unix seconds when the sample was taken
+	Status        string                  `protobuf:"bytes,3,opt,name=status,proto3" json:"status,omitempty"`
+	Metrics       map[string]*MetricValue `protobuf:"bytes,4,rep,name=metrics,proto3" json:"metrics,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Sample) Reset() {
+	*x = Sample{}
+	mi := &file_agent_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Sample) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Sample) ProtoMessage() {}
+
+func (x *Sample) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Sample.ProtoReflect.Descriptor instead.
+func (*Sample) Descriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *Sample) GetSeq() uint64 {
+	if x != nil {
+		return x.Seq
+	}
+	return 0
+}
+
+func (x *Sample) GetTimestamp() int64 {
+	if x != nil {
+		return x.Timestamp
+	}
+	return 0
+}
+
+func (x *Sample) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *Sample) GetMetrics() map[string]*MetricValue {
+	if x != nil {
+		return x.Metrics
+	}
+	return nil
+}
+
+// Backfill replays buffered samples, oldest first, after the agent
+// reconnects. The server stores them as machine snapshots with their original
+// timestamps and answers with a BackfillAck; only then does the agent drop
+// them from its buffer and send the next batch. A batch that is not
+// acknowledged is sent again on the next connection, so storing it must be
+// idempotent.
+type Backfill struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Samples       []*Sample              `protobuf:"bytes,1,rep,name=samples,proto3" json:"samples,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Backfill) Reset() {
+	*x = Backfill{}
+	mi := &file_agent_proto_msgTypes[18]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Backfill) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Backfill) ProtoMessage() {}
+
+func (x *Backfill) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_proto_msgTypes[18]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Backfill.ProtoReflect.Descriptor instead.
+func (*Backfill) Descriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *Backfill) GetSamples() []*Sample {
+	if x != nil {
+		return x.Samples
+	}
+	return nil
+}
+
+// BackfillAck confirms that all samples up to last_seq are stored.
+type BackfillAck struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	LastSeq       uint64                 `protobuf:"varint,1,opt,name=last_seq,json=lastSeq,proto3" json:"last_seq,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BackfillAck) Reset() {
+	*x = BackfillAck{}
+	mi := &file_agent_proto_msgTypes[19]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BackfillAck) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BackfillAck) ProtoMessage() {}
+
+func (x *BackfillAck) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_proto_msgTypes[19]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BackfillAck.ProtoReflect.Descriptor instead.
+func (*BackfillAck) Descriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{19}
+}
+
+func (x *BackfillAck) GetLastSeq() uint64 {
+	if x != nil {
+		return x.LastSeq
+	}
+	return 0
+}
+
+// FileEvents reports changes to watched files, oldest first. Like a
+// Backfill, the events stay queued on the agent until a FileEventsAck covers
+// them, so storing them must be idempotent.
+type FileEvents struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Events        []*FileEvent           `protobuf:"bytes,1,rep,name=events,proto3" json:"events,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FileEvents) Reset() {
+	*x = FileEvents{}
+	mi := &file_agent_proto_msgTypes[20]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FileEvents) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FileEvents) ProtoMessage() {}
+
+func (x *FileEvents) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_proto_msgTypes[20]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FileEvents.ProtoReflect.Descriptor instead.
+func (*FileEvents) Descriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{20}
+}
+
+func (x *FileEvents) GetEvents() []*FileEvent {
+	if x != nil {
+		return x.Events
+	}
+	return nil
+}
+
+// FileEventsAck confirms that all file events up to last_seq are stored.
+type FileEventsAck struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	LastSeq       uint64                 `protobuf:"varint,1,opt,name=last_seq,json=lastSeq,proto3" json:"last_seq,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FileEventsAck) Reset() {
+	*x = FileEventsAck{}
+	mi := &file_agent_proto_msgTypes[21]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FileEventsAck) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FileEventsAck) ProtoMessage() {}
+
+func (x *FileEventsAck) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_proto_msgTypes[21]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FileEventsAck.ProtoReflect.Descriptor instead.
+func (*FileEventsAck) Descriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{21}
+}
+
+func (x *FileEventsAck) GetLastSeq() uint64 {
+	if x != nil {
+		return x.LastSeq
+	}
+	return 0
+}
+
+// FileEvent is a difference between a watched file and its baseline.
+type FileEvent struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Seq           uint64                 `protobuf:"varint,1,opt,name=seq,proto3" json:"seq,omitempty"` // position in the agent's event queue
+	Change        FileChange             `protobuf:"varint,2,opt,name=change,proto3,enum=agent.FileChange" json:"change,omitempty"`
+	Path          string                 `protobuf:"bytes,3,opt,name=path,proto3" json:"path,omitempty"`
+	DetectedAt    int64                  `protobuf:"varint,4,opt,name=detected_at,json=detectedAt,proto3" json:"detected_at,omitempty"` // unix seconds
+	Before        *FileState             `protobuf:"bytes,5,opt,name=before,proto3" json:"before,omitempty"`                            // unset for FILE_CHANGE_ADDED
+	After         *FileState             `protobuf:"bytes,6,opt,name=after,proto3" json:"after,omitempty"`                              // unset for FILE_CHANGE_REMOVED
+	Realtime      bool                   `protobuf:"varint,7,opt,name=realtime,proto3" json:"realtime,omitempty"`                       // seen by the file system watcher rather than a scan
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FileEvent) Reset() {
+	*x = FileEvent{}
+	mi := &file_agent_proto_msgTypes[22]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FileEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FileEvent) ProtoMessage() {}
+
+func (x *FileEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_proto_msgTypes[22]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FileEvent.ProtoReflect.Descriptor instead.
+func (*FileEvent) Descriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{22}
+}
+
+func (x *FileEvent) GetSeq() uint64 {
+	if x != nil {
+		return x.Seq
+	}
+	return 0
+}
+
+func (x *FileEvent) GetChange() FileChange {
+	if x != nil {
+		return x.Change
+	}
+	return FileChange_FILE_CHANGE_UNSPECIFIED
+}
+
+func (x *FileEvent) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *FileEvent) GetDetectedAt() int64 {
+	if x != nil {
+		return x.DetectedAt
+	}
+	return 0
+}
+
+func (x *FileEvent) GetBefore() *FileState {
+	if x != nil {
+		return x.Before
+	}
+	return nil
+}
+
+func (x *FileEvent) GetAfter() *FileState {
+	if x != nil {
+		return x.After
+	}
+	return nil
+}
+
+func (x *FileEvent) GetRealtime() bool {
+	if x != nil {
+		return x.Realtime
+	}
+	return false
+}
+
+// FileState is what the baseline records about a file.
+type FileState struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Sha256        string                 `protobuf:"bytes,1,opt,name=sha256,proto3" json:"sha256,omitempty"` // hex; empty for directories, links and files over the size limit
+	Mode          uint32                 `protobuf:"varint,2,opt,name=mode,proto3" json:"mode,omitempty"`    // permission and type bits, as in stat(2)
+	Uid           uint32                 `protobuf:"varint,3,opt,name=uid,proto3" json:"uid,omitempty"`
+	Gid           uint32                 `protobuf:"varint,4,opt,name=gid,proto3" json:"gid,omitempty"`
+	Owner         string                 `protobuf:"bytes,5,opt,name=owner,proto3" json:"owner,omitempty"`
+	Group         string                 `protobuf:"bytes,6,opt,name=group,proto3" json:"group,omitempty"`
+	Size          int64                  `protobuf:"varint,7,opt,name=size,proto3" json:"size,omitempty"`
+	Mtime         int64                  `protobuf:"varint,8,opt,name=mtime,proto3" json:"mtime,omitempty"`                            // unix seconds
+	LinkTarget    string                 `protobuf:"bytes,9,opt,name=link_target,json=linkTarget,proto3" json:"link_target,omitempty"` // for symbolic links
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FileState) Reset() {
+	*x = FileState{}
+	mi := &file_agent_proto_msgTypes[23]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FileState) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FileState) ProtoMessage() {}
+
+func (x *FileState) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_proto_msgTypes[23]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FileState.ProtoReflect.Descriptor instead.
+func (*FileState) Descriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{23}
+}
+
+func (x *FileState) GetSha256() string {
+	if x != nil {
+		return x.Sha256
+	}
+	return ""
+}
+
+func (x *FileState) GetMode() uint32 {
+	if x != nil {
+		return x.Mode
+	}
+	return 0
+}
+
+func (x *FileState) GetUid() uint32 {
+	if x != nil {
+		return x.Uid
+	}
+	return 0
+}
+
+func (x *FileState) GetGid() uint32 {
+	if x != nil {
+		return x.Gid
+	}
+	return 0
+}
+
+func (x *FileState) GetOwner() string {
+	if x != nil {
+		return x.Owner
+	}
+	return ""
+}
+
+func (x *FileState) GetGroup() string {
+	if x != nil {
+		return x.Group
+	}
+	return ""
+}
+
+func (x *FileState) GetSize() int64 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+func (x *FileState) GetMtime() int64 {
+	if x != nil {
+		return x.Mtime
+	}
+	return 0
+}
+
+func (x *FileState) GetLinkTarget() string {
+	if x != nil {
+		return x.LinkTarget
+	}
+	return ""
+}
+
+// UnitReport is the state of the systemd units the agent watches: the
+// configured ones and every failed unit. It is sent when it changes.
+type UnitReport struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Hash          string                 `protobuf:"bytes,1,opt,name=hash,proto3" json:"hash,omitempty"`                                   // hex SHA-256 of the report with hash and collected_at unset
+	CollectedAt   int64                  `protobuf:"varint,2,opt,name=collected_at,json=collectedAt,proto3" json:"collected_at,omitempty"` // unix seconds
+	Systemd       bool                   `protobuf:"varint,3,opt,name=systemd,proto3" json:"systemd,omitempty"`                            // false when the host does not run systemd; units is empty
+	Units         []*Unit                `protobuf:"bytes,4,rep,name=units,proto3" json:"units,omitempty"`                                 // sorted by name
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UnitReport) Reset() {
+	*x = UnitReport{}
+	mi := &file_agent_proto_msgTypes[24]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UnitReport) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UnitReport) ProtoMessage() {}
+
+func (x *UnitReport) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_proto_msgTypes[24]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UnitReport.ProtoReflect.Descriptor instead.
+func (*UnitReport) Descriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{24}
+}
+
+func (x *UnitReport) GetHash() string {
+	if x != nil {
+		return x.Hash
+	}
+	return ""
+}
+
+func (x *UnitReport) GetCollectedAt() int64 {
+	if x != nil {
+		return x.CollectedAt
+	}
+	return 0
+}
+
+func (x *UnitReport) GetSystemd() bool {
+	if x != nil {
+		return x.Systemd
+	}
+	return false
+}
+
+func (x *UnitReport) GetUnits() []*Unit {
+	if x != nil {
+		return x.Units
+	}
+	return nil
+}
+
+// Unit is the state of one systemd unit, as shown by systemctl show.
+type Unit struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	Name           string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"` // e.g. "nginx.service"
+	Description    string                 `protobuf:"bytes,2,opt,name=description,proto3" json:"description,omitempty"`
+	LoadState      string                 `protobuf:"bytes,3,opt,name=load_state,json=loadState,proto3" json:"load_state,omitempty"`                   // loaded, not-found, masked, ...
+	ActiveState    string                 `protobuf:"bytes,4,opt,name=active_state,json=activeState,proto3" json:"active_state,omitempty"`             // active, inactive, failed, activating, ...
+	SubState       string                 `protobuf:"bytes,5,opt,name=sub_state,json=subState,proto3" json:"sub_state,omitempty"`                      // running, exited, dead, auto-restart, ...
+	Result         string                 `protobuf:"bytes,6,opt,name=result,proto3" json:"result,omitempty"`                                          // success, exit-code, signal, timeout, ...
+	Restarts       uint32                 `protobuf:"varint,7,opt,name=restarts,proto3" json:"restarts,omitempty"`                                     // automatic restarts since the unit was started
+	StateChangedAt int64                  `protobuf:"varint,8,opt,name=state_changed_at,json=stateChangedAt,proto3" json:"state_changed_at,omitempty"` // unix seconds of the last active state change
+	MainPid        uint32                 `protobuf:"varint,9,opt,name=main_pid,json=mainPid,proto3" json:"main_pid,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *Unit) Reset() {
+	*x = Unit{}
+	mi := &file_agent_proto_msgTypes[25]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Unit) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Unit) ProtoMessage() {}
+
+func (x *Unit) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_proto_msgTypes[25]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Unit.ProtoReflect.Descriptor instead.
+func (*Unit) Descriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{25}
+}
+
+func (x *Unit) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Unit) GetDescription() string {
+	if x != nil {
+		return x.Description
+	}
+	return ""
+}
+
+func (x *Unit) GetLoadState() string {
+	if x != nil {
+		return x.LoadState
+	}
+	return ""
+}
+
+func (x *Unit) GetActiveState() string {
+	if x != nil {
+		return x.ActiveState
+	}
+	return ""
+}
+
+func (x *Unit) GetSubState() string {
+	if x != nil {
+		return x.SubState
+	}
+	return ""
+}
+
+func (x *Unit) GetResult() string {
+	if x != nil {
+		return x.Result
+	}
+	return ""
+}
+
+func (x *Unit) GetRestarts() uint32 {
+	if x != nil {
+		return x.Restarts
+	}
+	return 0
+}
+
+func (x *Unit) GetStateChangedAt() int64 {
+	if x != nil {
+		return x.StateChangedAt
+	}
+	return 0
+}
+
+func (x *Unit) GetMainPid() uint32 {
+	if x != nil {
+		return x.MainPid
+	}
+	return 0
+}
+
+// ActionRequest asks the agent to carry out a typed action:
+//
+//	service-start, service-stop, service-restart  params: unit
+//	reboot                                        params: delay_seconds
+//	run-script                                    params: script; args
+//
+// The agent only runs actions its policy enables and answers each request
+// with an ActionResult. A request is resent after a reconnect until its
+// result arrives; the agent answers a request it already ran with the same
+// result instead of running it again.
+type ActionRequest struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	Id             string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"` // command ID
+	Action         string                 `protobuf:"bytes,2,opt,name=action,proto3" json:"action,omitempty"`
+	Params         map[string]string      `protobuf:"bytes,3,rep,name=params,proto3" json:"params,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Args           []string               `protobuf:"bytes,4,rep,name=args,proto3" json:"args,omitempty"`
+	TimeoutSeconds uint32                 `protobuf:"varint,5,opt,name=timeout_seconds,json=timeoutSeconds,proto3" json:"timeout_seconds,omitempty"` // 0 for the agent's default
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *ActionRequest) Reset() {
+	*x = ActionRequest{}
+	mi := &file_agent_proto_msgTypes[26]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ActionRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ActionRequest) ProtoMessage() {}
+
+func (x *ActionRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_proto_msgTypes[26]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ActionRequest.ProtoReflect.Descriptor instead.
+func (*ActionRequest) Descriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{26}
+}
+
+func (x *ActionRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *ActionRequest) GetAction() string {
+	if x != nil {
+		return x.Action
+	}
+	return ""
+}
+
+func (x *ActionRequest) GetParams() map[string]string {
+	if x != nil {
+		return x.Params
+	}
+	return nil
+}
+
+func (x *ActionRequest) GetArgs() []string {
+	if x != nil {
+		return x.Args
+	}
+	return nil
+}
+
+func (x *ActionRequest) GetTimeoutSeconds() uint32 {
+	if x != nil {
+		return x.TimeoutSeconds
+	}
+	return 0
+}
+
+// ActionResult is the outcome of an ActionRequest.
+type ActionResult struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Success       bool                   `protobuf:"varint,2,opt,name=success,proto3" json:"success,omitempty"`
+	ExitCode      int32                  `protobuf:"varint,3,opt,name=exit_code,json=exitCode,proto3" json:"exit_code,omitempty"`
+	Output        string                 `protobuf:"bytes,4,opt,name=output,proto3" json:"output,omitempty"`                         // combined stdout and stderr, truncated to 64 KiB
+	Error         string                 `protobuf:"bytes,5,opt,name=error,proto3" json:"error,omitempty"`                           // why the action did not succeed
+	StartedAt     int64                  `protobuf:"varint,6,opt,name=started_at,json=startedAt,proto3" json:"started_at,omitempty"` // unix seconds
+	FinishedAt    int64                  `protobuf:"varint,7,opt,name=finished_at,json=finishedAt,proto3" json:"finished_at,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ActionResult) Reset() {
+	*x = ActionResult{}
+	mi := &file_agent_proto_msgTypes[27]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ActionResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ActionResult) ProtoMessage() {}
+
+func (x *ActionResult) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_proto_msgTypes[27]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ActionResult.ProtoReflect.Descriptor instead.
+func (*ActionResult) Descriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{27}
+}
+
+func (x *ActionResult) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *ActionResult) GetSuccess() bool {
+	if x != nil {
+		return x.Success
+	}
+	return false
+}
+
+func (x *ActionResult) GetExitCode() int32 {
+	if x != nil {
+		return x.ExitCode
+	}
+	return 0
+}
+
+func (x *ActionResult) GetOutput() string {
+	if x != nil {
+		return x.Output
+	}
+	return ""
+}
+
+func (x *ActionResult) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *ActionResult) GetStartedAt() int64 {
+	if x != nil {
+		return x.StartedAt
+	}
+	return 0
+}
+
+func (x *ActionResult) GetFinishedAt() int64 {
+	if x != nil {
+		return x.FinishedAt
+	}
+	return 0
+}
+
+// FileTransfer starts, or after a reconnect resumes, copying a file. Only
+// paths the agent's policy allows for the direction can be copied.
+//
+// To the agent: the agent answers with a FileTransferStatus giving the
+// offset it already holds (0 for a new transfer), the server sends a
+// FileChunk from there and each status acknowledges a chunk and asks for the
+// next. After the last chunk the agent checks size and SHA-256, puts the file
+// in place and reports done.
+//
+// From the agent: the agent sends FileChunks from offset, one per
+// FileTransferStatus the server acknowledges it with; the last chunk carries
+// the SHA-256 of the whole file.
+//
+// Either side ends a transfer by sending a FileTransferStatus with an error.
+type FileTransfer struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Direction     FileDirection          `protobuf:"varint,2,opt,name=direction,proto3,enum=agent.FileDirection" json:"direction,omitempty"`
+	Path          string                 `protobuf:"bytes,3,opt,name=path,proto3" json:"path,omitempty"`                             // absolute path on the machine
+	ChunkSize     uint32                 `protobuf:"varint,4,opt,name=chunk_size,json=chunkSize,proto3" json:"chunk_size,omitempty"` // every chunk but the last has this size
+	MaxSize       int64                  `protobuf:"varint,5,opt,name=max_size,json=maxSize,proto3" json:"max_size,omitempty"`       // from the agent: larger files are refused
+	Offset        int64                  `protobuf:"varint,6,opt,name=offset,proto3" json:"offset,omitempty"`                        // from the agent: bytes the server already holds
+	Size          int64                  `protobuf:"varint,7,opt,name=size,proto3" json:"size,omitempty"`                            // to the agent
+	Sha256        string                 `protobuf:"bytes,8,opt,name=sha256,proto3" json:"sha256,omitempty"`                         // to the agent, hex
+	Mode          uint32                 `protobuf:"varint,9,opt,name=mode,proto3" json:"mode,omitempty"`                            // to the agent: permission bits; 0 keeps those of the file replaced, else 0644
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FileTransfer) Reset() {
+	*x = FileTransfer{}
+	mi := &file_agent_proto_msgTypes[28]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *AgentMessage) String() string {
+func (x *FileTransfer) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*AgentMessage) ProtoMessage() {}
+func (*FileTransfer) ProtoMessage() {}
 
-func (x *AgentMessage) ProtoReflect() protoreflect.Message {
-	mi := &file_agent_proto_msgTypes[0]
+func (x *FileTransfer) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_proto_msgTypes[28]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -57,69 +2705,102 @@ func (x *AgentMessage) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use AgentMessage.ProtoReflect.Descriptor instead.
-func (*AgentMessage) Descriptor() ([]byte, []int) {
-	return file_agent_proto_rawDescGZIP(), []int{0}
+// Deprecated: Use FileTransfer.ProtoReflect.Descriptor instead.
+func (*FileTransfer) Descriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{28}
 }
 
-func (x *AgentMessage) GetMachineId() string {
+func (x *FileTransfer) GetId() string {
 	if x != nil {
-		return x.MachineId
+		return x.Id
 	}
 	return ""
 }
 
-func (x *AgentMessage) GetPayload() isAgentMessage_Payload {
+func (x *FileTransfer) GetDirection() FileDirection {
 	if x != nil {
-		return x.Payload
+		return x.Direction
 	}
-	return nil
+	return FileDirection_FILE_DIRECTION_UNSPECIFIED
 }
 
-func (x *AgentMessage) GetHeartbeatPong() *HeartbeatPong {
+func (x *FileTransfer) GetPath() string {
 	if x != nil {
-		if x, ok := x.Payload.(*AgentMessage_HeartbeatPong); ok {
-			return x.HeartbeatPong
-		}
+		return x.Path
 	}
-	return nil
+	return ""
 }
 
-type isAgentMessage_Payload interface {
-	isAgentMessage_Payload()
+func (x *FileTransfer) GetChunkSize() uint32 {
+	if x != nil {
+		return x.ChunkSize
+	}
+	return 0
 }
 
-type AgentMessage_HeartbeatPong struct {
-	HeartbeatPong *HeartbeatPong `protobuf:"bytes,2,opt,name=heartbeat_pong,json=heartbeatPong,proto3,oneof"`
+func (x *FileTransfer) GetMaxSize() int64 {
+	if x != nil {
+		return x.MaxSize
+	}
+	return 0
 }
 
-func (*AgentMessage_HeartbeatPong) isAgentMessage_Payload() {}
+func (x *FileTransfer) GetOffset() int64 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
 
-type ServerMessage struct {
-	state protoimpl.MessageState `protogen:"open.v1"`
-	// Types that are valid to be assigned to Payload:
-	//
-	//	*ServerMessage_HeartbeatPing
-	Payload       isServerMessage_Payload `protobuf_oneof:"payload"`
+func (x *FileTransfer) GetSize() int64 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+func (x *FileTransfer) GetSha256() string {
+	if x != nil {
+		return x.Sha256
+	}
+	return ""
+}
+
+func (x *FileTransfer) GetMode() uint32 {
+	if x != nil {
+		return x.Mode
+	}
+	return 0
+}
+
+// FileChunk is part of a file, starting at offset.
+type FileChunk struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Offset        int64                  `protobuf:"varint,2,opt,name=offset,proto3" json:"offset,omitempty"`
+	Data          []byte                 `protobuf:"bytes,3,opt,name=data,proto3" json:"data,omitempty"`
+	Eof           bool                   `protobuf:"varint,4,opt,name=eof,proto3" json:"eof,omitempty"`      // last chunk
+	Size          int64                  `protobuf:"varint,5,opt,name=size,proto3" json:"size,omitempty"`    // from the agent: size of the file being sent
+	Sha256        string                 `protobuf:"bytes,6,opt,name=sha256,proto3" json:"sha256,omitempty"` // from the agent, with the last chunk
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *ServerMessage) Reset() {
-	*x = ServerMessage{}
-	mi := &file_agent_proto_msgTypes[1]
+func (x *FileChunk) Reset() {
+	*x = FileChunk{}
+	mi := &file_agent_proto_msgTypes[29]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *ServerMessage) String() string {
+func (x *FileChunk) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*ServerMessage) ProtoMessage() {}
+func (*FileChunk) ProtoMessage() {}
 
-func (x *ServerMessage) ProtoReflect() protoreflect.Message {
-	mi := &file_agent_proto_msgTypes[1]
+func (x *FileChunk) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_proto_msgTypes[29]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -130,59 +2811,79 @@ func (x *ServerMessage) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use ServerMessage.ProtoReflect.Descriptor instead.
-func (*ServerMessage) Descriptor() ([]byte, []int) {
-	return file_agent_proto_rawDescGZIP(), []int{1}
+// Deprecated: Use FileChunk.ProtoReflect.Descriptor instead.
+func (*FileChunk) Descriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{29}
 }
 
-func (x *ServerMessage) GetPayload() isServerMessage_Payload {
+func (x *FileChunk) GetId() string {
 	if x != nil {
-		return x.Payload
+		return x.Id
 	}
-	return nil
+	return ""
 }
 
-func (x *ServerMessage) GetHeartbeatPing() *HeartbeatPing {
+func (x *FileChunk) GetOffset() int64 {
 	if x != nil {
-		if x, ok := x.Payload.(*ServerMessage_HeartbeatPing); ok {
-			return x.HeartbeatPing
-		}
+		return x.Offset
+	}
+	return 0
+}
+
+func (x *FileChunk) GetData() []byte {
+	if x != nil {
+		return x.Data
 	}
 	return nil
 }
 
-type isServerMessage_Payload interface {
-	isServerMessage_Payload()
+func (x *FileChunk) GetEof() bool {
+	if x != nil {
+		return x.Eof
+	}
+	return false
 }
 
-type ServerMessage_HeartbeatPing struct {
-	HeartbeatPing *HeartbeatPing `protobuf:"bytes,1,opt,name=heartbeat_ping,json=heartbeatPing,proto3,oneof"`
+func (x *FileChunk) GetSize() int64 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
 }
 
-func (*ServerMessage_HeartbeatPing) isServerMessage_Payload() {}
+func (x *FileChunk) GetSha256() string {
+	if x != nil {
+		return x.Sha256
+	}
+	return ""
+}
 
-type HeartbeatPing struct {
+// FileTransferStatus acknowledges the chunks received so far.
+type FileTransferStatus struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	Timestamp     int64                  `protobuf:"varint,1,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Offset        int64                  `protobuf:"varint,2,opt,name=offset,proto3" json:"offset,omitempty"` // bytes received and stored
+	Done          bool                   `protobuf:"varint,3,opt,name=done,proto3" json:"done,omitempty"`     // the file is complete (and, on the agent, in place)
+	Error         string                 `protobuf:"bytes,4,opt,name=error,proto3" json:"error,omitempty"`    // the transfer failed and is abandoned
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *HeartbeatPing) Reset() {
-	*x = HeartbeatPing{}
-	mi := &file_agent_proto_msgTypes[2]
+func (x *FileTransferStatus) Reset() {
+	*x = FileTransferStatus{}
+	mi := &file_agent_proto_msgTypes[30]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *HeartbeatPing) String() string {
+func (x *FileTransferStatus) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*HeartbeatPing) ProtoMessage() {}
+func (*FileTransferStatus) ProtoMessage() {}
 
-func (x *HeartbeatPing) ProtoReflect() protoreflect.Message {
-	mi := &file_agent_proto_msgTypes[2]
+func (x *FileTransferStatus) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_proto_msgTypes[30]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -193,46 +2894,72 @@ func (x *HeartbeatPing) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use HeartbeatPing.ProtoReflect.Descriptor instead.
-func (*HeartbeatPing) Descriptor() ([]byte, []int) {
-	return file_agent_proto_rawDescGZIP(), []int{2}
+// Deprecated: Use FileTransferStatus.ProtoReflect.Descriptor instead.
+func (*FileTransferStatus) Descriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{30}
 }
 
-func (x *HeartbeatPing) GetTimestamp() int64 {
+func (x *FileTransferStatus) GetId() string {
 	if x != nil {
-		return x.Timestamp
+		return x.Id
+	}
+	return ""
+}
+
+func (x *FileTransferStatus) GetOffset() int64 {
+	if x != nil {
+		return x.Offset
 	}
 	return 0
 }
 
-// MetricValue holds a single metric as an integer, float, or string.
-type MetricValue struct {
-	state protoimpl.MessageState `protogen:"open.v1"`
-	// Types that are valid to be assigned to Kind:
-	//
-	//	*MetricValue_I
-	//	*MetricValue_F
-	//	*MetricValue_S
-	Kind          isMetricValue_Kind `protobuf_oneof:"kind"`
-	unknownFields protoimpl.UnknownFields
-	sizeCache     protoimpl.SizeCache
+func (x *FileTransferStatus) GetDone() bool {
+	if x != nil {
+		return x.Done
+	}
+	return false
 }
 
-func (x *MetricValue) Reset() {
-	*x = MetricValue{}
-	mi := &file_agent_proto_msgTypes[3]
+func (x *FileTransferStatus) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+// AgentConfig is the configuration the server wants the agent to run with.
+// It replaces the previous one as a whole: unset fields go back to the
+// agent's local setting. The server sends it on connect and whenever it
+// changes; the agent applies it without restarting and answers with
+// ConfigApplied.
+type AgentConfig struct {
+	state                protoimpl.MessageState `protogen:"open.v1"`
+	Version              string                 `protobuf:"bytes,1,opt,name=version,proto3" json:"version,omitempty"`                                                                       // identifies the content; equal versions are equal configs
+	LogLevel             string                 `protobuf:"bytes,2,opt,name=log_level,json=logLevel,proto3" json:"log_level,omitempty"`                                                     // "debug", "info", "warn" or "error"
+	Collectors           []string               `protobuf:"bytes,3,rep,name=collectors,proto3" json:"collectors,omitempty"`                                                                 // metrics sent with heartbeats: "cpu", "memory", "disk"; empty for all
+	OfflineSampleSeconds uint32                 `protobuf:"varint,4,opt,name=offline_sample_seconds,json=offlineSampleSeconds,proto3" json:"offline_sample_seconds,omitempty"`              // metrics sampling while disconnected
+	InventorySeconds     uint32                 `protobuf:"varint,5,opt,name=inventory_seconds,json=inventorySeconds,proto3" json:"inventory_seconds,omitempty"`                            // inventory collection
+	UnitsSeconds         uint32                 `protobuf:"varint,6,opt,name=units_seconds,json=unitsSeconds,proto3" json:"units_seconds,omitempty"`                                        // unit state checks
+	Extra                map[string]string      `protobuf:"bytes,7,rep,name=extra,proto3" json:"extra,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"` // free-form, for settings newer agents understand
+	unknownFields        protoimpl.UnknownFields
+	sizeCache            protoimpl.SizeCache
+}
+
+func (x *AgentConfig) Reset() {
+	*x = AgentConfig{}
+	mi := &file_agent_proto_msgTypes[31]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *MetricValue) String() string {
+func (x *AgentConfig) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*MetricValue) ProtoMessage() {}
+func (*AgentConfig) ProtoMessage() {}
 
-func (x *MetricValue) ProtoReflect() protoreflect.Message {
-	mi := &file_agent_proto_msgTypes[3]
+func (x *AgentConfig) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_proto_msgTypes[31]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -243,91 +2970,84 @@ func (x *MetricValue) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use MetricValue.ProtoReflect.Descriptor instead.
-func (*MetricValue) Descriptor() ([]byte, []int) {
-	return file_agent_proto_rawDescGZIP(), []int{3}
+// Deprecated: Use AgentConfig.ProtoReflect.Descriptor instead.
+func (*AgentConfig) Descriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{31}
 }
 
-func (x *MetricValue) GetKind() isMetricValue_Kind {
+func (x *AgentConfig) GetVersion() string {
 	if x != nil {
-		return x.Kind
+		return x.Version
 	}
-	return nil
+	return ""
 }
 
-func (x *MetricValue) GetI() int64 {
+func (x *AgentConfig) GetLogLevel() string {
 	if x != nil {
-		if x, ok := x.Kind.(*MetricValue_I); ok {
-			return x.I
-		}
+		return x.LogLevel
 	}
-	return 0
+	return ""
 }
 
-func (x *MetricValue) GetF() float64 {
+func (x *AgentConfig) GetCollectors() []string {
 	if x != nil {
-		if x, ok := x.Kind.(*MetricValue_F); ok {
-			return x.F
-		}
+		return x.Collectors
 	}
-	return 0
+	return nil
 }
 
-func (x *MetricValue) GetS() string {
+func (x *AgentConfig) GetOfflineSampleSeconds() uint32 {
 	if x != nil {
-		if x, ok := x.Kind.(*MetricValue_S); ok {
-			return x.S
-		}
+		return x.OfflineSampleSeconds
 	}
-	return ""
-}
-
-type isMetricValue_Kind interface {
-	isMetricValue_Kind()
+	return 0
 }
 
-type MetricValue_I struct {
-	I int64 `protobuf:"varint,1,opt,name=i,proto3,oneof"`
+func (x *AgentConfig) GetInventorySeconds() uint32 {
+	if x != nil {
+		return x.InventorySeconds
+	}
+	return 0
 }
 
-type MetricValue_F struct {
-	F float64 `protobuf:"fixed64,2,opt,name=f,proto3,oneof"`
+func (x *AgentConfig) GetUnitsSeconds() uint32 {
+	if x != nil {
+		return x.UnitsSeconds
+	}
+	return 0
 }
 
-type MetricValue_S struct {
-	S string `protobuf:"bytes,3,opt,name=s,proto3,oneof"`
+func (x *AgentConfig) GetExtra() map[string]string {
+	if x != nil {
+		return x.Extra
+	}
+	return nil
 }
 
-func (*MetricValue_I) isMetricValue_Kind() {}
-
-func (*MetricValue_F) isMetricValue_Kind() {}
-
-func (*MetricValue_S) isMetricValue_Kind() {}
-
-type HeartbeatPong struct {
-	state         protoimpl.MessageState  `protogen:"open.v1"`
-	Status        string                  `protobuf:"bytes,1,opt,name=status,proto3" json:"status,omitempty"`
-	Metrics       map[string]*MetricValue `protobuf:"bytes,2,rep,name=metrics,proto3" json:"metrics,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
-	Timestamp     int64                   `protobuf:"varint,3,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+// ConfigApplied acknowledges an AgentConfig.
+type ConfigApplied struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Version       string                 `protobuf:"bytes,1,opt,name=version,proto3" json:"version,omitempty"`
+	Error         string                 `protobuf:"bytes,2,opt,name=error,proto3" json:"error,omitempty"` // set when part of the config could not be applied
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *HeartbeatPong) Reset() {
-	*x = HeartbeatPong{}
-	mi := &file_agent_proto_msgTypes[4]
+func (x *ConfigApplied) Reset() {
+	*x = ConfigApplied{}
+	mi := &file_agent_proto_msgTypes[32]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *HeartbeatPong) String() string {
+func (x *ConfigApplied) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*HeartbeatPong) ProtoMessage() {}
+func (*ConfigApplied) ProtoMessage() {}
 
-func (x *HeartbeatPong) ProtoReflect() protoreflect.Message {
-	mi := &file_agent_proto_msgTypes[4]
+func (x *ConfigApplied) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_proto_msgTypes[32]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -338,45 +3058,142 @@ func (x *HeartbeatPong) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use HeartbeatPong.ProtoReflect.Descriptor instead.
-func (*HeartbeatPong) Descriptor() ([]byte, []int) {
-	return file_agent_proto_rawDescGZIP(), []int{4}
+// Deprecated: Use ConfigApplied.ProtoReflect.Descriptor instead.
+func (*ConfigApplied) Descriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{32}
 }
 
-func (x *HeartbeatPong) GetStatus() string {
+func (x *ConfigApplied) GetVersion() string {
 	if x != nil {
-		return x.Status
+		return x.Version
 	}
 	return ""
 }
 
-func (x *HeartbeatPong) GetMetrics() map[string]*MetricValue {
-	if x != nil {
-		return x.Metrics
-	}
-	return nil
-}
-
-func (x *HeartbeatPong) GetTimestamp() int64 {
+func (x *ConfigApplied) GetError() string {
 	if x != nil {
-		return x.Timestamp
+		return x.Error
 	}
-	return 0
+	return ""
 }
 
 var File_agent_proto protoreflect.FileDescriptor
 
 const file_agent_proto_rawDesc = "" +
 	"\n" +
-	"\vagent.proto\x12\x05agent\"w\n" +
+	"\vagent.proto\x12\x05agent\"\xc0\x05\n" +
 	"\fAgentMessage\x12\x1d\n" +
 	"\n" +
 	"machine_id\x18\x01 \x01(\tR\tmachineId\x12=\n" +
-	"\x0eheartbeat_pong\x18\x02 \x01(\v2\x14.agent.HeartbeatPongH\x00R\rheartbeatPongB\t\n" +
-	"\apayload\"Y\n" +
+	"\x0eheartbeat_pong\x18\x02 \x01(\v2\x14.agent.HeartbeatPongH\x00R\rheartbeatPong\x12b\n" +
+	"\x1bcertificate_signing_request\x18\x03 \x01(\v2 .agent.CertificateSigningRequestH\x00R\x19certificateSigningRequest\x12-\n" +
+	"\bbackfill\x18\x04 \x01(\v2\x0f.agent.BackfillH\x00R\bbackfill\x12$\n" +
+	"\x05hello\x18\x05 \x01(\v2\f.agent.HelloH\x00R\x05hello\x120\n" +
+	"\tinventory\x18\x06 \x01(\v2\x10.agent.InventoryH\x00R\tinventory\x124\n" +
+	"\vfile_events\x18\a \x01(\v2\x11.agent.FileEventsH\x00R\n" +
+	"fileEvents\x12)\n" +
+	"\x05units\x18\b \x01(\v2\x11.agent.UnitReportH\x00R\x05units\x12:\n" +
+	"\raction_result\x18\t \x01(\v2\x13.agent.ActionResultH\x00R\factionResult\x121\n" +
+	"\n" +
+	"file_chunk\x18\n" +
+	" \x01(\v2\x10.agent.FileChunkH\x00R\tfileChunk\x12M\n" +
+	"\x14file_transfer_status\x18\v \x01(\v2\x19.agent.FileTransferStatusH\x00R\x12fileTransferStatus\x12=\n" +
+	"\x0econfig_applied\x18\f \x01(\v2\x14.agent.ConfigAppliedH\x00R\rconfigAppliedB\t\n" +
+	"\apayload\"\xbc\x06\n" +
 	"\rServerMessage\x12=\n" +
-	"\x0eheartbeat_ping\x18\x01 \x01(\v2\x14.agent.HeartbeatPingH\x00R\rheartbeatPingB\t\n" +
-	"\apayload\"-\n" +
+	"\x0eheartbeat_ping\x18\x01 \x01(\v2\x14.agent.HeartbeatPingH\x00R\rheartbeatPing\x12L\n" +
+	"\x13certificate_renewal\x18\x02 \x01(\v2\x19.agent.CertificateRenewalH\x00R\x12certificateRenewal\x12I\n" +
+	"\x12issued_certificate\x18\x03 \x01(\v2\x18.agent.IssuedCertificateH\x00R\x11issuedCertificate\x124\n" +
+	"\vagent_token\x18\x04 \x01(\v2\x11.agent.AgentTokenH\x00R\n" +
+	"agentToken\x127\n" +
+	"\fagent_update\x18\x05 \x01(\v2\x12.agent.AgentUpdateH\x00R\vagentUpdate\x127\n" +
+	"\fbackfill_ack\x18\x06 \x01(\v2\x12.agent.BackfillAckH\x00R\vbackfillAck\x12*\n" +
+	"\awelcome\x18\a \x01(\v2\x0e.agent.WelcomeH\x00R\awelcome\x12>\n" +
+	"\x0ffile_events_ack\x18\b \x01(\v2\x14.agent.FileEventsAckH\x00R\rfileEventsAck\x12=\n" +
+	"\x0eaction_request\x18\t \x01(\v2\x14.agent.ActionRequestH\x00R\ractionRequest\x12:\n" +
+	"\rfile_transfer\x18\n" +
+	" \x01(\v2\x13.agent.FileTransferH\x00R\ffileTransfer\x121\n" +
+	"\n" +
+	"file_chunk\x18\v \x01(\v2\x10.agent.FileChunkH\x00R\tfileChunk\x12M\n" +
+	"\x14file_transfer_status\x18\f \x01(\v2\x19.agent.FileTransferStatusH\x00R\x12fileTransferStatus\x127\n" +
+	"\fagent_config\x18\r \x01(\v2\x12.agent.AgentConfigH\x00R\vagentConfigB\t\n" +
+	"\apayload\"\xf7\x02\n" +
+	"\x05Hello\x12#\n" +
+	"\ragent_version\x18\x01 \x01(\tR\fagentVersion\x12)\n" +
+	"\x10protocol_version\x18\x02 \x01(\rR\x0fprotocolVersion\x12*\n" +
+	"\bfeatures\x18\x03 \x03(\x0e2\x0e.agent.FeatureR\bfeatures\x12\x0e\n" +
+	"\x02os\x18\x04 \x01(\tR\x02os\x12\x12\n" +
+	"\x04arch\x18\x05 \x01(\tR\x04arch\x12\x1d\n" +
+	"\n" +
+	"os_release\x18\x06 \x01(\tR\tosRelease\x12\x16\n" +
+	"\x06kernel\x18\a \x01(\tR\x06kernel\x12%\n" +
+	"\x0euptime_seconds\x18\b \x01(\x03R\ruptimeSeconds\x12!\n" +
+	"\fip_addresses\x18\t \x03(\tR\vipAddresses\x12\x17\n" +
+	"\aboot_id\x18\n" +
+	" \x01(\tR\x06bootId\x12\x1a\n" +
+	"\bhostname\x18\v \x01(\tR\bhostname\x12\x18\n" +
+	"\aactions\x18\f \x03(\tR\aactions\"\x87\x01\n" +
+	"\aWelcome\x12)\n" +
+	"\x10protocol_version\x18\x01 \x01(\rR\x0fprotocolVersion\x12*\n" +
+	"\bfeatures\x18\x02 \x03(\x0e2\x0e.agent.FeatureR\bfeatures\x12%\n" +
+	"\x0einventory_hash\x18\x03 \x01(\tR\rinventoryHash\"\x8c\x03\n" +
+	"\tInventory\x12\x12\n" +
+	"\x04hash\x18\x01 \x01(\tR\x04hash\x12!\n" +
+	"\fcollected_at\x18\x02 \x01(\x03R\vcollectedAt\x12\x1d\n" +
+	"\n" +
+	"os_release\x18\x03 \x01(\tR\tosRelease\x12\x16\n" +
+	"\x06kernel\x18\x04 \x01(\tR\x06kernel\x12+\n" +
+	"\bhardware\x18\x05 \x01(\v2\x0f.agent.HardwareR\bhardware\x12*\n" +
+	"\bpackages\x18\x06 \x03(\v2\x0e.agent.PackageR\bpackages\x12F\n" +
+	"\x12network_interfaces\x18\a \x03(\v2\x17.agent.NetworkInterfaceR\x11networkInterfaces\x127\n" +
+	"\rblock_devices\x18\b \x03(\v2\x12.agent.BlockDeviceR\fblockDevices\x12\x13\n" +
+	"\x05os_id\x18\t \x01(\tR\x04osId\x12\"\n" +
+	"\ros_version_id\x18\n" +
+	" \x01(\tR\vosVersionId\"{\n" +
+	"\aPackage\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x18\n" +
+	"\aversion\x18\x02 \x01(\tR\aversion\x12\x12\n" +
+	"\x04arch\x18\x03 \x01(\tR\x04arch\x12\x16\n" +
+	"\x06format\x18\x04 \x01(\tR\x06format\x12\x16\n" +
+	"\x06source\x18\x05 \x01(\tR\x06source\"\xde\x02\n" +
+	"\bHardware\x12\x1b\n" +
+	"\tcpu_model\x18\x01 \x01(\tR\bcpuModel\x12\x1b\n" +
+	"\tcpu_count\x18\x02 \x01(\rR\bcpuCount\x12!\n" +
+	"\fmemory_bytes\x18\x03 \x01(\x04R\vmemoryBytes\x12#\n" +
+	"\rsystem_vendor\x18\x04 \x01(\tR\fsystemVendor\x12!\n" +
+	"\fproduct_name\x18\x05 \x01(\tR\vproductName\x12'\n" +
+	"\x0fproduct_version\x18\x06 \x01(\tR\x0eproductVersion\x12\x1f\n" +
+	"\vbios_vendor\x18\a \x01(\tR\n" +
+	"biosVendor\x12!\n" +
+	"\fbios_version\x18\b \x01(\tR\vbiosVersion\x12!\n" +
+	"\fboard_vendor\x18\t \x01(\tR\vboardVendor\x12\x1d\n" +
+	"\n" +
+	"board_name\x18\n" +
+	" \x01(\tR\tboardName\"h\n" +
+	"\x10NetworkInterface\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x10\n" +
+	"\x03mac\x18\x02 \x01(\tR\x03mac\x12\x10\n" +
+	"\x03mtu\x18\x03 \x01(\rR\x03mtu\x12\x1c\n" +
+	"\taddresses\x18\x04 \x03(\tR\taddresses\"\x94\x01\n" +
+	"\vBlockDevice\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x1d\n" +
+	"\n" +
+	"size_bytes\x18\x02 \x01(\x04R\tsizeBytes\x12\x14\n" +
+	"\x05model\x18\x03 \x01(\tR\x05model\x12\x1e\n" +
+	"\n" +
+	"rotational\x18\x04 \x01(\bR\n" +
+	"rotational\x12\x1c\n" +
+	"\tremovable\x18\x05 \x01(\bR\tremovable\"3\n" +
+	"\x12CertificateRenewal\x12\x1d\n" +
+	"\n" +
+	"expires_at\x18\x01 \x01(\x03R\texpiresAt\"4\n" +
+	"\x19CertificateSigningRequest\x12\x17\n" +
+	"\acsr_pem\x18\x01 \x01(\fR\x06csrPem\"\x89\x01\n" +
+	"\x11IssuedCertificate\x12'\n" +
+	"\x0fcertificate_pem\x18\x01 \x01(\fR\x0ecertificatePem\x12,\n" +
+	"\x12ca_certificate_pem\x18\x02 \x01(\fR\x10caCertificatePem\x12\x1d\n" +
+	"\n" +
+	"expires_at\x18\x03 \x01(\x03R\texpiresAt\"-\n" +
 	"\rHeartbeatPing\x12\x1c\n" +
 	"\ttimestamp\x18\x01 \x01(\x03R\ttimestamp\"E\n" +
 	"\vMetricValue\x12\x0e\n" +
@@ -390,7 +3207,152 @@ const file_agent_proto_rawDesc = "" +
 	"\ttimestamp\x18\x03 \x01(\x03R\ttimestamp\x1aN\n" +
 	"\fMetricsEntry\x12\x10\n" +
 	"\x03key\x18\x01 \x01(\tR\x03key\x12(\n" +
-	"\x05value\x18\x02 \x01(\v2\x12.agent.MetricValueR\x05value:\x028\x012H\n" +
+	"\x05value\x18\x02 \x01(\v2\x12.agent.MetricValueR\x05value:\x028\x01\"\"\n" +
+	"\n" +
+	"AgentToken\x12\x14\n" +
+	"\x05token\x18\x01 \x01(\tR\x05token\"\x96\x01\n" +
+	"\vAgentUpdate\x12\x18\n" +
+	"\aversion\x18\x01 \x01(\tR\aversion\x12\x16\n" +
+	"\x06sha256\x18\x02 \x01(\tR\x06sha256\x12\x12\n" +
+	"\x04size\x18\x03 \x01(\x03R\x04size\x12#\n" +
+	"\rdownload_path\x18\x04 \x01(\tR\fdownloadPath\x12\x1c\n" +
+	"\tsignature\x18\x05 \x01(\fR\tsignature\"\xd6\x01\n" +
+	"\x06Sample\x12\x10\n" +
+	"\x03seq\x18\x01 \x01(\x04R\x03seq\x12\x1c\n" +
+	"\ttimestamp\x18\x02 \x01(\x03R\ttimestamp\x12\x16\n" +
+	"\x06status\x18\x03 \x01(\tR\x06status\x124\n" +
+	"\ametrics\x18\x04 \x03(\v2\x1a.agent.Sample.MetricsEntryR\ametrics\x1aN\n" +
+	"\fMetricsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12(\n" +
+	"\x05value\x18\x02 \x01(\v2\x12.agent.MetricValueR\x05value:\x028\x01\"3\n" +
+	"\bBackfill\x12'\n" +
+	"\asamples\x18\x01 \x03(\v2\r.agent.SampleR\asamples\"(\n" +
+	"\vBackfillAck\x12\x19\n" +
+	"\blast_seq\x18\x01 \x01(\x04R\alastSeq\"6\n" +
+	"\n" +
+	"FileEvents\x12(\n" +
+	"\x06events\x18\x01 \x03(\v2\x10.agent.FileEventR\x06events\"*\n" +
+	"\rFileEventsAck\x12\x19\n" +
+	"\blast_seq\x18\x01 \x01(\x04R\alastSeq\"\xeb\x01\n" +
+	"\tFileEvent\x12\x10\n" +
+	"\x03seq\x18\x01 \x01(\x04R\x03seq\x12)\n" +
+	"\x06change\x18\x02 \x01(\x0e2\x11.agent.FileChangeR\x06change\x12\x12\n" +
+	"\x04path\x18\x03 \x01(\tR\x04path\x12\x1f\n" +
+	"\vdetected_at\x18\x04 \x01(\x03R\n" +
+	"detectedAt\x12(\n" +
+	"\x06before\x18\x05 \x01(\v2\x10.agent.FileStateR\x06before\x12&\n" +
+	"\x05after\x18\x06 \x01(\v2\x10.agent.FileStateR\x05after\x12\x1a\n" +
+	"\brealtime\x18\a \x01(\bR\brealtime\"\xd2\x01\n" +
+	"\tFileState\x12\x16\n" +
+	"\x06sha256\x18\x01 \x01(\tR\x06sha256\x12\x12\n" +
+	"\x04mode\x18\x02 \x01(\rR\x04mode\x12\x10\n" +
+	"\x03uid\x18\x03 \x01(\rR\x03uid\x12\x10\n" +
+	"\x03gid\x18\x04 \x01(\rR\x03gid\x12\x14\n" +
+	"\x05owner\x18\x05 \x01(\tR\x05owner\x12\x14\n" +
+	"\x05group\x18\x06 \x01(\tR\x05group\x12\x12\n" +
+	"\x04size\x18\a \x01(\x03R\x04size\x12\x14\n" +
+	"\x05mtime\x18\b \x01(\x03R\x05mtime\x12\x1f\n" +
+	"\vlink_target\x18\t \x01(\tR\n" +
+	"linkTarget\"\x80\x01\n" +
+	"\n" +
+	"UnitReport\x12\x12\n" +
+	"\x04hash\x18\x01 \x01(\tR\x04hash\x12!\n" +
+	"\fcollected_at\x18\x02 \x01(\x03R\vcollectedAt\x12\x18\n" +
+	"\asystemd\x18\x03 \x01(\bR\asystemd\x12!\n" +
+	"\x05units\x18\x04 \x03(\v2\v.agent.UnitR\x05units\"\x94\x02\n" +
+	"\x04Unit\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12 \n" +
+	"\vdescription\x18\x02 \x01(\tR\vdescription\x12\x1d\n" +
+	"\n" +
+	"load_state\x18\x03 \x01(\tR\tloadState\x12!\n" +
+	"\factive_state\x18\x04 \x01(\tR\vactiveState\x12\x1b\n" +
+	"\tsub_state\x18\x05 \x01(\tR\bsubState\x12\x16\n" +
+	"\x06result\x18\x06 \x01(\tR\x06result\x12\x1a\n" +
+	"\brestarts\x18\a \x01(\rR\brestarts\x12(\n" +
+	"\x10state_changed_at\x18\b \x01(\x03R\x0estateChangedAt\x12\x19\n" +
+	"\bmain_pid\x18\t \x01(\rR\amainPid\"\xe9\x01\n" +
+	"\rActionRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x16\n" +
+	"\x06action\x18\x02 \x01(\tR\x06action\x128\n" +
+	"\x06params\x18\x03 \x03(\v2 .agent.ActionRequest.ParamsEntryR\x06params\x12\x12\n" +
+	"\x04args\x18\x04 \x03(\tR\x04args\x12'\n" +
+	"\x0ftimeout_seconds\x18\x05 \x01(\rR\x0etimeoutSeconds\x1a9\n" +
+	"\vParamsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\xc3\x01\n" +
+	"\fActionResult\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x18\n" +
+	"\asuccess\x18\x02 \x01(\bR\asuccess\x12\x1b\n" +
+	"\texit_code\x18\x03 \x01(\x05R\bexitCode\x12\x16\n" +
+	"\x06output\x18\x04 \x01(\tR\x06output\x12\x14\n" +
+	"\x05error\x18\x05 \x01(\tR\x05error\x12\x1d\n" +
+	"\n" +
+	"started_at\x18\x06 \x01(\x03R\tstartedAt\x12\x1f\n" +
+	"\vfinished_at\x18\a \x01(\x03R\n" +
+	"finishedAt\"\xf8\x01\n" +
+	"\fFileTransfer\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x122\n" +
+	"\tdirection\x18\x02 \x01(\x0e2\x14.agent.FileDirectionR\tdirection\x12\x12\n" +
+	"\x04path\x18\x03 \x01(\tR\x04path\x12\x1d\n" +
+	"\n" +
+	"chunk_size\x18\x04 \x01(\rR\tchunkSize\x12\x19\n" +
+	"\bmax_size\x18\x05 \x01(\x03R\amaxSize\x12\x16\n" +
+	"\x06offset\x18\x06 \x01(\x03R\x06offset\x12\x12\n" +
+	"\x04size\x18\a \x01(\x03R\x04size\x12\x16\n" +
+	"\x06sha256\x18\b \x01(\tR\x06sha256\x12\x12\n" +
+	"\x04mode\x18\t \x01(\rR\x04mode\"\x85\x01\n" +
+	"\tFileChunk\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x16\n" +
+	"\x06offset\x18\x02 \x01(\x03R\x06offset\x12\x12\n" +
+	"\x04data\x18\x03 \x01(\fR\x04data\x12\x10\n" +
+	"\x03eof\x18\x04 \x01(\bR\x03eof\x12\x12\n" +
+	"\x04size\x18\x05 \x01(\x03R\x04size\x12\x16\n" +
+	"\x06sha256\x18\x06 \x01(\tR\x06sha256\"f\n" +
+	"\x12FileTransferStatus\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x16\n" +
+	"\x06offset\x18\x02 \x01(\x03R\x06offset\x12\x12\n" +
+	"\x04done\x18\x03 \x01(\bR\x04done\x12\x14\n" +
+	"\x05error\x18\x04 \x01(\tR\x05error\"\xdb\x02\n" +
+	"\vAgentConfig\x12\x18\n" +
+	"\aversion\x18\x01 \x01(\tR\aversion\x12\x1b\n" +
+	"\tlog_level\x18\x02 \x01(\tR\blogLevel\x12\x1e\n" +
+	"\n" +
+	"collectors\x18\x03 \x03(\tR\n" +
+	"collectors\x124\n" +
+	"\x16offline_sample_seconds\x18\x04 \x01(\rR\x14offlineSampleSeconds\x12+\n" +
+	"\x11inventory_seconds\x18\x05 \x01(\rR\x10inventorySeconds\x12#\n" +
+	"\runits_seconds\x18\x06 \x01(\rR\funitsSeconds\x123\n" +
+	"\x05extra\x18\a \x03(\v2\x1d.agent.AgentConfig.ExtraEntryR\x05extra\x1a8\n" +
+	"\n" +
+	"ExtraEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"?\n" +
+	"\rConfigApplied\x12\x18\n" +
+	"\aversion\x18\x01 \x01(\tR\aversion\x12\x14\n" +
+	"\x05error\x18\x02 \x01(\tR\x05error*\x98\x02\n" +
+	"\aFeature\x12\x17\n" +
+	"\x13FEATURE_UNSPECIFIED\x10\x00\x12\x1f\n" +
+	"\x1bFEATURE_CERTIFICATE_RENEWAL\x10\x01\x12\x1a\n" +
+	"\x16FEATURE_TOKEN_ROTATION\x10\x02\x12\x17\n" +
+	"\x13FEATURE_SELF_UPDATE\x10\x03\x12\x14\n" +
+	"\x10FEATURE_BACKFILL\x10\x04\x12\x15\n" +
+	"\x11FEATURE_INVENTORY\x10\x05\x12\x1a\n" +
+	"\x16FEATURE_FILE_INTEGRITY\x10\x06\x12\x11\n" +
+	"\rFEATURE_UNITS\x10\a\x12\x13\n" +
+	"\x0fFEATURE_ACTIONS\x10\b\x12\x19\n" +
+	"\x15FEATURE_FILE_TRANSFER\x10\t\x12\x12\n" +
+	"\x0eFEATURE_CONFIG\x10\n" +
+	"*s\n" +
+	"\n" +
+	"FileChange\x12\x1b\n" +
+	"\x17FILE_CHANGE_UNSPECIFIED\x10\x00\x12\x15\n" +
+	"\x11FILE_CHANGE_ADDED\x10\x01\x12\x17\n" +
+	"\x13FILE_CHANGE_REMOVED\x10\x02\x12\x18\n" +
+	"\x14FILE_CHANGE_MODIFIED\x10\x03*k\n" +
+	"\rFileDirection\x12\x1e\n" +
+	"\x1aFILE_DIRECTION_UNSPECIFIED\x10\x00\x12\x1b\n" +
+	"\x17FILE_DIRECTION_TO_AGENT\x10\x01\x12\x1d\n" +
+	"\x19FILE_DIRECTION_FROM_AGENT\x10\x022H\n" +
 	"\fAgentService\x128\n" +
 	"\aConnect\x12\x13.agent.AgentMessage\x1a\x14.agent.ServerMessage(\x010\x01B#Z!github.com/lute/agent/proto/agentb\x06proto3"
 
@@ -406,27 +3368,101 @@ func file_agent_proto_rawDescGZIP() []byte {
 	return file_agent_proto_rawDescData
 }
 
-var file_agent_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
+var file_agent_proto_enumTypes = make([]protoimpl.EnumInfo, 3)
+var file_agent_proto_msgTypes = make([]protoimpl.MessageInfo, 37)
 var file_agent_proto_goTypes = []any{
-	(*AgentMessage)(nil),  // 0: agent.AgentMessage
-	(*ServerMessage)(nil), // 1: agent.ServerMessage
-	(*HeartbeatPing)(nil), // 2: agent.HeartbeatPing
-	(*MetricValue)(nil),   // 3: agent.MetricValue
-	(*HeartbeatPong)(nil), // 4: agent.HeartbeatPong
-	nil,                   // 5: agent.HeartbeatPong.MetricsEntry
+	(Feature)(0),                      // 0: agent.Feature
+	(FileChange)(0),                   // 1: agent.FileChange
+	(FileDirection)(0),                // 2: agent.FileDirection
+	(*AgentMessage)(nil),              // 3: agent.AgentMessage
+	(*ServerMessage)(nil),             // 4: agent.ServerMessage
+	(*Hello)(nil),                     // 5: agent.Hello
+	(*Welcome)(nil),                   // 6: agent.Welcome
+	(*Inventory)(nil),                 // 7: agent.Inventory
+	(*Package)(nil),                   // 8: agent.Package
+	(*Hardware)(nil),                  // 9: agent.Hardware
+	(*NetworkInterface)(nil),          // 10: agent.NetworkInterface
+	(*BlockDevice)(nil),               // 11: agent.BlockDevice
+	(*CertificateRenewal)(nil),        // 12: agent.CertificateRenewal
+	(*CertificateSigningRequest)(nil), // 13: agent.CertificateSigningRequest
+	(*IssuedCertificate)(nil),         // 14: agent.IssuedCertificate
+	(*HeartbeatPing)(nil),             // 15: agent.HeartbeatPing
+	(*MetricValue)(nil),               // 16: agent.MetricValue
+	(*HeartbeatPong)(nil),             // 17: agent.HeartbeatPong
+	(*AgentToken)(nil),                // 18: agent.AgentToken
+	(*AgentUpdate)(nil),               // 19: agent.AgentUpdate
+	(*Sample)(nil),                    // 20: agent.Sample
+	(*Backfill)(nil),                  // 21: agent.Backfill
+	(*BackfillAck)(nil),               // 22: agent.BackfillAck
+	(*FileEvents)(nil),                // 23: agent.FileEvents
+	(*FileEventsAck)(nil),             // 24: agent.FileEventsAck
+	(*FileEvent)(nil),                 // 25: agent.FileEvent
+	(*FileState)(nil),                 // 26: agent.FileState
+	(*UnitReport)(nil),                // 27: agent.UnitReport
+	(*Unit)(nil),                      // 28: agent.Unit
+	(*ActionRequest)(nil),             // 29: agent.ActionRequest
+	(*ActionResult)(nil),              // 30: agent.ActionResult
+	(*FileTransfer)(nil),              // 31: agent.FileTransfer
+	(*FileChunk)(nil),                 // 32: agent.FileChunk
+	(*FileTransferStatus)(nil),        // 33: agent.FileTransferStatus
+	(*AgentConfig)(nil),               // 34: agent.AgentConfig
+	(*ConfigApplied)(nil),             // 35: agent.ConfigApplied
+	nil,                               // 36: agent.HeartbeatPong.MetricsEntry
+	nil,                               // 37: agent.Sample.MetricsEntry
+	nil,                               // 38: agent.ActionRequest.ParamsEntry
+	nil,                               // 39: agent.AgentConfig.ExtraEntry
 }
 var file_agent_proto_depIdxs = []int32{
-	4, // 0: agent.AgentMessage.heartbeat_pong:type_name -> agent.HeartbeatPong
-	2, // 1: agent.ServerMessage.heartbeat_ping:type_name -> agent.HeartbeatPing
-	5, // 2: agent.HeartbeatPong.metrics:type_name -> agent.HeartbeatPong.MetricsEntry
-	3, // 3: agent.HeartbeatPong.MetricsEntry.value:type_name -> agent.MetricValue
-	0, // 4: agent.AgentService.Connect:input_type -> agent.AgentMessage
-	1, // 5: agent.AgentService.Connect:output_type -> agent.ServerMessage
-	5, // [5:6] is the sub-list for method output_type
-	4, // [4:5] is the sub-list for method input_type
-	4, // [4:4] is the sub-list for extension type_name
-	4, // [4:4] is the sub-list for extension extendee
-	0, // [0:4] is the sub-list for field type_name
+	17, // 0: agent.AgentMessage.heartbeat_pong:type_name -> agent.HeartbeatPong
+	13, // 1: agent.AgentMessage.certificate_signing_request:type_name -> agent.CertificateSigningRequest
+	21, // 2: agent.AgentMessage.backfill:type_name -> agent.Backfill
+	5,  // 3: agent.AgentMessage.hello:type_name -> agent.Hello
+	7,  // 4: agent.AgentMessage.inventory:type_name -> agent.Inventory
+	23, // 5: agent.AgentMessage.file_events:type_name -> agent.FileEvents
+	27, // 6: agent.AgentMessage.units:type_name -> agent.UnitReport
+	30, // 7: agent.AgentMessage.action_result:type_name -> agent.ActionResult
+	32, // 8: agent.AgentMessage.file_chunk:type_name -> agent.FileChunk
+	33, // 9: agent.AgentMessage.file_transfer_status:type_name -> agent.FileTransferStatus
+	35, // 10: agent.AgentMessage.config_applied:type_name -> agent.ConfigApplied
+	15, // 11: agent.ServerMessage.heartbeat_ping:type_name -> agent.HeartbeatPing
+	12, // 12: agent.ServerMessage.certificate_renewal:type_name -> agent.CertificateRenewal
+	14, // 13: agent.ServerMessage.issued_certificate:type_name -> agent.IssuedCertificate
+	18, // 14: agent.ServerMessage.agent_token:type_name -> agent.AgentToken
+	19, // 15: agent.ServerMessage.agent_update:type_name -> agent.AgentUpdate
+	22, // 16: agent.ServerMessage.backfill_ack:type_name -> agent.BackfillAck
+	6,  // 17: agent.ServerMessage.welcome:type_name -> agent.Welcome
+	24, // 18: agent.ServerMessage.file_events_ack:type_name -> agent.FileEventsAck
+	29, // 19: agent.ServerMessage.action_request:type_name -> agent.ActionRequest
+	31, // 20: agent.ServerMessage.file_transfer:type_name -> agent.FileTransfer
+	32, // 21: agent.ServerMessage.file_chunk:type_name -> agent.FileChunk
+	33, // 22: agent.ServerMessage.file_transfer_status:type_name -> agent.FileTransferStatus
+	34, // 23: agent.ServerMessage.agent_config:type_name -> agent.AgentConfig
+	0,  // 24: agent.Hello.features:type_name -> agent.Feature
+	0,  // 25: agent.Welcome.features:type_name -> agent.Feature
+	9,  // 26: agent.Inventory.hardware:type_name -> agent.Hardware
+	8,  // 27: agent.Inventory.packages:type_name -> agent.Package
+	10, // 28: agent.Inventory.network_interfaces:type_name -> agent.NetworkInterface
+	11, // 29: agent.Inventory.block_devices:type_name -> agent.BlockDevice
+	36, // 30: agent.HeartbeatPong.metrics:type_name -> agent.HeartbeatPong.MetricsEntry
+	37, // 31: agent.Sample.metrics:type_name -> agent.Sample.MetricsEntry
+	20, // 32: agent.Backfill.samples:type_name -> agent.Sample
+	25, // 33: agent.FileEvents.events:type_name -> agent.FileEvent
+	1,  // 34: agent.FileEvent.change:type_name -> agent.FileChange
+	26, // 35: agent.FileEvent.before:type_name -> agent.FileState
+	26, // 36: agent.FileEvent.after:type_name -> agent.FileState
+	28, // 37: agent.UnitReport.units:type_name -> agent.Unit
+	38, // 38: agent.ActionRequest.params:type_name -> agent.ActionRequest.ParamsEntry
+	2,  // 39: agent.FileTransfer.direction:type_name -> agent.FileDirection
+	39, // 40: agent.AgentConfig.extra:type_name -> agent.AgentConfig.ExtraEntry
+	16, // 41: agent.HeartbeatPong.MetricsEntry.value:type_name -> agent.MetricValue
+	16, // 42: agent.Sample.MetricsEntry.value:type_name -> agent.MetricValue
+	3,  // 43: agent.AgentService.Connect:input_type -> agent.AgentMessage
+	4,  // 44: agent.AgentService.Connect:output_type -> agent.ServerMessage
+	44, // [44:45] is the sub-list for method output_type
+	43, // [43:44] is the sub-list for method input_type
+	43, // [43:43] is the sub-list for extension type_name
+	43, // [43:43] is the sub-list for extension extendee
+	0,  // [0:43] is the sub-list for field type_name
 }
 
 func init() { file_agent_proto_init() }
@@ -436,11 +3472,33 @@ func file_agent_proto_init() {
 	}
 	file_agent_proto_msgTypes[0].OneofWrappers = []any{
 		(*AgentMessage_HeartbeatPong)(nil),
+		(*AgentMessage_CertificateSigningRequest)(nil),
+		(*AgentMessage_Backfill)(nil),
+		(*AgentMessage_Hello)(nil),
+		(*AgentMessage_Inventory)(nil),
+		(*AgentMessage_FileEvents)(nil),
+		(*AgentMessage_Units)(nil),
+		(*AgentMessage_ActionResult)(nil),
+		(*AgentMessage_FileChunk)(nil),
+		(*AgentMessage_FileTransferStatus)(nil),
+		(*AgentMessage_ConfigApplied)(nil),
 	}
 	file_agent_proto_msgTypes[1].OneofWrappers = []any{
 		(*ServerMessage_HeartbeatPing)(nil),
+		(*ServerMessage_CertificateRenewal)(nil),
+		(*ServerMessage_IssuedCertificate)(nil),
+		(*ServerMessage_AgentToken)(nil),
+		(*ServerMessage_AgentUpdate)(nil),
+		(*ServerMessage_BackfillAck)(nil),
+		(*ServerMessage_Welcome)(nil),
+		(*ServerMessage_FileEventsAck)(nil),
+		(*ServerMessage_ActionRequest)(nil),
+		(*ServerMessage_FileTransfer)(nil),
+		(*ServerMessage_FileChunk)(nil),
+		(*ServerMessage_FileTransferStatus)(nil),
+		(*ServerMessage_AgentConfig)(nil),
 	}
-	file_agent_proto_msgTypes[3].OneofWrappers = []any{
+	file_agent_proto_msgTypes[13].OneofWrappers = []any{
 		(*MetricValue_I)(nil),
 		(*MetricValue_F)(nil),
 		(*MetricValue_S)(nil),
@@ -450,13 +3508,14 @@ func file_agent_proto_init() {
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_agent_proto_rawDesc), len(file_agent_proto_rawDesc)),
-			NumEnums:      0,
-			NumMessages:   6,
+			NumEnums:      3,
+			NumMessages:   37,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
 		GoTypes:           file_agent_proto_goTypes,
 		DependencyIndexes: file_agent_proto_depIdxs,
+		EnumInfos:         file_agent_proto_enumTypes,
 		MessageInfos:      file_agent_proto_msgTypes,
 	}.Build()
 	File_agent_proto = out.File
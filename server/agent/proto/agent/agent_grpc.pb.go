@@ -27,8 +27,9 @@ const (
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 //
 // AgentService — a single bidirectional stream between agent and API server.
-// The agent opens the stream after REST registration; the API sends heartbeat
-// pings and the agent responds with pongs carrying status and metrics.
+// The agent opens the stream after REST registration and introduces itself
+// with a Hello; the API answers with a Welcome, then sends heartbeat pings and
+// the agent responds with pongs carrying status and metrics.
 type AgentServiceClient interface {
 	Connect(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[AgentMessage, ServerMessage], error)
 }
@@ -59,8 +60,9 @@ type AgentService_ConnectClient = grpc.BidiStreamingClient[AgentMessage, ServerM
 // for forward compatibility.
 //
 // AgentService — a single bidirectional stream between agent and API server.
-// The agent opens the stream after REST registration; the API sends heartbeat
-// pings and the agent responds with pongs carrying status and metrics.
+// The agent opens the stream after REST registration and introduces itself
+// with a Hello; the API answers with a Welcome, then sends heartbeat pings and
+// the agent responds with pongs carrying status and metrics.
 type AgentServiceServer interface {
 	Connect(grpc.BidiStreamingServer[AgentMessage, ServerMessage]) error
 	mustEmbedUnimplementedAgentServiceServer()
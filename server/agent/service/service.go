@@ -0,0 +1,365 @@
+// Package service installs the agent as a system service, so it starts on
+// boot, is restarted when it exits and logs through the init system.
+//
+//	lute-agent service install --api https://lute.example.com --claim-code CODE
+//	lute-agent service status
+package service
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/lute/agent/config"
+	"github.com/lute/agent/utils"
+)
+
+const (
+	// Name is the service name registered with the init system.
+	Name = "lute-agent"
+
+	DefaultUser       = "lute-agent"
+	DefaultConfigPath = "/etc/lute-agent/config.yaml"
+	DefaultStateDir   = "/var/lib/lute-agent"
+
+	// envFile holds the one-time registration secret. It is readable by root
+	// only; the init system passes it to the agent as environment variables.
+	envFile = "/etc/lute-agent/agent.env"
+)
+
+// Options describes the service to install.
+type Options struct {
+	BinaryPath string
+	ConfigPath string
+	StateDir   string
+	User       string
+	// WritePaths are the directories outside StateDir the agent writes to,
+	// those of the config's files.write allowlist.
+	WritePaths []string
+}
+
+// manager controls the service through the host's init system.
+type manager interface {
+	Name() string
+	Install(o Options) error
+	Uninstall() error
+	Start() error
+	Stop() error
+	Status() (string, error)
+}
+
+const usage = `Usage: lute-agent service <command> [flags]
+
+Commands:
+  install     Install, enable and start the agent service
+  uninstall   Stop and remove the service (--purge also removes config and state)
+  start       Start the service
+  stop        Stop the service
+  status      Show the service status
+`
+
+// Main runs a "lute-agent service" subcommand.
+func Main(args []string) error {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" || args[0] == "help" {
+		fmt.Print(usage)
+		return nil
+	}
+	m, err := detect()
+	if err != nil {
+		return err
+	}
+
+	switch cmd, rest := args[0], args[1:]; cmd {
+	case "install":
+		return install(m, rest)
+	case "uninstall":
+		return uninstall(m, rest)
+	case "start":
+		if err := requireRoot(); err != nil {
+			return err
+		}
+		return m.Start()
+	case "stop":
+		if err := requireRoot(); err != nil {
+			return err
+		}
+		return m.Stop()
+	case "status":
+		out, err := m.Status()
+		fmt.Print(out)
+		return err
+	default:
+		fmt.Print(usage)
+		return fmt.Errorf("unknown command %q", cmd)
+	}
+}
+
+func install(m manager, args []string) error {
+	fs := flag.NewFlagSet("service install", flag.ContinueOnError)
+	var (
+		opts            Options
+		api, server     string
+		claimCode       string
+		enrollmentToken string
+		labels          string
+		noStart         bool
+	)
+	fs.StringVar(&opts.ConfigPath, "config", DefaultConfigPath, "Config file written on first install and passed to the agent")
+	fs.StringVar(&opts.StateDir, "state-dir", DefaultStateDir, "State directory (machine ID and credentials)")
+	fs.StringVar(&opts.User, "user", DefaultUser, "System user the agent runs as (created if missing)")
+	fs.StringVar(&api, "api", "", "HTTP API base URL written to the config")
+	fs.StringVar(&server, "server", "", "gRPC server address written to the config")
+	fs.StringVar(&labels, "labels", "", "Comma-separated key=value labels written to the config")
+	fs.StringVar(&claimCode, "claim-code", os.Getenv("LUTE_CLAIM_CODE"), "Claim code used for the first registration")
+	fs.StringVar(&enrollmentToken, "enrollment-token", os.Getenv("LUTE_ENROLLMENT_TOKEN"), "Enrollment token used for the first registration")
+	fs.BoolVar(&noStart, "no-start", false, "Install and enable without starting")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := requireRoot(); err != nil {
+		return err
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("locate agent binary: %w", err)
+	}
+	if opts.BinaryPath, err = filepath.EvalSymlinks(exe); err != nil {
+		return fmt.Errorf("locate agent binary: %w", err)
+	}
+
+	if err := ensureUser(opts.User, opts.StateDir); err != nil {
+		return fmt.Errorf("create user %s: %w", opts.User, err)
+	}
+	uid, gid, err := lookupIDs(opts.User)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(opts.StateDir, 0o700); err != nil {
+		return err
+	}
+	if err := os.Chown(opts.StateDir, uid, gid); err != nil {
+		return err
+	}
+	// The service runs its own copy of the binary, owned by the service
+	// user, so the agent can replace it when it updates itself.
+	if opts.BinaryPath, err = installBinary(opts.BinaryPath, filepath.Join(opts.StateDir, "bin"), uid, gid); err != nil {
+		return fmt.Errorf("install agent binary: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(opts.ConfigPath), 0o755); err != nil {
+		return err
+	}
+
+	labelSet, err := utils.ParseLabels(labels)
+	if err != nil {
+		return fmt.Errorf("--labels: %w", err)
+	}
+	if err := writeConfig(opts, gid, api, server, labelSet); err != nil {
+		return err
+	}
+	if err := writeEnv(claimCode, enrollmentToken); err != nil {
+		return err
+	}
+	if opts.WritePaths, err = writePaths(opts.ConfigPath); err != nil {
+		return err
+	}
+
+	if err := m.Install(opts); err != nil {
+		return err
+	}
+	fmt.Printf("Installed %s service (%s), running as %s\n", Name, m.Name(), opts.User)
+	fmt.Printf("  Binary: %s\n", opts.BinaryPath)
+	fmt.Printf("  Config: %s\n", opts.ConfigPath)
+	fmt.Printf("  State:  %s\n", opts.StateDir)
+	if len(opts.WritePaths) > 0 {
+		fmt.Printf("  Writes: %s\n", strings.Join(opts.WritePaths, ", "))
+	}
+	if noStart {
+		return nil
+	}
+	if err := m.Start(); err != nil {
+		return err
+	}
+	fmt.Printf("Started %s\n", Name)
+	return nil
+}
+
+func uninstall(m manager, args []string) error {
+	fs := flag.NewFlagSet("service uninstall", flag.ContinueOnError)
+	purge := fs.Bool("purge", false, "Also remove the config, registration secret and state directory")
+	configPath := fs.String("config", DefaultConfigPath, "Config file to remove with --purge")
+	stateDir := fs.String("state-dir", DefaultStateDir, "State directory to remove with --purge")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := requireRoot(); err != nil {
+		return err
+	}
+	if err := m.Uninstall(); err != nil {
+		return err
+	}
+	fmt.Printf("Removed %s service\n", Name)
+	if !*purge {
+		fmt.Printf("Kept %s and %s; the machine stays registered\n", *configPath, *stateDir)
+		return nil
+	}
+	for _, path := range []string{*configPath, envFile} {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return os.RemoveAll(*stateDir)
+}
+
+// installBinary copies the agent binary into dir and returns the new path.
+// It replaces an existing copy atomically, since the service may be running it.
+func installBinary(src, dir string, uid, gid int) (string, error) {
+	dst := filepath.Join(dir, Name)
+	if src == dst {
+		return dst, nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	if err := os.Chown(dir, uid, gid); err != nil {
+		return "", err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	tmp := dst + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o755)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chown(tmp, uid, gid)
+	}
+	if err == nil {
+		err = os.Rename(tmp, dst)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return dst, nil
+}
+
+// writeConfig writes the agent config unless one exists already, so
+// reinstalling (e.g. after an upgrade) keeps local changes.
+func writeConfig(opts Options, gid int, api, server string, labels map[string]string) error {
+	if _, err := os.Stat(opts.ConfigPath); err == nil {
+		fmt.Printf("Keeping existing config %s\n", opts.ConfigPath)
+		return nil
+	}
+	doc := map[string]interface{}{"state_dir": opts.StateDir}
+	if api != "" {
+		doc["api"] = api
+	}
+	if server != "" {
+		doc["server"] = server
+	}
+	if len(labels) > 0 {
+		doc["labels"] = labels
+	}
+	data, err := yaml.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	data = append([]byte("# Written by \"lute-agent service install\"; see config.example.yaml for all settings.\n"), data...)
+	if err := os.WriteFile(opts.ConfigPath, data, 0o640); err != nil {
+		return err
+	}
+	return os.Chown(opts.ConfigPath, 0, gid)
+}
+
+// writePaths returns the directories the agent needs to write to for the
+// files.write allowlist of the config at path. Uploads create their
+// temporary file beside the target, so that is a listed directory, the
+// directory of a listed file, or for a glob pattern the directory its
+// wildcards are in.
+func writePaths(path string) ([]string, error) {
+	cfg, err := config.Load(path, false)
+	if err != nil {
+		return nil, err
+	}
+	var dirs []string
+	for _, entry := range cfg.Files.Write {
+		dir := filepath.Clean(entry)
+		if i := strings.IndexAny(dir, `*?[\`); i >= 0 {
+			dir = filepath.Dir(dir[:i])
+		} else if info, err := os.Stat(dir); err == nil && !info.IsDir() {
+			dir = filepath.Dir(dir)
+		}
+		if !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	sort.Strings(dirs)
+	return dirs, nil
+}
+
+// writeEnv stores the registration secret for the first start. It is no
+// longer needed once the agent has saved its state.
+func writeEnv(claimCode, enrollmentToken string) error {
+	vars := map[string]string{}
+	if claimCode != "" {
+		vars["LUTE_CLAIM_CODE"] = claimCode
+	}
+	if enrollmentToken != "" {
+		vars["LUTE_ENROLLMENT_TOKEN"] = enrollmentToken
+	}
+	if len(vars) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(vars))
+	for k := range vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s=%s\n", k, vars[k])
+	}
+	return os.WriteFile(envFile, []byte(b.String()), 0o600)
+}
+
+func requireRoot() error {
+	if os.Geteuid() != 0 {
+		return errors.New("must be run as root (try sudo)")
+	}
+	return nil
+}
+
+func lookupIDs(name string) (int, int, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return 0, 0, err
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return 0, 0, fmt.Errorf("user %s: unexpected uid %q", name, u.Uid)
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return 0, 0, fmt.Errorf("user %s: unexpected gid %q", name, u.Gid)
+	}
+	return uid, gid, nil
+}
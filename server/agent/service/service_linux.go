@@ -0,0 +1,427 @@
+//go:build linux
+
+package service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/template"
+
+	"github.com/lute/agent/actions"
+)
+
+const (
+	systemdUnitPath = "/etc/systemd/system/" + Name + ".service"
+	initScriptPath  = "/etc/init.d/" + Name
+	logPath         = "/var/log/" + Name + ".log"
+	pidPath         = "/var/run/" + Name + ".pid"
+)
+
+// detect picks the init system: systemd, then OpenRC, then SysV init scripts.
+func detect() (manager, error) {
+	if fi, err := os.Stat("/run/systemd/system"); err == nil && fi.IsDir() {
+		return systemd{}, nil
+	}
+	if _, err := exec.LookPath("openrc-run"); err == nil {
+		return openrc{}, nil
+	}
+	if fi, err := os.Stat("/etc/init.d"); err == nil && fi.IsDir() {
+		return sysv{}, nil
+	}
+	return nil, errors.New("no supported init system found (systemd, OpenRC or SysV)")
+}
+
+// ensureUser creates a system user and group without a login shell or home.
+func ensureUser(name, home string) error {
+	if _, _, err := lookupIDs(name); err == nil {
+		return nil
+	}
+	shell := "/usr/sbin/nologin"
+	if _, err := os.Stat(shell); err != nil {
+		shell = "/sbin/nologin"
+	}
+	if _, err := exec.LookPath("useradd"); err == nil {
+		return run("useradd", "--system", "--user-group", "--no-create-home", "--home-dir", home, "--shell", shell, name)
+	}
+	// BusyBox (Alpine)
+	if err := run("addgroup", "-S", name); err != nil {
+		return err
+	}
+	return run("adduser", "-S", "-D", "-H", "-h", home, "-s", shell, "-G", name, name)
+}
+
+func run(name string, args ...string) error {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s: %v: %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func writeTemplate(path string, tmpl *template.Template, opts Options, mode os.FileMode) error {
+	var b bytes.Buffer
+	data := struct {
+		Options
+		Name    string
+		EnvFile string
+		LogPath string
+		PIDPath string
+	}{opts, Name, envFile, logPath, pidPath}
+	if err := tmpl.Execute(&b, data); err != nil {
+		return err
+	}
+	return os.WriteFile(path, b.Bytes(), mode)
+}
+
+// --- systemd ---
+
+// The agent only needs outbound network access, its state directory and
+// the directories of its files.write allowlist, so the unit makes the rest
+// of the system read-only and drops every capability but
+// CAP_DAC_READ_SEARCH, which lets file integrity monitoring read files only
+// root may, such as /etc/shadow and /root/.ssh. With a files.write
+// allowlist it also keeps CAP_DAC_OVERRIDE, CAP_CHOWN and CAP_FOWNER, so
+// uploads can replace root's files there and keep their owner; the
+// read-only mounts confine them to those directories.
+var systemdUnit = template.Must(template.New("unit").Parse(`[Unit]
+Description=Lute monitoring agent
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+Type=simple
+User={{.User}}
+Group={{.User}}
+Environment=LUTE_SUPERVISED=1
+EnvironmentFile=-{{.EnvFile}}
+ExecStart={{.BinaryPath}} --config {{.ConfigPath}} --state-dir {{.StateDir}}
+Restart=always
+RestartSec=5
+StandardOutput=journal
+StandardError=journal
+SyslogIdentifier={{.Name}}
+
+NoNewPrivileges=yes
+CapabilityBoundingSet=CAP_DAC_READ_SEARCH{{if .WritePaths}} CAP_DAC_OVERRIDE CAP_CHOWN CAP_FOWNER{{end}}
+AmbientCapabilities=CAP_DAC_READ_SEARCH{{if .WritePaths}} CAP_DAC_OVERRIDE CAP_CHOWN CAP_FOWNER{{end}}
+ProtectSystem=strict
+ReadWritePaths={{.StateDir}}{{range .WritePaths}} "-{{.}}"{{end}}
+ProtectHome=read-only
+PrivateTmp=yes
+PrivateDevices=yes
+ProtectKernelTunables=yes
+ProtectKernelModules=yes
+ProtectKernelLogs=yes
+ProtectControlGroups=yes
+ProtectClock=yes
+ProtectHostname=yes
+RestrictAddressFamilies=AF_INET AF_INET6 AF_UNIX AF_NETLINK
+RestrictNamespaces=yes
+RestrictRealtime=yes
+RestrictSUIDSGID=yes
+LockPersonality=yes
+MemoryDenyWriteExecute=yes
+SystemCallArchitectures=native
+UMask=0077
+
+[Install]
+WantedBy=multi-user.target
+`))
+
+// The unit cannot gain privileges, so the service user is granted managing
+// units and rebooting (the service-* and reboot actions) through polkit;
+// the agent's policy decides which of them it carries out.
+var polkitRule = template.Must(template.New("rule").Parse(`// Installed by "lute-agent service install"
+polkit.addRule(function(action, subject) {
+	if (subject.user == "{{.User}}" &&
+	    (action.id == "org.freedesktop.systemd1.manage-units" ||
+	     action.id.indexOf("org.freedesktop.login1.reboot") == 0)) {
+		return polkit.Result.YES;
+	}
+});
+`))
+
+var polkitPKLA = template.Must(template.New("pkla").Parse(`# Installed by "lute-agent service install"
+[{{.Name}} service actions]
+Identity=unix-user:{{.User}}
+Action=org.freedesktop.systemd1.manage-units;org.freedesktop.login1.reboot*
+ResultAny=yes
+ResultInactive=yes
+ResultActive=yes
+`))
+
+// installPolkit grants the service user the actions of polkitRule, as a
+// rule and, for polkit before 0.106, as a local authority file.
+func installPolkit(o Options) error {
+	installed := false
+	if fi, err := os.Stat(filepath.Dir(actions.PolkitRulePath)); err == nil && fi.IsDir() {
+		if err := writeTemplate(actions.PolkitRulePath, polkitRule, o, 0o644); err != nil {
+			return err
+		}
+		installed = true
+	}
+	if fi, err := os.Stat("/etc/polkit-1/localauthority"); err == nil && fi.IsDir() {
+		if err := os.MkdirAll(filepath.Dir(actions.PolkitPKLAPath), 0o755); err != nil {
+			return err
+		}
+		if err := writeTemplate(actions.PolkitPKLAPath, polkitPKLA, o, 0o644); err != nil {
+			return err
+		}
+		installed = true
+	}
+	if !installed {
+		fmt.Println("Note: polkit not found; the service-start, service-stop, service-restart and reboot actions are unavailable")
+	}
+	return nil
+}
+
+func uninstallPolkit() error {
+	for _, path := range []string{actions.PolkitRulePath, actions.PolkitPKLAPath} {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+type systemd struct{}
+
+func (systemd) Name() string { return "systemd" }
+
+func (systemd) Install(o Options) error {
+	if err := writeTemplate(systemdUnitPath, systemdUnit, o, 0o644); err != nil {
+		return err
+	}
+	if err := installPolkit(o); err != nil {
+		return fmt.Errorf("install polkit rule: %w", err)
+	}
+	if err := run("systemctl", "daemon-reload"); err != nil {
+		return err
+	}
+	return run("systemctl", "enable", Name)
+}
+
+func (systemd) Uninstall() error {
+	if _, err := os.Stat(systemdUnitPath); errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%s is not installed", Name)
+	}
+	_ = run("systemctl", "disable", "--now", Name)
+	if err := os.Remove(systemdUnitPath); err != nil {
+		return err
+	}
+	if err := uninstallPolkit(); err != nil {
+		return err
+	}
+	return run("systemctl", "daemon-reload")
+}
+
+// Start restarts a running service so a reinstall picks up the new unit.
+func (systemd) Start() error { return run("systemctl", "restart", Name) }
+func (systemd) Stop() error  { return run("systemctl", "stop", Name) }
+
+func (systemd) Status() (string, error) {
+	// systemctl status exits non-zero for stopped units; the output says why
+	out, _ := exec.Command("systemctl", "status", "--no-pager", Name).CombinedOutput()
+	return string(out), nil
+}
+
+// --- OpenRC ---
+
+var openrcScript = template.Must(template.New("openrc").Parse(`#!/sbin/openrc-run
+# Installed by "lute-agent service install"
+
+name="{{.Name}}"
+description="Lute monitoring agent"
+command="{{.BinaryPath}}"
+command_args="--config {{.ConfigPath}} --state-dir {{.StateDir}}"
+command_user="{{.User}}:{{.User}}"
+supervisor=supervise-daemon
+# Lets file integrity monitoring read root-only files and uploads replace
+# them under files.write (OpenRC 0.45 and later)
+capabilities="^cap_dac_read_search{{if .WritePaths}},^cap_dac_override,^cap_chown,^cap_fowner{{end}}"
+respawn_delay=5
+respawn_max=0
+pidfile="/run/${RC_SVCNAME}.pid"
+output_log="{{.LogPath}}"
+error_log="{{.LogPath}}"
+
+depend() {
+	need net
+	after firewall
+}
+
+start_pre() {
+	export LUTE_SUPERVISED=1
+	if [ -f "{{.EnvFile}}" ]; then
+		set -a
+		. "{{.EnvFile}}"
+		set +a
+	fi
+	checkpath -f -m 0640 -o "{{.User}}:{{.User}}" "{{.LogPath}}"
+}
+`))
+
+type openrc struct{}
+
+func (openrc) Name() string { return "OpenRC" }
+
+func (openrc) Install(o Options) error {
+	if err := writeTemplate(initScriptPath, openrcScript, o, 0o755); err != nil {
+		return err
+	}
+	return run("rc-update", "add", Name, "default")
+}
+
+func (openrc) Uninstall() error {
+	if _, err := os.Stat(initScriptPath); errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%s is not installed", Name)
+	}
+	_ = run("rc-service", Name, "stop")
+	_ = run("rc-update", "del", Name, "default")
+	return os.Remove(initScriptPath)
+}
+
+func (openrc) Start() error { return run("rc-service", Name, "restart") }
+func (openrc) Stop() error  { return run("rc-service", Name, "stop") }
+
+func (openrc) Status() (string, error) {
+	out, _ := exec.Command("rc-service", Name, "status").CombinedOutput()
+	return string(out), nil
+}
+
+// --- SysV init ---
+
+// The script keeps a small shell loop as root that restarts the agent after
+// it exits; the agent itself runs as the service user via su.
+var sysvScript = template.Must(template.New("sysv").Parse(`#!/bin/sh
+### BEGIN INIT INFO
+# Provides:          {{.Name}}
+# Required-Start:    $network $remote_fs
+# Required-Stop:     $network $remote_fs
+# Default-Start:     2 3 4 5
+# Default-Stop:      0 1 6
+# Short-Description: Lute monitoring agent
+### END INIT INFO
+# Installed by "lute-agent service install"
+
+NAME="{{.Name}}"
+DAEMON="{{.BinaryPath}}"
+DAEMON_ARGS="--config {{.ConfigPath}} --state-dir {{.StateDir}}"
+RUN_AS="{{.User}}"
+PIDFILE="{{.PIDPath}}"
+LOGFILE="{{.LogPath}}"
+ENVFILE="{{.EnvFile}}"
+
+running() {
+	[ -f "$PIDFILE" ] && kill -0 "$(cat "$PIDFILE")" 2>/dev/null
+}
+
+start() {
+	if running; then
+		echo "$NAME is already running"
+		return 0
+	fi
+	export LUTE_SUPERVISED=1
+	if [ -f "$ENVFILE" ]; then
+		set -a
+		. "$ENVFILE"
+		set +a
+	fi
+	touch "$LOGFILE"
+	chown "$RUN_AS" "$LOGFILE"
+	chmod 0640 "$LOGFILE"
+	(
+		trap 'kill "$child" 2>/dev/null; wait "$child"; exit 0' TERM
+		while :; do
+			su -s /bin/sh "$RUN_AS" -c "exec \"$DAEMON\" $DAEMON_ARGS" >>"$LOGFILE" 2>&1 &
+			child=$!
+			wait "$child"
+			sleep 5
+		done
+	) </dev/null >/dev/null 2>&1 &
+	echo $! >"$PIDFILE"
+	echo "Started $NAME"
+}
+
+stop() {
+	if ! running; then
+		echo "$NAME is not running"
+		rm -f "$PIDFILE"
+		return 0
+	fi
+	kill "$(cat "$PIDFILE")"
+	rm -f "$PIDFILE"
+	echo "Stopped $NAME"
+}
+
+case "$1" in
+	start) start ;;
+	stop) stop ;;
+	restart) stop; sleep 1; start ;;
+	status)
+		if running; then
+			echo "$NAME is running (pid $(cat "$PIDFILE"))"
+		else
+			echo "$NAME is not running"
+			exit 3
+		fi
+		;;
+	*)
+		echo "Usage: $0 {start|stop|restart|status}"
+		exit 2
+		;;
+esac
+`))
+
+type sysv struct{}
+
+func (sysv) Name() string { return "SysV init" }
+
+func (sysv) Install(o Options) error {
+	if err := writeTemplate(initScriptPath, sysvScript, o, 0o755); err != nil {
+		return err
+	}
+	if _, err := exec.LookPath("update-rc.d"); err == nil {
+		return run("update-rc.d", Name, "defaults")
+	}
+	if _, err := exec.LookPath("chkconfig"); err == nil {
+		return run("chkconfig", "--add", Name)
+	}
+	fmt.Printf("Note: enable %s in your runlevels manually\n", initScriptPath)
+	return nil
+}
+
+func (s sysv) Uninstall() error {
+	if _, err := os.Stat(initScriptPath); errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%s is not installed", Name)
+	}
+	_ = s.Stop()
+	if _, err := exec.LookPath("update-rc.d"); err == nil {
+		_ = run("update-rc.d", "-f", Name, "remove")
+	} else if _, err := exec.LookPath("chkconfig"); err == nil {
+		_ = run("chkconfig", "--del", Name)
+	}
+	return os.Remove(initScriptPath)
+}
+
+func (sysv) Start() error { return run(initScriptPath, "restart") }
+func (sysv) Stop() error  { return run(initScriptPath, "stop") }
+
+func (sysv) Status() (string, error) {
+	b, err := os.ReadFile(pidPath)
+	if err != nil {
+		return Name + " is not running\n", nil
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil || syscall.Kill(pid, 0) != nil {
+		return Name + " is not running\n", nil
+	}
+	return fmt.Sprintf("%s is running (pid %d)\n", Name, pid), nil
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package service
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// detect reports that service management is only implemented for Linux.
+func detect() (manager, error) {
+	return nil, fmt.Errorf("service management is not supported on %s; run the agent under your platform's service manager", runtime.GOOS)
+}
+
+func ensureUser(name, home string) error {
+	return nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lute/agent/config"
+	"github.com/lute/agent/logging"
+	"github.com/lute/agent/metrics"
+
+	pb "github.com/lute/agent/proto/agent"
+)
+
+// settings are the agent settings the server may change while the agent
+// runs: collectors, intervals and log level. A pushed AgentConfig replaces
+// the previous one as a whole; what it leaves unset comes from the local
+// configuration. Pushed settings are not persisted: after a restart the
+// local ones apply until the server pushes its config again on connect.
+type settings struct {
+	local      *config.Config
+	buffer     *telemetryBuffer
+	inv        *inventoryCollector
+	unitStates *unitCollector
+
+	collectors atomic.Pointer[[]string]
+
+	mu      sync.Mutex
+	version string // of the AgentConfig last applied
+}
+
+func newSettings(cfg *config.Config, buffer *telemetryBuffer, inv *inventoryCollector, unitStates *unitCollector) *settings {
+	s := &settings{local: cfg, buffer: buffer, inv: inv, unitStates: unitStates}
+	s.collectors.Store(&cfg.Collectors)
+	return s
+}
+
+// Collectors returns the metrics collectors to send with each heartbeat.
+func (s *settings) Collectors() []string {
+	return *s.collectors.Load()
+}
+
+// apply puts c into effect and returns its acknowledgement. Invalid values
+// are reported in the acknowledgement and keep their local setting.
+func (s *settings) apply(c *pb.AgentConfig) *pb.ConfigApplied {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var problems []string
+
+	level, _ := logging.ParseLevel(s.local.LogLevel) // checked by config.Finalize
+	if c.GetLogLevel() != "" {
+		if l, err := logging.ParseLevel(c.GetLogLevel()); err != nil {
+			problems = append(problems, "log_level: "+err.Error())
+		} else {
+			level = l
+		}
+	}
+	logging.SetLevel(level)
+
+	collectors := s.local.Collectors
+	if len(c.GetCollectors()) > 0 {
+		var valid []string
+		for _, name := range c.GetCollectors() {
+			if metrics.ValidCollector(name) {
+				valid = append(valid, name)
+			} else {
+				problems = append(problems, fmt.Sprintf("unknown collector %q", name))
+			}
+		}
+		if len(valid) > 0 {
+			collectors = valid
+		}
+	}
+	s.collectors.Store(&collectors)
+	s.buffer.setCollectors(collectors)
+
+	s.buffer.setInterval(seconds(c.GetOfflineSampleSeconds(), s.local.Intervals.OfflineSample))
+	s.inv.setInterval(seconds(c.GetInventorySeconds(), s.local.Intervals.Inventory))
+	s.unitStates.setInterval(seconds(c.GetUnitsSeconds(), s.local.Intervals.Units))
+
+	if c.GetVersion() != s.version {
+		log.Printf("Applied configuration %s from the server", c.GetVersion())
+		s.version = c.GetVersion()
+	}
+	if len(problems) > 0 {
+		logging.Warnf("Configuration %s: %s", c.GetVersion(), strings.Join(problems, "; "))
+	}
+	return &pb.ConfigApplied{Version: c.GetVersion(), Error: strings.Join(problems, "; ")}
+}
+
+// seconds returns n seconds, or def when n is 0.
+func seconds(n uint32, def time.Duration) time.Duration {
+	if n == 0 {
+		return def
+	}
+	return time.Duration(n) * time.Second
+}
+
+// tickInterval is the period of a collector's ticker, which may change
+// while the collector runs.
+type tickInterval struct {
+	d       atomic.Int64
+	changed chan struct{}
+}
+
+func newTickInterval(d time.Duration) *tickInterval {
+	t := &tickInterval{changed: make(chan struct{}, 1)}
+	t.d.Store(int64(d))
+	return t
+}
+
+func (t *tickInterval) get() time.Duration {
+	return time.Duration(t.d.Load())
+}
+
+// set changes the period; the collector resets its ticker on the next
+// receive from changed.
+func (t *tickInterval) set(d time.Duration) {
+	if time.Duration(t.d.Swap(int64(d))) == d {
+		return
+	}
+	select {
+	case t.changed <- struct{}{}:
+	default:
+	}
+}
+
+// wait blocks until ticker, which runs at this period, fires, resetting it
+// when the period changes. It returns false when ctx is done.
+func (t *tickInterval) wait(ctx context.Context, ticker *time.Ticker) bool {
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-t.changed:
+			ticker.Reset(t.get())
+		case <-ticker.C:
+			return true
+		}
+	}
+}
@@ -12,14 +12,22 @@ import (
 	"os/exec"
 	"runtime"
 	"strings"
+	"time"
 
+	"github.com/lute/agent/certs"
+	"github.com/lute/agent/config"
 	"github.com/lute/agent/setup/types"
+	"github.com/lute/agent/state"
 	"github.com/lute/agent/utils"
 )
 
 // Run executes the interactive setup process.
-// claimCode is optional; when set, the new machine is linked to that user.
-func Run(apiURL, version, buildTime, claimCode string) {
+// claimCode (from the UI) or cfg.EnrollmentToken (for automated provisioning)
+// decides who owns the new machine. cfg.Labels are sent with the registration
+// and stored on the machine. The issued credentials are stored under
+// cfg.CertDir and the registration in cfg.StateDir, so the started agent
+// reconnects as the same machine after a restart.
+func Run(cfg *config.Config, version, buildTime, claimCode string) {
 	reader := bufio.NewReader(os.Stdin)
 
 	fmt.Println()
@@ -33,14 +41,14 @@ func Run(apiURL, version, buildTime, claimCode string) {
 	serviceName := promptServiceName(reader)
 
 	// 2. Collect system information
-	sysInfo := collectSystemInfo(serviceName, version, buildTime, claimCode)
+	sysInfo := collectSystemInfo(serviceName, version, buildTime, claimCode, cfg.EnrollmentToken, cfg.Labels)
 	displaySystemInfo(sysInfo)
 
 	// 3. Register with the server
-	setupResp := registerWithServer(apiURL, sysInfo)
+	setupResp := registerWithServer(cfg, sysInfo)
 
 	// 4. Auto-start the agent in detached (background) mode
-	startAgent(setupResp, version)
+	startAgent(setupResp, cfg)
 }
 
 // promptServiceName prompts the user for a service name
@@ -58,7 +66,7 @@ func promptServiceName(reader *bufio.Reader) string {
 }
 
 // collectSystemInfo gathers system information
-func collectSystemInfo(serviceName, version, buildTime, claimCode string) *types.SetupRequest {
+func collectSystemInfo(serviceName, version, buildTime, claimCode, enrollmentToken string, labels map[string]string) *types.SetupRequest {
 	fmt.Println()
 	fmt.Println("Collecting system information...")
 
@@ -66,14 +74,16 @@ func collectSystemInfo(serviceName, version, buildTime, claimCode string) *types
 	localIP := utils.GetLocalIP()
 
 	req := &types.SetupRequest{
-		Name:      serviceName,
-		Hostname:  hostname,
-		OS:        runtime.GOOS,
-		Arch:      runtime.GOARCH,
-		CPUs:      runtime.NumCPU(),
-		IP:        localIP,
-		Version:   version,
-		ClaimCode: claimCode,
+		Name:            serviceName,
+		Hostname:        hostname,
+		OS:              runtime.GOOS,
+		Arch:            runtime.GOARCH,
+		CPUs:            runtime.NumCPU(),
+		IP:              localIP,
+		Version:         version,
+		ClaimCode:       claimCode,
+		Labels:          labels,
+		EnrollmentToken: enrollmentToken,
 		Metadata: map[string]string{
 			"go_version": runtime.Version(),
 			"build_time": buildTime,
@@ -90,55 +100,95 @@ func displaySystemInfo(sysInfo *types.SetupRequest) {
 	fmt.Printf("  Arch:     %s\n", sysInfo.Arch)
 	fmt.Printf("  CPUs:     %d\n", sysInfo.CPUs)
 	fmt.Printf("  IP:       %s\n", sysInfo.IP)
+	if len(sysInfo.Labels) > 0 {
+		fmt.Printf("  Labels:   %v\n", sysInfo.Labels)
+	}
 	fmt.Println()
 }
 
-// registerWithServer sends registration request to the server
-func registerWithServer(apiURL string, sysInfo *types.SetupRequest) *types.SetupResponse {
-	fmt.Printf("Registering with server at %s ...\n", apiURL)
+// registerWithServer sends registration request to the server and stores
+// the returned credentials and registration
+func registerWithServer(cfg *config.Config, sysInfo *types.SetupRequest) *types.SetupResponse {
+	fmt.Printf("Registering with server at %s ...\n", cfg.API)
 
-	body, err := json.Marshal(sysInfo)
+	setupResp, _, err := Register(cfg, sysInfo)
 	if err != nil {
-		log.Fatalf("Failed to serialize request: %v", err)
+		log.Fatalf("Registration failed: %v", err)
 	}
 
-	url := strings.TrimRight(apiURL, "/") + "/api/v1/agent/register"
+	fmt.Println()
+	fmt.Println("✓ Machine registered successfully!")
+	fmt.Printf("  Machine ID: %s\n", setupResp.MachineID)
+	fmt.Printf("  Certificate: %s (expires %s)\n", certs.ForMachine(cfg.CertDir, setupResp.MachineID).Dir, setupResp.CertificateExpiresAt.Format("2006-01-02"))
+	fmt.Printf("  State:       %s\n", cfg.StateDir)
+	fmt.Println()
+
+	return setupResp
+}
+
+// Register generates the agent key, sends req with its CSR to
+// POST /api/v1/agent/register, stores the issued certificate and agent
+// token under cfg.CertDir and saves the registration in cfg.StateDir. Both
+// the interactive setup and an agent started without a registration use it.
+func Register(cfg *config.Config, req *types.SetupRequest) (*types.SetupResponse, *state.State, error) {
+	key, csrPEM, err := certs.NewKey(req.Hostname)
+	if err != nil {
+		return nil, nil, fmt.Errorf("generate agent key: %w", err)
+	}
+	req.CSR = string(csrPEM)
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("serialize request: %w", err)
+	}
+
+	url := strings.TrimRight(cfg.API, "/") + "/api/v1/agent/register"
 	resp, err := http.Post(url, "application/json", bytes.NewReader(body))
 	if err != nil {
-		log.Fatalf("Failed to connect to server: %v", err)
+		return nil, nil, fmt.Errorf("connect to server: %w", err)
 	}
 	defer resp.Body.Close()
 
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		log.Fatalf("Failed to read response: %v", err)
+		return nil, nil, fmt.Errorf("read response: %w", err)
 	}
-
 	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
-		log.Fatalf("Server returned error %d: %s", resp.StatusCode, string(respBody))
+		return nil, nil, fmt.Errorf("server returned error %d: %s", resp.StatusCode, string(respBody))
 	}
 
 	var setupResp types.SetupResponse
 	if err := json.Unmarshal(respBody, &setupResp); err != nil {
-		log.Fatalf("Failed to parse response: %v", err)
+		return nil, nil, fmt.Errorf("parse response: %w", err)
 	}
 
-	fmt.Println()
-	fmt.Println("✓ Machine registered successfully!")
-	fmt.Printf("  Machine ID: %s\n", setupResp.MachineID)
-	fmt.Println()
-
-	return &setupResp
+	store := certs.ForMachine(cfg.CertDir, setupResp.MachineID)
+	if err := store.Save(key, []byte(setupResp.Certificate), []byte(setupResp.CACertificate), setupResp.GRPCServerName); err != nil {
+		return nil, nil, fmt.Errorf("store client certificate: %w", err)
+	}
+	if err := store.SaveToken(setupResp.AgentToken); err != nil {
+		return nil, nil, fmt.Errorf("store agent token: %w", err)
+	}
+	st := &state.State{
+		MachineID:    setupResp.MachineID,
+		GRPCAddress:  setupResp.GRPCAddress,
+		APIURL:       cfg.API,
+		RegisteredAt: time.Now().UTC(),
+	}
+	if err := state.Save(cfg.StateDir, st); err != nil {
+		return nil, nil, fmt.Errorf("save agent state: %w", err)
+	}
+	return &setupResp, st, nil
 }
 
 // startAgent starts the agent in background mode
-func startAgent(setupResp *types.SetupResponse, version string) {
+func startAgent(setupResp *types.SetupResponse, cfg *config.Config) {
 	fmt.Println("Starting agent in background...")
 
 	exePath, err := os.Executable()
 	if err != nil {
 		log.Printf("Warning: cannot find own binary path: %v", err)
-		displayManualInstructions(setupResp)
+		displayManualInstructions(cfg)
 		return
 	}
 
@@ -146,27 +196,25 @@ func startAgent(setupResp *types.SetupResponse, version string) {
 	lf, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
 	if err != nil {
 		log.Printf("Warning: cannot open log file %s: %v", logFile, err)
-		displayManualInstructions(setupResp)
+		displayManualInstructions(cfg)
 		return
 	}
 	defer lf.Close()
 
-	cmd := createAgentCommand(exePath, setupResp, lf)
+	cmd := createAgentCommand(exePath, cfg, lf)
 	if err := cmd.Start(); err != nil {
 		log.Printf("Warning: failed to start agent: %v", err)
-		displayManualInstructions(setupResp)
+		displayManualInstructions(cfg)
 		return
 	}
 
 	displayStartupInfo(cmd.Process.Pid, logFile)
 }
 
-// createAgentCommand creates the command to start the agent
-func createAgentCommand(exePath string, setupResp *types.SetupResponse, logFile *os.File) *exec.Cmd {
-	cmd := exec.Command(exePath,
-		"--server", setupResp.GRPCAddress,
-		"--machine-id", setupResp.MachineID,
-	)
+// createAgentCommand creates the command to start the agent. The machine ID
+// and server address are read back from the state directory.
+func createAgentCommand(exePath string, cfg *config.Config, logFile *os.File) *exec.Cmd {
+	cmd := exec.Command(exePath, agentArgs(cfg)...)
 	cmd.Stdout = logFile
 	cmd.Stderr = logFile
 
@@ -177,10 +225,25 @@ func createAgentCommand(exePath string, setupResp *types.SetupResponse, logFile
 }
 
 // displayManualInstructions shows manual start instructions
-func displayManualInstructions(setupResp *types.SetupResponse) {
+func displayManualInstructions(cfg *config.Config) {
 	fmt.Println("Could not auto-start. Run manually:")
-	fmt.Printf("  lute-agent --server %s --machine-id %s\n",
-		setupResp.GRPCAddress, setupResp.MachineID)
+	fmt.Printf("  lute-agent %s\n", strings.Join(agentArgs(cfg), " "))
+}
+
+// agentArgs returns the flags the started agent needs to find its config
+// and state; everything else comes from those.
+func agentArgs(cfg *config.Config) []string {
+	args := []string{"--state-dir", cfg.StateDir}
+	if cfg.Path != "" {
+		args = append(args, "--config", cfg.Path)
+	}
+	if cfg.Server != "" {
+		args = append(args, "--server", cfg.Server)
+	}
+	if cfg.CertDir != state.CertDir(cfg.StateDir) {
+		args = append(args, "--cert-dir", cfg.CertDir)
+	}
+	return args
 }
 
 // displayStartupInfo displays information about the started agent
@@ -191,4 +254,7 @@ func displayStartupInfo(pid int, logFile string) {
 	fmt.Println("Manage:")
 	fmt.Printf("  Stop:   kill %d\n", pid)
 	fmt.Printf("  Logs:   tail -f %s\n", logFile)
+	fmt.Println()
+	fmt.Println("This process does not survive a reboot. To run the agent as a system service:")
+	fmt.Println("  sudo lute-agent service install")
 }
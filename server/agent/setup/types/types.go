@@ -1,5 +1,7 @@
 package types
 
+import "time"
+
 // SetupRequest is sent to the server to register a new machine
 type SetupRequest struct {
 	Name      string            `json:"name"`
@@ -10,14 +12,23 @@ type SetupRequest struct {
 	IP        string            `json:"ip"`
 	Version   string            `json:"version"`
 	Metadata  map[string]string `json:"metadata,omitempty"`
-	ClaimCode string            `json:"claim_code,omitempty"` // optional; links machine to user
+	Labels    map[string]string `json:"labels,omitempty"`     // key/value labels declared by the agent
+	ClaimCode string            `json:"claim_code,omitempty"` // single-use code from the UI
+	// EnrollmentToken is a reusable token for automated provisioning (used instead of ClaimCode)
+	EnrollmentToken string `json:"enrollment_token,omitempty"`
+	CSR             string `json:"csr"` // PEM CSR for the agent's mTLS client certificate
 }
 
 // SetupResponse is returned by the server after registration
 type SetupResponse struct {
-	MachineID   string `json:"machine_id"`
-	GRPCAddress string `json:"grpc_address"`
-	Message     string `json:"message"`
+	MachineID            string    `json:"machine_id"`
+	GRPCAddress          string    `json:"grpc_address"`
+	GRPCServerName       string    `json:"grpc_server_name"`
+	Certificate          string    `json:"certificate"`
+	CACertificate        string    `json:"ca_certificate"`
+	CertificateExpiresAt time.Time `json:"certificate_expires_at"`
+	AgentToken           string    `json:"agent_token"`
+	Message              string    `json:"message"`
 }
 
 // PendingCmd represents a command received from the server config
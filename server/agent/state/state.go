@@ -0,0 +1,96 @@
+// Package state persists what the agent learns when it registers, so a
+// restart reconnects as the same machine instead of registering a new one.
+//
+// Layout of the state directory:
+//
+//	<dir>/
+//	  state.json           machine ID and gRPC address
+//	  certs/<machine-id>/  key, certificates and agent token (see package certs)
+//	  buffer/              metrics sampled while offline (see package wal)
+//	  fim/                 file integrity baseline and unsent file events
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const stateFile = "state.json"
+
+// State is the agent's registration.
+type State struct {
+	MachineID    string    `json:"machine_id"`
+	GRPCAddress  string    `json:"grpc_address"`
+	APIURL       string    `json:"api_url"`
+	RegisteredAt time.Time `json:"registered_at"`
+}
+
+// DefaultDir returns the default state directory
+// (e.g. ~/.config/lute-agent on Linux).
+func DefaultDir() string {
+	if dir, err := os.UserConfigDir(); err == nil {
+		return filepath.Join(dir, "lute-agent")
+	}
+	return ".lute-agent"
+}
+
+// CertDir returns the directory holding the credentials of each machine.
+func CertDir(dir string) string {
+	return filepath.Join(dir, "certs")
+}
+
+// BufferDir returns the directory of the telemetry buffer kept while the
+// agent is offline.
+func BufferDir(dir string) string {
+	return filepath.Join(dir, "buffer")
+}
+
+// FIMDir returns the directory of the file integrity baseline and of the
+// queue of file events not yet acknowledged by the server.
+func FIMDir(dir string) string {
+	return filepath.Join(dir, "fim")
+}
+
+// TransferDir returns the directory of partly received file transfers.
+func TransferDir(dir string) string {
+	return filepath.Join(dir, "transfers")
+}
+
+// Load reads the state from dir. It returns nil and no error when the agent
+// has not registered yet.
+func Load(dir string) (*State, error) {
+	data, err := os.ReadFile(filepath.Join(dir, stateFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var s State
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	if s.MachineID == "" {
+		return nil, nil
+	}
+	return &s, nil
+}
+
+// Save writes the state to dir, replacing the previous one atomically.
+func Save(dir string, s *State) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, stateFile+".tmp")
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, stateFile))
+}
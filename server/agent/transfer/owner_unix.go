@@ -0,0 +1,25 @@
+//go:build !windows
+
+package transfer
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// keepOwner gives f the owner and group of the file at target, if any.
+func keepOwner(f *os.File, target string) error {
+	info, err := os.Stat(target)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	return f.Chown(int(st.Uid), int(st.Gid))
+}
@@ -0,0 +1,9 @@
+package transfer
+
+import "os"
+
+// keepOwner does nothing on Windows; a replaced file gets the directory's
+// inherited permissions.
+func keepOwner(f *os.File, target string) error {
+	return nil
+}
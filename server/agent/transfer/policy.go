@@ -0,0 +1,89 @@
+package transfer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNotAllowed is returned for paths outside the agent's allowlists.
+var ErrNotAllowed = errors.New("path is not allowed by the agent's policy")
+
+// Policy decides which files can be copied.
+type Policy struct {
+	// Read and Write are the allowlists for copying files from and to the
+	// machine: directories (everything below them) or glob patterns.
+	Read    []string
+	Write   []string
+	MaxSize int64 // bytes
+	// Dir keeps partly received files.
+	Dir string
+}
+
+// readable checks that path may be read. Symbolic links must stay within
+// the allowlist too.
+func (p Policy) readable(path string) (string, error) {
+	path, err := cleanPath(path)
+	if err != nil {
+		return "", err
+	}
+	if !allowed(p.Read, path) {
+		return "", ErrNotAllowed
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	if !allowed(p.Read, resolved) {
+		return "", ErrNotAllowed
+	}
+	return resolved, nil
+}
+
+// writable checks that path may be written. Its directory must exist; a
+// symbolic link in its place is replaced rather than followed.
+func (p Policy) writable(path string) (string, error) {
+	path, err := cleanPath(path)
+	if err != nil {
+		return "", err
+	}
+	if !allowed(p.Write, path) {
+		return "", ErrNotAllowed
+	}
+	dir, err := filepath.EvalSymlinks(filepath.Dir(path))
+	if err != nil {
+		return "", err
+	}
+	resolved := filepath.Join(dir, filepath.Base(path))
+	if !allowed(p.Write, resolved) {
+		return "", ErrNotAllowed
+	}
+	if info, err := os.Lstat(resolved); err == nil && info.IsDir() {
+		return "", fmt.Errorf("%s is a directory", resolved)
+	}
+	return resolved, nil
+}
+
+func cleanPath(path string) (string, error) {
+	if !filepath.IsAbs(path) {
+		return "", fmt.Errorf("%q is not an absolute path", path)
+	}
+	return filepath.Clean(path), nil
+}
+
+// allowed reports whether path is one of entries, below one of them or
+// matches one as a glob.
+func allowed(entries []string, path string) bool {
+	for _, entry := range entries {
+		dir := strings.TrimSuffix(filepath.Clean(entry), string(filepath.Separator))
+		if path == dir || strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			return true
+		}
+		if ok, _ := filepath.Match(entry, path); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,380 @@
+// Package transfer copies files between the server and the machine in
+// chunks over the agent stream. Transfers resume where they stopped after a
+// reconnect: files being received are kept in the policy's directory until
+// complete, and files being sent are read again from the offset the server
+// asks for. Both ends check the size and SHA-256 of the whole file.
+package transfer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	pb "github.com/lute/agent/proto/agent"
+)
+
+const (
+	defaultChunkSize = 256 << 10
+	maxChunkSize     = 1 << 20
+	// idleTimeout drops transfers the server stopped driving; a later
+	// FileTransfer resumes them.
+	idleTimeout = time.Hour
+	// partRetention is how long partly received files are kept.
+	partRetention = 7 * 24 * time.Hour
+)
+
+// Manager handles the file transfers of the agent. It is driven from the
+// stream loop and is not safe for concurrent use.
+type Manager struct {
+	policy    Policy
+	incoming  map[string]*incoming
+	outgoing  map[string]*outgoing
+	lastSweep time.Time
+}
+
+// incoming is a file being written to the machine.
+type incoming struct {
+	req      *pb.FileTransfer
+	target   string
+	part     *os.File
+	received int64
+	lastUsed time.Time
+}
+
+// outgoing is a file being read from the machine.
+type outgoing struct {
+	id        string
+	file      *os.File
+	size      int64
+	sha256    string
+	chunkSize int64
+	lastUsed  time.Time
+}
+
+// NewManager returns a Manager and removes stale partly received files.
+func NewManager(policy Policy) *Manager {
+	if entries, err := os.ReadDir(policy.Dir); err == nil {
+		for _, e := range entries {
+			info, err := e.Info()
+			if err == nil && strings.HasSuffix(e.Name(), ".part") && time.Since(info.ModTime()) > partRetention {
+				os.Remove(filepath.Join(policy.Dir, e.Name()))
+			}
+		}
+	}
+	return &Manager{
+		policy:   policy,
+		incoming: make(map[string]*incoming),
+		outgoing: make(map[string]*outgoing),
+	}
+}
+
+// Start begins or resumes a transfer. It returns the first chunk of a file
+// to send or the status of a file to receive.
+func (m *Manager) Start(req *pb.FileTransfer) (*pb.FileChunk, *pb.FileTransferStatus) {
+	m.sweep()
+	switch req.GetDirection() {
+	case pb.FileDirection_FILE_DIRECTION_TO_AGENT:
+		in, err := m.openIncoming(req)
+		if err != nil {
+			return nil, failed(req.GetId(), err)
+		}
+		log.Printf("File transfer %s: receiving %s (%d of %d bytes present)", req.GetId(), in.target, in.received, req.GetSize())
+		return nil, &pb.FileTransferStatus{Id: req.GetId(), Offset: in.received}
+	case pb.FileDirection_FILE_DIRECTION_FROM_AGENT:
+		out, err := m.openOutgoing(req)
+		if err != nil {
+			return nil, failed(req.GetId(), err)
+		}
+		log.Printf("File transfer %s: sending %s (%d bytes from offset %d)", req.GetId(), req.GetPath(), out.size, req.GetOffset())
+		chunk, err := out.chunk(req.GetOffset())
+		if err != nil {
+			m.closeOutgoing(out.id)
+			return nil, failed(req.GetId(), err)
+		}
+		return chunk, nil
+	default:
+		return nil, failed(req.GetId(), errors.New("unknown direction"))
+	}
+}
+
+// Receive stores a chunk of a file being written to the machine and
+// acknowledges it. After the last chunk the file is checked and put in place.
+func (m *Manager) Receive(c *pb.FileChunk) *pb.FileTransferStatus {
+	in := m.incoming[c.GetId()]
+	if in == nil {
+		return failed(c.GetId(), errors.New("unknown transfer"))
+	}
+	in.lastUsed = time.Now()
+	if c.GetOffset() != in.received {
+		// A chunk resent after a reconnect; ask for what is missing.
+		return &pb.FileTransferStatus{Id: c.GetId(), Offset: in.received}
+	}
+	if in.received+int64(len(c.GetData())) > in.req.GetSize() {
+		m.abortIncoming(in, true)
+		return failed(c.GetId(), errors.New("more data than the announced size"))
+	}
+	if _, err := in.part.Write(c.GetData()); err != nil {
+		m.abortIncoming(in, false)
+		return failed(c.GetId(), err)
+	}
+	in.received += int64(len(c.GetData()))
+	if !c.GetEof() {
+		return &pb.FileTransferStatus{Id: c.GetId(), Offset: in.received}
+	}
+	if err := in.install(); err != nil {
+		m.abortIncoming(in, true)
+		return failed(c.GetId(), err)
+	}
+	delete(m.incoming, c.GetId())
+	log.Printf("File transfer %s: wrote %s (%d bytes)", c.GetId(), in.target, in.received)
+	return &pb.FileTransferStatus{Id: c.GetId(), Offset: in.received, Done: true}
+}
+
+// Acknowledged handles the server's status for a file being sent. It
+// returns the next chunk, a status abandoning the transfer if the chunk
+// cannot be read, or neither once the transfer is over. An error status for
+// a file being received discards what was received.
+func (m *Manager) Acknowledged(s *pb.FileTransferStatus) (*pb.FileChunk, *pb.FileTransferStatus) {
+	if in := m.incoming[s.GetId()]; in != nil && s.GetError() != "" {
+		log.Printf("File transfer %s: stopped by the server: %s", s.GetId(), s.GetError())
+		m.abortIncoming(in, true)
+		return nil, nil
+	}
+	out := m.outgoing[s.GetId()]
+	if out == nil {
+		return nil, nil
+	}
+	if s.GetDone() || s.GetError() != "" {
+		if s.GetError() != "" {
+			log.Printf("File transfer %s: stopped by the server: %s", s.GetId(), s.GetError())
+		} else {
+			log.Printf("File transfer %s: sent (%d bytes)", s.GetId(), out.size)
+		}
+		m.closeOutgoing(s.GetId())
+		return nil, nil
+	}
+	out.lastUsed = time.Now()
+	chunk, err := out.chunk(s.GetOffset())
+	if err != nil {
+		m.closeOutgoing(s.GetId())
+		return nil, failed(s.GetId(), err)
+	}
+	return chunk, nil
+}
+
+func (m *Manager) openIncoming(req *pb.FileTransfer) (*incoming, error) {
+	if in := m.incoming[req.GetId()]; in != nil {
+		in.lastUsed = time.Now()
+		return in, nil
+	}
+	target, err := m.policy.writable(req.GetPath())
+	if err != nil {
+		return nil, err
+	}
+	if req.GetSize() < 0 || req.GetSize() > m.policy.MaxSize {
+		return nil, fmt.Errorf("file of %d bytes exceeds the agent's limit of %d", req.GetSize(), m.policy.MaxSize)
+	}
+	if err := os.MkdirAll(m.policy.Dir, 0o700); err != nil {
+		return nil, err
+	}
+	part, err := os.OpenFile(filepath.Join(m.policy.Dir, safeID(req.GetId())+".part"), os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	received, err := part.Seek(0, io.SeekEnd)
+	if err != nil || received > req.GetSize() {
+		part.Close()
+		os.Remove(part.Name())
+		if err == nil {
+			err = errors.New("partial file is larger than the file")
+		}
+		return nil, err
+	}
+	in := &incoming{req: req, target: target, part: part, received: received, lastUsed: time.Now()}
+	m.incoming[req.GetId()] = in
+	return in, nil
+}
+
+// install checks the received file and moves it into place, replacing the
+// target atomically.
+func (in *incoming) install() error {
+	if in.received != in.req.GetSize() {
+		return fmt.Errorf("received %d bytes, expected %d", in.received, in.req.GetSize())
+	}
+	if _, err := in.part.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, in.part); err != nil {
+		return err
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(sum, in.req.GetSha256()) {
+		return fmt.Errorf("checksum mismatch: got %s, expected %s", sum, in.req.GetSha256())
+	}
+
+	mode := os.FileMode(in.req.GetMode()) & os.ModePerm
+	if mode == 0 {
+		mode = 0o644
+		if info, err := os.Stat(in.target); err == nil {
+			mode = info.Mode().Perm()
+		}
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(in.target), ".lute-transfer-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := in.part.Seek(0, io.SeekStart); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := io.Copy(tmp, in.part); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	// A replaced file keeps its owner rather than becoming the agent's
+	if err := keepOwner(tmp, in.target); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), in.target); err != nil {
+		return err
+	}
+	in.part.Close()
+	os.Remove(in.part.Name())
+	return nil
+}
+
+// abortIncoming forgets a transfer, deleting what was received if discard.
+func (m *Manager) abortIncoming(in *incoming, discard bool) {
+	in.part.Close()
+	if discard {
+		os.Remove(in.part.Name())
+	}
+	delete(m.incoming, in.req.GetId())
+}
+
+func (m *Manager) openOutgoing(req *pb.FileTransfer) (*outgoing, error) {
+	m.closeOutgoing(req.GetId())
+	path, err := m.policy.readable(req.GetPath())
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		f.Close()
+		return nil, fmt.Errorf("%s is not a regular file", path)
+	}
+	limit := m.policy.MaxSize
+	if max := req.GetMaxSize(); max > 0 && max < limit {
+		limit = max
+	}
+	// A file that grows while it is sent (a log) is sent as it was at the start.
+	size := info.Size()
+	if size > limit {
+		f.Close()
+		return nil, fmt.Errorf("file of %d bytes exceeds the limit of %d", size, limit)
+	}
+	h := sha256.New()
+	if _, err := io.CopyN(h, f, size); err != nil {
+		f.Close()
+		return nil, err
+	}
+	chunkSize := int64(req.GetChunkSize())
+	if chunkSize <= 0 || chunkSize > maxChunkSize {
+		chunkSize = defaultChunkSize
+	}
+	out := &outgoing{
+		id:        req.GetId(),
+		file:      f,
+		size:      size,
+		sha256:    hex.EncodeToString(h.Sum(nil)),
+		chunkSize: chunkSize,
+		lastUsed:  time.Now(),
+	}
+	m.outgoing[req.GetId()] = out
+	return out, nil
+}
+
+// chunk reads the chunk starting at offset.
+func (out *outgoing) chunk(offset int64) (*pb.FileChunk, error) {
+	if offset < 0 || offset > out.size {
+		return nil, fmt.Errorf("offset %d is outside the file (%d bytes)", offset, out.size)
+	}
+	buf := make([]byte, min(out.chunkSize, out.size-offset))
+	if _, err := out.file.ReadAt(buf, offset); err != nil && !(errors.Is(err, io.EOF) && len(buf) == 0) {
+		return nil, err
+	}
+	c := &pb.FileChunk{Id: out.id, Offset: offset, Data: buf, Size: out.size}
+	if offset+int64(len(buf)) >= out.size {
+		c.Eof = true
+		c.Sha256 = out.sha256
+	}
+	return c, nil
+}
+
+func (m *Manager) closeOutgoing(id string) {
+	if out := m.outgoing[id]; out != nil {
+		out.file.Close()
+		delete(m.outgoing, id)
+	}
+}
+
+// sweep forgets transfers the server stopped driving. Partly received files
+// are kept so the transfer can resume.
+func (m *Manager) sweep() {
+	if time.Since(m.lastSweep) < time.Minute {
+		return
+	}
+	m.lastSweep = time.Now()
+	for _, in := range m.incoming {
+		if time.Since(in.lastUsed) > idleTimeout {
+			m.abortIncoming(in, false)
+		}
+	}
+	for id, out := range m.outgoing {
+		if time.Since(out.lastUsed) > idleTimeout {
+			m.closeOutgoing(id)
+		}
+	}
+}
+
+func failed(id string, err error) *pb.FileTransferStatus {
+	return &pb.FileTransferStatus{Id: id, Error: err.Error()}
+}
+
+// safeID keeps a transfer ID usable as a file name.
+func safeID(id string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, id)
+}
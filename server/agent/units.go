@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/lute/agent/units"
+
+	pb "github.com/lute/agent/proto/agent"
+)
+
+// unitCollector checks the state of systemd units periodically in the
+// background, so the stream loop only compares hashes. A nil collector
+// (units disabled) has nothing to report.
+type unitCollector struct {
+	watch    []string
+	interval *tickInterval
+	current  atomic.Pointer[pb.UnitReport]
+}
+
+func newUnitCollector(watch []string, interval time.Duration) *unitCollector {
+	return &unitCollector{watch: watch, interval: newTickInterval(interval)}
+}
+
+func (c *unitCollector) run(ctx context.Context) {
+	if c == nil {
+		return
+	}
+	ticker := time.NewTicker(c.interval.get())
+	defer ticker.Stop()
+	for {
+		c.current.Store(units.Collect(c.watch))
+		if !c.interval.wait(ctx, ticker) {
+			return
+		}
+	}
+}
+
+// setInterval changes how often units are checked.
+func (c *unitCollector) setInterval(d time.Duration) {
+	if c != nil {
+		c.interval.set(d)
+	}
+}
+
+// changedSince returns the latest report if its hash differs from hash, or
+// nil.
+func (c *unitCollector) changedSince(hash string) *pb.UnitReport {
+	if c == nil {
+		return nil
+	}
+	report := c.current.Load()
+	if report == nil || report.GetHash() == hash {
+		return nil
+	}
+	return report
+}
@@ -0,0 +1,206 @@
+// Package units reports the state of systemd units: the configured ones and
+// every failed unit.
+//
+// It runs systemctl(1) rather than talking to systemd over D-Bus, which
+// keeps the agent free of a D-Bus client; list-units and show are stable
+// interfaces meant for scripts. On hosts without systemd the report says so
+// and lists nothing.
+package units
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"os"
+	"os/exec"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	pb "github.com/lute/agent/proto/agent"
+)
+
+// systemctlTimeout bounds each systemctl run; a hung systemd must not stall
+// the collector forever.
+const systemctlTimeout = 15 * time.Second
+
+// showProperties are the unit properties read by systemctl show.
+var showProperties = []string{
+	"Id", "Description", "LoadState", "ActiveState", "SubState", "Result",
+	"NRestarts", "StateChangeTimestampMonotonic", "MainPID",
+}
+
+// Collect reports the units matching watch (unit names or shell globs such
+// as "postgresql@*.service") and all failed units, and sets the hash.
+func Collect(watch []string) *pb.UnitReport {
+	report := &pb.UnitReport{CollectedAt: time.Now().Unix()}
+	if booted() {
+		report.Systemd = true
+		report.Units = collect(watch)
+	}
+	report.Hash = Hash(report)
+	return report
+}
+
+// Hash returns the hex SHA-256 of the report's content. The hash and
+// collection time are left out, so two collections of unchanged units hash
+// the same.
+func Hash(report *pb.UnitReport) string {
+	content := proto.Clone(report).(*pb.UnitReport)
+	content.Hash = ""
+	content.CollectedAt = 0
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(content)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// booted reports whether the host runs systemd, like sd_booted(3).
+func booted() bool {
+	info, err := os.Stat("/run/systemd/system")
+	return err == nil && info.IsDir()
+}
+
+func collect(watch []string) []*pb.Unit {
+	var names, patterns []string
+	for _, w := range watch {
+		if strings.ContainsAny(w, "*?[") {
+			patterns = append(patterns, w)
+		} else {
+			names = append(names, w)
+		}
+	}
+	if len(patterns) > 0 {
+		names = append(names, listUnits(append([]string{"--all", "--"}, patterns...))...)
+	}
+	names = append(names, listUnits([]string{"--state=failed", "--all"})...)
+	if len(names) == 0 {
+		return nil
+	}
+	sort.Strings(names)
+	names = slices.Compact(names)
+
+	out, err := systemctl(append([]string{"show", "--property=" + strings.Join(showProperties, ","), "--"}, names...)...)
+	if err != nil && len(out) == 0 {
+		log.Printf("Units: systemctl show failed: %v", err)
+		return nil
+	}
+	boot := bootTime()
+	seen := make(map[string]bool)
+	var units []*pb.Unit
+	for _, props := range parseShow(out) {
+		u := unitFromProperties(props, boot)
+		if u.GetName() == "" || seen[u.GetName()] {
+			continue // an alias of a unit already listed
+		}
+		seen[u.GetName()] = true
+		units = append(units, u)
+	}
+	sort.Slice(units, func(i, j int) bool { return units[i].GetName() < units[j].GetName() })
+	return units
+}
+
+// listUnits returns the names of the units systemctl list-units prints for
+// args.
+func listUnits(args []string) []string {
+	out, err := systemctl(append([]string{"list-units", "--plain", "--no-legend", "--full"}, args...)...)
+	if err != nil {
+		log.Printf("Units: systemctl list-units failed: %v", err)
+		return nil
+	}
+	var names []string
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		// Failed units may be marked with a bullet even with --plain.
+		if len(fields) > 0 && (fields[0] == "●" || fields[0] == "*") {
+			fields = fields[1:]
+		}
+		if len(fields) > 0 {
+			names = append(names, fields[0])
+		}
+	}
+	return names
+}
+
+// parseShow splits systemctl show output into the properties of each unit;
+// units are separated by blank lines.
+func parseShow(out []byte) []map[string]string {
+	var all []map[string]string
+	cur := make(map[string]string)
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		line := sc.Text()
+		if line == "" {
+			if len(cur) > 0 {
+				all = append(all, cur)
+				cur = make(map[string]string)
+			}
+			continue
+		}
+		if key, value, ok := strings.Cut(line, "="); ok {
+			cur[key] = value
+		}
+	}
+	if len(cur) > 0 {
+		all = append(all, cur)
+	}
+	return all
+}
+
+func unitFromProperties(props map[string]string, boot time.Time) *pb.Unit {
+	u := &pb.Unit{
+		Name:        props["Id"],
+		Description: props["Description"],
+		LoadState:   props["LoadState"],
+		ActiveState: props["ActiveState"],
+		SubState:    props["SubState"],
+		Result:      props["Result"],
+	}
+	if n, err := strconv.ParseUint(props["NRestarts"], 10, 32); err == nil {
+		u.Restarts = uint32(n)
+	}
+	if pid, err := strconv.ParseUint(props["MainPID"], 10, 32); err == nil {
+		u.MainPid = uint32(pid)
+	}
+	// The monotonic timestamp is exact and needs no time zone parsing; 0
+	// means the state never changed since boot.
+	if usec, err := strconv.ParseInt(props["StateChangeTimestampMonotonic"], 10, 64); err == nil && usec > 0 && !boot.IsZero() {
+		u.StateChangedAt = boot.Add(time.Duration(usec) * time.Microsecond).Unix()
+	}
+	return u
+}
+
+// bootTime reads the boot time from /proc/stat (zero if unknown).
+func bootTime() time.Time {
+	data, err := os.ReadFile("/proc/stat")
+	if err != nil {
+		return time.Time{}
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if v, ok := strings.CutPrefix(line, "btime "); ok {
+			if secs, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64); err == nil {
+				return time.Unix(secs, 0)
+			}
+		}
+	}
+	return time.Time{}
+}
+
+func systemctl(args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), systemctlTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "systemctl", append([]string{"--no-pager"}, args...)...)
+	cmd.Env = append(os.Environ(), "LC_ALL=C", "SYSTEMD_COLORS=0")
+	return cmd.Output()
+}
@@ -0,0 +1,16 @@
+//go:build !windows
+
+package update
+
+import (
+	"os"
+	"syscall"
+)
+
+// restart starts the binary at exe in place of the running agent.
+func restart(exe string) error {
+	if supervised() {
+		os.Exit(0)
+	}
+	return syscall.Exec(exe, os.Args, os.Environ())
+}
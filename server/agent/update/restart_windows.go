@@ -0,0 +1,17 @@
+//go:build windows
+
+package update
+
+import (
+	"errors"
+	"os"
+)
+
+// restart exits under a service manager; otherwise the agent must be
+// restarted by hand to run the new binary.
+func restart(exe string) error {
+	if supervised() {
+		os.Exit(0)
+	}
+	return errors.New("restart the agent to run the new version")
+}
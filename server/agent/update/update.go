@@ -0,0 +1,393 @@
+// Package update replaces the agent binary with the version the server asks
+// for (see AgentUpdate in agent.proto) and rolls back when it does not work.
+//
+// An update downloads the binary next to the running executable, checks its
+// size, SHA-256 and ed25519 signature against the pinned release key, makes
+// sure it runs (--version), and swaps it in with two
+// renames, keeping the old binary as <exe>.prev. The agent then restarts:
+// under a service manager (LUTE_SUPERVISED set by "lute-agent service
+// install") it exits and is restarted, otherwise it re-executes itself.
+//
+// The new version is on probation until it hears from the server. If it has
+// not within healthTimeout, or it was restarted maxAttempts times without
+// doing so, the old binary is put back and that build is not tried again.
+// State is kept in <state-dir>/update.json.
+package update
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	pb "github.com/lute/agent/proto/agent"
+)
+
+// PublicKey is the release signing key (base64 ed25519) built into the
+// agent, set at build time with
+//
+//	-ldflags "-X github.com/lute/agent/update.PublicKey=<key>"
+//
+// An agent without a key refuses all updates.
+var PublicKey string
+
+// SupervisedEnv is set by "lute-agent service install" so the agent exits
+// instead of re-executing itself and lets the service manager restart it.
+const SupervisedEnv = "LUTE_SUPERVISED"
+
+const (
+	markerFile      = "update.json"
+	healthTimeout   = 5 * time.Minute
+	maxAttempts     = 3
+	downloadTimeout = 10 * time.Minute
+)
+
+// marker records an update between the swap and the health check, and
+// builds that were rolled back.
+type marker struct {
+	Version         string    `json:"version"`
+	SHA256          string    `json:"sha256"`
+	PreviousVersion string    `json:"previous_version"`
+	Binary          string    `json:"binary"`
+	Backup          string    `json:"backup"`
+	Attempts        int       `json:"attempts"`
+	StartedAt       time.Time `json:"started_at"`
+	RolledBack      bool      `json:"rolled_back,omitempty"`
+}
+
+// Updater applies the updates offered by the server.
+type Updater struct {
+	apiURL    string
+	version   string // running version
+	stateDir  string
+	publicKey ed25519.PublicKey // updates must be signed with its private key
+	disabled  bool
+
+	mu      sync.Mutex
+	busy    bool
+	pending *marker      // set while the running version is on probation
+	timer   *time.Timer  // rolls back when the probation times out
+	skip    *marker      // build that was rolled back
+	client  *http.Client // for downloads
+}
+
+// New creates an updater for the running version that installs binaries
+// signed for publicKey (see PinnedKey). A disabled updater, or one without a
+// key, still finishes a pending update but ignores new offers.
+func New(apiURL, version, stateDir string, publicKey ed25519.PublicKey, disabled bool) *Updater {
+	return &Updater{
+		apiURL:    strings.TrimRight(apiURL, "/"),
+		version:   version,
+		stateDir:  stateDir,
+		publicKey: publicKey,
+		disabled:  disabled,
+		client:    &http.Client{Timeout: downloadTimeout},
+	}
+}
+
+// PinnedKey returns the key updates are verified against: override when set
+// (update_public_key in the config), otherwise the key built in as PublicKey.
+// It returns nil when neither is set.
+func PinnedKey(override string) (ed25519.PublicKey, error) {
+	key := strings.TrimSpace(override)
+	if key == "" {
+		key = strings.TrimSpace(PublicKey)
+	}
+	if key == "" {
+		return nil, nil
+	}
+	if block, _ := pem.Decode([]byte(key)); block != nil {
+		parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		pub, ok := parsed.(ed25519.PublicKey)
+		if !ok {
+			return nil, errors.New("not an ed25519 public key")
+		}
+		return pub, nil
+	}
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("public key has %d bytes, expected %d", len(raw), ed25519.PublicKeySize)
+	}
+	return ed25519.PublicKey(raw), nil
+}
+
+// Resume picks up an update left by the previous process. Call it once at
+// start, before connecting. It may roll back and restart the agent.
+func (u *Updater) Resume() error {
+	m, err := u.loadMarker()
+	if err != nil || m == nil {
+		return err
+	}
+	if m.RolledBack {
+		u.skip = m
+		return nil
+	}
+	if m.Version != u.version {
+		// The old binary is running again: the swap failed or someone
+		// restored it. Do not retry this build.
+		log.Printf("Update to %s did not take effect; staying on %s", m.Version, u.version)
+		m.RolledBack = true
+		u.skip = m
+		return u.saveMarker(m)
+	}
+
+	m.Attempts++
+	if m.Attempts > maxAttempts {
+		return u.rollback(m, fmt.Sprintf("restarted %d times without reaching the server", maxAttempts))
+	}
+	if err := u.saveMarker(m); err != nil {
+		return err
+	}
+	log.Printf("Running %s after update from %s (attempt %d/%d); waiting for the server", m.Version, m.PreviousVersion, m.Attempts, maxAttempts)
+	u.mu.Lock()
+	u.pending = m
+	u.timer = time.AfterFunc(healthTimeout, func() {
+		u.mu.Lock()
+		defer u.mu.Unlock()
+		if u.pending == nil {
+			return
+		}
+		if err := u.rollback(u.pending, "no contact with the server within "+healthTimeout.String()); err != nil {
+			log.Printf("Rollback failed: %v", err)
+		}
+	})
+	u.mu.Unlock()
+	return nil
+}
+
+// Healthy ends the probation of a freshly updated agent. Call it whenever a
+// message from the server arrives; it is a no-op otherwise.
+func (u *Updater) Healthy() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	m := u.pending
+	if m == nil {
+		return
+	}
+	u.pending = nil
+	u.timer.Stop()
+	if err := os.Remove(m.Backup); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("Update: failed to remove %s: %v", m.Backup, err)
+	}
+	if err := os.Remove(filepath.Join(u.stateDir, markerFile)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("Update: failed to clear update state: %v", err)
+	}
+	log.Printf("Update to %s confirmed", m.Version)
+}
+
+// Handle starts the update described by offer in the background, unless the
+// agent runs that version already, another update is running, or the build
+// was rolled back before.
+func (u *Updater) Handle(offer *pb.AgentUpdate) {
+	if offer.GetVersion() == "" || offer.GetVersion() == u.version {
+		return
+	}
+	if u.disabled {
+		log.Printf("Server offers agent %s; updates are disabled in the config", offer.GetVersion())
+		return
+	}
+	if u.publicKey == nil {
+		log.Printf("Server offers agent %s; not updating because no release signing key is pinned (update_public_key)", offer.GetVersion())
+		return
+	}
+	if len(offer.GetSignature()) == 0 {
+		log.Printf("Server offers agent %s without a signature; not updating", offer.GetVersion())
+		return
+	}
+	u.mu.Lock()
+	if u.busy || u.pending != nil {
+		u.mu.Unlock()
+		return
+	}
+	if u.skip != nil && u.skip.Version == offer.GetVersion() && strings.EqualFold(u.skip.SHA256, offer.GetSha256()) {
+		u.mu.Unlock()
+		log.Printf("Not updating to %s: this build was rolled back before", offer.GetVersion())
+		return
+	}
+	u.busy = true
+	u.mu.Unlock()
+
+	go func() {
+		err := u.apply(offer)
+		u.mu.Lock()
+		u.busy = false
+		u.mu.Unlock()
+		if err != nil {
+			log.Printf("Update to %s failed: %v", offer.GetVersion(), err)
+		}
+	}()
+}
+
+// apply downloads, verifies and installs the offered binary, then restarts.
+func (u *Updater) apply(offer *pb.AgentUpdate) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+	log.Printf("Updating agent %s -> %s", u.version, offer.GetVersion())
+
+	tmp := filepath.Join(filepath.Dir(exe), "."+filepath.Base(exe)+".new")
+	if err := u.download(offer, tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := checkRuns(tmp, offer.GetVersion()); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	m := &marker{
+		Version:         offer.GetVersion(),
+		SHA256:          offer.GetSha256(),
+		PreviousVersion: u.version,
+		Binary:          exe,
+		Backup:          exe + ".prev",
+		StartedAt:       time.Now().UTC(),
+	}
+	if err := u.saveMarker(m); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(exe, m.Backup); err != nil {
+		os.Remove(tmp)
+		os.Remove(filepath.Join(u.stateDir, markerFile))
+		return fmt.Errorf("keep current binary: %w", err)
+	}
+	if err := os.Rename(tmp, exe); err != nil {
+		if rerr := os.Rename(m.Backup, exe); rerr != nil {
+			log.Printf("Update: failed to restore %s: %v", exe, rerr)
+		}
+		os.Remove(filepath.Join(u.stateDir, markerFile))
+		return fmt.Errorf("install new binary: %w", err)
+	}
+
+	log.Printf("Installed agent %s; restarting", m.Version)
+	return restart(exe)
+}
+
+// download fetches the binary into path and checks its size, checksum and
+// signature.
+func (u *Updater) download(offer *pb.AgentUpdate, path string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), downloadTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.apiURL+offer.GetDownloadPath(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("download: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download: %s", resp.Status)
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o755)
+	if err != nil {
+		return fmt.Errorf("cannot write next to the agent binary: %w", err)
+	}
+	// Keep the body in memory as well: ed25519 signs the whole message
+	var body bytes.Buffer
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, h, &body), resp.Body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("download: %w", err)
+	}
+	if offer.GetSize() > 0 && n != offer.GetSize() {
+		return fmt.Errorf("download: got %d bytes, expected %d", n, offer.GetSize())
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(sum, offer.GetSha256()) {
+		return fmt.Errorf("checksum mismatch: got %s, expected %s", sum, offer.GetSha256())
+	}
+	if !ed25519.Verify(u.publicKey, body.Bytes(), offer.GetSignature()) {
+		return errors.New("signature does not verify against the pinned release key")
+	}
+	return nil
+}
+
+// checkRuns runs "<path> --version" and expects it to report version.
+func checkRuns(path, version string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, path, "--version").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("new binary does not run: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	if !strings.Contains(string(out), version) {
+		return fmt.Errorf("new binary reports %q, expected version %s", strings.TrimSpace(string(out)), version)
+	}
+	return nil
+}
+
+// rollback restores the previous binary and restarts into it.
+func (u *Updater) rollback(m *marker, reason string) error {
+	log.Printf("Rolling back agent %s -> %s: %s", m.Version, m.PreviousVersion, reason)
+	if err := os.Rename(m.Backup, m.Binary); err != nil {
+		return fmt.Errorf("restore %s: %w", m.Backup, err)
+	}
+	m.RolledBack = true
+	if err := u.saveMarker(m); err != nil {
+		log.Printf("Update: failed to save update state: %v", err)
+	}
+	return restart(m.Binary)
+}
+
+func supervised() bool {
+	return os.Getenv(SupervisedEnv) != ""
+}
+
+func (u *Updater) loadMarker() (*marker, error) {
+	data, err := os.ReadFile(filepath.Join(u.stateDir, markerFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var m marker
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", markerFile, err)
+	}
+	return &m, nil
+}
+
+func (u *Updater) saveMarker(m *marker) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(u.stateDir, markerFile+".tmp")
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(u.stateDir, markerFile))
+}
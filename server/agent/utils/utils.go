@@ -1,8 +1,10 @@
 package utils
 
 import (
+	"fmt"
 	"net"
 	"os"
+	"strings"
 )
 
 // MustHostname returns the hostname or "unknown" if it fails
@@ -30,3 +32,20 @@ func GetLocalIP() string {
 	return "unknown"
 }
 
+// ParseLabels parses a comma-separated "key=value" list (e.g. "env=prod,role=db").
+// Validation of keys and values is left to the server.
+func ParseLabels(s string) (map[string]string, error) {
+	out := make(map[string]string)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(part, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			return nil, fmt.Errorf("invalid label %q: expected key=value", part)
+		}
+		out[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return out, nil
+}
@@ -0,0 +1,343 @@
+// Package wal is a bounded on-disk log the agent keeps telemetry and file
+// events in until the server has them.
+//
+// Records are appended to segment files named after the sequence number of
+// their first record (<seq>.wal). Each record is framed as
+//
+//	uint32 length | uint32 CRC-32 (IEEE) of seq+data | uint64 seq | data
+//
+// in big-endian order. A torn record at the end of the newest segment (the
+// agent died while writing) is cut off when the log is opened. Consumers read
+// records in order and Commit the last one they no longer need; the
+// committed sequence number is kept in a small "committed" file, and segments
+// holding only committed records are deleted. When the log grows beyond its
+// size limit the oldest segments are dropped, committed or not.
+package wal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	segmentSuffix = ".wal"
+	committedFile = "committed"
+	headerSize    = 16
+	// MaxRecordSize bounds a single record.
+	MaxRecordSize = 1 << 20
+)
+
+// ErrTooLarge is returned by Append for records over MaxRecordSize.
+var ErrTooLarge = errors.New("wal: record too large")
+
+// Record is one entry of the log.
+type Record struct {
+	Seq  uint64
+	Data []byte
+}
+
+type segment struct {
+	first uint64 // seq of the first record
+	last  uint64 // seq of the last record; first-1 when empty
+	size  int64
+}
+
+func (s *segment) name() string {
+	return fmt.Sprintf("%020d%s", s.first, segmentSuffix)
+}
+
+// WAL is a bounded write-ahead log. It is safe for concurrent use.
+type WAL struct {
+	dir          string
+	maxBytes     int64
+	segmentBytes int64
+
+	mu        sync.Mutex
+	segments  []*segment // oldest first; the last one is written to
+	cur       *os.File
+	committed uint64
+	next      uint64 // seq of the next record
+}
+
+// Open opens (creating if needed) the log in dir. The log keeps at most
+// maxBytes on disk, in segments of about a sixteenth of that.
+func Open(dir string, maxBytes int64) (*WAL, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	segmentBytes := maxBytes / 16
+	if segmentBytes < 64<<10 {
+		segmentBytes = 64 << 10
+	}
+	w := &WAL{dir: dir, maxBytes: maxBytes, segmentBytes: segmentBytes, next: 1}
+	if err := w.load(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// load scans the segments on disk and repairs a torn tail.
+func (w *WAL) load() error {
+	if data, err := os.ReadFile(filepath.Join(w.dir, committedFile)); err == nil {
+		w.committed, _ = strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	}
+
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		first, err := strconv.ParseUint(strings.TrimSuffix(name, segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		w.segments = append(w.segments, &segment{first: first, last: first - 1})
+	}
+	sort.Slice(w.segments, func(i, j int) bool { return w.segments[i].first < w.segments[j].first })
+
+	for i, seg := range w.segments {
+		last, size, err := scanSegment(filepath.Join(w.dir, seg.name()), seg.first)
+		if err != nil {
+			return err
+		}
+		seg.last, seg.size = last, size
+		if i == len(w.segments)-1 {
+			// Cut off a torn record so appends continue from a clean end
+			if err := os.Truncate(filepath.Join(w.dir, seg.name()), size); err != nil {
+				return err
+			}
+		}
+		if seg.last >= w.next {
+			w.next = seg.last + 1
+		}
+	}
+	if w.committed >= w.next {
+		w.next = w.committed + 1
+	}
+	w.dropCommitted()
+	return nil
+}
+
+// scanSegment returns the seq of the last valid record and the size of the
+// valid prefix of a segment.
+func scanSegment(path string, first uint64) (uint64, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	last, size := first-1, int64(0)
+	for {
+		rec, n, err := readRecord(f)
+		if err != nil {
+			// io.EOF or a torn/corrupt record: the valid part ends here
+			return last, size, nil
+		}
+		last = rec.Seq
+		size += n
+	}
+}
+
+func readRecord(r io.Reader) (Record, int64, error) {
+	var hdr [headerSize]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return Record{}, 0, err
+	}
+	length := binary.BigEndian.Uint32(hdr[0:4])
+	sum := binary.BigEndian.Uint32(hdr[4:8])
+	seq := binary.BigEndian.Uint64(hdr[8:16])
+	if length > MaxRecordSize {
+		return Record{}, 0, errors.New("wal: corrupt record length")
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return Record{}, 0, err
+	}
+	crc := crc32.NewIEEE()
+	crc.Write(hdr[8:16])
+	crc.Write(data)
+	if crc.Sum32() != sum {
+		return Record{}, 0, errors.New("wal: checksum mismatch")
+	}
+	return Record{Seq: seq, Data: data}, int64(headerSize) + int64(length), nil
+}
+
+// Append writes a record and returns its sequence number. The oldest
+// segments are dropped when the log exceeds its size limit.
+func (w *WAL) Append(data []byte) (uint64, error) {
+	if len(data) > MaxRecordSize {
+		return 0, ErrTooLarge
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.ensureWritable(); err != nil {
+		return 0, err
+	}
+	seq := w.next
+	buf := make([]byte, headerSize+len(data))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(data)))
+	binary.BigEndian.PutUint64(buf[8:16], seq)
+	copy(buf[headerSize:], data)
+	binary.BigEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(buf[8:]))
+	if _, err := w.cur.Write(buf); err != nil {
+		return 0, err
+	}
+	seg := w.segments[len(w.segments)-1]
+	seg.last = seq
+	seg.size += int64(len(buf))
+	w.next++
+	w.enforceLimit()
+	return seq, nil
+}
+
+// ensureWritable opens the newest segment for appending, starting a new one
+// when it is full.
+func (w *WAL) ensureWritable() error {
+	if n := len(w.segments); n > 0 && w.segments[n-1].size >= w.segmentBytes {
+		if w.cur != nil {
+			w.cur.Close()
+			w.cur = nil
+		}
+		w.segments = append(w.segments, &segment{first: w.next, last: w.next - 1})
+	}
+	if len(w.segments) == 0 {
+		w.segments = append(w.segments, &segment{first: w.next, last: w.next - 1})
+	}
+	if w.cur != nil {
+		return nil
+	}
+	seg := w.segments[len(w.segments)-1]
+	f, err := os.OpenFile(filepath.Join(w.dir, seg.name()), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	w.cur = f
+	return nil
+}
+
+// enforceLimit deletes the oldest segments while the log is too large. The
+// segment being written is never deleted.
+func (w *WAL) enforceLimit() {
+	var total int64
+	for _, seg := range w.segments {
+		total += seg.size
+	}
+	for total > w.maxBytes && len(w.segments) > 1 {
+		seg := w.segments[0]
+		if err := os.Remove(filepath.Join(w.dir, seg.name())); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("wal: failed to drop %s: %v", seg.name(), err)
+			return
+		}
+		if seg.last > w.committed {
+			log.Printf("wal: buffer full, dropped records %d-%d", max(seg.first, w.committed+1), seg.last)
+		}
+		total -= seg.size
+		w.segments = w.segments[1:]
+	}
+}
+
+// Read returns up to max uncommitted records in order.
+func (w *WAL) Read(max int) ([]Record, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var out []Record
+	for _, seg := range w.segments {
+		if seg.last <= w.committed || seg.last < seg.first {
+			continue
+		}
+		f, err := os.Open(filepath.Join(w.dir, seg.name()))
+		if err != nil {
+			return out, err
+		}
+		// Only the valid prefix counts (the newest segment may be mid-write)
+		r := io.LimitReader(f, seg.size)
+		for len(out) < max {
+			rec, _, err := readRecord(r)
+			if err != nil {
+				break
+			}
+			if rec.Seq > w.committed {
+				out = append(out, rec)
+			}
+		}
+		f.Close()
+		if len(out) >= max {
+			break
+		}
+	}
+	return out, nil
+}
+
+// Commit marks all records up to seq as consumed and deletes the segments
+// that hold nothing else.
+func (w *WAL) Commit(seq uint64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if seq <= w.committed {
+		return nil
+	}
+	w.committed = seq
+	tmp := filepath.Join(w.dir, committedFile+".tmp")
+	if err := os.WriteFile(tmp, []byte(strconv.FormatUint(seq, 10)+"\n"), 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(w.dir, committedFile)); err != nil {
+		return err
+	}
+	w.dropCommitted()
+	return nil
+}
+
+// dropCommitted deletes fully committed segments other than the newest.
+func (w *WAL) dropCommitted() {
+	for len(w.segments) > 1 && w.segments[0].last <= w.committed {
+		seg := w.segments[0]
+		if err := os.Remove(filepath.Join(w.dir, seg.name())); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("wal: failed to remove %s: %v", seg.name(), err)
+			return
+		}
+		w.segments = w.segments[1:]
+	}
+}
+
+// Pending returns the number of uncommitted records.
+func (w *WAL) Pending() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	first := w.committed + 1
+	if len(w.segments) > 0 && w.segments[0].first > first {
+		first = w.segments[0].first // older records were dropped
+	}
+	if w.next <= first {
+		return 0
+	}
+	return w.next - first
+}
+
+// Close closes the segment being written.
+func (w *WAL) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.cur == nil {
+		return nil
+	}
+	err := w.cur.Close()
+	w.cur = nil
+	return err
+}
@@ -0,0 +1,102 @@
+// Package advisory imports vulnerability advisories from offline OSV feeds
+// and matches them against the packages installed on a machine.
+//
+// Advisories and machines meet on a distribution release (see Distro):
+// Debian, Ubuntu, Alpine, Rocky Linux, AlmaLinux and RHEL are matched.
+// Versions are compared the way the distribution's package manager does
+// (see package pkgversion). Distribution advisories usually name source
+// packages, so a package matches by its own name or its source's.
+package advisory
+
+import (
+	"github.com/lute/api/models"
+	"github.com/lute/api/pkgversion"
+)
+
+// Affected reports whether version of a package is affected by p, and the
+// version that fixes it (empty when the feed knows no fix). format is the
+// package's format.
+func Affected(p models.AffectedPackage, format, version string) (affected bool, fixed string) {
+	for _, v := range p.Versions {
+		if pkgversion.Compare(format, version, v) == 0 {
+			return true, ""
+		}
+	}
+	for _, r := range p.Ranges {
+		if r.Introduced != "" && r.Introduced != "0" && pkgversion.Compare(format, version, r.Introduced) < 0 {
+			continue
+		}
+		if r.Fixed != "" && pkgversion.Compare(format, version, r.Fixed) >= 0 {
+			continue
+		}
+		if r.LastAffected != "" && pkgversion.Compare(format, version, r.LastAffected) > 0 {
+			continue
+		}
+		return true, r.Fixed
+	}
+	return false, ""
+}
+
+// Match returns the findings of the advisories on a machine whose packages
+// are pkgs and whose Distro key is distro. Findings have no machine or
+// detection time set.
+func Match(distro string, pkgs []models.InstalledPackage, advisories []*models.Advisory) []*models.VulnerabilityFinding {
+	format := Format(distro)
+	byName := make(map[string][]models.InstalledPackage)
+	for _, p := range pkgs {
+		if p.Format != format {
+			continue // e.g. rpm on a Debian host: not the distribution's package
+		}
+		byName[p.Name] = append(byName[p.Name], p)
+		if p.Source != "" {
+			byName[p.Source] = append(byName[p.Source], p)
+		}
+	}
+
+	var out []*models.VulnerabilityFinding
+	for _, adv := range advisories {
+		seen := make(map[string]bool) // a package is reported once per advisory
+		for _, a := range adv.Affected {
+			if a.Distro != distro {
+				continue
+			}
+			for _, p := range byName[a.Package] {
+				key := p.Name + "/" + p.Arch
+				if seen[key] {
+					continue
+				}
+				affected, fixed := Affected(a, format, p.Version)
+				if !affected {
+					continue
+				}
+				seen[key] = true
+				out = append(out, &models.VulnerabilityFinding{
+					AdvisoryID:   adv.ID,
+					CVEs:         adv.CVEs,
+					Severity:     adv.Severity,
+					Score:        adv.Score,
+					Summary:      adv.Summary,
+					Package:      p,
+					FixedVersion: fixed,
+				})
+			}
+		}
+	}
+	return out
+}
+
+// PackageNames lists the names advisories may use for pkgs: package and
+// source package names.
+func PackageNames(pkgs []models.InstalledPackage) []string {
+	seen := make(map[string]bool, len(pkgs))
+	var out []string
+	for _, p := range pkgs {
+		for _, name := range []string{p.Name, p.Source} {
+			if name != "" && !seen[name] {
+				seen[name] = true
+				out = append(out, name)
+			}
+		}
+	}
+	return out
+}
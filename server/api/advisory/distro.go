@@ -0,0 +1,80 @@
+package advisory
+
+import (
+	"strings"
+
+	"github.com/lute/api/pkgversion"
+)
+
+// Distro returns the key advisories and machines are matched on for a
+// machine's os-release ID and VERSION_ID, e.g. "debian:12", "ubuntu:22.04",
+// "alpine:3.19" or "rocky:9". It is "" for systems no feed covers, or when
+// the version is unknown (e.g. Debian testing).
+func Distro(osID, versionID string) string {
+	if versionID == "" {
+		return ""
+	}
+	switch osID {
+	case "debian", "rocky", "almalinux", "rhel":
+		major, _, _ := strings.Cut(versionID, ".")
+		return osID + ":" + major
+	case "ubuntu":
+		return osID + ":" + versionID
+	case "alpine":
+		parts := strings.SplitN(versionID, ".", 3)
+		if len(parts) < 2 {
+			return ""
+		}
+		return osID + ":" + parts[0] + "." + parts[1]
+	}
+	return ""
+}
+
+// distroOfEcosystem returns the Distro key of an OSV ecosystem, or "" for
+// ecosystems that are not Linux distributions Lute matches (npm, PyPI, ...).
+//
+//	Debian:12                            debian:12
+//	Ubuntu:22.04:LTS, Ubuntu:Pro:18.04:LTS  ubuntu:22.04, ubuntu:18.04
+//	Alpine:v3.19                         alpine:3.19
+//	Rocky Linux:9, AlmaLinux:9           rocky:9, almalinux:9
+//	Red Hat:enterprise_linux:9::appstream   rhel:9
+func distroOfEcosystem(ecosystem string) string {
+	parts := strings.Split(ecosystem, ":")
+	if len(parts) < 2 {
+		return ""
+	}
+	switch parts[0] {
+	case "Debian":
+		return Distro("debian", parts[1])
+	case "Ubuntu":
+		version := parts[1]
+		if version == "Pro" && len(parts) > 2 {
+			version = parts[2]
+		}
+		return Distro("ubuntu", version)
+	case "Alpine":
+		return Distro("alpine", strings.TrimPrefix(parts[1], "v"))
+	case "Rocky Linux":
+		return Distro("rocky", parts[1])
+	case "AlmaLinux":
+		return Distro("almalinux", parts[1])
+	case "Red Hat":
+		if len(parts) > 2 && parts[1] == "enterprise_linux" {
+			return Distro("rhel", parts[2])
+		}
+	}
+	return ""
+}
+
+// Format returns the package format of a Distro key, which selects how its
+// versions compare.
+func Format(distro string) string {
+	name, _, _ := strings.Cut(distro, ":")
+	switch name {
+	case "debian", "ubuntu":
+		return pkgversion.Deb
+	case "alpine":
+		return pkgversion.Apk
+	}
+	return pkgversion.RPM
+}
@@ -0,0 +1,357 @@
+package advisory
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lute/api/models"
+	"github.com/lute/api/pkgversion"
+)
+
+// osvRecord is the part of the OSV schema (https://ossf.github.io/osv-schema/)
+// Lute uses.
+type osvRecord struct {
+	ID        string        `json:"id"`
+	Aliases   []string      `json:"aliases"`
+	Upstream  []string      `json:"upstream"`
+	Summary   string        `json:"summary"`
+	Details   string        `json:"details"`
+	Published string        `json:"published"`
+	Modified  string        `json:"modified"`
+	Withdrawn string        `json:"withdrawn"`
+	Severity  []osvSeverity `json:"severity"`
+	Affected  []osvAffected `json:"affected"`
+
+	DatabaseSpecific struct {
+		Severity string `json:"severity"`
+	} `json:"database_specific"`
+}
+
+type osvSeverity struct {
+	Type  string `json:"type"`  // "CVSS_V3", "CVSS_V4", "Ubuntu", ...
+	Score string `json:"score"` // a vector for CVSS types, a label otherwise
+}
+
+type osvAffected struct {
+	Package struct {
+		Ecosystem string `json:"ecosystem"`
+		Name      string `json:"name"`
+	} `json:"package"`
+	Ranges   []osvRange `json:"ranges"`
+	Versions []string   `json:"versions"`
+
+	EcosystemSpecific struct {
+		Urgency string `json:"urgency"` // Debian
+	} `json:"ecosystem_specific"`
+	DatabaseSpecific struct {
+		Severity string `json:"severity"`
+	} `json:"database_specific"`
+}
+
+type osvRange struct {
+	Type   string     `json:"type"` // "ECOSYSTEM", "SEMVER" or "GIT"
+	Events []osvEvent `json:"events"`
+}
+
+type osvEvent struct {
+	Introduced   string `json:"introduced,omitempty"`
+	Fixed        string `json:"fixed,omitempty"`
+	LastAffected string `json:"last_affected,omitempty"`
+	Limit        string `json:"limit,omitempty"`
+}
+
+// version is the version an event is about, whichever kind it is.
+func (e osvEvent) version() string {
+	switch {
+	case e.Introduced != "":
+		return e.Introduced
+	case e.Fixed != "":
+		return e.Fixed
+	case e.LastAffected != "":
+		return e.LastAffected
+	}
+	return e.Limit
+}
+
+// Load reads the OSV records in path and calls fn with each advisory that
+// affects a distribution Lute matches. path is a JSON file (one record or an
+// array of them), a zip archive of JSON files (the all.zip dumps OSV
+// publishes per ecosystem) or a directory of either. Withdrawn records are
+// skipped. An error from fn stops the load.
+func Load(path string, fn func(*models.Advisory) error) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return loadFile(path, fn)
+	}
+	return filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if ext := strings.ToLower(filepath.Ext(p)); ext != ".json" && ext != ".zip" {
+			return nil
+		}
+		return loadFile(p, fn)
+	})
+}
+
+// ModTime returns the newest modification time of the feed files in path.
+func ModTime(path string) (time.Time, error) {
+	var newest time.Time
+	err := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+		return nil
+	})
+	return newest, err
+}
+
+func loadFile(path string, fn func(*models.Advisory) error) error {
+	if strings.EqualFold(filepath.Ext(path), ".zip") {
+		return loadZip(path, fn)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := decode(f, fn); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+func loadZip(path string, fn func(*models.Advisory) error) error {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	for _, entry := range zr.File {
+		if entry.FileInfo().IsDir() || !strings.EqualFold(filepath.Ext(entry.Name), ".json") {
+			continue
+		}
+		r, err := entry.Open()
+		if err != nil {
+			return fmt.Errorf("%s: %s: %w", path, entry.Name, err)
+		}
+		err = decode(r, fn)
+		r.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %s: %w", path, entry.Name, err)
+		}
+	}
+	return nil
+}
+
+// decode reads one OSV record or an array of them from r.
+func decode(r io.Reader, fn func(*models.Advisory) error) error {
+	br := bufio.NewReader(r)
+	first, err := peekNonSpace(br)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(br)
+	if first != '[' {
+		var rec osvRecord
+		if err := dec.Decode(&rec); err != nil {
+			return err
+		}
+		return emit(&rec, fn)
+	}
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+	for dec.More() {
+		var rec osvRecord
+		if err := dec.Decode(&rec); err != nil {
+			return err
+		}
+		if err := emit(&rec, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// peekNonSpace skips leading white space and returns the next byte without
+// consuming it.
+func peekNonSpace(br *bufio.Reader) (byte, error) {
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if b != ' ' && b != '\t' && b != '\n' && b != '\r' {
+			return b, br.UnreadByte()
+		}
+	}
+}
+
+func emit(rec *osvRecord, fn func(*models.Advisory) error) error {
+	if rec.Withdrawn != "" {
+		return nil
+	}
+	adv := fromOSV(rec)
+	if adv == nil {
+		return nil
+	}
+	return fn(adv)
+}
+
+// fromOSV converts a record, or returns nil if none of its packages belongs
+// to a distribution Lute matches.
+func fromOSV(rec *osvRecord) *models.Advisory {
+	adv := &models.Advisory{
+		ID:        rec.ID,
+		CVEs:      cves(rec),
+		Summary:   rec.Summary,
+		Published: parseTime(rec.Published),
+		Modified:  parseTime(rec.Modified),
+	}
+	if adv.Summary == "" {
+		adv.Summary = firstLine(rec.Details)
+	}
+
+	severity := SeverityUnknown
+	raise := func(s string) {
+		if SeverityRank(s) > SeverityRank(severity) {
+			severity = s
+		}
+	}
+	raise(normalizeSeverity(rec.DatabaseSpecific.Severity))
+	for _, s := range rec.Severity {
+		if score, ok := cvss3Score(s.Score); ok {
+			adv.Score = max(adv.Score, score)
+			continue
+		}
+		if !strings.HasPrefix(s.Type, "CVSS") {
+			raise(normalizeSeverity(s.Score))
+		}
+	}
+
+	for _, a := range rec.Affected {
+		distro := distroOfEcosystem(a.Package.Ecosystem)
+		if distro == "" || a.Package.Name == "" {
+			continue
+		}
+		raise(normalizeSeverity(a.EcosystemSpecific.Urgency))
+		raise(normalizeSeverity(a.DatabaseSpecific.Severity))
+		pkg := models.AffectedPackage{
+			Distro:   distro,
+			Package:  a.Package.Name,
+			Versions: a.Versions,
+		}
+		for _, r := range a.Ranges {
+			if r.Type == "GIT" {
+				continue
+			}
+			pkg.Ranges = append(pkg.Ranges, ranges(Format(distro), r.Events)...)
+		}
+		if len(pkg.Ranges) == 0 && len(pkg.Versions) == 0 {
+			continue
+		}
+		adv.Affected = append(adv.Affected, pkg)
+	}
+	if len(adv.Affected) == 0 {
+		return nil
+	}
+
+	// A CVSS score is the most precise measure; labels are used without one.
+	if adv.Score > 0 {
+		severity = scoreSeverity(adv.Score)
+	}
+	adv.Severity = severity
+	return adv
+}
+
+// ranges turns the events of an OSV range into spans. Events are evaluated
+// in version order, as the OSV schema specifies, whatever order the feed
+// lists them in; "0" is older than every version.
+func ranges(format string, events []osvEvent) []models.AffectedRange {
+	sorted := make([]osvEvent, 0, len(events))
+	for _, e := range events {
+		if e.Limit == "" {
+			sorted = append(sorted, e)
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i].version(), sorted[j].version()
+		if a == "0" || b == "0" {
+			return a == "0" && b != "0"
+		}
+		return pkgversion.Compare(format, a, b) < 0
+	})
+
+	var out []models.AffectedRange
+	var open *models.AffectedRange
+	for _, e := range sorted {
+		switch {
+		case e.Introduced != "":
+			if open == nil {
+				open = &models.AffectedRange{Introduced: e.Introduced}
+			}
+		case e.Fixed != "" || e.LastAffected != "":
+			if open == nil {
+				open = &models.AffectedRange{Introduced: "0"}
+			}
+			open.Fixed, open.LastAffected = e.Fixed, e.LastAffected
+			out = append(out, *open)
+			open = nil
+		}
+	}
+	if open != nil {
+		out = append(out, *open)
+	}
+	return out
+}
+
+// cves returns the CVE IDs a record is about: its own ID, aliases and
+// upstream IDs. Records without one are known by their own ID.
+func cves(rec *osvRecord) []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, id := range append(append([]string{rec.ID}, rec.Aliases...), rec.Upstream...) {
+		if strings.HasPrefix(id, "CVE-") && !seen[id] {
+			seen[id] = true
+			out = append(out, id)
+		}
+	}
+	if len(out) == 0 {
+		return []string{rec.ID}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func parseTime(s string) time.Time {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}
+	}
+	return t.UTC()
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(s), "\n")
+	return line
+}
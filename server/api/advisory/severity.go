@@ -0,0 +1,159 @@
+package advisory
+
+import (
+	"math"
+	"strings"
+)
+
+// Severity levels, from most to least severe.
+const (
+	SeverityCritical = "critical"
+	SeverityHigh     = "high"
+	SeverityMedium   = "medium"
+	SeverityLow      = "low"
+	SeverityUnknown  = "unknown"
+)
+
+var severityRanks = map[string]int{
+	SeverityCritical: 4,
+	SeverityHigh:     3,
+	SeverityMedium:   2,
+	SeverityLow:      1,
+}
+
+// SeverityRank orders severities: higher is more severe, unknown is 0.
+func SeverityRank(severity string) int {
+	return severityRanks[severity]
+}
+
+// ValidSeverity reports whether s is one of the severity levels.
+func ValidSeverity(s string) bool {
+	_, ok := severityRanks[s]
+	return ok || s == SeverityUnknown
+}
+
+// SeveritiesAtLeast lists the severities at least as severe as min.
+func SeveritiesAtLeast(min string) []string {
+	var out []string
+	for s, rank := range severityRanks {
+		if rank >= SeverityRank(min) {
+			out = append(out, s)
+		}
+	}
+	if min == SeverityUnknown {
+		out = append(out, SeverityUnknown)
+	}
+	return out
+}
+
+// normalizeSeverity maps the labels feeds use (CVSS ratings, Red Hat's
+// "important"/"moderate", Debian's urgencies, Ubuntu's priorities) to a
+// severity level. Debian marks uncertain urgencies with asterisks
+// ("medium**"); they are ignored.
+func normalizeSeverity(label string) string {
+	switch strings.ToLower(strings.TrimRight(strings.TrimSpace(label), "*")) {
+	case "critical":
+		return SeverityCritical
+	case "high", "important":
+		return SeverityHigh
+	case "medium", "moderate":
+		return SeverityMedium
+	case "low", "negligible", "unimportant":
+		return SeverityLow
+	}
+	return SeverityUnknown
+}
+
+// scoreSeverity is the CVSS qualitative rating of a base score.
+func scoreSeverity(score float64) string {
+	switch {
+	case score >= 9:
+		return SeverityCritical
+	case score >= 7:
+		return SeverityHigh
+	case score >= 4:
+		return SeverityMedium
+	case score > 0:
+		return SeverityLow
+	}
+	return SeverityUnknown
+}
+
+// cvss3Weights are the metric values of the CVSS v3.x base score.
+var cvss3Weights = map[string]map[string]float64{
+	"AV": {"N": 0.85, "A": 0.62, "L": 0.55, "P": 0.2},
+	"AC": {"L": 0.77, "H": 0.44},
+	"UI": {"N": 0.85, "R": 0.62},
+	"C":  {"H": 0.56, "L": 0.22, "N": 0},
+	"I":  {"H": 0.56, "L": 0.22, "N": 0},
+	"A":  {"H": 0.56, "L": 0.22, "N": 0},
+}
+
+// cvss3Score computes the base score of a CVSS v3.0 or v3.1 vector such as
+// "CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H". ok is false when the
+// vector is not v3 or misses a base metric.
+func cvss3Score(vector string) (score float64, ok bool) {
+	parts := strings.Split(vector, "/")
+	if len(parts) == 0 || !strings.HasPrefix(parts[0], "CVSS:3.") {
+		return 0, false
+	}
+	metrics := make(map[string]string, len(parts))
+	for _, p := range parts[1:] {
+		if k, v, found := strings.Cut(p, ":"); found {
+			metrics[k] = v
+		}
+	}
+	values := make(map[string]float64, len(cvss3Weights))
+	for metric, weights := range cvss3Weights {
+		w, found := weights[metrics[metric]]
+		if !found {
+			return 0, false
+		}
+		values[metric] = w
+	}
+	changed := metrics["S"] == "C"
+	if !changed && metrics["S"] != "U" {
+		return 0, false
+	}
+	var pr float64
+	switch metrics["PR"] {
+	case "N":
+		pr = 0.85
+	case "L":
+		pr = 0.62
+		if changed {
+			pr = 0.68
+		}
+	case "H":
+		pr = 0.27
+		if changed {
+			pr = 0.5
+		}
+	default:
+		return 0, false
+	}
+
+	iss := 1 - (1-values["C"])*(1-values["I"])*(1-values["A"])
+	impact := 6.42 * iss
+	if changed {
+		impact = 7.52*(iss-0.029) - 3.25*math.Pow(iss-0.02, 15)
+	}
+	if impact <= 0 {
+		return 0, true
+	}
+	exploitability := 8.22 * values["AV"] * values["AC"] * pr * values["UI"]
+	if changed {
+		return roundUp(math.Min(1.08*(impact+exploitability), 10)), true
+	}
+	return roundUp(math.Min(impact+exploitability, 10)), true
+}
+
+// roundUp is CVSS v3.1's Roundup: the smallest number with one decimal
+// that is not less than x, computed without floating point surprises.
+func roundUp(x float64) float64 {
+	n := int64(math.Round(x * 100000))
+	if n%10000 == 0 {
+		return float64(n) / 100000
+	}
+	return float64(n/10000+1) / 10
+}
@@ -0,0 +1,76 @@
+// Package agentauth implements the per-machine agent token. The token is
+// returned once at registration, kept by the agent and sent in the gRPC
+// metadata of every Connect; only its SHA-256 is stored on the machine. It is
+// checked in addition to the machine's client certificate.
+package agentauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+
+	"github.com/lute/api/models"
+	"github.com/lute/api/tokens"
+)
+
+// MetadataKey is the gRPC metadata key carrying the agent token.
+const MetadataKey = "x-lute-agent-token"
+
+// Match says which of a machine's tokens was presented.
+type Match int
+
+const (
+	NoMatch Match = iota
+	MatchCurrent
+	MatchNext // the token handed out by a rotation, used for the first time
+)
+
+// NewToken returns a random token and its hash.
+func NewToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = hex.EncodeToString(b)
+	return token, tokens.Hash(token), nil
+}
+
+// FromContext returns the agent token sent in the incoming gRPC metadata.
+func FromContext(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if v := md.Get(MetadataKey); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// Verify compares token with the machine's current and pending token.
+func Verify(machine *models.Machine, token string) Match {
+	if token == "" {
+		return NoMatch
+	}
+	hash := tokens.Hash(token)
+	if machine.AgentTokenHash != "" && subtle.ConstantTimeCompare([]byte(hash), []byte(machine.AgentTokenHash)) == 1 {
+		return MatchCurrent
+	}
+	if machine.AgentTokenNextHash != "" && subtle.ConstantTimeCompare([]byte(hash), []byte(machine.AgentTokenNextHash)) == 1 {
+		return MatchNext
+	}
+	return NoMatch
+}
+
+// RotationDue reports whether the machine's current token should be replaced:
+// a rotation was requested, or the token is older than ttl (0 disables).
+func RotationDue(machine *models.Machine, ttl time.Duration, now time.Time) bool {
+	if machine.AgentTokenRotate {
+		return true
+	}
+	return ttl > 0 && machine.AgentTokenIssuedAt != nil && now.Sub(*machine.AgentTokenIssuedAt) > ttl
+}
@@ -0,0 +1,605 @@
+// Package agentbin indexes the compiled agent binaries served to installers
+// and to agents updating themselves.
+//
+// Every version lives in its own directory, and release channels point at
+// versions:
+//
+//	<dir>/
+//	  channels.json                {"stable": "1.4.0", "beta": "1.5.0-rc.1"}
+//	  1.4.0/
+//	    lute-agent-linux-amd64
+//	    lute-agent-linux-amd64.sig detached ed25519 signature, one per binary
+//	    lute-agent-linux-arm64
+//	    lute-agent-darwin-amd64
+//	    lute-agent-darwin-arm64
+//	    lute-agent-windows-amd64.exe
+//	    ...
+//	  1.5.0-rc.1/
+//	    ...
+//
+// Binaries placed directly in <dir> (the layout of the Docker image) are
+// indexed as the version in <dir>/VERSION, "unknown" without one.
+//
+// A channel without an entry in channels.json follows the next more stable
+// one (canary -> beta -> stable), and stable defaults to the newest release.
+//
+// A binary is only indexed when its signature verifies against the release
+// public key, so a file dropped into the directory is never served or
+// offered to agents. Signatures are made with
+//
+//	openssl pkeyutl -sign -inkey signing.pem -rawin -in <binary> -out <binary>.sig
+package agentbin
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Release channels, from most to least stable.
+const (
+	ChannelStable = "stable"
+	ChannelBeta   = "beta"
+	ChannelCanary = "canary"
+)
+
+// Channels lists the release channels, from most to least stable.
+var Channels = []string{ChannelStable, ChannelBeta, ChannelCanary}
+
+// MaxUploadSize is the largest binary Add accepts.
+const MaxUploadSize = 200 << 20
+
+const channelsFile = "channels.json"
+
+var (
+	ErrUnknownVersion = errors.New("no agent binaries of that version")
+	ErrUnknownChannel = errors.New("unknown release channel (stable, beta or canary)")
+	ErrBadVersion     = errors.New("version may only contain letters, digits, '.', '-', '+' and '_'")
+	ErrBadPlatform    = errors.New("os and arch may only contain lowercase letters and digits")
+	ErrTooLarge       = errors.New("agent binary is too large")
+	ErrUnsigned       = errors.New("agent binary is not signed")
+	ErrSignature      = errors.New("signature does not verify against the release public key")
+)
+
+var (
+	versionPattern  = regexp.MustCompile(`^[0-9A-Za-z][0-9A-Za-z.+_-]{0,63}$`)
+	platformPattern = regexp.MustCompile(`^[a-z0-9]{1,16}$`)
+)
+
+// Binary describes one compiled agent binary.
+type Binary struct {
+	OS       string `json:"os"`
+	Arch     string `json:"arch"`
+	Version  string `json:"version"`
+	Filename string `json:"filename"`
+	SHA256   string `json:"sha256"`
+	Size     int64  `json:"size"`
+	// Signature is the detached ed25519 signature of the file (base64 in
+	// JSON). Empty only for unsigned binaries indexed with AllowUnsigned.
+	Signature []byte `json:"signature,omitempty"`
+
+	path string
+}
+
+// DownloadPath is the API path the binary is served from.
+func (b *Binary) DownloadPath() string {
+	return "/api/v1/agent/download/" + b.OS + "/" + b.Arch + "?version=" + b.Version
+}
+
+// Index is an in-memory index of the binaries in a directory. It is safe for
+// concurrent use.
+type Index struct {
+	dir           string
+	publicKey     ed25519.PublicKey
+	allowUnsigned bool
+
+	fsMu sync.Mutex // serializes changes to the directory
+
+	mu       sync.RWMutex
+	releases map[string]map[string]*Binary // version -> "os/arch" -> binary
+	channels map[string]string             // as set in channels.json
+	legacy   string                        // version of the binaries directly in dir
+}
+
+// New creates an index of dir and scans it. Binaries must be signed with the
+// private key of publicKey; with allowUnsigned (development only) binaries
+// without a signature are indexed too. Without a public key and
+// allowUnsigned nothing is indexed.
+func New(dir string, publicKey ed25519.PublicKey, allowUnsigned bool) *Index {
+	idx := &Index{
+		dir:           dir,
+		publicKey:     publicKey,
+		allowUnsigned: allowUnsigned,
+		releases:      make(map[string]map[string]*Binary),
+		channels:      make(map[string]string),
+	}
+	if publicKey == nil && !allowUnsigned {
+		log.Printf("Warning: no agent signing public key configured (AGENT_SIGNING_PUBLIC_KEY); agent binaries will not be served")
+	}
+	idx.Refresh()
+	return idx
+}
+
+// Refresh rescans the directory and returns the number of binaries found.
+func (idx *Index) Refresh() int {
+	idx.fsMu.Lock()
+	defer idx.fsMu.Unlock()
+
+	entries, err := os.ReadDir(idx.dir)
+	if err != nil {
+		log.Printf("Warning: cannot read agent binary dir %s: %v", idx.dir, err)
+		return idx.Len()
+	}
+
+	releases := make(map[string]map[string]*Binary)
+	legacy := ""
+	if version := readVersionFile(idx.dir); versionPattern.MatchString(version) {
+		if bins := idx.scan(idx.dir, version); len(bins) > 0 {
+			releases[version] = bins
+			legacy = version
+		}
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || !versionPattern.MatchString(entry.Name()) {
+			continue
+		}
+		if bins := idx.scan(filepath.Join(idx.dir, entry.Name()), entry.Name()); len(bins) > 0 {
+			releases[entry.Name()] = bins
+		}
+	}
+
+	channels, err := readChannels(idx.dir)
+	if err != nil {
+		log.Printf("Warning: cannot read %s: %v", channelsFile, err)
+	}
+
+	idx.mu.Lock()
+	idx.releases = releases
+	idx.channels = channels
+	idx.legacy = legacy
+	idx.mu.Unlock()
+
+	count := 0
+	for _, bins := range releases {
+		count += len(bins)
+	}
+	return count
+}
+
+// scan indexes the binaries of one version found in dir.
+func (idx *Index) scan(dir, version string) map[string]*Binary {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.Printf("Warning: cannot read agent binary dir %s: %v", dir, err)
+		return nil
+	}
+	bins := make(map[string]*Binary)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		name := entry.Name()
+		if !strings.HasPrefix(name, "lute-agent-") || strings.HasSuffix(name, SignatureSuffix) {
+			continue
+		}
+
+		osName, arch := parseFilename(name)
+		if osName == "" || arch == "" {
+			continue
+		}
+
+		path := filepath.Join(dir, name)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Printf("Warning: cannot read agent binary %s: %v", path, err)
+			continue
+		}
+		sig, err := readSignature(path)
+		if errors.Is(err, os.ErrNotExist) {
+			sig, err = nil, nil
+		}
+		if err == nil {
+			err = idx.verify(data, sig)
+		}
+		if err != nil {
+			log.Printf("Warning: not serving agent binary %s: %v", path, err)
+			continue
+		}
+
+		b := newBinary(osName, arch, version, name, data, sig)
+		b.path = path
+		bins[osName+"/"+arch] = b
+		log.Printf("Indexed agent binary: %s %s (%s/%s, %d bytes, signed: %t)", version, name, osName, arch, len(data), sig != nil)
+	}
+	return bins
+}
+
+// Add stores an uploaded binary as version for a platform and indexes it.
+// The signature is required unless the index allows unsigned binaries. An
+// existing binary of the same version and platform is replaced.
+func (idx *Index) Add(version, osName, arch string, r io.Reader, sig []byte) (*Binary, error) {
+	if !versionPattern.MatchString(version) {
+		return nil, ErrBadVersion
+	}
+	if !platformPattern.MatchString(osName) || !platformPattern.MatchString(arch) {
+		return nil, ErrBadPlatform
+	}
+	data, err := io.ReadAll(io.LimitReader(r, MaxUploadSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxUploadSize {
+		return nil, ErrTooLarge
+	}
+	if err := idx.verify(data, sig); err != nil {
+		return nil, err
+	}
+
+	idx.fsMu.Lock()
+	defer idx.fsMu.Unlock()
+
+	dir := filepath.Join(idx.dir, version)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	name := "lute-agent-" + osName + "-" + arch
+	if osName == "windows" {
+		name += ".exe"
+	}
+	path := filepath.Join(dir, name)
+	// The signature goes first: a binary is never visible without it
+	if sig != nil {
+		if err := writeFileAtomic(path+SignatureSuffix, sig, 0o644); err != nil {
+			return nil, err
+		}
+	} else if err := os.Remove(path + SignatureSuffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err := writeFileAtomic(path, data, 0o755); err != nil {
+		return nil, err
+	}
+
+	b := newBinary(osName, arch, version, name, data, sig)
+	b.path = path
+	idx.mu.Lock()
+	if idx.releases[version] == nil {
+		idx.releases[version] = make(map[string]*Binary)
+	}
+	idx.releases[version][osName+"/"+arch] = b
+	idx.mu.Unlock()
+	log.Printf("Added agent binary: %s %s (%s/%s, %d bytes, signed: %t)", version, name, osName, arch, len(data), sig != nil)
+	return b, nil
+}
+
+// SetChannel points a release channel at a version.
+func (idx *Index) SetChannel(channel, version string) error {
+	if !validChannel(channel) {
+		return ErrUnknownChannel
+	}
+	if !idx.HasVersion(version) {
+		return ErrUnknownVersion
+	}
+
+	idx.fsMu.Lock()
+	defer idx.fsMu.Unlock()
+
+	idx.mu.RLock()
+	channels := make(map[string]string, len(idx.channels)+1)
+	for k, v := range idx.channels {
+		channels[k] = v
+	}
+	idx.mu.RUnlock()
+	channels[channel] = version
+
+	data, err := json.MarshalIndent(channels, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(idx.dir, channelsFile), append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	idx.mu.Lock()
+	idx.channels = channels
+	idx.mu.Unlock()
+	return nil
+}
+
+// Prune deletes all but the keep newest versions. Versions a channel points
+// at, those listed in inUse and the binaries directly in the directory are
+// always kept. It returns the deleted versions.
+func (idx *Index) Prune(keep int, inUse []string) ([]string, error) {
+	idx.fsMu.Lock()
+	defer idx.fsMu.Unlock()
+
+	protected := make(map[string]bool)
+	for _, v := range inUse {
+		protected[v] = true
+	}
+	for _, ch := range Channels {
+		if v, err := idx.Resolve("", ch); err == nil {
+			protected[v] = true
+		}
+	}
+	idx.mu.RLock()
+	protected[idx.legacy] = true
+	idx.mu.RUnlock()
+
+	var removed []string
+	for i, version := range idx.Versions() {
+		if i < keep || protected[version] {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(idx.dir, version)); err != nil {
+			return removed, err
+		}
+		idx.mu.Lock()
+		delete(idx.releases, version)
+		idx.mu.Unlock()
+		removed = append(removed, version)
+		log.Printf("Pruned agent version %s", version)
+	}
+	return removed, nil
+}
+
+// Resolve returns the version to serve: version itself when set (it must be
+// indexed), otherwise the version of channel ("" means stable).
+func (idx *Index) Resolve(version, channel string) (string, error) {
+	if version != "" {
+		if !idx.HasVersion(version) {
+			return "", ErrUnknownVersion
+		}
+		return version, nil
+	}
+	if channel == "" {
+		channel = ChannelStable
+	}
+	if !validChannel(channel) {
+		return "", ErrUnknownChannel
+	}
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	// Fall back to the more stable channels when this one is not set
+	for i := indexOf(Channels, channel); i >= 0; i-- {
+		if v := idx.channels[Channels[i]]; v != "" && idx.releases[v] != nil {
+			return v, nil
+		}
+	}
+	versions := idx.versionsLocked()
+	if len(versions) == 0 {
+		return "", ErrUnknownVersion
+	}
+	return versions[0], nil
+}
+
+// Find returns the binary of a version for a platform.
+func (idx *Index) Find(osName, arch, version string) (*Binary, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	b, ok := idx.releases[version][osName+"/"+arch]
+	return b, ok
+}
+
+// Get returns the stable binary for a platform.
+func (idx *Index) Get(osName, arch string) (*Binary, bool) {
+	version, err := idx.Resolve("", ChannelStable)
+	if err != nil {
+		return nil, false
+	}
+	return idx.Find(osName, arch, version)
+}
+
+// HasVersion reports whether any binary of version is indexed.
+func (idx *Index) HasVersion(version string) bool {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return len(idx.releases[version]) > 0
+}
+
+// List returns all indexed binaries, newest version first, then by platform.
+func (idx *Index) List() []*Binary {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	var out []*Binary
+	for _, version := range idx.versionsLocked() {
+		start := len(out)
+		for _, b := range idx.releases[version] {
+			out = append(out, b)
+		}
+		platforms := out[start:]
+		sort.Slice(platforms, func(i, j int) bool {
+			return platforms[i].OS+"/"+platforms[i].Arch < platforms[j].OS+"/"+platforms[j].Arch
+		})
+	}
+	return out
+}
+
+// Versions returns the indexed versions, newest first.
+func (idx *Index) Versions() []string {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.versionsLocked()
+}
+
+func (idx *Index) versionsLocked() []string {
+	versions := make([]string, 0, len(idx.releases))
+	for v := range idx.releases {
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return CompareVersions(versions[i], versions[j]) > 0
+	})
+	return versions
+}
+
+// ChannelVersions returns the version each channel currently resolves to.
+// Channels without any binary are left out.
+func (idx *Index) ChannelVersions() map[string]string {
+	out := make(map[string]string, len(Channels))
+	for _, ch := range Channels {
+		if v, err := idx.Resolve("", ch); err == nil {
+			out[ch] = v
+		}
+	}
+	return out
+}
+
+// Len returns the number of indexed binaries.
+func (idx *Index) Len() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	n := 0
+	for _, bins := range idx.releases {
+		n += len(bins)
+	}
+	return n
+}
+
+// Platforms returns the "os/arch" keys of the binaries of a version.
+func (idx *Index) Platforms(version string) []string {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	keys := make([]string, 0, len(idx.releases[version]))
+	for k := range idx.releases[version] {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// PublicKey returns the base64 public key binaries are verified against, or
+// "" when none is configured.
+func (idx *Index) PublicKey() string {
+	if idx.publicKey == nil {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString(idx.publicKey)
+}
+
+// Version returns the stable version ("unknown" when nothing is indexed).
+func (idx *Index) Version() string {
+	version, err := idx.Resolve("", ChannelStable)
+	if err != nil {
+		return "unknown"
+	}
+	return version
+}
+
+// Path returns the file path of a binary.
+func (idx *Index) Path(b *Binary) string {
+	return b.path
+}
+
+// verify checks the signature of a binary. A missing signature (nil) is only
+// accepted with allowUnsigned.
+func (idx *Index) verify(data, sig []byte) error {
+	if sig == nil {
+		if idx.allowUnsigned {
+			return nil
+		}
+		return ErrUnsigned
+	}
+	if len(sig) != ed25519.SignatureSize {
+		return ErrMalformedSignature
+	}
+	if idx.publicKey == nil {
+		if idx.allowUnsigned {
+			// Nothing to check against; serve it like an unsigned binary
+			return nil
+		}
+		return errors.New("no signing public key configured")
+	}
+	if !ed25519.Verify(idx.publicKey, data, sig) {
+		return ErrSignature
+	}
+	return nil
+}
+
+func newBinary(osName, arch, version, name string, data, sig []byte) *Binary {
+	return &Binary{
+		OS:        osName,
+		Arch:      arch,
+		Version:   version,
+		Filename:  name,
+		SHA256:    fmt.Sprintf("%x", sha256.Sum256(data)),
+		Size:      int64(len(data)),
+		Signature: sig,
+	}
+}
+
+// parseFilename extracts OS and arch from "lute-agent-<os>-<arch>[.exe]"
+func parseFilename(name string) (string, string) {
+	name = strings.TrimSuffix(name, ".exe")
+	parts := strings.Split(name, "-")
+	// expect: lute-agent-linux-amd64
+	if len(parts) < 4 {
+		return "", ""
+	}
+	return parts[2], parts[3]
+}
+
+func readVersionFile(dir string) string {
+	data, err := os.ReadFile(filepath.Join(dir, "VERSION"))
+	if err != nil {
+		return "unknown"
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func readChannels(dir string) (map[string]string, error) {
+	channels := make(map[string]string)
+	data, err := os.ReadFile(filepath.Join(dir, channelsFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return channels, nil
+	}
+	if err != nil {
+		return channels, err
+	}
+	if err := json.Unmarshal(data, &channels); err != nil {
+		return make(map[string]string), err
+	}
+	return channels, nil
+}
+
+func writeFileAtomic(path string, data []byte, mode os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func validChannel(channel string) bool {
+	return indexOf(Channels, channel) >= 0
+}
+
+func indexOf(list []string, s string) int {
+	for i, v := range list {
+		if v == s {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,66 @@
+package agentbin
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// SignatureSuffix is appended to a binary's filename to name its detached
+// ed25519 signature. The file holds the raw 64-byte signature as written by
+// "openssl pkeyutl -sign -rawin", or the same bytes base64-encoded.
+const SignatureSuffix = ".sig"
+
+// ErrMalformedSignature is returned for a signature that is not 64 bytes.
+var ErrMalformedSignature = errors.New("malformed signature: expected 64 raw bytes or their base64")
+
+// ParsePublicKey parses an ed25519 public key given as the base64 of its 32
+// raw bytes or as a PEM "PUBLIC KEY" block.
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	s = string(bytes.TrimSpace([]byte(s)))
+	if block, _ := pem.Decode([]byte(s)); block != nil {
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parse signing public key: %w", err)
+		}
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return nil, errors.New("signing public key is not an ed25519 key")
+		}
+		return pub, nil
+	}
+	raw, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("parse signing public key: %w", err)
+	}
+	if len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("signing public key has %d bytes, expected %d", len(raw), ed25519.PublicKeySize)
+	}
+	return ed25519.PublicKey(raw), nil
+}
+
+// readSignature reads the detached signature of the binary at path.
+func readSignature(path string) ([]byte, error) {
+	data, err := os.ReadFile(path + SignatureSuffix)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSignature(data)
+}
+
+// ParseSignature accepts a raw 64-byte ed25519 signature or its base64.
+func ParseSignature(data []byte) ([]byte, error) {
+	if len(data) == ed25519.SignatureSize {
+		return data, nil
+	}
+	sig, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(data)))
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return nil, ErrMalformedSignature
+	}
+	return sig, nil
+}
@@ -0,0 +1,72 @@
+package agentbin
+
+import (
+	"strconv"
+	"strings"
+)
+
+// CompareVersions orders versions like "1.4.0", "v1.5.0-rc.1" and "dev":
+// numeric dot-separated parts compare as numbers, a release sorts after its
+// pre-releases, and anything else compares as text. It returns -1, 0 or 1.
+func CompareVersions(a, b string) int {
+	a, b = strings.TrimPrefix(a, "v"), strings.TrimPrefix(b, "v")
+	// Build metadata does not take part in ordering
+	if i := strings.IndexByte(a, '+'); i >= 0 {
+		a = a[:i]
+	}
+	if i := strings.IndexByte(b, '+'); i >= 0 {
+		b = b[:i]
+	}
+	coreA, preA, _ := strings.Cut(a, "-")
+	coreB, preB, _ := strings.Cut(b, "-")
+	if c := compareParts(strings.Split(coreA, "."), strings.Split(coreB, ".")); c != 0 {
+		return c
+	}
+	switch {
+	case preA == preB:
+		return 0
+	case preA == "":
+		return 1
+	case preB == "":
+		return -1
+	}
+	return compareParts(strings.Split(preA, "."), strings.Split(preB, "."))
+}
+
+func compareParts(a, b []string) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		if i >= len(a) {
+			return -1
+		}
+		if i >= len(b) {
+			return 1
+		}
+		na, errA := strconv.Atoi(a[i])
+		nb, errB := strconv.Atoi(b[i])
+		switch {
+		case errA == nil && errB == nil:
+			if na != nb {
+				return cmpInt(na, nb)
+			}
+		case errA == nil:
+			return -1 // numeric parts sort before text
+		case errB == nil:
+			return 1
+		default:
+			if c := strings.Compare(a[i], b[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	return 0
+}
+
+func cmpInt(a, b int) int {
+	if a < b {
+		return -1
+	}
+	if a > b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,353 @@
+// Package audit records who did what to which machine, organization or token.
+// Events are appended to the audit_events collection and never modified.
+package audit
+
+import (
+	"context"
+	"log"
+	"reflect"
+	"regexp"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/lute/api/authz"
+	"github.com/lute/api/models"
+	"github.com/lute/api/repository"
+)
+
+// Actor types.
+const (
+	ActorUser     = "user"
+	ActorAPIToken = "api_token"
+	ActorAgent    = "agent"
+	ActorSystem   = "system"
+)
+
+// Actions recorded by the API.
+const (
+	ActionMachineCreate      = "machine.create"
+	ActionMachineUpdate      = "machine.update"
+	ActionMachineVisibility  = "machine.visibility"
+	ActionMachineLabels      = "machine.labels"
+	ActionMachineTransfer    = "machine.transfer"
+	ActionMachineReEnable    = "machine.re_enable"
+	ActionMachineDelete      = "machine.delete"
+	ActionConfigSet          = "config.set"
+	ActionConfigDelete       = "config.delete"
+	ActionAgentTokenRotate   = "agent.token_rotate"
+	ActionAgentRevoke        = "agent.revoke"
+	ActionAgentRegister      = "agent.register"
+	ActionClaimCodeCreate    = "claim_code.create"
+	ActionEnrollmentCreate   = "enrollment_token.create"
+	ActionEnrollmentRevoke   = "enrollment_token.revoke"
+	ActionAgentRolloutCreate = "agent_rollout.create"
+	ActionAgentRolloutUpdate = "agent_rollout.update"
+	ActionAgentRolloutDelete = "agent_rollout.delete"
+	ActionAgentReleaseUpload = "agent_release.upload"
+	ActionAgentChannelSet    = "agent_release.channel"
+	ActionAgentReleasePrune  = "agent_release.prune"
+	ActionCommandSend        = "command.send"
+	ActionCommandAction      = "command.action"
+	ActionFileUpload         = "file.upload"
+	ActionFileDownload       = "file.download"
+	ActionJobCreate          = "job.create"
+	ActionJobCancel          = "job.cancel"
+	ActionScheduleCreate     = "schedule.create"
+	ActionScheduleUpdate     = "schedule.update"
+	ActionScheduleDelete     = "schedule.delete"
+	ActionFileEventAck       = "file_event.acknowledge"
+	ActionAlertRuleCreate    = "alert_rule.create"
+	ActionAlertRuleUpdate    = "alert_rule.update"
+	ActionAlertRuleDelete    = "alert_rule.delete"
+	ActionOrgCreate          = "org.create"
+	ActionOrgUpdate          = "org.update"
+	ActionOrgDelete          = "org.delete"
+	ActionOrgMemberRole      = "org.member_role"
+	ActionOrgMemberRemove    = "org.member_remove"
+	ActionOrgInviteCreate    = "org.invite_create"
+	ActionOrgInviteRevoke    = "org.invite_revoke"
+	ActionOrgInviteAccept    = "org.invite_accept"
+	ActionTokenCreate        = "token.create"
+	ActionTokenRevoke        = "token.revoke"
+	ActionAuthLogin          = "auth.login"
+)
+
+// Actor is who performed an action. It is attached to the request context by
+// the auth middleware.
+type Actor struct {
+	Type    string
+	UserID  primitive.ObjectID
+	TokenID primitive.ObjectID
+}
+
+// RequestInfo identifies the HTTP request an action came from.
+type RequestInfo struct {
+	ID        string
+	IP        string
+	UserAgent string
+}
+
+type actorKey struct{}
+type requestKey struct{}
+
+// WithActor returns a context carrying the actor.
+func WithActor(ctx context.Context, actor Actor) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// WithRequest returns a context carrying the request info.
+func WithRequest(ctx context.Context, req RequestInfo) context.Context {
+	return context.WithValue(ctx, requestKey{}, req)
+}
+
+// Target is the object an action was applied to.
+type Target struct {
+	Type    string
+	ID      primitive.ObjectID
+	Name    string
+	OrgID   primitive.ObjectID // org that owns the target, if any
+	OwnerID primitive.ObjectID // user that owns a personal target
+}
+
+// MachineTarget describes a machine as an audit target.
+func MachineTarget(m *models.Machine) Target {
+	t := Target{Type: "machine", ID: m.ID, Name: m.Name, OrgID: m.OrgID}
+	if m.OrgID.IsZero() {
+		t.OwnerID = m.UserID
+	}
+	return t
+}
+
+// OrgTarget describes an organization as an audit target.
+func OrgTarget(orgID primitive.ObjectID, name string) Target {
+	return Target{Type: "organization", ID: orgID, Name: name, OrgID: orgID}
+}
+
+// APITokenTarget describes an API token as an audit target. Org-scoped tokens
+// are also visible to that org's admins.
+func APITokenTarget(t *models.APIToken) Target {
+	return Target{Type: "api_token", ID: t.ID, Name: t.Name, OrgID: t.OrgID, OwnerID: t.UserID}
+}
+
+// GroupTarget describes a machine group as an audit target.
+func GroupTarget(g *models.MachineGroup) Target {
+	t := Target{Type: "group", ID: g.ID, Name: g.Name, OrgID: g.OrgID}
+	if g.OrgID.IsZero() {
+		t.OwnerID = g.UserID
+	}
+	return t
+}
+
+// EnrollmentTokenTarget describes an enrollment token as an audit target.
+func EnrollmentTokenTarget(t *models.EnrollmentToken) Target {
+	target := Target{Type: "enrollment_token", ID: t.ID, Name: t.Name, OrgID: t.OrgID}
+	if t.Kind == models.EnrollmentKindClaimCode {
+		target.Type = "claim_code"
+	}
+	if t.OrgID.IsZero() {
+		target.OwnerID = t.UserID
+	}
+	return target
+}
+
+// AgentRolloutTarget describes an agent rollout as an audit target.
+func AgentRolloutTarget(r *models.AgentRollout) Target {
+	t := Target{Type: "agent_rollout", ID: r.ID, Name: r.Version, OrgID: r.OrgID}
+	if r.OrgID.IsZero() {
+		t.OwnerID = r.UserID
+	}
+	return t
+}
+
+// JobTarget describes a job as an audit target.
+func JobTarget(j *models.Job) Target {
+	t := Target{Type: "job", ID: j.ID, Name: j.Name, OrgID: j.OrgID}
+	if j.OrgID.IsZero() {
+		t.OwnerID = j.UserID
+	}
+	return t
+}
+
+// ScheduleTarget describes a schedule as an audit target.
+func ScheduleTarget(sc *models.Schedule) Target {
+	t := Target{Type: "schedule", ID: sc.ID, Name: sc.Name, OrgID: sc.OrgID}
+	if sc.OrgID.IsZero() {
+		t.OwnerID = sc.UserID
+	}
+	return t
+}
+
+// AlertRuleTarget describes an alert rule as an audit target.
+func AlertRuleTarget(r *models.AlertRule) Target {
+	t := Target{Type: "alert_rule", ID: r.ID, Name: r.Name, OrgID: r.OrgID}
+	if r.OrgID.IsZero() {
+		t.OwnerID = r.UserID
+	}
+	return t
+}
+
+// AgentReleaseTarget describes an agent version as an audit target.
+func AgentReleaseTarget(version string) Target {
+	return Target{Type: "agent_release", Name: version}
+}
+
+// UserTarget describes a user account as an audit target.
+func UserTarget(u *models.User) Target {
+	return Target{Type: "user", ID: u.ID, Name: u.Email, OwnerID: u.ID}
+}
+
+// Entry is one action to record.
+type Entry struct {
+	Action  string
+	Target  Target
+	Changes map[string]models.AuditChange
+	Details map[string]interface{}
+}
+
+// Recorder writes and queries audit events.
+type Recorder struct {
+	repo  *repository.AuditRepository
+	authz *authz.Authorizer
+}
+
+// New creates a Recorder.
+func New(repo *repository.AuditRepository, authorizer *authz.Authorizer) *Recorder {
+	return &Recorder{repo: repo, authz: authorizer}
+}
+
+// Record appends an event, taking actor and request details from ctx. Failures
+// are logged and never fail the action being audited. A nil Recorder is a no-op.
+func (r *Recorder) Record(ctx context.Context, e Entry) {
+	if r == nil {
+		return
+	}
+	event := &models.AuditEvent{
+		At:         time.Now(),
+		ActorType:  ActorSystem,
+		Action:     e.Action,
+		TargetType: e.Target.Type,
+		TargetID:   e.Target.ID,
+		TargetName: e.Target.Name,
+		OrgID:      e.Target.OrgID,
+		OwnerID:    e.Target.OwnerID,
+		Changes:    e.Changes,
+		Details:    e.Details,
+	}
+	if actor, ok := ctx.Value(actorKey{}).(Actor); ok {
+		event.ActorType = actor.Type
+		event.ActorID = actor.UserID
+		event.TokenID = actor.TokenID
+	}
+	if req, ok := ctx.Value(requestKey{}).(RequestInfo); ok {
+		event.RequestID = req.ID
+		event.IP = req.IP
+		event.UserAgent = req.UserAgent
+	}
+	// Write even if the request was cancelled right after the action succeeded
+	writeCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	if err := r.repo.Insert(writeCtx, event); err != nil {
+		log.Printf("Audit: failed to record %s on %s %s: %v", e.Action, e.Target.Type, e.Target.ID.Hex(), err)
+	}
+}
+
+// Filter narrows an audit query. Zero fields are ignored.
+type Filter struct {
+	ActorID    primitive.ObjectID
+	Action     string // exact action, or a prefix ending in "." (e.g. "machine.")
+	TargetType string
+	TargetID   primitive.ObjectID
+	OrgID      primitive.ObjectID
+	From       time.Time
+	To         time.Time
+	Before     primitive.ObjectID // cursor: only events older than this event ID
+	Limit      int64
+}
+
+// Query returns events visible to the user, newest first: events they
+// performed, events on their personal machines, and events in orgs where they
+// may read the audit log (admins and owners).
+func (r *Recorder) Query(ctx context.Context, userID primitive.ObjectID, f Filter) ([]*models.AuditEvent, error) {
+	if err := authz.CheckScope(ctx, authz.ActionAuditRead); err != nil {
+		return nil, err
+	}
+	orgIDs, err := r.authz.OrgIDsAllowing(ctx, userID, authz.ActionAuditRead)
+	if err != nil {
+		return nil, err
+	}
+	visible := []bson.M{{"actor_id": userID}, {"owner_id": userID}}
+	if len(orgIDs) > 0 {
+		visible = append(visible, bson.M{"org_id": bson.M{"$in": orgIDs}})
+	}
+
+	clauses := []bson.M{{"$or": visible}}
+	if !f.ActorID.IsZero() {
+		clauses = append(clauses, bson.M{"actor_id": f.ActorID})
+	}
+	if f.Action != "" {
+		if f.Action[len(f.Action)-1] == '.' {
+			clauses = append(clauses, bson.M{"action": bson.M{"$regex": "^" + regexp.QuoteMeta(f.Action)}})
+		} else {
+			clauses = append(clauses, bson.M{"action": f.Action})
+		}
+	}
+	if f.TargetType != "" {
+		clauses = append(clauses, bson.M{"target_type": f.TargetType})
+	}
+	if !f.TargetID.IsZero() {
+		clauses = append(clauses, bson.M{"target_id": f.TargetID})
+	}
+	if !f.OrgID.IsZero() {
+		clauses = append(clauses, bson.M{"org_id": f.OrgID})
+	}
+	if !f.From.IsZero() {
+		clauses = append(clauses, bson.M{"at": bson.M{"$gte": f.From}})
+	}
+	if !f.To.IsZero() {
+		clauses = append(clauses, bson.M{"at": bson.M{"$lt": f.To}})
+	}
+	if !f.Before.IsZero() {
+		clauses = append(clauses, bson.M{"_id": bson.M{"$lt": f.Before}})
+	}
+	return r.repo.Find(ctx, bson.M{"$and": clauses}, f.Limit)
+}
+
+// Diff returns the fields whose values differ between before and after.
+func Diff(before, after map[string]interface{}) map[string]models.AuditChange {
+	changes := make(map[string]models.AuditChange)
+	for k, b := range before {
+		if a := after[k]; !reflect.DeepEqual(b, a) {
+			changes[k] = models.AuditChange{Before: b, After: a}
+		}
+	}
+	for k, a := range after {
+		if _, seen := before[k]; !seen {
+			changes[k] = models.AuditChange{Before: nil, After: a}
+		}
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	return changes
+}
+
+// MachineFields returns the user-editable fields of a machine for Diff.
+func MachineFields(m *models.Machine) map[string]interface{} {
+	return map[string]interface{}{
+		"name":        m.Name,
+		"description": m.Description,
+		"is_public":   m.IsPublic,
+		"labels":      emptyToNil(m.Labels),
+		"metadata":    emptyToNil(m.Metadata),
+	}
+}
+
+// emptyToNil treats nil and empty maps as equal so they do not show up as changes.
+func emptyToNil[V any](m map[string]V) interface{} {
+	if len(m) == 0 {
+		return nil
+	}
+	return m
+}
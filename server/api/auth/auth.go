@@ -0,0 +1,63 @@
+// Package auth verifies interactive user credentials. The provider is chosen
+// by config.AuthConfig.Provider: Firebase ID tokens, OIDC JWTs validated
+// against a JWKS, or local email/password accounts with server-side sessions.
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/lute/api/config"
+	"github.com/lute/api/models"
+	"github.com/lute/api/repository"
+)
+
+// Provider names accepted in AUTH_PROVIDER.
+const (
+	ProviderFirebase = "firebase"
+	ProviderOIDC     = "oidc"
+	ProviderLocal    = "local"
+)
+
+// ErrInvalidToken is returned when a bearer token cannot be verified.
+var ErrInvalidToken = errors.New("invalid token")
+
+// Authenticator verifies a bearer token and resolves it to a Lute user,
+// creating the user on first sign-in where the provider allows it.
+type Authenticator interface {
+	// Name returns the provider name ("firebase", "oidc" or "local").
+	Name() string
+	// Authenticate verifies the token and returns the user it belongs to.
+	Authenticate(ctx context.Context, token string) (*models.User, error)
+}
+
+// New builds the authenticator selected by cfg.Auth.Provider.
+func New(ctx context.Context, cfg *config.Config, userRepo *repository.UserRepository, sessionRepo *repository.SessionRepository) (Authenticator, error) {
+	switch cfg.Auth.Provider {
+	case ProviderFirebase, "":
+		return NewFirebaseAuthenticator(ctx, cfg.Firebase, userRepo)
+	case ProviderOIDC:
+		return NewOIDCAuthenticator(ctx, cfg.Auth.OIDC, userRepo)
+	case ProviderLocal:
+		return NewLocalAuthenticator(ctx, cfg.Auth.Local, userRepo, sessionRepo)
+	}
+	return nil, fmt.Errorf("unknown auth provider %q (expected firebase, oidc or local)", cfg.Auth.Provider)
+}
+
+// findOrCreateUser returns the user found by lookup, creating newUser when none exists.
+func findOrCreateUser(ctx context.Context, userRepo *repository.UserRepository, lookup func() (*models.User, error), newUser *models.User) (*models.User, error) {
+	user, err := lookup()
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("failed to lookup user: %w", err)
+	}
+	if err := userRepo.Create(ctx, newUser); err != nil {
+		return nil, fmt.Errorf("failed to create user: %w", err)
+	}
+	return newUser, nil
+}
@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	firebase "firebase.google.com/go/v4"
+	fbauth "firebase.google.com/go/v4/auth"
+	"google.golang.org/api/option"
+
+	"github.com/lute/api/config"
+	"github.com/lute/api/models"
+	"github.com/lute/api/repository"
+)
+
+// FirebaseAuthenticator verifies Firebase ID tokens.
+type FirebaseAuthenticator struct {
+	client   *fbauth.Client
+	userRepo *repository.UserRepository
+}
+
+// NewFirebaseAuthenticator initializes the Firebase Admin SDK. Without a
+// project ID every request is rejected (the previous behavior).
+func NewFirebaseAuthenticator(ctx context.Context, cfg config.FirebaseConfig, userRepo *repository.UserRepository) (*FirebaseAuthenticator, error) {
+	a := &FirebaseAuthenticator{userRepo: userRepo}
+	if cfg.ProjectID == "" {
+		log.Println("Warning: FIREBASE_PROJECT_ID not set, Firebase authentication will not work")
+		return a, nil
+	}
+
+	var opts []option.ClientOption
+	if cfg.CredentialsJSON != "" {
+		opts = append(opts, option.WithCredentialsJSON([]byte(cfg.CredentialsJSON)))
+	}
+	app, err := firebase.NewApp(ctx, &firebase.Config{ProjectID: cfg.ProjectID}, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("error initializing Firebase app: %v", err)
+	}
+	client, err := app.Auth(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error getting Auth client: %v", err)
+	}
+	a.client = client
+	log.Println("Firebase initialized successfully")
+	return a, nil
+}
+
+func (a *FirebaseAuthenticator) Name() string { return ProviderFirebase }
+
+// Authenticate verifies a Firebase ID token and looks up (or creates) the user by Firebase UID.
+func (a *FirebaseAuthenticator) Authenticate(ctx context.Context, idToken string) (*models.User, error) {
+	if a.client == nil {
+		return nil, fmt.Errorf("Firebase not initialized")
+	}
+	token, err := a.client.VerifyIDToken(ctx, idToken)
+	if err != nil {
+		return nil, fmt.Errorf("error verifying ID token: %v", err)
+	}
+	email, _ := token.Claims["email"].(string)
+
+	return findOrCreateUser(ctx, a.userRepo,
+		func() (*models.User, error) { return a.userRepo.GetByFirebaseUID(ctx, token.UID) },
+		&models.User{FirebaseUID: token.UID, Email: email},
+	)
+}
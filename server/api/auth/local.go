@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/lute/api/config"
+	"github.com/lute/api/models"
+	"github.com/lute/api/repository"
+	"github.com/lute/api/tokens"
+)
+
+// MinPasswordLength is the shortest password accepted for local accounts.
+const MinPasswordLength = 8
+
+var (
+	ErrInvalidCredentials = errors.New("invalid email or password")
+	ErrSignupDisabled     = errors.New("sign-up is disabled")
+	ErrEmailTaken         = errors.New("an account with this email already exists")
+	ErrWeakPassword       = fmt.Errorf("password must be at least %d characters", MinPasswordLength)
+	ErrSessionExpired     = errors.New("session has expired")
+)
+
+// LocalAuthenticator manages email/password accounts (bcrypt hashes) and
+// opaque server-side sessions. It needs no external service, which makes it
+// suitable for air-gapped installs and integration tests.
+type LocalAuthenticator struct {
+	cfg         config.LocalAuthConfig
+	userRepo    *repository.UserRepository
+	sessionRepo *repository.SessionRepository
+}
+
+// NewLocalAuthenticator creates the local provider and, when LOCAL_ADMIN_EMAIL
+// and LOCAL_ADMIN_PASSWORD are set, creates that account if it does not exist.
+func NewLocalAuthenticator(ctx context.Context, cfg config.LocalAuthConfig, userRepo *repository.UserRepository, sessionRepo *repository.SessionRepository) (*LocalAuthenticator, error) {
+	a := &LocalAuthenticator{cfg: cfg, userRepo: userRepo, sessionRepo: sessionRepo}
+	if cfg.AdminEmail != "" && cfg.AdminPassword != "" {
+		if _, err := a.createUser(ctx, cfg.AdminEmail, cfg.AdminPassword, "Administrator"); err != nil && err != ErrEmailTaken {
+			return nil, fmt.Errorf("failed to create local admin: %w", err)
+		} else if err == nil {
+			log.Printf("Local auth: created admin account %s", cfg.AdminEmail)
+		}
+	}
+	log.Println("Local authentication enabled")
+	return a, nil
+}
+
+func (a *LocalAuthenticator) Name() string { return ProviderLocal }
+
+// Authenticate resolves a session token to its user.
+func (a *LocalAuthenticator) Authenticate(ctx context.Context, token string) (*models.User, error) {
+	session, err := a.sessionRepo.GetByTokenHash(ctx, tokens.Hash(token))
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+	if time.Now().After(session.ExpiresAt) {
+		return nil, ErrSessionExpired
+	}
+	return a.userRepo.GetByID(ctx, session.UserID)
+}
+
+// Register creates a local account when sign-up is enabled.
+func (a *LocalAuthenticator) Register(ctx context.Context, email, password, displayName string) (*models.User, error) {
+	if !a.cfg.AllowSignup {
+		return nil, ErrSignupDisabled
+	}
+	return a.createUser(ctx, email, password, displayName)
+}
+
+// Login checks the password and starts a session. The returned token is the
+// bearer token for subsequent requests; only its hash is stored.
+func (a *LocalAuthenticator) Login(ctx context.Context, email, password string) (string, *models.Session, *models.User, error) {
+	user, err := a.userRepo.GetByEmail(ctx, normalizeEmail(email))
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return "", nil, nil, ErrInvalidCredentials
+		}
+		return "", nil, nil, err
+	}
+	if user.PasswordHash == "" || bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return "", nil, nil, ErrInvalidCredentials
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, nil, err
+	}
+	token := hex.EncodeToString(b)
+	session := &models.Session{
+		UserID:    user.ID,
+		TokenHash: tokens.Hash(token),
+		ExpiresAt: time.Now().Add(a.cfg.SessionTTL),
+	}
+	if err := a.sessionRepo.Create(ctx, session); err != nil {
+		return "", nil, nil, err
+	}
+	return token, session, user, nil
+}
+
+// Logout ends the session of the given token.
+func (a *LocalAuthenticator) Logout(ctx context.Context, token string) error {
+	return a.sessionRepo.DeleteByTokenHash(ctx, tokens.Hash(token))
+}
+
+func (a *LocalAuthenticator) createUser(ctx context.Context, email, password, displayName string) (*models.User, error) {
+	email = normalizeEmail(email)
+	if email == "" || !strings.Contains(email, "@") {
+		return nil, fmt.Errorf("a valid email is required")
+	}
+	if len(password) < MinPasswordLength {
+		return nil, ErrWeakPassword
+	}
+	if _, err := a.userRepo.GetByEmail(ctx, email); err == nil {
+		return nil, ErrEmailTaken
+	} else if err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	user := &models.User{
+		Email:        email,
+		DisplayName:  displayName,
+		PasswordHash: string(hash),
+	}
+	if err := a.userRepo.Create(ctx, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
@@ -0,0 +1,129 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/MicahParks/keyfunc"
+	"github.com/golang-jwt/jwt/v4"
+
+	"github.com/lute/api/config"
+	"github.com/lute/api/models"
+	"github.com/lute/api/repository"
+)
+
+// oidcSigningMethods are the JWT algorithms accepted from the identity provider.
+var oidcSigningMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// OIDCAuthenticator validates JWTs issued by a generic OpenID Connect provider
+// (Keycloak, Dex, Authentik, Azure AD, ...) against the provider's JWKS.
+type OIDCAuthenticator struct {
+	cfg      config.OIDCConfig
+	jwks     *keyfunc.JWKS
+	parser   *jwt.Parser
+	userRepo *repository.UserRepository
+}
+
+// NewOIDCAuthenticator fetches the JWKS (discovered from the issuer when
+// OIDC_JWKS_URL is not set) and keeps it refreshed in the background.
+func NewOIDCAuthenticator(ctx context.Context, cfg config.OIDCConfig, userRepo *repository.UserRepository) (*OIDCAuthenticator, error) {
+	if cfg.Issuer == "" || cfg.Audience == "" {
+		return nil, fmt.Errorf("OIDC_ISSUER and OIDC_AUDIENCE are required for the oidc auth provider")
+	}
+	if cfg.EmailClaim == "" {
+		cfg.EmailClaim = "email"
+	}
+	jwksURL := cfg.JWKSURL
+	if jwksURL == "" {
+		var err error
+		if jwksURL, err = discoverJWKSURL(ctx, cfg.Issuer); err != nil {
+			return nil, err
+		}
+	}
+	jwks, err := keyfunc.Get(jwksURL, keyfunc.Options{
+		Ctx:               ctx,
+		RefreshInterval:   time.Hour,
+		RefreshRateLimit:  5 * time.Minute,
+		RefreshTimeout:    10 * time.Second,
+		RefreshUnknownKID: true,
+		RefreshErrorHandler: func(err error) {
+			log.Printf("OIDC: failed to refresh JWKS from %s: %v", jwksURL, err)
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load OIDC JWKS from %s: %w", jwksURL, err)
+	}
+	log.Printf("OIDC authentication enabled (issuer %s)", cfg.Issuer)
+	return &OIDCAuthenticator{
+		cfg:      cfg,
+		jwks:     jwks,
+		parser:   jwt.NewParser(jwt.WithValidMethods(oidcSigningMethods)),
+		userRepo: userRepo,
+	}, nil
+}
+
+func (a *OIDCAuthenticator) Name() string { return ProviderOIDC }
+
+// Authenticate validates signature, issuer, audience and expiry, then looks up
+// (or creates) the user by the token's subject.
+func (a *OIDCAuthenticator) Authenticate(ctx context.Context, raw string) (*models.User, error) {
+	claims := jwt.MapClaims{}
+	if _, err := a.parser.ParseWithClaims(raw, claims, a.jwks.Keyfunc); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	// The parser accepts tokens without exp; ID tokens must expire
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return nil, fmt.Errorf("%w: missing or past expiry", ErrInvalidToken)
+	}
+	if !claims.VerifyIssuer(a.cfg.Issuer, true) {
+		return nil, fmt.Errorf("%w: unexpected issuer", ErrInvalidToken)
+	}
+	if !claims.VerifyAudience(a.cfg.Audience, true) {
+		return nil, fmt.Errorf("%w: unexpected audience", ErrInvalidToken)
+	}
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return nil, fmt.Errorf("%w: missing subject", ErrInvalidToken)
+	}
+	email, _ := claims[a.cfg.EmailClaim].(string)
+	name, _ := claims["name"].(string)
+
+	return findOrCreateUser(ctx, a.userRepo,
+		func() (*models.User, error) { return a.userRepo.GetByOIDCSubject(ctx, subject) },
+		&models.User{OIDCSubject: subject, Email: email, DisplayName: name},
+	)
+}
+
+// discoverJWKSURL reads jwks_uri from the issuer's OpenID configuration.
+func discoverJWKSURL(ctx context.Context, issuer string) (string, error) {
+	url := strings.TrimRight(issuer, "/") + "/.well-known/openid-configuration"
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("OIDC discovery failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("OIDC discovery failed: %s returned %s", url, resp.Status)
+	}
+	var doc struct {
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return "", fmt.Errorf("OIDC discovery failed: %w", err)
+	}
+	if doc.JWKSURI == "" {
+		return "", fmt.Errorf("OIDC discovery failed: no jwks_uri in %s", url)
+	}
+	return doc.JWKSURI, nil
+}
@@ -0,0 +1,253 @@
+package authz
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/lute/api/models"
+	"github.com/lute/api/repository"
+)
+
+// ErrForbidden is returned when the caller lacks the permission for an action.
+var ErrForbidden = errors.New("forbidden: insufficient permissions")
+
+// Role is a member's role inside an organization.
+type Role string
+
+const (
+	RoleOwner    Role = "owner"
+	RoleAdmin    Role = "admin"
+	RoleOperator Role = "operator"
+	RoleViewer   Role = "viewer"
+)
+
+// rank orders roles from least (1) to most (4) privileged; 0 means no role.
+func (r Role) rank() int {
+	switch r {
+	case RoleViewer:
+		return 1
+	case RoleOperator:
+		return 2
+	case RoleAdmin:
+		return 3
+	case RoleOwner:
+		return 4
+	}
+	return 0
+}
+
+// Valid reports whether r is one of the known roles.
+func (r Role) Valid() bool {
+	return r.rank() > 0
+}
+
+// AtLeast reports whether r is as privileged as other.
+func (r Role) AtLeast(other Role) bool {
+	return r.rank() >= other.rank() && r.rank() > 0
+}
+
+// Action is something a user can do to a machine or organization.
+type Action string
+
+const (
+	ActionMachineRead    Action = "machine:read"
+	ActionMachineOperate Action = "machine:operate" // re-enable, acknowledge events
+	ActionCommandExecute Action = "command:execute"
+	ActionMachineCreate  Action = "machine:create" // create machines, claim codes
+	ActionMachineWrite   Action = "machine:write"  // rename, labels, visibility
+	ActionMachineDelete  Action = "machine:delete"
+	ActionOrgRead        Action = "org:read"
+	ActionOrgManage      Action = "org:manage" // rename, members, invites
+	ActionOrgDelete      Action = "org:delete"
+	ActionAuditRead      Action = "audit:read"
+	ActionAlertManage    Action = "alert:manage" // alert rules
+	// ActionAgentRelease manages the agent builds served by this instance. No
+	// org role grants it; see AGENT_RELEASE_ADMINS.
+	ActionAgentRelease Action = "agent:release"
+)
+
+// minRole is the permission matrix: the least privileged role allowed to perform each action.
+var minRole = map[Action]Role{
+	ActionMachineRead:    RoleViewer,
+	ActionOrgRead:        RoleViewer,
+	ActionMachineOperate: RoleOperator,
+	ActionCommandExecute: RoleOperator,
+	ActionAlertManage:    RoleOperator,
+	ActionMachineCreate:  RoleAdmin,
+	ActionMachineWrite:   RoleAdmin,
+	ActionMachineDelete:  RoleAdmin,
+	ActionOrgManage:      RoleAdmin,
+	ActionAuditRead:      RoleAdmin,
+	ActionOrgDelete:      RoleOwner,
+}
+
+// Allows reports whether role may perform action.
+func Allows(role Role, action Action) bool {
+	min, ok := minRole[action]
+	if !ok {
+		return false
+	}
+	return role.AtLeast(min)
+}
+
+// Authorizer is the single place where access to machines and organizations is decided.
+// Personal machines (no org) grant their owner the owner role; org machines use the
+// caller's membership role in that org.
+type Authorizer struct {
+	memberRepo *repository.OrgMemberRepository
+}
+
+// New creates an Authorizer.
+func New(memberRepo *repository.OrgMemberRepository) *Authorizer {
+	return &Authorizer{memberRepo: memberRepo}
+}
+
+// OrgRole returns the user's role in the org, or "" when the user is not a member.
+func (a *Authorizer) OrgRole(ctx context.Context, userID, orgID primitive.ObjectID) (Role, error) {
+	member, err := a.memberRepo.Get(ctx, orgID, userID)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return "", nil
+		}
+		return "", err
+	}
+	return Role(member.Role), nil
+}
+
+// AuthorizeOrg checks that the user may perform action in the org and returns their role.
+func (a *Authorizer) AuthorizeOrg(ctx context.Context, userID, orgID primitive.ObjectID, action Action) (Role, error) {
+	if err := CheckScope(ctx, action); err != nil {
+		return "", err
+	}
+	if err := checkTokenOrg(ctx, orgID); err != nil {
+		return "", err
+	}
+	role, err := a.OrgRole(ctx, userID, orgID)
+	if err != nil {
+		return "", err
+	}
+	if !Allows(role, action) {
+		return role, ErrForbidden
+	}
+	return role, nil
+}
+
+// MachineRole returns the user's effective role on a machine ("" for none).
+func (a *Authorizer) MachineRole(ctx context.Context, userID primitive.ObjectID, machine *models.Machine) (Role, error) {
+	if !machine.OrgID.IsZero() {
+		return a.OrgRole(ctx, userID, machine.OrgID)
+	}
+	switch machine.UserID {
+	case userID:
+		return RoleOwner, nil
+	case primitive.NilObjectID:
+		// Legacy machines registered without an owner are visible to everyone.
+		return RoleViewer, nil
+	}
+	return "", nil
+}
+
+// AuthorizeMachine checks that the user may perform action on the machine.
+// Public machines are readable by anyone. Requests made with an API token are
+// additionally limited by the token's scopes and organization.
+func (a *Authorizer) AuthorizeMachine(ctx context.Context, userID primitive.ObjectID, machine *models.Machine, action Action) error {
+	if action == ActionMachineRead && machine.IsPublic {
+		return nil
+	}
+	if err := CheckScope(ctx, action); err != nil {
+		return err
+	}
+	if err := checkTokenOrg(ctx, machine.OrgID); err != nil {
+		return err
+	}
+	role, err := a.MachineRole(ctx, userID, machine)
+	if err != nil {
+		return err
+	}
+	if !Allows(role, action) {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// GroupRole returns the user's effective role on a machine group ("" for
+// none): their org role for org groups, owner for their personal groups.
+func (a *Authorizer) GroupRole(ctx context.Context, userID primitive.ObjectID, group *models.MachineGroup) (Role, error) {
+	if !group.OrgID.IsZero() {
+		return a.OrgRole(ctx, userID, group.OrgID)
+	}
+	if group.UserID == userID {
+		return RoleOwner, nil
+	}
+	return "", nil
+}
+
+// AuthorizeGroup checks that the user may perform action on the group:
+// ActionMachineRead to see it and target it, ActionMachineWrite to change
+// it or its members.
+func (a *Authorizer) AuthorizeGroup(ctx context.Context, userID primitive.ObjectID, group *models.MachineGroup, action Action) error {
+	if err := CheckScope(ctx, action); err != nil {
+		return err
+	}
+	if err := checkTokenOrg(ctx, group.OrgID); err != nil {
+		return err
+	}
+	role, err := a.GroupRole(ctx, userID, group)
+	if err != nil {
+		return err
+	}
+	if !Allows(role, action) {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// AuthorizePersonal checks an action on the caller's personal (non-org) machines,
+// e.g. creating one. Only API token restrictions apply.
+func (a *Authorizer) AuthorizePersonal(ctx context.Context, action Action) error {
+	if err := CheckScope(ctx, action); err != nil {
+		return err
+	}
+	return checkTokenOrg(ctx, primitive.NilObjectID)
+}
+
+// OrgIDs returns the IDs of every org the user belongs to. For an org-scoped
+// API token only that org is returned.
+func (a *Authorizer) OrgIDs(ctx context.Context, userID primitive.ObjectID) ([]primitive.ObjectID, error) {
+	members, err := a.memberRepo.GetByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	grant := TokenGrantFromContext(ctx)
+	ids := make([]primitive.ObjectID, 0, len(members))
+	for _, m := range members {
+		if grant != nil && !grant.OrgID.IsZero() && grant.OrgID != m.OrgID {
+			continue
+		}
+		ids = append(ids, m.OrgID)
+	}
+	return ids, nil
+}
+
+// OrgIDsAllowing returns the IDs of the orgs in which the user may perform
+// action. It fails with ErrForbidden for an API token without the scope.
+func (a *Authorizer) OrgIDsAllowing(ctx context.Context, userID primitive.ObjectID, action Action) ([]primitive.ObjectID, error) {
+	if err := CheckScope(ctx, action); err != nil {
+		return nil, err
+	}
+	members, err := a.memberRepo.GetByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	var ids []primitive.ObjectID
+	for _, m := range members {
+		if checkTokenOrg(ctx, m.OrgID) != nil || !Allows(Role(m.Role), action) {
+			continue
+		}
+		ids = append(ids, m.OrgID)
+	}
+	return ids, nil
+}
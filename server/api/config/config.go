@@ -1,26 +1,61 @@
 package config
 
 import (
+	"errors"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
 type Config struct {
-	Server      ServerConfig
-	MongoDB     MongoDBConfig
-	GRPC        GRPCConfig
-	Heartbeat   HeartbeatConfig
-	WebSocket   WebSocketConfig
-	Firebase    FirebaseConfig
-	AgentBinary AgentBinaryConfig
-	Metrics     MetricsConfig
+	Server          ServerConfig
+	MongoDB         MongoDBConfig
+	GRPC            GRPCConfig
+	Heartbeat       HeartbeatConfig
+	WebSocket       WebSocketConfig
+	Firebase        FirebaseConfig
+	Auth            AuthConfig
+	AgentBinary     AgentBinaryConfig
+	Metrics         MetricsConfig
+	Mail            MailConfig
+	Vulnerabilities VulnerabilityConfig
+	FileTransfer    FileTransferConfig
 }
 
-// MetricsConfig controls machine snapshot job and dashboard polling.
+// FileTransferConfig limits files copied to and from machines. Agents
+// apply their own limit and path allowlists as well.
+type FileTransferConfig struct {
+	MaxSize int64 // bytes
+}
+
+// VulnerabilityConfig locates the offline advisory feed. FeedPaths are OSV
+// JSON files, zip dumps or directories of them; they are imported on start
+// and again whenever a file changes. With none, no vulnerabilities are found.
+type VulnerabilityConfig struct {
+	FeedPaths      []string
+	ReloadInterval time.Duration // how often the files are checked for changes
+}
+
+// MailConfig configures outgoing email (organization invites).
+// When SMTPHost is empty, emails are written to the log instead.
+type MailConfig struct {
+	SMTPHost string
+	SMTPPort string
+	Username string
+	Password string
+	From     string
+}
+
+// MetricsConfig controls machine snapshot job, how long snapshots and
+// their rollups are kept, and dashboard polling.
 type MetricsConfig struct {
 	// SnapshotInterval is how often the snapshot job runs (e.g. 5m). UI should poll at this interval.
 	SnapshotInterval time.Duration
+	// Retention of raw snapshots and of their per-minute and per-hour rollups.
+	RawRetention    time.Duration
+	MinuteRetention time.Duration
+	HourRetention   time.Duration
 }
 
 type HeartbeatConfig struct {
@@ -31,6 +66,15 @@ type HeartbeatConfig struct {
 
 type AgentBinaryConfig struct {
 	Dir string // directory containing compiled agent binaries
+	// SigningPublicKey is the ed25519 key agent binaries are signed with
+	// (base64 of the raw key or PEM). Binaries without a valid signature
+	// are not served.
+	SigningPublicKey string
+	AllowUnsigned    bool // serve binaries without a signature (development only)
+	// ReleaseAdmins are the emails of the users who may upload agent builds
+	// and move release channels.
+	ReleaseAdmins []string
+	KeepVersions  int // versions kept when pruning, besides those in use
 }
 
 type ServerConfig struct {
@@ -40,6 +84,7 @@ type ServerConfig struct {
 	WriteTimeout time.Duration
 	IdleTimeout  time.Duration
 	Mode         string // "debug", "release", "test"
+	PublicURL    string // base URL of the web UI, used in links sent by email
 }
 
 type MongoDBConfig struct {
@@ -50,8 +95,20 @@ type MongoDBConfig struct {
 }
 
 type GRPCConfig struct {
-	Port string
-	Host string
+	Port          string
+	Host          string
+	TLS           GRPCTLSConfig
+	AgentTokenTTL time.Duration // agent tokens older than this are rotated on connect (0 = never)
+}
+
+// GRPCTLSConfig configures mutual TLS for agent connections. The API acts as a
+// small CA: it signs its own server certificate and one client certificate per
+// machine. The CA key is kept in CADir and created on first start.
+type GRPCTLSConfig struct {
+	CADir         string
+	ServerName    string   // name agents verify the server certificate against
+	ExtraHosts    []string // additional DNS names / IPs for the server certificate
+	ClientCertTTL time.Duration
 }
 
 type WebSocketConfig struct {
@@ -63,6 +120,28 @@ type WebSocketConfig struct {
 	WriteWait       time.Duration
 }
 
+// AuthConfig selects how interactive users sign in: "firebase" (default),
+// "oidc" (JWTs validated against a JWKS) or "local" (email/password).
+type AuthConfig struct {
+	Provider string
+	OIDC     OIDCConfig
+	Local    LocalAuthConfig
+}
+
+type OIDCConfig struct {
+	Issuer     string // expected "iss"; also used for discovery when JWKSURL is empty
+	Audience   string // expected "aud" (client ID); required
+	JWKSURL    string
+	EmailClaim string // claim holding the user's email (default "email")
+}
+
+type LocalAuthConfig struct {
+	SessionTTL    time.Duration
+	AllowSignup   bool
+	AdminEmail    string // created on startup if missing
+	AdminPassword string
+}
+
 type FirebaseConfig struct {
 	ProjectID        string
 	CredentialsJSON  string
@@ -77,6 +156,7 @@ func Load() (*Config, error) {
 			WriteTimeout: getDurationEnv("SERVER_WRITE_TIMEOUT", 15*time.Second),
 			IdleTimeout:  getDurationEnv("SERVER_IDLE_TIMEOUT", 60*time.Second),
 			Mode:         getEnv("GIN_MODE", "debug"),
+			PublicURL:    getEnv("PUBLIC_URL", "http://localhost:3000"),
 		},
 		MongoDB: MongoDBConfig{
 			URI:            getEnv("MONGODB_URI", "mongodb://localhost:27017"),
@@ -87,6 +167,13 @@ func Load() (*Config, error) {
 		GRPC: GRPCConfig{
 			Port: getEnv("GRPC_PORT", "50051"),
 			Host: getEnv("GRPC_HOST", "0.0.0.0"),
+			TLS: GRPCTLSConfig{
+				CADir:         getEnv("GRPC_CA_DIR", "/var/lib/lute/ca"),
+				ServerName:    getEnv("GRPC_TLS_SERVER_NAME", "lute-grpc"),
+				ExtraHosts:    getListEnv("GRPC_TLS_HOSTS"),
+				ClientCertTTL: getDurationEnv("AGENT_CERT_TTL", 90*24*time.Hour),
+			},
+			AgentTokenTTL: getDurationEnv("AGENT_TOKEN_TTL", 30*24*time.Hour),
 		},
 		Heartbeat: HeartbeatConfig{
 			CheckInterval: getDurationEnv("HEARTBEAT_CHECK_INTERVAL", 30*time.Second),
@@ -105,12 +192,55 @@ func Load() (*Config, error) {
 			ProjectID:       getEnv("FIREBASE_PROJECT_ID", ""),
 			CredentialsJSON: getEnv("FIREBASE_CREDENTIALS_JSON", ""),
 		},
+		Auth: AuthConfig{
+			Provider: getEnv("AUTH_PROVIDER", "firebase"),
+			OIDC: OIDCConfig{
+				Issuer:     getEnv("OIDC_ISSUER", ""),
+				Audience:   getEnv("OIDC_AUDIENCE", ""),
+				JWKSURL:    getEnv("OIDC_JWKS_URL", ""),
+				EmailClaim: getEnv("OIDC_EMAIL_CLAIM", "email"),
+			},
+			Local: LocalAuthConfig{
+				SessionTTL:    getDurationEnv("LOCAL_SESSION_TTL", 7*24*time.Hour),
+				AllowSignup:   getBoolEnv("LOCAL_ALLOW_SIGNUP", false),
+				AdminEmail:    getEnv("LOCAL_ADMIN_EMAIL", ""),
+				AdminPassword: getEnv("LOCAL_ADMIN_PASSWORD", ""),
+			},
+		},
 		AgentBinary: AgentBinaryConfig{
-			Dir: getEnv("AGENT_BINARY_DIR", "/opt/lute/agent-binaries"),
+			Dir:              getEnv("AGENT_BINARY_DIR", "/opt/lute/agent-binaries"),
+			SigningPublicKey: getEnv("AGENT_SIGNING_PUBLIC_KEY", ""),
+			AllowUnsigned:    getBoolEnv("AGENT_ALLOW_UNSIGNED", false),
+			ReleaseAdmins:    getListEnv("AGENT_RELEASE_ADMINS"),
+			KeepVersions:     getIntEnv("AGENT_KEEP_VERSIONS", 5),
 		},
 		Metrics: MetricsConfig{
 			SnapshotInterval: getDurationEnv("METRICS_SNAPSHOT_INTERVAL", 5*time.Minute),
+			RawRetention:     time.Duration(getIntEnv("METRICS_RAW_RETENTION_DAYS", 7)) * 24 * time.Hour,
+			MinuteRetention:  time.Duration(getIntEnv("METRICS_1M_RETENTION_DAYS", 30)) * 24 * time.Hour,
+			HourRetention:    time.Duration(getIntEnv("METRICS_1H_RETENTION_DAYS", 365)) * 24 * time.Hour,
+		},
+		Mail: MailConfig{
+			SMTPHost: getEnv("SMTP_HOST", ""),
+			SMTPPort: getEnv("SMTP_PORT", "587"),
+			Username: getEnv("SMTP_USERNAME", ""),
+			Password: getEnv("SMTP_PASSWORD", ""),
+			From:     getEnv("SMTP_FROM", "lute@localhost"),
 		},
+		Vulnerabilities: VulnerabilityConfig{
+			FeedPaths:      getListEnv("VULN_FEED_PATHS"),
+			ReloadInterval: getDurationEnv("VULN_FEED_RELOAD_INTERVAL", time.Hour),
+		},
+		FileTransfer: FileTransferConfig{
+			MaxSize: int64(getIntEnv("FILE_TRANSFER_MAX_SIZE_MB", 100)) << 20,
+		},
+	}
+
+	if cfg.Auth.Provider == "oidc" && (cfg.Auth.OIDC.Issuer == "" || cfg.Auth.OIDC.Audience == "") {
+		return nil, errors.New("OIDC_ISSUER and OIDC_AUDIENCE are required with AUTH_PROVIDER=oidc")
+	}
+	if cfg.Metrics.RawRetention <= 0 || cfg.Metrics.MinuteRetention <= 0 || cfg.Metrics.HourRetention <= 0 {
+		return nil, errors.New("METRICS_RAW_RETENTION_DAYS, METRICS_1M_RETENTION_DAYS and METRICS_1H_RETENTION_DAYS must be at least 1")
 	}
 
 	return cfg, nil
@@ -123,6 +253,17 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+// getListEnv returns the comma-separated values of key, or nil when unset.
+func getListEnv(key string) []string {
+	var out []string
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
 func getIntEnv(key string, defaultValue int) int {
 	if value := os.Getenv(key); value != "" {
 		if intValue, err := strconv.Atoi(value); err == nil {
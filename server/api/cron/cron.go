@@ -0,0 +1,243 @@
+// Package cron parses standard five-field cron expressions ("minute hour
+// day-of-month month day-of-week") and computes when they next fire in a
+// time zone.
+//
+// Fields accept "*", numbers, ranges ("1-5"), steps ("*/15", "10-50/20") and
+// comma-separated lists of those. Months and weekdays may be given by their
+// three-letter English names ("jan", "mon"); Sunday is 0 or 7. The macros
+// @yearly, @annually, @monthly, @weekly, @daily, @midnight and @hourly are
+// accepted too. As in Vixie cron, when both day-of-month and day-of-week are
+// restricted a day matches if either does.
+package cron
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalid is wrapped by every parse error.
+var ErrInvalid = errors.New("invalid cron expression")
+
+// maxSearchDays bounds the search for the next run, so that expressions
+// which rarely fire (e.g. "0 0 29 2 *") still terminate quickly.
+const maxSearchDays = 8 * 366
+
+var macros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var (
+	monthNames = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	dayNames   = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+type field struct {
+	name     string
+	min, max int
+	names    []string // names[i] stands for min+i
+}
+
+var (
+	minuteField = field{name: "minute", min: 0, max: 59}
+	hourField   = field{name: "hour", min: 0, max: 23}
+	domField    = field{name: "day of month", min: 1, max: 31}
+	monthField  = field{name: "month", min: 1, max: 12, names: monthNames}
+	dowField    = field{name: "day of week", min: 0, max: 7, names: dayNames}
+)
+
+// Schedule is a parsed cron expression. Each field is a bit set of the
+// values it matches.
+type Schedule struct {
+	minute, hour, dom, month, dow uint64
+	// domAny and dowAny record a "*" day field, which decides whether the
+	// day fields are combined with AND (one is "*") or OR.
+	domAny, dowAny bool
+}
+
+// Parse parses a cron expression.
+func Parse(expr string) (*Schedule, error) {
+	expr = strings.TrimSpace(expr)
+	if expanded, ok := macros[strings.ToLower(expr)]; ok {
+		expr = expanded
+	} else if strings.HasPrefix(expr, "@") {
+		return nil, fmt.Errorf("%w: unknown macro %q", ErrInvalid, expr)
+	}
+	parts := strings.Fields(expr)
+	if len(parts) != 5 {
+		return nil, fmt.Errorf("%w: want 5 fields (minute hour day-of-month month day-of-week), got %d", ErrInvalid, len(parts))
+	}
+	s := &Schedule{}
+	var err error
+	if s.minute, err = minuteField.parse(parts[0]); err != nil {
+		return nil, err
+	}
+	if s.hour, err = hourField.parse(parts[1]); err != nil {
+		return nil, err
+	}
+	if s.dom, err = domField.parse(parts[2]); err != nil {
+		return nil, err
+	}
+	if s.month, err = monthField.parse(parts[3]); err != nil {
+		return nil, err
+	}
+	if s.dow, err = dowField.parse(parts[4]); err != nil {
+		return nil, err
+	}
+	// Sunday may be written as 7
+	if s.dow&(1<<7) != 0 {
+		s.dow = s.dow&^(1<<7) | 1
+	}
+	s.domAny = strings.HasPrefix(parts[2], "*")
+	s.dowAny = strings.HasPrefix(parts[4], "*")
+	return s, nil
+}
+
+// Next returns the first time after t at which the schedule fires, in t's
+// location, or the zero time if it does not fire within the next eight
+// years. Wall-clock times skipped by a daylight saving change do not fire;
+// a time repeated by one fires once.
+func (s *Schedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	y, m, d := t.Date()
+	for i := 0; i < maxSearchDays; i++ {
+		day := time.Date(y, m, d+i, 0, 0, 0, 0, loc)
+		if !s.matchesDay(day) {
+			continue
+		}
+		dy, dm, dd := day.Date()
+		for h := 0; h < 24; h++ {
+			if s.hour&(1<<h) == 0 {
+				continue
+			}
+			for min := 0; min < 60; min++ {
+				if s.minute&(1<<min) == 0 {
+					continue
+				}
+				next := time.Date(dy, dm, dd, h, min, 0, 0, loc)
+				if next.Hour() != h || next.Minute() != min {
+					continue // skipped by a daylight saving change
+				}
+				if next.After(t) {
+					return next
+				}
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// NextN returns the next n times after t at which the schedule fires.
+func (s *Schedule) NextN(t time.Time, n int) []time.Time {
+	var out []time.Time
+	for len(out) < n {
+		t = s.Next(t)
+		if t.IsZero() {
+			break
+		}
+		out = append(out, t)
+	}
+	return out
+}
+
+func (s *Schedule) matchesDay(day time.Time) bool {
+	if s.month&(1<<uint(day.Month())) == 0 {
+		return false
+	}
+	domMatch := s.dom&(1<<uint(day.Day())) != 0
+	dowMatch := s.dow&(1<<uint(day.Weekday())) != 0
+	switch {
+	case s.domAny || s.dowAny:
+		return domMatch && dowMatch
+	default:
+		return domMatch || dowMatch
+	}
+}
+
+// parse returns the bit set of the values matched by a field expression.
+func (f field) parse(expr string) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(expr, ",") {
+		bits, err := f.parsePart(part)
+		if err != nil {
+			return 0, err
+		}
+		set |= bits
+	}
+	return set, nil
+}
+
+func (f field) parsePart(part string) (uint64, error) {
+	rangeExpr, stepExpr, hasStep := strings.Cut(part, "/")
+	step := 1
+	if hasStep {
+		n, err := strconv.Atoi(stepExpr)
+		if err != nil || n < 1 {
+			return 0, fmt.Errorf("%w: %s step %q must be a positive number", ErrInvalid, f.name, stepExpr)
+		}
+		step = n
+	}
+
+	var lo, hi int
+	switch {
+	case rangeExpr == "*":
+		lo, hi = f.min, f.max
+		if f.max == 7 {
+			hi = 6 // "*" in day of week need not include 7 as well as 0
+		}
+	case strings.Contains(rangeExpr, "-"):
+		loExpr, hiExpr, _ := strings.Cut(rangeExpr, "-")
+		var err error
+		if lo, err = f.value(loExpr); err != nil {
+			return 0, err
+		}
+		if hi, err = f.value(hiExpr); err != nil {
+			return 0, err
+		}
+		if lo > hi {
+			return 0, fmt.Errorf("%w: %s range %q ends before it starts", ErrInvalid, f.name, rangeExpr)
+		}
+	default:
+		var err error
+		if lo, err = f.value(rangeExpr); err != nil {
+			return 0, err
+		}
+		hi = lo
+		if hasStep {
+			hi = f.max // "5/15" means "5-max/15"
+		}
+	}
+
+	var set uint64
+	for v := lo; v <= hi; v += step {
+		set |= 1 << uint(v)
+	}
+	return set, nil
+}
+
+func (f field) value(s string) (int, error) {
+	if s == "" {
+		return 0, fmt.Errorf("%w: empty %s", ErrInvalid, f.name)
+	}
+	for i, name := range f.names {
+		if strings.EqualFold(s, name) {
+			return f.min + i, nil
+		}
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s %q is not a number", ErrInvalid, f.name, s)
+	}
+	if n < f.min || n > f.max {
+		return 0, fmt.Errorf("%w: %s %d is outside %d-%d", ErrInvalid, f.name, n, f.min, f.max)
+	}
+	return n, nil
+}
@@ -0,0 +1,125 @@
+package cron_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lute/api/cron"
+)
+
+const wallClock = "2006-01-02T15:04"
+
+func TestParseInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"a * * * *",
+		"1,,2 * * * *",
+		"* * * foo *",
+		"@every",
+	} {
+		if _, err := cron.Parse(expr); !errors.Is(err, cron.ErrInvalid) {
+			t.Errorf("Parse(%q) = %v, want ErrInvalid", expr, err)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	utc := func(s string) time.Time {
+		t.Helper()
+		v, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	for _, tt := range []struct {
+		name string
+		expr string
+		from time.Time
+		want []string // RFC 3339 in from's location, or wall clock without offset
+	}{
+		{"every minute", "* * * * *", utc("2024-10-01T10:00:30Z"),
+			[]string{"2024-10-01T10:01:00Z", "2024-10-01T10:02:00Z"}},
+		{"strictly after", "0 10 * * *", utc("2024-10-01T10:00:00Z"),
+			[]string{"2024-10-02T10:00:00Z"}},
+		{"steps and lists", "0,30 */6 * * *", utc("2024-10-01T05:00:00Z"),
+			[]string{"2024-10-01T06:00:00Z", "2024-10-01T06:30:00Z", "2024-10-01T12:00:00Z"}},
+		{"value with step runs to the end", "50/5 0 * * *", utc("2024-10-01T00:00:00Z"),
+			[]string{"2024-10-01T00:50:00Z", "2024-10-01T00:55:00Z", "2024-10-02T00:50:00Z"}},
+		{"range with step", "10-50/20 0 * * *", utc("2024-10-01T00:00:00Z"),
+			[]string{"2024-10-01T00:10:00Z", "2024-10-01T00:30:00Z", "2024-10-01T00:50:00Z"}},
+		// 2024-10-13 is a Sunday: either day field matching fires
+		{"day of month or day of week", "0 0 13 * 5", utc("2024-10-01T00:00:00Z"),
+			[]string{"2024-10-04T00:00:00Z", "2024-10-11T00:00:00Z", "2024-10-13T00:00:00Z", "2024-10-18T00:00:00Z"}},
+		{"day of month only", "0 0 13 * *", utc("2024-10-01T00:00:00Z"),
+			[]string{"2024-10-13T00:00:00Z", "2024-11-13T00:00:00Z"}},
+		{"day of week only", "0 0 * * fri", utc("2024-10-01T00:00:00Z"),
+			[]string{"2024-10-04T00:00:00Z", "2024-10-11T00:00:00Z"}},
+		// A day field starting with "*" is unrestricted for the OR rule, so
+		// both must match: odd days that are Mondays
+		{"starred step and day of week", "0 0 */2 * 1", utc("2024-09-01T00:00:00Z"),
+			[]string{"2024-09-09T00:00:00Z", "2024-09-23T00:00:00Z"}},
+		{"Sunday as 7", "0 12 * * 7", utc("2024-09-02T00:00:00Z"),
+			[]string{"2024-09-08T12:00:00Z", "2024-09-15T12:00:00Z"}},
+		{"range ending on Sunday as 7", "0 12 * * 6-7", utc("2024-09-02T00:00:00Z"),
+			[]string{"2024-09-07T12:00:00Z", "2024-09-08T12:00:00Z", "2024-09-14T12:00:00Z"}},
+		{"month names", "0 0 1 jan,jul *", utc("2024-02-01T00:00:00Z"),
+			[]string{"2024-07-01T00:00:00Z", "2025-01-01T00:00:00Z"}},
+		{"leap day", "0 0 29 2 *", utc("2025-01-01T00:00:00Z"),
+			[]string{"2028-02-29T00:00:00Z"}},
+		{"never", "0 0 30 2 *", utc("2025-01-01T00:00:00Z"), nil},
+		{"weekly macro", "@weekly", utc("2024-09-02T00:00:00Z"),
+			[]string{"2024-09-08T00:00:00Z"}},
+		{"hourly macro", "@HOURLY", utc("2024-09-02T00:10:00Z"),
+			[]string{"2024-09-02T01:00:00Z"}},
+		// Clocks go from 02:00 to 03:00 on 2024-03-31: 02:30 does not exist
+		{"skipped by daylight saving", "30 2 * * *", time.Date(2024, 3, 30, 3, 0, 0, 0, berlin),
+			[]string{"2024-04-01T02:30:00+02:00"}},
+		{"hour after the gap", "30 3 * * *", time.Date(2024, 3, 31, 0, 0, 0, 0, berlin),
+			[]string{"2024-03-31T03:30:00+02:00"}},
+		// Clocks go from 03:00 back to 02:00 on 2024-10-27: 02:30 fires
+		// once, at either offset
+		{"repeated by daylight saving", "30 2 * * *", time.Date(2024, 10, 27, 0, 0, 0, 0, berlin),
+			[]string{"2024-10-27T02:30", "2024-10-28T02:30"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := cron.Parse(tt.expr)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.expr, err)
+			}
+			got := s.NextN(tt.from, len(tt.want)+1)
+			if len(tt.want) == 0 {
+				if len(got) != 0 {
+					t.Fatalf("NextN = %v, want none", got)
+				}
+				return
+			}
+			if len(got) < len(tt.want) {
+				t.Fatalf("NextN = %v, want %v", got, tt.want)
+			}
+			for i, w := range tt.want {
+				layout := time.RFC3339
+				if len(w) == len(wallClock) {
+					layout = wallClock
+				}
+				if s := got[i].Format(layout); s != w {
+					t.Errorf("run %d = %s, want %s", i, s, w)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// lockTTL is how long a lock outlives an instance that died holding it.
+	// The holder extends it every lockTTL/3.
+	lockTTL   = time.Minute
+	lockRetry = 2 * time.Second
+)
+
+// withLock runs fn while holding the lock name, shared by every API
+// instance on the database, so migrations run on one instance at a time.
+// It waits until the lock is free.
+func (m *MongoDB) withLock(ctx context.Context, name string, fn func() error) error {
+	locks := m.Database.Collection(CollectionLocks)
+	owner := primitive.NewObjectID()
+	for waiting := false; ; waiting = true {
+		// A held lock does not match and its upsert fails on the _id
+		now := time.Now()
+		_, err := locks.UpdateOne(ctx,
+			bson.M{"_id": name, "expires_at": bson.M{"$lt": now}},
+			bson.M{"$set": bson.M{"owner": owner, "expires_at": now.Add(lockTTL)}},
+			options.Update().SetUpsert(true))
+		if err == nil {
+			break
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return fmt.Errorf("lock %s: %w", name, err)
+		}
+		if !waiting {
+			log.Printf("MongoDB: waiting for another instance to release %s", name)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(lockRetry):
+		}
+	}
+
+	done := make(chan struct{})
+	defer func() {
+		close(done)
+		if _, err := locks.DeleteOne(context.Background(), bson.M{"_id": name, "owner": owner}); err != nil {
+			log.Printf("MongoDB: release lock %s: %v", name, err)
+		}
+	}()
+	go func() {
+		ticker := time.NewTicker(lockTTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				_, err := locks.UpdateOne(ctx, bson.M{"_id": name, "owner": owner},
+					bson.M{"$set": bson.M{"expires_at": time.Now().Add(lockTTL)}})
+				if err != nil {
+					log.Printf("MongoDB: extend lock %s: %v", name, err)
+				}
+			}
+		}
+	}()
+	return fn()
+}
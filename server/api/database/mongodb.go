@@ -17,11 +17,38 @@ import (
 
 // Collection names used by the app (must match repository.NewRepository)
 const (
-	CollectionMachines        = "machines"
-	CollectionUsers           = "users"
-	CollectionCommands        = "commands"
-	CollectionUptimeSnapshots = "uptime_snapshots"
-	CollectionMachineSnapshots = "machine_snapshots"
+	CollectionMachines           = "machines"
+	CollectionUsers              = "users"
+	CollectionCommands           = "commands"
+	CollectionUptimeSnapshots    = "uptime_snapshots"
+	CollectionMachineSnapshots   = "machine_snapshots"
+	CollectionSnapshots1m        = "machine_snapshots_1m"
+	CollectionSnapshots1h        = "machine_snapshots_1h"
+	CollectionMachineGroups      = "machine_groups"
+	CollectionOrganizations      = "organizations"
+	CollectionOrgMembers         = "org_members"
+	CollectionOrgInvites         = "org_invites"
+	CollectionAPITokens          = "api_tokens"
+	CollectionSessions           = "sessions"
+	CollectionAuditEvents        = "audit_events"
+	CollectionAgentCerts         = "agent_certificates"
+	CollectionEnrollmentTokens   = "enrollment_tokens"
+	CollectionAgentRollouts      = "agent_rollouts"
+	CollectionMachineInventories = "machine_inventories"
+	CollectionInventoryChanges   = "inventory_changes"
+	CollectionAdvisories         = "advisories"
+	CollectionVulnFindings       = "vulnerability_findings"
+	CollectionFileEvents         = "file_events"
+	CollectionMachineUnits       = "machine_units"
+	CollectionAlertRules         = "alert_rules"
+	CollectionAlerts             = "alerts"
+	CollectionFileTransfers      = "file_transfers"
+	CollectionFileChunks         = "file_chunks"
+	CollectionJobs               = "jobs"
+	CollectionSchedules          = "schedules"
+	CollectionScheduleRuns       = "schedule_runs"
+	CollectionMachineConfigs     = "machine_configs"
+	CollectionLocks              = "locks"
 )
 
 type MongoDB struct {
@@ -55,6 +82,12 @@ func NewMongoDB(cfg *config.Config) (*MongoDB, error) {
 		_ = client.Disconnect(ctx)
 		return nil, fmt.Errorf("failed to ensure collections: %w", err)
 	}
+	// Migrating the snapshots of an earlier version may take longer than
+	// connecting, or wait for another instance doing so
+	if err := m.EnsureSnapshotCollections(context.Background(), cfg.Metrics); err != nil {
+		_ = client.Disconnect(ctx)
+		return nil, fmt.Errorf("failed to ensure snapshot collections: %w", err)
+	}
 
 	return m, nil
 }
@@ -62,7 +95,7 @@ func NewMongoDB(cfg *config.Config) (*MongoDB, error) {
 // EnsureCollections creates the required collections if they don't exist,
 // so the "lute" database and collections appear as soon as the API starts.
 func (m *MongoDB) EnsureCollections(ctx context.Context) error {
-	for _, name := range []string{CollectionMachines, CollectionUsers, CollectionCommands, CollectionUptimeSnapshots, CollectionMachineSnapshots} {
+	for _, name := range []string{CollectionMachines, CollectionUsers, CollectionCommands, CollectionUptimeSnapshots, CollectionMachineGroups, CollectionOrganizations, CollectionOrgMembers, CollectionOrgInvites, CollectionAPITokens, CollectionSessions, CollectionAuditEvents, CollectionAgentCerts, CollectionEnrollmentTokens, CollectionAgentRollouts, CollectionMachineInventories, CollectionInventoryChanges, CollectionAdvisories, CollectionVulnFindings, CollectionFileEvents, CollectionMachineUnits, CollectionAlertRules, CollectionAlerts, CollectionFileTransfers, CollectionFileChunks, CollectionJobs, CollectionSchedules, CollectionScheduleRuns, CollectionMachineConfigs, CollectionLocks} {
 		if err := m.Database.CreateCollection(ctx, name); err != nil {
 			// Code 48 = namespace already exists
 			var ce mongo.CommandError
@@ -82,44 +115,69 @@ func (m *MongoDB) EnsureCollections(ctx context.Context) error {
 	})
 	if err != nil {
 		var ce mongo.CommandError
-		if errors.As(err, &ce) && (ce.HasErrorCode(85) || ce.HasErrorCode(86)) {
-			// Index with same key or name already exists
-			return nil
+		if !(errors.As(err, &ce) && (ce.HasErrorCode(85) || ce.HasErrorCode(86))) {
+			return fmt.Errorf("create uptime_snapshots TTL index: %w", err)
+		}
+		// Index with same key or name already exists; keep going so newer indexes are created
+	} else {
+		log.Printf("MongoDB: created TTL index on %s.at", CollectionUptimeSnapshots)
+	}
+	// TTL index on file_chunks.created_at: file contents are kept for 7 days
+	_, err = m.Database.Collection(CollectionFileChunks).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.M{"created_at": 1},
+		Options: options.Index().SetExpireAfterSeconds(7 * 24 * 3600),
+	})
+	if err != nil {
+		var ce mongo.CommandError
+		if !(errors.As(err, &ce) && (ce.HasErrorCode(85) || ce.HasErrorCode(86))) {
+			return fmt.Errorf("create file_chunks TTL index: %w", err)
 		}
-		return fmt.Errorf("create uptime_snapshots TTL index: %w", err)
 	}
-	log.Printf("MongoDB: created TTL index on %s.at", CollectionUptimeSnapshots)
-	// TTL index on machine_snapshots.at: expire after 30 days
-	machineSnapColl := m.Database.Collection(CollectionMachineSnapshots)
-	_, err = machineSnapColl.Indexes().CreateOne(ctx, mongo.IndexModel{
+	// TTL index on schedule_runs.at: schedule history is kept for 90 days
+	_, err = m.Database.Collection(CollectionScheduleRuns).Indexes().CreateOne(ctx, mongo.IndexModel{
 		Keys:    bson.M{"at": 1},
-		Options: options.Index().SetExpireAfterSeconds(ttlSeconds),
+		Options: options.Index().SetExpireAfterSeconds(90 * 24 * 3600),
 	})
 	if err != nil {
 		var ce mongo.CommandError
-		if errors.As(err, &ce) && (ce.HasErrorCode(85) || ce.HasErrorCode(86)) {
-			return nil
+		if !(errors.As(err, &ce) && (ce.HasErrorCode(85) || ce.HasErrorCode(86))) {
+			return fmt.Errorf("create schedule_runs TTL index: %w", err)
 		}
-		return fmt.Errorf("create machine_snapshots TTL index: %w", err)
 	}
-	log.Printf("MongoDB: created TTL index on %s.at", CollectionMachineSnapshots)
-	// Compound index for GetByMachineIDs (machine_id + at) to avoid whole collection scan
-	_, err = machineSnapColl.Indexes().CreateOne(ctx, mongo.IndexModel{
-		Keys: bson.D{{Key: "machine_id", Value: 1}, {Key: "at", Value: 1}},
+	// TTL index on sessions.expires_at: remove local login sessions once they expire
+	_, err = m.Database.Collection(CollectionSessions).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.M{"expires_at": 1},
+		Options: options.Index().SetExpireAfterSeconds(0),
 	})
 	if err != nil {
 		var ce mongo.CommandError
-		if errors.As(err, &ce) && (ce.HasErrorCode(85) || ce.HasErrorCode(86)) {
-			// index already exists
-		} else {
-			return fmt.Errorf("create machine_snapshots compound index: %w", err)
+		if !(errors.As(err, &ce) && (ce.HasErrorCode(85) || ce.HasErrorCode(86))) {
+			return fmt.Errorf("create sessions TTL index: %w", err)
 		}
 	}
-	// Indexes on machines for List/GetByUserID (user_id) and GetPublic (is_public)
+	// TTL index on enrollment_tokens.expires_at for claim codes only: drop them a
+	// day after they expire. Reusable tokens are kept for auditing.
+	_, err = m.Database.Collection(CollectionEnrollmentTokens).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.M{"expires_at": 1},
+		Options: options.Index().
+			SetExpireAfterSeconds(24 * 3600).
+			SetPartialFilterExpression(bson.M{"kind": "claim_code"}),
+	})
+	if err != nil {
+		var ce mongo.CommandError
+		if !(errors.As(err, &ce) && (ce.HasErrorCode(85) || ce.HasErrorCode(86))) {
+			return fmt.Errorf("create enrollment_tokens TTL index: %w", err)
+		}
+	}
+	// Indexes on machines for List/GetByUserID (user_id), GetPublic (is_public),
+	// label selectors (wildcard on labels) and group membership (group_ids)
 	machinesColl := m.Database.Collection(CollectionMachines)
 	for _, idx := range []mongo.IndexModel{
 		{Keys: bson.D{{Key: "user_id", Value: 1}}},
 		{Keys: bson.D{{Key: "is_public", Value: 1}}},
+		{Keys: bson.D{{Key: "labels.$**", Value: 1}}},
+		{Keys: bson.D{{Key: "group_ids", Value: 1}}},
+		{Keys: bson.D{{Key: "org_id", Value: 1}}},
 	} {
 		_, err = machinesColl.Indexes().CreateOne(ctx, idx)
 		if err != nil {
@@ -130,6 +188,151 @@ func (m *MongoDB) EnsureCollections(ctx context.Context) error {
 			return fmt.Errorf("create machines index: %w", err)
 		}
 	}
+	// Unique indexes: group names per user, one membership per org/user, invite, API token, session and enrollment token hashes, one inventory and unit list per machine, one chunk per file offset, one run per schedule and due time
+	for _, ui := range []struct {
+		coll string
+		keys bson.D
+	}{
+		{CollectionMachineGroups, bson.D{{Key: "user_id", Value: 1}, {Key: "name", Value: 1}}},
+		{CollectionOrgMembers, bson.D{{Key: "org_id", Value: 1}, {Key: "user_id", Value: 1}}},
+		{CollectionOrgInvites, bson.D{{Key: "token_hash", Value: 1}}},
+		{CollectionAPITokens, bson.D{{Key: "token_hash", Value: 1}}},
+		{CollectionSessions, bson.D{{Key: "token_hash", Value: 1}}},
+		{CollectionAgentCerts, bson.D{{Key: "serial", Value: 1}}},
+		{CollectionEnrollmentTokens, bson.D{{Key: "token_hash", Value: 1}}},
+		{CollectionMachineInventories, bson.D{{Key: "machine_id", Value: 1}}},
+		{CollectionMachineUnits, bson.D{{Key: "machine_id", Value: 1}}},
+		{CollectionFileChunks, bson.D{{Key: "transfer_id", Value: 1}, {Key: "offset", Value: 1}}},
+		{CollectionScheduleRuns, bson.D{{Key: "schedule_id", Value: 1}, {Key: "scheduled_for", Value: -1}}},
+	} {
+		_, err = m.Database.Collection(ui.coll).Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys:    ui.keys,
+			Options: options.Index().SetUnique(true),
+		})
+		if err != nil {
+			var ce mongo.CommandError
+			if errors.As(err, &ce) && (ce.HasErrorCode(85) || ce.HasErrorCode(86)) {
+				continue
+			}
+			return fmt.Errorf("create %s index: %w", ui.coll, err)
+		}
+	}
+	// Group names are unique per organization as well as per creator
+	_, err = m.Database.Collection(CollectionMachineGroups).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "org_id", Value: 1}, {Key: "name", Value: 1}},
+		Options: options.Index().
+			SetUnique(true).
+			SetPartialFilterExpression(bson.M{"org_id": bson.M{"$exists": true}}),
+	})
+	if err != nil {
+		var ce mongo.CommandError
+		if !(errors.As(err, &ce) && (ce.HasErrorCode(85) || ce.HasErrorCode(86))) {
+			return fmt.Errorf("create machine_groups index: %w", err)
+		}
+	}
+	// One config per machine and one per group; a config has one of the two
+	for _, key := range []string{"machine_id", "group_id"} {
+		_, err = m.Database.Collection(CollectionMachineConfigs).Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys: bson.D{{Key: key, Value: 1}},
+			Options: options.Index().
+				SetUnique(true).
+				SetPartialFilterExpression(bson.M{key: bson.M{"$exists": true}}),
+		})
+		if err != nil {
+			var ce mongo.CommandError
+			if errors.As(err, &ce) && (ce.HasErrorCode(85) || ce.HasErrorCode(86)) {
+				continue
+			}
+			return fmt.Errorf("create machine_configs index: %w", err)
+		}
+	}
+	// Audit log queries: newest first, by actor, by target and by org/owner visibility
+	auditColl := m.Database.Collection(CollectionAuditEvents)
+	for _, idx := range []mongo.IndexModel{
+		{Keys: bson.D{{Key: "at", Value: -1}}},
+		{Keys: bson.D{{Key: "actor_id", Value: 1}, {Key: "at", Value: -1}}},
+		{Keys: bson.D{{Key: "target_id", Value: 1}, {Key: "at", Value: -1}}},
+		{Keys: bson.D{{Key: "org_id", Value: 1}, {Key: "at", Value: -1}}},
+		{Keys: bson.D{{Key: "owner_id", Value: 1}, {Key: "at", Value: -1}}},
+	} {
+		_, err = auditColl.Indexes().CreateOne(ctx, idx)
+		if err != nil {
+			var ce mongo.CommandError
+			if errors.As(err, &ce) && (ce.HasErrorCode(85) || ce.HasErrorCode(86)) {
+				continue
+			}
+			return fmt.Errorf("create audit_events index: %w", err)
+		}
+	}
+	// Fleet-wide package queries and per-machine inventory history
+	for _, idx := range []struct {
+		coll string
+		keys bson.D
+	}{
+		{CollectionMachineInventories, bson.D{{Key: "packages.name", Value: 1}}},
+		{CollectionInventoryChanges, bson.D{{Key: "machine_id", Value: 1}, {Key: "at", Value: -1}}},
+		// Advisory lookups by distribution and package, stale advisories after an import
+		{CollectionAdvisories, bson.D{{Key: "affected.distro", Value: 1}, {Key: "affected.package", Value: 1}}},
+		{CollectionAdvisories, bson.D{{Key: "imported_at", Value: 1}}},
+		// Findings per machine and per CVE
+		{CollectionVulnFindings, bson.D{{Key: "machine_id", Value: 1}}},
+		{CollectionVulnFindings, bson.D{{Key: "cves", Value: 1}}},
+		// File events per machine, newest first; redelivered batches by sequence number
+		{CollectionFileEvents, bson.D{{Key: "machine_id", Value: 1}, {Key: "detected_at", Value: -1}}},
+		{CollectionFileEvents, bson.D{{Key: "machine_id", Value: 1}, {Key: "seq", Value: 1}}},
+		// Fleet-wide failed units; alert rules by owner; alerts per machine and state
+		{CollectionMachineUnits, bson.D{{Key: "units.active_state", Value: 1}}},
+		{CollectionAlertRules, bson.D{{Key: "org_id", Value: 1}}},
+		{CollectionAlertRules, bson.D{{Key: "user_id", Value: 1}}},
+		{CollectionAlerts, bson.D{{Key: "machine_id", Value: 1}, {Key: "status", Value: 1}}},
+		{CollectionAlerts, bson.D{{Key: "rule_id", Value: 1}, {Key: "status", Value: 1}}},
+		// Commands per machine, newest first; actions awaiting delivery or a result
+		{CollectionCommands, bson.D{{Key: "machine_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{CollectionCommands, bson.D{{Key: "machine_id", Value: 1}, {Key: "status", Value: 1}}},
+		// File transfers per machine, newest first; open transfers of a machine that connects
+		{CollectionFileTransfers, bson.D{{Key: "machine_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{CollectionFileTransfers, bson.D{{Key: "machine_id", Value: 1}, {Key: "status", Value: 1}}},
+		// Jobs by owner, newest first; running jobs advanced by the job runner
+		{CollectionJobs, bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{CollectionJobs, bson.D{{Key: "org_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{CollectionJobs, bson.D{{Key: "status", Value: 1}}},
+		// Schedules by owner, newest first; enabled schedules by when they fire next
+		{CollectionSchedules, bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{CollectionSchedules, bson.D{{Key: "org_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{CollectionSchedules, bson.D{{Key: "enabled", Value: 1}, {Key: "next_run_at", Value: 1}}},
+	} {
+		_, err = m.Database.Collection(idx.coll).Indexes().CreateOne(ctx, mongo.IndexModel{Keys: idx.keys})
+		if err != nil {
+			var ce mongo.CommandError
+			if !(errors.As(err, &ce) && (ce.HasErrorCode(85) || ce.HasErrorCode(86))) {
+				return fmt.Errorf("create %s index: %w", idx.coll, err)
+			}
+		}
+	}
+	// Lookups by owner: memberships and API tokens of a user, certificates of a machine, enrollment tokens and agent rollouts of an org
+	for _, li := range []struct {
+		coll string
+		key  string
+	}{
+		{CollectionOrgMembers, "user_id"},
+		{CollectionAPITokens, "user_id"},
+		{CollectionAgentCerts, "machine_id"},
+		{CollectionEnrollmentTokens, "user_id"},
+		{CollectionEnrollmentTokens, "org_id"},
+		{CollectionAgentRollouts, "user_id"},
+		{CollectionAgentRollouts, "org_id"},
+	} {
+		coll := li.coll
+		_, err = m.Database.Collection(coll).Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys: bson.D{{Key: li.key, Value: 1}},
+		})
+		if err != nil {
+			var ce mongo.CommandError
+			if !(errors.As(err, &ce) && (ce.HasErrorCode(85) || ce.HasErrorCode(86))) {
+				return fmt.Errorf("create %s index: %w", coll, err)
+			}
+		}
+	}
 	return nil
 }
 
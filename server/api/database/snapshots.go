@@ -0,0 +1,281 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/lute/api/config"
+)
+
+const (
+	// legacySnapshotsCollection holds a machine_snapshots collection of an
+	// earlier version, a plain collection, while it is copied into the
+	// time-series one.
+	legacySnapshotsCollection = "machine_snapshots_legacy"
+	legacyCopyBatch           = 1000
+	// snapshotsMigrationLock is held while machine_snapshots is migrated.
+	snapshotsMigrationLock = "machine_snapshots_migration"
+)
+
+// EnsureSnapshotCollections creates machine_snapshots as a time-series
+// collection with the machine ID as metadata, whose snapshots expire after
+// cfg.RawRetention, and the collections of their per-minute and per-hour
+// rollups. A plain machine_snapshots collection of an earlier version is
+// replaced: the snapshots still within the retention are copied over, by
+// one API instance while the others wait. Changed retentions apply to
+// existing collections as well.
+func (m *MongoDB) EnsureSnapshotCollections(ctx context.Context, cfg config.MetricsConfig) error {
+	migrate, err := m.snapshotsNeedMigration(ctx)
+	if err != nil {
+		return err
+	}
+	if migrate {
+		err := m.withLock(ctx, snapshotsMigrationLock, func() error {
+			return m.migrateSnapshots(ctx, cfg)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	kind, err := m.collectionType(ctx, CollectionMachineSnapshots)
+	if err != nil {
+		return err
+	}
+	if kind == "" {
+		if err := m.createSnapshotsCollection(ctx, cfg.RawRetention); err != nil {
+			return err
+		}
+	} else {
+		err = m.Database.RunCommand(ctx, bson.D{
+			{Key: "collMod", Value: CollectionMachineSnapshots},
+			{Key: "expireAfterSeconds", Value: int64(cfg.RawRetention.Seconds())},
+		}).Err()
+		if err != nil {
+			return fmt.Errorf("set %s retention: %w", CollectionMachineSnapshots, err)
+		}
+	}
+	// Chart queries and rollups select snapshots by machine and time
+	_, err = m.Database.Collection(CollectionMachineSnapshots).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "machine_id", Value: 1}, {Key: "at", Value: 1}},
+	})
+	if err != nil {
+		var ce mongo.CommandError
+		if !(errors.As(err, &ce) && (ce.HasErrorCode(85) || ce.HasErrorCode(86))) {
+			return fmt.Errorf("create %s index: %w", CollectionMachineSnapshots, err)
+		}
+	}
+	// One rollup per machine and period, expiring after its retention
+	for _, r := range []struct {
+		coll      string
+		retention time.Duration
+	}{
+		{CollectionSnapshots1m, cfg.MinuteRetention},
+		{CollectionSnapshots1h, cfg.HourRetention},
+	} {
+		if err := m.Database.CreateCollection(ctx, r.coll); err != nil {
+			var ce mongo.CommandError
+			if !(errors.As(err, &ce) && ce.HasErrorCode(48)) {
+				return fmt.Errorf("create %s: %w", r.coll, err)
+			}
+		}
+		_, err = m.Database.Collection(r.coll).Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys:    bson.D{{Key: "machine_id", Value: 1}, {Key: "at", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		})
+		if err != nil {
+			var ce mongo.CommandError
+			if !(errors.As(err, &ce) && (ce.HasErrorCode(85) || ce.HasErrorCode(86))) {
+				return fmt.Errorf("create %s index: %w", r.coll, err)
+			}
+		}
+		if err := m.ensureTTL(ctx, r.coll, "at", r.retention); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// snapshotsNeedMigration reports whether machine_snapshots is a plain
+// collection, or the copy of one was interrupted.
+func (m *MongoDB) snapshotsNeedMigration(ctx context.Context) (bool, error) {
+	kind, err := m.collectionType(ctx, CollectionMachineSnapshots)
+	if err != nil {
+		return false, err
+	}
+	legacy, err := m.collectionType(ctx, legacySnapshotsCollection)
+	if err != nil {
+		return false, err
+	}
+	return (kind != "" && kind != "timeseries") || legacy != "", nil
+}
+
+// migrateSnapshots renames a plain machine_snapshots collection to the
+// legacy one and copies its snapshots into a new time-series collection.
+// Call it holding snapshotsMigrationLock; it checks again what is left to
+// do, since another instance may have finished the migration meanwhile.
+func (m *MongoDB) migrateSnapshots(ctx context.Context, cfg config.MetricsConfig) error {
+	kind, err := m.collectionType(ctx, CollectionMachineSnapshots)
+	if err != nil {
+		return err
+	}
+	if kind != "" && kind != "timeseries" {
+		if err := m.renameCollection(ctx, CollectionMachineSnapshots, legacySnapshotsCollection); err != nil {
+			return fmt.Errorf("rename %s: %w", CollectionMachineSnapshots, err)
+		}
+		log.Printf("MongoDB: renamed plain %s to %s", CollectionMachineSnapshots, legacySnapshotsCollection)
+		kind = ""
+	}
+	legacy, err := m.collectionType(ctx, legacySnapshotsCollection)
+	if err != nil {
+		return err
+	}
+	if legacy == "" {
+		return nil
+	}
+	if kind == "" {
+		if err := m.createSnapshotsCollection(ctx, cfg.RawRetention); err != nil {
+			return err
+		}
+	}
+	if err := m.copyLegacySnapshots(ctx, cfg.RawRetention); err != nil {
+		return fmt.Errorf("copy %s: %w", legacySnapshotsCollection, err)
+	}
+	return nil
+}
+
+// createSnapshotsCollection creates machine_snapshots as a time-series
+// collection whose snapshots expire after retention.
+func (m *MongoDB) createSnapshotsCollection(ctx context.Context, retention time.Duration) error {
+	err := m.Database.CreateCollection(ctx, CollectionMachineSnapshots, options.CreateCollection().
+		SetTimeSeriesOptions(options.TimeSeries().
+			SetTimeField("at").
+			SetMetaField("machine_id").
+			SetGranularity("seconds")).
+		SetExpireAfterSeconds(int64(retention.Seconds())))
+	var ce mongo.CommandError
+	if err != nil && !(errors.As(err, &ce) && ce.HasErrorCode(48)) {
+		return fmt.Errorf("create %s: %w", CollectionMachineSnapshots, err)
+	}
+	if err == nil {
+		log.Printf("MongoDB: created time-series collection %s", CollectionMachineSnapshots)
+	}
+	return nil
+}
+
+// collectionType returns the type of a collection ("collection",
+// "timeseries", ...), or "" when it does not exist.
+func (m *MongoDB) collectionType(ctx context.Context, name string) (string, error) {
+	specs, err := m.Database.ListCollectionSpecifications(ctx, bson.M{"name": name})
+	if err != nil {
+		return "", fmt.Errorf("list collection %s: %w", name, err)
+	}
+	if len(specs) == 0 {
+		return "", nil
+	}
+	return specs[0].Type, nil
+}
+
+func (m *MongoDB) renameCollection(ctx context.Context, from, to string) error {
+	db := m.Database.Name()
+	return m.Client.Database("admin").RunCommand(ctx, bson.D{
+		{Key: "renameCollection", Value: db + "." + from},
+		{Key: "to", Value: db + "." + to},
+	}).Err()
+}
+
+// copyLegacySnapshots copies the snapshots of the last retention from the
+// legacy collection into machine_snapshots, oldest first, and drops the
+// legacy collection. An interrupted copy resumes at the newest snapshot
+// time copied, which may have been copied partially.
+func (m *MongoDB) copyLegacySnapshots(ctx context.Context, retention time.Duration) error {
+	src := m.Database.Collection(legacySnapshotsCollection)
+	dst := m.Database.Collection(CollectionMachineSnapshots)
+
+	since := time.Now().Add(-retention)
+	var last struct {
+		At time.Time `bson:"at"`
+	}
+	err := dst.FindOne(ctx, bson.M{}, options.FindOne().
+		SetSort(bson.M{"at": -1}).
+		SetProjection(bson.M{"at": 1})).Decode(&last)
+	switch {
+	case err == nil && last.At.After(since):
+		if _, err := dst.DeleteMany(ctx, bson.M{"at": bson.M{"$gte": last.At}}); err != nil {
+			return err
+		}
+		since = last.At
+	case err != nil && err != mongo.ErrNoDocuments:
+		return err
+	}
+
+	cursor, err := src.Find(ctx, bson.M{"at": bson.M{"$gte": since}}, options.Find().SetSort(bson.M{"at": 1}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	copied := 0
+	batch := make([]interface{}, 0, legacyCopyBatch)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if _, err := dst.InsertMany(ctx, batch, options.InsertMany().SetOrdered(false)); err != nil {
+			return err
+		}
+		copied += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+	for cursor.Next(ctx) {
+		batch = append(batch, append(bson.Raw(nil), cursor.Current...))
+		if len(batch) == legacyCopyBatch {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	log.Printf("MongoDB: copied %d snapshots from %s to %s", copied, legacySnapshotsCollection, CollectionMachineSnapshots)
+	return src.Drop(ctx)
+}
+
+// ensureTTL creates a TTL index on key of coll, or changes the expiry of an
+// existing one.
+func (m *MongoDB) ensureTTL(ctx context.Context, coll, key string, ttl time.Duration) error {
+	seconds := int32(ttl.Seconds())
+	_, err := m.Database.Collection(coll).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: key, Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(seconds),
+	})
+	if err == nil {
+		return nil
+	}
+	var ce mongo.CommandError
+	if !(errors.As(err, &ce) && ce.HasErrorCode(85)) {
+		return fmt.Errorf("create %s TTL index: %w", coll, err)
+	}
+	// An index on key with another expiry exists
+	err = m.Database.RunCommand(ctx, bson.D{
+		{Key: "collMod", Value: coll},
+		{Key: "index", Value: bson.D{
+			{Key: "keyPattern", Value: bson.D{{Key: key, Value: 1}}},
+			{Key: "expireAfterSeconds", Value: seconds},
+		}},
+	}).Err()
+	if err != nil {
+		return fmt.Errorf("set %s TTL: %w", coll, err)
+	}
+	return nil
+}
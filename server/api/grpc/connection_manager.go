@@ -2,6 +2,7 @@ package grpc
 
 import (
 	"errors"
+	"slices"
 	"sync"
 	"time"
 
@@ -11,6 +12,7 @@ import (
 var (
 	ErrNoConnection = errors.New("no active connection for machine")
 	ErrPingTimeout  = errors.New("heartbeat ping timed out")
+	ErrSendTimeout  = errors.New("send to agent timed out")
 )
 
 // pingRequest is sent from the HeartbeatChecker to the stream handler goroutine.
@@ -26,22 +28,40 @@ type pingResult struct {
 }
 
 // MachineConnection wraps a single bidirectional stream for one machine.
-// All stream I/O happens inside the Run loop (single goroutine); the
-// HeartbeatChecker communicates via the pingCh channel.
+// All stream sends happen inside the Run loop and all receives in its reader
+// goroutine; the HeartbeatChecker communicates via the pingCh channel, other
+// senders of one-way messages via sendCh.
 type MachineConnection struct {
 	MachineID string
+	Features  []pb.Feature // negotiated when the stream was opened
 	stream    pb.AgentService_ConnectServer
 	pingCh    chan pingRequest
+	sendCh    chan *pb.ServerMessage
+	closeCh   chan struct{}
+	closeOnce sync.Once
 }
 
-func newMachineConnection(machineID string, stream pb.AgentService_ConnectServer) *MachineConnection {
+func newMachineConnection(machineID string, features []pb.Feature, stream pb.AgentService_ConnectServer) *MachineConnection {
 	return &MachineConnection{
 		MachineID: machineID,
+		Features:  features,
 		stream:    stream,
 		pingCh:    make(chan pingRequest, 1),
+		sendCh:    make(chan *pb.ServerMessage, 4),
+		closeCh:   make(chan struct{}),
 	}
 }
 
+// Supports reports whether the feature was negotiated for this stream.
+func (mc *MachineConnection) Supports(f pb.Feature) bool {
+	return slices.Contains(mc.Features, f)
+}
+
+// Close makes Run return, which ends the agent's stream.
+func (mc *MachineConnection) Close() {
+	mc.closeOnce.Do(func() { close(mc.closeCh) })
+}
+
 // Ping sends a HeartbeatPing over the stream and waits for the pong.
 // Called by HeartbeatChecker from a different goroutine.
 func (mc *MachineConnection) Ping(timeout time.Duration) (*pb.HeartbeatPong, error) {
@@ -59,14 +79,72 @@ func (mc *MachineConnection) Ping(timeout time.Duration) (*pb.HeartbeatPong, err
 	}
 }
 
-// Run processes ping requests and dispatches them over the stream.
-// It blocks until the stream closes or the context is cancelled.
-// Must be called from the gRPC Connect handler goroutine.
-func (mc *MachineConnection) Run() {
+// Send queues a message the agent does not answer (e.g. an AgentUpdate).
+// Messages queued before Run starts are sent once it does.
+func (mc *MachineConnection) Send(msg *pb.ServerMessage, timeout time.Duration) error {
+	select {
+	case mc.sendCh <- msg:
+		return nil
+	case <-mc.closeCh:
+		return ErrNoConnection
+	case <-time.After(timeout):
+		return ErrSendTimeout
+	}
+}
+
+// MessageHandler handles an agent message that is not a reply to a server
+// request (e.g. a telemetry backfill). A non-nil result is sent back to the
+// agent.
+type MessageHandler func(msg *pb.AgentMessage) *pb.ServerMessage
+
+// Run processes ping requests and dispatches them over the stream. Pongs are
+// matched to pings in order; any other message from the agent is passed to
+// handle (which may be nil). It blocks until the stream closes or the context
+// is cancelled. Must be called from the gRPC Connect handler goroutine.
+func (mc *MachineConnection) Run(handle MessageHandler) {
+	recvCh := make(chan *pb.AgentMessage)
+	errCh := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			msg, err := mc.stream.Recv()
+			if err != nil {
+				errCh <- err
+				return
+			}
+			select {
+			case recvCh <- msg:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	// Pings awaiting their pong, oldest first
+	var pending []chan<- pingResult
+	defer func() {
+		for _, resultCh := range pending {
+			resultCh <- pingResult{Err: ErrNoConnection}
+		}
+	}()
+
 	for {
 		select {
 		case <-mc.stream.Context().Done():
 			return
+		case <-mc.closeCh:
+			return
+		case err := <-errCh:
+			for _, resultCh := range pending {
+				resultCh <- pingResult{Err: err}
+			}
+			pending = nil
+			return
+		case msg := <-mc.sendCh:
+			if err := mc.stream.Send(msg); err != nil {
+				return
+			}
 		case req := <-mc.pingCh:
 			err := mc.stream.Send(&pb.ServerMessage{
 				Payload: &pb.ServerMessage_HeartbeatPing{
@@ -79,13 +157,23 @@ func (mc *MachineConnection) Run() {
 				req.resultCh <- pingResult{Err: err}
 				return
 			}
-
-			msg, err := mc.stream.Recv()
-			if err != nil {
-				req.resultCh <- pingResult{Err: err}
-				return
+			pending = append(pending, req.resultCh)
+		case msg := <-recvCh:
+			if pong := msg.GetHeartbeatPong(); pong != nil {
+				if len(pending) > 0 {
+					pending[0] <- pingResult{Pong: pong}
+					pending = pending[1:]
+				}
+				continue
+			}
+			if handle == nil {
+				continue
+			}
+			if reply := handle(msg); reply != nil {
+				if err := mc.stream.Send(reply); err != nil {
+					return
+				}
 			}
-			req.resultCh <- pingResult{Pong: msg.GetHeartbeatPong()}
 		}
 	}
 }
@@ -103,8 +191,8 @@ func NewConnectionManager() *ConnectionManager {
 }
 
 // Register adds (or replaces) a connection for the given machine.
-func (cm *ConnectionManager) Register(machineID string, stream pb.AgentService_ConnectServer) *MachineConnection {
-	mc := newMachineConnection(machineID, stream)
+func (cm *ConnectionManager) Register(machineID string, features []pb.Feature, stream pb.AgentService_ConnectServer) *MachineConnection {
+	mc := newMachineConnection(machineID, features, stream)
 	cm.mu.Lock()
 	cm.conns[machineID] = mc
 	cm.mu.Unlock()
@@ -118,6 +206,14 @@ func (cm *ConnectionManager) Unregister(machineID string) {
 	cm.mu.Unlock()
 }
 
+// Disconnect closes the active stream of a machine, if any (e.g. after its
+// certificates were revoked).
+func (cm *ConnectionManager) Disconnect(machineID string) {
+	if mc := cm.Get(machineID); mc != nil {
+		mc.Close()
+	}
+}
+
 // Get returns the active connection for a machine, or nil.
 func (cm *ConnectionManager) Get(machineID string) *MachineConnection {
 	cm.mu.RLock()
@@ -0,0 +1,54 @@
+package grpc
+
+import (
+	"slices"
+	"strings"
+
+	pb "github.com/lute/agent/proto/agent"
+)
+
+// ProtocolVersion is the stream protocol this server speaks.
+const ProtocolVersion = 1
+
+// serverFeatures lists the optional protocol features this server implements.
+var serverFeatures = []pb.Feature{
+	pb.Feature_FEATURE_CERTIFICATE_RENEWAL,
+	pb.Feature_FEATURE_TOKEN_ROTATION,
+	pb.Feature_FEATURE_SELF_UPDATE,
+	pb.Feature_FEATURE_BACKFILL,
+	pb.Feature_FEATURE_INVENTORY,
+	pb.Feature_FEATURE_FILE_INTEGRITY,
+	pb.Feature_FEATURE_UNITS,
+	pb.Feature_FEATURE_ACTIONS,
+	pb.Feature_FEATURE_FILE_TRANSFER,
+	pb.Feature_FEATURE_CONFIG,
+}
+
+// legacyFeatures is what an agent that predates Hello implements.
+var legacyFeatures = []pb.Feature{
+	pb.Feature_FEATURE_CERTIFICATE_RENEWAL,
+	pb.Feature_FEATURE_TOKEN_ROTATION,
+	pb.Feature_FEATURE_SELF_UPDATE,
+	pb.Feature_FEATURE_BACKFILL,
+}
+
+// negotiateFeatures returns the features both sides implement, in the
+// server's order. A nil hello stands for a legacy agent.
+func negotiateFeatures(hello *pb.Hello) []pb.Feature {
+	offered := legacyFeatures
+	if hello != nil {
+		offered = hello.GetFeatures()
+	}
+	var out []pb.Feature
+	for _, f := range serverFeatures {
+		if slices.Contains(offered, f) {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// FeatureName is the name stored for f, e.g. "self_update".
+func FeatureName(f pb.Feature) string {
+	return strings.ToLower(strings.TrimPrefix(f.String(), "FEATURE_"))
+}
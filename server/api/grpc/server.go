@@ -1,16 +1,27 @@
 package grpc
 
 import (
+	"context"
+	"crypto/x509"
 	"fmt"
 	"log"
 	"net"
+	"slices"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
 
 	pb "github.com/lute/agent/proto/agent"
+	"github.com/lute/api/agentauth"
 	"github.com/lute/api/config"
+	"github.com/lute/api/models"
+	"github.com/lute/api/pki"
 	"github.com/lute/api/repository"
 )
 
@@ -22,18 +33,27 @@ type Server struct {
 	pb.UnimplementedAgentServiceServer
 	config                 *config.Config
 	machineRepo            *repository.MachineRepository
+	authority              *pki.Authority
 	ConnMgr                *ConnectionManager
 	grpcServer             *grpc.Server
-	OnConnectionRegistered func() // called when a new agent stream is registered (e.g. to trigger heartbeat check)
+	OnConnectionRegistered func(machineID string) // called when a new agent stream is registered (e.g. to trigger heartbeat check)
+	// OnAgentMessage handles agent messages that are not replies (e.g. a
+	// telemetry backfill); a non-nil result is sent back to the agent.
+	OnAgentMessage func(machineID string, msg *pb.AgentMessage) *pb.ServerMessage
+	// InventoryHash returns the hash of the machine's stored inventory, sent
+	// in the Welcome so the agent only reports a changed one.
+	InventoryHash func(ctx context.Context, machineID string) string
 }
 
 func NewServer(
 	cfg *config.Config,
 	machineRepo *repository.MachineRepository,
+	authority *pki.Authority,
 ) *Server {
 	return &Server{
 		config:      cfg,
 		machineRepo: machineRepo,
+		authority:   authority,
 		ConnMgr:     NewConnectionManager(),
 	}
 }
@@ -45,11 +65,15 @@ func (s *Server) Start() error {
 		return fmt.Errorf("failed to listen on %s: %w", addr, err)
 	}
 
-	s.grpcServer = grpc.NewServer()
+	tlsConfig, err := s.authority.ServerTLSConfig()
+	if err != nil {
+		return fmt.Errorf("failed to issue gRPC server certificate: %w", err)
+	}
+	s.grpcServer = grpc.NewServer(grpc.Creds(credentials.NewTLS(tlsConfig)))
 	pb.RegisterAgentServiceServer(s.grpcServer, s)
 	reflection.Register(s.grpcServer)
 
-	log.Printf("gRPC server listening on %s", addr)
+	log.Printf("gRPC server listening on %s (mTLS, server name %s)", addr, s.authority.ServerName())
 
 	if err := s.grpcServer.Serve(lis); err != nil {
 		return fmt.Errorf("failed to serve gRPC: %w", err)
@@ -64,28 +88,37 @@ func (s *Server) Stop() {
 }
 
 // Connect handles the bidirectional stream opened by an agent.
-// The first message must carry the machine_id. After registration in the
-// ConnectionManager, Run() takes over: it waits for ping requests from the
-// HeartbeatChecker, writes them to the stream, reads pongs back, and
-// forwards the results.
+// The machine is identified by the client certificate presented during the
+// TLS handshake; a machine_id in the first message must match it, and the
+// agent token in the metadata must belong to the machine. The agent's Hello
+// is answered with a Welcome naming the negotiated features and refreshes
+// what is stored about the agent and its host. If the certificate
+// is close to expiry or the token is due for rotation, both are replaced
+// before the stream is handed to the ConnectionManager. Run() then takes over: it waits for ping
+// requests from the HeartbeatChecker, writes them to the stream, reads pongs
+// back, and forwards the results.
 func (s *Server) Connect(stream pb.AgentService_ConnectServer) error {
-	// Read the first message to identify the machine.
-	first, err := stream.Recv()
+	cert, err := clientCertificate(stream)
 	if err != nil {
-		return fmt.Errorf("connect: failed to receive initial message: %w", err)
+		return err
 	}
-
-	machineID := first.GetMachineId()
-	if machineID == "" {
-		return fmt.Errorf("connect: machine_id is required in the first message")
+	mid, err := s.authority.Verify(stream.Context(), cert)
+	if err != nil {
+		return status.Errorf(codes.Unauthenticated, "connect: %v", err)
 	}
+	machineID := mid.Hex()
 
-	// Validate the machine exists and is not dead.
-	mid, err := ParseMachineID(machineID)
+	// Read the first message; it confirms the agent's view of its identity and
+	// carries the agent's Hello (absent from agents that predate it).
+	first, err := stream.Recv()
 	if err != nil {
-		return fmt.Errorf("connect: %w", err)
+		return fmt.Errorf("connect: failed to receive initial message: %w", err)
+	}
+	if claimed := first.GetMachineId(); claimed != "" && claimed != machineID {
+		return status.Errorf(codes.PermissionDenied, "connect: certificate was issued to machine %s, not %s", machineID, claimed)
 	}
 
+	// Validate the machine exists and is not dead.
 	machine, err := s.machineRepo.GetByID(stream.Context(), mid)
 	if err != nil {
 		return fmt.Errorf("connect: machine %s not found: %w", machineID, err)
@@ -94,6 +127,49 @@ func (s *Server) Connect(stream pb.AgentService_ConnectServer) error {
 		return fmt.Errorf("connect: machine %s is dead; set status to pending to re-enable", machineID)
 	}
 
+	match := agentauth.Verify(machine, agentauth.FromContext(stream.Context()))
+	switch match {
+	case agentauth.NoMatch:
+		return status.Errorf(codes.Unauthenticated, "connect: invalid or revoked agent token for machine %s", machineID)
+	case agentauth.MatchNext:
+		// The agent has switched to the rotated token; retire the old one.
+		if err := s.machineRepo.PromoteAgentToken(stream.Context(), mid, machine.AgentTokenNextHash); err != nil {
+			log.Printf("Connect: failed to promote agent token of machine %s: %v", machineID, err)
+		}
+	}
+
+	hello := first.GetHello()
+	features := negotiateFeatures(hello)
+	if hello != nil {
+		welcome := &pb.Welcome{
+			ProtocolVersion: ProtocolVersion,
+			Features:        features,
+		}
+		if slices.Contains(features, pb.Feature_FEATURE_INVENTORY) && s.InventoryHash != nil {
+			welcome.InventoryHash = s.InventoryHash(stream.Context(), machineID)
+		}
+		err := stream.Send(&pb.ServerMessage{
+			Payload: &pb.ServerMessage_Welcome{Welcome: welcome},
+		})
+		if err != nil {
+			return fmt.Errorf("connect: failed to send welcome: %w", err)
+		}
+	}
+	s.updateAgentInfo(stream, machine, hello, features)
+
+	if slices.Contains(features, pb.Feature_FEATURE_CERTIFICATE_RENEWAL) && pki.RenewalDue(cert, time.Now()) {
+		if err := s.renewCertificate(stream, mid, cert); err != nil {
+			// The current certificate is still valid; try again on the next connect.
+			log.Printf("Connect: certificate renewal for machine %s failed: %v", machineID, err)
+		}
+	}
+
+	if slices.Contains(features, pb.Feature_FEATURE_TOKEN_ROTATION) && match == agentauth.MatchCurrent && agentauth.RotationDue(machine, s.config.GRPC.AgentTokenTTL, time.Now()) {
+		if err := s.rotateAgentToken(stream, mid); err != nil {
+			log.Printf("Connect: agent token rotation for machine %s failed: %v", machineID, err)
+		}
+	}
+
 	// So the heartbeat checker picks up this machine, ensure it's monitored.
 	if machine.Status == "pending" {
 		if err := s.machineRepo.UpdateStatus(stream.Context(), mid, "registered"); err != nil {
@@ -105,16 +181,139 @@ func (s *Server) Connect(stream pb.AgentService_ConnectServer) error {
 
 	log.Printf("Connect: machine %s connected", machineID)
 
-	conn := s.ConnMgr.Register(machineID, stream)
+	conn := s.ConnMgr.Register(machineID, features, stream)
 	if s.OnConnectionRegistered != nil {
-		s.OnConnectionRegistered()
+		s.OnConnectionRegistered(machineID)
 	}
 	defer func() {
 		s.ConnMgr.Unregister(machineID)
 		log.Printf("Connect: machine %s disconnected", machineID)
 	}()
 
+	var handle MessageHandler
+	if s.OnAgentMessage != nil {
+		handle = func(msg *pb.AgentMessage) *pb.ServerMessage { return s.OnAgentMessage(machineID, msg) }
+	}
 	// Run blocks until the stream closes or an error occurs.
-	conn.Run()
+	conn.Run(handle)
 	return nil
 }
+
+// updateAgentInfo records the agent version and address from the Hello. For
+// an agent without a Hello only the address the stream came from is updated.
+func (s *Server) updateAgentInfo(stream pb.AgentService_ConnectServer, machine *models.Machine, hello *pb.Hello, features []pb.Feature) {
+	ip, version := peerIP(stream), machine.AgentVersion
+	var host *models.HostInfo
+	if hello != nil {
+		if v := hello.GetAgentVersion(); v != "" {
+			version = v
+		}
+		if ips := hello.GetIpAddresses(); len(ips) > 0 {
+			ip = ips[0]
+		}
+		host = &models.HostInfo{
+			OS:              hello.GetOs(),
+			Arch:            hello.GetArch(),
+			OSRelease:       hello.GetOsRelease(),
+			Kernel:          hello.GetKernel(),
+			IPAddresses:     hello.GetIpAddresses(),
+			BootID:          hello.GetBootId(),
+			ProtocolVersion: int(hello.GetProtocolVersion()),
+			Actions:         hello.GetActions(),
+		}
+		if up := hello.GetUptimeSeconds(); up > 0 {
+			host.BootedAt = time.Now().Add(-time.Duration(up) * time.Second).Truncate(time.Second)
+		}
+		for _, f := range features {
+			host.Features = append(host.Features, FeatureName(f))
+		}
+	}
+	if ip == "" {
+		ip = machine.AgentIP
+	}
+	if err := s.machineRepo.UpdateAgentInfo(stream.Context(), machine.ID, ip, version, host); err != nil {
+		log.Printf("Connect: failed to update agent info of machine %s: %v", machine.ID.Hex(), err)
+		return
+	}
+	if version != machine.AgentVersion {
+		log.Printf("Connect: machine %s now runs agent %s (was %s)", machine.ID.Hex(), version, machine.AgentVersion)
+	}
+	machine.AgentIP, machine.AgentVersion = ip, version
+}
+
+// renewCertificate asks the agent for a CSR and sends back a new certificate.
+// It runs before Run() starts, so it owns the stream.
+func (s *Server) renewCertificate(stream pb.AgentService_ConnectServer, machineID primitive.ObjectID, current *x509.Certificate) error {
+	err := stream.Send(&pb.ServerMessage{
+		Payload: &pb.ServerMessage_CertificateRenewal{
+			CertificateRenewal: &pb.CertificateRenewal{ExpiresAt: current.NotAfter.Unix()},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	msg, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	csr := msg.GetCertificateSigningRequest()
+	if csr == nil {
+		return fmt.Errorf("expected a certificate signing request")
+	}
+	issued, err := s.authority.Issue(stream.Context(), machineID, csr.GetCsrPem())
+	if err != nil {
+		return err
+	}
+	log.Printf("Connect: renewed certificate of machine %s (expires %s)", machineID.Hex(), issued.ExpiresAt.Format(time.RFC3339))
+	return stream.Send(&pb.ServerMessage{
+		Payload: &pb.ServerMessage_IssuedCertificate{
+			IssuedCertificate: &pb.IssuedCertificate{
+				CertificatePem:   issued.CertificatePEM,
+				CaCertificatePem: issued.CACertificatePEM,
+				ExpiresAt:        issued.ExpiresAt.Unix(),
+			},
+		},
+	})
+}
+
+// rotateAgentToken sends the agent a new token. The current token stays valid
+// until the agent connects with the new one, so a lost message is harmless.
+func (s *Server) rotateAgentToken(stream pb.AgentService_ConnectServer, machineID primitive.ObjectID) error {
+	token, hash, err := agentauth.NewToken()
+	if err != nil {
+		return err
+	}
+	if err := s.machineRepo.SetNextAgentToken(stream.Context(), machineID, hash); err != nil {
+		return err
+	}
+	log.Printf("Connect: rotating agent token of machine %s", machineID.Hex())
+	return stream.Send(&pb.ServerMessage{
+		Payload: &pb.ServerMessage_AgentToken{AgentToken: &pb.AgentToken{Token: token}},
+	})
+}
+
+// peerIP returns the address the stream came from, or "" if unknown.
+func peerIP(stream pb.AgentService_ConnectServer) string {
+	p, ok := peer.FromContext(stream.Context())
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return ""
+	}
+	return host
+}
+
+// clientCertificate returns the verified client certificate of the stream.
+func clientCertificate(stream pb.AgentService_ConnectServer) (*x509.Certificate, error) {
+	p, ok := peer.FromContext(stream.Context())
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "connect: no peer information")
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 || len(tlsInfo.State.VerifiedChains[0]) == 0 {
+		return nil, status.Error(codes.Unauthenticated, "connect: a client certificate is required")
+	}
+	return tlsInfo.State.VerifiedChains[0][0], nil
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/lute/api/authz"
+	"github.com/lute/api/services"
+)
+
+// ActionHandler handles the typed actions (service control, reboot, scripts)
+// users request on machines.
+type ActionHandler struct {
+	actionService *services.ActionService
+}
+
+// NewActionHandler creates a new ActionHandler.
+func NewActionHandler(actionService *services.ActionService) *ActionHandler {
+	return &ActionHandler{actionService: actionService}
+}
+
+// ActionRequest is the optional JSON body of an action request, e.g.
+// {"params": {"unit": "nginx.service"}} for service-restart,
+// {"params": {"delay_seconds": "60"}} for reboot or
+// {"params": {"script": "rotate-logs.sh"}, "args": ["--force"]} for run-script.
+type ActionRequest struct {
+	Params         map[string]string `json:"params"`
+	Args           []string          `json:"args"`
+	TimeoutSeconds int               `json:"timeout_seconds"`
+}
+
+// ListActions handles GET /api/v1/machines/:id/actions
+// Lists the actions and whether the machine's agent has them enabled.
+func (h *ActionHandler) ListActions(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid machine ID"})
+		return
+	}
+	actions, err := h.actionService.Available(c.Request.Context(), id, userID)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, actions)
+}
+
+// RequestAction handles POST /api/v1/machines/:id/actions/:action
+// The action is recorded as a command; its result is read like any other
+// command's (GET /api/v1/agent/command/:commandId).
+func (h *ActionHandler) RequestAction(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid machine ID"})
+		return
+	}
+	var req ActionRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	cmd, err := h.actionService.Request(c.Request.Context(), id, userID, c.Param("action"), services.ActionInput{
+		Params:         req.Params,
+		Args:           req.Args,
+		TimeoutSeconds: req.TimeoutSeconds,
+	})
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusAccepted, cmd)
+}
+
+func (h *ActionHandler) writeError(c *gin.Context, err error) {
+	switch {
+	case err == services.ErrUnknownAction:
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error(), "actions": services.Actions})
+	case errors.Is(err, services.ErrInvalidActionParam):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case err == services.ErrActionUnsupported, err == services.ErrActionDisabled:
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case err.Error() == "machine not found":
+		c.JSON(http.StatusNotFound, gin.H{"error": "machine not found"})
+	case errors.Is(err, authz.ErrForbidden):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
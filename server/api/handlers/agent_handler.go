@@ -1,36 +1,30 @@
 package handlers
 
 import (
-	"crypto/rand"
-	"crypto/sha256"
+	"encoding/base64"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
-	"os"
-	"path/filepath"
+	"sort"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 
+	"github.com/lute/api/agentauth"
+	"github.com/lute/api/agentbin"
+	"github.com/lute/api/audit"
+	"github.com/lute/api/authz"
 	"github.com/lute/api/config"
+	"github.com/lute/api/labels"
 	"github.com/lute/api/models"
+	"github.com/lute/api/pki"
 	"github.com/lute/api/repository"
+	"github.com/lute/api/services"
 )
 
-// AgentBinaryInfo describes one compiled agent binary
-type AgentBinaryInfo struct {
-	OS       string `json:"os"`
-	Arch     string `json:"arch"`
-	Version  string `json:"version"`
-	Filename string `json:"filename"`
-	SHA256   string `json:"sha256"`
-	Size     int64  `json:"size"`
-}
-
 // AgentSetupRequest is sent by the agent during --setup to register a new machine
 type AgentSetupRequest struct {
 	Name      string            `json:"name" binding:"required"`
@@ -40,195 +34,99 @@ type AgentSetupRequest struct {
 	CPUs      int               `json:"cpus"`
 	IP        string            `json:"ip"`
 	Version   string            `json:"version"`
-	Metadata  map[string]string  `json:"metadata,omitempty"`
-	ClaimCode string            `json:"claim_code,omitempty"` // optional; links machine to user when valid
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`     // labels declared in the agent's config
+	ClaimCode string            `json:"claim_code,omitempty"` // single-use code from the Add Machine dialog
+	// EnrollmentToken is a reusable token for automated provisioning; either it or ClaimCode is required
+	EnrollmentToken string `json:"enrollment_token,omitempty"`
+	CSR             string `json:"csr" binding:"required"` // PEM certificate signing request for the agent's mTLS key
 }
 
 // AgentSetupResponse is returned after the agent registers a new machine
 type AgentSetupResponse struct {
-	MachineID   string `json:"machine_id"`
-	GRPCAddress string `json:"grpc_address"`
-	Message     string `json:"message"`
-}
-
-// claimEntry holds a short-lived claim code that links a new machine to a user
-type claimEntry struct {
-	UserID    string
-	ExpiresAt time.Time
+	MachineID            string    `json:"machine_id"`
+	GRPCAddress          string    `json:"grpc_address"`
+	GRPCServerName       string    `json:"grpc_server_name"` // name to verify the gRPC server certificate against
+	Certificate          string    `json:"certificate"`      // PEM client certificate bound to machine_id
+	CACertificate        string    `json:"ca_certificate"`   // PEM CA certificate to trust for the gRPC server
+	CertificateExpiresAt time.Time `json:"certificate_expires_at"`
+	AgentToken           string    `json:"agent_token"` // sent as gRPC metadata on every Connect; shown once
+	Message              string    `json:"message"`
 }
 
 // AgentHandler serves compiled agent binaries and handles agent registration
 type AgentHandler struct {
-	binaryDir   string                      // directory containing compiled agent binaries
-	mu          sync.RWMutex                // protects the cache
-	cache       map[string]*AgentBinaryInfo // key: "os/arch"
-	claimMu     sync.RWMutex
-	claimCodes  map[string]*claimEntry      // short-lived codes: code -> userID + expiry
+	binaries    *agentbin.Index
+	enrollment  *services.EnrollmentTokenService
 	cfg         *config.Config
 	machineRepo *repository.MachineRepository
 	commandRepo *repository.CommandRepository
+	authz       *authz.Authorizer
+	audit       *audit.Recorder
+	certs       *pki.Authority
 }
 
-// NewAgentHandler creates a handler that serves agent binaries from binaryDir.
-// binaryDir layout:
-//
-//	<binaryDir>/
-//	  lute-agent-linux-amd64
-//	  lute-agent-linux-arm64
-//	  lute-agent-darwin-amd64
-//	  lute-agent-darwin-arm64
-//	  lute-agent-windows-amd64.exe
+// NewAgentHandler creates a handler that serves the agent binaries indexed
+// by binaries (see package agentbin for the directory layout).
 func NewAgentHandler(
-	binaryDir string,
+	binaries *agentbin.Index,
 	cfg *config.Config,
 	machineRepo *repository.MachineRepository,
 	commandRepo *repository.CommandRepository,
+	authorizer *authz.Authorizer,
+	recorder *audit.Recorder,
+	certAuthority *pki.Authority,
+	enrollment *services.EnrollmentTokenService,
 ) *AgentHandler {
 	h := &AgentHandler{
-		binaryDir:   binaryDir,
-		cache:       make(map[string]*AgentBinaryInfo),
-		claimCodes:  make(map[string]*claimEntry),
+		binaries:    binaries,
+		enrollment:  enrollment,
 		cfg:         cfg,
 		machineRepo: machineRepo,
 		commandRepo: commandRepo,
+		authz:       authorizer,
+		audit:       recorder,
+		certs:       certAuthority,
 	}
-	h.refreshCache()
 	return h
 }
 
-const claimCodeLen = 20
-const claimCodeExpiry = 15 * time.Minute
-const claimCodeChars = "0123456789ABCDEFGHJKLMNPQRSTUVWXYZ" // no I,O to avoid confusion
-
-func (h *AgentHandler) createClaimCode(userID string) (code string, expiresAt time.Time) {
-	b := make([]byte, claimCodeLen)
-	_, _ = rand.Read(b)
-	for i := range b {
-		b[i] = claimCodeChars[int(b[i])%len(claimCodeChars)]
-	}
-	code = string(b)
-	expiresAt = time.Now().Add(claimCodeExpiry)
-	h.claimMu.Lock()
-	defer h.claimMu.Unlock()
-	// Remove expired
-	for k, v := range h.claimCodes {
-		if time.Now().After(v.ExpiresAt) {
-			delete(h.claimCodes, k)
-		}
-	}
-	h.claimCodes[code] = &claimEntry{UserID: userID, ExpiresAt: expiresAt}
-	return code, expiresAt
-}
-
-func (h *AgentHandler) consumeClaimCode(code string) (userID string, ok bool) {
-	if code == "" {
-		return "", false
-	}
-	h.claimMu.Lock()
-	defer h.claimMu.Unlock()
-	ent, ok := h.claimCodes[code]
-	if !ok || time.Now().After(ent.ExpiresAt) {
-		return "", false
-	}
-	delete(h.claimCodes, code)
-	return ent.UserID, true
-}
-
-// refreshCache scans the binary directory and rebuilds metadata cache
-func (h *AgentHandler) refreshCache() {
-	h.mu.Lock()
-	defer h.mu.Unlock()
-
-	entries, err := os.ReadDir(h.binaryDir)
-	if err != nil {
-		log.Printf("Warning: cannot read agent binary dir %s: %v", h.binaryDir, err)
-		return
-	}
-
-	newCache := make(map[string]*AgentBinaryInfo)
-	for _, entry := range entries {
-		if entry.IsDir() {
-			continue
-		}
-		name := entry.Name()
-		if !strings.HasPrefix(name, "lute-agent-") {
-			continue
-		}
-
-		osName, arch := parseFilename(name)
-		if osName == "" || arch == "" {
-			continue
-		}
-
-		fullPath := filepath.Join(h.binaryDir, name)
-		info, err := entry.Info()
-		if err != nil {
-			continue
-		}
-
-		checksum, err := sha256File(fullPath)
-		if err != nil {
-			log.Printf("Warning: cannot compute checksum for %s: %v", name, err)
-			continue
-		}
-
-		key := osName + "/" + arch
-		newCache[key] = &AgentBinaryInfo{
-			OS:       osName,
-			Arch:     arch,
-			Version:  readVersionFile(h.binaryDir),
-			Filename: name,
-			SHA256:   checksum,
-			Size:     info.Size(),
-		}
-		log.Printf("Indexed agent binary: %s (%s/%s, %d bytes)", name, osName, arch, info.Size())
-	}
-
-	h.cache = newCache
-}
-
 // ListBinaries returns metadata about all available agent binaries
 // GET /api/v1/agent/binaries
 func (h *AgentHandler) ListBinaries(c *gin.Context) {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
-
-	binaries := make([]*AgentBinaryInfo, 0, len(h.cache))
-	for _, b := range h.cache {
-		binaries = append(binaries, b)
-	}
-
 	c.JSON(http.StatusOK, gin.H{
-		"binaries": binaries,
-		"version":  readVersionFile(h.binaryDir),
+		"binaries":   h.binaries.List(),
+		"version":    h.binaries.Version(),
+		"versions":   h.binaries.Versions(),
+		"channels":   h.binaries.ChannelVersions(),
+		"public_key": h.binaries.PublicKey(),
 	})
 }
 
-// DownloadBinary serves the agent binary for the requested OS/arch
+// DownloadBinary serves the agent binary for the requested OS/arch, from the
+// stable channel unless ?version= or ?channel= says otherwise
 // GET /api/v1/agent/download/:os/:arch
 func (h *AgentHandler) DownloadBinary(c *gin.Context) {
 	osName := c.Param("os")
 	arch := c.Param("arch")
-	key := osName + "/" + arch
-
-	h.mu.RLock()
-	info, ok := h.cache[key]
-	h.mu.RUnlock()
+	h.serveBinary(c, osName, arch)
+}
 
+// DownloadSignature serves the raw detached ed25519 signature of a binary;
+// it takes the same ?version= and ?channel= as DownloadBinary
+// GET /api/v1/agent/download/:os/:arch/signature
+func (h *AgentHandler) DownloadSignature(c *gin.Context) {
+	info, ok := h.findBinary(c, c.Param("os"), c.Param("arch"))
 	if !ok {
-		c.JSON(http.StatusNotFound, gin.H{
-			"error":     fmt.Sprintf("no agent binary for %s/%s", osName, arch),
-			"available": h.availableKeys(),
-		})
 		return
 	}
-
-	fullPath := filepath.Join(h.binaryDir, info.Filename)
-	c.Header("Content-Type", "application/octet-stream")
-	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", info.Filename))
+	if len(info.Signature) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("the %s/%s agent binary %s is not signed", info.OS, info.Arch, info.Version)})
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s%s", info.Filename, agentbin.SignatureSuffix))
 	c.Header("X-Agent-Version", info.Version)
-	c.Header("X-Agent-SHA256", info.SHA256)
-	c.File(fullPath)
+	c.Data(http.StatusOK, "application/octet-stream", info.Signature)
 }
 
 // DownloadAutoDetect serves the binary based on the requesting machine's info
@@ -236,44 +134,32 @@ func (h *AgentHandler) DownloadBinary(c *gin.Context) {
 func (h *AgentHandler) DownloadAutoDetect(c *gin.Context) {
 	osName := c.DefaultQuery("os", "linux")
 	arch := c.DefaultQuery("arch", "amd64")
-	key := osName + "/" + arch
-
-	h.mu.RLock()
-	info, ok := h.cache[key]
-	h.mu.RUnlock()
-
-	if !ok {
-		c.JSON(http.StatusNotFound, gin.H{
-			"error":     fmt.Sprintf("no agent binary for %s/%s", osName, arch),
-			"available": h.availableKeys(),
-		})
-		return
-	}
-
-	fullPath := filepath.Join(h.binaryDir, info.Filename)
-	c.Header("Content-Type", "application/octet-stream")
-	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", info.Filename))
-	c.Header("X-Agent-Version", info.Version)
-	c.Header("X-Agent-SHA256", info.SHA256)
-	c.File(fullPath)
+	h.serveBinary(c, osName, arch)
 }
 
-// GetVersion returns the current agent version
+// GetVersion returns the agent version of a channel (?channel=, default stable)
 // GET /api/v1/agent/version
 func (h *AgentHandler) GetVersion(c *gin.Context) {
+	channel := c.DefaultQuery("channel", agentbin.ChannelStable)
+	version, err := h.binaries.Resolve("", channel)
+	if err == agentbin.ErrUnknownChannel {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		version = "unknown"
+	}
 	c.JSON(http.StatusOK, gin.H{
-		"version": readVersionFile(h.binaryDir),
+		"version":  version,
+		"channel":  channel,
+		"channels": h.binaries.ChannelVersions(),
 	})
 }
 
 // RefreshBinaries re-scans the binary directory (for hot-reload after upload)
 // POST /api/v1/agent/refresh
 func (h *AgentHandler) RefreshBinaries(c *gin.Context) {
-	h.refreshCache()
-
-	h.mu.RLock()
-	count := len(h.cache)
-	h.mu.RUnlock()
+	count := h.binaries.Refresh()
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Binary cache refreshed",
@@ -294,11 +180,63 @@ func (h *AgentHandler) InstallScript(c *gin.Context) {
 	}
 	baseURL := fmt.Sprintf("%s://%s", scheme, c.Request.Host)
 
+	// ?version= and ?channel= on the script URL become the defaults below
+	version := c.Query("version")
+	channel := c.Query("channel")
+	if _, err := h.binaries.Resolve(version, channel); err != nil {
+		// The response is usually piped into bash: fail with a readable message
+		c.Data(http.StatusBadRequest, "text/x-shellscript", []byte(fmt.Sprintf("#!/bin/bash\necho %q >&2\nexit 1\n", "Lute agent installer: "+err.Error())))
+		return
+	}
+
 	script := fmt.Sprintf(`#!/bin/bash
 set -e
 
 # Lute Agent Installer
-# Usage: curl -sSL %s/api/v1/agent/install.sh | bash -s -- --machine-id <ID> --server <GRPC_ADDR>
+# Usage: curl -sSL %[1]s/api/v1/agent/install.sh | sudo bash -s -- --claim-code <CLAIM_CODE>
+#
+# Options:
+#   --claim-code CODE         one-time code from the Add Machine dialog
+#   --enrollment-token TOKEN  reusable token for automated provisioning
+#   --labels k=v,k2=v2        labels for this machine
+#   --no-service              only install the binary
+#   --version VERSION         install this agent version
+#   --channel CHANNEL         install the version of a release channel
+#                             (stable, beta or canary; default stable)
+#   --public-key KEY          ed25519 release key (base64) to verify the binary
+#                             against instead of the one pinned below
+#   --insecure-skip-verify    install without checking the signature
+#
+# The binary is verified with openssl (3.0 or newer) before it is installed.
+
+# Release signing key pinned by the server that generated this script
+PUBLIC_KEY="%[2]s"
+
+CLAIM_CODE=""
+ENROLLMENT_TOKEN=""
+LABELS=""
+INSTALL_SERVICE=1
+VERIFY=1
+VERSION="%[3]s"
+CHANNEL="%[4]s"
+while [ $# -gt 0 ]; do
+  case "$1" in
+    --claim-code)       CLAIM_CODE="$2"; shift 2 ;;
+    --enrollment-token) ENROLLMENT_TOKEN="$2"; shift 2 ;;
+    --labels)           LABELS="$2"; shift 2 ;;
+    --no-service)       INSTALL_SERVICE=0; shift ;;
+    --version)          VERSION="$2"; shift 2 ;;
+    --channel)          CHANNEL="$2"; shift 2 ;;
+    --public-key)       PUBLIC_KEY="$2"; shift 2 ;;
+    --insecure-skip-verify) VERIFY=0; shift ;;
+    *) echo "Unknown option: $1" >&2; exit 2 ;;
+  esac
+done
+
+SUDO=""
+if [ "$(id -u)" -ne 0 ]; then
+  SUDO="sudo"
+fi
 
 OS=$(uname -s | tr '[:upper:]' '[:lower:]')
 ARCH=$(uname -m)
@@ -312,21 +250,66 @@ INSTALL_DIR="/usr/local/bin"
 BINARY_NAME="lute-agent"
 
 echo "==> Detecting platform: ${OS}/${ARCH}"
-echo "==> Downloading agent from %s ..."
-
-curl -fSL -o "/tmp/${BINARY_NAME}" \
-  "%s/api/v1/agent/download/${OS}/${ARCH}"
-
-chmod +x "/tmp/${BINARY_NAME}"
-sudo mv "/tmp/${BINARY_NAME}" "${INSTALL_DIR}/${BINARY_NAME}"
+echo "==> Downloading agent from %[1]s ..."
+
+TMP_DIR=$(mktemp -d)
+trap 'rm -rf "$TMP_DIR"' EXIT
+
+QUERY="channel=${CHANNEL}"
+[ -n "$VERSION" ] && QUERY="version=${VERSION}"
+curl -fSL -D "${TMP_DIR}/headers" -o "${TMP_DIR}/${BINARY_NAME}" \
+  "%[1]s/api/v1/agent/download/${OS}/${ARCH}?${QUERY}"
+# Pin the version actually served so the signature matches even if the
+# channel moves in between
+VERSION=$(grep -i '^x-agent-version:' "${TMP_DIR}/headers" | tail -n 1 | cut -d' ' -f2 | tr -d '\r')
+echo "==> Downloaded agent ${VERSION}"
+
+if [ "$VERIFY" = 0 ]; then
+  echo "==> WARNING: skipping signature verification (--insecure-skip-verify)"
+elif [ -z "$PUBLIC_KEY" ]; then
+  echo "==> WARNING: the server has no release signing key; the binary is not verified"
+else
+  echo "==> Verifying signature"
+  if ! command -v openssl >/dev/null 2>&1; then
+    echo "openssl is required to verify the agent binary (or pass --insecure-skip-verify)" >&2
+    exit 1
+  fi
+  curl -fsSL -o "${TMP_DIR}/${BINARY_NAME}.sig" \
+    "%[1]s/api/v1/agent/download/${OS}/${ARCH}/signature?version=${VERSION}"
+  # DER SubjectPublicKeyInfo of an ed25519 key: fixed prefix + 32 raw bytes
+  { printf '\060\052\060\005\006\003\053\145\160\003\041\000'; printf '%%s' "$PUBLIC_KEY" | base64 -d; } >"${TMP_DIR}/release.der"
+  if ! openssl pkeyutl -verify -pubin -keyform DER -inkey "${TMP_DIR}/release.der" \
+      -rawin -in "${TMP_DIR}/${BINARY_NAME}" -sigfile "${TMP_DIR}/${BINARY_NAME}.sig" >/dev/null; then
+    echo "Signature verification FAILED; not installing ${BINARY_NAME}" >&2
+    exit 1
+  fi
+fi
+
+chmod +x "${TMP_DIR}/${BINARY_NAME}"
+$SUDO mv "${TMP_DIR}/${BINARY_NAME}" "${INSTALL_DIR}/${BINARY_NAME}"
 
 echo "==> Installed ${BINARY_NAME} to ${INSTALL_DIR}/${BINARY_NAME}"
 ${INSTALL_DIR}/${BINARY_NAME} --version
 
+if [ "$OS" = "linux" ] && [ "$INSTALL_SERVICE" = 1 ]; then
+  ARGS=(--api "%[1]s")
+  [ -n "$CLAIM_CODE" ] && ARGS+=(--claim-code "$CLAIM_CODE")
+  [ -n "$ENROLLMENT_TOKEN" ] && ARGS+=(--enrollment-token "$ENROLLMENT_TOKEN")
+  [ -n "$LABELS" ] && ARGS+=(--labels "$LABELS")
+
+  echo "==> Installing the ${BINARY_NAME} service"
+  $SUDO "${INSTALL_DIR}/${BINARY_NAME}" service install "${ARGS[@]}"
+  echo ""
+  echo "==> Check the agent with: ${BINARY_NAME} service status"
+  exit 0
+fi
+
 echo ""
-echo "==> Run the agent:"
-echo "    ${BINARY_NAME} --server <GRPC_HOST:PORT> --machine-id <MACHINE_ID>"
-`, baseURL, baseURL, baseURL)
+echo "==> Register and run the agent (it receives its mTLS client certificate on registration):"
+echo "    ${BINARY_NAME} --api %[1]s --claim-code <CLAIM_CODE>"
+echo "==> For automated provisioning, use a reusable token instead:"
+echo "    ${BINARY_NAME} --api %[1]s --enrollment-token <ENROLLMENT_TOKEN>"
+`, baseURL, h.binaries.PublicKey(), version, channel)
 
 	c.Data(http.StatusOK, "text/x-shellscript", []byte(script))
 }
@@ -339,30 +322,57 @@ func (h *AgentHandler) RegisterFromAgent(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	if req.ClaimCode == "" {
+	secret := req.EnrollmentToken
+	if secret == "" {
+		secret = req.ClaimCode
+	}
+	if secret == "" {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "claim_code is required. Open the Add Machine dialog in the Lute UI (while logged in), copy the full command including --claim-code, and run it on this VM.",
+			"error": "claim_code or enrollment_token is required. Open the Add Machine dialog in the Lute UI (while logged in), copy the full command including --claim-code, and run it on this VM.",
 		})
 		return
 	}
 
-	ctx := c.Request.Context()
-
-	// Resolve user from one-time claim code (required)
-	uidStr, ok := h.consumeClaimCode(req.ClaimCode)
-	if !ok {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "invalid or expired claim code. Codes are single-use and expire after 15 minutes. Open the Add Machine dialog in the Lute UI (while logged in), copy the full command again (it includes a new --claim-code), and run it on this VM.",
-		})
+	if err := labels.Validate(req.Labels); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// Reject a bad CSR before the token is consumed
+	if _, err := pki.ParseCSR([]byte(req.CSR)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	userID, err := primitive.ObjectIDFromHex(uidStr)
+
+	ctx := c.Request.Context()
+
+	// Resolve owner, org and defaults from the claim code or enrollment token
+	enrollment, err := h.enrollment.Consume(ctx, secret)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "invalid claim code format. Use the exact command from the Add Machine dialog in the Lute UI.",
-		})
+		if err != services.ErrEnrollmentInvalid {
+			log.Printf("Failed to check enrollment token: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register agent"})
+			return
+		}
+		msg := "invalid or expired claim code. Codes are single-use and expire after 15 minutes. Open the Add Machine dialog in the Lute UI (while logged in), copy the full command again (it includes a new --claim-code), and run it on this VM."
+		if req.EnrollmentToken != "" {
+			msg = err.Error()
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
 		return
 	}
+	userID := enrollment.UserID
+
+	// Token labels are defaults; labels declared by the agent win
+	machineLabels := make(map[string]string, len(enrollment.Labels)+len(req.Labels))
+	for k, v := range enrollment.Labels {
+		machineLabels[k] = v
+	}
+	for k, v := range req.Labels {
+		machineLabels[k] = v
+	}
+	if len(machineLabels) == 0 {
+		machineLabels = nil
+	}
 
 	// Build metadata from agent system info
 	metadata := map[string]interface{}{
@@ -376,34 +386,62 @@ func (h *AgentHandler) RegisterFromAgent(c *gin.Context) {
 		metadata[k] = v
 	}
 
-	// Create the machine record (userID from claim code above)
+	// Create the machine record (owner from the enrollment token above)
 	machine := &models.Machine{
 		UserID:      userID,
+		OrgID:       enrollment.OrgID,
 		Name:        req.Name,
 		Description: fmt.Sprintf("Registered from agent on %s (%s/%s)", req.Hostname, req.OS, req.Arch),
 		Status:      "pending",
 		Metadata:    metadata,
+		Labels:      machineLabels,
+	}
+	if !enrollment.GroupID.IsZero() {
+		machine.GroupIDs = []primitive.ObjectID{enrollment.GroupID}
 	}
 	if err := h.machineRepo.Create(ctx, machine); err != nil {
+		h.enrollment.Release(ctx, enrollment)
 		log.Printf("Failed to create machine: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create machine"})
 		return
 	}
 
+	agentToken, agentTokenHash, err := agentauth.NewToken()
+	if err != nil {
+		_ = h.machineRepo.Delete(ctx, machine.ID)
+		h.enrollment.Release(ctx, enrollment)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register agent"})
+		return
+	}
+
 	// Update machine with agent information
+	now := time.Now()
 	machine.Status = "registered"
 	machine.AgentIP = req.IP
 	machine.AgentVersion = req.Version
-	machine.LastSeen = time.Now()
-	
+	machine.LastSeen = now
+	machine.AgentTokenHash = agentTokenHash
+	machine.AgentTokenIssuedAt = &now
+
 	if err := h.machineRepo.Update(ctx, machine.ID, machine); err != nil {
 		log.Printf("Failed to update machine with agent info: %v", err)
 		// Clean up: delete the machine we just created
 		_ = h.machineRepo.Delete(ctx, machine.ID)
+		h.enrollment.Release(ctx, enrollment)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register agent"})
 		return
 	}
 
+	// Issue the client certificate the agent authenticates to the gRPC server with
+	issued, err := h.certs.Issue(ctx, machine.ID, []byte(req.CSR))
+	if err != nil {
+		log.Printf("Failed to issue agent certificate: %v", err)
+		_ = h.machineRepo.Delete(ctx, machine.ID)
+		h.enrollment.Release(ctx, enrollment)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue agent certificate"})
+		return
+	}
+
 	// Derive gRPC address from the request's Host header
 	// This ensures the agent connects to the same hostname it used for HTTP
 	host := c.Request.Host
@@ -426,26 +464,61 @@ func (h *AgentHandler) RegisterFromAgent(c *gin.Context) {
 
 	grpcAddr := fmt.Sprintf("%s:%s", host, h.cfg.GRPC.Port)
 
+	// The agent acts on behalf of the user who created the claim code or token
+	h.audit.Record(audit.WithActor(ctx, audit.Actor{Type: audit.ActorAgent, UserID: userID}), audit.Entry{
+		Action: audit.ActionAgentRegister,
+		Target: audit.MachineTarget(machine),
+		Details: map[string]interface{}{
+			"hostname":         req.Hostname,
+			"os":               req.OS,
+			"arch":             req.Arch,
+			"version":          req.Version,
+			"enrollment_kind":  enrollment.Kind,
+			"enrollment_token": enrollment.ID.Hex(),
+		},
+	})
+
 	log.Printf("Agent registered: machine=%s host=%s grpc=%s",
 		machine.ID.Hex(), req.Hostname, grpcAddr)
 
 	c.JSON(http.StatusCreated, AgentSetupResponse{
-		MachineID:   machine.ID.Hex(),
-		GRPCAddress: grpcAddr,
-		Message:     "Machine registered successfully",
+		MachineID:            machine.ID.Hex(),
+		GRPCAddress:          grpcAddr,
+		GRPCServerName:       h.certs.ServerName(),
+		Certificate:          string(issued.CertificatePEM),
+		CACertificate:        string(issued.CACertificatePEM),
+		CertificateExpiresAt: issued.ExpiresAt,
+		AgentToken:           agentToken,
+		Message:              "Machine registered successfully",
 	})
 }
 
 // CreateClaimCode handles POST /api/v1/agent/claim-code (authenticated).
 // Returns a short-lived code the user can pass to the agent so the new machine is linked to them.
+// Optional query org_id=<hex> registers the machine into that organization (requires admin).
 func (h *AgentHandler) CreateClaimCode(c *gin.Context) {
-	uid, exists := c.Get("user_id")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "not authenticated"})
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	orgID := primitive.NilObjectID
+	if raw := c.Query("org_id"); raw != "" {
+		var err error
+		orgID, err = primitive.ObjectIDFromHex(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid org_id"})
+			return
+		}
+	}
+	code, expiresAt, err := h.enrollment.CreateClaimCode(c.Request.Context(), userID, orgID)
+	if err != nil {
+		if errors.Is(err, authz.ErrForbidden) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	userIDStr, _ := uid.(string)
-	code, expiresAt := h.createClaimCode(userIDStr)
 	c.JSON(http.StatusOK, gin.H{
 		"code":       code,
 		"expires_at": expiresAt.UTC().Format(time.RFC3339),
@@ -454,47 +527,47 @@ func (h *AgentHandler) CreateClaimCode(c *gin.Context) {
 
 // --- helpers ---
 
-func (h *AgentHandler) availableKeys() []string {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
-	keys := make([]string, 0, len(h.cache))
-	for k := range h.cache {
-		keys = append(keys, k)
+func (h *AgentHandler) serveBinary(c *gin.Context, osName, arch string) {
+	info, ok := h.findBinary(c, osName, arch)
+	if !ok {
+		return
 	}
-	return keys
-}
 
-// parseFilename extracts OS and arch from "lute-agent-<os>-<arch>[.exe]"
-func parseFilename(name string) (string, string) {
-	name = strings.TrimSuffix(name, ".exe")
-	parts := strings.Split(name, "-")
-	// expect: lute-agent-linux-amd64
-	if len(parts) < 4 {
-		return "", ""
+	c.Header("Content-Type", "application/octet-stream")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", info.Filename))
+	c.Header("X-Agent-Version", info.Version)
+	c.Header("X-Agent-SHA256", info.SHA256)
+	if len(info.Signature) > 0 {
+		c.Header("X-Agent-Signature", base64.StdEncoding.EncodeToString(info.Signature))
 	}
-	return parts[2], parts[3]
+	c.File(h.binaries.Path(info))
 }
 
-func sha256File(path string) (string, error) {
-	f, err := os.Open(path)
-	if err != nil {
-		return "", err
-	}
-	defer f.Close()
-
-	h := sha256.New()
-	if _, err := io.Copy(h, f); err != nil {
-		return "", err
+// findBinary looks up the binary for a platform in the version given by the
+// ?version= or ?channel= query (default: stable) and writes the error
+// response when there is none.
+func (h *AgentHandler) findBinary(c *gin.Context, osName, arch string) (*agentbin.Binary, bool) {
+	version, err := h.binaries.Resolve(c.Query("version"), c.Query("channel"))
+	switch {
+	case err == agentbin.ErrUnknownChannel:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	case err != nil:
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":    err.Error(),
+			"versions": h.binaries.Versions(),
+		})
+		return nil, false
 	}
-	return fmt.Sprintf("%x", h.Sum(nil)), nil
-}
-
-func readVersionFile(dir string) string {
-	data, err := os.ReadFile(filepath.Join(dir, "VERSION"))
-	if err != nil {
-		return "unknown"
+	info, ok := h.binaries.Find(osName, arch, version)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":     fmt.Sprintf("no agent binary for %s/%s in version %s", osName, arch, version),
+			"available": h.binaries.Platforms(version),
+		})
+		return nil, false
 	}
-	return strings.TrimSpace(string(data))
+	return info, true
 }
 
 // ===========================================================================
@@ -526,10 +599,9 @@ func (h *AgentHandler) SendCommand(c *gin.Context) {
 
 	ctx := c.Request.Context()
 
-	// Look up the machine to get its agent ID
-	machine, err := h.machineRepo.GetByID(ctx, machineID)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "machine not found"})
+	// Look up the machine and check command execution rights
+	machine, ok := h.authorizedMachine(c, machineID, authz.ActionCommandExecute)
+	if !ok {
 		return
 	}
 
@@ -551,6 +623,22 @@ func (h *AgentHandler) SendCommand(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to queue command"})
 		return
 	}
+	// Environment values may hold secrets, so only their names are recorded
+	envKeys := make([]string, 0, len(req.Env))
+	for k := range req.Env {
+		envKeys = append(envKeys, k)
+	}
+	sort.Strings(envKeys)
+	h.audit.Record(ctx, audit.Entry{
+		Action: audit.ActionCommandSend,
+		Target: audit.MachineTarget(machine),
+		Details: map[string]interface{}{
+			"command_id": cmd.ID.Hex(),
+			"command":    req.Command,
+			"args":       req.Args,
+			"env_keys":   envKeys,
+		},
+	})
 
 	c.JSON(http.StatusCreated, gin.H{
 		"command_id": cmd.ID.Hex(),
@@ -569,6 +657,10 @@ func (h *AgentHandler) ListCommands(c *gin.Context) {
 		return
 	}
 
+	if _, ok := h.authorizedMachine(c, machineID, authz.ActionMachineRead); !ok {
+		return
+	}
+
 	ctx := c.Request.Context()
 	commands, err := h.commandRepo.GetByMachineID(ctx, machineID, 50)
 	if err != nil {
@@ -593,11 +685,8 @@ func (h *AgentHandler) GetAgentStatus(c *gin.Context) {
 		return
 	}
 
-	ctx := c.Request.Context()
-
-	machine, err := h.machineRepo.GetByID(ctx, machineID)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "machine not found"})
+	machine, ok := h.authorizedMachine(c, machineID, authz.ActionMachineRead)
+	if !ok {
 		return
 	}
 
@@ -633,6 +722,33 @@ func (h *AgentHandler) GetCommandResult(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "command not found"})
 		return
 	}
+	if _, ok := h.authorizedMachine(c, cmd.MachineID, authz.ActionMachineRead); !ok {
+		return
+	}
 
 	c.JSON(http.StatusOK, cmd)
 }
+
+// authorizedMachine loads a machine and checks that the current user may perform
+// action on it. On failure it writes the error response and returns false.
+func (h *AgentHandler) authorizedMachine(c *gin.Context, machineID primitive.ObjectID, action authz.Action) (*models.Machine, bool) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return nil, false
+	}
+	ctx := c.Request.Context()
+	machine, err := h.machineRepo.GetByID(ctx, machineID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "machine not found"})
+		return nil, false
+	}
+	if err := h.authz.AuthorizeMachine(ctx, userID, machine, action); err != nil {
+		if errors.Is(err, authz.ErrForbidden) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return nil, false
+	}
+	return machine, true
+}
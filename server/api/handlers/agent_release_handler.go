@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/lute/api/agentbin"
+	"github.com/lute/api/authz"
+	"github.com/lute/api/services"
+)
+
+// AgentReleaseHandler handles uploads of agent builds and release channels.
+type AgentReleaseHandler struct {
+	releaseService *services.AgentReleaseService
+}
+
+// NewAgentReleaseHandler creates a new AgentReleaseHandler.
+func NewAgentReleaseHandler(releaseService *services.AgentReleaseService) *AgentReleaseHandler {
+	return &AgentReleaseHandler{releaseService: releaseService}
+}
+
+// SetChannelRequest is the JSON body for moving a release channel.
+type SetChannelRequest struct {
+	Version string `json:"version" binding:"required"`
+}
+
+// UploadBinary stores an agent build for one platform.
+// POST /api/v1/agent/releases (multipart form)
+//
+//	version    release version, e.g. 1.5.0 (required)
+//	os, arch   platform, e.g. linux and amd64 (required)
+//	binary     the agent binary (required)
+//	signature  its detached ed25519 signature, raw or base64
+//	channel    point this channel (stable, beta, canary) at the version
+func (h *AgentReleaseHandler) UploadBinary(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, agentbin.MaxUploadSize+1<<20)
+
+	file, err := c.FormFile("binary")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			h.writeError(c, err)
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "binary file is required"})
+		return
+	}
+	binary, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer binary.Close()
+
+	in := services.UploadAgentBinaryInput{
+		Version: c.PostForm("version"),
+		OS:      c.PostForm("os"),
+		Arch:    c.PostForm("arch"),
+		Binary:  binary,
+		Channel: c.PostForm("channel"),
+	}
+	if in.Version == "" || in.OS == "" || in.Arch == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "version, os and arch are required"})
+		return
+	}
+	if in.Signature, err = readSignatureField(c); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	bin, err := h.releaseService.Upload(c.Request.Context(), userID, in)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, bin)
+}
+
+// SetChannel points a release channel at a version.
+// PUT /api/v1/agent/channels/:channel
+func (h *AgentReleaseHandler) SetChannel(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	var req SetChannelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	channel := c.Param("channel")
+	if err := h.releaseService.SetChannel(c.Request.Context(), userID, channel, req.Version); err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"channel": channel, "version": req.Version})
+}
+
+// Prune deletes old agent versions.
+// POST /api/v1/agent/releases/prune
+func (h *AgentReleaseHandler) Prune(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	removed, err := h.releaseService.Prune(c.Request.Context(), userID)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	if removed == nil {
+		removed = []string{}
+	}
+	c.JSON(http.StatusOK, gin.H{"removed": removed})
+}
+
+func (h *AgentReleaseHandler) writeError(c *gin.Context, err error) {
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.Is(err, authz.ErrForbidden):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case err == agentbin.ErrUnknownVersion:
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case err == agentbin.ErrTooLarge, errors.As(err, &tooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": agentbin.ErrTooLarge.Error()})
+	case err == agentbin.ErrUnknownChannel, err == agentbin.ErrBadVersion, err == agentbin.ErrBadPlatform,
+		err == agentbin.ErrUnsigned, err == agentbin.ErrSignature, err == agentbin.ErrMalformedSignature:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// readSignatureField reads the signature from the "signature" file or form
+// field; it returns nil when neither is set.
+func readSignatureField(c *gin.Context) ([]byte, error) {
+	var data []byte
+	if file, err := c.FormFile("signature"); err == nil {
+		f, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if data, err = io.ReadAll(io.LimitReader(f, 1<<10)); err != nil {
+			return nil, err
+		}
+	} else if v := c.PostForm("signature"); v != "" {
+		data = []byte(v)
+	} else {
+		return nil, nil
+	}
+	return agentbin.ParseSignature(data)
+}
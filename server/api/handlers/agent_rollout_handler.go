@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/lute/api/authz"
+	"github.com/lute/api/services"
+)
+
+// AgentRolloutHandler handles the rollouts that decide which agent version
+// machines run.
+type AgentRolloutHandler struct {
+	rolloutService *services.AgentRolloutService
+}
+
+// NewAgentRolloutHandler creates a new AgentRolloutHandler.
+func NewAgentRolloutHandler(rolloutService *services.AgentRolloutService) *AgentRolloutHandler {
+	return &AgentRolloutHandler{rolloutService: rolloutService}
+}
+
+// CreateAgentRolloutRequest is the JSON body for creating a rollout. Without
+// machine_ids and group_ids it applies to every machine of the owner.
+// Percent 0 means 100.
+type CreateAgentRolloutRequest struct {
+	Version    string   `json:"version" binding:"required"`
+	OrgID      string   `json:"org_id"`
+	MachineIDs []string `json:"machine_ids"`
+	GroupIDs   []string `json:"group_ids"`
+	Percent    int      `json:"percent"`
+}
+
+// UpdateAgentRolloutRequest is the JSON body for changing a rollout's percentage.
+type UpdateAgentRolloutRequest struct {
+	Percent int `json:"percent" binding:"required"`
+}
+
+// CreateRollout handles POST /api/v1/agent-rollouts
+func (h *AgentRolloutHandler) CreateRollout(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	var req CreateAgentRolloutRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	in := services.CreateAgentRolloutInput{Version: req.Version, Percent: req.Percent}
+	if req.OrgID != "" {
+		orgID, err := primitive.ObjectIDFromHex(req.OrgID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+			return
+		}
+		in.OrgID = orgID
+	}
+	var err error
+	if in.MachineIDs, err = parseObjectIDs(req.MachineIDs); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid machine ID"})
+		return
+	}
+	if in.GroupIDs, err = parseObjectIDs(req.GroupIDs); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group ID"})
+		return
+	}
+
+	rollout, err := h.rolloutService.Create(c.Request.Context(), userID, in)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, rollout)
+}
+
+// ListRollouts handles GET /api/v1/agent-rollouts
+func (h *AgentRolloutHandler) ListRollouts(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	rollouts, err := h.rolloutService.List(c.Request.Context(), userID)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, rollouts)
+}
+
+// UpdateRollout handles PATCH /api/v1/agent-rollouts/:id
+func (h *AgentRolloutHandler) UpdateRollout(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rollout ID"})
+		return
+	}
+	var req UpdateAgentRolloutRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rollout, err := h.rolloutService.SetPercent(c.Request.Context(), userID, id, req.Percent)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, rollout)
+}
+
+// DeleteRollout handles DELETE /api/v1/agent-rollouts/:id
+func (h *AgentRolloutHandler) DeleteRollout(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rollout ID"})
+		return
+	}
+	if err := h.rolloutService.Delete(c.Request.Context(), userID, id); err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Agent rollout deleted successfully"})
+}
+
+func (h *AgentRolloutHandler) writeError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, authz.ErrForbidden), err == services.ErrGroupUnauthorized:
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case err == services.ErrRolloutNotFound, err == services.ErrGroupNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case err == services.ErrRolloutVersionMissing, err == services.ErrRolloutUnknownVersion,
+		err == services.ErrRolloutBadPercent, err == services.ErrRolloutBadMachine:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+func parseObjectIDs(hexIDs []string) ([]primitive.ObjectID, error) {
+	if len(hexIDs) == 0 {
+		return nil, nil
+	}
+	ids := make([]primitive.ObjectID, 0, len(hexIDs))
+	for _, h := range hexIDs {
+		id, err := primitive.ObjectIDFromHex(h)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
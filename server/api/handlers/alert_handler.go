@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/lute/api/authz"
+	"github.com/lute/api/models"
+	"github.com/lute/api/services"
+)
+
+// AlertHandler handles alert rules and the alerts they raise.
+type AlertHandler struct {
+	alertService *services.AlertService
+}
+
+// NewAlertHandler creates a new AlertHandler.
+func NewAlertHandler(alertService *services.AlertService) *AlertHandler {
+	return &AlertHandler{alertService: alertService}
+}
+
+// AlertRuleRequest is the JSON body for creating or replacing an alert rule.
+// A unit_state rule without states or min_restarts alerts on failed units;
+// unit defaults to "*".
+type AlertRuleRequest struct {
+	Name        string   `json:"name" binding:"required"`
+	OrgID       string   `json:"org_id"` // create only
+	Kind        string   `json:"kind"`
+	Selector    string   `json:"selector"`
+	Unit        string   `json:"unit"`
+	States      []string `json:"states"`
+	MinRestarts uint32   `json:"min_restarts"`
+	Notify      []string `json:"notify"`
+	Disabled    bool     `json:"disabled"`
+}
+
+func (r AlertRuleRequest) input() services.AlertRuleInput {
+	return services.AlertRuleInput{
+		Name:        r.Name,
+		Kind:        r.Kind,
+		Selector:    r.Selector,
+		Unit:        r.Unit,
+		States:      r.States,
+		MinRestarts: r.MinRestarts,
+		Notify:      r.Notify,
+		Disabled:    r.Disabled,
+	}
+}
+
+// CreateRule handles POST /api/v1/alert-rules
+func (h *AlertHandler) CreateRule(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	var req AlertRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	in := req.input()
+	if req.OrgID != "" {
+		orgID, err := primitive.ObjectIDFromHex(req.OrgID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+			return
+		}
+		in.OrgID = orgID
+	}
+	rule, err := h.alertService.CreateRule(c.Request.Context(), userID, in)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, rule)
+}
+
+// ListRules handles GET /api/v1/alert-rules
+func (h *AlertHandler) ListRules(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	rules, err := h.alertService.ListRules(c.Request.Context(), userID)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, rules)
+}
+
+// UpdateRule handles PUT /api/v1/alert-rules/:id
+func (h *AlertHandler) UpdateRule(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid alert rule ID"})
+		return
+	}
+	var req AlertRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rule, err := h.alertService.UpdateRule(c.Request.Context(), userID, id, req.input())
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, rule)
+}
+
+// DeleteRule handles DELETE /api/v1/alert-rules/:id
+func (h *AlertHandler) DeleteRule(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid alert rule ID"})
+		return
+	}
+	if err := h.alertService.DeleteRule(c.Request.Context(), userID, id); err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Alert rule deleted successfully"})
+}
+
+// ListAlerts handles GET /api/v1/alerts
+// Alerts of the machines visible to the user, newest first; selector, group
+// and org filter the machines as for GET /api/v1/machines. Optional query:
+// status=firing|resolved, limit=<n> (default and maximum 500).
+func (h *AlertHandler) ListAlerts(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	status := c.Query("status")
+	if status != "" && status != models.AlertStatusFiring && status != models.AlertStatusResolved {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be firing or resolved"})
+		return
+	}
+	var limit int
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+			return
+		}
+		limit = n
+	}
+	filter, err := parseMachineFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	alerts, err := h.alertService.Alerts(c.Request.Context(), userID, filter, status, limit)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, alerts)
+}
+
+func (h *AlertHandler) writeError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, authz.ErrForbidden):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case err == services.ErrAlertRuleNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case err == services.ErrAlertRuleName, err == services.ErrAlertRuleKind,
+		err == services.ErrAlertRuleUnit, err == services.ErrAlertRuleState,
+		err == services.ErrAlertRuleNotify, errors.Is(err, services.ErrAlertRuleBadSelector):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/lute/api/authz"
+	"github.com/lute/api/models"
+	"github.com/lute/api/services"
+)
+
+// APITokenHandler handles personal access tokens used by automation.
+type APITokenHandler struct {
+	tokenService *services.APITokenService
+}
+
+// NewAPITokenHandler creates a new APITokenHandler.
+func NewAPITokenHandler(tokenService *services.APITokenService) *APITokenHandler {
+	return &APITokenHandler{tokenService: tokenService}
+}
+
+// CreateAPITokenRequest is the JSON body for creating a token.
+// Either ExpiresInDays or ExpiresAt may be set; neither means no expiry.
+type CreateAPITokenRequest struct {
+	Name          string     `json:"name" binding:"required"`
+	Scopes        []string   `json:"scopes" binding:"required"`
+	OrgID         string     `json:"org_id"`
+	ExpiresInDays int        `json:"expires_in_days"`
+	ExpiresAt     *time.Time `json:"expires_at"`
+}
+
+// CreateAPITokenResponse returns the token record plus its plaintext value (shown once).
+type CreateAPITokenResponse struct {
+	*models.APIToken
+	Token string `json:"token"`
+}
+
+// CreateToken handles POST /api/v1/tokens
+func (h *APITokenHandler) CreateToken(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	var req CreateAPITokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	in := services.CreateAPITokenInput{Name: req.Name, ExpiresAt: req.ExpiresAt}
+	for _, sc := range req.Scopes {
+		in.Scopes = append(in.Scopes, authz.Scope(sc))
+	}
+	if req.OrgID != "" {
+		orgID, err := primitive.ObjectIDFromHex(req.OrgID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+			return
+		}
+		in.OrgID = orgID
+	}
+	if req.ExpiresInDays > 0 && in.ExpiresAt == nil {
+		exp := time.Now().Add(time.Duration(req.ExpiresInDays) * 24 * time.Hour)
+		in.ExpiresAt = &exp
+	}
+
+	token, plaintext, err := h.tokenService.Create(c.Request.Context(), userID, in)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, CreateAPITokenResponse{APIToken: token, Token: plaintext})
+}
+
+// ListTokens handles GET /api/v1/tokens
+func (h *APITokenHandler) ListTokens(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	tokens, err := h.tokenService.List(c.Request.Context(), userID)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+// RevokeToken handles DELETE /api/v1/tokens/:id
+func (h *APITokenHandler) RevokeToken(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid token ID"})
+		return
+	}
+	if err := h.tokenService.Revoke(c.Request.Context(), userID, id); err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Token revoked successfully"})
+}
+
+func (h *APITokenHandler) writeError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, authz.ErrForbidden):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case err == services.ErrTokenNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidScope), err == services.ErrTokenNameRequired,
+		err == services.ErrTokenNoScopes, err == services.ErrTokenBadExpiry:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/lute/api/audit"
+	"github.com/lute/api/authz"
+	"github.com/lute/api/models"
+)
+
+const (
+	auditDefaultLimit = 100
+	auditMaxLimit     = 1000
+	auditExportLimit  = 10000
+)
+
+// AuditHandler serves the audit log.
+type AuditHandler struct {
+	recorder *audit.Recorder
+}
+
+// NewAuditHandler creates a new AuditHandler.
+func NewAuditHandler(recorder *audit.Recorder) *AuditHandler {
+	return &AuditHandler{recorder: recorder}
+}
+
+// ListEvents handles GET /api/v1/audit
+// Query: actor, action (exact or prefix ending in "."), target_type, target_id,
+// org, from, to (RFC3339), before (event ID cursor), limit (default 100, max 1000).
+func (h *AuditHandler) ListEvents(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	filter, err := parseAuditFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if filter.Limit == 0 {
+		filter.Limit = auditDefaultLimit
+	} else if filter.Limit > auditMaxLimit {
+		filter.Limit = auditMaxLimit
+	}
+	events, err := h.recorder.Query(c.Request.Context(), userID, filter)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	if events == nil {
+		events = []*models.AuditEvent{}
+	}
+	resp := gin.H{"events": events}
+	if int64(len(events)) == filter.Limit {
+		resp["next_before"] = events[len(events)-1].ID.Hex()
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// ExportEvents handles GET /api/v1/audit/export?format=csv|json
+// Accepts the same filters as ListEvents and returns up to 10000 events as a
+// download (json is newline-delimited, one event per line).
+func (h *AuditHandler) ExportEvents(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "json" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be csv or json"})
+		return
+	}
+	filter, err := parseAuditFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if filter.Limit == 0 || filter.Limit > auditExportLimit {
+		filter.Limit = auditExportLimit
+	}
+	events, err := h.recorder.Query(c.Request.Context(), userID, filter)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+
+	filename := "lute-audit-" + time.Now().UTC().Format("20060102-150405")
+	if format == "json" {
+		c.Header("Content-Disposition", "attachment; filename="+filename+".ndjson")
+		c.Header("Content-Type", "application/x-ndjson")
+		c.Status(http.StatusOK)
+		enc := json.NewEncoder(c.Writer)
+		for _, e := range events {
+			if err := enc.Encode(e); err != nil {
+				return
+			}
+		}
+		return
+	}
+
+	c.Header("Content-Disposition", "attachment; filename="+filename+".csv")
+	c.Header("Content-Type", "text/csv")
+	c.Status(http.StatusOK)
+	w := csv.NewWriter(c.Writer)
+	_ = w.Write([]string{"id", "at", "actor_type", "actor_id", "token_id", "action", "target_type", "target_id", "target_name",
+		"org_id", "request_id", "ip", "user_agent", "changes", "details"})
+	for _, e := range events {
+		changes, _ := jsonOrEmpty(e.Changes)
+		details, _ := jsonOrEmpty(e.Details)
+		_ = w.Write([]string{
+			e.ID.Hex(),
+			e.At.UTC().Format(time.RFC3339),
+			e.ActorType,
+			hexOrEmpty(e.ActorID),
+			hexOrEmpty(e.TokenID),
+			e.Action,
+			e.TargetType,
+			hexOrEmpty(e.TargetID),
+			e.TargetName,
+			hexOrEmpty(e.OrgID),
+			e.RequestID,
+			e.IP,
+			e.UserAgent,
+			changes,
+			details,
+		})
+	}
+	w.Flush()
+}
+
+func (h *AuditHandler) writeError(c *gin.Context, err error) {
+	if errors.Is(err, authz.ErrForbidden) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
+// parseAuditFilter reads the audit query parameters shared by list and export.
+func parseAuditFilter(c *gin.Context) (audit.Filter, error) {
+	f := audit.Filter{
+		Action:     c.Query("action"),
+		TargetType: c.Query("target_type"),
+	}
+	ids := []struct {
+		param string
+		dst   *primitive.ObjectID
+	}{
+		{"actor", &f.ActorID},
+		{"target_id", &f.TargetID},
+		{"org", &f.OrgID},
+		{"before", &f.Before},
+	}
+	for _, id := range ids {
+		if raw := c.Query(id.param); raw != "" {
+			oid, err := primitive.ObjectIDFromHex(raw)
+			if err != nil {
+				return f, fmt.Errorf("invalid %s", id.param)
+			}
+			*id.dst = oid
+		}
+	}
+	times := []struct {
+		param string
+		dst   *time.Time
+	}{
+		{"from", &f.From},
+		{"to", &f.To},
+	}
+	for _, t := range times {
+		if raw := c.Query(t.param); raw != "" {
+			parsed, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				return f, fmt.Errorf("invalid %s: expected RFC3339 time", t.param)
+			}
+			*t.dst = parsed
+		}
+	}
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || n < 1 {
+			return f, fmt.Errorf("invalid limit")
+		}
+		f.Limit = n
+	}
+	return f, nil
+}
+
+func hexOrEmpty(id primitive.ObjectID) string {
+	if id.IsZero() {
+		return ""
+	}
+	return id.Hex()
+}
+
+func jsonOrEmpty[T any](m map[string]T) (string, error) {
+	if len(m) == 0 {
+		return "", nil
+	}
+	b, err := json.Marshal(m)
+	return string(b), err
+}
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/lute/api/audit"
+	"github.com/lute/api/auth"
+	"github.com/lute/api/config"
+	"github.com/lute/api/repository"
+)
+
+// AuthHandler exposes the auth provider configuration and, for the local
+// provider, sign-up, login and logout.
+type AuthHandler struct {
+	cfg           *config.Config
+	authenticator auth.Authenticator
+	userRepo      *repository.UserRepository
+	audit         *audit.Recorder
+}
+
+// NewAuthHandler creates a new AuthHandler.
+func NewAuthHandler(cfg *config.Config, authenticator auth.Authenticator, userRepo *repository.UserRepository, recorder *audit.Recorder) *AuthHandler {
+	return &AuthHandler{cfg: cfg, authenticator: authenticator, userRepo: userRepo, audit: recorder}
+}
+
+// LoginRequest is the JSON body for local login
+type LoginRequest struct {
+	Email    string `json:"email" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// RegisterRequest is the JSON body for local sign-up
+type RegisterRequest struct {
+	Email       string `json:"email" binding:"required"`
+	Password    string `json:"password" binding:"required"`
+	DisplayName string `json:"display_name"`
+}
+
+// GetConfig handles GET /api/v1/auth/config (public) so the UI knows how to sign in.
+func (h *AuthHandler) GetConfig(c *gin.Context) {
+	resp := gin.H{"provider": h.authenticator.Name()}
+	switch h.authenticator.Name() {
+	case auth.ProviderOIDC:
+		resp["issuer"] = h.cfg.Auth.OIDC.Issuer
+		resp["client_id"] = h.cfg.Auth.OIDC.Audience
+	case auth.ProviderLocal:
+		resp["allow_signup"] = h.cfg.Auth.Local.AllowSignup
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// Register handles POST /api/v1/auth/register (local provider only)
+func (h *AuthHandler) Register(c *gin.Context) {
+	local, ok := h.local(c)
+	if !ok {
+		return
+	}
+	var req RegisterRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user, err := local.Register(c.Request.Context(), req.Email, req.Password, req.DisplayName)
+	if err != nil {
+		switch err {
+		case auth.ErrSignupDisabled:
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case auth.ErrEmailTaken:
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	c.JSON(http.StatusCreated, user)
+}
+
+// Login handles POST /api/v1/auth/login (local provider only).
+// Returns a session token to send as "Authorization: Bearer <token>".
+func (h *AuthHandler) Login(c *gin.Context) {
+	local, ok := h.local(c)
+	if !ok {
+		return
+	}
+	var req LoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	token, session, user, err := local.Login(c.Request.Context(), req.Email, req.Password)
+	if err != nil {
+		if err == auth.ErrInvalidCredentials {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx := audit.WithActor(c.Request.Context(), audit.Actor{Type: audit.ActorUser, UserID: user.ID})
+	h.audit.Record(ctx, audit.Entry{Action: audit.ActionAuthLogin, Target: audit.UserTarget(user)})
+	c.JSON(http.StatusOK, gin.H{
+		"token":      token,
+		"expires_at": session.ExpiresAt,
+		"user":       user,
+	})
+}
+
+// Logout handles POST /api/v1/auth/logout (local provider only, authenticated)
+func (h *AuthHandler) Logout(c *gin.Context) {
+	local, ok := h.local(c)
+	if !ok {
+		return
+	}
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if err := local.Logout(c.Request.Context(), token); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out"})
+}
+
+// Me handles GET /api/v1/auth/me (authenticated) and returns the current user.
+func (h *AuthHandler) Me(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	user, err := h.userRepo.GetByID(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+// local returns the local authenticator, or writes 404 when another provider is configured.
+func (h *AuthHandler) local(c *gin.Context) (*auth.LocalAuthenticator, bool) {
+	local, ok := h.authenticator.(*auth.LocalAuthenticator)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not available with the " + h.authenticator.Name() + " auth provider"})
+		return nil, false
+	}
+	return local, true
+}
@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/lute/api/authz"
+	"github.com/lute/api/models"
+	"github.com/lute/api/services"
+)
+
+// ConfigHandler handles the agent configs of machines and groups.
+type ConfigHandler struct {
+	configService *services.ConfigService
+}
+
+// NewConfigHandler creates a new ConfigHandler.
+func NewConfigHandler(configService *services.ConfigService) *ConfigHandler {
+	return &ConfigHandler{configService: configService}
+}
+
+// GetMachineConfig handles GET /api/v1/machines/:id/config
+// The machine's own config, the settings in effect with its groups'
+// configs, and the version its agent acknowledged.
+func (h *ConfigHandler) GetMachineConfig(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid machine ID"})
+		return
+	}
+	view, err := h.configService.GetMachine(c.Request.Context(), userID, id)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, view)
+}
+
+// SetMachineConfig handles PUT /api/v1/machines/:id/config
+// Replaces the machine's own config, e.g.
+//
+//	{"log_level": "debug", "collectors": ["cpu", "memory"],
+//	 "heartbeat_interval": 60, "inventory_interval": 3600}
+//
+// Intervals are in seconds. Omitted settings come from the machine's
+// groups' configs, else from the agent's local configuration.
+func (h *ConfigHandler) SetMachineConfig(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid machine ID"})
+		return
+	}
+	var settings models.AgentSettings
+	if err := c.ShouldBindJSON(&settings); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	view, err := h.configService.SetMachine(c.Request.Context(), userID, id, settings)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, view)
+}
+
+// DeleteMachineConfig handles DELETE /api/v1/machines/:id/config
+func (h *ConfigHandler) DeleteMachineConfig(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid machine ID"})
+		return
+	}
+	if err := h.configService.DeleteMachine(c.Request.Context(), userID, id); err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Config deleted successfully"})
+}
+
+// GetGroupConfig handles GET /api/v1/groups/:id/config
+func (h *ConfigHandler) GetGroupConfig(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group ID"})
+		return
+	}
+	cfg, err := h.configService.GetGroup(c.Request.Context(), userID, id)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, cfg)
+}
+
+// SetGroupConfig handles PUT /api/v1/groups/:id/config
+// Replaces the group's config, with the body of PUT
+// /api/v1/machines/:id/config. It applies to every machine of the group;
+// a machine in several groups gets the settings of the newest config that
+// sets them.
+func (h *ConfigHandler) SetGroupConfig(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group ID"})
+		return
+	}
+	var settings models.AgentSettings
+	if err := c.ShouldBindJSON(&settings); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	cfg, err := h.configService.SetGroup(c.Request.Context(), userID, id, settings)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, cfg)
+}
+
+// DeleteGroupConfig handles DELETE /api/v1/groups/:id/config
+func (h *ConfigHandler) DeleteGroupConfig(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group ID"})
+		return
+	}
+	if err := h.configService.DeleteGroup(c.Request.Context(), userID, id); err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Config deleted successfully"})
+}
+
+func (h *ConfigHandler) writeError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, authz.ErrForbidden), err == services.ErrGroupUnauthorized:
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case err == services.ErrConfigNotFound, err == services.ErrGroupNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case err.Error() == "machine not found":
+		c.JSON(http.StatusNotFound, gin.H{"error": "machine not found"})
+	case errors.Is(err, services.ErrInvalidConfig):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -1,12 +1,14 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 
+	"github.com/lute/api/authz"
 	"github.com/lute/api/config"
 	"github.com/lute/api/models"
 	"github.com/lute/api/repository"
@@ -41,6 +43,7 @@ func (h *DashboardHandler) GetConfig(c *gin.Context) {
 
 // GetStats handles GET /api/v1/dashboard/stats (authenticated).
 // Returns { total, alive, dead, public } for the current user.
+// Optional query: selector=<label selector>, group=<group id> narrow total/alive/dead.
 func (h *DashboardHandler) GetStats(c *gin.Context) {
 	userID, exists := c.Get("user_id")
 	if !exists {
@@ -53,9 +56,19 @@ func (h *DashboardHandler) GetStats(c *gin.Context) {
 		return
 	}
 
+	filter, err := parseMachineFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	ctx := c.Request.Context()
-	machines, err := h.machineService.GetByUserID(ctx, userIDObj)
+	machines, err := h.machineService.GetByUserIDFiltered(ctx, userIDObj, filter)
 	if err != nil {
+		if errors.Is(err, authz.ErrForbidden) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -96,11 +109,13 @@ type ChartPoint struct {
 }
 
 // ChartResponse is the dashboard uptime API response (chart-ready, backend-bucketed).
+// Resolution is the data the points were computed from: "raw" snapshots or "1m"/"1h" rollups.
 type ChartResponse struct {
 	Points        []ChartPoint `json:"points"`
 	PeriodStartMs int64        `json:"period_start_ms"`
 	PeriodEndMs   int64        `json:"period_end_ms"`
-	DiskYDomain   [2]float64  `json:"disk_y_domain"`
+	DiskYDomain   [2]float64   `json:"disk_y_domain"`
+	Resolution    string       `json:"resolution"`
 }
 
 // targetChartPoints is the desired number of data points for any period.
@@ -134,88 +149,37 @@ func bucketDuration(period string, snapshotInterval time.Duration) time.Duration
 	return bucket
 }
 
-func buildChartPerMachine(snapshots []*models.MachineSnapshot, periodStart, periodEnd time.Time, bucketDur time.Duration) (points []ChartPoint, diskMax float64) {
-	bucketMs := bucketDur.Milliseconds()
-	periodStartMs := periodStart.UnixMilli()
-	periodEndMs := periodEnd.UnixMilli()
-
-	// Group by bucket, keep latest snapshot per bucket (handles ticker jitter).
-	type bucketVal struct {
-		at                      time.Time
-		cpu, mem, diskUsed, diskTotal float64
-	}
-	byBucket := make(map[int64]*bucketVal)
-	for _, s := range snapshots {
-		tMs := s.At.UnixMilli()
-		b := (tMs / bucketMs) * bucketMs
-		existing, ok := byBucket[b]
-		if !ok || s.At.After(existing.at) {
-			byBucket[b] = &bucketVal{
-				at:        s.At,
-				cpu:       floatFrom(s.Metrics, "cpu_load"),
-				mem:       floatFrom(s.Metrics, "mem_usage_mb"),
-				diskUsed:  floatFrom(s.Metrics, "disk_used_gb"),
-				diskTotal: floatFrom(s.Metrics, "disk_total_gb"),
-			}
-		}
-	}
-
-	// Emit one point per bucket. Null metrics = machine was down (gap).
-	diskMax = 1
-	for b := periodStartMs; b <= periodEndMs; b += bucketMs {
-		p := ChartPoint{T: b}
-		if v, ok := byBucket[b]; ok {
-			p.CpuLoad = ptrFloat(roundMetric(v.cpu))
-			p.MemUsageMb = ptrFloat(roundMetric(v.mem))
-			p.DiskUsedGb = ptrFloat(roundMetric(v.diskUsed))
-			p.DiskTotalGb = ptrFloat(roundMetric(v.diskTotal))
-			if v.diskTotal > diskMax {
-				diskMax = v.diskTotal
-			}
-		}
-		points = append(points, p)
+// chartResolution picks the data a chart reading back to since is computed
+// from, and its step: the coarsest rollups whose period fits in a bucket,
+// or coarser ones when the finer data no longer reaches back to since.
+func chartResolution(bucket time.Duration, since time.Time, cfg config.MetricsConfig) (string, time.Duration) {
+	age := time.Since(since)
+	switch {
+	case bucket >= time.Hour || age > cfg.MinuteRetention:
+		return repository.ResolutionHour, time.Hour
+	case bucket >= time.Minute || age > cfg.RawRetention:
+		return repository.ResolutionMinute, time.Minute
 	}
-	return points, diskMax
+	return repository.ResolutionRaw, 0
 }
 
-func buildChartAggregated(snapshots []*models.MachineSnapshot, periodStart, periodEnd time.Time, bucketDur time.Duration) (points []ChartPoint, diskMax float64) {
+// buildChart emits one point per bucket from periodStart to periodEnd with
+// the average of each metric. Null metrics = no machine was up (gap).
+func buildChart(buckets []*models.SnapshotRollup, periodStart, periodEnd time.Time, bucketDur time.Duration) (points []ChartPoint, diskMax float64) {
 	bucketMs := bucketDur.Milliseconds()
-	periodStartMs := periodStart.UnixMilli()
-	periodEndMs := periodEnd.UnixMilli()
-
-	type agg struct {
-		n            int
-		sumCpu       float64
-		sumMem       float64
-		sumDiskUsed  float64
-		sumDiskTotal float64
-	}
-	byBucket := make(map[int64]*agg)
-	for _, s := range snapshots {
-		tMs := s.At.UnixMilli()
-		b := (tMs / bucketMs) * bucketMs
-		a, ok := byBucket[b]
-		if !ok {
-			a = &agg{}
-			byBucket[b] = a
-		}
-		a.n++
-		a.sumCpu += floatFrom(s.Metrics, "cpu_load")
-		a.sumMem += floatFrom(s.Metrics, "mem_usage_mb")
-		a.sumDiskUsed += floatFrom(s.Metrics, "disk_used_gb")
-		a.sumDiskTotal += floatFrom(s.Metrics, "disk_total_gb")
+	byBucket := make(map[int64]*models.SnapshotRollup, len(buckets))
+	for _, b := range buckets {
+		byBucket[b.At.UnixMilli()] = b
 	}
 
-	// Emit one point per bucket. Null metrics = all machines were down (gap).
 	diskMax = 1
-	for b := periodStartMs; b <= periodEndMs; b += bucketMs {
+	for b := periodStart.UnixMilli(); b <= periodEnd.UnixMilli(); b += bucketMs {
 		p := ChartPoint{T: b}
-		if a, ok := byBucket[b]; ok && a.n > 0 {
-			n := float64(a.n)
-			p.CpuLoad = ptrFloat(roundMetric(a.sumCpu / n))
-			p.MemUsageMb = ptrFloat(roundMetric(a.sumMem / n))
-			p.DiskUsedGb = ptrFloat(roundMetric(a.sumDiskUsed / n))
-			total := a.sumDiskTotal / n
+		if v, ok := byBucket[b]; ok && v.Count > 0 {
+			p.CpuLoad = ptrFloat(roundMetric(v.Metrics["cpu_load"].Avg))
+			p.MemUsageMb = ptrFloat(roundMetric(v.Metrics["mem_usage_mb"].Avg))
+			p.DiskUsedGb = ptrFloat(roundMetric(v.Metrics["disk_used_gb"].Avg))
+			total := v.Metrics["disk_total_gb"].Avg
 			p.DiskTotalGb = ptrFloat(roundMetric(total))
 			if total > diskMax {
 				diskMax = total
@@ -229,8 +193,10 @@ func buildChartAggregated(snapshots []*models.MachineSnapshot, periodStart, peri
 func ptrFloat(f float64) *float64 { return &f }
 
 // GetUptime handles GET /api/v1/dashboard/uptime?period=7d (optional: machine_id=hex) (authenticated).
-// If machine_id is set: returns per-machine points (at, status, uptime_pct 0|100, metrics) after validating ownership.
-// If machine_id is absent: returns aggregated points across the user's machines.
+// If machine_id is set: returns per-machine points (at, status, uptime_pct 0|100, metrics) after checking read access.
+// If machine_id is absent: returns aggregated points across the user's machines,
+// optionally narrowed by selector=<label selector> and group=<group id>.
+// Points of longer periods are computed from the per-minute or per-hour rollups.
 func (h *DashboardHandler) GetUptime(c *gin.Context) {
 	userID, exists := c.Get("user_id")
 	if !exists {
@@ -246,7 +212,6 @@ func (h *DashboardHandler) GetUptime(c *gin.Context) {
 	period := c.DefaultQuery("period", "7d")
 	now := time.Now()
 	bucketDur := bucketDuration(period, h.cfg.Metrics.SnapshotInterval)
-	bucketMs := bucketDur.Milliseconds()
 
 	var rawStart time.Time
 	switch period {
@@ -261,6 +226,12 @@ func (h *DashboardHandler) GetUptime(c *gin.Context) {
 	default:
 		rawStart = now.Add(-7 * 24 * time.Hour)
 	}
+	// Buckets are whole multiples of the rollup period so each rollup falls in one bucket.
+	resolution, step := chartResolution(bucketDur, rawStart, h.cfg.Metrics)
+	if step > 0 && bucketDur%step != 0 {
+		bucketDur = (bucketDur/step + 1) * step
+	}
+	bucketMs := bucketDur.Milliseconds()
 	// Align periodStart to the bucket boundary so the first bucket in the loop
 	// always has data when the machine was alive, avoiding a phantom leading gap.
 	periodStart := time.UnixMilli((rawStart.UnixMilli() / bucketMs) * bucketMs)
@@ -269,41 +240,46 @@ func (h *DashboardHandler) GetUptime(c *gin.Context) {
 	ctx := c.Request.Context()
 	machineIDHex := c.Query("machine_id")
 	if machineIDHex != "" {
-		// Per-machine: validate ownership, bucket snapshots, return chart response
+		// Per-machine: validate ownership, bucket snapshots or rollups, return chart response
 		machineID, err := primitive.ObjectIDFromHex(machineIDHex)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid machine ID"})
 			return
 		}
-		machine, err := h.machineService.GetByID(ctx, machineID)
-		if err != nil {
+		if _, err := h.machineService.GetForUser(ctx, machineID, userIDObj, authz.ActionMachineRead); err != nil {
 			c.JSON(http.StatusNotFound, gin.H{"error": "machine not found"})
 			return
 		}
-		if machine.UserID != userIDObj {
-			c.JSON(http.StatusNotFound, gin.H{"error": "machine not found"})
-			return
-		}
-		snapshots, err := h.snapshotRepo.GetByMachineID(ctx, machineID, periodStart)
+		buckets, err := h.snapshotRepo.Chart(ctx, resolution, []primitive.ObjectID{machineID}, periodStart, bucketDur)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
-		points, diskMax := buildChartPerMachine(snapshots, periodStart, periodEnd, bucketDur)
+		points, diskMax := buildChart(buckets, periodStart, periodEnd, bucketDur)
 		resp := ChartResponse{
 			Points:        points,
 			PeriodStartMs: periodStart.UnixMilli(),
 			PeriodEndMs:   periodEnd.UnixMilli(),
 			DiskYDomain:   [2]float64{0, diskMax},
+			Resolution:    resolution,
 		}
 		c.Header("Cache-Control", "no-store")
 		c.JSON(http.StatusOK, resp)
 		return
 	}
 
-	// Aggregated: all user's machines
-	machines, err := h.machineService.GetByUserID(ctx, userIDObj)
+	// Aggregated: all user's machines matching the optional selector/group
+	filter, err := parseMachineFilter(c)
 	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	machines, err := h.machineService.GetByUserIDFiltered(ctx, userIDObj, filter)
+	if err != nil {
+		if errors.Is(err, authz.ErrForbidden) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -314,6 +290,7 @@ func (h *DashboardHandler) GetUptime(c *gin.Context) {
 			PeriodStartMs: periodStart.UnixMilli(),
 			PeriodEndMs:   periodEnd.UnixMilli(),
 			DiskYDomain:   [2]float64{0, 1},
+			Resolution:    resolution,
 		})
 		return
 	}
@@ -321,41 +298,23 @@ func (h *DashboardHandler) GetUptime(c *gin.Context) {
 	for _, m := range machines {
 		machineIDs = append(machineIDs, m.ID)
 	}
-	snapshots, err := h.snapshotRepo.GetByMachineIDs(ctx, machineIDs, periodStart)
+	buckets, err := h.snapshotRepo.Chart(ctx, resolution, machineIDs, periodStart, bucketDur)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	points, diskMax := buildChartAggregated(snapshots, periodStart, periodEnd, bucketDur)
+	points, diskMax := buildChart(buckets, periodStart, periodEnd, bucketDur)
 	resp := ChartResponse{
 		Points:        points,
 		PeriodStartMs: periodStart.UnixMilli(),
 		PeriodEndMs:   periodEnd.UnixMilli(),
 		DiskYDomain:   [2]float64{0, diskMax},
+		Resolution:    resolution,
 	}
 	c.Header("Cache-Control", "no-store")
 	c.JSON(http.StatusOK, resp)
 }
 
-func floatFrom(m map[string]interface{}, key string) float64 {
-	if m == nil {
-		return 0
-	}
-	v, ok := m[key]
-	if !ok || v == nil {
-		return 0
-	}
-	switch x := v.(type) {
-	case float64:
-		return x
-	case int:
-		return float64(x)
-	case int64:
-		return float64(x)
-	}
-	return 0
-}
-
 func roundMetric(v float64) float64 {
 	return float64(int(v*1000+0.5)) / 1000
 }
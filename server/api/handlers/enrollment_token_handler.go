@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/lute/api/authz"
+	"github.com/lute/api/labels"
+	"github.com/lute/api/models"
+	"github.com/lute/api/services"
+)
+
+// EnrollmentTokenHandler handles reusable tokens agents register machines with.
+type EnrollmentTokenHandler struct {
+	enrollmentService *services.EnrollmentTokenService
+}
+
+// NewEnrollmentTokenHandler creates a new EnrollmentTokenHandler.
+func NewEnrollmentTokenHandler(enrollmentService *services.EnrollmentTokenService) *EnrollmentTokenHandler {
+	return &EnrollmentTokenHandler{enrollmentService: enrollmentService}
+}
+
+// CreateEnrollmentTokenRequest is the JSON body for creating an enrollment token.
+// Either ExpiresInHours or ExpiresAt may be set; neither means no expiry.
+// MaxUses 0 means unlimited.
+type CreateEnrollmentTokenRequest struct {
+	Name           string            `json:"name" binding:"required"`
+	OrgID          string            `json:"org_id"`
+	Labels         map[string]string `json:"labels"`
+	GroupID        string            `json:"group_id"`
+	MaxUses        int               `json:"max_uses"`
+	ExpiresInHours int               `json:"expires_in_hours"`
+	ExpiresAt      *time.Time        `json:"expires_at"`
+}
+
+// CreateEnrollmentTokenResponse returns the token record plus its plaintext value (shown once).
+type CreateEnrollmentTokenResponse struct {
+	*models.EnrollmentToken
+	Token string `json:"token"`
+}
+
+// CreateToken handles POST /api/v1/enrollment-tokens
+func (h *EnrollmentTokenHandler) CreateToken(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	var req CreateEnrollmentTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	in := services.CreateEnrollmentTokenInput{
+		Name:      req.Name,
+		Labels:    req.Labels,
+		MaxUses:   req.MaxUses,
+		ExpiresAt: req.ExpiresAt,
+	}
+	if req.OrgID != "" {
+		orgID, err := primitive.ObjectIDFromHex(req.OrgID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+			return
+		}
+		in.OrgID = orgID
+	}
+	if req.GroupID != "" {
+		groupID, err := primitive.ObjectIDFromHex(req.GroupID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group ID"})
+			return
+		}
+		in.GroupID = groupID
+	}
+	if req.ExpiresInHours > 0 && in.ExpiresAt == nil {
+		exp := time.Now().Add(time.Duration(req.ExpiresInHours) * time.Hour)
+		in.ExpiresAt = &exp
+	}
+
+	token, plaintext, err := h.enrollmentService.Create(c.Request.Context(), userID, in)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, CreateEnrollmentTokenResponse{EnrollmentToken: token, Token: plaintext})
+}
+
+// ListTokens handles GET /api/v1/enrollment-tokens
+func (h *EnrollmentTokenHandler) ListTokens(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	tokens, err := h.enrollmentService.List(c.Request.Context(), userID)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+// RevokeToken handles DELETE /api/v1/enrollment-tokens/:id
+func (h *EnrollmentTokenHandler) RevokeToken(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid token ID"})
+		return
+	}
+	if err := h.enrollmentService.Revoke(c.Request.Context(), userID, id); err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Enrollment token revoked successfully"})
+}
+
+func (h *EnrollmentTokenHandler) writeError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, authz.ErrForbidden), err == services.ErrGroupUnauthorized:
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case err == services.ErrEnrollmentNotFound, err == services.ErrGroupNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, labels.ErrInvalid), err == services.ErrEnrollmentNameRequired,
+		err == services.ErrEnrollmentBadMaxUses, err == services.ErrEnrollmentBadExpiry,
+		err == services.ErrEnrollmentGroupOrg:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/lute/api/authz"
+	"github.com/lute/api/services"
+)
+
+// FileEventHandler serves the file integrity events agents report and their
+// acknowledgement.
+type FileEventHandler struct {
+	fileEventService *services.FileEventService
+}
+
+// NewFileEventHandler creates a new FileEventHandler.
+func NewFileEventHandler(fileEventService *services.FileEventService) *FileEventHandler {
+	return &FileEventHandler{fileEventService: fileEventService}
+}
+
+// parseFileEventQuery reads the optional acknowledged=<true|false>, path and
+// limit=<n> (default and maximum 500) query parameters.
+func parseFileEventQuery(c *gin.Context) (services.FileEventQuery, error) {
+	q := services.FileEventQuery{Path: c.Query("path")}
+	if raw := c.Query("acknowledged"); raw != "" {
+		acked, err := strconv.ParseBool(raw)
+		if err != nil {
+			return q, errors.New("acknowledged must be true or false")
+		}
+		q.Acknowledged = &acked
+	}
+	if raw := c.Query("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit <= 0 {
+			return q, errors.New("limit must be a positive integer")
+		}
+		q.Limit = limit
+	}
+	return q, nil
+}
+
+// acknowledgeRequest is the optional body of the acknowledge endpoints.
+type acknowledgeRequest struct {
+	Note string `json:"note"`
+	Path string `json:"path"` // per-machine endpoint only: acknowledge one path
+}
+
+func bindAcknowledgeRequest(c *gin.Context) (acknowledgeRequest, bool) {
+	var req acknowledgeRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return req, false
+	}
+	return req, true
+}
+
+// ListMachineFileEvents handles GET /api/v1/machines/:id/file-events
+// Optional query: acknowledged, path, limit (see parseFileEventQuery).
+func (h *FileEventHandler) ListMachineFileEvents(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid machine ID"})
+		return
+	}
+	q, err := parseFileEventQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	events, err := h.fileEventService.ForMachine(c.Request.Context(), id, userID, q)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, events)
+}
+
+// AcknowledgeMachineFileEvents handles POST /api/v1/machines/:id/file-events/ack
+// Optional body: {"note": "...", "path": "/etc/passwd"}; without a path every
+// unacknowledged event of the machine is acknowledged.
+func (h *FileEventHandler) AcknowledgeMachineFileEvents(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid machine ID"})
+		return
+	}
+	req, ok := bindAcknowledgeRequest(c)
+	if !ok {
+		return
+	}
+	count, err := h.fileEventService.AcknowledgeMachine(c.Request.Context(), id, userID, req.Path, req.Note)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"acknowledged": count})
+}
+
+// ListFileEvents handles GET /api/v1/file-events
+// Fleet-wide view over the machines visible to the user; selector, group and
+// org filter the machines as for GET /api/v1/machines. Optional query:
+// acknowledged, path, limit (see parseFileEventQuery).
+func (h *FileEventHandler) ListFileEvents(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	q, err := parseFileEventQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	filter, err := parseMachineFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	events, err := h.fileEventService.Fleet(c.Request.Context(), userID, filter, q)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, events)
+}
+
+// AcknowledgeFileEvent handles POST /api/v1/file-events/:id/ack
+// Optional body: {"note": "..."}.
+func (h *FileEventHandler) AcknowledgeFileEvent(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file event ID"})
+		return
+	}
+	req, ok := bindAcknowledgeRequest(c)
+	if !ok {
+		return
+	}
+	event, err := h.fileEventService.Acknowledge(c.Request.Context(), id, userID, req.Note)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, event)
+}
+
+func (h *FileEventHandler) writeError(c *gin.Context, err error) {
+	switch {
+	case err == services.ErrFileEventNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case err.Error() == "machine not found":
+		c.JSON(http.StatusNotFound, gin.H{"error": "machine not found"})
+	case errors.Is(err, authz.ErrForbidden):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
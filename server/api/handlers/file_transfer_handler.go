@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/lute/api/authz"
+	"github.com/lute/api/models"
+	"github.com/lute/api/services"
+)
+
+// fileDownloadWait is how long GET /machines/:id/files waits for the agent
+// before answering with the transfer to poll instead of the file.
+const fileDownloadWait = 30 * time.Second
+
+// FileTransferHandler handles copying files to and from machines.
+type FileTransferHandler struct {
+	fileTransferService *services.FileTransferService
+}
+
+// NewFileTransferHandler creates a new FileTransferHandler.
+func NewFileTransferHandler(fileTransferService *services.FileTransferService) *FileTransferHandler {
+	return &FileTransferHandler{fileTransferService: fileTransferService}
+}
+
+// UploadFile handles POST /api/v1/machines/:id/files?path=<path>[&mode=0644]
+// The request body is the file. It is copied to the machine once its agent
+// is connected; the response is the transfer to follow (202).
+func (h *FileTransferHandler) UploadFile(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid machine ID"})
+		return
+	}
+	var mode uint64
+	if raw := c.Query("mode"); raw != "" {
+		mode, err = strconv.ParseUint(raw, 8, 32)
+		if err != nil {
+			h.writeError(c, services.ErrInvalidFileMode)
+			return
+		}
+	}
+	// Large files take longer than the server's read timeout.
+	_ = http.NewResponseController(c.Writer).SetReadDeadline(time.Time{})
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.fileTransferService.MaxSize()+1)
+
+	t, err := h.fileTransferService.Upload(c.Request.Context(), id, userID, c.Query("path"), uint32(mode), c.Request.Body)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			err = services.ErrFileTooLarge
+		}
+		h.writeError(c, err)
+		return
+	}
+	c.Header("Location", transferLocation(t))
+	c.JSON(http.StatusAccepted, t)
+}
+
+// DownloadFile handles GET /api/v1/machines/:id/files?path=<path>
+// Copies the file from the machine and responds with it. When the agent is
+// offline or the copy takes longer than fileDownloadWait the response is the
+// transfer to follow instead (202); its content is then read from
+// GET /api/v1/machines/:id/file-transfers/:transferId/content.
+func (h *FileTransferHandler) DownloadFile(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid machine ID"})
+		return
+	}
+	t, err := h.fileTransferService.Download(c.Request.Context(), id, userID, c.Query("path"))
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	t, err = h.fileTransferService.Wait(c.Request.Context(), t, fileDownloadWait)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	switch t.Status {
+	case models.FileTransferCompleted:
+		h.serveContent(c, t)
+	case models.FileTransferFailed:
+		c.JSON(http.StatusBadGateway, gin.H{"error": t.Error, "transfer": t})
+	default:
+		c.Header("Location", transferLocation(t))
+		c.JSON(http.StatusAccepted, t)
+	}
+}
+
+// ListFileTransfers handles GET /api/v1/machines/:id/file-transfers
+// Optional query: limit=<n> (default and maximum 500). Newest first.
+func (h *FileTransferHandler) ListFileTransfers(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid machine ID"})
+		return
+	}
+	var limit int
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+			return
+		}
+		limit = n
+	}
+	transfers, err := h.fileTransferService.List(c.Request.Context(), id, userID, limit)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	if transfers == nil {
+		transfers = []*models.FileTransfer{}
+	}
+	c.JSON(http.StatusOK, transfers)
+}
+
+// GetFileTransfer handles GET /api/v1/machines/:id/file-transfers/:transferId
+func (h *FileTransferHandler) GetFileTransfer(c *gin.Context) {
+	t, ok := h.transfer(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, t)
+}
+
+// GetFileTransferContent handles GET /api/v1/machines/:id/file-transfers/:transferId/content
+// Responds with the file of a completed transfer, in either direction.
+func (h *FileTransferHandler) GetFileTransferContent(c *gin.Context) {
+	t, ok := h.transfer(c)
+	if !ok {
+		return
+	}
+	h.serveContent(c, t)
+}
+
+func (h *FileTransferHandler) transfer(c *gin.Context) (*models.FileTransfer, bool) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return nil, false
+	}
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid machine ID"})
+		return nil, false
+	}
+	transferID, err := primitive.ObjectIDFromHex(c.Param("transferId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid transfer ID"})
+		return nil, false
+	}
+	t, err := h.fileTransferService.Get(c.Request.Context(), id, transferID, userID)
+	if err != nil {
+		h.writeError(c, err)
+		return nil, false
+	}
+	return t, true
+}
+
+func (h *FileTransferHandler) serveContent(c *gin.Context, t *models.FileTransfer) {
+	if err := h.fileTransferService.CheckContent(t); err != nil {
+		h.writeError(c, err)
+		return
+	}
+	// Large files take longer than the server's write timeout.
+	_ = http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+	c.Header("Content-Type", "application/octet-stream")
+	c.Header("Content-Disposition", "attachment; filename="+strconv.Quote(path.Base(strings.ReplaceAll(t.Path, `\`, "/"))))
+	c.Header("Content-Length", strconv.FormatInt(t.Size, 10))
+	c.Header("X-File-SHA256", t.SHA256)
+	c.Status(http.StatusOK)
+	if err := h.fileTransferService.WriteContent(c.Request.Context(), t, c.Writer); err != nil {
+		// The status is sent; a short body is all the client sees.
+		log.Printf("Files: failed to send content of transfer %s: %v", t.ID.Hex(), err)
+	}
+}
+
+func transferLocation(t *models.FileTransfer) string {
+	return "/api/v1/machines/" + t.MachineID.Hex() + "/file-transfers/" + t.ID.Hex()
+}
+
+func (h *FileTransferHandler) writeError(c *gin.Context, err error) {
+	switch {
+	case err == services.ErrInvalidFilePath, err == services.ErrInvalidFileMode:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrFileTooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+	case err == services.ErrFileTransferUnsupported, err == services.ErrFileNotReady:
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case err == services.ErrFileContentExpired:
+		c.JSON(http.StatusGone, gin.H{"error": err.Error()})
+	case err == services.ErrFileTransferNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case err.Error() == "machine not found":
+		c.JSON(http.StatusNotFound, gin.H{"error": "machine not found"})
+	case errors.Is(err, authz.ErrForbidden):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/lute/api/authz"
+	"github.com/lute/api/models"
+	"github.com/lute/api/services"
+)
+
+// GroupHandler handles user-defined machine groups.
+type GroupHandler struct {
+	groupService *services.GroupService
+}
+
+// NewGroupHandler creates a new GroupHandler.
+func NewGroupHandler(groupService *services.GroupService) *GroupHandler {
+	return &GroupHandler{groupService: groupService}
+}
+
+// GroupMembersRequest is the JSON body for adding/removing group members
+type GroupMembersRequest struct {
+	MachineIDs []string `json:"machine_ids" binding:"required"`
+}
+
+// CreateGroup handles POST /api/v1/groups
+func (h *GroupHandler) CreateGroup(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	var group models.MachineGroup
+	if err := c.ShouldBindJSON(&group); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	created, err := h.groupService.Create(c.Request.Context(), userID, &group)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, created)
+}
+
+// ListGroups handles GET /api/v1/groups
+func (h *GroupHandler) ListGroups(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	groups, err := h.groupService.List(c.Request.Context(), userID)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, groups)
+}
+
+// GetGroup handles GET /api/v1/groups/:id (group plus its member machines)
+func (h *GroupHandler) GetGroup(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group ID"})
+		return
+	}
+	ctx := c.Request.Context()
+	group, err := h.groupService.Get(ctx, id, userID)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	machines, err := h.groupService.Members(ctx, id, userID)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"group":    group,
+		"machines": machines,
+	})
+}
+
+// UpdateGroup handles PUT /api/v1/groups/:id
+func (h *GroupHandler) UpdateGroup(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group ID"})
+		return
+	}
+	var group models.MachineGroup
+	if err := c.ShouldBindJSON(&group); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	updated, err := h.groupService.Update(c.Request.Context(), id, userID, &group)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, updated)
+}
+
+// DeleteGroup handles DELETE /api/v1/groups/:id
+func (h *GroupHandler) DeleteGroup(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group ID"})
+		return
+	}
+	if err := h.groupService.Delete(c.Request.Context(), id, userID); err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Group deleted successfully"})
+}
+
+// AddMembers handles POST /api/v1/groups/:id/machines
+func (h *GroupHandler) AddMembers(c *gin.Context) {
+	h.changeMembers(c, h.groupService.AddMachines)
+}
+
+// RemoveMembers handles DELETE /api/v1/groups/:id/machines
+func (h *GroupHandler) RemoveMembers(c *gin.Context) {
+	h.changeMembers(c, h.groupService.RemoveMachines)
+}
+
+func (h *GroupHandler) changeMembers(c *gin.Context, apply func(ctx context.Context, id, userID primitive.ObjectID, machineIDs []primitive.ObjectID) error) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group ID"})
+		return
+	}
+	var req GroupMembersRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	machineIDs := make([]primitive.ObjectID, 0, len(req.MachineIDs))
+	for _, raw := range req.MachineIDs {
+		mid, err := primitive.ObjectIDFromHex(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid machine ID: " + raw})
+			return
+		}
+		machineIDs = append(machineIDs, mid)
+	}
+	if err := apply(c.Request.Context(), id, userID, machineIDs); err != nil {
+		h.writeError(c, err)
+		return
+	}
+	machines, err := h.groupService.Members(c.Request.Context(), id, userID)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"machines": machines})
+}
+
+func (h *GroupHandler) writeError(c *gin.Context, err error) {
+	switch {
+	case err == services.ErrGroupNotFound || err.Error() == "machine not found":
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case err == services.ErrGroupUnauthorized || errors.Is(err, authz.ErrForbidden):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case err == services.ErrGroupNameTaken:
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case err == services.ErrGroupOtherOrg || err.Error() == "group name is required":
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/lute/api/labels"
+	"github.com/lute/api/services"
+)
+
+// currentUserID returns the authenticated user's ID from the context (set by
+// auth middleware). On failure it writes the error response and returns false.
+func currentUserID(c *gin.Context) (primitive.ObjectID, bool) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return primitive.NilObjectID, false
+	}
+	userIDObj, err := primitive.ObjectIDFromHex(userID.(string))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return primitive.NilObjectID, false
+	}
+	return userIDObj, true
+}
+
+// parseMachineFilter reads the optional ?selector= (label selector),
+// ?group= (group ID) and ?org= (organization ID) query parameters.
+func parseMachineFilter(c *gin.Context) (services.MachineFilter, error) {
+	var filter services.MachineFilter
+	if raw := c.Query("selector"); raw != "" {
+		sel, err := labels.ParseSelector(raw)
+		if err != nil {
+			return filter, err
+		}
+		filter.Selector = sel
+	}
+	if raw := c.Query("group"); raw != "" {
+		gid, err := primitive.ObjectIDFromHex(raw)
+		if err != nil {
+			return filter, err
+		}
+		filter.GroupID = gid
+	}
+	if raw := c.Query("org"); raw != "" {
+		oid, err := primitive.ObjectIDFromHex(raw)
+		if err != nil {
+			return filter, err
+		}
+		filter.OrgID = oid
+	}
+	return filter, nil
+}
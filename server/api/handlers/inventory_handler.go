@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/lute/api/authz"
+	"github.com/lute/api/pkgversion"
+	"github.com/lute/api/services"
+)
+
+// InventoryHandler serves machine inventories and fleet-wide package queries.
+type InventoryHandler struct {
+	inventoryService *services.InventoryService
+}
+
+// NewInventoryHandler creates a new InventoryHandler.
+func NewInventoryHandler(inventoryService *services.InventoryService) *InventoryHandler {
+	return &InventoryHandler{inventoryService: inventoryService}
+}
+
+// GetInventory handles GET /api/v1/machines/:id/inventory
+func (h *InventoryHandler) GetInventory(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid machine ID"})
+		return
+	}
+	inv, err := h.inventoryService.Get(c.Request.Context(), id, userID)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, inv)
+}
+
+// GetInventoryHistory handles GET /api/v1/machines/:id/inventory/history
+// Optional query: limit=<n> (default and maximum 500).
+func (h *InventoryHandler) GetInventoryHistory(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid machine ID"})
+		return
+	}
+	limit := 0
+	if raw := c.Query("limit"); raw != "" {
+		if limit, err = strconv.Atoi(raw); err != nil || limit <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+			return
+		}
+	}
+	changes, err := h.inventoryService.History(c.Request.Context(), id, userID, limit)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, changes)
+}
+
+// versionOps maps query parameters to version comparisons.
+var versionOps = []pkgversion.Op{pkgversion.OpLT, pkgversion.OpLE, pkgversion.OpEQ, pkgversion.OpGE, pkgversion.OpGT}
+
+// FindPackages handles GET /api/v1/inventory/packages
+// Query: name=<package> (required); optional lt, lte, eq, gte, gt=<version>
+// (e.g. name=openssl&lt=3.0.2), plus selector, group and org as for
+// GET /api/v1/machines.
+func (h *InventoryHandler) FindPackages(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	q := services.PackageQuery{Name: strings.TrimSpace(c.Query("name"))}
+	if q.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+		return
+	}
+	for _, op := range versionOps {
+		if v := c.Query(string(op)); v != "" {
+			q.Constraints = append(q.Constraints, services.VersionConstraint{Op: op, Version: v})
+		}
+	}
+	filter, err := parseMachineFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	matches, err := h.inventoryService.FindPackages(c.Request.Context(), userID, filter, q)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, matches)
+}
+
+func (h *InventoryHandler) writeError(c *gin.Context, err error) {
+	switch {
+	case err == services.ErrInventoryNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case err.Error() == "machine not found":
+		c.JSON(http.StatusNotFound, gin.H{"error": "machine not found"})
+	case errors.Is(err, authz.ErrForbidden):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
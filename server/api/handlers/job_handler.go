@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/lute/api/authz"
+	"github.com/lute/api/models"
+	"github.com/lute/api/services"
+)
+
+// JobHandler handles jobs running a typed action across many machines.
+type JobHandler struct {
+	jobService *services.JobService
+}
+
+// NewJobHandler creates a new JobHandler.
+func NewJobHandler(jobService *services.JobService) *JobHandler {
+	return &JobHandler{jobService: jobService}
+}
+
+// CreateJobRequest is the JSON body for creating a job, e.g.
+//
+//	{"action": "service-restart", "params": {"unit": "nginx.service"},
+//	 "selector": "role=web", "batch_size": 10, "concurrency": 5,
+//	 "stop_after_failures": 2, "offline": "skip"}
+//
+// Action, params, args and timeout_seconds are those of a machine action
+// (POST /api/v1/machines/:id/actions/:action). A selector or group_id is
+// required; without org_id the job runs on the creator's personal machines.
+type CreateJobRequest struct {
+	Name              string            `json:"name"`
+	OrgID             string            `json:"org_id"`
+	Action            string            `json:"action" binding:"required"`
+	Params            map[string]string `json:"params"`
+	Args              []string          `json:"args"`
+	TimeoutSeconds    int               `json:"timeout_seconds"`
+	Selector          string            `json:"selector"`
+	GroupID           string            `json:"group_id"`
+	Concurrency       int               `json:"concurrency"`         // 0 for no limit
+	BatchSize         int               `json:"batch_size"`          // 0 for one batch
+	StopAfterFailures int               `json:"stop_after_failures"` // 0 never stops
+	Offline           string            `json:"offline"`             // "queue" (default) or "skip"
+}
+
+// CreateJob handles POST /api/v1/jobs
+func (h *JobHandler) CreateJob(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	var req CreateJobRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	in := services.CreateJobInput{
+		Name:   req.Name,
+		Action: req.Action,
+		Input: services.ActionInput{
+			Params:         req.Params,
+			Args:           req.Args,
+			TimeoutSeconds: req.TimeoutSeconds,
+		},
+		Selector:          req.Selector,
+		Concurrency:       req.Concurrency,
+		BatchSize:         req.BatchSize,
+		StopAfterFailures: req.StopAfterFailures,
+		Offline:           req.Offline,
+	}
+	if req.OrgID != "" {
+		orgID, err := primitive.ObjectIDFromHex(req.OrgID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+			return
+		}
+		in.OrgID = orgID
+	}
+	if req.GroupID != "" {
+		groupID, err := primitive.ObjectIDFromHex(req.GroupID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group ID"})
+			return
+		}
+		in.GroupID = groupID
+	}
+
+	job, err := h.jobService.Create(c.Request.Context(), userID, in)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, job)
+}
+
+// ListJobs handles GET /api/v1/jobs
+// Newest first, with their summaries but not their machines.
+func (h *JobHandler) ListJobs(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	jobs, err := h.jobService.List(c.Request.Context(), userID)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	if jobs == nil {
+		jobs = []*models.Job{}
+	}
+	c.JSON(http.StatusOK, jobs)
+}
+
+// GetJob handles GET /api/v1/jobs/:id
+// The job with its summary and the state, exit code, error and output of
+// every machine's command.
+func (h *JobHandler) GetJob(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid job ID"})
+		return
+	}
+	job, err := h.jobService.Get(c.Request.Context(), userID, id)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// CancelJob handles POST /api/v1/jobs/:id/cancel
+// Machines not started yet are cancelled; commands already sent run on.
+func (h *JobHandler) CancelJob(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid job ID"})
+		return
+	}
+	if err := h.jobService.Cancel(c.Request.Context(), userID, id); err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"message": "Job cancellation requested"})
+}
+
+func (h *JobHandler) writeError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, authz.ErrForbidden), err == services.ErrGroupUnauthorized:
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case err == services.ErrJobNotFound, err == services.ErrGroupNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case err == services.ErrUnknownAction:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "actions": services.Actions})
+	case errors.Is(err, services.ErrInvalidActionParam), errors.Is(err, services.ErrInvalidJob),
+		err == services.ErrJobNoMachines:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case err == services.ErrJobNotRunning:
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -1,11 +1,16 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 
+	"github.com/lute/api/authz"
+	"github.com/lute/api/labels"
 	"github.com/lute/api/models"
 	"github.com/lute/api/services"
 )
@@ -43,6 +48,14 @@ func (h *MachineHandler) CreateMachine(c *gin.Context) {
 
 	createdMachine, err := h.machineService.Create(c.Request.Context(), userIDObj, &machine)
 	if err != nil {
+		if errors.Is(err, labels.ErrInvalid) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, authz.ErrForbidden) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -58,10 +71,15 @@ func (h *MachineHandler) GetMachine(c *gin.Context) {
 		return
 	}
 
-	machine, err := h.machineService.GetByID(c.Request.Context(), id)
+	userIDObj, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	machine, err := h.machineService.GetForUser(c.Request.Context(), id, userIDObj, authz.ActionMachineRead)
 	if err != nil {
-		if err.Error() == "machine not found" {
-			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		if err.Error() == "machine not found" || errors.Is(err, authz.ErrForbidden) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "machine not found"})
 			return
 		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -72,6 +90,7 @@ func (h *MachineHandler) GetMachine(c *gin.Context) {
 }
 
 // ListUserMachines handles GET /api/v1/machines
+// Optional query: selector=<label selector> (e.g. env=prod,role in (db,cache)), group=<group id>.
 func (h *MachineHandler) ListUserMachines(c *gin.Context) {
 	userID, exists := c.Get("user_id")
 	if !exists {
@@ -85,8 +104,18 @@ func (h *MachineHandler) ListUserMachines(c *gin.Context) {
 		return
 	}
 
-	machines, err := h.machineService.GetByUserID(c.Request.Context(), userIDObj)
+	filter, err := parseMachineFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	machines, err := h.machineService.GetByUserIDFiltered(c.Request.Context(), userIDObj, filter)
 	if err != nil {
+		if errors.Is(err, authz.ErrForbidden) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -105,6 +134,15 @@ func (h *MachineHandler) ListPublicMachines(c *gin.Context) {
 	c.JSON(http.StatusOK, machines)
 }
 
+// UpdateMachineRequest is the JSON body for updating a machine: the fields
+// its users edit. Labels are kept when omitted.
+type UpdateMachineRequest struct {
+	Name        string            `json:"name" binding:"required"`
+	Description string            `json:"description"`
+	IsPublic    bool              `json:"is_public"`
+	Labels      map[string]string `json:"labels"`
+}
+
 // UpdateMachine handles PUT /api/v1/machines/:id
 func (h *MachineHandler) UpdateMachine(c *gin.Context) {
 	id, err := primitive.ObjectIDFromHex(c.Param("id"))
@@ -126,19 +164,28 @@ func (h *MachineHandler) UpdateMachine(c *gin.Context) {
 		return
 	}
 
-	var machine models.Machine
-	if err := c.ShouldBindJSON(&machine); err != nil {
+	var req UpdateMachineRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	updatedMachine, err := h.machineService.Update(c.Request.Context(), id, userIDObj, &machine)
+	updatedMachine, err := h.machineService.Update(c.Request.Context(), id, userIDObj, services.UpdateMachineInput{
+		Name:        req.Name,
+		Description: req.Description,
+		IsPublic:    req.IsPublic,
+		Labels:      req.Labels,
+	})
 	if err != nil {
+		if errors.Is(err, labels.ErrInvalid) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		if err.Error() == "machine not found" {
 			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 			return
 		}
-		if err.Error() == "unauthorized: machine does not belong to user" {
+		if errors.Is(err, authz.ErrForbidden) {
 			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
 			return
 		}
@@ -149,6 +196,96 @@ func (h *MachineHandler) UpdateMachine(c *gin.Context) {
 	c.JSON(http.StatusOK, updatedMachine)
 }
 
+// SetMachineLabels handles PUT /api/v1/machines/:id/labels
+// Body: {"labels": {"env": "prod"}}. Replaces the whole label set (empty map clears it).
+func (h *MachineHandler) SetMachineLabels(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid machine ID"})
+		return
+	}
+
+	userIDObj, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Labels map[string]string `json:"labels"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	updated, err := h.machineService.SetLabels(c.Request.Context(), id, userIDObj, req.Labels)
+	if err != nil {
+		if errors.Is(err, labels.ErrInvalid) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err.Error() == "machine not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, authz.ErrForbidden) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, updated)
+}
+
+// TransferMachine handles PUT /api/v1/machines/:id/org
+// Body: {"org_id": "<hex>"} moves the machine into an organization; an empty org_id makes it personal again.
+func (h *MachineHandler) TransferMachine(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid machine ID"})
+		return
+	}
+
+	userIDObj, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		OrgID string `json:"org_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	orgID := primitive.NilObjectID
+	if req.OrgID != "" {
+		orgID, err = primitive.ObjectIDFromHex(req.OrgID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid org ID"})
+			return
+		}
+	}
+
+	updated, err := h.machineService.TransferToOrg(c.Request.Context(), id, userIDObj, orgID)
+	if err != nil {
+		if err.Error() == "machine not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, authz.ErrForbidden) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, updated)
+}
+
 // ReEnableMachine handles POST /api/v1/machines/:id/re-enable (only when status is "dead").
 func (h *MachineHandler) ReEnableMachine(c *gin.Context) {
 	id, err := primitive.ObjectIDFromHex(c.Param("id"))
@@ -169,21 +306,21 @@ func (h *MachineHandler) ReEnableMachine(c *gin.Context) {
 		return
 	}
 
-	existing, err := h.machineService.GetByID(c.Request.Context(), id)
+	existing, err := h.machineService.GetForUser(c.Request.Context(), id, userIDObj, authz.ActionMachineOperate)
 	if err != nil {
+		if errors.Is(err, authz.ErrForbidden) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusNotFound, gin.H{"error": "machine not found"})
 		return
 	}
-	if existing.UserID != userIDObj {
-		c.JSON(http.StatusForbidden, gin.H{"error": "machine not found"})
-		return
-	}
 	if existing.Status != "dead" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "machine is not dead; only dead machines can be re-enabled"})
 		return
 	}
 
-	if err := h.machineService.UpdateStatus(c.Request.Context(), id, "pending"); err != nil {
+	if err := h.machineService.ReEnable(c.Request.Context(), existing); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -192,6 +329,51 @@ func (h *MachineHandler) ReEnableMachine(c *gin.Context) {
 	c.JSON(http.StatusOK, updated)
 }
 
+// RotateAgentToken handles POST /api/v1/machines/:id/agent-token/rotate
+func (h *MachineHandler) RotateAgentToken(c *gin.Context) {
+	h.agentTokenAction(c, h.machineService.RotateAgentToken, "Agent token rotation requested")
+}
+
+// RevokeAgent handles POST /api/v1/machines/:id/agent-token/revoke
+func (h *MachineHandler) RevokeAgent(c *gin.Context) {
+	h.agentTokenAction(c, h.machineService.RevokeAgent, "Agent credentials revoked")
+}
+
+func (h *MachineHandler) agentTokenAction(c *gin.Context, action func(ctx context.Context, id, userID primitive.ObjectID) error, message string) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid machine ID"})
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	userIDObj, err := primitive.ObjectIDFromHex(userID.(string))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	if err := action(c.Request.Context(), id, userIDObj); err != nil {
+		if err.Error() == "machine not found" || errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "machine not found"})
+			return
+		}
+		if errors.Is(err, authz.ErrForbidden) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": message})
+}
+
 // DeleteMachine handles DELETE /api/v1/machines/:id
 func (h *MachineHandler) DeleteMachine(c *gin.Context) {
 	id, err := primitive.ObjectIDFromHex(c.Param("id"))
@@ -218,7 +400,7 @@ func (h *MachineHandler) DeleteMachine(c *gin.Context) {
 			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 			return
 		}
-		if err.Error() == "unauthorized: machine does not belong to user" {
+		if errors.Is(err, authz.ErrForbidden) {
 			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
 			return
 		}
@@ -0,0 +1,281 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/lute/api/authz"
+	"github.com/lute/api/services"
+)
+
+// OrgHandler handles organizations, memberships and invites.
+type OrgHandler struct {
+	orgService *services.OrgService
+}
+
+// NewOrgHandler creates a new OrgHandler.
+func NewOrgHandler(orgService *services.OrgService) *OrgHandler {
+	return &OrgHandler{orgService: orgService}
+}
+
+// OrgRequest is the JSON body for creating or renaming an organization
+type OrgRequest struct {
+	Name string `json:"name" binding:"required"`
+}
+
+// MemberRoleRequest is the JSON body for changing a member's role
+type MemberRoleRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+// InviteRequest is the JSON body for inviting a user by email
+type InviteRequest struct {
+	Email string `json:"email" binding:"required"`
+	Role  string `json:"role" binding:"required"`
+}
+
+// AcceptInviteRequest is the JSON body for accepting an invite
+type AcceptInviteRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// CreateOrg handles POST /api/v1/orgs
+func (h *OrgHandler) CreateOrg(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	var req OrgRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	org, err := h.orgService.Create(c.Request.Context(), userID, req.Name)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, org)
+}
+
+// ListOrgs handles GET /api/v1/orgs (organizations of the user with their role)
+func (h *OrgHandler) ListOrgs(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	orgs, err := h.orgService.List(c.Request.Context(), userID)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, orgs)
+}
+
+// GetOrg handles GET /api/v1/orgs/:id
+func (h *OrgHandler) GetOrg(c *gin.Context) {
+	userID, orgID, ok := h.orgParams(c)
+	if !ok {
+		return
+	}
+	org, err := h.orgService.Get(c.Request.Context(), orgID, userID)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, org)
+}
+
+// UpdateOrg handles PUT /api/v1/orgs/:id
+func (h *OrgHandler) UpdateOrg(c *gin.Context) {
+	userID, orgID, ok := h.orgParams(c)
+	if !ok {
+		return
+	}
+	var req OrgRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	org, err := h.orgService.Rename(c.Request.Context(), orgID, userID, req.Name)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, org)
+}
+
+// DeleteOrg handles DELETE /api/v1/orgs/:id
+func (h *OrgHandler) DeleteOrg(c *gin.Context) {
+	userID, orgID, ok := h.orgParams(c)
+	if !ok {
+		return
+	}
+	if err := h.orgService.Delete(c.Request.Context(), orgID, userID); err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Organization deleted successfully"})
+}
+
+// ListMembers handles GET /api/v1/orgs/:id/members
+func (h *OrgHandler) ListMembers(c *gin.Context) {
+	userID, orgID, ok := h.orgParams(c)
+	if !ok {
+		return
+	}
+	members, err := h.orgService.Members(c.Request.Context(), orgID, userID)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, members)
+}
+
+// UpdateMember handles PUT /api/v1/orgs/:id/members/:userId
+func (h *OrgHandler) UpdateMember(c *gin.Context) {
+	userID, orgID, ok := h.orgParams(c)
+	if !ok {
+		return
+	}
+	memberID, err := primitive.ObjectIDFromHex(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	var req MemberRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	member, err := h.orgService.UpdateMemberRole(c.Request.Context(), orgID, userID, memberID, authz.Role(req.Role))
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, member)
+}
+
+// RemoveMember handles DELETE /api/v1/orgs/:id/members/:userId
+func (h *OrgHandler) RemoveMember(c *gin.Context) {
+	userID, orgID, ok := h.orgParams(c)
+	if !ok {
+		return
+	}
+	memberID, err := primitive.ObjectIDFromHex(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	if err := h.orgService.RemoveMember(c.Request.Context(), orgID, userID, memberID); err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Member removed successfully"})
+}
+
+// CreateInvite handles POST /api/v1/orgs/:id/invites
+func (h *OrgHandler) CreateInvite(c *gin.Context) {
+	userID, orgID, ok := h.orgParams(c)
+	if !ok {
+		return
+	}
+	var req InviteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	invite, err := h.orgService.Invite(c.Request.Context(), orgID, userID, req.Email, authz.Role(req.Role))
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, invite)
+}
+
+// ListInvites handles GET /api/v1/orgs/:id/invites (pending invites only)
+func (h *OrgHandler) ListInvites(c *gin.Context) {
+	userID, orgID, ok := h.orgParams(c)
+	if !ok {
+		return
+	}
+	invites, err := h.orgService.Invites(c.Request.Context(), orgID, userID)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, invites)
+}
+
+// RevokeInvite handles DELETE /api/v1/orgs/:id/invites/:inviteId
+func (h *OrgHandler) RevokeInvite(c *gin.Context) {
+	userID, orgID, ok := h.orgParams(c)
+	if !ok {
+		return
+	}
+	inviteID, err := primitive.ObjectIDFromHex(c.Param("inviteId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invite ID"})
+		return
+	}
+	if err := h.orgService.RevokeInvite(c.Request.Context(), orgID, userID, inviteID); err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Invite revoked successfully"})
+}
+
+// AcceptInvite handles POST /api/v1/invites/accept
+func (h *OrgHandler) AcceptInvite(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	var req AcceptInviteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	member, err := h.orgService.AcceptInvite(c.Request.Context(), userID, req.Token)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, member)
+}
+
+// orgParams returns the current user and the :id organization parameter.
+func (h *OrgHandler) orgParams(c *gin.Context) (userID, orgID primitive.ObjectID, ok bool) {
+	userID, ok = currentUserID(c)
+	if !ok {
+		return
+	}
+	orgID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+		return userID, orgID, false
+	}
+	return userID, orgID, true
+}
+
+func (h *OrgHandler) writeError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, authz.ErrForbidden):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case err == services.ErrOrgNotFound, err == services.ErrMemberNotFound, err == services.ErrInviteNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case err == services.ErrOrgNotEmpty, err == services.ErrLastOwner, err == services.ErrAlreadyMember:
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case err == services.ErrInviteExpired:
+		c.JSON(http.StatusGone, gin.H{"error": err.Error()})
+	case err == services.ErrInviteEmail:
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case err == services.ErrInvalidRole, err == services.ErrOrgNameRequired, err == services.ErrInviteEmailEmpty:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,266 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/lute/api/authz"
+	"github.com/lute/api/services"
+)
+
+// ScheduleHandler handles schedules running a typed action on a cron
+// expression.
+type ScheduleHandler struct {
+	scheduleService *services.ScheduleService
+}
+
+// NewScheduleHandler creates a new ScheduleHandler.
+func NewScheduleHandler(scheduleService *services.ScheduleService) *ScheduleHandler {
+	return &ScheduleHandler{scheduleService: scheduleService}
+}
+
+// ScheduleRequest is the JSON body for creating or replacing a schedule, e.g.
+//
+//	{"name": "nightly log cleanup", "cron": "30 2 * * *",
+//	 "timezone": "Europe/Berlin", "action": "run-script",
+//	 "params": {"script": "cleanup-logs"}, "selector": "role=web"}
+//
+// Action, params, args and timeout_seconds are those of a machine action
+// (POST /api/v1/machines/:id/actions/:action). With machine_id each run is a
+// command on that machine; otherwise it is a job on the machines matching
+// selector and group_id at that time, with the settings of POST /api/v1/jobs.
+// missed_runs is "skip" (default) or "run_once": what to do with runs
+// missed while no API instance was running.
+type ScheduleRequest struct {
+	Name              string            `json:"name"`
+	OrgID             string            `json:"org_id"` // create only
+	Cron              string            `json:"cron" binding:"required"`
+	Timezone          string            `json:"timezone"` // default "UTC"
+	Enabled           *bool             `json:"enabled"`  // default true
+	MissedRuns        string            `json:"missed_runs"`
+	Action            string            `json:"action" binding:"required"`
+	Params            map[string]string `json:"params"`
+	Args              []string          `json:"args"`
+	TimeoutSeconds    int               `json:"timeout_seconds"`
+	MachineID         string            `json:"machine_id"`
+	Selector          string            `json:"selector"`
+	GroupID           string            `json:"group_id"`
+	Concurrency       int               `json:"concurrency"`
+	BatchSize         int               `json:"batch_size"`
+	StopAfterFailures int               `json:"stop_after_failures"`
+	Offline           string            `json:"offline"`
+}
+
+// input converts the request, writing a 400 response on invalid IDs.
+func (r ScheduleRequest) input(c *gin.Context) (services.ScheduleInput, bool) {
+	in := services.ScheduleInput{
+		Name:       r.Name,
+		Cron:       r.Cron,
+		Timezone:   r.Timezone,
+		Enabled:    r.Enabled == nil || *r.Enabled,
+		MissedRuns: r.MissedRuns,
+		Action:     r.Action,
+		Input: services.ActionInput{
+			Params:         r.Params,
+			Args:           r.Args,
+			TimeoutSeconds: r.TimeoutSeconds,
+		},
+		Selector:          r.Selector,
+		Concurrency:       r.Concurrency,
+		BatchSize:         r.BatchSize,
+		StopAfterFailures: r.StopAfterFailures,
+		Offline:           r.Offline,
+	}
+	for _, id := range []struct {
+		hex  string
+		dst  *primitive.ObjectID
+		name string
+	}{
+		{r.OrgID, &in.OrgID, "organization"},
+		{r.MachineID, &in.MachineID, "machine"},
+		{r.GroupID, &in.GroupID, "group"},
+	} {
+		if id.hex == "" {
+			continue
+		}
+		oid, err := primitive.ObjectIDFromHex(id.hex)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + id.name + " ID"})
+			return in, false
+		}
+		*id.dst = oid
+	}
+	return in, true
+}
+
+// CreateSchedule handles POST /api/v1/schedules
+func (h *ScheduleHandler) CreateSchedule(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	var req ScheduleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	in, ok := req.input(c)
+	if !ok {
+		return
+	}
+	schedule, err := h.scheduleService.Create(c.Request.Context(), userID, in)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, schedule)
+}
+
+// ListSchedules handles GET /api/v1/schedules
+func (h *ScheduleHandler) ListSchedules(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	schedules, err := h.scheduleService.List(c.Request.Context(), userID)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, schedules)
+}
+
+// PreviewScheduleRequest is the JSON body for previewing a cron expression.
+type PreviewScheduleRequest struct {
+	Cron     string `json:"cron" binding:"required"`
+	Timezone string `json:"timezone"` // default "UTC"
+	Count    int    `json:"count"`    // default 5, at most 50
+}
+
+// PreviewSchedule handles POST /api/v1/schedules/preview
+// The next times a cron expression fires in a time zone, to check it before
+// saving a schedule.
+func (h *ScheduleHandler) PreviewSchedule(c *gin.Context) {
+	var req PreviewScheduleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Timezone == "" {
+		req.Timezone = "UTC"
+	}
+	times, err := h.scheduleService.Preview(req.Cron, req.Timezone, req.Count)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"cron": req.Cron, "timezone": req.Timezone, "next_runs": times})
+}
+
+// GetSchedule handles GET /api/v1/schedules/:id
+func (h *ScheduleHandler) GetSchedule(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid schedule ID"})
+		return
+	}
+	schedule, err := h.scheduleService.Get(c.Request.Context(), userID, id)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, schedule)
+}
+
+// UpdateSchedule handles PUT /api/v1/schedules/:id
+// Replaces the schedule's settings; its next run is worked out from now.
+func (h *ScheduleHandler) UpdateSchedule(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid schedule ID"})
+		return
+	}
+	var req ScheduleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	in, ok := req.input(c)
+	if !ok {
+		return
+	}
+	schedule, err := h.scheduleService.Update(c.Request.Context(), userID, id, in)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, schedule)
+}
+
+// DeleteSchedule handles DELETE /api/v1/schedules/:id
+func (h *ScheduleHandler) DeleteSchedule(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid schedule ID"})
+		return
+	}
+	if err := h.scheduleService.Delete(c.Request.Context(), userID, id); err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Schedule deleted successfully"})
+}
+
+// ListScheduleRuns handles GET /api/v1/schedules/:id/runs
+// The latest 100 runs, latest first, with the command or job each started,
+// or why it failed or was skipped.
+func (h *ScheduleHandler) ListScheduleRuns(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid schedule ID"})
+		return
+	}
+	runs, err := h.scheduleService.Runs(c.Request.Context(), userID, id)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, runs)
+}
+
+func (h *ScheduleHandler) writeError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, authz.ErrForbidden), err == services.ErrGroupUnauthorized:
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case err == services.ErrScheduleNotFound, err == services.ErrGroupNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case err.Error() == "machine not found":
+		c.JSON(http.StatusNotFound, gin.H{"error": "machine not found"})
+	case err == services.ErrUnknownAction:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "actions": services.Actions})
+	case errors.Is(err, services.ErrInvalidSchedule), errors.Is(err, services.ErrInvalidActionParam),
+		errors.Is(err, services.ErrInvalidJob):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
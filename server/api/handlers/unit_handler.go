@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/lute/api/authz"
+	"github.com/lute/api/services"
+)
+
+// UnitHandler serves the systemd unit states agents report.
+type UnitHandler struct {
+	unitService *services.UnitService
+}
+
+// NewUnitHandler creates a new UnitHandler.
+func NewUnitHandler(unitService *services.UnitService) *UnitHandler {
+	return &UnitHandler{unitService: unitService}
+}
+
+// GetMachineUnits handles GET /api/v1/machines/:id/units
+func (h *UnitHandler) GetMachineUnits(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid machine ID"})
+		return
+	}
+	units, err := h.unitService.ForMachine(c.Request.Context(), id, userID)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, units)
+}
+
+// ListFailedUnits handles GET /api/v1/units/failed
+// Fleet-wide view over the machines visible to the user; selector, group and
+// org filter the machines as for GET /api/v1/machines.
+func (h *UnitHandler) ListFailedUnits(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	filter, err := parseMachineFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	units, err := h.unitService.Failed(c.Request.Context(), userID, filter)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, units)
+}
+
+func (h *UnitHandler) writeError(c *gin.Context, err error) {
+	switch {
+	case err == services.ErrUnitsNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case err.Error() == "machine not found":
+		c.JSON(http.StatusNotFound, gin.H{"error": "machine not found"})
+	case errors.Is(err, authz.ErrForbidden):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
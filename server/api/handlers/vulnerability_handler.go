@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/lute/api/advisory"
+	"github.com/lute/api/authz"
+	"github.com/lute/api/services"
+)
+
+// VulnerabilityHandler serves the vulnerabilities found by matching machine
+// inventories against the advisory feed.
+type VulnerabilityHandler struct {
+	vulnerabilityService *services.VulnerabilityService
+}
+
+// NewVulnerabilityHandler creates a new VulnerabilityHandler.
+func NewVulnerabilityHandler(vulnerabilityService *services.VulnerabilityService) *VulnerabilityHandler {
+	return &VulnerabilityHandler{vulnerabilityService: vulnerabilityService}
+}
+
+// ListVulnerabilities handles GET /api/v1/vulnerabilities
+// Fleet-wide view: the CVEs found on the machines visible to the user, each
+// with its affected machines; selector, group and org filter the machines
+// as for GET /api/v1/machines. With machine_id=<id>, the per-machine view:
+// that machine's findings. Optional severity=<critical|high|medium|low>
+// keeps only findings at least that severe.
+func (h *VulnerabilityHandler) ListVulnerabilities(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	severity := strings.ToLower(c.Query("severity"))
+	if severity != "" && !advisory.ValidSeverity(severity) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "severity must be critical, high, medium, low or unknown"})
+		return
+	}
+
+	if raw := c.Query("machine_id"); raw != "" {
+		id, err := primitive.ObjectIDFromHex(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid machine ID"})
+			return
+		}
+		findings, err := h.vulnerabilityService.ForMachine(c.Request.Context(), id, userID, severity)
+		if err != nil {
+			h.writeError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, findings)
+		return
+	}
+
+	filter, err := parseMachineFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	summaries, err := h.vulnerabilityService.Fleet(c.Request.Context(), userID, filter, severity)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, summaries)
+}
+
+// GetVulnerability handles GET /api/v1/vulnerabilities/:id
+// id is a CVE ID (or the ID of an advisory that names none). Returns the
+// affected packages of every visible machine; selector, group and org as
+// for the list.
+func (h *VulnerabilityHandler) GetVulnerability(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	filter, err := parseMachineFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	detail, err := h.vulnerabilityService.Get(c.Request.Context(), userID, filter, strings.ToUpper(c.Param("id")))
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, detail)
+}
+
+// GetFeedStatus handles GET /api/v1/vulnerabilities/feed
+func (h *VulnerabilityHandler) GetFeedStatus(c *gin.Context) {
+	if _, ok := currentUserID(c); !ok {
+		return
+	}
+	status, err := h.vulnerabilityService.FeedStatus(c.Request.Context())
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+func (h *VulnerabilityHandler) writeError(c *gin.Context, err error) {
+	switch {
+	case err == services.ErrVulnerabilityNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case err.Error() == "machine not found":
+		c.JSON(http.StatusNotFound, gin.H{"error": "machine not found"})
+	case errors.Is(err, authz.ErrForbidden):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
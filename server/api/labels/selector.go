@@ -0,0 +1,303 @@
+package labels
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Limits for label keys and values. Keys must not contain "." or "$" because
+// they are stored as sub-fields of machines.labels in MongoDB.
+const (
+	MaxKeyLength   = 63
+	MaxValueLength = 63
+	MaxLabels      = 64
+)
+
+// ErrInvalid is wrapped by every label validation error.
+var ErrInvalid = errors.New("invalid label")
+
+var (
+	keyPattern   = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9_/-]*[A-Za-z0-9])?$`)
+	valuePattern = regexp.MustCompile(`^([A-Za-z0-9]([A-Za-z0-9._-]*[A-Za-z0-9])?)?$`)
+)
+
+// ValidateKey returns an error if key is not a valid label key.
+func ValidateKey(key string) error {
+	if key == "" {
+		return fmt.Errorf("%w: key cannot be empty", ErrInvalid)
+	}
+	if len(key) > MaxKeyLength {
+		return fmt.Errorf("%w: key %q is longer than %d characters", ErrInvalid, key, MaxKeyLength)
+	}
+	if !keyPattern.MatchString(key) {
+		return fmt.Errorf("%w: key %q must be alphanumeric and may contain '-', '_' or '/'", ErrInvalid, key)
+	}
+	return nil
+}
+
+// ValidateValue returns an error if value is not a valid label value.
+func ValidateValue(value string) error {
+	if len(value) > MaxValueLength {
+		return fmt.Errorf("%w: value %q is longer than %d characters", ErrInvalid, value, MaxValueLength)
+	}
+	if !valuePattern.MatchString(value) {
+		return fmt.Errorf("%w: value %q must be alphanumeric and may contain '-', '_' or '.'", ErrInvalid, value)
+	}
+	return nil
+}
+
+// Validate checks every key/value pair of a label set.
+func Validate(set map[string]string) error {
+	if len(set) > MaxLabels {
+		return fmt.Errorf("%w: too many labels (%d > %d)", ErrInvalid, len(set), MaxLabels)
+	}
+	for k, v := range set {
+		if err := ValidateKey(k); err != nil {
+			return err
+		}
+		if err := ValidateValue(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Operator is the comparison used by a single selector requirement.
+type Operator string
+
+const (
+	OpEquals       Operator = "="
+	OpNotEquals    Operator = "!="
+	OpIn           Operator = "in"
+	OpNotIn        Operator = "notin"
+	OpExists       Operator = "exists"
+	OpDoesNotExist Operator = "!"
+)
+
+// Requirement is one clause of a selector, e.g. "env=prod" or "role in (db,cache)".
+type Requirement struct {
+	Key      string
+	Operator Operator
+	Values   []string
+}
+
+// Selector is a conjunction of requirements. The zero value matches everything.
+type Selector struct {
+	Requirements []Requirement
+}
+
+// Empty reports whether the selector has no requirements.
+func (s *Selector) Empty() bool {
+	return s == nil || len(s.Requirements) == 0
+}
+
+// ParseSelector parses a label selector such as
+//
+//	env=prod,role in (db,cache),!legacy,tier!=frontend
+//
+// Supported forms: key=value, key==value, key!=value, key in (a,b),
+// key notin (a,b), key (exists) and !key (does not exist).
+func ParseSelector(s string) (*Selector, error) {
+	sel := &Selector{}
+	for _, clause := range splitClauses(s) {
+		clause = strings.TrimSpace(clause)
+		if clause == "" {
+			continue
+		}
+		req, err := parseRequirement(clause)
+		if err != nil {
+			return nil, err
+		}
+		sel.Requirements = append(sel.Requirements, req)
+	}
+	return sel, nil
+}
+
+// splitClauses splits on commas that are not inside parentheses.
+func splitClauses(s string) []string {
+	var out []string
+	depth, start := 0, 0
+	for i, r := range s {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				out = append(out, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(out, s[start:])
+}
+
+func parseRequirement(clause string) (Requirement, error) {
+	if strings.HasPrefix(clause, "!") && !strings.Contains(clause, "=") {
+		key := strings.TrimSpace(clause[1:])
+		if err := ValidateKey(key); err != nil {
+			return Requirement{}, err
+		}
+		return Requirement{Key: key, Operator: OpDoesNotExist}, nil
+	}
+
+	if open := strings.Index(clause, "("); open != -1 {
+		if !strings.HasSuffix(clause, ")") {
+			return Requirement{}, fmt.Errorf("invalid selector clause %q: missing ')'", clause)
+		}
+		fields := strings.Fields(clause[:open])
+		if len(fields) != 2 {
+			return Requirement{}, fmt.Errorf("invalid selector clause %q: expected 'key in (...)'", clause)
+		}
+		key, op := fields[0], Operator(strings.ToLower(fields[1]))
+		if op != OpIn && op != OpNotIn {
+			return Requirement{}, fmt.Errorf("invalid selector operator %q in %q", fields[1], clause)
+		}
+		if err := ValidateKey(key); err != nil {
+			return Requirement{}, err
+		}
+		var values []string
+		for _, v := range strings.Split(clause[open+1:len(clause)-1], ",") {
+			v = strings.TrimSpace(v)
+			if v == "" {
+				continue
+			}
+			if err := ValidateValue(v); err != nil {
+				return Requirement{}, err
+			}
+			values = append(values, v)
+		}
+		if len(values) == 0 {
+			return Requirement{}, fmt.Errorf("invalid selector clause %q: empty value list", clause)
+		}
+		return Requirement{Key: key, Operator: op, Values: values}, nil
+	}
+
+	for _, sep := range []string{"!=", "==", "="} {
+		if idx := strings.Index(clause, sep); idx != -1 {
+			key := strings.TrimSpace(clause[:idx])
+			value := strings.TrimSpace(clause[idx+len(sep):])
+			if err := ValidateKey(key); err != nil {
+				return Requirement{}, err
+			}
+			if err := ValidateValue(value); err != nil {
+				return Requirement{}, err
+			}
+			op := OpEquals
+			if sep == "!=" {
+				op = OpNotEquals
+			}
+			return Requirement{Key: key, Operator: op, Values: []string{value}}, nil
+		}
+	}
+
+	if err := ValidateKey(clause); err != nil {
+		return Requirement{}, err
+	}
+	return Requirement{Key: clause, Operator: OpExists}, nil
+}
+
+// Matches reports whether the given label set satisfies every requirement.
+func (s *Selector) Matches(set map[string]string) bool {
+	if s.Empty() {
+		return true
+	}
+	for _, r := range s.Requirements {
+		v, ok := set[r.Key]
+		switch r.Operator {
+		case OpEquals:
+			if !ok || v != r.Values[0] {
+				return false
+			}
+		case OpNotEquals:
+			if ok && v == r.Values[0] {
+				return false
+			}
+		case OpIn:
+			if !ok || !contains(r.Values, v) {
+				return false
+			}
+		case OpNotIn:
+			if ok && contains(r.Values, v) {
+				return false
+			}
+		case OpExists:
+			if !ok {
+				return false
+			}
+		case OpDoesNotExist:
+			if ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Filter converts the selector into a MongoDB filter on the given field
+// (normally "labels"). Returns nil for an empty selector.
+func (s *Selector) Filter(field string) bson.M {
+	if s.Empty() {
+		return nil
+	}
+	clauses := make([]bson.M, 0, len(s.Requirements))
+	for _, r := range s.Requirements {
+		path := field + "." + r.Key
+		switch r.Operator {
+		case OpEquals:
+			clauses = append(clauses, bson.M{path: r.Values[0]})
+		case OpNotEquals:
+			clauses = append(clauses, bson.M{path: bson.M{"$ne": r.Values[0]}})
+		case OpIn:
+			clauses = append(clauses, bson.M{path: bson.M{"$in": r.Values}})
+		case OpNotIn:
+			clauses = append(clauses, bson.M{path: bson.M{"$nin": r.Values}})
+		case OpExists:
+			clauses = append(clauses, bson.M{path: bson.M{"$exists": true}})
+		case OpDoesNotExist:
+			clauses = append(clauses, bson.M{path: bson.M{"$exists": false}})
+		}
+	}
+	if len(clauses) == 1 {
+		return clauses[0]
+	}
+	return bson.M{"$and": clauses}
+}
+
+// String returns the canonical form of the selector (requirements sorted by key).
+func (s *Selector) String() string {
+	if s.Empty() {
+		return ""
+	}
+	reqs := append([]Requirement(nil), s.Requirements...)
+	sort.SliceStable(reqs, func(i, j int) bool { return reqs[i].Key < reqs[j].Key })
+	parts := make([]string, 0, len(reqs))
+	for _, r := range reqs {
+		switch r.Operator {
+		case OpEquals, OpNotEquals:
+			parts = append(parts, r.Key+string(r.Operator)+r.Values[0])
+		case OpIn, OpNotIn:
+			parts = append(parts, fmt.Sprintf("%s %s (%s)", r.Key, r.Operator, strings.Join(r.Values, ",")))
+		case OpExists:
+			parts = append(parts, r.Key)
+		case OpDoesNotExist:
+			parts = append(parts, "!"+r.Key)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func contains(list []string, v string) bool {
+	for _, x := range list {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,135 @@
+package labels_test
+
+import (
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/lute/api/labels"
+)
+
+func TestParseSelector(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want string // canonical String() form
+	}{
+		{"", ""},
+		{" , ", ""},
+		{"env=prod", "env=prod"},
+		{"env==prod", "env=prod"},
+		{" env = prod ", "env=prod"},
+		{"env=", "env="},
+		{"tier!=frontend", "tier!=frontend"},
+		{"role in (db, cache)", "role in (db,cache)"},
+		{"role IN (db)", "role in (db)"},
+		{"role notin (db,,cache)", "role notin (db,cache)"},
+		{"gpu", "gpu"},
+		{"!legacy", "!legacy"},
+		{"! legacy", "!legacy"},
+		{"team/owner=ops", "team/owner=ops"},
+		{"version=1.2.3", "version=1.2.3"},
+		{"role in (db,cache),env=prod,!legacy,tier!=frontend",
+			"env=prod,!legacy,role in (db,cache),tier!=frontend"},
+	} {
+		sel, err := labels.ParseSelector(tt.in)
+		if err != nil {
+			t.Errorf("ParseSelector(%q): %v", tt.in, err)
+			continue
+		}
+		if got := sel.String(); got != tt.want {
+			t.Errorf("ParseSelector(%q).String() = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseSelectorInvalid(t *testing.T) {
+	for _, in := range []string{
+		"!",
+		"=prod",
+		"!env=prod",
+		"-env=prod",
+		"env.name=prod",
+		"env$=prod",
+		"env=bad value",
+		"env=-prod",
+		"role in (db,cache",
+		"role in ()",
+		"role in (,)",
+		"role like (db)",
+		"in (db)",
+		"role in (db$)",
+	} {
+		if sel, err := labels.ParseSelector(in); err == nil {
+			t.Errorf("ParseSelector(%q) = %q, want error", in, sel)
+		}
+	}
+}
+
+func TestSelectorMatches(t *testing.T) {
+	set := map[string]string{"env": "prod", "role": "db", "gpu": ""}
+	for _, tt := range []struct {
+		sel  string
+		want bool
+	}{
+		{"", true},
+		{"env=prod", true},
+		{"env=dev", false},
+		{"zone=eu", false},
+		{"env!=dev", true},
+		{"env!=prod", false},
+		{"zone!=eu", true},
+		{"role in (db,cache)", true},
+		{"role in (web)", false},
+		{"zone in (eu)", false},
+		{"role notin (web)", true},
+		{"role notin (db)", false},
+		{"zone notin (eu)", true},
+		{"gpu", true},
+		{"zone", false},
+		{"!zone", true},
+		{"!gpu", false},
+		{"gpu=", true},
+		{"env=prod,role in (db),!zone", true},
+		{"env=prod,role=web", false},
+	} {
+		sel, err := labels.ParseSelector(tt.sel)
+		if err != nil {
+			t.Fatalf("ParseSelector(%q): %v", tt.sel, err)
+		}
+		if got := sel.Matches(set); got != tt.want {
+			t.Errorf("%q.Matches(%v) = %v, want %v", tt.sel, set, got, tt.want)
+		}
+	}
+	var nilSel *labels.Selector
+	if !nilSel.Matches(set) {
+		t.Error("nil selector does not match")
+	}
+}
+
+func TestSelectorFilter(t *testing.T) {
+	for _, tt := range []struct {
+		sel  string
+		want bson.M
+	}{
+		{"", nil},
+		{"env=prod", bson.M{"labels.env": "prod"}},
+		{"env!=prod", bson.M{"labels.env": bson.M{"$ne": "prod"}}},
+		{"role in (db,cache)", bson.M{"labels.role": bson.M{"$in": []string{"db", "cache"}}}},
+		{"role notin (db)", bson.M{"labels.role": bson.M{"$nin": []string{"db"}}}},
+		{"gpu", bson.M{"labels.gpu": bson.M{"$exists": true}}},
+		{"!gpu", bson.M{"labels.gpu": bson.M{"$exists": false}}},
+		{"env=prod,!gpu", bson.M{"$and": []bson.M{
+			{"labels.env": "prod"},
+			{"labels.gpu": bson.M{"$exists": false}},
+		}}},
+	} {
+		sel, err := labels.ParseSelector(tt.sel)
+		if err != nil {
+			t.Fatalf("ParseSelector(%q): %v", tt.sel, err)
+		}
+		if got := sel.Filter("labels"); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q.Filter() = %v, want %v", tt.sel, got, tt.want)
+		}
+	}
+}
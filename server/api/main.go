@@ -7,6 +7,8 @@ import (
 	"os/signal"
 	"syscall"
 	"time"
+	// Schedules name IANA time zones; the runtime image has no zone database
+	_ "time/tzdata"
 
 	"github.com/lute/api/server"
 	"github.com/lute/api/setup"
@@ -29,6 +31,31 @@ func main() {
 		deps.CommandRepo,
 		deps.UptimeSnapshotRepo,
 		deps.MachineSnapshotRepo,
+		deps.MachineGroupRepo,
+		deps.OrganizationRepo,
+		deps.OrgMemberRepo,
+		deps.OrgInviteRepo,
+		deps.APITokenRepo,
+		deps.AuditRepo,
+		deps.EnrollmentTokenRepo,
+		deps.AgentRolloutRepo,
+		deps.InventoryRepo,
+		deps.InventoryChangeRepo,
+		deps.AdvisoryRepo,
+		deps.VulnFindingRepo,
+		deps.FileEventRepo,
+		deps.UnitsRepo,
+		deps.AlertRuleRepo,
+		deps.AlertRepo,
+		deps.FileTransferRepo,
+		deps.FileChunkRepo,
+		deps.JobRepo,
+		deps.ScheduleRepo,
+		deps.ScheduleRunRepo,
+		deps.MachineConfigRepo,
+		deps.Authenticator,
+		deps.CertAuthority,
+		deps.AgentBinaries,
 	)
 
 	if err := srv.Start(); err != nil {
@@ -1,78 +1,32 @@
 package middleware
 
 import (
-	"context"
-	"fmt"
 	"net/http"
-	"os"
 	"strings"
 
-	firebase "firebase.google.com/go/v4"
-	"firebase.google.com/go/v4/auth"
 	"github.com/gin-gonic/gin"
-	"go.mongodb.org/mongo-driver/mongo"
-	"google.golang.org/api/option"
 
-	"github.com/lute/api/models"
+	"github.com/lute/api/audit"
+	"github.com/lute/api/auth"
+	"github.com/lute/api/authz"
 	"github.com/lute/api/repository"
+	"github.com/lute/api/services"
 )
 
-var firebaseApp *firebase.App
-var firebaseAuth *auth.Client
+var authenticator auth.Authenticator
+var apiTokens *services.APITokenService
 
-// InitFirebase initializes Firebase Admin SDK
-func InitFirebase(projectID string) error {
-	if firebaseApp != nil {
-		return nil // Already initialized
-	}
-
-	ctx := context.Background()
-	var opt option.ClientOption
-
-	// Try to use credentials from environment variable
-	credsJSON := os.Getenv("FIREBASE_CREDENTIALS_JSON")
-	if credsJSON != "" {
-		opt = option.WithCredentialsJSON([]byte(credsJSON))
-	}
-
-	app, err := firebase.NewApp(ctx, &firebase.Config{
-		ProjectID: projectID,
-	}, opt)
-	if err != nil {
-		return fmt.Errorf("error initializing Firebase app: %v", err)
-	}
-
-	authClient, err := app.Auth(ctx)
-	if err != nil {
-		return fmt.Errorf("error getting Auth client: %v", err)
-	}
-
-	firebaseApp = app
-	firebaseAuth = authClient
-	return nil
+// SetAuthenticator sets the provider used by AuthMiddleware to verify user tokens
+func SetAuthenticator(a auth.Authenticator) {
+	authenticator = a
 }
 
-// verifyFirebaseToken verifies a Firebase ID token and returns the user ID and email
-func verifyFirebaseToken(ctx context.Context, idToken string) (string, string, error) {
-	if firebaseAuth == nil {
-		return "", "", fmt.Errorf("Firebase not initialized")
-	}
-
-	token, err := firebaseAuth.VerifyIDToken(ctx, idToken)
-	if err != nil {
-		return "", "", fmt.Errorf("error verifying ID token: %v", err)
-	}
-
-	// Extract user ID (UID) from token
-	uid := token.UID
-
-	// Extract email from token claims
-	email, _ := token.Claims["email"].(string)
-
-	return uid, email, nil
+// InitAPITokens enables API token authentication in AuthMiddleware
+func InitAPITokens(tokenService *services.APITokenService) {
+	apiTokens = tokenService
 }
 
-// AuthMiddleware validates Firebase JWT tokens and sets user context
+// AuthMiddleware validates user tokens (via the configured Authenticator) or Lute API tokens and sets user context
 func AuthMiddleware(userRepo *repository.UserRepository) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
@@ -97,52 +51,65 @@ func AuthMiddleware(userRepo *repository.UserRepository) gin.HandlerFunc {
 			return
 		}
 
-		// Verify Firebase token and extract claims
+		// API tokens (automation) are recognized by their prefix
 		ctx := c.Request.Context()
-		firebaseUID, email, err := verifyFirebaseToken(ctx, token)
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token: " + err.Error()})
-			c.Abort()
+		if apiTokens != nil && services.IsAPIToken(token) {
+			authenticateAPIToken(c, userRepo, token)
 			return
 		}
 
-		// Look up or create user in MongoDB
-		user, err := userRepo.GetByFirebaseUID(ctx, firebaseUID)
+		// Verify the token with the configured provider (Firebase, OIDC or local session)
+		if authenticator == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token: authentication is not configured"})
+			c.Abort()
+			return
+		}
+		user, err := authenticator.Authenticate(ctx, token)
 		if err != nil {
-			if err == mongo.ErrNoDocuments {
-				// Create new user if doesn't exist
-				user = &models.User{
-					FirebaseUID: firebaseUID,
-					Email:       email,
-				}
-				if err := userRepo.Create(ctx, user); err != nil {
-					c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
-					c.Abort()
-					return
-				}
-				// Fetch the created user to get the ID
-				user, err = userRepo.GetByFirebaseUID(ctx, firebaseUID)
-				if err != nil {
-					c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch created user"})
-					c.Abort()
-					return
-				}
-			} else {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to lookup user"})
-				c.Abort()
-				return
-			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token: " + err.Error()})
+			c.Abort()
+			return
 		}
 
 		// Set user ID in context (MongoDB ObjectID as hex string)
 		c.Set("user_id", user.ID.Hex())
-		c.Set("firebase_uid", firebaseUID)
+		c.Set("auth_provider", authenticator.Name())
+		if user.FirebaseUID != "" {
+			c.Set("firebase_uid", user.FirebaseUID)
+		}
 		c.Set("token", token)
+		c.Request = c.Request.WithContext(audit.WithActor(ctx, audit.Actor{Type: audit.ActorUser, UserID: user.ID}))
 
 		c.Next()
 	}
 }
 
+// authenticateAPIToken authenticates a request made with an API token. The token's
+// scopes and organization are attached to the request context for authz.
+func authenticateAPIToken(c *gin.Context, userRepo *repository.UserRepository, token string) {
+	ctx := c.Request.Context()
+	apiToken, err := apiTokens.Authenticate(ctx, token)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token: " + err.Error()})
+		c.Abort()
+		return
+	}
+	user, err := userRepo.GetByID(ctx, apiToken.UserID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token: owner no longer exists"})
+		c.Abort()
+		return
+	}
+
+	c.Set("user_id", user.ID.Hex())
+	c.Set("api_token_id", apiToken.ID.Hex())
+	ctx = authz.WithTokenGrant(ctx, services.Grant(apiToken))
+	ctx = audit.WithActor(ctx, audit.Actor{Type: audit.ActorAPIToken, UserID: user.ID, TokenID: apiToken.ID})
+	c.Request = c.Request.WithContext(ctx)
+
+	c.Next()
+}
+
 // OptionalAuthMiddleware allows requests with or without auth
 func OptionalAuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/lute/api/audit"
+)
+
+// RequestIDHeader carries the request ID in requests and responses.
+const RequestIDHeader = "X-Request-ID"
+
+// RequestID assigns every request an ID (reusing a sane incoming X-Request-ID)
+// and attaches it, with the client IP and user agent, to the request context
+// for audit events.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if id == "" || len(id) > 128 {
+			b := make([]byte, 12)
+			_, _ = rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+		c.Set("request_id", id)
+		c.Header(RequestIDHeader, id)
+		c.Request = c.Request.WithContext(audit.WithRequest(c.Request.Context(), audit.RequestInfo{
+			ID:        id,
+			IP:        c.ClientIP(),
+			UserAgent: c.Request.UserAgent(),
+		}))
+		c.Next()
+	}
+}
@@ -28,35 +28,187 @@ func (b *BaseModel) BeforeUpdate() {
 	b.UpdatedAt = time.Now()
 }
 
-// User represents a user in the system
+// User represents a user in the system. Depending on the auth provider a user
+// is identified by FirebaseUID, OIDCSubject or (local accounts) PasswordHash.
 type User struct {
-	BaseModel        `bson:",inline"`
-	Email       string `json:"email" bson:"email"`
-	DisplayName string `json:"display_name" bson:"display_name"`
-	FirebaseUID string `json:"firebase_uid" bson:"firebase_uid"`
+	BaseModel    `bson:",inline"`
+	Email        string `json:"email" bson:"email"`
+	DisplayName  string `json:"display_name" bson:"display_name"`
+	FirebaseUID  string `json:"firebase_uid" bson:"firebase_uid"`
+	OIDCSubject  string `json:"-" bson:"oidc_subject,omitempty"`
+	PasswordHash string `json:"-" bson:"password_hash,omitempty"`
+}
+
+// Session is a login session of a local account. Only the SHA-256 of the token is stored.
+type Session struct {
+	BaseModel `bson:",inline"`
+	UserID    primitive.ObjectID `json:"user_id" bson:"user_id"`
+	TokenHash string             `json:"-" bson:"token_hash"`
+	ExpiresAt time.Time          `json:"expires_at" bson:"expires_at"`
 }
 
 // Machine represents a virtual machine with embedded agent data
 type Machine struct {
-	BaseModel        `bson:",inline"`
-	UserID       primitive.ObjectID     `json:"user_id" bson:"user_id"`
-	Name         string                 `json:"name" bson:"name"`
-	Description  string                 `json:"description" bson:"description"`
-	Status       string                 `json:"status" bson:"status"` // "pending", "registered", "alive", "dead"
-	IsPublic     bool                   `json:"is_public" bson:"is_public"`
-	Metadata     map[string]interface{} `json:"metadata,omitempty" bson:"metadata,omitempty"`
-	AgentIP      string                 `json:"agent_ip,omitempty" bson:"agent_ip,omitempty"`
-	AgentVersion string                 `json:"agent_version,omitempty" bson:"agent_version,omitempty"`
+	BaseModel      `bson:",inline"`
+	UserID         primitive.ObjectID     `json:"user_id" bson:"user_id"`
+	OrgID          primitive.ObjectID     `json:"org_id,omitempty" bson:"org_id,omitempty"` // set when the machine is owned by an organization
+	Name           string                 `json:"name" bson:"name"`
+	Description    string                 `json:"description" bson:"description"`
+	Status         string                 `json:"status" bson:"status"` // "pending", "registered", "alive", "dead"
+	IsPublic       bool                   `json:"is_public" bson:"is_public"`
+	Metadata       map[string]interface{} `json:"metadata,omitempty" bson:"metadata,omitempty"`
+	Labels         map[string]string      `json:"labels,omitempty" bson:"labels,omitempty"`
+	GroupIDs       []primitive.ObjectID   `json:"group_ids,omitempty" bson:"group_ids,omitempty"`
+	AgentIP        string                 `json:"agent_ip,omitempty" bson:"agent_ip,omitempty"`
+	AgentVersion   string                 `json:"agent_version,omitempty" bson:"agent_version,omitempty"`
 	LastSeen       time.Time              `json:"last_seen,omitempty" bson:"last_seen,omitempty"`
 	Metrics        map[string]interface{} `json:"metrics,omitempty" bson:"metrics,omitempty"`
 	HeartbeatRetry int                    `json:"-" bson:"heartbeat_retry"`
+	Host           *HostInfo              `json:"host,omitempty" bson:"host,omitempty"` // reported by the agent on every connect
+
+	// Central configuration (see MachineConfig): the heartbeat interval it
+	// sets, in seconds (0 for the server default), and what the agent
+	// acknowledged.
+	HeartbeatInterval int            `json:"heartbeat_interval,omitempty" bson:"heartbeat_interval,omitempty"`
+	AppliedConfig     *AppliedConfig `json:"applied_config,omitempty" bson:"applied_config,omitempty"`
+
+	// Agent token (see package agentauth). Only hashes are stored.
+	AgentTokenHash     string     `json:"-" bson:"agent_token_hash,omitempty"`
+	AgentTokenNextHash string     `json:"-" bson:"agent_token_next_hash,omitempty"` // handed out by a rotation, not yet used
+	AgentTokenIssuedAt *time.Time `json:"agent_token_issued_at,omitempty" bson:"agent_token_issued_at,omitempty"`
+	AgentTokenRotate   bool       `json:"agent_token_rotate,omitempty" bson:"agent_token_rotate,omitempty"` // rotate on next connect
+}
+
+// HostInfo is what the agent reported about itself and its host in the Hello
+// of its latest connection.
+type HostInfo struct {
+	OS              string    `json:"os,omitempty" bson:"os,omitempty"`
+	Arch            string    `json:"arch,omitempty" bson:"arch,omitempty"`
+	OSRelease       string    `json:"os_release,omitempty" bson:"os_release,omitempty"`
+	Kernel          string    `json:"kernel,omitempty" bson:"kernel,omitempty"`
+	IPAddresses     []string  `json:"ip_addresses,omitempty" bson:"ip_addresses,omitempty"`
+	BootID          string    `json:"boot_id,omitempty" bson:"boot_id,omitempty"`
+	BootedAt        time.Time `json:"booted_at,omitempty" bson:"booted_at,omitempty"`
+	ProtocolVersion int       `json:"protocol_version" bson:"protocol_version"`
+	Features        []string  `json:"features,omitempty" bson:"features,omitempty"` // negotiated, e.g. "self_update"
+	Actions         []string  `json:"actions,omitempty" bson:"actions,omitempty"`   // enabled by the agent's policy, e.g. "service-restart"
+}
+
+// MachineGroup is a user-defined, named set of machines. Membership is stored
+// on the machine (Machine.GroupIDs) so a machine can belong to several groups.
+// Groups of an organization (OrgID set) hold its machines and are managed
+// by its members according to their role; UserID is then their creator.
+type MachineGroup struct {
+	BaseModel   `bson:",inline"`
+	UserID      primitive.ObjectID `json:"user_id" bson:"user_id"`
+	OrgID       primitive.ObjectID `json:"org_id,omitempty" bson:"org_id,omitempty"`
+	Name        string             `json:"name" bson:"name" binding:"required"`
+	Description string             `json:"description,omitempty" bson:"description,omitempty"`
+}
+
+// Organization owns machines shared by a team. Access is granted through OrgMember roles.
+type Organization struct {
+	BaseModel `bson:",inline"`
+	Name      string             `json:"name" bson:"name"`
+	CreatedBy primitive.ObjectID `json:"created_by" bson:"created_by"`
+}
+
+// OrgMember links a user to an organization with a role ("owner", "admin", "operator", "viewer").
+type OrgMember struct {
+	BaseModel `bson:",inline"`
+	OrgID     primitive.ObjectID `json:"org_id" bson:"org_id"`
+	UserID    primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Email     string             `json:"email,omitempty" bson:"email,omitempty"`
+	Role      string             `json:"role" bson:"role"`
+}
+
+// OrgInvite is a pending invitation sent by email. Only the SHA-256 of the token is stored.
+type OrgInvite struct {
+	BaseModel  `bson:",inline"`
+	OrgID      primitive.ObjectID `json:"org_id" bson:"org_id"`
+	Email      string             `json:"email" bson:"email"`
+	Role       string             `json:"role" bson:"role"`
+	TokenHash  string             `json:"-" bson:"token_hash"`
+	InvitedBy  primitive.ObjectID `json:"invited_by" bson:"invited_by"`
+	ExpiresAt  time.Time          `json:"expires_at" bson:"expires_at"`
+	AcceptedAt *time.Time         `json:"accepted_at,omitempty" bson:"accepted_at,omitempty"`
+}
+
+// APIToken is a personal access token for automation. Only the SHA-256 of the
+// token is stored; Prefix keeps the first characters so users can tell tokens apart.
+// When OrgID is set the token can only reach that organization's machines.
+type APIToken struct {
+	BaseModel  `bson:",inline"`
+	UserID     primitive.ObjectID `json:"user_id" bson:"user_id"`
+	OrgID      primitive.ObjectID `json:"org_id,omitempty" bson:"org_id,omitempty"`
+	Name       string             `json:"name" bson:"name"`
+	Prefix     string             `json:"prefix" bson:"prefix"`
+	TokenHash  string             `json:"-" bson:"token_hash"`
+	Scopes     []string           `json:"scopes" bson:"scopes"` // "machines:read", "machines:write", "commands:execute", "alerts:manage", "audit:read"
+	ExpiresAt  *time.Time         `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+	LastUsedAt *time.Time         `json:"last_used_at,omitempty" bson:"last_used_at,omitempty"`
+	RevokedAt  *time.Time         `json:"revoked_at,omitempty" bson:"revoked_at,omitempty"`
+}
+
+// Enrollment token kinds. Claim codes are the short single-use tokens shown in
+// the Add Machine dialog; they are hidden from the token list.
+const (
+	EnrollmentKindToken     = "token"
+	EnrollmentKindClaimCode = "claim_code"
+)
+
+// EnrollmentToken lets agents register machines without a user session, e.g.
+// from cloud-init. Machines registered with it belong to UserID (or OrgID when
+// set) and receive its default labels and group. Only the SHA-256 of the token
+// is stored. MaxUses 0 means unlimited.
+type EnrollmentToken struct {
+	BaseModel  `bson:",inline"`
+	Kind       string             `json:"kind" bson:"kind"`
+	UserID     primitive.ObjectID `json:"user_id" bson:"user_id"`
+	OrgID      primitive.ObjectID `json:"org_id,omitempty" bson:"org_id,omitempty"`
+	Name       string             `json:"name" bson:"name"`
+	Prefix     string             `json:"prefix" bson:"prefix"`
+	TokenHash  string             `json:"-" bson:"token_hash"`
+	Labels     map[string]string  `json:"labels,omitempty" bson:"labels,omitempty"`
+	GroupID    primitive.ObjectID `json:"group_id,omitempty" bson:"group_id,omitempty"`
+	MaxUses    int                `json:"max_uses" bson:"max_uses"`
+	Uses       int                `json:"uses" bson:"uses"`
+	ExpiresAt  *time.Time         `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+	LastUsedAt *time.Time         `json:"last_used_at,omitempty" bson:"last_used_at,omitempty"`
+	RevokedAt  *time.Time         `json:"revoked_at,omitempty" bson:"revoked_at,omitempty"`
+}
+
+// AgentRollout sets the agent version machines should run. It applies to the
+// listed machines and groups, or to every machine of its owner (OrgID, else
+// the creator's personal machines) when both are empty. Percent limits it to
+// a share of those machines, picked by a stable hash of the machine ID, so
+// raising it only adds machines. When several rollouts match, the newest wins.
+type AgentRollout struct {
+	BaseModel  `bson:",inline"`
+	UserID     primitive.ObjectID   `json:"user_id" bson:"user_id"`
+	OrgID      primitive.ObjectID   `json:"org_id,omitempty" bson:"org_id,omitempty"`
+	Version    string               `json:"version" bson:"version"`
+	MachineIDs []primitive.ObjectID `json:"machine_ids,omitempty" bson:"machine_ids,omitempty"`
+	GroupIDs   []primitive.ObjectID `json:"group_ids,omitempty" bson:"group_ids,omitempty"`
+	Percent    int                  `json:"percent" bson:"percent"` // 1-100
+}
+
+// AgentCertificate records a client certificate issued to a machine's agent
+// for mutual TLS. Connections presenting a revoked or unknown serial are refused.
+type AgentCertificate struct {
+	BaseModel `bson:",inline"`
+	MachineID primitive.ObjectID `json:"machine_id" bson:"machine_id"`
+	Serial    string             `json:"serial" bson:"serial"` // hex
+	NotBefore time.Time          `json:"not_before" bson:"not_before"`
+	NotAfter  time.Time          `json:"not_after" bson:"not_after"`
+	RevokedAt *time.Time         `json:"revoked_at,omitempty" bson:"revoked_at,omitempty"`
 }
 
 // Agent model has been removed - agent data is now embedded in Machine
 
 // Command represents a queued command for an agent to execute
 type Command struct {
-	BaseModel        `bson:",inline"`
+	BaseModel `bson:",inline"`
 	MachineID primitive.ObjectID `json:"machine_id" bson:"machine_id"`
 	Command   string             `json:"command" bson:"command"`
 	Args      []string           `json:"args,omitempty" bson:"args,omitempty"`
@@ -65,15 +217,77 @@ type Command struct {
 	Output    string             `json:"output,omitempty" bson:"output,omitempty"`
 	ExitCode  int                `json:"exit_code" bson:"exit_code"`
 	Error     string             `json:"error,omitempty" bson:"error,omitempty"`
+
+	// Typed actions (see ActionService) name the action and its parameters;
+	// Command then describes it, e.g. "systemctl restart nginx.service".
+	Action         string             `json:"action,omitempty" bson:"action,omitempty"`
+	Params         map[string]string  `json:"params,omitempty" bson:"params,omitempty"`
+	TimeoutSeconds int                `json:"timeout_seconds,omitempty" bson:"timeout_seconds,omitempty"`
+	RequestedBy    primitive.ObjectID `json:"requested_by,omitempty" bson:"requested_by,omitempty"`
+	StartedAt      *time.Time         `json:"started_at,omitempty" bson:"started_at,omitempty"`
+	FinishedAt     *time.Time         `json:"finished_at,omitempty" bson:"finished_at,omitempty"`
+	JobID          primitive.ObjectID `json:"job_id,omitempty" bson:"job_id,omitempty"` // set for actions run by a Job
 }
 
-// MachineConfig holds configuration for a machine/agent
+// MachineConfig is agent configuration set centrally, for one machine
+// (MachineID) or for the machines of a group (GroupID). A machine runs with
+// the settings of its groups' configs, oldest first, overridden by its own;
+// settings none of them set keep the agent's local value. Version counts
+// the changes of the document.
 type MachineConfig struct {
-	BaseModel        `bson:",inline"`
-	MachineID         primitive.ObjectID `json:"machine_id" bson:"machine_id"`
-	HeartbeatInterval int                `json:"heartbeat_interval" bson:"heartbeat_interval"` // seconds
-	LogLevel          string             `json:"log_level" bson:"log_level"`                   // "debug", "info", "warn", "error"
-	Extra             map[string]string  `json:"extra,omitempty" bson:"extra,omitempty"`
+	BaseModel     `bson:",inline"`
+	MachineID     primitive.ObjectID `json:"machine_id,omitempty" bson:"machine_id,omitempty"`
+	GroupID       primitive.ObjectID `json:"group_id,omitempty" bson:"group_id,omitempty"`
+	AgentSettings `bson:",inline"`
+	Version       int64              `json:"version" bson:"version"`
+	UpdatedBy     primitive.ObjectID `json:"updated_by,omitempty" bson:"updated_by,omitempty"`
+}
+
+// AgentSettings are the settings a MachineConfig may set. Zero values are
+// unset. All but HeartbeatInterval are pushed to the agent.
+type AgentSettings struct {
+	HeartbeatInterval     int               `json:"heartbeat_interval,omitempty" bson:"heartbeat_interval,omitempty"`           // seconds; how often the server pings the agent
+	LogLevel              string            `json:"log_level,omitempty" bson:"log_level,omitempty"`                             // "debug", "info", "warn", "error"
+	Collectors            []string          `json:"collectors,omitempty" bson:"collectors,omitempty"`                           // metrics sent with heartbeats: "cpu", "memory", "disk"
+	OfflineSampleInterval int               `json:"offline_sample_interval,omitempty" bson:"offline_sample_interval,omitempty"` // seconds
+	InventoryInterval     int               `json:"inventory_interval,omitempty" bson:"inventory_interval,omitempty"`           // seconds
+	UnitsInterval         int               `json:"units_interval,omitempty" bson:"units_interval,omitempty"`                   // seconds
+	Extra                 map[string]string `json:"extra,omitempty" bson:"extra,omitempty"`
+}
+
+// AppliedConfig is the agent's acknowledgement of the config last pushed
+// to it.
+type AppliedConfig struct {
+	Version   string    `json:"version" bson:"version"` // see ConfigPusher: a hash of the pushed settings
+	AppliedAt time.Time `json:"applied_at" bson:"applied_at"`
+	Error     string    `json:"error,omitempty" bson:"error,omitempty"` // settings the agent could not apply
+}
+
+// AuditEvent is an append-only record of an action taken by a user, an API
+// token or an agent. OrgID/OwnerID identify who may read the event.
+type AuditEvent struct {
+	ID         primitive.ObjectID     `json:"id" bson:"_id,omitempty"`
+	At         time.Time              `json:"at" bson:"at"`
+	ActorType  string                 `json:"actor_type" bson:"actor_type"` // "user", "api_token", "agent", "system"
+	ActorID    primitive.ObjectID     `json:"actor_id,omitempty" bson:"actor_id,omitempty"`
+	TokenID    primitive.ObjectID     `json:"token_id,omitempty" bson:"token_id,omitempty"`
+	Action     string                 `json:"action" bson:"action"` // e.g. "machine.delete", "command.send"
+	TargetType string                 `json:"target_type,omitempty" bson:"target_type,omitempty"`
+	TargetID   primitive.ObjectID     `json:"target_id,omitempty" bson:"target_id,omitempty"`
+	TargetName string                 `json:"target_name,omitempty" bson:"target_name,omitempty"`
+	OrgID      primitive.ObjectID     `json:"org_id,omitempty" bson:"org_id,omitempty"`
+	OwnerID    primitive.ObjectID     `json:"owner_id,omitempty" bson:"owner_id,omitempty"`
+	RequestID  string                 `json:"request_id,omitempty" bson:"request_id,omitempty"`
+	IP         string                 `json:"ip,omitempty" bson:"ip,omitempty"`
+	UserAgent  string                 `json:"user_agent,omitempty" bson:"user_agent,omitempty"`
+	Changes    map[string]AuditChange `json:"changes,omitempty" bson:"changes,omitempty"`
+	Details    map[string]interface{} `json:"details,omitempty" bson:"details,omitempty"`
+}
+
+// AuditChange is the before/after value of one field changed by an action.
+type AuditChange struct {
+	Before interface{} `json:"before" bson:"before"`
+	After  interface{} `json:"after" bson:"after"`
 }
 
 // UptimeSnapshot is a per-user snapshot of machine counts at a point in time (for dashboard uptime graph).
@@ -85,10 +299,460 @@ type UptimeSnapshot struct {
 	Total  int                `json:"total" bson:"total"`
 }
 
+// SnapshotMetrics are the canonical metric keys of snapshots (must match
+// heartbeat_checker and agent).
+var SnapshotMetrics = []string{"cpu_load", "mem_usage_mb", "disk_used_gb", "disk_total_gb"}
+
 // MachineSnapshot is a per-machine point-in-time snapshot (canonical metrics, same keys as Machine.Metrics).
 // Only written when the machine is alive; gaps in the time-series represent downtime.
+// Backfilled snapshots were sampled by the agent while it could not reach the server.
 type MachineSnapshot struct {
+	MachineID  primitive.ObjectID     `json:"machine_id" bson:"machine_id"`
+	At         time.Time              `json:"at" bson:"at"`
+	Metrics    map[string]interface{} `json:"metrics,omitempty" bson:"metrics,omitempty"` // cpu_load, mem_usage_mb, disk_used_gb, disk_total_gb
+	Backfilled bool                   `json:"backfilled,omitempty" bson:"backfilled,omitempty"`
+}
+
+// SnapshotRollup summarizes the snapshots of a machine over one minute or
+// one hour starting at At: their count and, per canonical metric, the
+// lowest, average and highest value. Rollups are kept longer than the
+// snapshots and serve the charts of longer periods. Chart buckets have the
+// same shape, over all the machines charted, without MachineID.
+type SnapshotRollup struct {
+	MachineID primitive.ObjectID     `json:"machine_id,omitempty" bson:"machine_id,omitempty"`
+	At        time.Time              `json:"at" bson:"at"`
+	Count     int                    `json:"count" bson:"count"`
+	Metrics   map[string]MetricStats `json:"metrics" bson:"metrics"`
+}
+
+// MetricStats are the lowest, average and highest value of a metric.
+type MetricStats struct {
+	Min float64 `json:"min" bson:"min"`
+	Avg float64 `json:"avg" bson:"avg"`
+	Max float64 `json:"max" bson:"max"`
+}
+
+// MachineInventory is the latest software and hardware inventory an agent
+// reported. There is one per machine; earlier states are kept as
+// InventoryChange records.
+type MachineInventory struct {
+	MachineID         primitive.ObjectID `json:"machine_id" bson:"machine_id"`
+	Hash              string             `json:"hash" bson:"hash"`
+	CollectedAt       time.Time          `json:"collected_at" bson:"collected_at"`
+	ReceivedAt        time.Time          `json:"received_at" bson:"received_at"`
+	OSRelease         string             `json:"os_release,omitempty" bson:"os_release,omitempty"`
+	OSID              string             `json:"os_id,omitempty" bson:"os_id,omitempty"`                 // os-release ID, e.g. "debian"
+	OSVersionID       string             `json:"os_version_id,omitempty" bson:"os_version_id,omitempty"` // os-release VERSION_ID, e.g. "12"
+	Kernel            string             `json:"kernel,omitempty" bson:"kernel,omitempty"`
+	Hardware          InventoryHardware  `json:"hardware" bson:"hardware"`
+	Packages          []InstalledPackage `json:"packages" bson:"packages"`
+	NetworkInterfaces []NetworkInterface `json:"network_interfaces,omitempty" bson:"network_interfaces,omitempty"`
+	BlockDevices      []BlockDevice      `json:"block_devices,omitempty" bson:"block_devices,omitempty"`
+}
+
+// InstalledPackage is a package as recorded by the machine's package manager.
+// Format ("deb", "rpm" or "apk") selects how versions compare (see package pkgversion).
+type InstalledPackage struct {
+	Name    string `json:"name" bson:"name"`
+	Version string `json:"version" bson:"version"`
+	Arch    string `json:"arch,omitempty" bson:"arch,omitempty"`
+	Format  string `json:"format" bson:"format"`
+	Source  string `json:"source,omitempty" bson:"source,omitempty"` // source package, when named differently
+}
+
+// InventoryHardware is the CPU, memory and DMI system information of a machine.
+type InventoryHardware struct {
+	CPUModel       string `json:"cpu_model,omitempty" bson:"cpu_model,omitempty"`
+	CPUCount       int    `json:"cpu_count,omitempty" bson:"cpu_count,omitempty"`
+	MemoryBytes    int64  `json:"memory_bytes,omitempty" bson:"memory_bytes,omitempty"`
+	SystemVendor   string `json:"system_vendor,omitempty" bson:"system_vendor,omitempty"`
+	ProductName    string `json:"product_name,omitempty" bson:"product_name,omitempty"`
+	ProductVersion string `json:"product_version,omitempty" bson:"product_version,omitempty"`
+	BIOSVendor     string `json:"bios_vendor,omitempty" bson:"bios_vendor,omitempty"`
+	BIOSVersion    string `json:"bios_version,omitempty" bson:"bios_version,omitempty"`
+	BoardVendor    string `json:"board_vendor,omitempty" bson:"board_vendor,omitempty"`
+	BoardName      string `json:"board_name,omitempty" bson:"board_name,omitempty"`
+}
+
+// NetworkInterface is a network interface with a hardware address.
+type NetworkInterface struct {
+	Name      string   `json:"name" bson:"name"`
+	MAC       string   `json:"mac" bson:"mac"`
+	MTU       int      `json:"mtu,omitempty" bson:"mtu,omitempty"`
+	Addresses []string `json:"addresses,omitempty" bson:"addresses,omitempty"`
+}
+
+// BlockDevice is a whole disk (partitions and virtual devices are not listed).
+type BlockDevice struct {
+	Name       string `json:"name" bson:"name"`
+	SizeBytes  int64  `json:"size_bytes" bson:"size_bytes"`
+	Model      string `json:"model,omitempty" bson:"model,omitempty"`
+	Rotational bool   `json:"rotational" bson:"rotational"`
+	Removable  bool   `json:"removable" bson:"removable"`
+}
+
+// InventoryChange records how a machine's inventory differed from the one
+// before it. The first inventory of a machine is recorded with Initial set
+// and no package lists.
+type InventoryChange struct {
+	ID        primitive.ObjectID     `json:"id" bson:"_id,omitempty"`
 	MachineID primitive.ObjectID     `json:"machine_id" bson:"machine_id"`
 	At        time.Time              `json:"at" bson:"at"`
-	Metrics   map[string]interface{} `json:"metrics,omitempty" bson:"metrics,omitempty"` // cpu_load, mem_usage_mb, disk_used_gb, disk_total_gb
+	Hash      string                 `json:"hash" bson:"hash"`
+	Initial   bool                   `json:"initial,omitempty" bson:"initial,omitempty"`
+	Added     []InstalledPackage     `json:"added,omitempty" bson:"added,omitempty"`
+	Removed   []InstalledPackage     `json:"removed,omitempty" bson:"removed,omitempty"`
+	Updated   []PackageUpdate        `json:"updated,omitempty" bson:"updated,omitempty"`
+	Changes   map[string]AuditChange `json:"changes,omitempty" bson:"changes,omitempty"` // os_release, kernel, hardware, network_interfaces, block_devices
+}
+
+// PackageUpdate is a package whose version changed between two inventories.
+type PackageUpdate struct {
+	Name   string `json:"name" bson:"name"`
+	Arch   string `json:"arch,omitempty" bson:"arch,omitempty"`
+	Format string `json:"format" bson:"format"`
+	From   string `json:"from" bson:"from"`
+	To     string `json:"to" bson:"to"`
+}
+
+// Advisory is a vulnerability record imported from an offline OSV feed. Only
+// the packages of distributions Lute can match against are kept.
+type Advisory struct {
+	ID         string            `json:"id" bson:"_id"`    // OSV ID, e.g. "DSA-5532-1" or "CVE-2023-5363"
+	CVEs       []string          `json:"cves" bson:"cves"` // CVE IDs it covers; the advisory ID if it names none
+	Summary    string            `json:"summary,omitempty" bson:"summary,omitempty"`
+	Severity   string            `json:"severity" bson:"severity"`               // "critical", "high", "medium", "low" or "unknown"
+	Score      float64           `json:"score,omitempty" bson:"score,omitempty"` // CVSS v3 base score, when the feed has a vector
+	Published  time.Time         `json:"published,omitempty" bson:"published,omitempty"`
+	Modified   time.Time         `json:"modified,omitempty" bson:"modified,omitempty"`
+	Affected   []AffectedPackage `json:"affected" bson:"affected"`
+	ImportedAt time.Time         `json:"imported_at" bson:"imported_at"`
+}
+
+// AffectedPackage is a package of one distribution release an advisory
+// applies to. A version is affected when it is listed in Versions or falls
+// in one of Ranges.
+type AffectedPackage struct {
+	Distro   string          `json:"distro" bson:"distro"` // e.g. "debian:12", "ubuntu:22.04", "alpine:3.19", "rocky:9"
+	Package  string          `json:"package" bson:"package"`
+	Ranges   []AffectedRange `json:"ranges,omitempty" bson:"ranges,omitempty"`
+	Versions []string        `json:"versions,omitempty" bson:"versions,omitempty"`
+}
+
+// AffectedRange is a span of affected versions: from Introduced ("" or "0"
+// for the first version) up to, but not including, Fixed, or up to and
+// including LastAffected. With neither set, every later version is affected.
+type AffectedRange struct {
+	Introduced   string `json:"introduced,omitempty" bson:"introduced,omitempty"`
+	Fixed        string `json:"fixed,omitempty" bson:"fixed,omitempty"`
+	LastAffected string `json:"last_affected,omitempty" bson:"last_affected,omitempty"`
+}
+
+// VulnerabilityFinding is an installed package of a machine that an advisory
+// applies to. Findings are recomputed when the machine reports a new
+// inventory and when the advisory feed is imported.
+type VulnerabilityFinding struct {
+	ID           primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	MachineID    primitive.ObjectID `json:"machine_id" bson:"machine_id"`
+	AdvisoryID   string             `json:"advisory_id" bson:"advisory_id"`
+	CVEs         []string           `json:"cves" bson:"cves"`
+	Severity     string             `json:"severity" bson:"severity"`
+	Score        float64            `json:"score,omitempty" bson:"score,omitempty"`
+	Summary      string             `json:"summary,omitempty" bson:"summary,omitempty"`
+	Package      InstalledPackage   `json:"package" bson:"package"`
+	FixedVersion string             `json:"fixed_version,omitempty" bson:"fixed_version,omitempty"` // empty when no fix is known
+	DetectedAt   time.Time          `json:"detected_at" bson:"detected_at"`
+}
+
+// File changes reported by file integrity monitoring.
+const (
+	FileChangeAdded    = "added"
+	FileChangeRemoved  = "removed"
+	FileChangeModified = "modified"
+)
+
+// FileEvent is a change to a watched file reported by an agent. Events stay
+// unacknowledged until someone confirms the change was expected.
+type FileEvent struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	MachineID  primitive.ObjectID `json:"machine_id" bson:"machine_id"`
+	Seq        uint64             `json:"-" bson:"seq"` // agent's queue position, to drop redelivered events
+	Change     string             `json:"change" bson:"change"`
+	Path       string             `json:"path" bson:"path"`
+	Before     *FileState         `json:"before,omitempty" bson:"before,omitempty"` // nil for added files
+	After      *FileState         `json:"after,omitempty" bson:"after,omitempty"`   // nil for removed files
+	Realtime   bool               `json:"realtime" bson:"realtime"`                 // seen by inotify rather than a scan
+	DetectedAt time.Time          `json:"detected_at" bson:"detected_at"`
+	ReceivedAt time.Time          `json:"received_at" bson:"received_at"`
+
+	Acknowledged   bool                `json:"acknowledged" bson:"acknowledged"`
+	AcknowledgedBy *primitive.ObjectID `json:"acknowledged_by,omitempty" bson:"acknowledged_by,omitempty"`
+	AcknowledgedAt *time.Time          `json:"acknowledged_at,omitempty" bson:"acknowledged_at,omitempty"`
+	AckNote        string              `json:"ack_note,omitempty" bson:"ack_note,omitempty"`
+}
+
+// FileState is what the agent recorded about a file.
+type FileState struct {
+	SHA256     string `json:"sha256,omitempty" bson:"sha256,omitempty"` // empty for directories and files too large to hash
+	Mode       uint32 `json:"mode" bson:"mode"`                         // st_mode of stat(2)
+	Permission string `json:"permissions" bson:"permissions"`           // as shown by ls, e.g. "-rwsr-xr-x"
+	UID        uint32 `json:"uid" bson:"uid"`
+	GID        uint32 `json:"gid" bson:"gid"`
+	Owner      string `json:"owner,omitempty" bson:"owner,omitempty"`
+	Group      string `json:"group,omitempty" bson:"group,omitempty"`
+	Size       int64  `json:"size" bson:"size"`
+	MTime      int64  `json:"mtime" bson:"mtime"`
+	LinkTarget string `json:"link_target,omitempty" bson:"link_target,omitempty"`
+}
+
+// MachineUnits is the latest state of a machine's systemd units: the units
+// its agent is configured to watch and every failed unit.
+type MachineUnits struct {
+	ID          primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+	MachineID   primitive.ObjectID `json:"machine_id" bson:"machine_id"`
+	Hash        string             `json:"-" bson:"hash"`
+	Systemd     bool               `json:"systemd" bson:"systemd"` // false when the host does not run systemd
+	CollectedAt time.Time          `json:"collected_at" bson:"collected_at"`
+	ReceivedAt  time.Time          `json:"received_at" bson:"received_at"`
+	Units       []SystemdUnit      `json:"units" bson:"units"`
+}
+
+// SystemdUnit is the state of one systemd unit.
+type SystemdUnit struct {
+	Name           string     `json:"name" bson:"name"`
+	Description    string     `json:"description,omitempty" bson:"description,omitempty"`
+	LoadState      string     `json:"load_state" bson:"load_state"`     // loaded, not-found, masked, ...
+	ActiveState    string     `json:"active_state" bson:"active_state"` // active, inactive, failed, ...
+	SubState       string     `json:"sub_state" bson:"sub_state"`       // running, exited, dead, ...
+	Result         string     `json:"result,omitempty" bson:"result,omitempty"`
+	Restarts       uint32     `json:"restarts" bson:"restarts"`
+	StateChangedAt *time.Time `json:"state_changed_at,omitempty" bson:"state_changed_at,omitempty"`
+	MainPID        uint32     `json:"main_pid,omitempty" bson:"main_pid,omitempty"`
+}
+
+// Alert rule kinds.
+const (
+	AlertKindUnitState = "unit_state"
+)
+
+// Alert states.
+const (
+	AlertStatusFiring   = "firing"
+	AlertStatusResolved = "resolved"
+)
+
+// AlertRule raises an alert for every machine of its owner (OrgID, else the
+// creator's personal machines) on which its condition holds, optionally only
+// machines matching Selector. A unit_state rule matches units named Unit (a
+// glob) whose active state is one of States or that restarted at least
+// MinRestarts times.
+type AlertRule struct {
+	BaseModel   `bson:",inline"`
+	UserID      primitive.ObjectID `json:"user_id" bson:"user_id"`
+	OrgID       primitive.ObjectID `json:"org_id,omitempty" bson:"org_id,omitempty"`
+	Name        string             `json:"name" bson:"name"`
+	Kind        string             `json:"kind" bson:"kind"`
+	Selector    string             `json:"selector,omitempty" bson:"selector,omitempty"` // machine labels, e.g. "role=db"
+	Unit        string             `json:"unit" bson:"unit"`                             // e.g. "nginx.service" or "*"
+	States      []string           `json:"states,omitempty" bson:"states,omitempty"`     // e.g. ["failed"]
+	MinRestarts uint32             `json:"min_restarts,omitempty" bson:"min_restarts,omitempty"`
+	Notify      []string           `json:"notify,omitempty" bson:"notify,omitempty"` // email addresses told when alerts fire and resolve
+	Disabled    bool               `json:"disabled" bson:"disabled"`
+}
+
+// Alert is a condition of an alert rule that held on a machine. It fires
+// once and stays firing until the condition no longer holds.
+type Alert struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	RuleID     primitive.ObjectID `json:"rule_id" bson:"rule_id"`
+	RuleName   string             `json:"rule_name" bson:"rule_name"`
+	Kind       string             `json:"kind" bson:"kind"`
+	MachineID  primitive.ObjectID `json:"machine_id" bson:"machine_id"`
+	Subject    string             `json:"subject" bson:"subject"` // what the condition held for, e.g. the unit name
+	Message    string             `json:"message" bson:"message"`
+	Status     string             `json:"status" bson:"status"`
+	FiredAt    time.Time          `json:"fired_at" bson:"fired_at"`
+	ResolvedAt *time.Time         `json:"resolved_at,omitempty" bson:"resolved_at,omitempty"`
+}
+
+// File transfer directions and states.
+const (
+	FileTransferUpload   = "upload"   // to the machine
+	FileTransferDownload = "download" // from the machine
+
+	FileTransferPending   = "pending"
+	FileTransferRunning   = "running"
+	FileTransferCompleted = "completed"
+	FileTransferFailed    = "failed"
+)
+
+// FileTransfer is a file copied to or from a machine. The content is kept
+// as FileChunks; Received is how much of it the receiving side holds.
+type FileTransfer struct {
+	BaseModel   `bson:",inline"`
+	MachineID   primitive.ObjectID `json:"machine_id" bson:"machine_id"`
+	Direction   string             `json:"direction" bson:"direction"`
+	Path        string             `json:"path" bson:"path"`
+	Mode        uint32             `json:"mode,omitempty" bson:"mode,omitempty"` // uploads: permission bits, 0 to keep those of the file replaced
+	Size        int64              `json:"size" bson:"size"`                     // downloads: known once the agent starts sending
+	SHA256      string             `json:"sha256,omitempty" bson:"sha256,omitempty"`
+	Received    int64              `json:"received" bson:"received"`
+	Status      string             `json:"status" bson:"status"`
+	Error       string             `json:"error,omitempty" bson:"error,omitempty"`
+	RequestedBy primitive.ObjectID `json:"requested_by" bson:"requested_by"`
+	CompletedAt *time.Time         `json:"completed_at,omitempty" bson:"completed_at,omitempty"`
+}
+
+// FileChunk is part of the content of a FileTransfer, starting at Offset.
+type FileChunk struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty"`
+	TransferID primitive.ObjectID `bson:"transfer_id"`
+	Offset     int64              `bson:"offset"`
+	Data       []byte             `bson:"data"`
+	CreatedAt  time.Time          `bson:"created_at"`
+}
+
+// Job states and what a job does with machines whose agent is offline.
+const (
+	JobRunning   = "running"
+	JobCompleted = "completed"
+	JobStopped   = "stopped"   // the failure threshold was reached
+	JobCancelled = "cancelled" // by a user
+
+	JobOfflineQueue = "queue" // wait for the agent to connect, up to an hour
+	JobOfflineSkip  = "skip"
+)
+
+// States of a machine in a job.
+const (
+	JobMachinePending   = "pending" // not started yet
+	JobMachineQueued    = "queued"  // command created, not yet sent to the agent
+	JobMachineRunning   = "running" // sent to the agent
+	JobMachineSucceeded = "succeeded"
+	JobMachineFailed    = "failed"
+	JobMachineTimedOut  = "timed_out"
+	JobMachineOffline   = "offline"   // skipped, or its agent did not connect in time
+	JobMachineCancelled = "cancelled" // not started because the job stopped
+)
+
+// Job runs a typed action on the machines of its owner (OrgID, else the
+// creator's personal machines) matching Selector and GroupID when it was
+// created. Machines are run in batches of BatchSize, one batch after the
+// other, with at most Concurrency commands running at a time; the job stops
+// starting new ones once StopAfterFailures machines failed or timed out.
+type Job struct {
+	BaseModel         `bson:",inline"`
+	UserID            primitive.ObjectID `json:"user_id" bson:"user_id"`
+	OrgID             primitive.ObjectID `json:"org_id,omitempty" bson:"org_id,omitempty"`
+	Name              string             `json:"name" bson:"name"`
+	Action            string             `json:"action" bson:"action"`
+	Params            map[string]string  `json:"params,omitempty" bson:"params,omitempty"`
+	Args              []string           `json:"args,omitempty" bson:"args,omitempty"`
+	TimeoutSeconds    int                `json:"timeout_seconds,omitempty" bson:"timeout_seconds,omitempty"`
+	Selector          string             `json:"selector,omitempty" bson:"selector,omitempty"`
+	GroupID           primitive.ObjectID `json:"group_id,omitempty" bson:"group_id,omitempty"`
+	Concurrency       int                `json:"concurrency" bson:"concurrency"`                 // 0 for no limit
+	BatchSize         int                `json:"batch_size" bson:"batch_size"`                   // 0 for a single batch
+	StopAfterFailures int                `json:"stop_after_failures" bson:"stop_after_failures"` // 0 never stops
+	Offline           string             `json:"offline" bson:"offline"`
+	Status            string             `json:"status" bson:"status"`
+	StopReason        string             `json:"stop_reason,omitempty" bson:"stop_reason,omitempty"`
+	CancelRequested   bool               `json:"-" bson:"cancel_requested,omitempty"`
+	Summary           JobSummary         `json:"summary" bson:"summary"`
+	Machines          []JobMachine       `json:"machines,omitempty" bson:"machines"`
+	FinishedAt        *time.Time         `json:"finished_at,omitempty" bson:"finished_at,omitempty"`
+	// Revision guards against concurrent updates of Machines.
+	Revision int64 `json:"-" bson:"revision"`
+}
+
+// JobMachine is a machine of a job and the outcome of its command.
+type JobMachine struct {
+	MachineID   primitive.ObjectID `json:"machine_id" bson:"machine_id"`
+	MachineName string             `json:"machine_name" bson:"machine_name"`
+	Batch       int                `json:"batch" bson:"batch"`
+	Status      string             `json:"status" bson:"status"`
+	CommandID   primitive.ObjectID `json:"command_id,omitempty" bson:"command_id,omitempty"`
+	ExitCode    int                `json:"exit_code" bson:"exit_code"`
+	Error       string             `json:"error,omitempty" bson:"error,omitempty"`
+	Output      string             `json:"output,omitempty" bson:"-"` // filled in from the command for the job detail
+	QueuedAt    *time.Time         `json:"queued_at,omitempty" bson:"queued_at,omitempty"`
+	StartedAt   *time.Time         `json:"started_at,omitempty" bson:"started_at,omitempty"`
+	FinishedAt  *time.Time         `json:"finished_at,omitempty" bson:"finished_at,omitempty"`
+}
+
+// JobSummary counts the machines of a job by state.
+type JobSummary struct {
+	Total     int `json:"total" bson:"total"`
+	Pending   int `json:"pending" bson:"pending"`
+	Queued    int `json:"queued" bson:"queued"`
+	Running   int `json:"running" bson:"running"`
+	Succeeded int `json:"succeeded" bson:"succeeded"`
+	Failed    int `json:"failed" bson:"failed"`
+	TimedOut  int `json:"timed_out" bson:"timed_out"`
+	Offline   int `json:"offline" bson:"offline"`
+	Cancelled int `json:"cancelled" bson:"cancelled"`
+}
+
+// What a schedule does with runs that fell due while no API instance was
+// running (or that are late by more than a few minutes for another reason).
+const (
+	ScheduleMissedSkip    = "skip"     // record them as skipped
+	ScheduleMissedRunOnce = "run_once" // run once for all of them
+)
+
+// Outcomes of a schedule run.
+const (
+	ScheduleRunStarted = "started" // the command or job was created
+	ScheduleRunFailed  = "failed"  // it could not be created
+	ScheduleRunSkipped = "skipped" // missed, and skipped by the missed-run policy
+)
+
+// Schedule runs a typed action whenever its cron expression fires in its
+// time zone: as a Command on MachineID, or otherwise as a Job on the
+// machines of its owner matching Selector and GroupID at that time. Runs
+// are made on behalf of the creator and need their permission then.
+type Schedule struct {
+	BaseModel         `bson:",inline"`
+	UserID            primitive.ObjectID `json:"user_id" bson:"user_id"`
+	OrgID             primitive.ObjectID `json:"org_id,omitempty" bson:"org_id,omitempty"`
+	Name              string             `json:"name" bson:"name"`
+	Cron              string             `json:"cron" bson:"cron"`
+	Timezone          string             `json:"timezone" bson:"timezone"` // IANA name, e.g. "Europe/Berlin"
+	Enabled           bool               `json:"enabled" bson:"enabled"`
+	MissedRuns        string             `json:"missed_runs" bson:"missed_runs"`
+	Action            string             `json:"action" bson:"action"`
+	Params            map[string]string  `json:"params,omitempty" bson:"params,omitempty"`
+	Args              []string           `json:"args,omitempty" bson:"args,omitempty"`
+	TimeoutSeconds    int                `json:"timeout_seconds,omitempty" bson:"timeout_seconds,omitempty"`
+	MachineID         primitive.ObjectID `json:"machine_id,omitempty" bson:"machine_id,omitempty"`
+	Selector          string             `json:"selector,omitempty" bson:"selector,omitempty"`
+	GroupID           primitive.ObjectID `json:"group_id,omitempty" bson:"group_id,omitempty"`
+	Concurrency       int                `json:"concurrency,omitempty" bson:"concurrency,omitempty"`
+	BatchSize         int                `json:"batch_size,omitempty" bson:"batch_size,omitempty"`
+	StopAfterFailures int                `json:"stop_after_failures,omitempty" bson:"stop_after_failures,omitempty"`
+	Offline           string             `json:"offline,omitempty" bson:"offline,omitempty"`
+	// TokenID is the API token the schedule was last created or changed
+	// with, if any; its runs are limited to the token's organization and
+	// scopes.
+	TokenID primitive.ObjectID `json:"token_id,omitempty" bson:"token_id,omitempty"`
+	// NextRunAt is when the schedule fires next; unset while it is disabled.
+	// Runners claim a run by moving it on, so only one of them makes it.
+	NextRunAt     *time.Time `json:"next_run_at,omitempty" bson:"next_run_at,omitempty"`
+	LastRunAt     *time.Time `json:"last_run_at,omitempty" bson:"last_run_at,omitempty"`
+	LastRunStatus string     `json:"last_run_status,omitempty" bson:"last_run_status,omitempty"`
+}
+
+// ScheduleRun records one run of a schedule.
+type ScheduleRun struct {
+	ID           primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	ScheduleID   primitive.ObjectID `json:"schedule_id" bson:"schedule_id"`
+	ScheduledFor time.Time          `json:"scheduled_for" bson:"scheduled_for"`
+	At           time.Time          `json:"at" bson:"at"`
+	Status       string             `json:"status" bson:"status"`
+	// Missed counts the later runs that also fell due before this one was
+	// handled and were folded into it.
+	Missed    int                `json:"missed,omitempty" bson:"missed,omitempty"`
+	CommandID primitive.ObjectID `json:"command_id,omitempty" bson:"command_id,omitempty"`
+	JobID     primitive.ObjectID `json:"job_id,omitempty" bson:"job_id,omitempty"`
+	Error     string             `json:"error,omitempty" bson:"error,omitempty"`
 }
@@ -0,0 +1,388 @@
+// Package pkgversion compares package versions the way the package manager
+// that installed them does. The formats are the ones agents report in their
+// inventory: "deb" (dpkg), "rpm" and "apk".
+package pkgversion
+
+import (
+	"strings"
+)
+
+// Package formats.
+const (
+	Deb = "deb"
+	RPM = "rpm"
+	Apk = "apk"
+)
+
+// Compare returns -1, 0 or +1 as a is older than, equal to or newer than b
+// under the rules of format. Unknown formats are compared with the RPM
+// algorithm, which orders plain dotted versions sensibly.
+func Compare(format, a, b string) int {
+	switch format {
+	case Deb:
+		return compareDeb(a, b)
+	case Apk:
+		return compareApk(a, b)
+	default:
+		return compareRPM(a, b)
+	}
+}
+
+// Op is a comparison operator for version queries.
+type Op string
+
+const (
+	OpLT Op = "lt"
+	OpLE Op = "lte"
+	OpEQ Op = "eq"
+	OpGE Op = "gte"
+	OpGT Op = "gt"
+)
+
+// Match reports whether version op than (e.g. version lt than).
+func Match(format, version string, op Op, than string) bool {
+	c := Compare(format, version, than)
+	switch op {
+	case OpLT:
+		return c < 0
+	case OpLE:
+		return c <= 0
+	case OpEQ:
+		return c == 0
+	case OpGE:
+		return c >= 0
+	case OpGT:
+		return c > 0
+	}
+	return false
+}
+
+// splitEpoch splits "epoch:rest"; a missing epoch is "0".
+func splitEpoch(v string) (epoch, rest string) {
+	if i := strings.IndexByte(v, ':'); i >= 0 {
+		return v[:i], v[i+1:]
+	}
+	return "0", v
+}
+
+// compareNumeric compares two digit strings of any length.
+func compareNumeric(a, b string) int {
+	a = strings.TrimLeft(a, "0")
+	b = strings.TrimLeft(b, "0")
+	if len(a) != len(b) {
+		if len(a) < len(b) {
+			return -1
+		}
+		return 1
+	}
+	return sign(strings.Compare(a, b))
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+func isAlpha(c byte) bool { return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') }
+
+// --- dpkg ---
+
+// compareDeb implements dpkg's [epoch:]upstream[-revision] ordering.
+func compareDeb(a, b string) int {
+	ea, ra := splitEpoch(a)
+	eb, rb := splitEpoch(b)
+	if c := compareNumeric(ea, eb); c != 0 {
+		return c
+	}
+	ua, va := splitRevision(ra)
+	ub, vb := splitRevision(rb)
+	if c := verrevcmp(ua, ub); c != 0 {
+		return c
+	}
+	return verrevcmp(va, vb)
+}
+
+// splitRevision splits at the last hyphen; no hyphen means no revision.
+func splitRevision(v string) (upstream, revision string) {
+	if i := strings.LastIndexByte(v, '-'); i >= 0 {
+		return v[:i], v[i+1:]
+	}
+	return v, ""
+}
+
+// debOrder is dpkg's order of a non-digit character: "~" sorts before
+// everything, even the end of the string, and letters sort before other
+// characters.
+func debOrder(c byte) int {
+	switch {
+	case isDigit(c):
+		return 0
+	case isAlpha(c):
+		return int(c)
+	case c == '~':
+		return -1
+	case c != 0:
+		return int(c) + 256
+	}
+	return 0
+}
+
+// verrevcmp is dpkg's comparison of one version part: alternating runs of
+// non-digits (compared with debOrder) and digits (compared numerically).
+func verrevcmp(a, b string) int {
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		firstDiff := 0
+		for (i < len(a) && !isDigit(a[i])) || (j < len(b) && !isDigit(b[j])) {
+			var ac, bc int
+			if i < len(a) {
+				ac = debOrder(a[i])
+			}
+			if j < len(b) {
+				bc = debOrder(b[j])
+			}
+			if ac != bc {
+				return sign(ac - bc)
+			}
+			i++
+			j++
+		}
+		for i < len(a) && a[i] == '0' {
+			i++
+		}
+		for j < len(b) && b[j] == '0' {
+			j++
+		}
+		for i < len(a) && isDigit(a[i]) && j < len(b) && isDigit(b[j]) {
+			if firstDiff == 0 {
+				firstDiff = int(a[i]) - int(b[j])
+			}
+			i++
+			j++
+		}
+		if i < len(a) && isDigit(a[i]) {
+			return 1
+		}
+		if j < len(b) && isDigit(b[j]) {
+			return -1
+		}
+		if firstDiff != 0 {
+			return sign(firstDiff)
+		}
+	}
+	return 0
+}
+
+// --- rpm ---
+
+// compareRPM implements rpm's [epoch:]version[-release] ordering. A release
+// missing on either side is not compared, so "1.0" matches every 1.0-N.
+func compareRPM(a, b string) int {
+	ea, ra := splitEpoch(a)
+	eb, rb := splitEpoch(b)
+	if c := compareNumeric(ea, eb); c != 0 {
+		return c
+	}
+	va, rela, hasA := strings.Cut(ra, "-")
+	vb, relb, hasB := strings.Cut(rb, "-")
+	if c := rpmvercmp(va, vb); c != 0 {
+		return c
+	}
+	if !hasA || !hasB {
+		return 0
+	}
+	return rpmvercmp(rela, relb)
+}
+
+// rpmvercmp is rpm's segment comparison: runs of digits or letters, with
+// "~" sorting before and "^" after the end of a version.
+func rpmvercmp(a, b string) int {
+	if a == b {
+		return 0
+	}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		for i < len(a) && !isDigit(a[i]) && !isAlpha(a[i]) && a[i] != '~' && a[i] != '^' {
+			i++
+		}
+		for j < len(b) && !isDigit(b[j]) && !isAlpha(b[j]) && b[j] != '~' && b[j] != '^' {
+			j++
+		}
+
+		// Tilde: older than anything, including the end of the string.
+		if (i < len(a) && a[i] == '~') || (j < len(b) && b[j] == '~') {
+			if i >= len(a) || a[i] != '~' {
+				return 1
+			}
+			if j >= len(b) || b[j] != '~' {
+				return -1
+			}
+			i++
+			j++
+			continue
+		}
+		// Caret: newer than the end of the string, older than anything else.
+		if (i < len(a) && a[i] == '^') || (j < len(b) && b[j] == '^') {
+			if i >= len(a) {
+				return -1
+			}
+			if j >= len(b) {
+				return 1
+			}
+			if a[i] != '^' {
+				return 1
+			}
+			if b[j] != '^' {
+				return -1
+			}
+			i++
+			j++
+			continue
+		}
+		if i >= len(a) || j >= len(b) {
+			break
+		}
+
+		si, sj := i, j
+		numeric := isDigit(a[i])
+		if numeric {
+			for i < len(a) && isDigit(a[i]) {
+				i++
+			}
+			for j < len(b) && isDigit(b[j]) {
+				j++
+			}
+		} else {
+			for i < len(a) && isAlpha(a[i]) {
+				i++
+			}
+			for j < len(b) && isAlpha(b[j]) {
+				j++
+			}
+		}
+		segA, segB := a[si:i], b[sj:j]
+		if segB == "" {
+			// Different segment types: numbers are newer than letters.
+			if numeric {
+				return 1
+			}
+			return -1
+		}
+		var c int
+		if numeric {
+			c = compareNumeric(segA, segB)
+		} else {
+			c = sign(strings.Compare(segA, segB))
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	switch {
+	case i >= len(a) && j >= len(b):
+		return 0
+	case i >= len(a):
+		return -1
+	}
+	return 1
+}
+
+// --- apk ---
+
+// apkSuffixes orders apk's pre-release (negative) and post-release
+// (positive) suffixes.
+var apkSuffixes = map[string]int{
+	"alpha": -4, "beta": -3, "pre": -2, "rc": -1,
+	"cvs": 1, "svn": 2, "git": 3, "hg": 4, "p": 5,
+}
+
+// apkVersion is a parsed apk version: numbers[.numbers...][letter]
+// [_suffix[N]...][-rN].
+type apkVersion struct {
+	numbers  []string
+	letter   byte
+	suffixes []apkSuffix
+	revision string
+}
+
+type apkSuffix struct {
+	rank   int
+	number string
+}
+
+func parseApk(v string) apkVersion {
+	var out apkVersion
+	if i := strings.LastIndex(v, "-r"); i >= 0 {
+		out.revision = v[i+2:]
+		v = v[:i]
+	}
+	main, suffixes, _ := strings.Cut(v, "_")
+	for _, part := range strings.Split(main, ".") {
+		if n := len(part); n > 0 && isAlpha(part[n-1]) {
+			out.letter = part[n-1]
+			part = part[:n-1]
+		}
+		out.numbers = append(out.numbers, part)
+	}
+	if suffixes != "" {
+		for _, s := range strings.Split(suffixes, "_") {
+			k := 0
+			for k < len(s) && isAlpha(s[k]) {
+				k++
+			}
+			out.suffixes = append(out.suffixes, apkSuffix{rank: apkSuffixes[s[:k]], number: s[k:]})
+		}
+	}
+	return out
+}
+
+// compareApk compares apk versions: number components (the first
+// numerically, later ones as in apk's fractional comparison when they have
+// a leading zero), then the letter, the suffixes and the -rN revision.
+func compareApk(a, b string) int {
+	va, vb := parseApk(a), parseApk(b)
+	for k := 0; k < len(va.numbers) || k < len(vb.numbers); k++ {
+		if k >= len(va.numbers) {
+			return -1
+		}
+		if k >= len(vb.numbers) {
+			return 1
+		}
+		na, nb := va.numbers[k], vb.numbers[k]
+		var c int
+		if k > 0 && (strings.HasPrefix(na, "0") || strings.HasPrefix(nb, "0")) {
+			c = sign(strings.Compare(na, nb))
+		} else {
+			c = compareNumeric(na, nb)
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	if va.letter != vb.letter {
+		return sign(int(va.letter) - int(vb.letter))
+	}
+	for k := 0; k < len(va.suffixes) || k < len(vb.suffixes); k++ {
+		var sa, sb apkSuffix
+		if k < len(va.suffixes) {
+			sa = va.suffixes[k]
+		}
+		if k < len(vb.suffixes) {
+			sb = vb.suffixes[k]
+		}
+		if sa.rank != sb.rank {
+			return sign(sa.rank - sb.rank)
+		}
+		if c := compareNumeric(sa.number, sb.number); c != 0 {
+			return c
+		}
+	}
+	return compareNumeric(va.revision, vb.revision)
+}
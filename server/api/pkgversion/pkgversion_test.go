@@ -0,0 +1,102 @@
+package pkgversion_test
+
+import (
+	"testing"
+
+	"github.com/lute/api/pkgversion"
+)
+
+func TestCompare(t *testing.T) {
+	for _, tt := range []struct {
+		format string
+		a, b   string
+		want   int
+	}{
+		// dpkg
+		{pkgversion.Deb, "1.0", "1.0", 0},
+		{pkgversion.Deb, "0:1.0", "1.0", 0},
+		{pkgversion.Deb, "1.01", "1.1", 0},
+		{pkgversion.Deb, "1:1.0", "2.0", 1},
+		{pkgversion.Deb, "2:1.0", "10:0.1", -1},
+		{pkgversion.Deb, "1.2", "1.10", -1},
+		{pkgversion.Deb, "1.0~rc1", "1.0", -1},
+		{pkgversion.Deb, "1.0~rc1", "1.0~rc2", -1},
+		{pkgversion.Deb, "1.0~~", "1.0~", -1},
+		{pkgversion.Deb, "1.0~", "1.0", -1},
+		{pkgversion.Deb, "1.0a", "1.0", 1},
+		{pkgversion.Deb, "1.0a", "1.0+", -1},
+		{pkgversion.Deb, "1.0+b1", "1.0", 1},
+		{pkgversion.Deb, "1.0-1", "1.0-2", -1},
+		{pkgversion.Deb, "1.0-9", "1.0-10", -1},
+		{pkgversion.Deb, "1.0", "1.0-1", -1},
+		{pkgversion.Deb, "2.30-1ubuntu1", "2.30-1", 1},
+		{pkgversion.Deb, "2.30-1ubuntu1.1", "2.30-1ubuntu1", 1},
+		{pkgversion.Deb, "1.0-beta-2", "1.0-beta-10", -1},
+		{pkgversion.Deb, "3.0.2-0ubuntu1.10", "3.0.2-0ubuntu1.9", 1},
+
+		// rpm
+		{pkgversion.RPM, "1.0", "1.0", 0},
+		{pkgversion.RPM, "001", "1", 0},
+		{pkgversion.RPM, "1.0", "1_0", 0},
+		{pkgversion.RPM, "1.0", "1.0.1", -1},
+		{pkgversion.RPM, "1.10", "1.9", 1},
+		{pkgversion.RPM, "1.0a", "1.0", 1},
+		{pkgversion.RPM, "1a", "1.1", -1},
+		{pkgversion.RPM, "1.0~rc1", "1.0", -1},
+		{pkgversion.RPM, "1.0~rc1", "1.0~rc2", -1},
+		{pkgversion.RPM, "1.0^post1", "1.0", 1},
+		{pkgversion.RPM, "1.0^post1", "1.0.1", -1},
+		{pkgversion.RPM, "2:1.0", "1:2.0", 1},
+		{pkgversion.RPM, "1.0-1.el9", "1.0-2.el9", -1},
+		{pkgversion.RPM, "1.0-10.el9", "1.0-9.el9", 1},
+		{pkgversion.RPM, "1.0", "1.0-5.el9", 0},
+		{pkgversion.RPM, "3.0.7-25.el9_3", "3.0.7-24.el9", 1},
+
+		// apk
+		{pkgversion.Apk, "1.2.3", "1.2.3", 0},
+		{pkgversion.Apk, "1.2.3", "1.2.4", -1},
+		{pkgversion.Apk, "1.2", "1.2.1", -1},
+		{pkgversion.Apk, "1.10", "1.9", 1},
+		{pkgversion.Apk, "1.02", "1.1", -1},
+		{pkgversion.Apk, "1.2a", "1.2", 1},
+		{pkgversion.Apk, "1.2b", "1.2a", 1},
+		{pkgversion.Apk, "1.0_rc1", "1.0", -1},
+		{pkgversion.Apk, "1.0_rc1", "1.0_rc2", -1},
+		{pkgversion.Apk, "1.0_alpha", "1.0_beta", -1},
+		{pkgversion.Apk, "1.0_p1", "1.0", 1},
+		{pkgversion.Apk, "1.0-r1", "1.0-r2", -1},
+		{pkgversion.Apk, "1.0-r9", "1.0-r10", -1},
+		{pkgversion.Apk, "3.1.4-r5", "3.1.4_p1-r0", -1},
+
+		// Unknown formats compare like rpm
+		{"", "1.10", "1.9", 1},
+	} {
+		if got := pkgversion.Compare(tt.format, tt.a, tt.b); got != tt.want {
+			t.Errorf("Compare(%q, %q, %q) = %d, want %d", tt.format, tt.a, tt.b, got, tt.want)
+		}
+		if got := pkgversion.Compare(tt.format, tt.b, tt.a); got != -tt.want {
+			t.Errorf("Compare(%q, %q, %q) = %d, want %d", tt.format, tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	for _, tt := range []struct {
+		version string
+		op      pkgversion.Op
+		than    string
+		want    bool
+	}{
+		{"1.0-1", pkgversion.OpLT, "1.0-2", true},
+		{"1.0-2", pkgversion.OpLT, "1.0-2", false},
+		{"1.0-2", pkgversion.OpLE, "1.0-2", true},
+		{"1:0.9", pkgversion.OpEQ, "1:0.9", true},
+		{"1.0~rc1", pkgversion.OpGE, "1.0", false},
+		{"1.0+b1", pkgversion.OpGT, "1.0", true},
+		{"1.0", pkgversion.Op("ne"), "2.0", false},
+	} {
+		if got := pkgversion.Match(pkgversion.Deb, tt.version, tt.op, tt.than); got != tt.want {
+			t.Errorf("Match(%q %s %q) = %v, want %v", tt.version, tt.op, tt.than, got, tt.want)
+		}
+	}
+}
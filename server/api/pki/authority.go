@@ -0,0 +1,132 @@
+package pki
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/lute/api/config"
+	"github.com/lute/api/models"
+	"github.com/lute/api/repository"
+)
+
+var (
+	ErrCertRevoked = errors.New("agent certificate has been revoked")
+	ErrCertUnknown = errors.New("agent certificate is not known to this server")
+)
+
+// IssuedCert is a client certificate handed to an agent.
+type IssuedCert struct {
+	CertificatePEM   []byte
+	CACertificatePEM []byte
+	ExpiresAt        time.Time
+}
+
+// Authority issues, verifies and revokes agent certificates. Issued serials
+// are stored so certificates can be revoked before they expire.
+type Authority struct {
+	ca       *CA
+	cfg      config.GRPCTLSConfig
+	certRepo *repository.AgentCertificateRepository
+
+	// OnRevoke is called with the machine ID after its certificates are
+	// revoked, e.g. to close the agent's open stream.
+	OnRevoke func(machineID string)
+}
+
+// NewAuthority loads (or creates) the CA configured in cfg.CADir.
+func NewAuthority(cfg config.GRPCTLSConfig, certRepo *repository.AgentCertificateRepository) (*Authority, error) {
+	ca, err := LoadOrCreateCA(cfg.CADir)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Agent CA loaded from %s", cfg.CADir)
+	return &Authority{ca: ca, cfg: cfg, certRepo: certRepo}, nil
+}
+
+// ServerName is the name agents verify the gRPC server certificate against.
+func (a *Authority) ServerName() string {
+	return a.cfg.ServerName
+}
+
+// ServerTLSConfig returns the gRPC server TLS config: a server certificate
+// signed by the CA, and a required client certificate from the same CA.
+func (a *Authority) ServerTLSConfig() (*tls.Config, error) {
+	cert, err := a.ca.IssueServerCert(a.cfg.ServerName, a.cfg.ExtraHosts)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    a.ca.Pool(),
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// Issue signs csrPEM for the machine and records the certificate.
+func (a *Authority) Issue(ctx context.Context, machineID primitive.ObjectID, csrPEM []byte) (*IssuedCert, error) {
+	cert, certPEM, err := a.ca.SignAgentCSR(csrPEM, machineID.Hex(), a.cfg.ClientCertTTL)
+	if err != nil {
+		return nil, err
+	}
+	record := &models.AgentCertificate{
+		MachineID: machineID,
+		Serial:    Serial(cert),
+		NotBefore: cert.NotBefore,
+		NotAfter:  cert.NotAfter,
+	}
+	if err := a.certRepo.Create(ctx, record); err != nil {
+		return nil, err
+	}
+	return &IssuedCert{CertificatePEM: certPEM, CACertificatePEM: a.ca.CertPEM(), ExpiresAt: cert.NotAfter}, nil
+}
+
+// Verify checks a client certificate already validated by the TLS handshake
+// against the issued-certificate records and returns its machine ID. When the
+// certificate is newer than the machine's others, those are revoked: the
+// agent has switched over after a renewal.
+func (a *Authority) Verify(ctx context.Context, cert *x509.Certificate) (primitive.ObjectID, error) {
+	hexID, err := MachineID(cert)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	machineID, err := primitive.ObjectIDFromHex(hexID)
+	if err != nil {
+		return primitive.NilObjectID, ErrNotAgentCert
+	}
+	record, err := a.certRepo.GetBySerial(ctx, Serial(cert))
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return primitive.NilObjectID, ErrCertUnknown
+		}
+		return primitive.NilObjectID, err
+	}
+	if record.MachineID != machineID {
+		return primitive.NilObjectID, ErrCertUnknown
+	}
+	if record.RevokedAt != nil {
+		return primitive.NilObjectID, ErrCertRevoked
+	}
+	if err := a.certRepo.RevokeIssuedBefore(ctx, machineID, record.NotBefore); err != nil {
+		log.Printf("Agent CA: failed to revoke superseded certificates of %s: %v", hexID, err)
+	}
+	return machineID, nil
+}
+
+// RevokeMachine revokes every certificate of a machine and notifies OnRevoke.
+func (a *Authority) RevokeMachine(ctx context.Context, machineID primitive.ObjectID) error {
+	if err := a.certRepo.RevokeByMachineID(ctx, machineID); err != nil {
+		return err
+	}
+	if a.OnRevoke != nil {
+		a.OnRevoke(machineID.Hex())
+	}
+	return nil
+}
@@ -0,0 +1,268 @@
+// Package pki implements the small certificate authority the API uses for
+// mutual TLS with agents: it signs the gRPC server certificate and one client
+// certificate per machine, bound to the machine ID.
+package pki
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	caCertFile = "ca.crt"
+	caKeyFile  = "ca.key"
+
+	caValidity     = 10 * 365 * 24 * time.Hour
+	serverValidity = 365 * 24 * time.Hour
+
+	// AgentOrgUnit marks client certificates issued to agents.
+	AgentOrgUnit = "lute-agent"
+)
+
+var (
+	ErrInvalidCSR      = errors.New("invalid certificate signing request")
+	ErrNotAgentCert    = errors.New("certificate was not issued to an agent")
+	ErrUnsupportedKey  = errors.New("unsupported CA key type")
+	errNoPEMBlockFound = errors.New("no PEM block found")
+)
+
+// CA signs certificates with a key kept on disk.
+type CA struct {
+	cert    *x509.Certificate
+	certPEM []byte
+	key     crypto.Signer
+}
+
+// LoadOrCreateCA loads ca.crt/ca.key from dir, generating a new ECDSA P-256 CA
+// when none exists yet. All API replicas must share the same directory.
+func LoadOrCreateCA(dir string) (*CA, error) {
+	certPath := filepath.Join(dir, caCertFile)
+	keyPath := filepath.Join(dir, caKeyFile)
+
+	certPEM, err := os.ReadFile(certPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return createCA(dir)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read CA certificate: %w", err)
+	}
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("read CA key: %w", err)
+	}
+
+	cert, err := parseCertPEM(certPEM)
+	if err != nil {
+		return nil, fmt.Errorf("parse CA certificate: %w", err)
+	}
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("parse CA key: %w", errNoPEMBlockFound)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse CA key: %w", err)
+	}
+	key, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, ErrUnsupportedKey
+	}
+	return &CA{cert: cert, certPEM: certPEM, key: key}, nil
+}
+
+func createCA(dir string) (*CA, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create CA directory: %w", err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"Lute"}, CommonName: "Lute Agent CA"},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(filepath.Join(dir, caKeyFile), keyPEM, 0o600); err != nil {
+		return nil, fmt.Errorf("write CA key: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, caCertFile), certPEM, 0o644); err != nil {
+		return nil, fmt.Errorf("write CA certificate: %w", err)
+	}
+	return &CA{cert: cert, certPEM: certPEM, key: key}, nil
+}
+
+// CertPEM returns the PEM-encoded CA certificate agents should trust.
+func (ca *CA) CertPEM() []byte {
+	return ca.certPEM
+}
+
+// Pool returns a pool containing only the CA certificate.
+func (ca *CA) Pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return pool
+}
+
+// IssueServerCert signs a fresh server certificate for serverName and hosts
+// (DNS names or IP addresses). It is kept in memory and reissued on restart.
+func (ca *CA) IssueServerCert(serverName string, hosts []string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{Organization: []string{"Lute"}, CommonName: serverName},
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     now.Add(serverValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{serverName},
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else if h != serverName {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der, ca.cert.Raw}, PrivateKey: key}, nil
+}
+
+// SignAgentCSR issues a client certificate for machineID from a PEM-encoded
+// CSR. Only the CSR's public key is used; the subject is set by the CA.
+func (ca *CA) SignAgentCSR(csrPEM []byte, machineID string, ttl time.Duration) (*x509.Certificate, []byte, error) {
+	csr, err := ParseCSR(csrPEM)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	notAfter := now.Add(ttl)
+	if notAfter.After(ca.cert.NotAfter) {
+		notAfter = ca.cert.NotAfter
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization:       []string{"Lute"},
+			OrganizationalUnit: []string{AgentOrgUnit},
+			CommonName:         machineID,
+		},
+		NotBefore:   now.Add(-time.Minute),
+		NotAfter:    notAfter,
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, csr.PublicKey, ca.key)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), nil
+}
+
+// ParseCSR decodes a PEM-encoded CSR and checks its self-signature.
+func ParseCSR(csrPEM []byte) (*x509.CertificateRequest, error) {
+	block, _ := pem.Decode(csrPEM)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, ErrInvalidCSR
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCSR, err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCSR, err)
+	}
+	return csr, nil
+}
+
+// MachineID returns the machine ID an agent certificate was issued to.
+func MachineID(cert *x509.Certificate) (string, error) {
+	for _, ou := range cert.Subject.OrganizationalUnit {
+		if ou == AgentOrgUnit && cert.Subject.CommonName != "" {
+			return cert.Subject.CommonName, nil
+		}
+	}
+	return "", ErrNotAgentCert
+}
+
+// Serial returns the hex serial number used to track a certificate.
+func Serial(cert *x509.Certificate) string {
+	return hex.EncodeToString(cert.SerialNumber.Bytes())
+}
+
+// RenewalDue reports whether less than 40% of the certificate's lifetime is
+// left. Agents reconnect after two thirds of the lifetime, so the server
+// renews on that connection.
+func RenewalDue(cert *x509.Certificate, now time.Time) bool {
+	lifetime := cert.NotAfter.Sub(cert.NotBefore)
+	return cert.NotAfter.Sub(now) < lifetime*2/5
+}
+
+func parseCertPEM(certPEM []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return nil, errNoPEMBlockFound
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func newSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
@@ -0,0 +1,93 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/lute/api/database"
+	"github.com/lute/api/models"
+)
+
+// AdvisoryRepository handles the advisories collection (one document per
+// OSV record, keyed by its ID).
+type AdvisoryRepository struct {
+	*Repository
+}
+
+// NewAdvisoryRepository creates a new AdvisoryRepository.
+func NewAdvisoryRepository(db *mongo.Database) *AdvisoryRepository {
+	return &AdvisoryRepository{
+		Repository: NewRepository(db, database.CollectionAdvisories),
+	}
+}
+
+// Upsert stores advisories, replacing those with the same ID.
+func (r *AdvisoryRepository) Upsert(ctx context.Context, advisories []*models.Advisory) error {
+	if len(advisories) == 0 {
+		return nil
+	}
+	writes := make([]mongo.WriteModel, 0, len(advisories))
+	for _, a := range advisories {
+		writes = append(writes, mongo.NewReplaceOneModel().
+			SetFilter(bson.M{"_id": a.ID}).
+			SetReplacement(a).
+			SetUpsert(true))
+	}
+	_, err := r.Collection.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+	return err
+}
+
+// DeleteImportedBefore removes the advisories the import at t did not
+// refresh, i.e. those no longer in the feed.
+func (r *AdvisoryRepository) DeleteImportedBefore(ctx context.Context, t time.Time) (int64, error) {
+	res, err := r.Collection.DeleteMany(ctx, bson.M{"imported_at": bson.M{"$lt": t}})
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
+
+// LastImport returns the time of the latest import (zero if there is none).
+func (r *AdvisoryRepository) LastImport(ctx context.Context) (time.Time, error) {
+	var doc struct {
+		ImportedAt time.Time `bson:"imported_at"`
+	}
+	opts := options.FindOne().SetSort(bson.D{{Key: "imported_at", Value: -1}}).SetProjection(bson.M{"imported_at": 1})
+	err := r.Collection.FindOne(ctx, bson.M{}, opts).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return time.Time{}, nil
+	}
+	return doc.ImportedAt, err
+}
+
+// Count returns the number of advisories stored.
+func (r *AdvisoryRepository) Count(ctx context.Context) (int64, error) {
+	return r.Collection.EstimatedDocumentCount(ctx)
+}
+
+// FindAffecting returns the advisories with an affected package of distro
+// named one of names.
+func (r *AdvisoryRepository) FindAffecting(ctx context.Context, distro string, names []string) ([]*models.Advisory, error) {
+	if distro == "" || len(names) == 0 {
+		return nil, nil
+	}
+	filter := bson.M{"affected": bson.M{"$elemMatch": bson.M{
+		"distro":  distro,
+		"package": bson.M{"$in": names},
+	}}}
+	cursor, err := r.Collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var out []*models.Advisory
+	if err := cursor.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
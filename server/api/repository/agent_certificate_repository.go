@@ -0,0 +1,58 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/lute/api/database"
+	"github.com/lute/api/models"
+)
+
+// AgentCertificateRepository handles the agent_certificates collection.
+type AgentCertificateRepository struct {
+	*Repository
+}
+
+// NewAgentCertificateRepository creates a new AgentCertificateRepository.
+func NewAgentCertificateRepository(db *mongo.Database) *AgentCertificateRepository {
+	return &AgentCertificateRepository{
+		Repository: NewRepository(db, database.CollectionAgentCerts),
+	}
+}
+
+func (r *AgentCertificateRepository) Create(ctx context.Context, cert *models.AgentCertificate) error {
+	cert.BeforeCreate()
+	_, err := r.Collection.InsertOne(ctx, cert)
+	return err
+}
+
+// GetBySerial looks up a certificate by its hex serial number.
+func (r *AgentCertificateRepository) GetBySerial(ctx context.Context, serial string) (*models.AgentCertificate, error) {
+	var cert models.AgentCertificate
+	if err := r.Collection.FindOne(ctx, bson.M{"serial": serial}).Decode(&cert); err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// RevokeByMachineID revokes every certificate of a machine (e.g. when it is deleted).
+func (r *AgentCertificateRepository) RevokeByMachineID(ctx context.Context, machineID primitive.ObjectID) error {
+	return r.revoke(ctx, bson.M{"machine_id": machineID})
+}
+
+// RevokeIssuedBefore revokes the machine's certificates that predate notBefore,
+// once the agent has switched to a renewed certificate.
+func (r *AgentCertificateRepository) RevokeIssuedBefore(ctx context.Context, machineID primitive.ObjectID, notBefore time.Time) error {
+	return r.revoke(ctx, bson.M{"machine_id": machineID, "not_before": bson.M{"$lt": notBefore}})
+}
+
+func (r *AgentCertificateRepository) revoke(ctx context.Context, filter bson.M) error {
+	now := time.Now()
+	filter["revoked_at"] = bson.M{"$exists": false}
+	_, err := r.Collection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"revoked_at": now, "updated_at": now}})
+	return err
+}
@@ -0,0 +1,103 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/lute/api/database"
+	"github.com/lute/api/models"
+)
+
+// AgentRolloutRepository handles the agent_rollouts collection.
+type AgentRolloutRepository struct {
+	*Repository
+}
+
+// NewAgentRolloutRepository creates a new AgentRolloutRepository.
+func NewAgentRolloutRepository(db *mongo.Database) *AgentRolloutRepository {
+	return &AgentRolloutRepository{
+		Repository: NewRepository(db, database.CollectionAgentRollouts),
+	}
+}
+
+func (r *AgentRolloutRepository) Create(ctx context.Context, rollout *models.AgentRollout) error {
+	rollout.BeforeCreate()
+	_, err := r.Collection.InsertOne(ctx, rollout)
+	return err
+}
+
+func (r *AgentRolloutRepository) GetByID(ctx context.Context, id primitive.ObjectID) (*models.AgentRollout, error) {
+	var rollout models.AgentRollout
+	err := r.Collection.FindOne(ctx, bson.M{"_id": id}).Decode(&rollout)
+	if err != nil {
+		return nil, err
+	}
+	return &rollout, nil
+}
+
+// GetVisible returns the user's personal rollouts plus those of the given
+// orgs, newest first.
+func (r *AgentRolloutRepository) GetVisible(ctx context.Context, userID primitive.ObjectID, orgIDs []primitive.ObjectID) ([]*models.AgentRollout, error) {
+	or := []bson.M{{"user_id": userID, "org_id": bson.M{"$exists": false}}}
+	if len(orgIDs) > 0 {
+		or = append(or, bson.M{"org_id": bson.M{"$in": orgIDs}})
+	}
+	return r.find(ctx, bson.M{"$or": or})
+}
+
+// GetForMachine returns the rollouts of the machine's owner, newest first.
+func (r *AgentRolloutRepository) GetForMachine(ctx context.Context, m *models.Machine) ([]*models.AgentRollout, error) {
+	if !m.OrgID.IsZero() {
+		return r.find(ctx, bson.M{"org_id": m.OrgID})
+	}
+	return r.find(ctx, bson.M{"user_id": m.UserID, "org_id": bson.M{"$exists": false}})
+}
+
+func (r *AgentRolloutRepository) find(ctx context.Context, filter bson.M) ([]*models.AgentRollout, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	cursor, err := r.Collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var rollouts []*models.AgentRollout
+	if err := cursor.All(ctx, &rollouts); err != nil {
+		return nil, err
+	}
+	return rollouts, nil
+}
+
+// SetPercent changes the share of targeted machines a rollout applies to.
+func (r *AgentRolloutRepository) SetPercent(ctx context.Context, id primitive.ObjectID, percent int) error {
+	_, err := r.Collection.UpdateOne(ctx,
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{"percent": percent, "updated_at": time.Now()}},
+	)
+	return err
+}
+
+func (r *AgentRolloutRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	_, err := r.Collection.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+// Versions returns the distinct versions targeted by any rollout.
+func (r *AgentRolloutRepository) Versions(ctx context.Context) ([]string, error) {
+	values, err := r.Collection.Distinct(ctx, "version", bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]string, 0, len(values))
+	for _, v := range values {
+		if s, ok := v.(string); ok {
+			versions = append(versions, s)
+		}
+	}
+	return versions, nil
+}
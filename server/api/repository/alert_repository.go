@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/lute/api/database"
+	"github.com/lute/api/models"
+)
+
+// AlertRepository handles the alerts collection.
+type AlertRepository struct {
+	*Repository
+}
+
+// NewAlertRepository creates a new AlertRepository.
+func NewAlertRepository(db *mongo.Database) *AlertRepository {
+	return &AlertRepository{
+		Repository: NewRepository(db, database.CollectionAlerts),
+	}
+}
+
+func (r *AlertRepository) Insert(ctx context.Context, alert *models.Alert) error {
+	res, err := r.Collection.InsertOne(ctx, alert)
+	if err != nil {
+		return err
+	}
+	if id, ok := res.InsertedID.(primitive.ObjectID); ok {
+		alert.ID = id
+	}
+	return nil
+}
+
+// GetFiring returns the firing alerts of kind on a machine.
+func (r *AlertRepository) GetFiring(ctx context.Context, machineID primitive.ObjectID, kind string) ([]*models.Alert, error) {
+	return r.find(ctx, bson.M{"machine_id": machineID, "kind": kind, "status": models.AlertStatusFiring}, nil)
+}
+
+// GetFiringByRule returns the firing alerts of a rule.
+func (r *AlertRepository) GetFiringByRule(ctx context.Context, ruleID primitive.ObjectID) ([]*models.Alert, error) {
+	return r.find(ctx, bson.M{"rule_id": ruleID, "status": models.AlertStatusFiring}, nil)
+}
+
+// List returns up to limit alerts of machineIDs, optionally only those with
+// status, newest first.
+func (r *AlertRepository) List(ctx context.Context, machineIDs []primitive.ObjectID, status string, limit int64) ([]*models.Alert, error) {
+	if len(machineIDs) == 0 {
+		return nil, nil
+	}
+	filter := bson.M{"machine_id": bson.M{"$in": machineIDs}}
+	if status != "" {
+		filter["status"] = status
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "fired_at", Value: -1}}).SetLimit(limit)
+	return r.find(ctx, filter, opts)
+}
+
+func (r *AlertRepository) find(ctx context.Context, filter bson.M, opts *options.FindOptions) ([]*models.Alert, error) {
+	cursor, err := r.Collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var alerts []*models.Alert
+	if err := cursor.All(ctx, &alerts); err != nil {
+		return nil, err
+	}
+	return alerts, nil
+}
+
+// Resolve marks a firing alert as resolved at t.
+func (r *AlertRepository) Resolve(ctx context.Context, id primitive.ObjectID, t time.Time) error {
+	_, err := r.Collection.UpdateOne(ctx,
+		bson.M{"_id": id, "status": models.AlertStatusFiring},
+		bson.M{"$set": bson.M{"status": models.AlertStatusResolved, "resolved_at": t}},
+	)
+	return err
+}
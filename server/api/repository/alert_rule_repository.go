@@ -0,0 +1,102 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/lute/api/database"
+	"github.com/lute/api/models"
+)
+
+// AlertRuleRepository handles the alert_rules collection.
+type AlertRuleRepository struct {
+	*Repository
+}
+
+// NewAlertRuleRepository creates a new AlertRuleRepository.
+func NewAlertRuleRepository(db *mongo.Database) *AlertRuleRepository {
+	return &AlertRuleRepository{
+		Repository: NewRepository(db, database.CollectionAlertRules),
+	}
+}
+
+func (r *AlertRuleRepository) Create(ctx context.Context, rule *models.AlertRule) error {
+	rule.BeforeCreate()
+	_, err := r.Collection.InsertOne(ctx, rule)
+	return err
+}
+
+func (r *AlertRuleRepository) GetByID(ctx context.Context, id primitive.ObjectID) (*models.AlertRule, error) {
+	var rule models.AlertRule
+	err := r.Collection.FindOne(ctx, bson.M{"_id": id}).Decode(&rule)
+	if err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// GetVisible returns the user's personal rules plus those of the given
+// orgs, newest first.
+func (r *AlertRuleRepository) GetVisible(ctx context.Context, userID primitive.ObjectID, orgIDs []primitive.ObjectID) ([]*models.AlertRule, error) {
+	or := []bson.M{{"user_id": userID, "org_id": bson.M{"$exists": false}}}
+	if len(orgIDs) > 0 {
+		or = append(or, bson.M{"org_id": bson.M{"$in": orgIDs}})
+	}
+	return r.find(ctx, bson.M{"$or": or})
+}
+
+// GetEnabledForMachine returns the enabled rules of kind that belong to the
+// machine's owner.
+func (r *AlertRuleRepository) GetEnabledForMachine(ctx context.Context, m *models.Machine, kind string) ([]*models.AlertRule, error) {
+	filter := bson.M{"kind": kind, "disabled": false}
+	if !m.OrgID.IsZero() {
+		filter["org_id"] = m.OrgID
+	} else {
+		filter["user_id"] = m.UserID
+		filter["org_id"] = bson.M{"$exists": false}
+	}
+	return r.find(ctx, filter)
+}
+
+func (r *AlertRuleRepository) find(ctx context.Context, filter bson.M) ([]*models.AlertRule, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	cursor, err := r.Collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var rules []*models.AlertRule
+	if err := cursor.All(ctx, &rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// Update replaces the editable fields of a rule.
+func (r *AlertRuleRepository) Update(ctx context.Context, rule *models.AlertRule) error {
+	_, err := r.Collection.UpdateOne(ctx,
+		bson.M{"_id": rule.ID},
+		bson.M{"$set": bson.M{
+			"name":         rule.Name,
+			"selector":     rule.Selector,
+			"unit":         rule.Unit,
+			"states":       rule.States,
+			"min_restarts": rule.MinRestarts,
+			"notify":       rule.Notify,
+			"disabled":     rule.Disabled,
+			"updated_at":   time.Now(),
+		}},
+	)
+	return err
+}
+
+func (r *AlertRuleRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	_, err := r.Collection.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
@@ -0,0 +1,95 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/lute/api/database"
+	"github.com/lute/api/models"
+)
+
+// lastUsedGranularity limits how often last_used_at is written for a busy token.
+const lastUsedGranularity = time.Minute
+
+// APITokenRepository handles the api_tokens collection.
+type APITokenRepository struct {
+	*Repository
+}
+
+// NewAPITokenRepository creates a new APITokenRepository.
+func NewAPITokenRepository(db *mongo.Database) *APITokenRepository {
+	return &APITokenRepository{
+		Repository: NewRepository(db, database.CollectionAPITokens),
+	}
+}
+
+func (r *APITokenRepository) Create(ctx context.Context, token *models.APIToken) error {
+	token.BeforeCreate()
+	_, err := r.Collection.InsertOne(ctx, token)
+	return err
+}
+
+func (r *APITokenRepository) GetByID(ctx context.Context, id primitive.ObjectID) (*models.APIToken, error) {
+	var token models.APIToken
+	err := r.Collection.FindOne(ctx, bson.M{"_id": id}).Decode(&token)
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// GetByTokenHash looks up a token by the SHA-256 hex of its value.
+func (r *APITokenRepository) GetByTokenHash(ctx context.Context, tokenHash string) (*models.APIToken, error) {
+	var token models.APIToken
+	err := r.Collection.FindOne(ctx, bson.M{"token_hash": tokenHash}).Decode(&token)
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// GetByUserID returns all tokens of a user, newest first.
+func (r *APITokenRepository) GetByUserID(ctx context.Context, userID primitive.ObjectID) ([]*models.APIToken, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	cursor, err := r.Collection.Find(ctx, bson.M{"user_id": userID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var tokens []*models.APIToken
+	if err := cursor.All(ctx, &tokens); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// Revoke marks a token as revoked. Revoked tokens are kept for auditing.
+func (r *APITokenRepository) Revoke(ctx context.Context, id primitive.ObjectID) error {
+	now := time.Now()
+	_, err := r.Collection.UpdateOne(ctx,
+		bson.M{"_id": id, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revoked_at": now, "updated_at": now}},
+	)
+	return err
+}
+
+// TouchLastUsed records that the token was used. Writes at most once per minute per token.
+func (r *APITokenRepository) TouchLastUsed(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	_, err := r.Collection.UpdateOne(ctx,
+		bson.M{
+			"_id": id,
+			"$or": []bson.M{
+				{"last_used_at": bson.M{"$exists": false}},
+				{"last_used_at": bson.M{"$lt": at.Add(-lastUsedGranularity)}},
+			},
+		},
+		bson.M{"$set": bson.M{"last_used_at": at}},
+	)
+	return err
+}
@@ -0,0 +1,58 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/lute/api/database"
+	"github.com/lute/api/models"
+)
+
+// AuditRepository handles the audit_events collection. It is append-only:
+// there are intentionally no update or delete methods.
+type AuditRepository struct {
+	*Repository
+}
+
+// NewAuditRepository creates a new AuditRepository.
+func NewAuditRepository(db *mongo.Database) *AuditRepository {
+	return &AuditRepository{
+		Repository: NewRepository(db, database.CollectionAuditEvents),
+	}
+}
+
+// Insert appends an event.
+func (r *AuditRepository) Insert(ctx context.Context, event *models.AuditEvent) error {
+	if event.ID.IsZero() {
+		event.ID = primitive.NewObjectID()
+	}
+	if event.At.IsZero() {
+		event.At = time.Now()
+	}
+	_, err := r.Collection.InsertOne(ctx, event)
+	return err
+}
+
+// Find returns events matching filter, newest first, at most limit (0 = no limit).
+func (r *AuditRepository) Find(ctx context.Context, filter bson.M, limit int64) ([]*models.AuditEvent, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "at", Value: -1}, {Key: "_id", Value: -1}})
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+	cursor, err := r.Collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var events []*models.AuditEvent
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
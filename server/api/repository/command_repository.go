@@ -110,3 +110,84 @@ func (r *CommandRepository) MarkRunning(ctx context.Context, id primitive.Object
 	_, err := r.Collection.UpdateOne(ctx, bson.M{"_id": id}, update)
 	return err
 }
+
+// GetByIDs returns the commands with the given IDs.
+func (r *CommandRepository) GetByIDs(ctx context.Context, ids []primitive.ObjectID) ([]*models.Command, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	cursor, err := r.Collection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var commands []*models.Command
+	if err := cursor.All(ctx, &commands); err != nil {
+		return nil, err
+	}
+	return commands, nil
+}
+
+// GetOpenActions returns the actions of a machine that are pending or were
+// sent without a result yet, oldest first.
+func (r *CommandRepository) GetOpenActions(ctx context.Context, machineID primitive.ObjectID) ([]*models.Command, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	cursor, err := r.Collection.Find(ctx, bson.M{
+		"machine_id": machineID,
+		"action":     bson.M{"$exists": true},
+		"status":     bson.M{"$in": bson.A{"pending", "running"}},
+	}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var commands []*models.Command
+	if err := cursor.All(ctx, &commands); err != nil {
+		return nil, err
+	}
+	return commands, nil
+}
+
+// FinishAction records the outcome of an open action of a machine. It
+// reports false when there is no such open action, e.g. for a result that
+// was already recorded.
+func (r *CommandRepository) FinishAction(ctx context.Context, id, machineID primitive.ObjectID, result *models.Command) (bool, error) {
+	res, err := r.Collection.UpdateOne(ctx,
+		bson.M{"_id": id, "machine_id": machineID, "status": bson.M{"$in": bson.A{"pending", "running"}}},
+		bson.M{"$set": bson.M{
+			"status":      result.Status,
+			"output":      result.Output,
+			"exit_code":   result.ExitCode,
+			"error":       result.Error,
+			"started_at":  result.StartedAt,
+			"finished_at": result.FinishedAt,
+			"updated_at":  time.Now(),
+		}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return res.MatchedCount > 0, nil
+}
+
+// CancelPending fails a command that was not sent to its agent yet. It
+// reports false when the command was already sent or finished.
+func (r *CommandRepository) CancelPending(ctx context.Context, id primitive.ObjectID, errMsg string) (bool, error) {
+	now := time.Now()
+	res, err := r.Collection.UpdateOne(ctx,
+		bson.M{"_id": id, "status": "pending"},
+		bson.M{"$set": bson.M{
+			"status":      "failed",
+			"exit_code":   -1,
+			"error":       errMsg,
+			"finished_at": now.UTC(),
+			"updated_at":  now,
+		}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return res.MatchedCount > 0, nil
+}
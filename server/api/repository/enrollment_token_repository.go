@@ -0,0 +1,121 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/lute/api/database"
+	"github.com/lute/api/models"
+)
+
+// EnrollmentTokenRepository handles the enrollment_tokens collection.
+type EnrollmentTokenRepository struct {
+	*Repository
+}
+
+// NewEnrollmentTokenRepository creates a new EnrollmentTokenRepository.
+func NewEnrollmentTokenRepository(db *mongo.Database) *EnrollmentTokenRepository {
+	return &EnrollmentTokenRepository{
+		Repository: NewRepository(db, database.CollectionEnrollmentTokens),
+	}
+}
+
+func (r *EnrollmentTokenRepository) Create(ctx context.Context, token *models.EnrollmentToken) error {
+	token.BeforeCreate()
+	_, err := r.Collection.InsertOne(ctx, token)
+	return err
+}
+
+func (r *EnrollmentTokenRepository) GetByID(ctx context.Context, id primitive.ObjectID) (*models.EnrollmentToken, error) {
+	var token models.EnrollmentToken
+	err := r.Collection.FindOne(ctx, bson.M{"_id": id}).Decode(&token)
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// GetByTokenHash looks up a token by the SHA-256 hex of its value.
+func (r *EnrollmentTokenRepository) GetByTokenHash(ctx context.Context, tokenHash string) (*models.EnrollmentToken, error) {
+	var token models.EnrollmentToken
+	err := r.Collection.FindOne(ctx, bson.M{"token_hash": tokenHash}).Decode(&token)
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// GetVisible returns the tokens of the given kind created by the user for
+// their personal machines, plus those of the given orgs, newest first.
+func (r *EnrollmentTokenRepository) GetVisible(ctx context.Context, userID primitive.ObjectID, orgIDs []primitive.ObjectID, kind string) ([]*models.EnrollmentToken, error) {
+	or := []bson.M{{"user_id": userID, "org_id": bson.M{"$exists": false}}}
+	if len(orgIDs) > 0 {
+		or = append(or, bson.M{"org_id": bson.M{"$in": orgIDs}})
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	cursor, err := r.Collection.Find(ctx, bson.M{"kind": kind, "$or": or}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var tokens []*models.EnrollmentToken
+	if err := cursor.All(ctx, &tokens); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// Consume atomically uses the token if it is not revoked, expired or used up,
+// and returns it with the updated count. mongo.ErrNoDocuments means the token
+// is unknown or no longer usable.
+func (r *EnrollmentTokenRepository) Consume(ctx context.Context, tokenHash string, at time.Time) (*models.EnrollmentToken, error) {
+	filter := bson.M{
+		"token_hash": tokenHash,
+		"revoked_at": bson.M{"$exists": false},
+		"$and": []bson.M{
+			{"$or": []bson.M{
+				{"expires_at": bson.M{"$exists": false}},
+				{"expires_at": bson.M{"$gt": at}},
+			}},
+			{"$or": []bson.M{
+				{"max_uses": 0},
+				{"$expr": bson.M{"$lt": bson.A{"$uses", "$max_uses"}}},
+			}},
+		},
+	}
+	update := bson.M{
+		"$inc": bson.M{"uses": 1},
+		"$set": bson.M{"last_used_at": at, "updated_at": at},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var token models.EnrollmentToken
+	if err := r.Collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&token); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// Release gives back a use taken by Consume when registration fails afterwards.
+func (r *EnrollmentTokenRepository) Release(ctx context.Context, id primitive.ObjectID) error {
+	_, err := r.Collection.UpdateOne(ctx,
+		bson.M{"_id": id, "uses": bson.M{"$gt": 0}},
+		bson.M{"$inc": bson.M{"uses": -1}},
+	)
+	return err
+}
+
+// Revoke marks a token as revoked. Revoked tokens are kept for auditing.
+func (r *EnrollmentTokenRepository) Revoke(ctx context.Context, id primitive.ObjectID) error {
+	now := time.Now()
+	_, err := r.Collection.UpdateOne(ctx,
+		bson.M{"_id": id, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revoked_at": now, "updated_at": now}},
+	)
+	return err
+}
@@ -0,0 +1,83 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/lute/api/database"
+	"github.com/lute/api/models"
+)
+
+// FileChunkRepository handles the file_chunks collection, the content of
+// file transfers. Chunks expire a week after they are stored.
+type FileChunkRepository struct {
+	*Repository
+}
+
+// NewFileChunkRepository creates a new FileChunkRepository.
+func NewFileChunkRepository(db *mongo.Database) *FileChunkRepository {
+	return &FileChunkRepository{
+		Repository: NewRepository(db, database.CollectionFileChunks),
+	}
+}
+
+// Put stores a chunk, replacing one stored earlier at the same offset.
+func (r *FileChunkRepository) Put(ctx context.Context, transferID primitive.ObjectID, offset int64, data []byte) error {
+	_, err := r.Collection.UpdateOne(ctx,
+		bson.M{"transfer_id": transferID, "offset": offset},
+		bson.M{"$set": bson.M{"data": data, "created_at": time.Now()}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// At returns the chunk holding offset, or nil when there is none.
+func (r *FileChunkRepository) At(ctx context.Context, transferID primitive.ObjectID, offset int64) (*models.FileChunk, error) {
+	var chunk models.FileChunk
+	err := r.Collection.FindOne(ctx,
+		bson.M{"transfer_id": transferID, "offset": bson.M{"$lte": offset}},
+		options.FindOne().SetSort(bson.D{{Key: "offset", Value: -1}}),
+	).Decode(&chunk)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if offset >= chunk.Offset+int64(len(chunk.Data)) {
+		return nil, nil
+	}
+	return &chunk, nil
+}
+
+// Each calls fn with the chunks of a transfer in order of offset, stopping
+// at the first error.
+func (r *FileChunkRepository) Each(ctx context.Context, transferID primitive.ObjectID, fn func(*models.FileChunk) error) error {
+	cursor, err := r.Collection.Find(ctx, bson.M{"transfer_id": transferID},
+		options.Find().SetSort(bson.D{{Key: "offset", Value: 1}}).SetBatchSize(4))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var chunk models.FileChunk
+		if err := cursor.Decode(&chunk); err != nil {
+			return err
+		}
+		if err := fn(&chunk); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// DeleteByTransferID removes the content of a transfer.
+func (r *FileChunkRepository) DeleteByTransferID(ctx context.Context, transferID primitive.ObjectID) error {
+	_, err := r.Collection.DeleteMany(ctx, bson.M{"transfer_id": transferID})
+	return err
+}
@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/lute/api/database"
+	"github.com/lute/api/models"
+)
+
+// FileEventRepository handles the file_events collection.
+type FileEventRepository struct {
+	*Repository
+}
+
+// NewFileEventRepository creates a new FileEventRepository.
+func NewFileEventRepository(db *mongo.Database) *FileEventRepository {
+	return &FileEventRepository{
+		Repository: NewRepository(db, database.CollectionFileEvents),
+	}
+}
+
+// InsertBatch stores events sent by an agent. An event already stored (the
+// agent sent the batch again because the ack was lost) is left unchanged, so
+// its acknowledgement is kept.
+func (r *FileEventRepository) InsertBatch(ctx context.Context, events []*models.FileEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	writes := make([]mongo.WriteModel, 0, len(events))
+	for _, e := range events {
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"machine_id": e.MachineID, "seq": e.Seq, "path": e.Path, "detected_at": e.DetectedAt}).
+			SetUpdate(bson.M{"$setOnInsert": e}).
+			SetUpsert(true))
+	}
+	_, err := r.Collection.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+	return err
+}
+
+func (r *FileEventRepository) GetByID(ctx context.Context, id primitive.ObjectID) (*models.FileEvent, error) {
+	var event models.FileEvent
+	if err := r.Collection.FindOne(ctx, bson.M{"_id": id}).Decode(&event); err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+// FileEventFilter selects file events.
+type FileEventFilter struct {
+	MachineIDs   []primitive.ObjectID
+	Acknowledged *bool // nil for both
+	Path         string
+}
+
+func (f FileEventFilter) query() bson.M {
+	q := bson.M{"machine_id": bson.M{"$in": f.MachineIDs}}
+	if f.Acknowledged != nil {
+		q["acknowledged"] = *f.Acknowledged
+	}
+	if f.Path != "" {
+		q["path"] = f.Path
+	}
+	return q
+}
+
+// List returns up to limit events matching filter, newest first.
+func (r *FileEventRepository) List(ctx context.Context, filter FileEventFilter, limit int64) ([]*models.FileEvent, error) {
+	if len(filter.MachineIDs) == 0 {
+		return nil, nil
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "detected_at", Value: -1}, {Key: "seq", Value: -1}}).SetLimit(limit)
+	cursor, err := r.Collection.Find(ctx, filter.query(), opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var out []*models.FileEvent
+	if err := cursor.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Acknowledge marks the unacknowledged events matching filter as
+// acknowledged by userID and returns how many there were.
+func (r *FileEventRepository) Acknowledge(ctx context.Context, filter FileEventFilter, userID primitive.ObjectID, note string) (int64, error) {
+	if len(filter.MachineIDs) == 0 {
+		return 0, nil
+	}
+	q := filter.query()
+	q["acknowledged"] = false
+	res, err := r.Collection.UpdateMany(ctx, q, bson.M{"$set": bson.M{
+		"acknowledged":    true,
+		"acknowledged_by": userID,
+		"acknowledged_at": time.Now().UTC(),
+		"ack_note":        note,
+	}})
+	if err != nil {
+		return 0, err
+	}
+	return res.ModifiedCount, nil
+}
+
+// AcknowledgeByID marks one event as acknowledged. It reports false when the
+// event was already acknowledged.
+func (r *FileEventRepository) AcknowledgeByID(ctx context.Context, id, userID primitive.ObjectID, note string) (bool, error) {
+	res, err := r.Collection.UpdateOne(ctx, bson.M{"_id": id, "acknowledged": false}, bson.M{"$set": bson.M{
+		"acknowledged":    true,
+		"acknowledged_by": userID,
+		"acknowledged_at": time.Now().UTC(),
+		"ack_note":        note,
+	}})
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount > 0, nil
+}
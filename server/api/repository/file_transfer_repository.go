@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/lute/api/database"
+	"github.com/lute/api/models"
+)
+
+// openTransfer matches transfers that are waiting for or being copied.
+var openTransfer = bson.M{"$in": bson.A{models.FileTransferPending, models.FileTransferRunning}}
+
+// FileTransferRepository handles the file_transfers collection.
+type FileTransferRepository struct {
+	*Repository
+}
+
+// NewFileTransferRepository creates a new FileTransferRepository.
+func NewFileTransferRepository(db *mongo.Database) *FileTransferRepository {
+	return &FileTransferRepository{
+		Repository: NewRepository(db, database.CollectionFileTransfers),
+	}
+}
+
+func (r *FileTransferRepository) Create(ctx context.Context, t *models.FileTransfer) error {
+	t.BeforeCreate()
+	if t.Status == "" {
+		t.Status = models.FileTransferPending
+	}
+	_, err := r.Collection.InsertOne(ctx, t)
+	return err
+}
+
+func (r *FileTransferRepository) GetByID(ctx context.Context, id primitive.ObjectID) (*models.FileTransfer, error) {
+	var t models.FileTransfer
+	if err := r.Collection.FindOne(ctx, bson.M{"_id": id}).Decode(&t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// GetOpen returns the open transfer id of a machine, or nil.
+func (r *FileTransferRepository) GetOpen(ctx context.Context, id, machineID primitive.ObjectID) (*models.FileTransfer, error) {
+	var t models.FileTransfer
+	err := r.Collection.FindOne(ctx, bson.M{"_id": id, "machine_id": machineID, "status": openTransfer}).Decode(&t)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// GetOpenByMachineID returns the transfers of a machine that are pending or
+// were started without finishing, oldest first.
+func (r *FileTransferRepository) GetOpenByMachineID(ctx context.Context, machineID primitive.ObjectID) ([]*models.FileTransfer, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	return r.find(ctx, bson.M{"machine_id": machineID, "status": openTransfer}, opts)
+}
+
+// GetByMachineID returns up to limit transfers of a machine, newest first.
+func (r *FileTransferRepository) GetByMachineID(ctx context.Context, machineID primitive.ObjectID, limit int64) ([]*models.FileTransfer, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(limit)
+	return r.find(ctx, bson.M{"machine_id": machineID}, opts)
+}
+
+func (r *FileTransferRepository) find(ctx context.Context, filter bson.M, opts *options.FindOptions) ([]*models.FileTransfer, error) {
+	cursor, err := r.Collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var transfers []*models.FileTransfer
+	if err := cursor.All(ctx, &transfers); err != nil {
+		return nil, err
+	}
+	return transfers, nil
+}
+
+// MarkRunning marks a pending transfer as running.
+func (r *FileTransferRepository) MarkRunning(ctx context.Context, id primitive.ObjectID) error {
+	_, err := r.Collection.UpdateOne(ctx,
+		bson.M{"_id": id, "status": models.FileTransferPending},
+		bson.M{"$set": bson.M{"status": models.FileTransferRunning, "updated_at": time.Now()}},
+	)
+	return err
+}
+
+// SetProgress records how much of an open transfer the receiving side holds
+// and, for downloads, the size of the file.
+func (r *FileTransferRepository) SetProgress(ctx context.Context, id primitive.ObjectID, received, size int64) error {
+	_, err := r.Collection.UpdateOne(ctx,
+		bson.M{"_id": id, "status": openTransfer},
+		bson.M{"$set": bson.M{"received": received, "size": size, "updated_at": time.Now()}},
+	)
+	return err
+}
+
+// Finish records the outcome of an open transfer: completed with the
+// checksum of the file, or failed with errMsg. It reports false when the
+// transfer is no longer open.
+func (r *FileTransferRepository) Finish(ctx context.Context, id primitive.ObjectID, status, sha256, errMsg string) (bool, error) {
+	now := time.Now().UTC()
+	set := bson.M{"status": status, "updated_at": now, "completed_at": now}
+	if sha256 != "" {
+		set["sha256"] = sha256
+	}
+	if errMsg != "" {
+		set["error"] = errMsg
+	}
+	res, err := r.Collection.UpdateOne(ctx, bson.M{"_id": id, "status": openTransfer}, bson.M{"$set": set})
+	if err != nil {
+		return false, err
+	}
+	return res.MatchedCount > 0, nil
+}
@@ -0,0 +1,46 @@
+package repository
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/lute/api/database"
+	"github.com/lute/api/models"
+)
+
+// InventoryChangeRepository handles the inventory_changes collection.
+type InventoryChangeRepository struct {
+	*Repository
+}
+
+// NewInventoryChangeRepository creates a new InventoryChangeRepository.
+func NewInventoryChangeRepository(db *mongo.Database) *InventoryChangeRepository {
+	return &InventoryChangeRepository{
+		Repository: NewRepository(db, database.CollectionInventoryChanges),
+	}
+}
+
+func (r *InventoryChangeRepository) Insert(ctx context.Context, change *models.InventoryChange) error {
+	_, err := r.Collection.InsertOne(ctx, change)
+	return err
+}
+
+// GetByMachineID returns up to limit changes of a machine, newest first.
+func (r *InventoryChangeRepository) GetByMachineID(ctx context.Context, machineID primitive.ObjectID, limit int64) ([]*models.InventoryChange, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "at", Value: -1}}).SetLimit(limit)
+	cursor, err := r.Collection.Find(ctx, bson.M{"machine_id": machineID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var out []*models.InventoryChange
+	if err := cursor.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,118 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/lute/api/database"
+	"github.com/lute/api/models"
+)
+
+// JobRepository handles the jobs collection.
+type JobRepository struct {
+	*Repository
+}
+
+// NewJobRepository creates a new JobRepository.
+func NewJobRepository(db *mongo.Database) *JobRepository {
+	return &JobRepository{
+		Repository: NewRepository(db, database.CollectionJobs),
+	}
+}
+
+func (r *JobRepository) Create(ctx context.Context, job *models.Job) error {
+	job.BeforeCreate()
+	if job.Status == "" {
+		job.Status = models.JobRunning
+	}
+	_, err := r.Collection.InsertOne(ctx, job)
+	return err
+}
+
+func (r *JobRepository) GetByID(ctx context.Context, id primitive.ObjectID) (*models.Job, error) {
+	var job models.Job
+	if err := r.Collection.FindOne(ctx, bson.M{"_id": id}).Decode(&job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// GetVisible returns up to limit of the user's personal jobs plus those of
+// the given orgs, newest first, without their machines.
+func (r *JobRepository) GetVisible(ctx context.Context, userID primitive.ObjectID, orgIDs []primitive.ObjectID, limit int64) ([]*models.Job, error) {
+	or := []bson.M{{"user_id": userID, "org_id": bson.M{"$exists": false}}}
+	if len(orgIDs) > 0 {
+		or = append(or, bson.M{"org_id": bson.M{"$in": orgIDs}})
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetLimit(limit).
+		SetProjection(bson.M{"machines": 0})
+	return r.find(ctx, bson.M{"$or": or}, opts)
+}
+
+// GetRunning returns the jobs that are still running.
+func (r *JobRepository) GetRunning(ctx context.Context) ([]*models.Job, error) {
+	return r.find(ctx, bson.M{"status": models.JobRunning}, nil)
+}
+
+func (r *JobRepository) find(ctx context.Context, filter bson.M, opts *options.FindOptions) ([]*models.Job, error) {
+	cursor, err := r.Collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var jobs []*models.Job
+	if err := cursor.All(ctx, &jobs); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// Save stores the progress of a running job read at job.Revision. It
+// reports false, leaving job unchanged, when the job was saved since.
+func (r *JobRepository) Save(ctx context.Context, job *models.Job) (bool, error) {
+	now := time.Now()
+	res, err := r.Collection.UpdateOne(ctx,
+		bson.M{"_id": job.ID, "revision": job.Revision},
+		bson.M{
+			"$set": bson.M{
+				"machines":    job.Machines,
+				"summary":     job.Summary,
+				"status":      job.Status,
+				"stop_reason": job.StopReason,
+				"finished_at": job.FinishedAt,
+				"updated_at":  now,
+			},
+			"$inc": bson.M{"revision": 1},
+		},
+	)
+	if err != nil {
+		return false, err
+	}
+	if res.MatchedCount == 0 {
+		return false, nil
+	}
+	job.Revision++
+	job.UpdatedAt = now
+	return true, nil
+}
+
+// RequestCancel asks the job runner to cancel a running job. It reports
+// false when the job is no longer running.
+func (r *JobRepository) RequestCancel(ctx context.Context, id primitive.ObjectID) (bool, error) {
+	res, err := r.Collection.UpdateOne(ctx,
+		bson.M{"_id": id, "status": models.JobRunning},
+		bson.M{"$set": bson.M{"cancel_requested": true, "updated_at": time.Now()}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return res.MatchedCount > 0, nil
+}
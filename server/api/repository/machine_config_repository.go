@@ -0,0 +1,131 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/lute/api/database"
+	"github.com/lute/api/models"
+)
+
+// MachineConfigRepository handles the machine_configs collection: at most
+// one config per machine and one per group.
+type MachineConfigRepository struct {
+	*Repository
+}
+
+// NewMachineConfigRepository creates a new MachineConfigRepository.
+func NewMachineConfigRepository(db *mongo.Database) *MachineConfigRepository {
+	return &MachineConfigRepository{
+		Repository: NewRepository(db, database.CollectionMachineConfigs),
+	}
+}
+
+// GetByMachine returns the machine's own config, or mongo.ErrNoDocuments.
+func (r *MachineConfigRepository) GetByMachine(ctx context.Context, machineID primitive.ObjectID) (*models.MachineConfig, error) {
+	return r.findOne(ctx, bson.M{"machine_id": machineID})
+}
+
+// GetByGroup returns the group's config, or mongo.ErrNoDocuments.
+func (r *MachineConfigRepository) GetByGroup(ctx context.Context, groupID primitive.ObjectID) (*models.MachineConfig, error) {
+	return r.findOne(ctx, bson.M{"group_id": groupID})
+}
+
+func (r *MachineConfigRepository) findOne(ctx context.Context, filter bson.M) (*models.MachineConfig, error) {
+	var cfg models.MachineConfig
+	if err := r.Collection.FindOne(ctx, filter).Decode(&cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// GetForMachine returns the configs that apply to a machine: those of its
+// groups, oldest first, then its own.
+func (r *MachineConfigRepository) GetForMachine(ctx context.Context, m *models.Machine) ([]*models.MachineConfig, error) {
+	or := []bson.M{{"machine_id": m.ID}}
+	if len(m.GroupIDs) > 0 {
+		or = append(or, bson.M{"group_id": bson.M{"$in": m.GroupIDs}})
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := r.Collection.Find(ctx, bson.M{"$or": or}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var configs, own []*models.MachineConfig
+	for cursor.Next(ctx) {
+		var cfg models.MachineConfig
+		if err := cursor.Decode(&cfg); err != nil {
+			return nil, err
+		}
+		if cfg.MachineID == m.ID {
+			own = append(own, &cfg)
+		} else {
+			configs = append(configs, &cfg)
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+	return append(configs, own...), nil
+}
+
+// Save creates or replaces the settings of the config of cfg.MachineID or
+// cfg.GroupID and bumps its version. cfg is updated from the stored
+// document.
+func (r *MachineConfigRepository) Save(ctx context.Context, cfg *models.MachineConfig) error {
+	filter := bson.M{"machine_id": cfg.MachineID}
+	if cfg.MachineID.IsZero() {
+		filter = bson.M{"group_id": cfg.GroupID}
+	}
+	now := time.Now()
+	s := cfg.AgentSettings
+	update := bson.M{
+		"$set": bson.M{
+			"heartbeat_interval":      s.HeartbeatInterval,
+			"log_level":               s.LogLevel,
+			"collectors":              s.Collectors,
+			"offline_sample_interval": s.OfflineSampleInterval,
+			"inventory_interval":      s.InventoryInterval,
+			"units_interval":          s.UnitsInterval,
+			"extra":                   s.Extra,
+			"updated_by":              cfg.UpdatedBy,
+			"updated_at":              now,
+		},
+		"$setOnInsert": bson.M{"created_at": now},
+		"$inc":         bson.M{"version": 1},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	var saved models.MachineConfig
+	if err := r.Collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&saved); err != nil {
+		return err
+	}
+	*cfg = saved
+	return nil
+}
+
+// DeleteByMachine removes the machine's own config, reporting whether there
+// was one.
+func (r *MachineConfigRepository) DeleteByMachine(ctx context.Context, machineID primitive.ObjectID) (bool, error) {
+	res, err := r.Collection.DeleteOne(ctx, bson.M{"machine_id": machineID})
+	if err != nil {
+		return false, err
+	}
+	return res.DeletedCount > 0, nil
+}
+
+// DeleteByGroup removes the group's config, reporting whether there was
+// one.
+func (r *MachineConfigRepository) DeleteByGroup(ctx context.Context, groupID primitive.ObjectID) (bool, error) {
+	res, err := r.Collection.DeleteOne(ctx, bson.M{"group_id": groupID})
+	if err != nil {
+		return false, err
+	}
+	return res.DeletedCount > 0, nil
+}
@@ -0,0 +1,78 @@
+package repository
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/lute/api/database"
+	"github.com/lute/api/models"
+)
+
+// MachineGroupRepository handles the machine_groups collection.
+type MachineGroupRepository struct {
+	*Repository
+}
+
+// NewMachineGroupRepository creates a new MachineGroupRepository.
+func NewMachineGroupRepository(db *mongo.Database) *MachineGroupRepository {
+	return &MachineGroupRepository{
+		Repository: NewRepository(db, database.CollectionMachineGroups),
+	}
+}
+
+func (r *MachineGroupRepository) Create(ctx context.Context, group *models.MachineGroup) error {
+	group.BeforeCreate()
+	_, err := r.Collection.InsertOne(ctx, group)
+	return err
+}
+
+func (r *MachineGroupRepository) GetByID(ctx context.Context, id primitive.ObjectID) (*models.MachineGroup, error) {
+	var group models.MachineGroup
+	err := r.Collection.FindOne(ctx, bson.M{"_id": id}).Decode(&group)
+	if err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
+
+// GetByUserID returns the personal groups of a user and the groups of the
+// given orgs, sorted by name.
+func (r *MachineGroupRepository) GetByUserID(ctx context.Context, userID primitive.ObjectID, orgIDs []primitive.ObjectID) ([]*models.MachineGroup, error) {
+	visible := []bson.M{{"user_id": userID, "org_id": bson.M{"$exists": false}}}
+	if len(orgIDs) > 0 {
+		visible = append(visible, bson.M{"org_id": bson.M{"$in": orgIDs}})
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "name", Value: 1}})
+	cursor, err := r.Collection.Find(ctx, bson.M{"$or": visible}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var groups []*models.MachineGroup
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+func (r *MachineGroupRepository) Update(ctx context.Context, id primitive.ObjectID, group *models.MachineGroup) error {
+	group.BeforeUpdate()
+	_, err := r.Collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{
+		"$set": bson.M{
+			"name":        group.Name,
+			"description": group.Description,
+			"updated_at":  group.UpdatedAt,
+		},
+	})
+	return err
+}
+
+func (r *MachineGroupRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	_, err := r.Collection.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
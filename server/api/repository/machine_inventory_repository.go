@@ -0,0 +1,105 @@
+package repository
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/lute/api/database"
+	"github.com/lute/api/models"
+)
+
+// MachineInventoryRepository handles the machine_inventories collection (one
+// document per machine).
+type MachineInventoryRepository struct {
+	*Repository
+}
+
+// NewMachineInventoryRepository creates a new MachineInventoryRepository.
+func NewMachineInventoryRepository(db *mongo.Database) *MachineInventoryRepository {
+	return &MachineInventoryRepository{
+		Repository: NewRepository(db, database.CollectionMachineInventories),
+	}
+}
+
+// Get returns the inventory of a machine (mongo.ErrNoDocuments if it has none).
+func (r *MachineInventoryRepository) Get(ctx context.Context, machineID primitive.ObjectID) (*models.MachineInventory, error) {
+	var inv models.MachineInventory
+	if err := r.Collection.FindOne(ctx, bson.M{"machine_id": machineID}).Decode(&inv); err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
+
+// GetHash returns the hash of a machine's inventory, or "" if it has none.
+func (r *MachineInventoryRepository) GetHash(ctx context.Context, machineID primitive.ObjectID) (string, error) {
+	var doc struct {
+		Hash string `bson:"hash"`
+	}
+	opts := options.FindOne().SetProjection(bson.M{"hash": 1})
+	err := r.Collection.FindOne(ctx, bson.M{"machine_id": machineID}, opts).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return "", nil
+	}
+	return doc.Hash, err
+}
+
+// Replace stores inv as the machine's inventory.
+func (r *MachineInventoryRepository) Replace(ctx context.Context, inv *models.MachineInventory) error {
+	_, err := r.Collection.ReplaceOne(ctx, bson.M{"machine_id": inv.MachineID}, inv, options.Replace().SetUpsert(true))
+	return err
+}
+
+// MachineIDs returns the IDs of the machines that reported an inventory.
+func (r *MachineInventoryRepository) MachineIDs(ctx context.Context) ([]primitive.ObjectID, error) {
+	values, err := r.Collection.Distinct(ctx, "machine_id", bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	out := make([]primitive.ObjectID, 0, len(values))
+	for _, v := range values {
+		if id, ok := v.(primitive.ObjectID); ok {
+			out = append(out, id)
+		}
+	}
+	return out, nil
+}
+
+// PackageMatches is a machine with the installed packages of one name.
+type PackageMatches struct {
+	MachineID primitive.ObjectID        `bson:"machine_id"`
+	Packages  []models.InstalledPackage `bson:"packages"`
+}
+
+// FindPackage returns, for each of the machines that has a package called
+// name, its installed packages of that name (one per architecture).
+func (r *MachineInventoryRepository) FindPackage(ctx context.Context, machineIDs []primitive.ObjectID, name string) ([]*PackageMatches, error) {
+	if len(machineIDs) == 0 {
+		return nil, nil
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"machine_id": bson.M{"$in": machineIDs}, "packages.name": name}}},
+		{{Key: "$project", Value: bson.M{
+			"machine_id": 1,
+			"packages": bson.M{"$filter": bson.M{
+				"input": "$packages",
+				"as":    "p",
+				"cond":  bson.M{"$eq": bson.A{"$$p.name", name}},
+			}},
+		}}},
+	}
+	cursor, err := r.Collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var out []*PackageMatches
+	if err := cursor.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
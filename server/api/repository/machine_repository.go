@@ -38,12 +38,24 @@ func (r *MachineRepository) GetByID(ctx context.Context, id primitive.ObjectID)
 }
 
 func (r *MachineRepository) GetByUserID(ctx context.Context, userID primitive.ObjectID) ([]*models.Machine, error) {
+	return r.GetByUserIDMatching(ctx, userID, nil, nil)
+}
+
+// GetByUserIDMatching returns the machines visible to a user: personal machines,
+// machines of the given orgs and legacy agent-registered machines, narrowed by an
+// extra filter (e.g. a label selector or group membership). A nil match returns all.
+func (r *MachineRepository) GetByUserIDMatching(ctx context.Context, userID primitive.ObjectID, orgIDs []primitive.ObjectID, match bson.M) ([]*models.Machine, error) {
 	// Include both user-owned machines AND agent-registered machines (zero user_id)
-	filter := bson.M{
-		"$or": []bson.M{
-			{"user_id": userID},
-			{"user_id": primitive.NilObjectID},
-		},
+	owned := []bson.M{
+		{"user_id": userID, "org_id": bson.M{"$exists": false}},
+		{"user_id": primitive.NilObjectID, "org_id": bson.M{"$exists": false}},
+	}
+	if len(orgIDs) > 0 {
+		owned = append(owned, bson.M{"org_id": bson.M{"$in": orgIDs}})
+	}
+	filter := bson.M{"$or": owned}
+	if len(match) > 0 {
+		filter = bson.M{"$and": []bson.M{filter, match}}
 	}
 	cursor, err := r.Collection.Find(ctx, filter)
 	if err != nil {
@@ -117,6 +129,170 @@ func (r *MachineRepository) List(ctx context.Context, filter bson.M, opts *optio
 	return machines, nil
 }
 
+// UpdateOrg moves a machine into an organization (or back to personal ownership with a nil orgID).
+func (r *MachineRepository) UpdateOrg(ctx context.Context, machineID, orgID primitive.ObjectID) error {
+	update := bson.M{"$set": bson.M{"org_id": orgID, "updated_at": time.Now()}}
+	if orgID.IsZero() {
+		update = bson.M{
+			"$unset": bson.M{"org_id": ""},
+			"$set":   bson.M{"updated_at": time.Now()},
+		}
+	}
+	result, err := r.Collection.UpdateOne(ctx, bson.M{"_id": machineID}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// CountByOrgID returns how many machines belong to an org.
+func (r *MachineRepository) CountByOrgID(ctx context.Context, orgID primitive.ObjectID) (int64, error) {
+	return r.Collection.CountDocuments(ctx, bson.M{"org_id": orgID})
+}
+
+// UpdateLabels replaces the label set of a machine.
+func (r *MachineRepository) UpdateLabels(ctx context.Context, machineID primitive.ObjectID, labels map[string]string) error {
+	update := bson.M{
+		"$set": bson.M{
+			"labels":     labels,
+			"updated_at": time.Now(),
+		},
+	}
+	result, err := r.Collection.UpdateOne(ctx, bson.M{"_id": machineID}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// UpdateDetails sets the user-editable fields of a machine. Nil labels
+// keep the current ones.
+func (r *MachineRepository) UpdateDetails(ctx context.Context, machineID primitive.ObjectID, name, description string, isPublic bool, labels map[string]string) error {
+	set := bson.M{
+		"name":        name,
+		"description": description,
+		"is_public":   isPublic,
+		"updated_at":  time.Now(),
+	}
+	if labels != nil {
+		set["labels"] = labels
+	}
+	return r.updateExisting(ctx, machineID, bson.M{"$set": set})
+}
+
+// PromoteAgentToken makes the pending agent token current once the agent has
+// used it. The filter on nextHash keeps a concurrent rotation from being lost.
+func (r *MachineRepository) PromoteAgentToken(ctx context.Context, machineID primitive.ObjectID, nextHash string) error {
+	now := time.Now()
+	_, err := r.Collection.UpdateOne(ctx,
+		bson.M{"_id": machineID, "agent_token_next_hash": nextHash},
+		bson.M{
+			"$set":   bson.M{"agent_token_hash": nextHash, "agent_token_issued_at": now, "updated_at": now},
+			"$unset": bson.M{"agent_token_next_hash": ""},
+		})
+	return err
+}
+
+// SetNextAgentToken stores the hash of a token handed out by a rotation. The
+// current token stays valid until the agent connects with the new one.
+func (r *MachineRepository) SetNextAgentToken(ctx context.Context, machineID primitive.ObjectID, nextHash string) error {
+	_, err := r.Collection.UpdateOne(ctx, bson.M{"_id": machineID}, bson.M{
+		"$set":   bson.M{"agent_token_next_hash": nextHash, "updated_at": time.Now()},
+		"$unset": bson.M{"agent_token_rotate": ""},
+	})
+	return err
+}
+
+// RequestAgentTokenRotation marks the agent token to be rotated on the next connect.
+func (r *MachineRepository) RequestAgentTokenRotation(ctx context.Context, machineID primitive.ObjectID) error {
+	return r.updateExisting(ctx, machineID, bson.M{
+		"$set": bson.M{"agent_token_rotate": true, "updated_at": time.Now()},
+	})
+}
+
+// ClearAgentToken removes all agent token hashes so the agent can no longer connect.
+func (r *MachineRepository) ClearAgentToken(ctx context.Context, machineID primitive.ObjectID) error {
+	return r.updateExisting(ctx, machineID, bson.M{
+		"$unset": bson.M{"agent_token_hash": "", "agent_token_next_hash": "", "agent_token_issued_at": "", "agent_token_rotate": ""},
+		"$set":   bson.M{"updated_at": time.Now()},
+	})
+}
+
+func (r *MachineRepository) updateExisting(ctx context.Context, machineID primitive.ObjectID, update bson.M) error {
+	result, err := r.Collection.UpdateOne(ctx, bson.M{"_id": machineID}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// SetHeartbeatInterval stores the heartbeat interval set by the machine's
+// config, in seconds; 0 restores the server default.
+func (r *MachineRepository) SetHeartbeatInterval(ctx context.Context, machineID primitive.ObjectID, seconds int) error {
+	update := bson.M{"$set": bson.M{"heartbeat_interval": seconds}}
+	if seconds == 0 {
+		update = bson.M{"$unset": bson.M{"heartbeat_interval": ""}}
+	}
+	_, err := r.Collection.UpdateOne(ctx, bson.M{"_id": machineID}, update)
+	return err
+}
+
+// SetAppliedConfig records the agent's acknowledgement of a pushed config.
+func (r *MachineRepository) SetAppliedConfig(ctx context.Context, machineID primitive.ObjectID, applied *models.AppliedConfig) error {
+	_, err := r.Collection.UpdateOne(ctx, bson.M{"_id": machineID}, bson.M{"$set": bson.M{"applied_config": applied}})
+	return err
+}
+
+// IDsInGroup returns the IDs of the machines in a group.
+func (r *MachineRepository) IDsInGroup(ctx context.Context, groupID primitive.ObjectID) ([]primitive.ObjectID, error) {
+	values, err := r.Collection.Distinct(ctx, "_id", bson.M{"group_ids": groupID})
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]primitive.ObjectID, 0, len(values))
+	for _, v := range values {
+		if id, ok := v.(primitive.ObjectID); ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// AddToGroup adds the group to each machine's group_ids (no duplicates).
+func (r *MachineRepository) AddToGroup(ctx context.Context, groupID primitive.ObjectID, machineIDs []primitive.ObjectID) error {
+	_, err := r.Collection.UpdateMany(ctx,
+		bson.M{"_id": bson.M{"$in": machineIDs}},
+		bson.M{
+			"$addToSet": bson.M{"group_ids": groupID},
+			"$set":      bson.M{"updated_at": time.Now()},
+		},
+	)
+	return err
+}
+
+// RemoveFromGroup removes the group from the given machines. A nil machineIDs
+// removes the group from every machine (used when the group is deleted).
+func (r *MachineRepository) RemoveFromGroup(ctx context.Context, groupID primitive.ObjectID, machineIDs []primitive.ObjectID) error {
+	filter := bson.M{"group_ids": groupID}
+	if machineIDs != nil {
+		filter["_id"] = bson.M{"$in": machineIDs}
+	}
+	_, err := r.Collection.UpdateMany(ctx, filter, bson.M{
+		"$pull": bson.M{"group_ids": groupID},
+		"$set":  bson.M{"updated_at": time.Now()},
+	})
+	return err
+}
+
 // UpdateLastSeen updates the last_seen timestamp for a machine
 func (r *MachineRepository) UpdateLastSeen(ctx context.Context, machineID primitive.ObjectID) error {
 	update := bson.M{
@@ -141,17 +317,26 @@ func (r *MachineRepository) UpdateMetrics(ctx context.Context, machineID primiti
 	return err
 }
 
-// UpdateAgentInfo updates agent-related fields (IP, version, last_seen)
-func (r *MachineRepository) UpdateAgentInfo(ctx context.Context, machineID primitive.ObjectID, ipAddress string, version string) error {
-	update := bson.M{
-		"$set": bson.M{
-			"agent_ip":      ipAddress,
-			"agent_version": version,
-			"last_seen":     time.Now(),
-			"updated_at":    time.Now(),
-		},
+// UpdateAgentInfo updates agent-related fields (IP, version, last_seen) and,
+// if host is not nil, the reported host details. The host's OS and
+// architecture also replace the ones recorded at registration.
+func (r *MachineRepository) UpdateAgentInfo(ctx context.Context, machineID primitive.ObjectID, ipAddress string, version string, host *models.HostInfo) error {
+	set := bson.M{
+		"agent_ip":      ipAddress,
+		"agent_version": version,
+		"last_seen":     time.Now(),
+		"updated_at":    time.Now(),
 	}
-	_, err := r.Collection.UpdateOne(ctx, bson.M{"_id": machineID}, update)
+	if host != nil {
+		set["host"] = host
+		if host.OS != "" {
+			set["metadata.os"] = host.OS
+		}
+		if host.Arch != "" {
+			set["metadata.arch"] = host.Arch
+		}
+	}
+	_, err := r.Collection.UpdateOne(ctx, bson.M{"_id": machineID}, bson.M{"$set": set})
 	return err
 }
 
@@ -2,6 +2,8 @@ package repository
 
 import (
 	"context"
+	"fmt"
+	"slices"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -13,15 +15,28 @@ import (
 	"github.com/lute/api/models"
 )
 
-// MachineSnapshotRepository handles machine_snapshots collection.
+// Snapshot resolutions: the snapshots themselves and their per-minute and
+// per-hour rollups.
+const (
+	ResolutionRaw    = "raw"
+	ResolutionMinute = "1m"
+	ResolutionHour   = "1h"
+)
+
+// MachineSnapshotRepository handles the machine_snapshots time-series
+// collection and the collections of its rollups.
 type MachineSnapshotRepository struct {
 	*Repository
+	minutes *mongo.Collection
+	hours   *mongo.Collection
 }
 
 // NewMachineSnapshotRepository creates a new MachineSnapshotRepository.
 func NewMachineSnapshotRepository(db *mongo.Database) *MachineSnapshotRepository {
 	return &MachineSnapshotRepository{
 		Repository: NewRepository(db, database.CollectionMachineSnapshots),
+		minutes:    db.Collection(database.CollectionSnapshots1m),
+		hours:      db.Collection(database.CollectionSnapshots1h),
 	}
 }
 
@@ -37,30 +52,187 @@ func (r *MachineSnapshotRepository) Insert(ctx context.Context, machineID primit
 	return err
 }
 
-// GetByMachineID returns snapshots for one machine since the given time, sorted by at ascending.
-func (r *MachineSnapshotRepository) GetByMachineID(ctx context.Context, machineID primitive.ObjectID, since time.Time) ([]*models.MachineSnapshot, error) {
-	return r.GetByMachineIDs(ctx, []primitive.ObjectID{machineID}, since)
+// InsertBackfill stores snapshots an agent sampled while offline. It is
+// idempotent: a snapshot is skipped when the machine already has one at the
+// same time, so replayed batches do not create duplicates.
+func (r *MachineSnapshotRepository) InsertBackfill(ctx context.Context, snapshots []*models.MachineSnapshot) error {
+	if len(snapshots) == 0 {
+		return nil
+	}
+	machineIDs := make([]primitive.ObjectID, 0, 1)
+	times := make([]time.Time, 0, len(snapshots))
+	for _, s := range snapshots {
+		if !slices.Contains(machineIDs, s.MachineID) {
+			machineIDs = append(machineIDs, s.MachineID)
+		}
+		times = append(times, s.At)
+	}
+	// Time-series collections have no unique indexes, so existing snapshots
+	// are looked up first.
+	cursor, err := r.Collection.Find(ctx, bson.M{
+		"machine_id": bson.M{"$in": machineIDs},
+		"at":         bson.M{"$in": times},
+	}, options.Find().SetProjection(bson.M{"machine_id": 1, "at": 1}))
+	if err != nil {
+		return err
+	}
+	var existing []*models.MachineSnapshot
+	if err := cursor.All(ctx, &existing); err != nil {
+		return err
+	}
+	type key struct {
+		machineID primitive.ObjectID
+		at        int64
+	}
+	seen := make(map[key]bool, len(existing)+len(snapshots))
+	for _, s := range existing {
+		seen[key{s.MachineID, s.At.UnixMilli()}] = true
+	}
+	docs := make([]interface{}, 0, len(snapshots))
+	for _, s := range snapshots {
+		k := key{s.MachineID, s.At.UnixMilli()}
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		s.Backfilled = true
+		docs = append(docs, s)
+	}
+	if len(docs) == 0 {
+		return nil
+	}
+	_, err = r.Collection.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	return err
 }
 
-// GetByMachineIDs returns snapshots for any of the given machine IDs since the given time, sorted by at ascending.
-func (r *MachineSnapshotRepository) GetByMachineIDs(ctx context.Context, machineIDs []primitive.ObjectID, since time.Time) ([]*models.MachineSnapshot, error) {
+// Rollup recomputes the rollups of resolution res (ResolutionMinute or
+// ResolutionHour) for the periods from the one containing from to the one
+// containing to, of the given machines or, with none, of all. Per-minute
+// rollups are computed from the snapshots, per-hour ones from the
+// per-minute ones. Periods are always recomputed whole.
+func (r *MachineSnapshotRepository) Rollup(ctx context.Context, res string, from, to time.Time, machineIDs ...primitive.ObjectID) error {
+	var src string
+	var step time.Duration
+	switch res {
+	case ResolutionMinute:
+		src, step = ResolutionRaw, time.Minute
+	case ResolutionHour:
+		src, step = ResolutionMinute, time.Hour
+	default:
+		return fmt.Errorf("no rollups of resolution %q", res)
+	}
+	match := bson.M{"at": bson.M{"$gte": from.Truncate(step), "$lt": to.Truncate(step).Add(step)}}
+	if len(machineIDs) > 0 {
+		match["machine_id"] = bson.M{"$in": machineIDs}
+	}
+	pipeline := append(summarize(src, match, step, true), bson.M{"$merge": bson.M{
+		"into":           r.collection(res).Name(),
+		"on":             bson.A{"machine_id", "at"},
+		"whenMatched":    "replace",
+		"whenNotMatched": "insert",
+	}})
+	cursor, err := r.collection(src).Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return err
+	}
+	return cursor.Close(ctx)
+}
+
+// LatestRollup returns the start of the newest rollup of resolution res,
+// or the zero time when there is none.
+func (r *MachineSnapshotRepository) LatestRollup(ctx context.Context, res string) (time.Time, error) {
+	var latest models.SnapshotRollup
+	err := r.collection(res).FindOne(ctx, bson.M{}, options.FindOne().
+		SetSort(bson.M{"at": -1}).
+		SetProjection(bson.M{"at": 1})).Decode(&latest)
+	if err == mongo.ErrNoDocuments {
+		return time.Time{}, nil
+	}
+	return latest.At, err
+}
+
+// Chart summarizes the snapshots or rollups of resolution res of the given
+// machines since the given time into buckets of step, sorted by at
+// ascending. Buckets start at multiples of step since the Unix epoch; those
+// without data are left out.
+func (r *MachineSnapshotRepository) Chart(ctx context.Context, res string, machineIDs []primitive.ObjectID, since time.Time, step time.Duration) ([]*models.SnapshotRollup, error) {
 	if len(machineIDs) == 0 {
 		return nil, nil
 	}
-	filter := bson.M{
+	match := bson.M{
 		"machine_id": bson.M{"$in": machineIDs},
 		"at":         bson.M{"$gte": since},
 	}
-	opts := options.Find().SetSort(bson.M{"at": 1})
-	cursor, err := r.Collection.Find(ctx, filter, opts)
+	pipeline := append(summarize(res, match, step, false), bson.M{"$sort": bson.M{"at": 1}})
+	cursor, err := r.collection(res).Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
 	if err != nil {
 		return nil, err
 	}
 	defer cursor.Close(ctx)
 
-	var out []*models.MachineSnapshot
+	var out []*models.SnapshotRollup
 	if err := cursor.All(ctx, &out); err != nil {
 		return nil, err
 	}
 	return out, nil
 }
+
+func (r *MachineSnapshotRepository) collection(res string) *mongo.Collection {
+	switch res {
+	case ResolutionMinute:
+		return r.minutes
+	case ResolutionHour:
+		return r.hours
+	}
+	return r.Collection
+}
+
+// summarize returns the stages grouping the matching snapshots or rollups
+// of resolution res into periods of step, per machine when perMachine is
+// set, into documents shaped like models.SnapshotRollup. Averages of
+// rollups are weighted by their count.
+func summarize(res string, match bson.M, step time.Duration, perMachine bool) bson.A {
+	// Bring snapshots and rollups to the same shape: a count and, per
+	// metric, the lowest and highest value and the sum.
+	fields := bson.M{"machine_id": 1, "at": 1, "n": bson.M{"$literal": 1}}
+	if res != ResolutionRaw {
+		fields["n"] = "$count"
+	}
+	group := bson.M{"count": bson.M{"$sum": "$n"}}
+	metrics := bson.M{}
+	for _, k := range models.SnapshotMetrics {
+		if res == ResolutionRaw {
+			fields[k+"_min"] = "$metrics." + k
+			fields[k+"_max"] = "$metrics." + k
+			fields[k+"_sum"] = "$metrics." + k
+		} else {
+			fields[k+"_min"] = "$metrics." + k + ".min"
+			fields[k+"_max"] = "$metrics." + k + ".max"
+			fields[k+"_sum"] = bson.M{"$multiply": bson.A{"$metrics." + k + ".avg", "$count"}}
+		}
+		group[k+"_min"] = bson.M{"$min": "$" + k + "_min"}
+		group[k+"_max"] = bson.M{"$max": "$" + k + "_max"}
+		group[k+"_sum"] = bson.M{"$sum": "$" + k + "_sum"}
+		metrics[k] = bson.M{
+			"min": "$" + k + "_min",
+			"avg": bson.M{"$divide": bson.A{"$" + k + "_sum", "$count"}},
+			"max": "$" + k + "_max",
+		}
+	}
+	// Periods start at multiples of step since the Unix epoch
+	at := bson.M{"$toLong": "$at"}
+	start := bson.M{"$toDate": bson.M{"$subtract": bson.A{at, bson.M{"$mod": bson.A{at, step.Milliseconds()}}}}}
+	id := bson.M{"at": start}
+	out := bson.M{"_id": 0, "at": "$_id.at", "count": 1, "metrics": metrics}
+	if perMachine {
+		id["machine_id"] = "$machine_id"
+		out["machine_id"] = "$_id.machine_id"
+	}
+	group["_id"] = id
+	return bson.A{
+		bson.M{"$match": match},
+		bson.M{"$project": fields},
+		bson.M{"$group": group},
+		bson.M{"$project": out},
+	}
+}
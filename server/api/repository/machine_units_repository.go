@@ -0,0 +1,92 @@
+package repository
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/lute/api/database"
+	"github.com/lute/api/models"
+)
+
+// MachineUnitsRepository handles the machine_units collection (one document
+// per machine).
+type MachineUnitsRepository struct {
+	*Repository
+}
+
+// NewMachineUnitsRepository creates a new MachineUnitsRepository.
+func NewMachineUnitsRepository(db *mongo.Database) *MachineUnitsRepository {
+	return &MachineUnitsRepository{
+		Repository: NewRepository(db, database.CollectionMachineUnits),
+	}
+}
+
+// Get returns the units of a machine (mongo.ErrNoDocuments if it reported none).
+func (r *MachineUnitsRepository) Get(ctx context.Context, machineID primitive.ObjectID) (*models.MachineUnits, error) {
+	var units models.MachineUnits
+	if err := r.Collection.FindOne(ctx, bson.M{"machine_id": machineID}).Decode(&units); err != nil {
+		return nil, err
+	}
+	return &units, nil
+}
+
+// Replace stores units as the machine's units.
+func (r *MachineUnitsRepository) Replace(ctx context.Context, units *models.MachineUnits) error {
+	_, err := r.Collection.ReplaceOne(ctx, bson.M{"machine_id": units.MachineID}, units, options.Replace().SetUpsert(true))
+	return err
+}
+
+// MachineIDs returns the IDs of the machines that reported units.
+func (r *MachineUnitsRepository) MachineIDs(ctx context.Context) ([]primitive.ObjectID, error) {
+	values, err := r.Collection.Distinct(ctx, "machine_id", bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	out := make([]primitive.ObjectID, 0, len(values))
+	for _, v := range values {
+		if id, ok := v.(primitive.ObjectID); ok {
+			out = append(out, id)
+		}
+	}
+	return out, nil
+}
+
+// UnitMatches is a machine with some of its units.
+type UnitMatches struct {
+	MachineID primitive.ObjectID   `bson:"machine_id"`
+	Units     []models.SystemdUnit `bson:"units"`
+}
+
+// FindFailed returns, for each of the machines that has failed units, those
+// units.
+func (r *MachineUnitsRepository) FindFailed(ctx context.Context, machineIDs []primitive.ObjectID) ([]*UnitMatches, error) {
+	if len(machineIDs) == 0 {
+		return nil, nil
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"machine_id": bson.M{"$in": machineIDs}, "units.active_state": "failed"}}},
+		{{Key: "$project", Value: bson.M{
+			"machine_id": 1,
+			"units": bson.M{"$filter": bson.M{
+				"input": "$units",
+				"as":    "u",
+				"cond":  bson.M{"$eq": bson.A{"$$u.active_state", "failed"}},
+			}},
+		}}},
+	}
+	cursor, err := r.Collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var out []*UnitMatches
+	if err := cursor.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,99 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/lute/api/database"
+	"github.com/lute/api/models"
+)
+
+// OrgInviteRepository handles the org_invites collection.
+type OrgInviteRepository struct {
+	*Repository
+}
+
+// NewOrgInviteRepository creates a new OrgInviteRepository.
+func NewOrgInviteRepository(db *mongo.Database) *OrgInviteRepository {
+	return &OrgInviteRepository{
+		Repository: NewRepository(db, database.CollectionOrgInvites),
+	}
+}
+
+func (r *OrgInviteRepository) Create(ctx context.Context, invite *models.OrgInvite) error {
+	invite.BeforeCreate()
+	_, err := r.Collection.InsertOne(ctx, invite)
+	return err
+}
+
+func (r *OrgInviteRepository) GetByID(ctx context.Context, id primitive.ObjectID) (*models.OrgInvite, error) {
+	var invite models.OrgInvite
+	err := r.Collection.FindOne(ctx, bson.M{"_id": id}).Decode(&invite)
+	if err != nil {
+		return nil, err
+	}
+	return &invite, nil
+}
+
+// GetByTokenHash looks up an invite by the SHA-256 hex of its token.
+func (r *OrgInviteRepository) GetByTokenHash(ctx context.Context, tokenHash string) (*models.OrgInvite, error) {
+	var invite models.OrgInvite
+	err := r.Collection.FindOne(ctx, bson.M{"token_hash": tokenHash}).Decode(&invite)
+	if err != nil {
+		return nil, err
+	}
+	return &invite, nil
+}
+
+// GetPendingByOrgID returns invites of an org that are neither accepted nor expired.
+func (r *OrgInviteRepository) GetPendingByOrgID(ctx context.Context, orgID primitive.ObjectID) ([]*models.OrgInvite, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	cursor, err := r.Collection.Find(ctx, bson.M{
+		"org_id":      orgID,
+		"accepted_at": bson.M{"$exists": false},
+		"expires_at":  bson.M{"$gt": time.Now()},
+	}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var invites []*models.OrgInvite
+	if err := cursor.All(ctx, &invites); err != nil {
+		return nil, err
+	}
+	return invites, nil
+}
+
+// MarkAccepted atomically marks a pending invite as accepted. Returns
+// mongo.ErrNoDocuments if it was already accepted.
+func (r *OrgInviteRepository) MarkAccepted(ctx context.Context, id primitive.ObjectID) error {
+	now := time.Now()
+	result, err := r.Collection.UpdateOne(ctx,
+		bson.M{"_id": id, "accepted_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"accepted_at": now, "updated_at": now}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func (r *OrgInviteRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	_, err := r.Collection.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+// DeleteByOrgID removes every invite of an org.
+func (r *OrgInviteRepository) DeleteByOrgID(ctx context.Context, orgID primitive.ObjectID) error {
+	_, err := r.Collection.DeleteMany(ctx, bson.M{"org_id": orgID})
+	return err
+}
@@ -0,0 +1,103 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/lute/api/database"
+	"github.com/lute/api/models"
+)
+
+// OrgMemberRepository handles the org_members collection.
+type OrgMemberRepository struct {
+	*Repository
+}
+
+// NewOrgMemberRepository creates a new OrgMemberRepository.
+func NewOrgMemberRepository(db *mongo.Database) *OrgMemberRepository {
+	return &OrgMemberRepository{
+		Repository: NewRepository(db, database.CollectionOrgMembers),
+	}
+}
+
+func (r *OrgMemberRepository) Create(ctx context.Context, member *models.OrgMember) error {
+	member.BeforeCreate()
+	_, err := r.Collection.InsertOne(ctx, member)
+	return err
+}
+
+// Get returns the membership of a user in an org.
+func (r *OrgMemberRepository) Get(ctx context.Context, orgID, userID primitive.ObjectID) (*models.OrgMember, error) {
+	var member models.OrgMember
+	err := r.Collection.FindOne(ctx, bson.M{"org_id": orgID, "user_id": userID}).Decode(&member)
+	if err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+// GetByOrgID returns all members of an org ordered by join time.
+func (r *OrgMemberRepository) GetByOrgID(ctx context.Context, orgID primitive.ObjectID) ([]*models.OrgMember, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	cursor, err := r.Collection.Find(ctx, bson.M{"org_id": orgID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var members []*models.OrgMember
+	if err := cursor.All(ctx, &members); err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// GetByUserID returns every membership of a user.
+func (r *OrgMemberRepository) GetByUserID(ctx context.Context, userID primitive.ObjectID) ([]*models.OrgMember, error) {
+	cursor, err := r.Collection.Find(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var members []*models.OrgMember
+	if err := cursor.All(ctx, &members); err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// CountByRole returns how many members of the org have the role.
+func (r *OrgMemberRepository) CountByRole(ctx context.Context, orgID primitive.ObjectID, role string) (int64, error) {
+	return r.Collection.CountDocuments(ctx, bson.M{"org_id": orgID, "role": role})
+}
+
+func (r *OrgMemberRepository) UpdateRole(ctx context.Context, orgID, userID primitive.ObjectID, role string) error {
+	result, err := r.Collection.UpdateOne(ctx,
+		bson.M{"org_id": orgID, "user_id": userID},
+		bson.M{"$set": bson.M{"role": role, "updated_at": time.Now()}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func (r *OrgMemberRepository) Delete(ctx context.Context, orgID, userID primitive.ObjectID) error {
+	_, err := r.Collection.DeleteOne(ctx, bson.M{"org_id": orgID, "user_id": userID})
+	return err
+}
+
+// DeleteByOrgID removes every membership of an org (used when the org is deleted).
+func (r *OrgMemberRepository) DeleteByOrgID(ctx context.Context, orgID primitive.ObjectID) error {
+	_, err := r.Collection.DeleteMany(ctx, bson.M{"org_id": orgID})
+	return err
+}
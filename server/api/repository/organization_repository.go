@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/lute/api/database"
+	"github.com/lute/api/models"
+)
+
+// OrganizationRepository handles the organizations collection.
+type OrganizationRepository struct {
+	*Repository
+}
+
+// NewOrganizationRepository creates a new OrganizationRepository.
+func NewOrganizationRepository(db *mongo.Database) *OrganizationRepository {
+	return &OrganizationRepository{
+		Repository: NewRepository(db, database.CollectionOrganizations),
+	}
+}
+
+func (r *OrganizationRepository) Create(ctx context.Context, org *models.Organization) error {
+	org.BeforeCreate()
+	_, err := r.Collection.InsertOne(ctx, org)
+	return err
+}
+
+func (r *OrganizationRepository) GetByID(ctx context.Context, id primitive.ObjectID) (*models.Organization, error) {
+	var org models.Organization
+	err := r.Collection.FindOne(ctx, bson.M{"_id": id}).Decode(&org)
+	if err != nil {
+		return nil, err
+	}
+	return &org, nil
+}
+
+// GetByIDs returns the organizations with the given IDs sorted by name.
+func (r *OrganizationRepository) GetByIDs(ctx context.Context, ids []primitive.ObjectID) ([]*models.Organization, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "name", Value: 1}})
+	cursor, err := r.Collection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var orgs []*models.Organization
+	if err := cursor.All(ctx, &orgs); err != nil {
+		return nil, err
+	}
+	return orgs, nil
+}
+
+func (r *OrganizationRepository) UpdateName(ctx context.Context, id primitive.ObjectID, name string) error {
+	_, err := r.Collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{
+		"$set": bson.M{"name": name, "updated_at": time.Now()},
+	})
+	return err
+}
+
+func (r *OrganizationRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	_, err := r.Collection.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
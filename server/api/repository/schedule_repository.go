@@ -0,0 +1,130 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/lute/api/database"
+	"github.com/lute/api/models"
+)
+
+// ScheduleRepository handles the schedules collection.
+type ScheduleRepository struct {
+	*Repository
+}
+
+// NewScheduleRepository creates a new ScheduleRepository.
+func NewScheduleRepository(db *mongo.Database) *ScheduleRepository {
+	return &ScheduleRepository{
+		Repository: NewRepository(db, database.CollectionSchedules),
+	}
+}
+
+func (r *ScheduleRepository) Create(ctx context.Context, schedule *models.Schedule) error {
+	schedule.BeforeCreate()
+	_, err := r.Collection.InsertOne(ctx, schedule)
+	return err
+}
+
+func (r *ScheduleRepository) GetByID(ctx context.Context, id primitive.ObjectID) (*models.Schedule, error) {
+	var schedule models.Schedule
+	if err := r.Collection.FindOne(ctx, bson.M{"_id": id}).Decode(&schedule); err != nil {
+		return nil, err
+	}
+	return &schedule, nil
+}
+
+// GetVisible returns the user's personal schedules plus those of the given
+// orgs, newest first.
+func (r *ScheduleRepository) GetVisible(ctx context.Context, userID primitive.ObjectID, orgIDs []primitive.ObjectID) ([]*models.Schedule, error) {
+	or := []bson.M{{"user_id": userID, "org_id": bson.M{"$exists": false}}}
+	if len(orgIDs) > 0 {
+		or = append(or, bson.M{"org_id": bson.M{"$in": orgIDs}})
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	return r.find(ctx, bson.M{"$or": or}, opts)
+}
+
+// GetDue returns up to limit enabled schedules whose next run is at or
+// before now, the most overdue first.
+func (r *ScheduleRepository) GetDue(ctx context.Context, now time.Time, limit int64) ([]*models.Schedule, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "next_run_at", Value: 1}}).
+		SetLimit(limit)
+	return r.find(ctx, bson.M{"enabled": true, "next_run_at": bson.M{"$lte": now}}, opts)
+}
+
+func (r *ScheduleRepository) find(ctx context.Context, filter bson.M, opts *options.FindOptions) ([]*models.Schedule, error) {
+	cursor, err := r.Collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var schedules []*models.Schedule
+	if err := cursor.All(ctx, &schedules); err != nil {
+		return nil, err
+	}
+	return schedules, nil
+}
+
+// Update replaces the editable fields of a schedule, including when it
+// runs next.
+func (r *ScheduleRepository) Update(ctx context.Context, schedule *models.Schedule) error {
+	update := bson.M{"$set": bson.M{
+		"name":                schedule.Name,
+		"cron":                schedule.Cron,
+		"timezone":            schedule.Timezone,
+		"enabled":             schedule.Enabled,
+		"missed_runs":         schedule.MissedRuns,
+		"params":              schedule.Params,
+		"args":                schedule.Args,
+		"timeout_seconds":     schedule.TimeoutSeconds,
+		"concurrency":         schedule.Concurrency,
+		"batch_size":          schedule.BatchSize,
+		"stop_after_failures": schedule.StopAfterFailures,
+		"offline":             schedule.Offline,
+		"updated_at":          time.Now(),
+	}}
+	setNextRun(update, schedule.NextRunAt)
+	_, err := r.Collection.UpdateOne(ctx, bson.M{"_id": schedule.ID}, update)
+	return err
+}
+
+// Claim moves the next run of an enabled schedule from due to next (nil
+// when it never fires again). It reports false when the schedule no longer
+// runs at due: another runner claimed the run, or the schedule was changed.
+// The runner that claims a run is the one that makes it.
+func (r *ScheduleRepository) Claim(ctx context.Context, id primitive.ObjectID, due time.Time, next *time.Time) (bool, error) {
+	update := bson.M{"$set": bson.M{"last_run_at": due}}
+	setNextRun(update, next)
+	res, err := r.Collection.UpdateOne(ctx, bson.M{"_id": id, "enabled": true, "next_run_at": due}, update)
+	if err != nil {
+		return false, err
+	}
+	return res.MatchedCount > 0, nil
+}
+
+// SetLastRunStatus records the outcome of the schedule's latest run.
+func (r *ScheduleRepository) SetLastRunStatus(ctx context.Context, id primitive.ObjectID, status string) error {
+	_, err := r.Collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"last_run_status": status}})
+	return err
+}
+
+func (r *ScheduleRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	_, err := r.Collection.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+func setNextRun(update bson.M, next *time.Time) {
+	if next != nil {
+		update["$set"].(bson.M)["next_run_at"] = *next
+	} else {
+		update["$unset"] = bson.M{"next_run_at": ""}
+	}
+}
@@ -0,0 +1,60 @@
+package repository
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/lute/api/database"
+	"github.com/lute/api/models"
+)
+
+// ScheduleRunRepository handles the schedule_runs collection, the history of
+// schedules. Runs expire after 90 days.
+type ScheduleRunRepository struct {
+	*Repository
+}
+
+// NewScheduleRunRepository creates a new ScheduleRunRepository.
+func NewScheduleRunRepository(db *mongo.Database) *ScheduleRunRepository {
+	return &ScheduleRunRepository{
+		Repository: NewRepository(db, database.CollectionScheduleRuns),
+	}
+}
+
+func (r *ScheduleRunRepository) Create(ctx context.Context, run *models.ScheduleRun) error {
+	res, err := r.Collection.InsertOne(ctx, run)
+	if err != nil {
+		return err
+	}
+	if id, ok := res.InsertedID.(primitive.ObjectID); ok {
+		run.ID = id
+	}
+	return nil
+}
+
+// GetBySchedule returns up to limit runs of a schedule, latest first.
+func (r *ScheduleRunRepository) GetBySchedule(ctx context.Context, scheduleID primitive.ObjectID, limit int64) ([]*models.ScheduleRun, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "scheduled_for", Value: -1}}).
+		SetLimit(limit)
+	cursor, err := r.Collection.Find(ctx, bson.M{"schedule_id": scheduleID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var runs []*models.ScheduleRun
+	if err := cursor.All(ctx, &runs); err != nil {
+		return nil, err
+	}
+	return runs, nil
+}
+
+func (r *ScheduleRunRepository) DeleteBySchedule(ctx context.Context, scheduleID primitive.ObjectID) error {
+	_, err := r.Collection.DeleteMany(ctx, bson.M{"schedule_id": scheduleID})
+	return err
+}
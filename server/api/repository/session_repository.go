@@ -0,0 +1,44 @@
+package repository
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/lute/api/database"
+	"github.com/lute/api/models"
+)
+
+// SessionRepository handles the sessions collection (local auth provider).
+type SessionRepository struct {
+	*Repository
+}
+
+// NewSessionRepository creates a new SessionRepository.
+func NewSessionRepository(db *mongo.Database) *SessionRepository {
+	return &SessionRepository{
+		Repository: NewRepository(db, database.CollectionSessions),
+	}
+}
+
+func (r *SessionRepository) Create(ctx context.Context, session *models.Session) error {
+	session.BeforeCreate()
+	_, err := r.Collection.InsertOne(ctx, session)
+	return err
+}
+
+// GetByTokenHash looks up a session by the SHA-256 hex of its token.
+func (r *SessionRepository) GetByTokenHash(ctx context.Context, tokenHash string) (*models.Session, error) {
+	var session models.Session
+	err := r.Collection.FindOne(ctx, bson.M{"token_hash": tokenHash}).Decode(&session)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (r *SessionRepository) DeleteByTokenHash(ctx context.Context, tokenHash string) error {
+	_, err := r.Collection.DeleteOne(ctx, bson.M{"token_hash": tokenHash})
+	return err
+}
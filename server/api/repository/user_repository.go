@@ -44,6 +44,16 @@ func (r *UserRepository) GetByFirebaseUID(ctx context.Context, firebaseUID strin
 	return &user, nil
 }
 
+// GetByOIDCSubject looks up a user by the "sub" claim of their OIDC tokens.
+func (r *UserRepository) GetByOIDCSubject(ctx context.Context, subject string) (*models.User, error) {
+	var user models.User
+	err := r.Collection.FindOne(ctx, bson.M{"oidc_subject": subject}).Decode(&user)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
 func (r *UserRepository) GetByEmail(ctx context.Context, email string) (*models.User, error) {
 	var user models.User
 	err := r.Collection.FindOne(ctx, bson.M{"email": email}).Decode(&user)
@@ -0,0 +1,136 @@
+package repository
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/lute/api/database"
+	"github.com/lute/api/models"
+)
+
+// VulnerabilityFindingRepository handles the vulnerability_findings collection.
+type VulnerabilityFindingRepository struct {
+	*Repository
+}
+
+// NewVulnerabilityFindingRepository creates a new VulnerabilityFindingRepository.
+func NewVulnerabilityFindingRepository(db *mongo.Database) *VulnerabilityFindingRepository {
+	return &VulnerabilityFindingRepository{
+		Repository: NewRepository(db, database.CollectionVulnFindings),
+	}
+}
+
+// ReplaceForMachine replaces the findings of a machine with findings.
+func (r *VulnerabilityFindingRepository) ReplaceForMachine(ctx context.Context, machineID primitive.ObjectID, findings []*models.VulnerabilityFinding) error {
+	if _, err := r.Collection.DeleteMany(ctx, bson.M{"machine_id": machineID}); err != nil {
+		return err
+	}
+	if len(findings) == 0 {
+		return nil
+	}
+	docs := make([]interface{}, len(findings))
+	for i, f := range findings {
+		docs[i] = f
+	}
+	_, err := r.Collection.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	return err
+}
+
+// findingFilter selects the findings of machineIDs, optionally only those
+// with one of severities.
+func findingFilter(machineIDs []primitive.ObjectID, severities []string) bson.M {
+	filter := bson.M{"machine_id": bson.M{"$in": machineIDs}}
+	if len(severities) > 0 {
+		filter["severity"] = bson.M{"$in": severities}
+	}
+	return filter
+}
+
+// GetByMachineIDs returns the findings of machineIDs, optionally only those
+// with one of severities.
+func (r *VulnerabilityFindingRepository) GetByMachineIDs(ctx context.Context, machineIDs []primitive.ObjectID, severities []string) ([]*models.VulnerabilityFinding, error) {
+	if len(machineIDs) == 0 {
+		return nil, nil
+	}
+	cursor, err := r.Collection.Find(ctx, findingFilter(machineIDs, severities))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var out []*models.VulnerabilityFinding
+	if err := cursor.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GetByCVE returns the findings of machineIDs for a CVE (or the ID of an
+// advisory that names none).
+func (r *VulnerabilityFindingRepository) GetByCVE(ctx context.Context, machineIDs []primitive.ObjectID, cve string) ([]*models.VulnerabilityFinding, error) {
+	if len(machineIDs) == 0 {
+		return nil, nil
+	}
+	filter := findingFilter(machineIDs, nil)
+	filter["cves"] = cve
+	cursor, err := r.Collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var out []*models.VulnerabilityFinding
+	if err := cursor.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// CVEFindings is what the findings of several machines say about one CVE.
+type CVEFindings struct {
+	CVE        string               `bson:"_id"`
+	Severities []string             `bson:"severities"` // of the advisories that name it
+	Score      float64              `bson:"score"`
+	Summary    string               `bson:"summary"`
+	Advisories []string             `bson:"advisories"`
+	Packages   []string             `bson:"packages"`
+	MachineIDs []primitive.ObjectID `bson:"machine_ids"`
+	Fixable    bool                 `bson:"fixable"` // a fixed version is known for some finding
+}
+
+// SummarizeByCVE groups the findings of machineIDs by CVE, optionally only
+// those with one of severities.
+func (r *VulnerabilityFindingRepository) SummarizeByCVE(ctx context.Context, machineIDs []primitive.ObjectID, severities []string) ([]*CVEFindings, error) {
+	if len(machineIDs) == 0 {
+		return nil, nil
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: findingFilter(machineIDs, severities)}},
+		{{Key: "$unwind", Value: "$cves"}},
+		{{Key: "$group", Value: bson.M{
+			"_id":         "$cves",
+			"severities":  bson.M{"$addToSet": "$severity"},
+			"score":       bson.M{"$max": "$score"},
+			"summary":     bson.M{"$first": "$summary"},
+			"advisories":  bson.M{"$addToSet": "$advisory_id"},
+			"packages":    bson.M{"$addToSet": "$package.name"},
+			"machine_ids": bson.M{"$addToSet": "$machine_id"},
+			"fixable":     bson.M{"$max": bson.M{"$gt": bson.A{"$fixed_version", nil}}},
+		}}},
+	}
+	cursor, err := r.Collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var out []*CVEFindings
+	if err := cursor.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,20 @@
+package router
+
+import (
+	"github.com/lute/api/handlers"
+	"github.com/lute/api/middleware"
+	"github.com/lute/api/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SetupActionRoutes sets up the typed machine action routes. All require
+// authentication.
+func SetupActionRoutes(r *gin.RouterGroup, actionHandler *handlers.ActionHandler, userRepo *repository.UserRepository) {
+	machines := r.Group("/machines")
+	machines.Use(middleware.AuthMiddleware(userRepo))
+	{
+		machines.GET("/:id/actions", actionHandler.ListActions)
+		machines.POST("/:id/actions/:action", actionHandler.RequestAction)
+	}
+}
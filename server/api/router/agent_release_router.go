@@ -0,0 +1,20 @@
+package router
+
+import (
+	"github.com/lute/api/handlers"
+	"github.com/lute/api/middleware"
+	"github.com/lute/api/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SetupAgentReleaseRoutes sets up the routes that publish agent builds.
+func SetupAgentReleaseRoutes(r *gin.RouterGroup, releaseHandler *handlers.AgentReleaseHandler, userRepo *repository.UserRepository) {
+	agent := r.Group("/agent")
+	agent.Use(middleware.AuthMiddleware(userRepo))
+	{
+		agent.POST("/releases", releaseHandler.UploadBinary)
+		agent.POST("/releases/prune", releaseHandler.Prune)
+		agent.PUT("/channels/:channel", releaseHandler.SetChannel)
+	}
+}
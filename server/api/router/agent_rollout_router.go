@@ -0,0 +1,21 @@
+package router
+
+import (
+	"github.com/lute/api/handlers"
+	"github.com/lute/api/middleware"
+	"github.com/lute/api/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SetupAgentRolloutRoutes sets up the routes that control agent versions.
+func SetupAgentRolloutRoutes(r *gin.RouterGroup, rolloutHandler *handlers.AgentRolloutHandler, userRepo *repository.UserRepository) {
+	rollouts := r.Group("/agent-rollouts")
+	rollouts.Use(middleware.AuthMiddleware(userRepo))
+	{
+		rollouts.POST("", rolloutHandler.CreateRollout)
+		rollouts.GET("", rolloutHandler.ListRollouts)
+		rollouts.PATCH("/:id", rolloutHandler.UpdateRollout)
+		rollouts.DELETE("/:id", rolloutHandler.DeleteRollout)
+	}
+}
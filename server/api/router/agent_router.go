@@ -14,6 +14,7 @@ func SetupAgentRoutes(r *gin.RouterGroup, agentHandler *handlers.AgentHandler, u
 	{
 		// Public endpoints — agents on VMs need these without auth
 		agent.GET("/download/:os/:arch", agentHandler.DownloadBinary)
+		agent.GET("/download/:os/:arch/signature", agentHandler.DownloadSignature)
 		agent.GET("/download", agentHandler.DownloadAutoDetect)
 		agent.GET("/version", agentHandler.GetVersion)
 		agent.GET("/install.sh", agentHandler.InstallScript)
@@ -0,0 +1,28 @@
+package router
+
+import (
+	"github.com/lute/api/handlers"
+	"github.com/lute/api/middleware"
+	"github.com/lute/api/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SetupAlertRoutes sets up the alert rule and alert routes. All require
+// authentication.
+func SetupAlertRoutes(r *gin.RouterGroup, alertHandler *handlers.AlertHandler, userRepo *repository.UserRepository) {
+	rules := r.Group("/alert-rules")
+	rules.Use(middleware.AuthMiddleware(userRepo))
+	{
+		rules.GET("", alertHandler.ListRules)
+		rules.POST("", alertHandler.CreateRule)
+		rules.PUT("/:id", alertHandler.UpdateRule)
+		rules.DELETE("/:id", alertHandler.DeleteRule)
+	}
+
+	alerts := r.Group("/alerts")
+	alerts.Use(middleware.AuthMiddleware(userRepo))
+	{
+		alerts.GET("", alertHandler.ListAlerts)
+	}
+}
@@ -0,0 +1,21 @@
+package router
+
+import (
+	"github.com/lute/api/handlers"
+	"github.com/lute/api/middleware"
+	"github.com/lute/api/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SetupAPITokenRoutes sets up API token management routes. These require an
+// interactive session; a token cannot be used to manage tokens.
+func SetupAPITokenRoutes(r *gin.RouterGroup, tokenHandler *handlers.APITokenHandler, userRepo *repository.UserRepository) {
+	tokens := r.Group("/tokens")
+	tokens.Use(middleware.AuthMiddleware(userRepo))
+	{
+		tokens.POST("", tokenHandler.CreateToken)
+		tokens.GET("", tokenHandler.ListTokens)
+		tokens.DELETE("/:id", tokenHandler.RevokeToken)
+	}
+}
@@ -0,0 +1,19 @@
+package router
+
+import (
+	"github.com/lute/api/handlers"
+	"github.com/lute/api/middleware"
+	"github.com/lute/api/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SetupAuditRoutes sets up the read-only audit log routes.
+func SetupAuditRoutes(r *gin.RouterGroup, auditHandler *handlers.AuditHandler, userRepo *repository.UserRepository) {
+	auditGroup := r.Group("/audit")
+	auditGroup.Use(middleware.AuthMiddleware(userRepo))
+	{
+		auditGroup.GET("", auditHandler.ListEvents)
+		auditGroup.GET("/export", auditHandler.ExportEvents)
+	}
+}
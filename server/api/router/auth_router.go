@@ -0,0 +1,29 @@
+package router
+
+import (
+	"github.com/lute/api/handlers"
+	"github.com/lute/api/middleware"
+	"github.com/lute/api/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SetupAuthRoutes sets up sign-in related routes. Register and login only
+// respond when the local auth provider is configured.
+func SetupAuthRoutes(r *gin.RouterGroup, authHandler *handlers.AuthHandler, userRepo *repository.UserRepository) {
+	authGroup := r.Group("/auth")
+	{
+		// Public endpoints
+		authGroup.GET("/config", authHandler.GetConfig)
+		authGroup.POST("/register", authHandler.Register)
+		authGroup.POST("/login", authHandler.Login)
+
+		// Protected endpoints
+		protected := authGroup.Group("")
+		protected.Use(middleware.AuthMiddleware(userRepo))
+		{
+			protected.POST("/logout", authHandler.Logout)
+			protected.GET("/me", authHandler.Me)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package router
+
+import (
+	"github.com/lute/api/handlers"
+	"github.com/lute/api/middleware"
+	"github.com/lute/api/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SetupConfigRoutes sets up the agent config routes of machines and
+// groups. All require authentication.
+func SetupConfigRoutes(r *gin.RouterGroup, configHandler *handlers.ConfigHandler, userRepo *repository.UserRepository) {
+	machines := r.Group("/machines")
+	machines.Use(middleware.AuthMiddleware(userRepo))
+	{
+		machines.GET("/:id/config", configHandler.GetMachineConfig)
+		machines.PUT("/:id/config", configHandler.SetMachineConfig)
+		machines.DELETE("/:id/config", configHandler.DeleteMachineConfig)
+	}
+
+	groups := r.Group("/groups")
+	groups.Use(middleware.AuthMiddleware(userRepo))
+	{
+		groups.GET("/:id/config", configHandler.GetGroupConfig)
+		groups.PUT("/:id/config", configHandler.SetGroupConfig)
+		groups.DELETE("/:id/config", configHandler.DeleteGroupConfig)
+	}
+}
@@ -0,0 +1,20 @@
+package router
+
+import (
+	"github.com/lute/api/handlers"
+	"github.com/lute/api/middleware"
+	"github.com/lute/api/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SetupEnrollmentTokenRoutes sets up enrollment token management routes.
+func SetupEnrollmentTokenRoutes(r *gin.RouterGroup, enrollmentHandler *handlers.EnrollmentTokenHandler, userRepo *repository.UserRepository) {
+	tokens := r.Group("/enrollment-tokens")
+	tokens.Use(middleware.AuthMiddleware(userRepo))
+	{
+		tokens.POST("", enrollmentHandler.CreateToken)
+		tokens.GET("", enrollmentHandler.ListTokens)
+		tokens.DELETE("/:id", enrollmentHandler.RevokeToken)
+	}
+}
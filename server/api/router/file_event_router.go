@@ -0,0 +1,27 @@
+package router
+
+import (
+	"github.com/lute/api/handlers"
+	"github.com/lute/api/middleware"
+	"github.com/lute/api/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SetupFileEventRoutes sets up the per-machine and fleet-wide file integrity
+// event routes. All require authentication.
+func SetupFileEventRoutes(r *gin.RouterGroup, fileEventHandler *handlers.FileEventHandler, userRepo *repository.UserRepository) {
+	machines := r.Group("/machines")
+	machines.Use(middleware.AuthMiddleware(userRepo))
+	{
+		machines.GET("/:id/file-events", fileEventHandler.ListMachineFileEvents)
+		machines.POST("/:id/file-events/ack", fileEventHandler.AcknowledgeMachineFileEvents)
+	}
+
+	events := r.Group("/file-events")
+	events.Use(middleware.AuthMiddleware(userRepo))
+	{
+		events.GET("", fileEventHandler.ListFileEvents)
+		events.POST("/:id/ack", fileEventHandler.AcknowledgeFileEvent)
+	}
+}
@@ -0,0 +1,23 @@
+package router
+
+import (
+	"github.com/lute/api/handlers"
+	"github.com/lute/api/middleware"
+	"github.com/lute/api/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SetupFileTransferRoutes sets up the routes copying files to and from
+// machines. All require authentication.
+func SetupFileTransferRoutes(r *gin.RouterGroup, fileTransferHandler *handlers.FileTransferHandler, userRepo *repository.UserRepository) {
+	machines := r.Group("/machines")
+	machines.Use(middleware.AuthMiddleware(userRepo))
+	{
+		machines.POST("/:id/files", fileTransferHandler.UploadFile)
+		machines.GET("/:id/files", fileTransferHandler.DownloadFile)
+		machines.GET("/:id/file-transfers", fileTransferHandler.ListFileTransfers)
+		machines.GET("/:id/file-transfers/:transferId", fileTransferHandler.GetFileTransfer)
+		machines.GET("/:id/file-transfers/:transferId/content", fileTransferHandler.GetFileTransferContent)
+	}
+}
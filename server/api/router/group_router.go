@@ -0,0 +1,24 @@
+package router
+
+import (
+	"github.com/lute/api/handlers"
+	"github.com/lute/api/middleware"
+	"github.com/lute/api/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SetupGroupRoutes sets up machine group routes. All require authentication.
+func SetupGroupRoutes(r *gin.RouterGroup, groupHandler *handlers.GroupHandler, userRepo *repository.UserRepository) {
+	groups := r.Group("/groups")
+	groups.Use(middleware.AuthMiddleware(userRepo))
+	{
+		groups.POST("", groupHandler.CreateGroup)
+		groups.GET("", groupHandler.ListGroups)
+		groups.GET("/:id", groupHandler.GetGroup)
+		groups.PUT("/:id", groupHandler.UpdateGroup)
+		groups.DELETE("/:id", groupHandler.DeleteGroup)
+		groups.POST("/:id/machines", groupHandler.AddMembers)
+		groups.DELETE("/:id/machines", groupHandler.RemoveMembers)
+	}
+}
@@ -0,0 +1,26 @@
+package router
+
+import (
+	"github.com/lute/api/handlers"
+	"github.com/lute/api/middleware"
+	"github.com/lute/api/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SetupInventoryRoutes sets up the per-machine inventory and fleet-wide
+// package query routes. All require authentication.
+func SetupInventoryRoutes(r *gin.RouterGroup, inventoryHandler *handlers.InventoryHandler, userRepo *repository.UserRepository) {
+	machines := r.Group("/machines")
+	machines.Use(middleware.AuthMiddleware(userRepo))
+	{
+		machines.GET("/:id/inventory", inventoryHandler.GetInventory)
+		machines.GET("/:id/inventory/history", inventoryHandler.GetInventoryHistory)
+	}
+
+	inventory := r.Group("/inventory")
+	inventory.Use(middleware.AuthMiddleware(userRepo))
+	{
+		inventory.GET("/packages", inventoryHandler.FindPackages)
+	}
+}
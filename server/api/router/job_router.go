@@ -0,0 +1,22 @@
+package router
+
+import (
+	"github.com/lute/api/handlers"
+	"github.com/lute/api/middleware"
+	"github.com/lute/api/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SetupJobRoutes sets up the routes for jobs running an action across
+// machines. All require authentication.
+func SetupJobRoutes(r *gin.RouterGroup, jobHandler *handlers.JobHandler, userRepo *repository.UserRepository) {
+	jobs := r.Group("/jobs")
+	jobs.Use(middleware.AuthMiddleware(userRepo))
+	{
+		jobs.POST("", jobHandler.CreateJob)
+		jobs.GET("", jobHandler.ListJobs)
+		jobs.GET("/:id", jobHandler.GetJob)
+		jobs.POST("/:id/cancel", jobHandler.CancelJob)
+	}
+}
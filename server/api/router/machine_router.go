@@ -22,7 +22,11 @@ func SetupMachineRoutes(r *gin.RouterGroup, machineHandler *handlers.MachineHand
 			machines.GET("", machineHandler.ListUserMachines)
 			machines.GET("/:id", machineHandler.GetMachine)
 			machines.PUT("/:id", machineHandler.UpdateMachine)
+			machines.PUT("/:id/labels", machineHandler.SetMachineLabels)
+			machines.PUT("/:id/org", machineHandler.TransferMachine)
 			machines.POST("/:id/re-enable", machineHandler.ReEnableMachine)
+			machines.POST("/:id/agent-token/rotate", machineHandler.RotateAgentToken)
+			machines.POST("/:id/agent-token/revoke", machineHandler.RevokeAgent)
 			machines.DELETE("/:id", machineHandler.DeleteMachine)
 		}
 	}
@@ -0,0 +1,36 @@
+package router
+
+import (
+	"github.com/lute/api/handlers"
+	"github.com/lute/api/middleware"
+	"github.com/lute/api/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SetupOrgRoutes sets up organization, membership and invite routes. All require authentication.
+func SetupOrgRoutes(r *gin.RouterGroup, orgHandler *handlers.OrgHandler, userRepo *repository.UserRepository) {
+	orgs := r.Group("/orgs")
+	orgs.Use(middleware.AuthMiddleware(userRepo))
+	{
+		orgs.POST("", orgHandler.CreateOrg)
+		orgs.GET("", orgHandler.ListOrgs)
+		orgs.GET("/:id", orgHandler.GetOrg)
+		orgs.PUT("/:id", orgHandler.UpdateOrg)
+		orgs.DELETE("/:id", orgHandler.DeleteOrg)
+
+		orgs.GET("/:id/members", orgHandler.ListMembers)
+		orgs.PUT("/:id/members/:userId", orgHandler.UpdateMember)
+		orgs.DELETE("/:id/members/:userId", orgHandler.RemoveMember)
+
+		orgs.POST("/:id/invites", orgHandler.CreateInvite)
+		orgs.GET("/:id/invites", orgHandler.ListInvites)
+		orgs.DELETE("/:id/invites/:inviteId", orgHandler.RevokeInvite)
+	}
+
+	invites := r.Group("/invites")
+	invites.Use(middleware.AuthMiddleware(userRepo))
+	{
+		invites.POST("/accept", orgHandler.AcceptInvite)
+	}
+}
@@ -1,10 +1,16 @@
 package router
 
 import (
+	"github.com/lute/api/agentbin"
+	"github.com/lute/api/audit"
+	"github.com/lute/api/auth"
+	"github.com/lute/api/authz"
 	"github.com/lute/api/config"
 	"github.com/lute/api/database"
+	luteGrpc "github.com/lute/api/grpc"
 	"github.com/lute/api/handlers"
 	"github.com/lute/api/middleware"
+	"github.com/lute/api/pki"
 	"github.com/lute/api/repository"
 	"github.com/lute/api/services"
 	"github.com/lute/api/websocket"
@@ -20,6 +26,39 @@ func SetupRouter(
 	commandRepo *repository.CommandRepository,
 	uptimeSnapshotRepo *repository.UptimeSnapshotRepository,
 	machineSnapshotRepo *repository.MachineSnapshotRepository,
+	machineGroupRepo *repository.MachineGroupRepository,
+	organizationRepo *repository.OrganizationRepository,
+	orgMemberRepo *repository.OrgMemberRepository,
+	orgInviteRepo *repository.OrgInviteRepository,
+	apiTokenRepo *repository.APITokenRepository,
+	auditRepo *repository.AuditRepository,
+	enrollmentTokenRepo *repository.EnrollmentTokenRepository,
+	agentRolloutRepo *repository.AgentRolloutRepository,
+	inventoryRepo *repository.MachineInventoryRepository,
+	inventoryChangeRepo *repository.InventoryChangeRepository,
+	advisoryRepo *repository.AdvisoryRepository,
+	vulnFindingRepo *repository.VulnerabilityFindingRepository,
+	fileEventRepo *repository.FileEventRepository,
+	unitsRepo *repository.MachineUnitsRepository,
+	alertRuleRepo *repository.AlertRuleRepository,
+	alertRepo *repository.AlertRepository,
+	fileTransferRepo *repository.FileTransferRepository,
+	fileChunkRepo *repository.FileChunkRepository,
+	jobRepo *repository.JobRepository,
+	scheduleRepo *repository.ScheduleRepository,
+	scheduleRunRepo *repository.ScheduleRunRepository,
+	machineConfigRepo *repository.MachineConfigRepository,
+	authenticator auth.Authenticator,
+	certAuthority *pki.Authority,
+	connMgr *luteGrpc.ConnectionManager,
+	binaries *agentbin.Index,
+	agentUpdater *services.AgentUpdater,
+	alertEvaluator *services.AlertEvaluator,
+	actionDispatcher *services.ActionDispatcher,
+	fileTransferDispatcher *services.FileTransferDispatcher,
+	jobRunner *services.JobRunner,
+	scheduleRunner *services.ScheduleRunner,
+	configPusher *services.ConfigPusher,
 	hub *websocket.Hub,
 ) *gin.Engine {
 	// Set Gin mode
@@ -28,6 +67,7 @@ func SetupRouter(
 	r := gin.New()
 
 	// Global middleware
+	r.Use(middleware.RequestID())
 	r.Use(middleware.Logger())
 	r.Use(middleware.Recovery())
 	r.Use(middleware.CORS())
@@ -44,25 +84,121 @@ func SetupRouter(
 	wsHandler := handlers.NewWebSocketHandler(hub, cfg)
 	api.GET("/ws", middleware.OptionalAuthMiddleware(), wsHandler.HandleWebSocket)
 
+	// Authorization is decided in one place and shared by every service/handler
+	authorizer := authz.New(orgMemberRepo)
+
+	// Audit log of who changed what; services record events as they act
+	auditRecorder := audit.New(auditRepo, authorizer)
+
 	// Initialize services
-	machineService := services.NewMachineService(machineRepo)
+	machineService := services.NewMachineService(machineRepo, authorizer, auditRecorder, certAuthority, connMgr)
+	groupService := services.NewGroupService(machineGroupRepo, machineRepo, authorizer, configPusher)
+	orgService := services.NewOrgService(cfg, organizationRepo, orgMemberRepo, orgInviteRepo, machineRepo, userRepo, authorizer, services.NewMailer(cfg.Mail), auditRecorder)
+	apiTokenService := services.NewAPITokenService(apiTokenRepo, authorizer, auditRecorder)
+	enrollmentService := services.NewEnrollmentTokenService(enrollmentTokenRepo, machineGroupRepo, authorizer, auditRecorder)
+	releaseService := services.NewAgentReleaseService(binaries, userRepo, agentRolloutRepo, cfg.AgentBinary.ReleaseAdmins, cfg.AgentBinary.KeepVersions, auditRecorder)
+	inventoryService := services.NewInventoryService(inventoryRepo, inventoryChangeRepo, machineService)
+	vulnerabilityService := services.NewVulnerabilityService(vulnFindingRepo, advisoryRepo, machineService)
+	fileEventService := services.NewFileEventService(fileEventRepo, machineService, auditRecorder)
+	unitService := services.NewUnitService(unitsRepo, machineService)
+	actionService := services.NewActionService(commandRepo, machineService, actionDispatcher, auditRecorder)
+	jobService := services.NewJobService(jobRepo, commandRepo, machineGroupRepo, machineService, jobRunner, authorizer, auditRecorder)
+	scheduleService := services.NewScheduleService(scheduleRepo, scheduleRunRepo, machineService, actionService, jobService, apiTokenService, authorizer, auditRecorder)
+	scheduleRunner.Fire = scheduleService.Fire
+	fileTransferService := services.NewFileTransferService(fileTransferRepo, fileChunkRepo, machineService, fileTransferDispatcher, auditRecorder, cfg.FileTransfer.MaxSize)
+	alertService := services.NewAlertService(alertRuleRepo, alertRepo, machineService, alertEvaluator, authorizer, auditRecorder)
+	configService := services.NewConfigService(machineConfigRepo, machineService, groupService, configPusher, auditRecorder)
+	rolloutService := services.NewAgentRolloutService(agentRolloutRepo, machineRepo, machineGroupRepo, binaries, agentUpdater, authorizer, auditRecorder)
+
+	// Accept API tokens alongside Firebase ID tokens in AuthMiddleware
+	middleware.InitAPITokens(apiTokenService)
 
 	// Initialize handlers
 	machineHandler := handlers.NewMachineHandler(machineService)
-	agentHandler := handlers.NewAgentHandler(cfg.AgentBinary.Dir, cfg, machineRepo, commandRepo)
+	agentHandler := handlers.NewAgentHandler(binaries, cfg, machineRepo, commandRepo, authorizer, auditRecorder, certAuthority, enrollmentService)
 	dashboardHandler := handlers.NewDashboardHandler(cfg, machineService, machineSnapshotRepo)
+	groupHandler := handlers.NewGroupHandler(groupService)
+	orgHandler := handlers.NewOrgHandler(orgService)
+	apiTokenHandler := handlers.NewAPITokenHandler(apiTokenService)
+	enrollmentHandler := handlers.NewEnrollmentTokenHandler(enrollmentService)
+	rolloutHandler := handlers.NewAgentRolloutHandler(rolloutService)
+	releaseHandler := handlers.NewAgentReleaseHandler(releaseService)
+	authHandler := handlers.NewAuthHandler(cfg, authenticator, userRepo, auditRecorder)
+	auditHandler := handlers.NewAuditHandler(auditRecorder)
+	inventoryHandler := handlers.NewInventoryHandler(inventoryService)
+	vulnerabilityHandler := handlers.NewVulnerabilityHandler(vulnerabilityService)
+	fileEventHandler := handlers.NewFileEventHandler(fileEventService)
+	unitHandler := handlers.NewUnitHandler(unitService)
+	alertHandler := handlers.NewAlertHandler(alertService)
+	actionHandler := handlers.NewActionHandler(actionService)
+	fileTransferHandler := handlers.NewFileTransferHandler(fileTransferService)
+	jobHandler := handlers.NewJobHandler(jobService)
+	scheduleHandler := handlers.NewScheduleHandler(scheduleService)
+	configHandler := handlers.NewConfigHandler(configService)
 
 	// Protected API routes
 	v1 := api.Group("/v1")
 	{
+		// Sign-in routes (provider config, local login)
+		SetupAuthRoutes(v1, authHandler, userRepo)
+
 		// Machine routes (with dedicated router)
 		SetupMachineRoutes(v1, machineHandler, userRepo)
 
+		// Machine inventories and fleet-wide package queries
+		SetupInventoryRoutes(v1, inventoryHandler, userRepo)
+
+		// Vulnerabilities found by matching inventories against the advisory feed
+		SetupVulnerabilityRoutes(v1, vulnerabilityHandler, userRepo)
+		// File integrity events reported by agents, and their acknowledgement
+		SetupFileEventRoutes(v1, fileEventHandler, userRepo)
+		// systemd unit states reported by agents, and fleet-wide failed units
+		SetupUnitRoutes(v1, unitHandler, userRepo)
+
+		// Service control, reboots and scripts requested as typed actions
+		SetupActionRoutes(v1, actionHandler, userRepo)
+
+		// Jobs running an action on the machines matching a selector or group
+		SetupJobRoutes(v1, jobHandler, userRepo)
+
+		// Schedules running an action, or a job, on a cron expression
+		SetupScheduleRoutes(v1, scheduleHandler, userRepo)
+
+		// Files copied to and from machines over the agent stream
+		SetupFileTransferRoutes(v1, fileTransferHandler, userRepo)
+
+		// Alert rules and the alerts they raise
+		SetupAlertRoutes(v1, alertHandler, userRepo)
+
+		// Machine group routes
+		SetupGroupRoutes(v1, groupHandler, userRepo)
+
+		// Organization, membership and invite routes
+		SetupOrgRoutes(v1, orgHandler, userRepo)
+
+		// API token (personal access token) routes
+		SetupAPITokenRoutes(v1, apiTokenHandler, userRepo)
+
+		// Enrollment tokens for automated agent registration
+		SetupEnrollmentTokenRoutes(v1, enrollmentHandler, userRepo)
+
+		// Agent configuration set centrally per machine and per group
+		SetupConfigRoutes(v1, configHandler, userRepo)
+
+		// Agent version rollouts
+		SetupAgentRolloutRoutes(v1, rolloutHandler, userRepo)
+
+		// Audit log routes
+		SetupAuditRoutes(v1, auditHandler, userRepo)
+
 		// Dashboard routes (stats, uptime)
 		SetupDashboardRoutes(v1, dashboardHandler, userRepo)
 
 		// Agent binary distribution routes
 		SetupAgentRoutes(v1, agentHandler, userRepo)
+
+		// Agent build uploads and release channels (release admins)
+		SetupAgentReleaseRoutes(v1, releaseHandler, userRepo)
 	}
 
 	return r
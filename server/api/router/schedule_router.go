@@ -0,0 +1,25 @@
+package router
+
+import (
+	"github.com/lute/api/handlers"
+	"github.com/lute/api/middleware"
+	"github.com/lute/api/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SetupScheduleRoutes sets up the routes for schedules running an action on
+// a cron expression. All require authentication.
+func SetupScheduleRoutes(r *gin.RouterGroup, scheduleHandler *handlers.ScheduleHandler, userRepo *repository.UserRepository) {
+	schedules := r.Group("/schedules")
+	schedules.Use(middleware.AuthMiddleware(userRepo))
+	{
+		schedules.POST("", scheduleHandler.CreateSchedule)
+		schedules.GET("", scheduleHandler.ListSchedules)
+		schedules.POST("/preview", scheduleHandler.PreviewSchedule)
+		schedules.GET("/:id", scheduleHandler.GetSchedule)
+		schedules.PUT("/:id", scheduleHandler.UpdateSchedule)
+		schedules.DELETE("/:id", scheduleHandler.DeleteSchedule)
+		schedules.GET("/:id/runs", scheduleHandler.ListScheduleRuns)
+	}
+}
@@ -0,0 +1,25 @@
+package router
+
+import (
+	"github.com/lute/api/handlers"
+	"github.com/lute/api/middleware"
+	"github.com/lute/api/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SetupUnitRoutes sets up the per-machine and fleet-wide systemd unit
+// routes. All require authentication.
+func SetupUnitRoutes(r *gin.RouterGroup, unitHandler *handlers.UnitHandler, userRepo *repository.UserRepository) {
+	machines := r.Group("/machines")
+	machines.Use(middleware.AuthMiddleware(userRepo))
+	{
+		machines.GET("/:id/units", unitHandler.GetMachineUnits)
+	}
+
+	units := r.Group("/units")
+	units.Use(middleware.AuthMiddleware(userRepo))
+	{
+		units.GET("/failed", unitHandler.ListFailedUnits)
+	}
+}
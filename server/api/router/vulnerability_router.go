@@ -0,0 +1,21 @@
+package router
+
+import (
+	"github.com/lute/api/handlers"
+	"github.com/lute/api/middleware"
+	"github.com/lute/api/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SetupVulnerabilityRoutes sets up the fleet-wide and per-machine
+// vulnerability routes. All require authentication.
+func SetupVulnerabilityRoutes(r *gin.RouterGroup, vulnerabilityHandler *handlers.VulnerabilityHandler, userRepo *repository.UserRepository) {
+	vulnerabilities := r.Group("/vulnerabilities")
+	vulnerabilities.Use(middleware.AuthMiddleware(userRepo))
+	{
+		vulnerabilities.GET("", vulnerabilityHandler.ListVulnerabilities)
+		vulnerabilities.GET("/feed", vulnerabilityHandler.GetFeedStatus)
+		vulnerabilities.GET("/:id", vulnerabilityHandler.GetVulnerability)
+	}
+}
@@ -4,26 +4,49 @@ import (
 	"context"
 	"log"
 	"net/http"
+	"time"
 
+	pb "github.com/lute/agent/proto/agent"
+
+	"github.com/lute/api/agentbin"
+	"github.com/lute/api/auth"
 	"github.com/lute/api/config"
 	"github.com/lute/api/database"
 	"github.com/lute/api/grpc"
+	"github.com/lute/api/models"
+	"github.com/lute/api/pki"
 	"github.com/lute/api/repository"
 	"github.com/lute/api/router"
 	"github.com/lute/api/services"
 	"github.com/lute/api/websocket"
 )
 
+// vulnScanTimeout bounds the vulnerability scan of a new inventory.
+const vulnScanTimeout = time.Minute
+
+// alertEvalTimeout bounds the alert evaluation of a new unit report.
+const alertEvalTimeout = 30 * time.Second
+
 type Server struct {
 	HTTP               *http.Server
 	GRPC               *grpc.Server
 	Hub                *websocket.Hub
 	HeartbeatChecker   *services.HeartbeatChecker
 	MachineSnapshotJob *services.MachineSnapshotJob
+	SnapshotRollupJob  *services.SnapshotRollupJob
+	VulnFeedJob        *services.VulnerabilityFeedJob
+	JobRunner          *services.JobRunner
+	ScheduleRunner     *services.ScheduleRunner
+	ConfigPusher       *services.ConfigPusher
 	checkerCtx         context.Context
 	checkerStop        context.CancelFunc
 	snapshotJobCtx     context.Context
 	snapshotJobCancel  context.CancelFunc
+	rollupCancel       context.CancelFunc
+	vulnFeedCancel     context.CancelFunc
+	jobRunnerCancel    context.CancelFunc
+	scheduleCancel     context.CancelFunc
+	configCancel       context.CancelFunc
 }
 
 func New(
@@ -34,13 +57,62 @@ func New(
 	commandRepo *repository.CommandRepository,
 	uptimeSnapshotRepo *repository.UptimeSnapshotRepository,
 	machineSnapshotRepo *repository.MachineSnapshotRepository,
+	machineGroupRepo *repository.MachineGroupRepository,
+	organizationRepo *repository.OrganizationRepository,
+	orgMemberRepo *repository.OrgMemberRepository,
+	orgInviteRepo *repository.OrgInviteRepository,
+	apiTokenRepo *repository.APITokenRepository,
+	auditRepo *repository.AuditRepository,
+	enrollmentTokenRepo *repository.EnrollmentTokenRepository,
+	agentRolloutRepo *repository.AgentRolloutRepository,
+	inventoryRepo *repository.MachineInventoryRepository,
+	inventoryChangeRepo *repository.InventoryChangeRepository,
+	advisoryRepo *repository.AdvisoryRepository,
+	vulnFindingRepo *repository.VulnerabilityFindingRepository,
+	fileEventRepo *repository.FileEventRepository,
+	unitsRepo *repository.MachineUnitsRepository,
+	alertRuleRepo *repository.AlertRuleRepository,
+	alertRepo *repository.AlertRepository,
+	fileTransferRepo *repository.FileTransferRepository,
+	fileChunkRepo *repository.FileChunkRepository,
+	jobRepo *repository.JobRepository,
+	scheduleRepo *repository.ScheduleRepository,
+	scheduleRunRepo *repository.ScheduleRunRepository,
+	machineConfigRepo *repository.MachineConfigRepository,
+	authenticator auth.Authenticator,
+	certAuthority *pki.Authority,
+	binaries *agentbin.Index,
 ) *Server {
 	hub := websocket.NewHub()
 	go hub.Run()
 
-	grpcServer := grpc.NewServer(cfg, machineRepo)
+	grpcServer := grpc.NewServer(cfg, machineRepo, certAuthority)
+	// Revoking a machine's certificates also drops its open stream
+	certAuthority.OnRevoke = grpcServer.ConnMgr.Disconnect
+
+	// Agent binaries are served for download and offered to agents by rollouts
+	agentUpdater := services.NewAgentUpdater(machineRepo, agentRolloutRepo, binaries, grpcServer.ConnMgr)
+
+	// Alert rules are evaluated as agents report state and whenever a rule changes
+	alertEvaluator := services.NewAlertEvaluator(alertRuleRepo, alertRepo, machineRepo, unitsRepo, services.NewMailer(cfg.Mail))
+
+	// Typed actions are sent to connected agents at once and to others when they connect
+	actionDispatcher := services.NewActionDispatcher(commandRepo, grpcServer.ConnMgr)
+
+	// Jobs fan actions out to many machines and advance as results come in
+	jobRunner := services.NewJobRunner(jobRepo, commandRepo, machineRepo, grpcServer.ConnMgr, actionDispatcher)
+	actionDispatcher.OnResult = jobRunner.Kick
 
-	r := router.SetupRouter(cfg, db, machineRepo, userRepo, commandRepo, uptimeSnapshotRepo, machineSnapshotRepo, hub)
+	// Schedules make commands and jobs as they fall due; the router supplies how
+	scheduleRunner := services.NewScheduleRunner(scheduleRepo, scheduleRunRepo)
+
+	// Machine and group configs are pushed to agents on connect and on change
+	configPusher := services.NewConfigPusher(machineConfigRepo, machineRepo, grpcServer.ConnMgr)
+
+	// Files are copied to connected agents at once and to others when they connect
+	fileTransferDispatcher := services.NewFileTransferDispatcher(fileTransferRepo, fileChunkRepo, grpcServer.ConnMgr, cfg.FileTransfer.MaxSize)
+
+	r := router.SetupRouter(cfg, db, machineRepo, userRepo, commandRepo, uptimeSnapshotRepo, machineSnapshotRepo, machineGroupRepo, organizationRepo, orgMemberRepo, orgInviteRepo, apiTokenRepo, auditRepo, enrollmentTokenRepo, agentRolloutRepo, inventoryRepo, inventoryChangeRepo, advisoryRepo, vulnFindingRepo, fileEventRepo, unitsRepo, alertRuleRepo, alertRepo, fileTransferRepo, fileChunkRepo, jobRepo, scheduleRepo, scheduleRunRepo, machineConfigRepo, authenticator, certAuthority, grpcServer.ConnMgr, binaries, agentUpdater, alertEvaluator, actionDispatcher, fileTransferDispatcher, jobRunner, scheduleRunner, configPusher, hub)
 
 	httpServer := &http.Server{
 		Addr:         cfg.Server.Host + ":" + cfg.Server.Port,
@@ -57,9 +129,65 @@ func New(
 		cfg.Heartbeat.PingTimeout,
 		cfg.Heartbeat.MaxRetries,
 	)
-	grpcServer.OnConnectionRegistered = func() { heartbeatChecker.TriggerCheck() }
+	grpcServer.OnConnectionRegistered = func(machineID string) {
+		heartbeatChecker.TriggerCheck()
+		go agentUpdater.Offer(context.Background(), machineID)
+		go actionDispatcher.DeliverPending(context.Background(), machineID)
+		go fileTransferDispatcher.DeliverPending(context.Background(), machineID)
+		go configPusher.Push(context.Background(), machineID, true)
+	}
+
+	// Samples agents buffered while offline are stored as backfilled snapshots
+	// and rolled up; inventories are stored with their change history and scanned for
+	// vulnerabilities; file integrity events are stored until acknowledged;
+	// systemd unit states are stored and checked against the alert rules
+	snapshotRollupJob := services.NewSnapshotRollupJob(machineSnapshotRepo, cfg.Metrics.RawRetention, cfg.Metrics.MinuteRetention)
+	telemetryBackfill := services.NewTelemetryBackfill(machineSnapshotRepo, snapshotRollupJob, cfg.Metrics.RawRetention)
+	vulnScanner := services.NewVulnerabilityScanner(advisoryRepo, vulnFindingRepo, inventoryRepo)
+	inventoryRecorder := services.NewInventoryRecorder(inventoryRepo, inventoryChangeRepo)
+	fileIntegrityRecorder := services.NewFileIntegrityRecorder(fileEventRepo)
+	unitRecorder := services.NewUnitRecorder(unitsRepo)
+	inventoryRecorder.OnStored = func(inv *models.MachineInventory) {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), vulnScanTimeout)
+			defer cancel()
+			if err := vulnScanner.ScanInventory(ctx, inv); err != nil {
+				log.Printf("Vulnerabilities: failed to scan machine %s: %v", inv.MachineID.Hex(), err)
+			}
+		}()
+	}
+	unitRecorder.OnStored = func(units *models.MachineUnits) {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), alertEvalTimeout)
+			defer cancel()
+			if err := alertEvaluator.EvaluateUnits(ctx, units); err != nil {
+				log.Printf("Alerts: failed to evaluate machine %s: %v", units.MachineID.Hex(), err)
+			}
+		}()
+	}
+	grpcServer.InventoryHash = inventoryRecorder.StoredHash
+	grpcServer.OnAgentMessage = func(machineID string, msg *pb.AgentMessage) *pb.ServerMessage {
+		switch msg.GetPayload().(type) {
+		case *pb.AgentMessage_Backfill:
+			return telemetryBackfill.HandleMessage(machineID, msg)
+		case *pb.AgentMessage_Inventory:
+			return inventoryRecorder.HandleMessage(machineID, msg)
+		case *pb.AgentMessage_FileEvents:
+			return fileIntegrityRecorder.HandleMessage(machineID, msg)
+		case *pb.AgentMessage_Units:
+			return unitRecorder.HandleMessage(machineID, msg)
+		case *pb.AgentMessage_ActionResult:
+			return actionDispatcher.HandleMessage(machineID, msg)
+		case *pb.AgentMessage_FileChunk, *pb.AgentMessage_FileTransferStatus:
+			return fileTransferDispatcher.HandleMessage(machineID, msg)
+		case *pb.AgentMessage_ConfigApplied:
+			return configPusher.HandleMessage(machineID, msg)
+		}
+		return nil
+	}
 
 	machineSnapshotJob := services.NewMachineSnapshotJob(machineRepo, machineSnapshotRepo, cfg.Metrics.SnapshotInterval)
+	vulnFeedJob := services.NewVulnerabilityFeedJob(cfg.Vulnerabilities.FeedPaths, cfg.Vulnerabilities.ReloadInterval, advisoryRepo, vulnScanner)
 
 	return &Server{
 		HTTP:               httpServer,
@@ -67,6 +195,11 @@ func New(
 		Hub:                hub,
 		HeartbeatChecker:   heartbeatChecker,
 		MachineSnapshotJob: machineSnapshotJob,
+		SnapshotRollupJob:  snapshotRollupJob,
+		VulnFeedJob:        vulnFeedJob,
+		JobRunner:          jobRunner,
+		ScheduleRunner:     scheduleRunner,
+		ConfigPusher:       configPusher,
 	}
 }
 
@@ -77,6 +210,26 @@ func (s *Server) Start() error {
 	s.snapshotJobCtx, s.snapshotJobCancel = context.WithCancel(context.Background())
 	go s.MachineSnapshotJob.Run(s.snapshotJobCtx)
 
+	var rollupCtx context.Context
+	rollupCtx, s.rollupCancel = context.WithCancel(context.Background())
+	go s.SnapshotRollupJob.Run(rollupCtx)
+
+	var vulnFeedCtx context.Context
+	vulnFeedCtx, s.vulnFeedCancel = context.WithCancel(context.Background())
+	go s.VulnFeedJob.Run(vulnFeedCtx)
+
+	var jobRunnerCtx context.Context
+	jobRunnerCtx, s.jobRunnerCancel = context.WithCancel(context.Background())
+	go s.JobRunner.Run(jobRunnerCtx)
+
+	var scheduleCtx context.Context
+	scheduleCtx, s.scheduleCancel = context.WithCancel(context.Background())
+	go s.ScheduleRunner.Run(scheduleCtx)
+
+	var configCtx context.Context
+	configCtx, s.configCancel = context.WithCancel(context.Background())
+	go s.ConfigPusher.Run(configCtx)
+
 	go func() {
 		if err := s.GRPC.Start(); err != nil {
 			log.Fatalf("Failed to start gRPC server: %v", err)
@@ -102,6 +255,21 @@ func (s *Server) Shutdown(ctx context.Context) error {
 	if s.snapshotJobCancel != nil {
 		s.snapshotJobCancel()
 	}
+	if s.rollupCancel != nil {
+		s.rollupCancel()
+	}
+	if s.vulnFeedCancel != nil {
+		s.vulnFeedCancel()
+	}
+	if s.jobRunnerCancel != nil {
+		s.jobRunnerCancel()
+	}
+	if s.scheduleCancel != nil {
+		s.scheduleCancel()
+	}
+	if s.configCancel != nil {
+		s.configCancel()
+	}
 
 	s.GRPC.Stop()
 
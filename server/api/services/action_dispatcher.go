@@ -0,0 +1,155 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	pb "github.com/lute/agent/proto/agent"
+	luteGrpc "github.com/lute/api/grpc"
+	"github.com/lute/api/models"
+	"github.com/lute/api/repository"
+)
+
+const (
+	actionSendTimeout   = 5 * time.Second
+	actionResultTimeout = 30 * time.Second
+	// ActionQueueTTL is how long an action waits for its machine's agent to
+	// connect before it fails.
+	ActionQueueTTL = time.Hour
+)
+
+// actionExpired is the error of an action whose agent did not connect in time.
+var actionExpired = fmt.Sprintf("the agent did not connect within %s", ActionQueueTTL)
+
+// ActionDispatcher sends typed actions (see ActionService) to connected
+// agents and records their results on the Command. Actions for an offline
+// agent are sent when it connects; actions sent without a result are sent
+// again after a reconnect, and the agent answers those it already ran with
+// the earlier result.
+type ActionDispatcher struct {
+	commandRepo *repository.CommandRepository
+	connMgr     *luteGrpc.ConnectionManager
+
+	// OnResult, if set, is called after a result is recorded.
+	OnResult func()
+}
+
+func NewActionDispatcher(commandRepo *repository.CommandRepository, connMgr *luteGrpc.ConnectionManager) *ActionDispatcher {
+	return &ActionDispatcher{commandRepo: commandRepo, connMgr: connMgr}
+}
+
+// Dispatch sends cmd if its machine is connected.
+func (d *ActionDispatcher) Dispatch(ctx context.Context, cmd *models.Command) {
+	conn := d.connMgr.Get(cmd.MachineID.Hex())
+	if conn == nil || !conn.Supports(pb.Feature_FEATURE_ACTIONS) {
+		return
+	}
+	d.send(ctx, conn, cmd)
+}
+
+// DeliverPending sends the open actions of a machine that just connected,
+// failing those that waited longer than ActionQueueTTL.
+func (d *ActionDispatcher) DeliverPending(ctx context.Context, machineID string) {
+	conn := d.connMgr.Get(machineID)
+	if conn == nil || !conn.Supports(pb.Feature_FEATURE_ACTIONS) {
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(machineID)
+	if err != nil {
+		return
+	}
+	commands, err := d.commandRepo.GetOpenActions(ctx, id)
+	if err != nil {
+		log.Printf("Actions: failed to load open actions of machine %s: %v", machineID, err)
+		return
+	}
+	for _, cmd := range commands {
+		if cmd.Status == "pending" && time.Since(cmd.CreatedAt) > ActionQueueTTL {
+			now := time.Now().UTC()
+			expired := &models.Command{
+				Status:     "failed",
+				ExitCode:   -1,
+				Error:      actionExpired,
+				FinishedAt: &now,
+			}
+			if _, err := d.commandRepo.FinishAction(ctx, cmd.ID, id, expired); err != nil {
+				log.Printf("Actions: failed to expire action %s: %v", cmd.ID.Hex(), err)
+			}
+			continue
+		}
+		d.send(ctx, conn, cmd)
+	}
+}
+
+func (d *ActionDispatcher) send(ctx context.Context, conn *luteGrpc.MachineConnection, cmd *models.Command) {
+	err := conn.Send(&pb.ServerMessage{
+		Payload: &pb.ServerMessage_ActionRequest{ActionRequest: &pb.ActionRequest{
+			Id:             cmd.ID.Hex(),
+			Action:         cmd.Action,
+			Params:         cmd.Params,
+			Args:           cmd.Args,
+			TimeoutSeconds: uint32(cmd.TimeoutSeconds),
+		}},
+	}, actionSendTimeout)
+	if err != nil {
+		log.Printf("Actions: failed to send %s to machine %s: %v", cmd.Action, cmd.MachineID.Hex(), err)
+		return
+	}
+	if cmd.Status == "pending" {
+		if err := d.commandRepo.MarkRunning(ctx, cmd.ID); err != nil {
+			log.Printf("Actions: failed to mark action %s as running: %v", cmd.ID.Hex(), err)
+		}
+	}
+}
+
+// HandleMessage records an ActionResult. It never replies.
+func (d *ActionDispatcher) HandleMessage(machineID string, msg *pb.AgentMessage) *pb.ServerMessage {
+	res := msg.GetActionResult()
+	if res == nil {
+		return nil
+	}
+	mid, err := primitive.ObjectIDFromHex(machineID)
+	if err != nil {
+		return nil
+	}
+	id, err := primitive.ObjectIDFromHex(res.GetId())
+	if err != nil {
+		return nil
+	}
+	result := &models.Command{
+		Status:   "failed",
+		Output:   res.GetOutput(),
+		ExitCode: int(res.GetExitCode()),
+		Error:    res.GetError(),
+	}
+	if res.GetSuccess() {
+		result.Status = "completed"
+	}
+	if ts := res.GetStartedAt(); ts > 0 {
+		t := time.Unix(ts, 0).UTC()
+		result.StartedAt = &t
+	}
+	if ts := res.GetFinishedAt(); ts > 0 {
+		t := time.Unix(ts, 0).UTC()
+		result.FinishedAt = &t
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), actionResultTimeout)
+	defer cancel()
+	recorded, err := d.commandRepo.FinishAction(ctx, id, mid, result)
+	if err != nil {
+		log.Printf("Actions: failed to store result of action %s: %v", res.GetId(), err)
+		return nil
+	}
+	if recorded {
+		log.Printf("Actions: action %s on machine %s %s", res.GetId(), machineID, result.Status)
+		if d.OnResult != nil {
+			d.OnResult()
+		}
+	}
+	return nil
+}
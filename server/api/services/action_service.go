@@ -0,0 +1,200 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	pb "github.com/lute/agent/proto/agent"
+	"github.com/lute/api/audit"
+	"github.com/lute/api/authz"
+	luteGrpc "github.com/lute/api/grpc"
+	"github.com/lute/api/models"
+	"github.com/lute/api/repository"
+)
+
+// Typed actions an agent can carry out.
+const (
+	ActionServiceStart   = "service-start"
+	ActionServiceStop    = "service-stop"
+	ActionServiceRestart = "service-restart"
+	ActionReboot         = "reboot"
+	ActionRunScript      = "run-script"
+)
+
+// Actions lists the typed actions, in the order they are presented.
+var Actions = []string{ActionServiceStart, ActionServiceStop, ActionServiceRestart, ActionReboot, ActionRunScript}
+
+const (
+	maxActionTimeout = 3600  // seconds
+	maxRebootDelay   = 86400 // seconds
+	maxScriptArgs    = 32
+)
+
+var (
+	ErrUnknownAction      = errors.New("unknown action")
+	ErrActionUnsupported  = errors.New("the machine's agent does not support actions; update it")
+	ErrActionDisabled     = errors.New("the action is not enabled by the agent's policy")
+	ErrInvalidActionParam = errors.New("invalid action parameters")
+)
+
+var (
+	actionUnitName   = regexp.MustCompile(`^[A-Za-z0-9:_.@-]+\.(service|socket|target|timer|mount|path)$`)
+	actionScriptName = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*$`)
+)
+
+// ActionInput holds the parameters of an action request.
+type ActionInput struct {
+	Params         map[string]string
+	Args           []string // run-script only
+	TimeoutSeconds int      // 0 for the agent's default
+}
+
+// AvailableAction is an action and whether a machine's agent carries it out.
+type AvailableAction struct {
+	Action  string `json:"action"`
+	Enabled bool   `json:"enabled"`
+}
+
+// ActionService validates requests for typed actions, records them as
+// Commands and hands them to the ActionDispatcher.
+type ActionService struct {
+	commandRepo *repository.CommandRepository
+	machines    *MachineService
+	dispatcher  *ActionDispatcher
+	audit       *audit.Recorder
+}
+
+func NewActionService(commandRepo *repository.CommandRepository, machines *MachineService, dispatcher *ActionDispatcher, recorder *audit.Recorder) *ActionService {
+	return &ActionService{
+		commandRepo: commandRepo,
+		machines:    machines,
+		dispatcher:  dispatcher,
+		audit:       recorder,
+	}
+}
+
+// Available lists the actions and whether the machine's agent reported
+// them as enabled when it last connected.
+func (s *ActionService) Available(ctx context.Context, machineID, userID primitive.ObjectID) ([]AvailableAction, error) {
+	machine, err := s.machines.GetForUser(ctx, machineID, userID, authz.ActionMachineRead)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]AvailableAction, 0, len(Actions))
+	for _, name := range Actions {
+		out = append(out, AvailableAction{Action: name, Enabled: actionEnabled(machine, name) == nil})
+	}
+	return out, nil
+}
+
+// Request queues an action on a machine the user may run commands on. It is
+// sent at once if the agent is connected, otherwise when it connects (for
+// up to ActionQueueTTL).
+func (s *ActionService) Request(ctx context.Context, machineID, userID primitive.ObjectID, action string, in ActionInput) (*models.Command, error) {
+	if !slices.Contains(Actions, action) {
+		return nil, ErrUnknownAction
+	}
+	description, err := validateAction(action, in)
+	if err != nil {
+		return nil, err
+	}
+	machine, err := s.machines.GetForUser(ctx, machineID, userID, authz.ActionCommandExecute)
+	if err != nil {
+		return nil, err
+	}
+	if err := actionEnabled(machine, action); err != nil {
+		return nil, err
+	}
+
+	cmd := &models.Command{
+		MachineID:      machineID,
+		Command:        description,
+		Args:           in.Args,
+		Status:         "pending",
+		Action:         action,
+		Params:         in.Params,
+		TimeoutSeconds: in.TimeoutSeconds,
+		RequestedBy:    userID,
+	}
+	if err := s.commandRepo.Create(ctx, cmd); err != nil {
+		return nil, err
+	}
+	s.audit.Record(ctx, audit.Entry{
+		Action: audit.ActionCommandAction,
+		Target: audit.MachineTarget(machine),
+		Details: map[string]interface{}{
+			"command_id": cmd.ID.Hex(),
+			"action":     action,
+			"params":     in.Params,
+			"args":       in.Args,
+		},
+	})
+	s.dispatcher.Dispatch(ctx, cmd)
+	return cmd, nil
+}
+
+// actionEnabled checks what the agent reported in its last Hello. The agent
+// checks its policy again before running anything.
+func actionEnabled(machine *models.Machine, action string) error {
+	if machine.Host == nil || !slices.Contains(machine.Host.Features, luteGrpc.FeatureName(pb.Feature_FEATURE_ACTIONS)) {
+		return ErrActionUnsupported
+	}
+	if !slices.Contains(machine.Host.Actions, action) {
+		return ErrActionDisabled
+	}
+	return nil
+}
+
+// validateAction checks the parameters of an action and describes it.
+func validateAction(action string, in ActionInput) (string, error) {
+	if in.TimeoutSeconds < 0 || in.TimeoutSeconds > maxActionTimeout {
+		return "", fmt.Errorf("%w: timeout_seconds must be between 0 and %d", ErrInvalidActionParam, maxActionTimeout)
+	}
+	if action != ActionRunScript && len(in.Args) > 0 {
+		return "", fmt.Errorf("%w: args are only accepted by %s", ErrInvalidActionParam, ActionRunScript)
+	}
+	var allowed []string
+	var description string
+	switch action {
+	case ActionServiceStart, ActionServiceStop, ActionServiceRestart:
+		allowed = []string{"unit"}
+		unit := in.Params["unit"]
+		if !actionUnitName.MatchString(unit) {
+			return "", fmt.Errorf("%w: unit must be a unit name such as nginx.service", ErrInvalidActionParam)
+		}
+		description = "systemctl " + strings.TrimPrefix(action, "service-") + " " + unit
+	case ActionReboot:
+		allowed = []string{"delay_seconds"}
+		description = "reboot"
+		if raw, ok := in.Params["delay_seconds"]; ok {
+			delay, err := strconv.Atoi(raw)
+			if err != nil || delay < 0 || delay > maxRebootDelay {
+				return "", fmt.Errorf("%w: delay_seconds must be between 0 and %d", ErrInvalidActionParam, maxRebootDelay)
+			}
+			description = fmt.Sprintf("reboot in %ds", delay)
+		}
+	case ActionRunScript:
+		allowed = []string{"script"}
+		script := in.Params["script"]
+		if !actionScriptName.MatchString(script) {
+			return "", fmt.Errorf("%w: script must be the file name of a script in the agent's scripts directory", ErrInvalidActionParam)
+		}
+		if len(in.Args) > maxScriptArgs {
+			return "", fmt.Errorf("%w: at most %d args", ErrInvalidActionParam, maxScriptArgs)
+		}
+		description = strings.Join(append([]string{script}, in.Args...), " ")
+	}
+	for key := range in.Params {
+		if !slices.Contains(allowed, key) {
+			return "", fmt.Errorf("%w: unknown parameter %q", ErrInvalidActionParam, key)
+		}
+	}
+	return description, nil
+}
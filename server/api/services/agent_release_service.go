@@ -0,0 +1,148 @@
+package services
+
+import (
+	"context"
+	"io"
+	"log"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/lute/api/agentbin"
+	"github.com/lute/api/audit"
+	"github.com/lute/api/authz"
+	"github.com/lute/api/repository"
+)
+
+// UploadAgentBinaryInput describes an uploaded agent build for one platform.
+type UploadAgentBinaryInput struct {
+	Version   string
+	OS        string
+	Arch      string
+	Binary    io.Reader
+	Signature []byte // detached ed25519 signature; nil for unsigned builds
+	Channel   string // optional: point this channel at the version afterwards
+}
+
+// AgentReleaseService manages the agent builds served by this instance:
+// uploads, release channels and pruning of old versions. Only the users
+// listed in AGENT_RELEASE_ADMINS may use it.
+type AgentReleaseService struct {
+	binaries    *agentbin.Index
+	userRepo    *repository.UserRepository
+	rolloutRepo *repository.AgentRolloutRepository
+	admins      []string
+	keep        int
+	audit       *audit.Recorder
+}
+
+func NewAgentReleaseService(
+	binaries *agentbin.Index,
+	userRepo *repository.UserRepository,
+	rolloutRepo *repository.AgentRolloutRepository,
+	admins []string,
+	keep int,
+	recorder *audit.Recorder,
+) *AgentReleaseService {
+	return &AgentReleaseService{
+		binaries:    binaries,
+		userRepo:    userRepo,
+		rolloutRepo: rolloutRepo,
+		admins:      admins,
+		keep:        keep,
+		audit:       recorder,
+	}
+}
+
+// Upload stores a build, optionally moves a channel to it and prunes old
+// versions.
+func (s *AgentReleaseService) Upload(ctx context.Context, userID primitive.ObjectID, in UploadAgentBinaryInput) (*agentbin.Binary, error) {
+	if err := s.requireAdmin(ctx, userID); err != nil {
+		return nil, err
+	}
+	bin, err := s.binaries.Add(strings.TrimSpace(in.Version), in.OS, in.Arch, in.Binary, in.Signature)
+	if err != nil {
+		return nil, err
+	}
+	s.audit.Record(ctx, audit.Entry{
+		Action:  audit.ActionAgentReleaseUpload,
+		Target:  audit.AgentReleaseTarget(bin.Version),
+		Details: map[string]interface{}{"os": bin.OS, "arch": bin.Arch, "sha256": bin.SHA256, "size": bin.Size, "signed": len(bin.Signature) > 0},
+	})
+
+	if in.Channel != "" {
+		if err := s.setChannel(ctx, in.Channel, bin.Version); err != nil {
+			return bin, err
+		}
+	}
+	if _, err := s.prune(ctx); err != nil {
+		log.Printf("AgentReleaseService: failed to prune old agent versions: %v", err)
+	}
+	return bin, nil
+}
+
+// SetChannel points a release channel at an uploaded version.
+func (s *AgentReleaseService) SetChannel(ctx context.Context, userID primitive.ObjectID, channel, version string) error {
+	if err := s.requireAdmin(ctx, userID); err != nil {
+		return err
+	}
+	return s.setChannel(ctx, channel, strings.TrimSpace(version))
+}
+
+// Prune deletes old versions beyond the configured number to keep (none
+// when it is 0). Versions used by a channel or a rollout are kept.
+func (s *AgentReleaseService) Prune(ctx context.Context, userID primitive.ObjectID) ([]string, error) {
+	if err := s.requireAdmin(ctx, userID); err != nil {
+		return nil, err
+	}
+	return s.prune(ctx)
+}
+
+func (s *AgentReleaseService) setChannel(ctx context.Context, channel, version string) error {
+	previous, _ := s.binaries.Resolve("", channel)
+	if err := s.binaries.SetChannel(channel, version); err != nil {
+		return err
+	}
+	s.audit.Record(ctx, audit.Entry{
+		Action:  audit.ActionAgentChannelSet,
+		Target:  audit.AgentReleaseTarget(version),
+		Details: map[string]interface{}{"channel": channel, "previous_version": previous},
+	})
+	return nil
+}
+
+func (s *AgentReleaseService) prune(ctx context.Context) ([]string, error) {
+	if s.keep <= 0 {
+		return nil, nil // pruning disabled
+	}
+	inUse, err := s.rolloutRepo.Versions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	removed, err := s.binaries.Prune(s.keep, inUse)
+	for _, version := range removed {
+		s.audit.Record(ctx, audit.Entry{
+			Action: audit.ActionAgentReleasePrune,
+			Target: audit.AgentReleaseTarget(version),
+		})
+	}
+	return removed, err
+}
+
+// requireAdmin allows release admins, and API tokens of release admins with
+// the agent:releases scope.
+func (s *AgentReleaseService) requireAdmin(ctx context.Context, userID primitive.ObjectID) error {
+	if err := authz.CheckScope(ctx, authz.ActionAgentRelease); err != nil {
+		return err
+	}
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return authz.ErrForbidden
+	}
+	for _, admin := range s.admins {
+		if strings.EqualFold(admin, user.Email) {
+			return nil
+		}
+	}
+	return authz.ErrForbidden
+}
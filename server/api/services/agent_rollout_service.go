@@ -0,0 +1,216 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/lute/api/agentbin"
+	"github.com/lute/api/audit"
+	"github.com/lute/api/authz"
+	"github.com/lute/api/models"
+	"github.com/lute/api/repository"
+)
+
+var (
+	ErrRolloutNotFound       = errors.New("agent rollout not found")
+	ErrRolloutVersionMissing = errors.New("version is required")
+	ErrRolloutUnknownVersion = errors.New("no agent binary of that version is available")
+	ErrRolloutBadPercent     = errors.New("percent must be between 1 and 100")
+	ErrRolloutBadMachine     = errors.New("machine not found or not owned by the rollout's owner")
+)
+
+// CreateAgentRolloutInput describes an agent rollout to create.
+type CreateAgentRolloutInput struct {
+	OrgID      primitive.ObjectID // zero for the creator's personal machines
+	Version    string
+	MachineIDs []primitive.ObjectID
+	GroupIDs   []primitive.ObjectID // must be the creator's groups
+	Percent    int                  // 0 means 100
+}
+
+// AgentRolloutService manages agent rollouts and pushes changes to the
+// connected agents through the AgentUpdater.
+type AgentRolloutService struct {
+	rolloutRepo *repository.AgentRolloutRepository
+	machineRepo *repository.MachineRepository
+	groupRepo   *repository.MachineGroupRepository
+	binaries    *agentbin.Index
+	updater     *AgentUpdater
+	authz       *authz.Authorizer
+	audit       *audit.Recorder
+}
+
+func NewAgentRolloutService(
+	rolloutRepo *repository.AgentRolloutRepository,
+	machineRepo *repository.MachineRepository,
+	groupRepo *repository.MachineGroupRepository,
+	binaries *agentbin.Index,
+	updater *AgentUpdater,
+	authorizer *authz.Authorizer,
+	recorder *audit.Recorder,
+) *AgentRolloutService {
+	return &AgentRolloutService{
+		rolloutRepo: rolloutRepo,
+		machineRepo: machineRepo,
+		groupRepo:   groupRepo,
+		binaries:    binaries,
+		updater:     updater,
+		authz:       authorizer,
+		audit:       recorder,
+	}
+}
+
+// Create starts a rollout. Connected agents it applies to are told at once.
+func (s *AgentRolloutService) Create(ctx context.Context, userID primitive.ObjectID, in CreateAgentRolloutInput) (*models.AgentRollout, error) {
+	version := strings.TrimSpace(in.Version)
+	if version == "" {
+		return nil, ErrRolloutVersionMissing
+	}
+	if in.Percent == 0 {
+		in.Percent = 100
+	}
+	if in.Percent < 1 || in.Percent > 100 {
+		return nil, ErrRolloutBadPercent
+	}
+	if err := s.authorize(ctx, userID, in.OrgID); err != nil {
+		return nil, err
+	}
+	if !s.versionAvailable(version) {
+		return nil, ErrRolloutUnknownVersion
+	}
+	for _, id := range in.MachineIDs {
+		m, err := s.machineRepo.GetByID(ctx, id)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				return nil, ErrRolloutBadMachine
+			}
+			return nil, err
+		}
+		if m.OrgID != in.OrgID || (in.OrgID.IsZero() && m.UserID != userID) {
+			return nil, ErrRolloutBadMachine
+		}
+	}
+	for _, id := range in.GroupIDs {
+		group, err := s.groupRepo.GetByID(ctx, id)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				return nil, ErrGroupNotFound
+			}
+			return nil, err
+		}
+		if err := s.authz.AuthorizeGroup(ctx, userID, group, authz.ActionMachineRead); err != nil {
+			return nil, err
+		}
+	}
+
+	rollout := &models.AgentRollout{
+		UserID:     userID,
+		OrgID:      in.OrgID,
+		Version:    version,
+		MachineIDs: in.MachineIDs,
+		GroupIDs:   in.GroupIDs,
+		Percent:    in.Percent,
+	}
+	if err := s.rolloutRepo.Create(ctx, rollout); err != nil {
+		return nil, err
+	}
+	s.audit.Record(ctx, audit.Entry{
+		Action: audit.ActionAgentRolloutCreate,
+		Target: audit.AgentRolloutTarget(rollout),
+		Details: map[string]interface{}{
+			"version":     rollout.Version,
+			"percent":     rollout.Percent,
+			"machine_ids": rollout.MachineIDs,
+			"group_ids":   rollout.GroupIDs,
+		},
+	})
+	go s.updater.OfferAll(context.Background())
+	return rollout, nil
+}
+
+// List returns the user's personal rollouts and those of orgs where they may
+// change machines.
+func (s *AgentRolloutService) List(ctx context.Context, userID primitive.ObjectID) ([]*models.AgentRollout, error) {
+	if err := authz.CheckScope(ctx, authz.ActionMachineWrite); err != nil {
+		return nil, err
+	}
+	orgIDs, err := s.authz.OrgIDsAllowing(ctx, userID, authz.ActionMachineWrite)
+	if err != nil {
+		return nil, err
+	}
+	return s.rolloutRepo.GetVisible(ctx, userID, orgIDs)
+}
+
+// SetPercent widens or narrows a rollout.
+func (s *AgentRolloutService) SetPercent(ctx context.Context, userID, id primitive.ObjectID, percent int) (*models.AgentRollout, error) {
+	if percent < 1 || percent > 100 {
+		return nil, ErrRolloutBadPercent
+	}
+	rollout, err := s.getForUser(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.rolloutRepo.SetPercent(ctx, id, percent); err != nil {
+		return nil, err
+	}
+	s.audit.Record(ctx, audit.Entry{
+		Action:  audit.ActionAgentRolloutUpdate,
+		Target:  audit.AgentRolloutTarget(rollout),
+		Details: map[string]interface{}{"version": rollout.Version, "percent": percent, "previous_percent": rollout.Percent},
+	})
+	rollout.Percent = percent
+	go s.updater.OfferAll(context.Background())
+	return rollout, nil
+}
+
+// Delete removes a rollout. Agents already updated keep their version; the
+// machines fall back to the next older matching rollout, if any.
+func (s *AgentRolloutService) Delete(ctx context.Context, userID, id primitive.ObjectID) error {
+	rollout, err := s.getForUser(ctx, userID, id)
+	if err != nil {
+		return err
+	}
+	if err := s.rolloutRepo.Delete(ctx, id); err != nil {
+		return err
+	}
+	s.audit.Record(ctx, audit.Entry{
+		Action:  audit.ActionAgentRolloutDelete,
+		Target:  audit.AgentRolloutTarget(rollout),
+		Details: map[string]interface{}{"version": rollout.Version, "percent": rollout.Percent},
+	})
+	go s.updater.OfferAll(context.Background())
+	return nil
+}
+
+func (s *AgentRolloutService) getForUser(ctx context.Context, userID, id primitive.ObjectID) (*models.AgentRollout, error) {
+	rollout, err := s.rolloutRepo.GetByID(ctx, id)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrRolloutNotFound
+		}
+		return nil, err
+	}
+	if rollout.OrgID.IsZero() && rollout.UserID != userID {
+		return nil, ErrRolloutNotFound
+	}
+	if err := s.authorize(ctx, userID, rollout.OrgID); err != nil {
+		return nil, err
+	}
+	return rollout, nil
+}
+
+func (s *AgentRolloutService) versionAvailable(version string) bool {
+	return s.binaries.HasVersion(version)
+}
+
+func (s *AgentRolloutService) authorize(ctx context.Context, userID, orgID primitive.ObjectID) error {
+	if orgID.IsZero() {
+		return s.authz.AuthorizePersonal(ctx, authz.ActionMachineWrite)
+	}
+	_, err := s.authz.AuthorizeOrg(ctx, userID, orgID, authz.ActionMachineWrite)
+	return err
+}
@@ -0,0 +1,148 @@
+package services
+
+import (
+	"context"
+	"hash/fnv"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	pb "github.com/lute/agent/proto/agent"
+	"github.com/lute/api/agentbin"
+	luteGrpc "github.com/lute/api/grpc"
+	"github.com/lute/api/models"
+	"github.com/lute/api/repository"
+)
+
+const updateSendTimeout = 5 * time.Second
+
+// AgentUpdater tells connected agents which version to run, as decided by
+// the agent rollouts of their owner. Agents download the binary themselves.
+type AgentUpdater struct {
+	machineRepo *repository.MachineRepository
+	rolloutRepo *repository.AgentRolloutRepository
+	binaries    *agentbin.Index
+	connMgr     *luteGrpc.ConnectionManager
+}
+
+func NewAgentUpdater(
+	machineRepo *repository.MachineRepository,
+	rolloutRepo *repository.AgentRolloutRepository,
+	binaries *agentbin.Index,
+	connMgr *luteGrpc.ConnectionManager,
+) *AgentUpdater {
+	return &AgentUpdater{
+		machineRepo: machineRepo,
+		rolloutRepo: rolloutRepo,
+		binaries:    binaries,
+		connMgr:     connMgr,
+	}
+}
+
+// DesiredVersion returns the version the newest matching rollout sets for
+// the machine, or "" when no rollout applies.
+func (u *AgentUpdater) DesiredVersion(ctx context.Context, m *models.Machine) (string, error) {
+	rollouts, err := u.rolloutRepo.GetForMachine(ctx, m)
+	if err != nil {
+		return "", err
+	}
+	for _, r := range rollouts {
+		if rolloutTargets(r, m) {
+			return r.Version, nil
+		}
+	}
+	return "", nil
+}
+
+// Offer sends an AgentUpdate to a connected machine whose rollout asks for
+// a version other than the one it reported.
+func (u *AgentUpdater) Offer(ctx context.Context, machineID string) {
+	conn := u.connMgr.Get(machineID)
+	if conn == nil || !conn.Supports(pb.Feature_FEATURE_SELF_UPDATE) {
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(machineID)
+	if err != nil {
+		return
+	}
+	m, err := u.machineRepo.GetByID(ctx, id)
+	if err != nil {
+		log.Printf("AgentUpdater: failed to load machine %s: %v", machineID, err)
+		return
+	}
+	version, err := u.DesiredVersion(ctx, m)
+	if err != nil {
+		log.Printf("AgentUpdater: failed to resolve rollout of machine %s: %v", machineID, err)
+		return
+	}
+	if version == "" || version == m.AgentVersion {
+		return
+	}
+
+	osName, _ := m.Metadata["os"].(string)
+	arch, _ := m.Metadata["arch"].(string)
+	bin, ok := u.binaries.Find(osName, arch, version)
+	if !ok {
+		log.Printf("AgentUpdater: machine %s should run %s, but no %s/%s binary of that version is available", machineID, version, osName, arch)
+		return
+	}
+	err = conn.Send(&pb.ServerMessage{
+		Payload: &pb.ServerMessage_AgentUpdate{AgentUpdate: &pb.AgentUpdate{
+			Version:      bin.Version,
+			Sha256:       bin.SHA256,
+			Size:         bin.Size,
+			Signature:    bin.Signature,
+			DownloadPath: bin.DownloadPath(),
+		}},
+	}, updateSendTimeout)
+	if err != nil {
+		log.Printf("AgentUpdater: failed to offer %s to machine %s: %v", version, machineID, err)
+		return
+	}
+	log.Printf("AgentUpdater: offered version %s to machine %s (running %s)", version, machineID, m.AgentVersion)
+}
+
+// OfferAll re-evaluates every connected machine, e.g. after a rollout changed.
+func (u *AgentUpdater) OfferAll(ctx context.Context) {
+	for _, id := range u.connMgr.ConnectedMachineIDs() {
+		u.Offer(ctx, id)
+	}
+}
+
+// rolloutTargets reports whether r applies to m. The caller has already
+// matched the owner.
+func rolloutTargets(r *models.AgentRollout, m *models.Machine) bool {
+	if len(r.MachineIDs) > 0 || len(r.GroupIDs) > 0 {
+		if !containsID(r.MachineIDs, m.ID) && !sharesID(r.GroupIDs, m.GroupIDs) {
+			return false
+		}
+	}
+	return rolloutBucket(m.ID) < r.Percent
+}
+
+// rolloutBucket places a machine in one of 100 buckets. It does not depend
+// on the rollout, so the same machines go first in every rollout.
+func rolloutBucket(id primitive.ObjectID) int {
+	h := fnv.New32a()
+	h.Write(id[:])
+	return int(h.Sum32() % 100)
+}
+
+func containsID(ids []primitive.ObjectID, id primitive.ObjectID) bool {
+	for _, x := range ids {
+		if x == id {
+			return true
+		}
+	}
+	return false
+}
+
+func sharesID(a, b []primitive.ObjectID) bool {
+	for _, x := range a {
+		if containsID(b, x) {
+			return true
+		}
+	}
+	return false
+}
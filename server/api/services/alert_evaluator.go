@@ -0,0 +1,193 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"slices"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/lute/api/labels"
+	"github.com/lute/api/models"
+	"github.com/lute/api/repository"
+)
+
+// AlertEvaluator checks the alert rules against the latest state of the
+// machines, fires an alert when a rule's condition starts to hold and
+// resolves it when it no longer does. Both are mailed to the rule's Notify
+// addresses.
+type AlertEvaluator struct {
+	ruleRepo    *repository.AlertRuleRepository
+	alertRepo   *repository.AlertRepository
+	machineRepo *repository.MachineRepository
+	unitsRepo   *repository.MachineUnitsRepository
+	mailer      Mailer
+
+	// mu serializes evaluations so that a condition fires only once.
+	mu sync.Mutex
+}
+
+func NewAlertEvaluator(
+	ruleRepo *repository.AlertRuleRepository,
+	alertRepo *repository.AlertRepository,
+	machineRepo *repository.MachineRepository,
+	unitsRepo *repository.MachineUnitsRepository,
+	mailer Mailer,
+) *AlertEvaluator {
+	return &AlertEvaluator{
+		ruleRepo:    ruleRepo,
+		alertRepo:   alertRepo,
+		machineRepo: machineRepo,
+		unitsRepo:   unitsRepo,
+		mailer:      mailer,
+	}
+}
+
+// EvaluateUnits evaluates the unit_state rules of the machine units belong to.
+func (e *AlertEvaluator) EvaluateUnits(ctx context.Context, units *models.MachineUnits) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.evaluateUnits(ctx, units)
+}
+
+// EvaluateAll evaluates the unit_state rules of every machine that reported
+// units, e.g. after a rule changed.
+func (e *AlertEvaluator) EvaluateAll(ctx context.Context) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	ids, err := e.unitsRepo.MachineIDs(ctx)
+	if err != nil {
+		log.Printf("Alerts: failed to list machines: %v", err)
+		return
+	}
+	for _, id := range ids {
+		units, err := e.unitsRepo.Get(ctx, id)
+		if err != nil {
+			if err != mongo.ErrNoDocuments {
+				log.Printf("Alerts: failed to load units of machine %s: %v", id.Hex(), err)
+			}
+			continue
+		}
+		if err := e.evaluateUnits(ctx, units); err != nil {
+			log.Printf("Alerts: failed to evaluate machine %s: %v", id.Hex(), err)
+		}
+	}
+}
+
+func (e *AlertEvaluator) evaluateUnits(ctx context.Context, units *models.MachineUnits) error {
+	machine, err := e.machineRepo.GetByID(ctx, units.MachineID)
+	if err != nil {
+		return err
+	}
+	rules, err := e.ruleRepo.GetEnabledForMachine(ctx, machine, models.AlertKindUnitState)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	byRule := make(map[primitive.ObjectID]*models.AlertRule, len(rules))
+	want := make(map[string]*models.Alert)
+	for _, rule := range rules {
+		byRule[rule.ID] = rule
+		if !ruleSelects(rule, machine) {
+			continue
+		}
+		for _, u := range units.Units {
+			msg, ok := unitCondition(rule, u)
+			if !ok {
+				continue
+			}
+			want[alertKey(rule.ID, u.Name)] = &models.Alert{
+				RuleID:    rule.ID,
+				RuleName:  rule.Name,
+				Kind:      models.AlertKindUnitState,
+				MachineID: machine.ID,
+				Subject:   u.Name,
+				Message:   msg,
+				Status:    models.AlertStatusFiring,
+				FiredAt:   now,
+			}
+		}
+	}
+
+	firing, err := e.alertRepo.GetFiring(ctx, machine.ID, models.AlertKindUnitState)
+	if err != nil {
+		return err
+	}
+	for _, alert := range firing {
+		key := alertKey(alert.RuleID, alert.Subject)
+		if _, ok := want[key]; ok {
+			delete(want, key)
+			continue
+		}
+		if err := e.alertRepo.Resolve(ctx, alert.ID, now); err != nil {
+			return err
+		}
+		log.Printf("Alerts: resolved %q on machine %s: %s", alert.RuleName, machine.Name, alert.Subject)
+		if rule := byRule[alert.RuleID]; rule != nil {
+			e.notify(rule, machine, "RESOLVED", alert.Subject+" no longer matches the rule.")
+		}
+	}
+	for _, alert := range want {
+		if err := e.alertRepo.Insert(ctx, alert); err != nil {
+			return err
+		}
+		log.Printf("Alerts: fired %q on machine %s: %s", alert.RuleName, machine.Name, alert.Message)
+		e.notify(byRule[alert.RuleID], machine, "FIRING", alert.Message)
+	}
+	return nil
+}
+
+func (e *AlertEvaluator) notify(rule *models.AlertRule, machine *models.Machine, status, message string) {
+	if len(rule.Notify) == 0 {
+		return
+	}
+	subject := fmt.Sprintf("[%s] %s on %s", status, rule.Name, machine.Name)
+	body := fmt.Sprintf("Alert rule %q on machine %q: %s", rule.Name, machine.Name, message)
+	for _, to := range rule.Notify {
+		if err := e.mailer.Send(to, subject, body); err != nil {
+			log.Printf("Alerts: failed to mail %s: %v", to, err)
+		}
+	}
+}
+
+func alertKey(ruleID primitive.ObjectID, subject string) string {
+	return ruleID.Hex() + "/" + subject
+}
+
+// ruleSelects reports whether a rule's selector matches the machine. Rules
+// with an invalid selector match nothing; they are validated when saved.
+func ruleSelects(rule *models.AlertRule, machine *models.Machine) bool {
+	if rule.Selector == "" {
+		return true
+	}
+	sel, err := labels.ParseSelector(rule.Selector)
+	if err != nil {
+		return false
+	}
+	return sel.Matches(machine.Labels)
+}
+
+// unitCondition reports whether a unit_state rule holds for u and, if so,
+// describes why.
+func unitCondition(rule *models.AlertRule, u models.SystemdUnit) (string, bool) {
+	if ok, _ := path.Match(rule.Unit, u.Name); !ok {
+		return "", false
+	}
+	if slices.Contains(rule.States, u.ActiveState) {
+		msg := fmt.Sprintf("%s is %s (%s)", u.Name, u.ActiveState, u.SubState)
+		if u.Result != "" && u.Result != "success" {
+			msg += ", result " + u.Result
+		}
+		return msg, true
+	}
+	if rule.MinRestarts > 0 && u.Restarts >= rule.MinRestarts {
+		return fmt.Sprintf("%s restarted %d times", u.Name, u.Restarts), true
+	}
+	return "", false
+}
@@ -0,0 +1,285 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"path"
+	"slices"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/lute/api/audit"
+	"github.com/lute/api/authz"
+	"github.com/lute/api/labels"
+	"github.com/lute/api/models"
+	"github.com/lute/api/repository"
+)
+
+// AlertLimit caps the alerts returned by one query.
+const AlertLimit = 500
+
+var (
+	ErrAlertRuleNotFound    = errors.New("alert rule not found")
+	ErrAlertRuleName        = errors.New("name is required")
+	ErrAlertRuleKind        = errors.New("unknown alert rule kind")
+	ErrAlertRuleUnit        = errors.New("unit must be a unit name or glob, e.g. nginx.service or *")
+	ErrAlertRuleState       = errors.New("states must be systemd active states (active, reloading, inactive, failed, activating, deactivating, maintenance)")
+	ErrAlertRuleNotify      = errors.New("notify must be email addresses")
+	ErrAlertRuleBadSelector = errors.New("invalid selector")
+)
+
+// unitActiveStates are the values of a systemd unit's ActiveState.
+var unitActiveStates = []string{"active", "reloading", "inactive", "failed", "activating", "deactivating", "maintenance"}
+
+// AlertRuleInput describes an alert rule to create or the new values of one.
+type AlertRuleInput struct {
+	OrgID       primitive.ObjectID // zero for the creator's personal machines; ignored on update
+	Name        string
+	Kind        string // default unit_state
+	Selector    string
+	Unit        string // default "*"
+	States      []string
+	MinRestarts uint32
+	Notify      []string
+	Disabled    bool
+}
+
+// FleetAlert is an alert with the name of its machine.
+type FleetAlert struct {
+	*models.Alert
+	MachineName string `json:"machine_name"`
+}
+
+// AlertService manages alert rules and lists the alerts the AlertEvaluator
+// raised.
+type AlertService struct {
+	ruleRepo  *repository.AlertRuleRepository
+	alertRepo *repository.AlertRepository
+	machines  *MachineService
+	evaluator *AlertEvaluator
+	authz     *authz.Authorizer
+	audit     *audit.Recorder
+}
+
+func NewAlertService(
+	ruleRepo *repository.AlertRuleRepository,
+	alertRepo *repository.AlertRepository,
+	machines *MachineService,
+	evaluator *AlertEvaluator,
+	authorizer *authz.Authorizer,
+	recorder *audit.Recorder,
+) *AlertService {
+	return &AlertService{
+		ruleRepo:  ruleRepo,
+		alertRepo: alertRepo,
+		machines:  machines,
+		evaluator: evaluator,
+		authz:     authorizer,
+		audit:     recorder,
+	}
+}
+
+// CreateRule adds a rule and evaluates it against every machine at once.
+func (s *AlertService) CreateRule(ctx context.Context, userID primitive.ObjectID, in AlertRuleInput) (*models.AlertRule, error) {
+	if err := s.authorize(ctx, userID, in.OrgID); err != nil {
+		return nil, err
+	}
+	rule := &models.AlertRule{UserID: userID, OrgID: in.OrgID}
+	if err := applyAlertRuleInput(rule, in); err != nil {
+		return nil, err
+	}
+	if err := s.ruleRepo.Create(ctx, rule); err != nil {
+		return nil, err
+	}
+	s.audit.Record(ctx, audit.Entry{
+		Action:  audit.ActionAlertRuleCreate,
+		Target:  audit.AlertRuleTarget(rule),
+		Details: alertRuleDetails(rule),
+	})
+	go s.evaluator.EvaluateAll(context.Background())
+	return rule, nil
+}
+
+// ListRules returns the user's personal rules and those of orgs where they
+// may manage alerts.
+func (s *AlertService) ListRules(ctx context.Context, userID primitive.ObjectID) ([]*models.AlertRule, error) {
+	if err := authz.CheckScope(ctx, authz.ActionAlertManage); err != nil {
+		return nil, err
+	}
+	orgIDs, err := s.authz.OrgIDsAllowing(ctx, userID, authz.ActionAlertManage)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := s.ruleRepo.GetVisible(ctx, userID, orgIDs)
+	if err != nil {
+		return nil, err
+	}
+	if rules == nil {
+		rules = []*models.AlertRule{}
+	}
+	return rules, nil
+}
+
+// UpdateRule replaces the settings of a rule. Alerts that no longer hold are
+// resolved.
+func (s *AlertService) UpdateRule(ctx context.Context, userID, id primitive.ObjectID, in AlertRuleInput) (*models.AlertRule, error) {
+	rule, err := s.getForUser(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+	previous := alertRuleDetails(rule)
+	if err := applyAlertRuleInput(rule, in); err != nil {
+		return nil, err
+	}
+	if err := s.ruleRepo.Update(ctx, rule); err != nil {
+		return nil, err
+	}
+	s.audit.Record(ctx, audit.Entry{
+		Action:  audit.ActionAlertRuleUpdate,
+		Target:  audit.AlertRuleTarget(rule),
+		Changes: audit.Diff(previous, alertRuleDetails(rule)),
+	})
+	go s.evaluator.EvaluateAll(context.Background())
+	return rule, nil
+}
+
+// DeleteRule removes a rule and resolves its firing alerts.
+func (s *AlertService) DeleteRule(ctx context.Context, userID, id primitive.ObjectID) error {
+	rule, err := s.getForUser(ctx, userID, id)
+	if err != nil {
+		return err
+	}
+	if err := s.ruleRepo.Delete(ctx, id); err != nil {
+		return err
+	}
+	s.audit.Record(ctx, audit.Entry{
+		Action:  audit.ActionAlertRuleDelete,
+		Target:  audit.AlertRuleTarget(rule),
+		Details: alertRuleDetails(rule),
+	})
+	go s.evaluator.EvaluateAll(context.Background())
+	return nil
+}
+
+// Alerts returns the alerts of the machines visible to the user that match
+// filter, newest first, optionally only those with status.
+func (s *AlertService) Alerts(ctx context.Context, userID primitive.ObjectID, filter MachineFilter, status string, limit int) ([]FleetAlert, error) {
+	if limit <= 0 || limit > AlertLimit {
+		limit = AlertLimit
+	}
+	machines, err := s.machines.GetByUserIDFiltered(ctx, userID, filter)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]primitive.ObjectID, 0, len(machines))
+	names := make(map[primitive.ObjectID]string, len(machines))
+	for _, m := range machines {
+		ids = append(ids, m.ID)
+		names[m.ID] = m.Name
+	}
+	alerts, err := s.alertRepo.List(ctx, ids, status, int64(limit))
+	if err != nil {
+		return nil, err
+	}
+	out := make([]FleetAlert, 0, len(alerts))
+	for _, a := range alerts {
+		out = append(out, FleetAlert{Alert: a, MachineName: names[a.MachineID]})
+	}
+	return out, nil
+}
+
+func (s *AlertService) getForUser(ctx context.Context, userID, id primitive.ObjectID) (*models.AlertRule, error) {
+	rule, err := s.ruleRepo.GetByID(ctx, id)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrAlertRuleNotFound
+		}
+		return nil, err
+	}
+	if rule.OrgID.IsZero() && rule.UserID != userID {
+		return nil, ErrAlertRuleNotFound
+	}
+	if err := s.authorize(ctx, userID, rule.OrgID); err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+func (s *AlertService) authorize(ctx context.Context, userID, orgID primitive.ObjectID) error {
+	if orgID.IsZero() {
+		return s.authz.AuthorizePersonal(ctx, authz.ActionAlertManage)
+	}
+	_, err := s.authz.AuthorizeOrg(ctx, userID, orgID, authz.ActionAlertManage)
+	return err
+}
+
+// applyAlertRuleInput validates in and copies it onto rule.
+func applyAlertRuleInput(rule *models.AlertRule, in AlertRuleInput) error {
+	name := strings.TrimSpace(in.Name)
+	if name == "" {
+		return ErrAlertRuleName
+	}
+	kind := in.Kind
+	if kind == "" {
+		kind = models.AlertKindUnitState
+	}
+	if kind != models.AlertKindUnitState {
+		return ErrAlertRuleKind
+	}
+	if in.Selector != "" {
+		if _, err := labels.ParseSelector(in.Selector); err != nil {
+			return fmt.Errorf("%w: %v", ErrAlertRuleBadSelector, err)
+		}
+	}
+	unit := strings.TrimSpace(in.Unit)
+	if unit == "" {
+		unit = "*"
+	}
+	if _, err := path.Match(unit, ""); err != nil || strings.ContainsAny(unit, "/ ") {
+		return ErrAlertRuleUnit
+	}
+	for _, state := range in.States {
+		if !slices.Contains(unitActiveStates, state) {
+			return ErrAlertRuleState
+		}
+	}
+	states := in.States
+	if len(states) == 0 && in.MinRestarts == 0 {
+		states = []string{"failed"}
+	}
+	notify := make([]string, 0, len(in.Notify))
+	for _, to := range in.Notify {
+		addr, err := mail.ParseAddress(to)
+		if err != nil {
+			return ErrAlertRuleNotify
+		}
+		notify = append(notify, addr.Address)
+	}
+
+	rule.Name = name
+	rule.Kind = kind
+	rule.Selector = in.Selector
+	rule.Unit = unit
+	rule.States = states
+	rule.MinRestarts = in.MinRestarts
+	rule.Notify = notify
+	rule.Disabled = in.Disabled
+	return nil
+}
+
+func alertRuleDetails(rule *models.AlertRule) map[string]interface{} {
+	return map[string]interface{}{
+		"name":         rule.Name,
+		"kind":         rule.Kind,
+		"selector":     rule.Selector,
+		"unit":         rule.Unit,
+		"states":       rule.States,
+		"min_restarts": rule.MinRestarts,
+		"notify":       rule.Notify,
+		"disabled":     rule.Disabled,
+	}
+}
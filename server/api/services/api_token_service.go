@@ -0,0 +1,206 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/lute/api/audit"
+	"github.com/lute/api/authz"
+	"github.com/lute/api/models"
+	"github.com/lute/api/repository"
+	"github.com/lute/api/tokens"
+)
+
+// APITokenPrefix marks Lute API tokens so they can be told apart from other
+// bearer tokens (and found by secret scanners).
+const APITokenPrefix = "lute_"
+
+var (
+	ErrTokenNotFound     = errors.New("token not found")
+	ErrTokenInvalid      = errors.New("invalid API token")
+	ErrTokenExpired      = errors.New("API token has expired")
+	ErrTokenRevoked      = errors.New("API token has been revoked")
+	ErrTokenNameRequired = errors.New("token name is required")
+	ErrTokenNoScopes     = errors.New("at least one scope is required")
+	ErrTokenBadExpiry    = errors.New("expiry must be in the future")
+	ErrInvalidScope      = errors.New("invalid scope")
+)
+
+// CreateAPITokenInput describes a token to create.
+type CreateAPITokenInput struct {
+	Name      string
+	OrgID     primitive.ObjectID // zero for a user-wide token
+	Scopes    []authz.Scope
+	ExpiresAt *time.Time // nil for a token that never expires
+}
+
+// APITokenService issues, lists, revokes and verifies API tokens.
+type APITokenService struct {
+	tokenRepo *repository.APITokenRepository
+	authz     *authz.Authorizer
+	audit     *audit.Recorder
+}
+
+func NewAPITokenService(tokenRepo *repository.APITokenRepository, authorizer *authz.Authorizer, recorder *audit.Recorder) *APITokenService {
+	return &APITokenService{
+		tokenRepo: tokenRepo,
+		authz:     authorizer,
+		audit:     recorder,
+	}
+}
+
+// Create issues a new token and returns it with its plaintext value, which is
+// shown once and never stored. Tokens cannot be created with another token.
+func (s *APITokenService) Create(ctx context.Context, userID primitive.ObjectID, in CreateAPITokenInput) (*models.APIToken, string, error) {
+	if err := authz.RequireSession(ctx); err != nil {
+		return nil, "", err
+	}
+	name := strings.TrimSpace(in.Name)
+	if name == "" {
+		return nil, "", ErrTokenNameRequired
+	}
+	if len(in.Scopes) == 0 {
+		return nil, "", ErrTokenNoScopes
+	}
+	scopes := make([]string, 0, len(in.Scopes))
+	for _, sc := range in.Scopes {
+		if !sc.Valid() {
+			return nil, "", fmt.Errorf("%w: %q", ErrInvalidScope, sc)
+		}
+		scopes = append(scopes, string(sc))
+	}
+	if in.ExpiresAt != nil && !in.ExpiresAt.After(time.Now()) {
+		return nil, "", ErrTokenBadExpiry
+	}
+	if !in.OrgID.IsZero() {
+		if _, err := s.authz.AuthorizeOrg(ctx, userID, in.OrgID, authz.ActionOrgRead); err != nil {
+			return nil, "", err
+		}
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, "", err
+	}
+	plaintext := APITokenPrefix + hex.EncodeToString(b)
+	token := &models.APIToken{
+		UserID:    userID,
+		OrgID:     in.OrgID,
+		Name:      name,
+		Prefix:    plaintext[:len(APITokenPrefix)+8],
+		TokenHash: tokens.Hash(plaintext),
+		Scopes:    scopes,
+		ExpiresAt: in.ExpiresAt,
+	}
+	if err := s.tokenRepo.Create(ctx, token); err != nil {
+		return nil, "", err
+	}
+	s.audit.Record(ctx, audit.Entry{
+		Action:  audit.ActionTokenCreate,
+		Target:  audit.APITokenTarget(token),
+		Details: map[string]interface{}{"prefix": token.Prefix, "scopes": token.Scopes, "expires_at": token.ExpiresAt},
+	})
+	return token, plaintext, nil
+}
+
+// List returns the user's tokens (including revoked and expired ones).
+func (s *APITokenService) List(ctx context.Context, userID primitive.ObjectID) ([]*models.APIToken, error) {
+	if err := authz.RequireSession(ctx); err != nil {
+		return nil, err
+	}
+	return s.tokenRepo.GetByUserID(ctx, userID)
+}
+
+// Revoke revokes one of the user's tokens.
+func (s *APITokenService) Revoke(ctx context.Context, userID, tokenID primitive.ObjectID) error {
+	if err := authz.RequireSession(ctx); err != nil {
+		return err
+	}
+	token, err := s.tokenRepo.GetByID(ctx, tokenID)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return ErrTokenNotFound
+		}
+		return err
+	}
+	if token.UserID != userID {
+		return ErrTokenNotFound
+	}
+	if err := s.tokenRepo.Revoke(ctx, tokenID); err != nil {
+		return err
+	}
+	s.audit.Record(ctx, audit.Entry{
+		Action:  audit.ActionTokenRevoke,
+		Target:  audit.APITokenTarget(token),
+		Details: map[string]interface{}{"prefix": token.Prefix},
+	})
+	return nil
+}
+
+// Authenticate resolves a plaintext token to its record, rejecting unknown,
+// revoked and expired tokens, and records when it was last used.
+func (s *APITokenService) Authenticate(ctx context.Context, plaintext string) (*models.APIToken, error) {
+	if !IsAPIToken(plaintext) {
+		return nil, ErrTokenInvalid
+	}
+	token, err := s.tokenRepo.GetByTokenHash(ctx, tokens.Hash(plaintext))
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrTokenInvalid
+		}
+		return nil, err
+	}
+	now := time.Now()
+	if token.RevokedAt != nil {
+		return nil, ErrTokenRevoked
+	}
+	if token.ExpiresAt != nil && now.After(*token.ExpiresAt) {
+		return nil, ErrTokenExpired
+	}
+	if err := s.tokenRepo.TouchLastUsed(ctx, token.ID, now); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// GrantOf returns the grant of the token with the given ID for work done on
+// its behalf later, e.g. the runs of a schedule it created. It fails like
+// Authenticate for revoked and expired tokens.
+func (s *APITokenService) GrantOf(ctx context.Context, id primitive.ObjectID) (*authz.TokenGrant, error) {
+	token, err := s.tokenRepo.GetByID(ctx, id)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrTokenInvalid
+		}
+		return nil, err
+	}
+	if token.RevokedAt != nil {
+		return nil, ErrTokenRevoked
+	}
+	if token.ExpiresAt != nil && time.Now().After(*token.ExpiresAt) {
+		return nil, ErrTokenExpired
+	}
+	return Grant(token), nil
+}
+
+// IsAPIToken reports whether a bearer token looks like a Lute API token.
+func IsAPIToken(bearer string) bool {
+	return strings.HasPrefix(bearer, APITokenPrefix)
+}
+
+// Grant converts a token into the authorization grant attached to requests.
+func Grant(token *models.APIToken) *authz.TokenGrant {
+	scopes := make([]authz.Scope, 0, len(token.Scopes))
+	for _, sc := range token.Scopes {
+		scopes = append(scopes, authz.Scope(sc))
+	}
+	return &authz.TokenGrant{TokenID: token.ID, OrgID: token.OrgID, Scopes: scopes}
+}
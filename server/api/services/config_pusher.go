@@ -0,0 +1,219 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/protobuf/proto"
+
+	pb "github.com/lute/agent/proto/agent"
+	luteGrpc "github.com/lute/api/grpc"
+	"github.com/lute/api/models"
+	"github.com/lute/api/repository"
+)
+
+const (
+	configSendTimeout = 5 * time.Second
+	// configResyncInterval is how often connected agents are checked for a
+	// config other than the one they acknowledged, e.g. after a change made
+	// through another API instance.
+	configResyncInterval = time.Minute
+)
+
+// ConfigPusher sends connected agents the configuration their machine's
+// and groups' configs add up to, and records which version they applied.
+// It also keeps the machine's heartbeat interval, used by the
+// HeartbeatChecker, in line with the config.
+type ConfigPusher struct {
+	configRepo  *repository.MachineConfigRepository
+	machineRepo *repository.MachineRepository
+	connMgr     *luteGrpc.ConnectionManager
+}
+
+func NewConfigPusher(
+	configRepo *repository.MachineConfigRepository,
+	machineRepo *repository.MachineRepository,
+	connMgr *luteGrpc.ConnectionManager,
+) *ConfigPusher {
+	return &ConfigPusher{
+		configRepo:  configRepo,
+		machineRepo: machineRepo,
+		connMgr:     connMgr,
+	}
+}
+
+// Effective returns the settings that apply to m: those of its groups'
+// configs, oldest first, overridden by its own config.
+func (p *ConfigPusher) Effective(ctx context.Context, m *models.Machine) (models.AgentSettings, error) {
+	configs, err := p.configRepo.GetForMachine(ctx, m)
+	if err != nil {
+		return models.AgentSettings{}, err
+	}
+	return mergeSettings(configs), nil
+}
+
+// Push sends a connected machine its config. Unless force is set it is only
+// sent when it differs from the version the agent last acknowledged.
+func (p *ConfigPusher) Push(ctx context.Context, machineID string, force bool) {
+	conn := p.connMgr.Get(machineID)
+	if conn == nil {
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(machineID)
+	if err != nil {
+		return
+	}
+	m, err := p.machineRepo.GetByID(ctx, id)
+	if err != nil {
+		log.Printf("ConfigPusher: failed to load machine %s: %v", machineID, err)
+		return
+	}
+	settings, err := p.Effective(ctx, m)
+	if err != nil {
+		log.Printf("ConfigPusher: failed to load configs of machine %s: %v", machineID, err)
+		return
+	}
+	if settings.HeartbeatInterval != m.HeartbeatInterval {
+		if err := p.machineRepo.SetHeartbeatInterval(ctx, id, settings.HeartbeatInterval); err != nil {
+			log.Printf("ConfigPusher: failed to set heartbeat interval of machine %s: %v", machineID, err)
+		}
+	}
+	if !conn.Supports(pb.Feature_FEATURE_CONFIG) {
+		return
+	}
+	msg := agentConfig(settings)
+	if !force && m.AppliedConfig != nil && m.AppliedConfig.Version == msg.GetVersion() {
+		return
+	}
+	err = conn.Send(&pb.ServerMessage{
+		Payload: &pb.ServerMessage_AgentConfig{AgentConfig: msg},
+	}, configSendTimeout)
+	if err != nil {
+		log.Printf("ConfigPusher: failed to send config %s to machine %s: %v", msg.GetVersion(), machineID, err)
+	}
+}
+
+// PushAll pushes their config to every connected machine whose agent has
+// not applied it.
+func (p *ConfigPusher) PushAll(ctx context.Context) {
+	for _, id := range p.connMgr.ConnectedMachineIDs() {
+		p.Push(ctx, id, false)
+	}
+}
+
+// PushMachines pushes their config to those of machineIDs that are
+// connected and have not applied it, e.g. after their groups changed.
+func (p *ConfigPusher) PushMachines(ctx context.Context, machineIDs []primitive.ObjectID) {
+	for _, id := range machineIDs {
+		p.Push(ctx, id.Hex(), false)
+	}
+}
+
+// PushGroup pushes their config to the connected machines of a group, e.g.
+// after the group's config changed.
+func (p *ConfigPusher) PushGroup(ctx context.Context, groupID primitive.ObjectID) {
+	ids, err := p.machineRepo.IDsInGroup(ctx, groupID)
+	if err != nil {
+		log.Printf("ConfigPusher: failed to list machines of group %s: %v", groupID.Hex(), err)
+		return
+	}
+	p.PushMachines(ctx, ids)
+}
+
+// Run calls PushAll every configResyncInterval until ctx is cancelled.
+// Call from a goroutine.
+func (p *ConfigPusher) Run(ctx context.Context) {
+	ticker := time.NewTicker(configResyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.PushAll(ctx)
+		}
+	}
+}
+
+// HandleMessage records a ConfigApplied from an agent.
+func (p *ConfigPusher) HandleMessage(machineID string, msg *pb.AgentMessage) *pb.ServerMessage {
+	ack := msg.GetConfigApplied()
+	if ack == nil {
+		return nil
+	}
+	id, err := primitive.ObjectIDFromHex(machineID)
+	if err != nil {
+		return nil
+	}
+	if ack.GetError() != "" {
+		log.Printf("ConfigPusher: machine %s applied config %s with errors: %s", machineID, ack.GetVersion(), ack.GetError())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), configSendTimeout)
+	defer cancel()
+	err = p.machineRepo.SetAppliedConfig(ctx, id, &models.AppliedConfig{
+		Version:   ack.GetVersion(),
+		AppliedAt: time.Now().UTC(),
+		Error:     ack.GetError(),
+	})
+	if err != nil {
+		log.Printf("ConfigPusher: failed to record config %s of machine %s: %v", ack.GetVersion(), machineID, err)
+	}
+	return nil
+}
+
+// mergeSettings combines configs in order; set fields of later configs
+// override earlier ones, extra settings are merged by key.
+func mergeSettings(configs []*models.MachineConfig) models.AgentSettings {
+	var out models.AgentSettings
+	for _, c := range configs {
+		s := c.AgentSettings
+		if s.HeartbeatInterval != 0 {
+			out.HeartbeatInterval = s.HeartbeatInterval
+		}
+		if s.LogLevel != "" {
+			out.LogLevel = s.LogLevel
+		}
+		if len(s.Collectors) > 0 {
+			out.Collectors = s.Collectors
+		}
+		if s.OfflineSampleInterval != 0 {
+			out.OfflineSampleInterval = s.OfflineSampleInterval
+		}
+		if s.InventoryInterval != 0 {
+			out.InventoryInterval = s.InventoryInterval
+		}
+		if s.UnitsInterval != 0 {
+			out.UnitsInterval = s.UnitsInterval
+		}
+		for k, v := range s.Extra {
+			if out.Extra == nil {
+				out.Extra = make(map[string]string)
+			}
+			out.Extra[k] = v
+		}
+	}
+	return out
+}
+
+// agentConfig converts settings to the message pushed to the agent. Its
+// version is a hash of the content, so agents with equal settings report
+// equal versions and a config changed back to earlier settings is not
+// pushed again to agents still running them.
+func agentConfig(s models.AgentSettings) *pb.AgentConfig {
+	msg := &pb.AgentConfig{
+		LogLevel:             s.LogLevel,
+		Collectors:           s.Collectors,
+		OfflineSampleSeconds: uint32(s.OfflineSampleInterval),
+		InventorySeconds:     uint32(s.InventoryInterval),
+		UnitsSeconds:         uint32(s.UnitsInterval),
+		Extra:                s.Extra,
+	}
+	data, _ := proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+	sum := sha256.Sum256(data)
+	msg.Version = hex.EncodeToString(sum[:8])
+	return msg
+}
@@ -0,0 +1,290 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/lute/api/audit"
+	"github.com/lute/api/authz"
+	"github.com/lute/api/models"
+	"github.com/lute/api/repository"
+)
+
+var (
+	ErrInvalidConfig  = errors.New("invalid config")
+	ErrConfigNotFound = errors.New("config not found")
+)
+
+var (
+	// AgentLogLevels are the log levels an agent accepts.
+	AgentLogLevels = []string{"debug", "info", "warn", "error"}
+	// AgentCollectors are the metrics collectors an agent can run.
+	AgentCollectors = []string{"cpu", "memory", "disk"}
+)
+
+// Bounds of the interval settings, in seconds.
+const (
+	minHeartbeatInterval     = 10
+	maxHeartbeatInterval     = 3600
+	minOfflineSampleInterval = 5
+	maxOfflineSampleInterval = 3600
+	minInventoryInterval     = 60
+	maxInventoryInterval     = 7 * 24 * 3600
+	minUnitsInterval         = 5
+	maxUnitsInterval         = 3600
+	maxExtraSettings         = 32
+	maxExtraKeyLen           = 64
+	maxExtraValueLen         = 1024
+)
+
+// ConfigService manages the configs of machines and groups. Every change
+// is pushed to the connected agents concerned through the ConfigPusher.
+type ConfigService struct {
+	configRepo *repository.MachineConfigRepository
+	machines   *MachineService
+	groups     *GroupService
+	pusher     *ConfigPusher
+	audit      *audit.Recorder
+}
+
+func NewConfigService(
+	configRepo *repository.MachineConfigRepository,
+	machines *MachineService,
+	groups *GroupService,
+	pusher *ConfigPusher,
+	recorder *audit.Recorder,
+) *ConfigService {
+	return &ConfigService{
+		configRepo: configRepo,
+		machines:   machines,
+		groups:     groups,
+		pusher:     pusher,
+		audit:      recorder,
+	}
+}
+
+// MachineConfigView is a machine's configuration: its own config, the
+// settings in effect once its groups' configs are added, and whether the
+// agent has applied them.
+type MachineConfigView struct {
+	Config    *models.MachineConfig `json:"config"` // the machine's own; null when it has none
+	Effective models.AgentSettings  `json:"effective"`
+	Version   string                `json:"version"` // of the effective settings, as pushed to the agent
+	Applied   *models.AppliedConfig `json:"applied,omitempty"`
+	InSync    bool                  `json:"in_sync"` // the agent acknowledged Version
+}
+
+// GetMachine returns the configuration of a machine.
+func (s *ConfigService) GetMachine(ctx context.Context, userID, machineID primitive.ObjectID) (*MachineConfigView, error) {
+	m, err := s.machines.GetForUser(ctx, machineID, userID, authz.ActionMachineRead)
+	if err != nil {
+		return nil, err
+	}
+	return s.view(ctx, m)
+}
+
+// SetMachine replaces the machine's own config and pushes the result to
+// its agent.
+func (s *ConfigService) SetMachine(ctx context.Context, userID, machineID primitive.ObjectID, settings models.AgentSettings) (*MachineConfigView, error) {
+	m, err := s.machines.GetForUser(ctx, machineID, userID, authz.ActionMachineWrite)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateSettings(&settings); err != nil {
+		return nil, err
+	}
+	previous, err := s.configRepo.GetByMachine(ctx, machineID)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	cfg := &models.MachineConfig{MachineID: machineID, AgentSettings: settings, UpdatedBy: userID}
+	if err := s.configRepo.Save(ctx, cfg); err != nil {
+		return nil, err
+	}
+	s.audit.Record(ctx, audit.Entry{
+		Action:  audit.ActionConfigSet,
+		Target:  audit.MachineTarget(m),
+		Changes: audit.Diff(settingsDetails(previous), settingsDetails(cfg)),
+	})
+	go s.pusher.Push(context.Background(), machineID.Hex(), false)
+	return s.view(ctx, m)
+}
+
+// DeleteMachine removes the machine's own config; its groups' configs and
+// the agent's local settings apply again.
+func (s *ConfigService) DeleteMachine(ctx context.Context, userID, machineID primitive.ObjectID) error {
+	m, err := s.machines.GetForUser(ctx, machineID, userID, authz.ActionMachineWrite)
+	if err != nil {
+		return err
+	}
+	previous, err := s.configRepo.GetByMachine(ctx, machineID)
+	if err == mongo.ErrNoDocuments {
+		return ErrConfigNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := s.configRepo.DeleteByMachine(ctx, machineID); err != nil {
+		return err
+	}
+	s.audit.Record(ctx, audit.Entry{
+		Action:  audit.ActionConfigDelete,
+		Target:  audit.MachineTarget(m),
+		Details: settingsDetails(previous),
+	})
+	go s.pusher.Push(context.Background(), machineID.Hex(), false)
+	return nil
+}
+
+// GetGroup returns the config of a group.
+func (s *ConfigService) GetGroup(ctx context.Context, userID, groupID primitive.ObjectID) (*models.MachineConfig, error) {
+	if _, err := s.groups.Get(ctx, groupID, userID); err != nil {
+		return nil, err
+	}
+	cfg, err := s.configRepo.GetByGroup(ctx, groupID)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrConfigNotFound
+	}
+	return cfg, err
+}
+
+// SetGroup replaces the config of a group and pushes the result to the
+// connected agents of its machines.
+func (s *ConfigService) SetGroup(ctx context.Context, userID, groupID primitive.ObjectID, settings models.AgentSettings) (*models.MachineConfig, error) {
+	group, err := s.groups.GetForUser(ctx, groupID, userID, authz.ActionMachineWrite)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateSettings(&settings); err != nil {
+		return nil, err
+	}
+	previous, err := s.configRepo.GetByGroup(ctx, groupID)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	cfg := &models.MachineConfig{GroupID: groupID, AgentSettings: settings, UpdatedBy: userID}
+	if err := s.configRepo.Save(ctx, cfg); err != nil {
+		return nil, err
+	}
+	s.audit.Record(ctx, audit.Entry{
+		Action:  audit.ActionConfigSet,
+		Target:  audit.GroupTarget(group),
+		Changes: audit.Diff(settingsDetails(previous), settingsDetails(cfg)),
+	})
+	go s.pusher.PushGroup(context.Background(), groupID)
+	return cfg, nil
+}
+
+// DeleteGroup removes the config of a group.
+func (s *ConfigService) DeleteGroup(ctx context.Context, userID, groupID primitive.ObjectID) error {
+	group, err := s.groups.GetForUser(ctx, groupID, userID, authz.ActionMachineWrite)
+	if err != nil {
+		return err
+	}
+	previous, err := s.configRepo.GetByGroup(ctx, groupID)
+	if err == mongo.ErrNoDocuments {
+		return ErrConfigNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := s.configRepo.DeleteByGroup(ctx, groupID); err != nil {
+		return err
+	}
+	s.audit.Record(ctx, audit.Entry{
+		Action:  audit.ActionConfigDelete,
+		Target:  audit.GroupTarget(group),
+		Details: settingsDetails(previous),
+	})
+	go s.pusher.PushGroup(context.Background(), groupID)
+	return nil
+}
+
+func (s *ConfigService) view(ctx context.Context, m *models.Machine) (*MachineConfigView, error) {
+	own, err := s.configRepo.GetByMachine(ctx, m.ID)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	effective, err := s.pusher.Effective(ctx, m)
+	if err != nil {
+		return nil, err
+	}
+	v := &MachineConfigView{
+		Config:    own,
+		Effective: effective,
+		Version:   agentConfig(effective).GetVersion(),
+		Applied:   m.AppliedConfig,
+	}
+	v.InSync = v.Applied != nil && v.Applied.Version == v.Version
+	return v, nil
+}
+
+// validateSettings checks settings and normalizes the log level and
+// collectors. Zero values are unset and always valid.
+func validateSettings(s *models.AgentSettings) error {
+	for _, b := range []struct {
+		name     string
+		value    int
+		min, max int
+	}{
+		{"heartbeat_interval", s.HeartbeatInterval, minHeartbeatInterval, maxHeartbeatInterval},
+		{"offline_sample_interval", s.OfflineSampleInterval, minOfflineSampleInterval, maxOfflineSampleInterval},
+		{"inventory_interval", s.InventoryInterval, minInventoryInterval, maxInventoryInterval},
+		{"units_interval", s.UnitsInterval, minUnitsInterval, maxUnitsInterval},
+	} {
+		if b.value != 0 && (b.value < b.min || b.value > b.max) {
+			return fmt.Errorf("%w: %s must be between %d and %d seconds", ErrInvalidConfig, b.name, b.min, b.max)
+		}
+	}
+	s.LogLevel = strings.ToLower(strings.TrimSpace(s.LogLevel))
+	if s.LogLevel != "" && !slices.Contains(AgentLogLevels, s.LogLevel) {
+		return fmt.Errorf("%w: log_level must be one of %s", ErrInvalidConfig, strings.Join(AgentLogLevels, ", "))
+	}
+	var collectors []string
+	for _, name := range s.Collectors {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !slices.Contains(AgentCollectors, name) {
+			return fmt.Errorf("%w: unknown collector %q (valid: %s)", ErrInvalidConfig, name, strings.Join(AgentCollectors, ", "))
+		}
+		if !slices.Contains(collectors, name) {
+			collectors = append(collectors, name)
+		}
+	}
+	s.Collectors = collectors
+	if len(s.Extra) > maxExtraSettings {
+		return fmt.Errorf("%w: at most %d extra settings", ErrInvalidConfig, maxExtraSettings)
+	}
+	for k, v := range s.Extra {
+		if k == "" || len(k) > maxExtraKeyLen || len(v) > maxExtraValueLen {
+			return fmt.Errorf("%w: extra setting %q: keys must have 1 to %d characters, values at most %d", ErrInvalidConfig, k, maxExtraKeyLen, maxExtraValueLen)
+		}
+	}
+	if len(s.Extra) == 0 {
+		s.Extra = nil
+	}
+	return nil
+}
+
+// settingsDetails returns the settings of a config for the audit log; nil
+// for no config.
+func settingsDetails(cfg *models.MachineConfig) map[string]interface{} {
+	if cfg == nil {
+		return nil
+	}
+	s := cfg.AgentSettings
+	return map[string]interface{}{
+		"heartbeat_interval":      s.HeartbeatInterval,
+		"log_level":               s.LogLevel,
+		"collectors":              s.Collectors,
+		"offline_sample_interval": s.OfflineSampleInterval,
+		"inventory_interval":      s.InventoryInterval,
+		"units_interval":          s.UnitsInterval,
+		"extra":                   s.Extra,
+	}
+}
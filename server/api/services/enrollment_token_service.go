@@ -0,0 +1,267 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/lute/api/audit"
+	"github.com/lute/api/authz"
+	"github.com/lute/api/labels"
+	"github.com/lute/api/models"
+	"github.com/lute/api/repository"
+	"github.com/lute/api/tokens"
+)
+
+// EnrollmentTokenPrefix marks reusable enrollment tokens.
+const EnrollmentTokenPrefix = "lute_enroll_"
+
+const (
+	claimCodeLen    = 20
+	claimCodeExpiry = 15 * time.Minute
+	claimCodeChars  = "0123456789ABCDEFGHJKLMNPQRSTUVWXYZ" // no I,O to avoid confusion
+)
+
+var (
+	ErrEnrollmentNotFound     = errors.New("enrollment token not found")
+	ErrEnrollmentInvalid      = errors.New("invalid, expired, revoked or used-up enrollment token")
+	ErrEnrollmentNameRequired = errors.New("enrollment token name is required")
+	ErrEnrollmentBadMaxUses   = errors.New("max_uses must not be negative")
+	ErrEnrollmentBadExpiry    = errors.New("expiry must be in the future")
+	ErrEnrollmentGroupOrg     = errors.New("group must belong to the token's organization")
+)
+
+// CreateEnrollmentTokenInput describes a reusable enrollment token to create.
+type CreateEnrollmentTokenInput struct {
+	Name      string
+	OrgID     primitive.ObjectID // zero registers machines as the creator's personal machines
+	Labels    map[string]string  // default labels; labels declared by the agent win
+	GroupID   primitive.ObjectID // zero for none; a group of OrgID, or one of the creator's personal groups
+	MaxUses   int                // 0 for unlimited
+	ExpiresAt *time.Time         // nil for a token that never expires
+}
+
+// EnrollmentTokenService issues, lists, revokes and consumes enrollment tokens,
+// including the single-use claim codes of the Add Machine dialog.
+type EnrollmentTokenService struct {
+	tokenRepo *repository.EnrollmentTokenRepository
+	groupRepo *repository.MachineGroupRepository
+	authz     *authz.Authorizer
+	audit     *audit.Recorder
+}
+
+func NewEnrollmentTokenService(tokenRepo *repository.EnrollmentTokenRepository, groupRepo *repository.MachineGroupRepository, authorizer *authz.Authorizer, recorder *audit.Recorder) *EnrollmentTokenService {
+	return &EnrollmentTokenService{
+		tokenRepo: tokenRepo,
+		groupRepo: groupRepo,
+		authz:     authorizer,
+		audit:     recorder,
+	}
+}
+
+// Create issues a reusable enrollment token and returns it with its plaintext
+// value, which is shown once and never stored.
+func (s *EnrollmentTokenService) Create(ctx context.Context, userID primitive.ObjectID, in CreateEnrollmentTokenInput) (*models.EnrollmentToken, string, error) {
+	name := strings.TrimSpace(in.Name)
+	if name == "" {
+		return nil, "", ErrEnrollmentNameRequired
+	}
+	if in.MaxUses < 0 {
+		return nil, "", ErrEnrollmentBadMaxUses
+	}
+	if in.ExpiresAt != nil && !in.ExpiresAt.After(time.Now()) {
+		return nil, "", ErrEnrollmentBadExpiry
+	}
+	if err := labels.Validate(in.Labels); err != nil {
+		return nil, "", err
+	}
+	if err := s.authorizeCreate(ctx, userID, in.OrgID); err != nil {
+		return nil, "", err
+	}
+	if !in.GroupID.IsZero() {
+		group, err := s.groupRepo.GetByID(ctx, in.GroupID)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				return nil, "", ErrGroupNotFound
+			}
+			return nil, "", err
+		}
+		if group.OrgID != in.OrgID {
+			return nil, "", ErrEnrollmentGroupOrg
+		}
+		if err := s.authz.AuthorizeGroup(ctx, userID, group, authz.ActionMachineRead); err != nil {
+			return nil, "", err
+		}
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, "", err
+	}
+	plaintext := EnrollmentTokenPrefix + hex.EncodeToString(b)
+	token := &models.EnrollmentToken{
+		Kind:      models.EnrollmentKindToken,
+		UserID:    userID,
+		OrgID:     in.OrgID,
+		Name:      name,
+		Prefix:    plaintext[:len(EnrollmentTokenPrefix)+8],
+		TokenHash: tokens.Hash(plaintext),
+		Labels:    in.Labels,
+		GroupID:   in.GroupID,
+		MaxUses:   in.MaxUses,
+		ExpiresAt: in.ExpiresAt,
+	}
+	if err := s.tokenRepo.Create(ctx, token); err != nil {
+		return nil, "", err
+	}
+	s.audit.Record(ctx, audit.Entry{
+		Action: audit.ActionEnrollmentCreate,
+		Target: audit.EnrollmentTokenTarget(token),
+		Details: map[string]interface{}{
+			"prefix":     token.Prefix,
+			"max_uses":   token.MaxUses,
+			"expires_at": token.ExpiresAt,
+			"labels":     token.Labels,
+			"group_id":   idOrNil(token.GroupID),
+		},
+	})
+	return token, plaintext, nil
+}
+
+// CreateClaimCode issues a short single-use code that expires after 15 minutes.
+func (s *EnrollmentTokenService) CreateClaimCode(ctx context.Context, userID, orgID primitive.ObjectID) (string, time.Time, error) {
+	if err := s.authorizeCreate(ctx, userID, orgID); err != nil {
+		return "", time.Time{}, err
+	}
+	code, err := newClaimCode()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expiresAt := time.Now().Add(claimCodeExpiry)
+	token := &models.EnrollmentToken{
+		Kind:      models.EnrollmentKindClaimCode,
+		UserID:    userID,
+		OrgID:     orgID,
+		Name:      "Claim code",
+		Prefix:    code[:4],
+		TokenHash: tokens.Hash(code),
+		MaxUses:   1,
+		ExpiresAt: &expiresAt,
+	}
+	if err := s.tokenRepo.Create(ctx, token); err != nil {
+		return "", time.Time{}, err
+	}
+	// Never record the code itself; it is a bearer credential until used
+	s.audit.Record(ctx, audit.Entry{
+		Action:  audit.ActionClaimCodeCreate,
+		Target:  audit.EnrollmentTokenTarget(token),
+		Details: map[string]interface{}{"expires_at": expiresAt},
+	})
+	return code, expiresAt, nil
+}
+
+// List returns the reusable tokens the user may manage: their personal ones
+// and those of orgs where they may create machines. Claim codes are omitted.
+func (s *EnrollmentTokenService) List(ctx context.Context, userID primitive.ObjectID) ([]*models.EnrollmentToken, error) {
+	if err := authz.CheckScope(ctx, authz.ActionMachineCreate); err != nil {
+		return nil, err
+	}
+	orgIDs, err := s.authz.OrgIDsAllowing(ctx, userID, authz.ActionMachineCreate)
+	if err != nil {
+		return nil, err
+	}
+	return s.tokenRepo.GetVisible(ctx, userID, orgIDs, models.EnrollmentKindToken)
+}
+
+// Revoke revokes a token. Machines already registered with it are not affected.
+func (s *EnrollmentTokenService) Revoke(ctx context.Context, userID, tokenID primitive.ObjectID) error {
+	token, err := s.tokenRepo.GetByID(ctx, tokenID)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return ErrEnrollmentNotFound
+		}
+		return err
+	}
+	if token.OrgID.IsZero() && token.UserID != userID {
+		return ErrEnrollmentNotFound
+	}
+	if err := s.authorizeCreate(ctx, userID, token.OrgID); err != nil {
+		return err
+	}
+	if err := s.tokenRepo.Revoke(ctx, tokenID); err != nil {
+		return err
+	}
+	s.audit.Record(ctx, audit.Entry{
+		Action:  audit.ActionEnrollmentRevoke,
+		Target:  audit.EnrollmentTokenTarget(token),
+		Details: map[string]interface{}{"prefix": token.Prefix, "uses": token.Uses},
+	})
+	return nil
+}
+
+// Consume takes one use of a token or claim code for an agent registration.
+// The creator must still be allowed to create machines in the token's org.
+// Call Release if the registration fails afterwards.
+func (s *EnrollmentTokenService) Consume(ctx context.Context, plaintext string) (*models.EnrollmentToken, error) {
+	if plaintext == "" {
+		return nil, ErrEnrollmentInvalid
+	}
+	token, err := s.tokenRepo.Consume(ctx, tokens.Hash(plaintext), time.Now())
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrEnrollmentInvalid
+		}
+		return nil, err
+	}
+	if !token.OrgID.IsZero() {
+		role, err := s.authz.OrgRole(ctx, token.UserID, token.OrgID)
+		if err != nil {
+			s.Release(ctx, token)
+			return nil, err
+		}
+		if !authz.Allows(role, authz.ActionMachineCreate) {
+			s.Release(ctx, token)
+			return nil, ErrEnrollmentInvalid
+		}
+	}
+	return token, nil
+}
+
+// Release returns a use taken by Consume.
+func (s *EnrollmentTokenService) Release(ctx context.Context, token *models.EnrollmentToken) {
+	_ = s.tokenRepo.Release(ctx, token.ID)
+}
+
+func (s *EnrollmentTokenService) authorizeCreate(ctx context.Context, userID, orgID primitive.ObjectID) error {
+	if orgID.IsZero() {
+		return s.authz.AuthorizePersonal(ctx, authz.ActionMachineCreate)
+	}
+	_, err := s.authz.AuthorizeOrg(ctx, userID, orgID, authz.ActionMachineCreate)
+	return err
+}
+
+// newClaimCode returns claimCodeLen characters drawn uniformly from
+// claimCodeChars. Random bytes at or above the largest multiple of the
+// alphabet size are discarded, so that no character is more likely.
+func newClaimCode() (string, error) {
+	limit := 256 - 256%len(claimCodeChars)
+	code := make([]byte, 0, claimCodeLen)
+	b := make([]byte, claimCodeLen)
+	for len(code) < claimCodeLen {
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		for _, c := range b {
+			if int(c) < limit && len(code) < claimCodeLen {
+				code = append(code, claimCodeChars[int(c)%len(claimCodeChars)])
+			}
+		}
+	}
+	return string(code), nil
+}
@@ -0,0 +1,163 @@
+package services
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/lute/api/audit"
+	"github.com/lute/api/authz"
+	"github.com/lute/api/models"
+	"github.com/lute/api/repository"
+)
+
+// FileEventLimit caps the events returned by one query.
+const FileEventLimit = 500
+
+var ErrFileEventNotFound = errors.New("file event not found")
+
+// FileEventService answers questions about the file events agents reported
+// (see FileIntegrityRecorder) and records their acknowledgement.
+type FileEventService struct {
+	eventRepo *repository.FileEventRepository
+	machines  *MachineService
+	audit     *audit.Recorder
+}
+
+func NewFileEventService(eventRepo *repository.FileEventRepository, machines *MachineService, auditRecorder *audit.Recorder) *FileEventService {
+	return &FileEventService{
+		eventRepo: eventRepo,
+		machines:  machines,
+		audit:     auditRecorder,
+	}
+}
+
+// FileEventQuery narrows a list of file events.
+type FileEventQuery struct {
+	Acknowledged *bool  // nil for both
+	Path         string // exact path
+	Limit        int    // default and maximum FileEventLimit
+}
+
+func (q FileEventQuery) limit() int64 {
+	if q.Limit <= 0 || q.Limit > FileEventLimit {
+		return FileEventLimit
+	}
+	return int64(q.Limit)
+}
+
+// FleetFileEvent is a file event with the name of its machine.
+type FleetFileEvent struct {
+	*models.FileEvent
+	MachineName string `json:"machine_name"`
+}
+
+// ForMachine returns the file events of a machine the user may read, newest
+// first.
+func (s *FileEventService) ForMachine(ctx context.Context, machineID, userID primitive.ObjectID, q FileEventQuery) ([]*models.FileEvent, error) {
+	if _, err := s.machines.GetForUser(ctx, machineID, userID, authz.ActionMachineRead); err != nil {
+		return nil, err
+	}
+	events, err := s.eventRepo.List(ctx, repository.FileEventFilter{
+		MachineIDs:   []primitive.ObjectID{machineID},
+		Acknowledged: q.Acknowledged,
+		Path:         q.Path,
+	}, q.limit())
+	if err != nil {
+		return nil, err
+	}
+	if events == nil {
+		events = []*models.FileEvent{}
+	}
+	return events, nil
+}
+
+// Fleet returns the file events of the machines visible to the user that
+// match filter, newest first.
+func (s *FileEventService) Fleet(ctx context.Context, userID primitive.ObjectID, filter MachineFilter, q FileEventQuery) ([]FleetFileEvent, error) {
+	machines, err := s.machines.GetByUserIDFiltered(ctx, userID, filter)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]primitive.ObjectID, 0, len(machines))
+	names := make(map[primitive.ObjectID]string, len(machines))
+	for _, m := range machines {
+		ids = append(ids, m.ID)
+		names[m.ID] = m.Name
+	}
+	events, err := s.eventRepo.List(ctx, repository.FileEventFilter{
+		MachineIDs:   ids,
+		Acknowledged: q.Acknowledged,
+		Path:         q.Path,
+	}, q.limit())
+	if err != nil {
+		return nil, err
+	}
+	out := make([]FleetFileEvent, 0, len(events))
+	for _, e := range events {
+		out = append(out, FleetFileEvent{FileEvent: e, MachineName: names[e.MachineID]})
+	}
+	return out, nil
+}
+
+// Acknowledge marks an event as expected. The user must be allowed to
+// operate its machine.
+func (s *FileEventService) Acknowledge(ctx context.Context, id, userID primitive.ObjectID, note string) (*models.FileEvent, error) {
+	event, err := s.eventRepo.GetByID(ctx, id)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrFileEventNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	machine, err := s.machines.GetForUser(ctx, event.MachineID, userID, authz.ActionMachineOperate)
+	if err != nil {
+		if errors.Is(err, authz.ErrForbidden) {
+			return nil, err
+		}
+		return nil, ErrFileEventNotFound
+	}
+	acked, err := s.eventRepo.AcknowledgeByID(ctx, id, userID, note)
+	if err != nil {
+		return nil, err
+	}
+	if acked {
+		s.audit.Record(ctx, audit.Entry{
+			Action:  audit.ActionFileEventAck,
+			Target:  audit.MachineTarget(machine),
+			Details: map[string]interface{}{"event_id": id.Hex(), "path": event.Path, "change": event.Change, "note": note},
+		})
+	}
+	return s.eventRepo.GetByID(ctx, id)
+}
+
+// AcknowledgeMachine marks all unacknowledged events of a machine the user
+// may operate as expected, optionally only those of one path, and returns
+// how many there were.
+func (s *FileEventService) AcknowledgeMachine(ctx context.Context, machineID, userID primitive.ObjectID, path, note string) (int64, error) {
+	machine, err := s.machines.GetForUser(ctx, machineID, userID, authz.ActionMachineOperate)
+	if err != nil {
+		return 0, err
+	}
+	count, err := s.eventRepo.Acknowledge(ctx, repository.FileEventFilter{
+		MachineIDs: []primitive.ObjectID{machineID},
+		Path:       path,
+	}, userID, note)
+	if err != nil {
+		return 0, err
+	}
+	if count > 0 {
+		details := map[string]interface{}{"count": count, "note": note}
+		if path != "" {
+			details["path"] = path
+		}
+		s.audit.Record(ctx, audit.Entry{
+			Action:  audit.ActionFileEventAck,
+			Target:  audit.MachineTarget(machine),
+			Details: details,
+		})
+	}
+	return count, nil
+}
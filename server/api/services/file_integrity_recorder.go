@@ -0,0 +1,129 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	pb "github.com/lute/agent/proto/agent"
+	"github.com/lute/api/models"
+	"github.com/lute/api/repository"
+)
+
+const fileEventWriteTimeout = 30 * time.Second
+
+// FileIntegrityRecorder stores the file events agents report for the paths
+// they monitor.
+type FileIntegrityRecorder struct {
+	eventRepo *repository.FileEventRepository
+}
+
+func NewFileIntegrityRecorder(eventRepo *repository.FileEventRepository) *FileIntegrityRecorder {
+	return &FileIntegrityRecorder{eventRepo: eventRepo}
+}
+
+// HandleMessage stores FileEvents and answers with a FileEventsAck. No ack
+// is sent when storing fails, so the agent sends the events again.
+func (r *FileIntegrityRecorder) HandleMessage(machineID string, msg *pb.AgentMessage) *pb.ServerMessage {
+	batch := msg.GetFileEvents()
+	if batch == nil {
+		return nil
+	}
+	mid, err := primitive.ObjectIDFromHex(machineID)
+	if err != nil {
+		return nil
+	}
+	now := time.Now().UTC()
+	var lastSeq uint64
+	events := make([]*models.FileEvent, 0, len(batch.GetEvents()))
+	for _, e := range batch.GetEvents() {
+		lastSeq = max(lastSeq, e.GetSeq())
+		change := fileChangeNames[e.GetChange()]
+		if change == "" {
+			continue // unreadable on the agent; acknowledged and dropped
+		}
+		events = append(events, &models.FileEvent{
+			MachineID:  mid,
+			Seq:        e.GetSeq(),
+			Change:     change,
+			Path:       e.GetPath(),
+			Before:     fileStateFromProto(e.GetBefore()),
+			After:      fileStateFromProto(e.GetAfter()),
+			Realtime:   e.GetRealtime(),
+			DetectedAt: time.Unix(e.GetDetectedAt(), 0).UTC(),
+			ReceivedAt: now,
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), fileEventWriteTimeout)
+	defer cancel()
+	if err := r.eventRepo.InsertBatch(ctx, events); err != nil {
+		log.Printf("FileIntegrity: failed to store file events of machine %s: %v", machineID, err)
+		return nil
+	}
+	if len(events) > 0 {
+		log.Printf("FileIntegrity: stored %d file events from machine %s", len(events), machineID)
+	}
+	return &pb.ServerMessage{
+		Payload: &pb.ServerMessage_FileEventsAck{
+			FileEventsAck: &pb.FileEventsAck{LastSeq: lastSeq},
+		},
+	}
+}
+
+var fileChangeNames = map[pb.FileChange]string{
+	pb.FileChange_FILE_CHANGE_ADDED:    models.FileChangeAdded,
+	pb.FileChange_FILE_CHANGE_REMOVED:  models.FileChangeRemoved,
+	pb.FileChange_FILE_CHANGE_MODIFIED: models.FileChangeModified,
+}
+
+func fileStateFromProto(s *pb.FileState) *models.FileState {
+	if s == nil {
+		return nil
+	}
+	return &models.FileState{
+		SHA256:     s.GetSha256(),
+		Mode:       s.GetMode(),
+		Permission: permissionString(s.GetMode()),
+		UID:        s.GetUid(),
+		GID:        s.GetGid(),
+		Owner:      s.GetOwner(),
+		Group:      s.GetGroup(),
+		Size:       s.GetSize(),
+		MTime:      s.GetMtime(),
+		LinkTarget: s.GetLinkTarget(),
+	}
+}
+
+// permissionString formats a stat(2) mode the way ls -l does.
+func permissionString(mode uint32) string {
+	b := []byte("----------")
+	switch mode & 0o170000 {
+	case 0o040000:
+		b[0] = 'd'
+	case 0o120000:
+		b[0] = 'l'
+	}
+	const rwx = "rwx"
+	for i := 0; i < 9; i++ {
+		if mode&(1<<uint(8-i)) != 0 {
+			b[i+1] = rwx[i%3]
+		}
+	}
+	special := func(bit uint32, pos int, set, setNoExec byte) {
+		if mode&bit == 0 {
+			return
+		}
+		if b[pos] == 'x' {
+			b[pos] = set
+		} else {
+			b[pos] = setNoExec
+		}
+	}
+	special(0o4000, 3, 's', 'S')
+	special(0o2000, 6, 's', 'S')
+	special(0o1000, 9, 't', 'T')
+	return string(b)
+}
@@ -0,0 +1,314 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	pb "github.com/lute/agent/proto/agent"
+	luteGrpc "github.com/lute/api/grpc"
+	"github.com/lute/api/models"
+	"github.com/lute/api/repository"
+)
+
+const (
+	fileSendTimeout    = 5 * time.Second
+	fileMessageTimeout = 30 * time.Second
+	// FileChunkSize is the size of the chunks files are stored and sent in.
+	FileChunkSize = 256 << 10
+	// FileTransferQueueTTL is how long a transfer waits for its machine's
+	// agent to connect before it fails.
+	FileTransferQueueTTL = time.Hour
+)
+
+// FileTransferDispatcher copies files to and from connected agents, one
+// chunk per message in either direction (see pb.FileTransfer). Transfers for
+// an offline agent start when it connects; interrupted transfers resume from
+// what the receiving side already holds.
+type FileTransferDispatcher struct {
+	transferRepo *repository.FileTransferRepository
+	chunkRepo    *repository.FileChunkRepository
+	connMgr      *luteGrpc.ConnectionManager
+	maxSize      int64
+}
+
+func NewFileTransferDispatcher(transferRepo *repository.FileTransferRepository, chunkRepo *repository.FileChunkRepository, connMgr *luteGrpc.ConnectionManager, maxSize int64) *FileTransferDispatcher {
+	return &FileTransferDispatcher{
+		transferRepo: transferRepo,
+		chunkRepo:    chunkRepo,
+		connMgr:      connMgr,
+		maxSize:      maxSize,
+	}
+}
+
+// Dispatch starts t if its machine is connected.
+func (d *FileTransferDispatcher) Dispatch(ctx context.Context, t *models.FileTransfer) {
+	conn := d.connMgr.Get(t.MachineID.Hex())
+	if conn == nil || !conn.Supports(pb.Feature_FEATURE_FILE_TRANSFER) {
+		return
+	}
+	d.send(ctx, conn, t)
+}
+
+// DeliverPending starts or resumes the open transfers of a machine that
+// just connected, failing those that waited longer than FileTransferQueueTTL.
+func (d *FileTransferDispatcher) DeliverPending(ctx context.Context, machineID string) {
+	conn := d.connMgr.Get(machineID)
+	if conn == nil || !conn.Supports(pb.Feature_FEATURE_FILE_TRANSFER) {
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(machineID)
+	if err != nil {
+		return
+	}
+	transfers, err := d.transferRepo.GetOpenByMachineID(ctx, id)
+	if err != nil {
+		log.Printf("Files: failed to load open transfers of machine %s: %v", machineID, err)
+		return
+	}
+	for _, t := range transfers {
+		if t.Status == models.FileTransferPending && time.Since(t.CreatedAt) > FileTransferQueueTTL {
+			d.fail(ctx, t, fmt.Sprintf("the agent did not connect within %s", FileTransferQueueTTL))
+			continue
+		}
+		d.send(ctx, conn, t)
+	}
+}
+
+func (d *FileTransferDispatcher) send(ctx context.Context, conn *luteGrpc.MachineConnection, t *models.FileTransfer) {
+	req := &pb.FileTransfer{
+		Id:        t.ID.Hex(),
+		Path:      t.Path,
+		ChunkSize: FileChunkSize,
+	}
+	if t.Direction == models.FileTransferUpload {
+		req.Direction = pb.FileDirection_FILE_DIRECTION_TO_AGENT
+		req.Size = t.Size
+		req.Sha256 = t.SHA256
+		req.Mode = t.Mode
+	} else {
+		req.Direction = pb.FileDirection_FILE_DIRECTION_FROM_AGENT
+		req.MaxSize = d.maxSize
+		req.Offset = t.Received
+	}
+	err := conn.Send(&pb.ServerMessage{
+		Payload: &pb.ServerMessage_FileTransfer{FileTransfer: req},
+	}, fileSendTimeout)
+	if err != nil {
+		log.Printf("Files: failed to start %s of %s on machine %s: %v", t.Direction, t.Path, t.MachineID.Hex(), err)
+		return
+	}
+	if t.Status == models.FileTransferPending {
+		if err := d.transferRepo.MarkRunning(ctx, t.ID); err != nil {
+			log.Printf("Files: failed to mark transfer %s as running: %v", t.ID.Hex(), err)
+		}
+	}
+}
+
+// HandleMessage advances a transfer by a FileChunk or FileTransferStatus
+// from the agent, replying with the next chunk or status.
+func (d *FileTransferDispatcher) HandleMessage(machineID string, msg *pb.AgentMessage) *pb.ServerMessage {
+	mid, err := primitive.ObjectIDFromHex(machineID)
+	if err != nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), fileMessageTimeout)
+	defer cancel()
+	switch {
+	case msg.GetFileChunk() != nil:
+		return d.receive(ctx, mid, msg.GetFileChunk())
+	case msg.GetFileTransferStatus() != nil:
+		return d.acknowledged(ctx, mid, msg.GetFileTransferStatus())
+	}
+	return nil
+}
+
+// acknowledged handles the agent's status of a transfer: the end of either
+// kind, or the offset of the next chunk of an upload.
+func (d *FileTransferDispatcher) acknowledged(ctx context.Context, machineID primitive.ObjectID, s *pb.FileTransferStatus) *pb.ServerMessage {
+	t, reply := d.open(ctx, machineID, s.GetId())
+	if t == nil {
+		if s.GetError() != "" || s.GetDone() {
+			return nil
+		}
+		return reply
+	}
+	if s.GetError() != "" {
+		d.fail(ctx, t, s.GetError())
+		return nil
+	}
+	if t.Direction != models.FileTransferUpload {
+		return nil
+	}
+	if err := d.transferRepo.SetProgress(ctx, t.ID, s.GetOffset(), t.Size); err != nil {
+		log.Printf("Files: failed to record progress of transfer %s: %v", t.ID.Hex(), err)
+	}
+	if s.GetDone() {
+		d.complete(ctx, t, t.SHA256)
+		return nil
+	}
+
+	offset := s.GetOffset()
+	if offset < 0 || offset > t.Size {
+		return d.fail(ctx, t, fmt.Sprintf("the agent asked for offset %d of a file of %d bytes", offset, t.Size))
+	}
+	var data []byte
+	if offset < t.Size {
+		chunk, err := d.chunkRepo.At(ctx, t.ID, offset)
+		if err != nil {
+			log.Printf("Files: failed to load transfer %s at offset %d: %v", t.ID.Hex(), offset, err)
+			return nil
+		}
+		if chunk == nil {
+			return d.fail(ctx, t, "the file's content is no longer kept")
+		}
+		data = chunk.Data[offset-chunk.Offset:]
+		if len(data) > FileChunkSize {
+			data = data[:FileChunkSize]
+		}
+	}
+	return &pb.ServerMessage{Payload: &pb.ServerMessage_FileChunk{FileChunk: &pb.FileChunk{
+		Id:     s.GetId(),
+		Offset: offset,
+		Data:   data,
+		Eof:    offset+int64(len(data)) == t.Size,
+	}}}
+}
+
+// receive stores a chunk of a download and acknowledges it. After the last
+// chunk the stored file is checked against the agent's checksum.
+func (d *FileTransferDispatcher) receive(ctx context.Context, machineID primitive.ObjectID, c *pb.FileChunk) *pb.ServerMessage {
+	t, reply := d.open(ctx, machineID, c.GetId())
+	if t == nil {
+		return reply
+	}
+	if t.Direction != models.FileTransferDownload {
+		return d.fail(ctx, t, "the agent sent data for an upload")
+	}
+	if c.GetSize() > d.maxSize {
+		return d.fail(ctx, t, fmt.Sprintf("file of %d bytes exceeds the limit of %d", c.GetSize(), d.maxSize))
+	}
+	if t.Received > 0 && c.GetSize() != t.Size {
+		// The file changed since the transfer was interrupted; start over.
+		if err := d.chunkRepo.DeleteByTransferID(ctx, t.ID); err != nil {
+			log.Printf("Files: failed to discard transfer %s: %v", t.ID.Hex(), err)
+			return nil
+		}
+		if err := d.transferRepo.SetProgress(ctx, t.ID, 0, c.GetSize()); err != nil {
+			log.Printf("Files: failed to record progress of transfer %s: %v", t.ID.Hex(), err)
+			return nil
+		}
+		return fileStatus(&pb.FileTransferStatus{Id: c.GetId()})
+	}
+	if c.GetOffset() != t.Received {
+		// A chunk resent after a reconnect; ask for what is missing.
+		return fileStatus(&pb.FileTransferStatus{Id: c.GetId(), Offset: t.Received})
+	}
+	received := c.GetOffset() + int64(len(c.GetData()))
+	if received > c.GetSize() {
+		return d.fail(ctx, t, "the agent sent more data than the file's size")
+	}
+	if err := d.chunkRepo.Put(ctx, t.ID, c.GetOffset(), c.GetData()); err != nil {
+		log.Printf("Files: failed to store transfer %s at offset %d: %v", t.ID.Hex(), c.GetOffset(), err)
+		return nil
+	}
+	if err := d.transferRepo.SetProgress(ctx, t.ID, received, c.GetSize()); err != nil {
+		log.Printf("Files: failed to record progress of transfer %s: %v", t.ID.Hex(), err)
+		return nil
+	}
+	if !c.GetEof() {
+		return fileStatus(&pb.FileTransferStatus{Id: c.GetId(), Offset: received})
+	}
+
+	if received != c.GetSize() {
+		return d.fail(ctx, t, fmt.Sprintf("received %d bytes, expected %d", received, c.GetSize()))
+	}
+	sum, err := d.checksum(ctx, t.ID, received)
+	if err != nil {
+		log.Printf("Files: failed to check transfer %s: %v", t.ID.Hex(), err)
+		return d.fail(ctx, t, "stored file is incomplete")
+	}
+	if !strings.EqualFold(sum, c.GetSha256()) {
+		return d.fail(ctx, t, fmt.Sprintf("checksum mismatch: got %s, expected %s", sum, c.GetSha256()))
+	}
+	d.complete(ctx, t, sum)
+	return fileStatus(&pb.FileTransferStatus{Id: c.GetId(), Offset: received, Done: true})
+}
+
+// open returns the open transfer id of a machine. Without one it returns
+// the status that ends the transfer on the agent.
+func (d *FileTransferDispatcher) open(ctx context.Context, machineID primitive.ObjectID, id string) (*models.FileTransfer, *pb.ServerMessage) {
+	unknown := fileStatus(&pb.FileTransferStatus{Id: id, Error: "unknown transfer"})
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, unknown
+	}
+	t, err := d.transferRepo.GetOpen(ctx, oid, machineID)
+	if err != nil {
+		log.Printf("Files: failed to load transfer %s: %v", id, err)
+		return nil, nil
+	}
+	if t == nil {
+		return nil, unknown
+	}
+	return t, nil
+}
+
+// checksum hashes the stored content of a transfer, checking that it is
+// size bytes without gaps.
+func (d *FileTransferDispatcher) checksum(ctx context.Context, id primitive.ObjectID, size int64) (string, error) {
+	h := sha256.New()
+	var n int64
+	err := d.chunkRepo.Each(ctx, id, func(c *models.FileChunk) error {
+		if c.Offset != n {
+			return fmt.Errorf("chunk at offset %d, expected %d", c.Offset, n)
+		}
+		h.Write(c.Data)
+		n += int64(len(c.Data))
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if n != size {
+		return "", errors.New("content is shorter than the file")
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (d *FileTransferDispatcher) complete(ctx context.Context, t *models.FileTransfer, sum string) {
+	recorded, err := d.transferRepo.Finish(ctx, t.ID, models.FileTransferCompleted, sum, "")
+	if err != nil {
+		log.Printf("Files: failed to complete transfer %s: %v", t.ID.Hex(), err)
+		return
+	}
+	if recorded {
+		log.Printf("Files: %s of %s on machine %s completed", t.Direction, t.Path, t.MachineID.Hex())
+	}
+}
+
+// fail records that a transfer failed and returns the status telling the
+// agent to stop. The content of a failed download is discarded.
+func (d *FileTransferDispatcher) fail(ctx context.Context, t *models.FileTransfer, reason string) *pb.ServerMessage {
+	if _, err := d.transferRepo.Finish(ctx, t.ID, models.FileTransferFailed, "", reason); err != nil {
+		log.Printf("Files: failed to record failure of transfer %s: %v", t.ID.Hex(), err)
+	}
+	if t.Direction == models.FileTransferDownload {
+		if err := d.chunkRepo.DeleteByTransferID(ctx, t.ID); err != nil {
+			log.Printf("Files: failed to discard transfer %s: %v", t.ID.Hex(), err)
+		}
+	}
+	log.Printf("Files: %s of %s on machine %s failed: %s", t.Direction, t.Path, t.MachineID.Hex(), reason)
+	return fileStatus(&pb.FileTransferStatus{Id: t.ID.Hex(), Error: reason})
+}
+
+func fileStatus(s *pb.FileTransferStatus) *pb.ServerMessage {
+	return &pb.ServerMessage{Payload: &pb.ServerMessage_FileTransferStatus{FileTransferStatus: s}}
+}
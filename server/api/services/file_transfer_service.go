@@ -0,0 +1,279 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	pb "github.com/lute/agent/proto/agent"
+	"github.com/lute/api/audit"
+	"github.com/lute/api/authz"
+	luteGrpc "github.com/lute/api/grpc"
+	"github.com/lute/api/models"
+	"github.com/lute/api/repository"
+)
+
+// FileContentRetention is how long the content of a transfer is kept (see
+// the TTL index on file_chunks).
+const FileContentRetention = 7 * 24 * time.Hour
+
+const fileTransferListLimit = 500
+
+var (
+	ErrFileTransferUnsupported = errors.New("the machine's agent does not support file transfer; update it")
+	ErrInvalidFilePath         = errors.New("path must be an absolute path on the machine")
+	ErrInvalidFileMode         = errors.New("mode must be octal permission bits such as 0644")
+	ErrFileTooLarge            = errors.New("file exceeds the size limit")
+	ErrFileTransferNotFound    = errors.New("file transfer not found")
+	ErrFileNotReady            = errors.New("the file transfer has not completed")
+	ErrFileContentExpired      = errors.New("the file's content is no longer kept")
+)
+
+var windowsAbsPath = regexp.MustCompile(`^[A-Za-z]:[\\/]`)
+
+// FileTransferService validates and authorizes copying files to and from
+// machines, keeps their content and hands them to the FileTransferDispatcher.
+// Copying in either direction requires the right to run commands on the
+// machine; the agent also only touches paths its policy allows.
+type FileTransferService struct {
+	transferRepo *repository.FileTransferRepository
+	chunkRepo    *repository.FileChunkRepository
+	machines     *MachineService
+	dispatcher   *FileTransferDispatcher
+	audit        *audit.Recorder
+	maxSize      int64
+}
+
+func NewFileTransferService(transferRepo *repository.FileTransferRepository, chunkRepo *repository.FileChunkRepository, machines *MachineService, dispatcher *FileTransferDispatcher, recorder *audit.Recorder, maxSize int64) *FileTransferService {
+	return &FileTransferService{
+		transferRepo: transferRepo,
+		chunkRepo:    chunkRepo,
+		machines:     machines,
+		dispatcher:   dispatcher,
+		audit:        recorder,
+		maxSize:      maxSize,
+	}
+}
+
+// MaxSize is the largest file that can be copied, in bytes.
+func (s *FileTransferService) MaxSize() int64 {
+	return s.maxSize
+}
+
+// Upload stores the file read from r and queues copying it to path on the
+// machine. mode is the file's permission bits, 0 to keep those of the file
+// it replaces.
+func (s *FileTransferService) Upload(ctx context.Context, machineID, userID primitive.ObjectID, path string, mode uint32, r io.Reader) (*models.FileTransfer, error) {
+	if err := validateFilePath(path); err != nil {
+		return nil, err
+	}
+	if mode > 0o7777 {
+		return nil, ErrInvalidFileMode
+	}
+	machine, err := s.authorize(ctx, machineID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &models.FileTransfer{
+		MachineID:   machineID,
+		Direction:   models.FileTransferUpload,
+		Path:        path,
+		Mode:        mode,
+		RequestedBy: userID,
+	}
+	t.ID = primitive.NewObjectID()
+	if err := s.store(ctx, t, r); err != nil {
+		if derr := s.chunkRepo.DeleteByTransferID(ctx, t.ID); derr != nil {
+			return nil, fmt.Errorf("%w (discarding the stored part: %v)", err, derr)
+		}
+		return nil, err
+	}
+	if err := s.transferRepo.Create(ctx, t); err != nil {
+		return nil, err
+	}
+	s.audit.Record(ctx, audit.Entry{
+		Action: audit.ActionFileUpload,
+		Target: audit.MachineTarget(machine),
+		Details: map[string]interface{}{
+			"transfer_id": t.ID.Hex(),
+			"path":        path,
+			"size":        t.Size,
+			"sha256":      t.SHA256,
+		},
+	})
+	s.dispatcher.Dispatch(ctx, t)
+	return t, nil
+}
+
+// store saves the content of an upload in chunks, setting its size and
+// checksum.
+func (s *FileTransferService) store(ctx context.Context, t *models.FileTransfer, r io.Reader) error {
+	h := sha256.New()
+	buf := make([]byte, FileChunkSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			if t.Size+int64(n) > s.maxSize {
+				return fmt.Errorf("%w of %d bytes", ErrFileTooLarge, s.maxSize)
+			}
+			if perr := s.chunkRepo.Put(ctx, t.ID, t.Size, buf[:n]); perr != nil {
+				return perr
+			}
+			h.Write(buf[:n])
+			t.Size += int64(n)
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	t.SHA256 = hex.EncodeToString(h.Sum(nil))
+	return nil
+}
+
+// Download queues copying path from the machine.
+func (s *FileTransferService) Download(ctx context.Context, machineID, userID primitive.ObjectID, path string) (*models.FileTransfer, error) {
+	if err := validateFilePath(path); err != nil {
+		return nil, err
+	}
+	machine, err := s.authorize(ctx, machineID, userID)
+	if err != nil {
+		return nil, err
+	}
+	t := &models.FileTransfer{
+		MachineID:   machineID,
+		Direction:   models.FileTransferDownload,
+		Path:        path,
+		RequestedBy: userID,
+	}
+	if err := s.transferRepo.Create(ctx, t); err != nil {
+		return nil, err
+	}
+	s.audit.Record(ctx, audit.Entry{
+		Action: audit.ActionFileDownload,
+		Target: audit.MachineTarget(machine),
+		Details: map[string]interface{}{
+			"transfer_id": t.ID.Hex(),
+			"path":        path,
+		},
+	})
+	s.dispatcher.Dispatch(ctx, t)
+	return t, nil
+}
+
+// List returns up to limit (at most 500) transfers of a machine, newest
+// first.
+func (s *FileTransferService) List(ctx context.Context, machineID, userID primitive.ObjectID, limit int) ([]*models.FileTransfer, error) {
+	if limit <= 0 || limit > fileTransferListLimit {
+		limit = fileTransferListLimit
+	}
+	if _, err := s.machines.GetForUser(ctx, machineID, userID, authz.ActionCommandExecute); err != nil {
+		return nil, err
+	}
+	return s.transferRepo.GetByMachineID(ctx, machineID, int64(limit))
+}
+
+// Get returns a transfer of a machine.
+func (s *FileTransferService) Get(ctx context.Context, machineID, transferID, userID primitive.ObjectID) (*models.FileTransfer, error) {
+	if _, err := s.machines.GetForUser(ctx, machineID, userID, authz.ActionCommandExecute); err != nil {
+		return nil, err
+	}
+	t, err := s.transferRepo.GetByID(ctx, transferID)
+	if err == mongo.ErrNoDocuments || (err == nil && t.MachineID != machineID) {
+		return nil, ErrFileTransferNotFound
+	}
+	return t, err
+}
+
+// Wait returns t once it is no longer open, or as it is after timeout.
+func (s *FileTransferService) Wait(ctx context.Context, t *models.FileTransfer, timeout time.Duration) (*models.FileTransfer, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+	for t.Status == models.FileTransferPending || t.Status == models.FileTransferRunning {
+		select {
+		case <-ctx.Done():
+			return t, nil
+		case <-ticker.C:
+		}
+		latest, err := s.transferRepo.GetByID(ctx, t.ID)
+		if err != nil {
+			if ctx.Err() != nil {
+				return t, nil
+			}
+			return nil, err
+		}
+		t = latest
+	}
+	return t, nil
+}
+
+// CheckContent reports whether the content of t can be read.
+func (s *FileTransferService) CheckContent(t *models.FileTransfer) error {
+	if t.Status != models.FileTransferCompleted {
+		return ErrFileNotReady
+	}
+	if time.Since(t.CreatedAt) > FileContentRetention {
+		return ErrFileContentExpired
+	}
+	return nil
+}
+
+// WriteContent writes the content of a completed transfer to w.
+func (s *FileTransferService) WriteContent(ctx context.Context, t *models.FileTransfer, w io.Writer) error {
+	if err := s.CheckContent(t); err != nil {
+		return err
+	}
+	var n int64
+	err := s.chunkRepo.Each(ctx, t.ID, func(c *models.FileChunk) error {
+		if c.Offset != n {
+			return ErrFileContentExpired
+		}
+		if _, err := w.Write(c.Data); err != nil {
+			return err
+		}
+		n += int64(len(c.Data))
+		return nil
+	})
+	if err == nil && n != t.Size {
+		err = ErrFileContentExpired
+	}
+	return err
+}
+
+func (s *FileTransferService) authorize(ctx context.Context, machineID, userID primitive.ObjectID) (*models.Machine, error) {
+	machine, err := s.machines.GetForUser(ctx, machineID, userID, authz.ActionCommandExecute)
+	if err != nil {
+		return nil, err
+	}
+	if machine.Host == nil || !slices.Contains(machine.Host.Features, luteGrpc.FeatureName(pb.Feature_FEATURE_FILE_TRANSFER)) {
+		return nil, ErrFileTransferUnsupported
+	}
+	return machine, nil
+}
+
+// validateFilePath checks that path is absolute on a Unix or Windows
+// machine. The agent checks it against its allowlists.
+func validateFilePath(path string) error {
+	if strings.ContainsRune(path, 0) || len(path) > 4096 {
+		return ErrInvalidFilePath
+	}
+	if !strings.HasPrefix(path, "/") && !windowsAbsPath.MatchString(path) {
+		return ErrInvalidFilePath
+	}
+	return nil
+}
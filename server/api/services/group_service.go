@@ -0,0 +1,200 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/lute/api/authz"
+	"github.com/lute/api/models"
+	"github.com/lute/api/repository"
+)
+
+var (
+	ErrGroupNotFound     = errors.New("group not found")
+	ErrGroupNameTaken    = errors.New("a group with this name already exists")
+	ErrGroupUnauthorized = errors.New("unauthorized: group does not belong to user")
+	ErrGroupOtherOrg     = errors.New("machine does not belong to the group's organization")
+)
+
+// GroupService manages user-defined machine groups and their membership.
+// Personal groups belong to their creator; groups of an organization are
+// authorized like its machines.
+// Membership changes are pushed to the agents concerned, since a group's
+// config applies to its machines.
+type GroupService struct {
+	groupRepo   *repository.MachineGroupRepository
+	machineRepo *repository.MachineRepository
+	authz       *authz.Authorizer
+	configs     *ConfigPusher
+}
+
+func NewGroupService(groupRepo *repository.MachineGroupRepository, machineRepo *repository.MachineRepository, authorizer *authz.Authorizer, configs *ConfigPusher) *GroupService {
+	return &GroupService{
+		groupRepo:   groupRepo,
+		machineRepo: machineRepo,
+		authz:       authorizer,
+		configs:     configs,
+	}
+}
+
+// Create creates a new group for the user, or in group.OrgID when set
+func (s *GroupService) Create(ctx context.Context, userID primitive.ObjectID, group *models.MachineGroup) (*models.MachineGroup, error) {
+	if group.OrgID.IsZero() {
+		if err := s.authz.AuthorizePersonal(ctx, authz.ActionMachineWrite); err != nil {
+			return nil, err
+		}
+	} else if _, err := s.authz.AuthorizeOrg(ctx, userID, group.OrgID, authz.ActionMachineWrite); err != nil {
+		return nil, err
+	}
+	group.UserID = userID
+	group.Name = strings.TrimSpace(group.Name)
+	if group.Name == "" {
+		return nil, errors.New("group name is required")
+	}
+	if err := s.groupRepo.Create(ctx, group); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrGroupNameTaken
+		}
+		return nil, err
+	}
+	return group, nil
+}
+
+// Get returns a group the user may see
+func (s *GroupService) Get(ctx context.Context, id, userID primitive.ObjectID) (*models.MachineGroup, error) {
+	return s.GetForUser(ctx, id, userID, authz.ActionMachineRead)
+}
+
+// GetForUser returns a group after checking that the user may perform action on it
+func (s *GroupService) GetForUser(ctx context.Context, id, userID primitive.ObjectID, action authz.Action) (*models.MachineGroup, error) {
+	group, err := s.groupRepo.GetByID(ctx, id)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrGroupNotFound
+		}
+		return nil, err
+	}
+	if err := s.authz.AuthorizeGroup(ctx, userID, group, action); err != nil {
+		return nil, err
+	}
+	return group, nil
+}
+
+// List returns the user's personal groups and those of their organizations
+func (s *GroupService) List(ctx context.Context, userID primitive.ObjectID) ([]*models.MachineGroup, error) {
+	if err := authz.CheckScope(ctx, authz.ActionMachineRead); err != nil {
+		return nil, err
+	}
+	orgIDs, err := s.authz.OrgIDs(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.groupRepo.GetByUserID(ctx, userID, orgIDs)
+}
+
+// Update renames a group or changes its description
+func (s *GroupService) Update(ctx context.Context, id, userID primitive.ObjectID, group *models.MachineGroup) (*models.MachineGroup, error) {
+	existing, err := s.GetForUser(ctx, id, userID, authz.ActionMachineWrite)
+	if err != nil {
+		return nil, err
+	}
+	existing.Name = strings.TrimSpace(group.Name)
+	existing.Description = group.Description
+	if existing.Name == "" {
+		return nil, errors.New("group name is required")
+	}
+	if err := s.groupRepo.Update(ctx, id, existing); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrGroupNameTaken
+		}
+		return nil, err
+	}
+	return existing, nil
+}
+
+// Delete removes a group and drops it from every member machine
+func (s *GroupService) Delete(ctx context.Context, id, userID primitive.ObjectID) error {
+	if _, err := s.GetForUser(ctx, id, userID, authz.ActionMachineWrite); err != nil {
+		return err
+	}
+	// The former members lose the group's config
+	members, err := s.machineRepo.IDsInGroup(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := s.machineRepo.RemoveFromGroup(ctx, id, nil); err != nil {
+		return err
+	}
+	if err := s.groupRepo.Delete(ctx, id); err != nil {
+		return err
+	}
+	go s.configs.PushMachines(context.Background(), members)
+	return nil
+}
+
+// AddMachines adds machines the user may change to the group; machines of
+// an organization's group must belong to that organization
+func (s *GroupService) AddMachines(ctx context.Context, id, userID primitive.ObjectID, machineIDs []primitive.ObjectID) error {
+	group, err := s.GetForUser(ctx, id, userID, authz.ActionMachineWrite)
+	if err != nil {
+		return err
+	}
+	if err := s.verifyMachines(ctx, userID, group, machineIDs); err != nil {
+		return err
+	}
+	if err := s.machineRepo.AddToGroup(ctx, id, machineIDs); err != nil {
+		return err
+	}
+	go s.configs.PushMachines(context.Background(), machineIDs)
+	return nil
+}
+
+// RemoveMachines removes machines from the group
+func (s *GroupService) RemoveMachines(ctx context.Context, id, userID primitive.ObjectID, machineIDs []primitive.ObjectID) error {
+	if _, err := s.GetForUser(ctx, id, userID, authz.ActionMachineWrite); err != nil {
+		return err
+	}
+	if len(machineIDs) == 0 {
+		return nil
+	}
+	if err := s.machineRepo.RemoveFromGroup(ctx, id, machineIDs); err != nil {
+		return err
+	}
+	go s.configs.PushMachines(context.Background(), machineIDs)
+	return nil
+}
+
+// Members returns the machines in the group
+func (s *GroupService) Members(ctx context.Context, id, userID primitive.ObjectID) ([]*models.Machine, error) {
+	if _, err := s.Get(ctx, id, userID); err != nil {
+		return nil, err
+	}
+	orgIDs, err := s.authz.OrgIDs(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.machineRepo.GetByUserIDMatching(ctx, userID, orgIDs, MachineFilter{GroupID: id}.Match())
+}
+
+func (s *GroupService) verifyMachines(ctx context.Context, userID primitive.ObjectID, group *models.MachineGroup, machineIDs []primitive.ObjectID) error {
+	for _, mid := range machineIDs {
+		m, err := s.machineRepo.GetByID(ctx, mid)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				return errors.New("machine not found")
+			}
+			return err
+		}
+		if err := s.authz.AuthorizeMachine(ctx, userID, m, authz.ActionMachineWrite); err != nil {
+			return err
+		}
+		if !group.OrgID.IsZero() && m.OrgID != group.OrgID {
+			return ErrGroupOtherOrg
+		}
+	}
+	return nil
+}
@@ -7,13 +7,16 @@ import (
 
 	pb "github.com/lute/agent/proto/agent"
 	luteGrpc "github.com/lute/api/grpc"
+	"github.com/lute/api/models"
 	"github.com/lute/api/repository"
 )
 
 // HeartbeatChecker periodically pings connected agents over their
 // bidirectional gRPC streams. On a successful pong the retry counter is
 // reset; on failure it is incremented. Once retries exceed max the machine
-// is marked dead and no longer polled.
+// is marked dead and no longer polled. A machine whose config sets a longer
+// heartbeat interval is pinged only once that interval has passed since
+// its stream was last pinged.
 type HeartbeatChecker struct {
 	machineRepo *repository.MachineRepository
 	connMgr     *luteGrpc.ConnectionManager
@@ -21,6 +24,14 @@ type HeartbeatChecker struct {
 	pingTimeout time.Duration
 	maxRetries  int
 	runNow      chan struct{} // trigger an immediate check (e.g. when a new connection registers)
+	// pinged is the last successful ping per machine; only used by check.
+	pinged map[string]pingRecord
+}
+
+// pingRecord is when a machine's stream was last pinged successfully.
+type pingRecord struct {
+	conn *luteGrpc.MachineConnection
+	at   time.Time
 }
 
 func NewHeartbeatChecker(
@@ -79,6 +90,9 @@ func (h *HeartbeatChecker) check(ctx context.Context) {
 		return
 	}
 
+	pinged := make(map[string]pingRecord, len(machines))
+	defer func() { h.pinged = pinged }()
+
 	for _, m := range machines {
 		machineID := m.ID.Hex()
 		conn := h.connMgr.Get(machineID)
@@ -87,6 +101,10 @@ func (h *HeartbeatChecker) check(ctx context.Context) {
 			h.handleMiss(ctx, machineID)
 			continue
 		}
+		if last, ok := h.pinged[machineID]; ok && last.conn == conn && !h.due(m, last.at) {
+			pinged[machineID] = last
+			continue
+		}
 
 		log.Printf("Heartbeat checker: pinging machine %s", machineID)
 		pong, err := conn.Ping(h.pingTimeout)
@@ -96,6 +114,8 @@ func (h *HeartbeatChecker) check(ctx context.Context) {
 			continue
 		}
 
+		pinged[machineID] = pingRecord{conn: conn, at: time.Now()}
+
 		var metrics map[string]interface{}
 		if pong != nil {
 			metrics = metricValueMapToInterface(pong.GetMetrics())
@@ -108,6 +128,14 @@ func (h *HeartbeatChecker) check(ctx context.Context) {
 	}
 }
 
+// due reports whether a stream last pinged at last should be pinged in this
+// check. Half a check interval of slack keeps a machine from waiting for
+// the check after the one its heartbeat interval ends in.
+func (h *HeartbeatChecker) due(m *models.Machine, last time.Time) bool {
+	interval := time.Duration(m.HeartbeatInterval) * time.Second
+	return time.Since(last)+h.interval/2 >= interval
+}
+
 func (h *HeartbeatChecker) handleMiss(ctx context.Context, machineID string) {
 	mid, err := luteGrpc.ParseMachineID(machineID)
 	if err != nil {
@@ -0,0 +1,197 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	pb "github.com/lute/agent/proto/agent"
+	"github.com/lute/api/audit"
+	"github.com/lute/api/models"
+	"github.com/lute/api/repository"
+)
+
+const inventoryWriteTimeout = 30 * time.Second
+
+// InventoryRecorder stores the software and hardware inventory agents report
+// and records how it changes.
+type InventoryRecorder struct {
+	inventoryRepo *repository.MachineInventoryRepository
+	changeRepo    *repository.InventoryChangeRepository
+
+	// OnStored, if set, is called with each inventory that differs from the
+	// stored one, after it was stored. It must not block.
+	OnStored func(inv *models.MachineInventory)
+}
+
+func NewInventoryRecorder(inventoryRepo *repository.MachineInventoryRepository, changeRepo *repository.InventoryChangeRepository) *InventoryRecorder {
+	return &InventoryRecorder{
+		inventoryRepo: inventoryRepo,
+		changeRepo:    changeRepo,
+	}
+}
+
+// StoredHash returns the hash of the machine's stored inventory ("" if none),
+// sent in the Welcome so the agent only reports an inventory that changed.
+func (r *InventoryRecorder) StoredHash(ctx context.Context, machineID string) string {
+	id, err := primitive.ObjectIDFromHex(machineID)
+	if err != nil {
+		return ""
+	}
+	hash, err := r.inventoryRepo.GetHash(ctx, id)
+	if err != nil {
+		log.Printf("Inventory: failed to read inventory hash of machine %s: %v", machineID, err)
+		return ""
+	}
+	return hash
+}
+
+// HandleMessage stores an Inventory sent by an agent. It never replies.
+func (r *InventoryRecorder) HandleMessage(machineID string, msg *pb.AgentMessage) *pb.ServerMessage {
+	report := msg.GetInventory()
+	if report == nil {
+		return nil
+	}
+	mid, err := primitive.ObjectIDFromHex(machineID)
+	if err != nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), inventoryWriteTimeout)
+	defer cancel()
+	if err := r.store(ctx, inventoryFromProto(mid, report)); err != nil {
+		log.Printf("Inventory: failed to store inventory of machine %s: %v", machineID, err)
+	}
+	return nil
+}
+
+// store replaces the machine's inventory and records the difference to the
+// previous one.
+func (r *InventoryRecorder) store(ctx context.Context, inv *models.MachineInventory) error {
+	prev, err := r.inventoryRepo.Get(ctx, inv.MachineID)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return err
+	}
+	if prev != nil && prev.Hash == inv.Hash {
+		return nil
+	}
+	if err := r.inventoryRepo.Replace(ctx, inv); err != nil {
+		return err
+	}
+	change := diffInventory(prev, inv)
+	if err := r.changeRepo.Insert(ctx, change); err != nil {
+		return err
+	}
+	log.Printf("Inventory: machine %s reported %d packages (%d added, %d removed, %d updated)",
+		inv.MachineID.Hex(), len(inv.Packages), len(change.Added), len(change.Removed), len(change.Updated))
+	if r.OnStored != nil {
+		r.OnStored(inv)
+	}
+	return nil
+}
+
+// packageKey identifies a package across inventories; the same name may be
+// installed for several architectures.
+func packageKey(p models.InstalledPackage) string {
+	return p.Format + "/" + p.Name + "/" + p.Arch
+}
+
+// diffInventory describes how next differs from prev (nil for the first
+// inventory of a machine).
+func diffInventory(prev, next *models.MachineInventory) *models.InventoryChange {
+	change := &models.InventoryChange{
+		MachineID: next.MachineID,
+		At:        next.ReceivedAt,
+		Hash:      next.Hash,
+	}
+	if prev == nil {
+		change.Initial = true
+		return change
+	}
+
+	before := make(map[string]models.InstalledPackage, len(prev.Packages))
+	for _, p := range prev.Packages {
+		before[packageKey(p)] = p
+	}
+	for _, p := range next.Packages {
+		key := packageKey(p)
+		old, ok := before[key]
+		delete(before, key)
+		switch {
+		case !ok:
+			change.Added = append(change.Added, p)
+		case old.Version != p.Version:
+			change.Updated = append(change.Updated, models.PackageUpdate{
+				Name: p.Name, Arch: p.Arch, Format: p.Format, From: old.Version, To: p.Version,
+			})
+		}
+	}
+	for _, p := range prev.Packages {
+		if _, removed := before[packageKey(p)]; removed {
+			change.Removed = append(change.Removed, p)
+		}
+	}
+
+	change.Changes = audit.Diff(inventoryFields(prev), inventoryFields(next))
+	return change
+}
+
+// inventoryFields are the non-package parts of an inventory compared by
+// diffInventory.
+func inventoryFields(inv *models.MachineInventory) map[string]interface{} {
+	return map[string]interface{}{
+		"os_release":         inv.OSRelease,
+		"os_id":              inv.OSID,
+		"os_version_id":      inv.OSVersionID,
+		"kernel":             inv.Kernel,
+		"hardware":           inv.Hardware,
+		"network_interfaces": inv.NetworkInterfaces,
+		"block_devices":      inv.BlockDevices,
+	}
+}
+
+func inventoryFromProto(machineID primitive.ObjectID, report *pb.Inventory) *models.MachineInventory {
+	hw := report.GetHardware()
+	inv := &models.MachineInventory{
+		MachineID:   machineID,
+		Hash:        report.GetHash(),
+		CollectedAt: time.Unix(report.GetCollectedAt(), 0).UTC(),
+		ReceivedAt:  time.Now().UTC(),
+		OSRelease:   report.GetOsRelease(),
+		OSID:        report.GetOsId(),
+		OSVersionID: report.GetOsVersionId(),
+		Kernel:      report.GetKernel(),
+		Hardware: models.InventoryHardware{
+			CPUModel:       hw.GetCpuModel(),
+			CPUCount:       int(hw.GetCpuCount()),
+			MemoryBytes:    int64(hw.GetMemoryBytes()),
+			SystemVendor:   hw.GetSystemVendor(),
+			ProductName:    hw.GetProductName(),
+			ProductVersion: hw.GetProductVersion(),
+			BIOSVendor:     hw.GetBiosVendor(),
+			BIOSVersion:    hw.GetBiosVersion(),
+			BoardVendor:    hw.GetBoardVendor(),
+			BoardName:      hw.GetBoardName(),
+		},
+		Packages: make([]models.InstalledPackage, 0, len(report.GetPackages())),
+	}
+	for _, p := range report.GetPackages() {
+		inv.Packages = append(inv.Packages, models.InstalledPackage{
+			Name: p.GetName(), Version: p.GetVersion(), Arch: p.GetArch(), Format: p.GetFormat(), Source: p.GetSource(),
+		})
+	}
+	for _, n := range report.GetNetworkInterfaces() {
+		inv.NetworkInterfaces = append(inv.NetworkInterfaces, models.NetworkInterface{
+			Name: n.GetName(), MAC: n.GetMac(), MTU: int(n.GetMtu()), Addresses: n.GetAddresses(),
+		})
+	}
+	for _, d := range report.GetBlockDevices() {
+		inv.BlockDevices = append(inv.BlockDevices, models.BlockDevice{
+			Name: d.GetName(), SizeBytes: int64(d.GetSizeBytes()), Model: d.GetModel(),
+			Rotational: d.GetRotational(), Removable: d.GetRemovable(),
+		})
+	}
+	return inv
+}
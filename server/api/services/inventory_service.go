@@ -0,0 +1,117 @@
+package services
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/lute/api/authz"
+	"github.com/lute/api/models"
+	"github.com/lute/api/pkgversion"
+	"github.com/lute/api/repository"
+)
+
+// InventoryHistoryLimit caps the changes returned by History.
+const InventoryHistoryLimit = 500
+
+var ErrInventoryNotFound = errors.New("machine has not reported an inventory yet")
+
+// InventoryService answers questions about the inventories agents reported
+// (see InventoryRecorder): per machine and fleet-wide package queries.
+type InventoryService struct {
+	inventoryRepo *repository.MachineInventoryRepository
+	changeRepo    *repository.InventoryChangeRepository
+	machines      *MachineService
+}
+
+func NewInventoryService(inventoryRepo *repository.MachineInventoryRepository, changeRepo *repository.InventoryChangeRepository, machines *MachineService) *InventoryService {
+	return &InventoryService{
+		inventoryRepo: inventoryRepo,
+		changeRepo:    changeRepo,
+		machines:      machines,
+	}
+}
+
+// Get returns the inventory of a machine the user may read.
+func (s *InventoryService) Get(ctx context.Context, machineID, userID primitive.ObjectID) (*models.MachineInventory, error) {
+	if _, err := s.machines.GetForUser(ctx, machineID, userID, authz.ActionMachineRead); err != nil {
+		return nil, err
+	}
+	inv, err := s.inventoryRepo.Get(ctx, machineID)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrInventoryNotFound
+	}
+	return inv, err
+}
+
+// History returns up to limit inventory changes of a machine, newest first.
+func (s *InventoryService) History(ctx context.Context, machineID, userID primitive.ObjectID, limit int) ([]*models.InventoryChange, error) {
+	if _, err := s.machines.GetForUser(ctx, machineID, userID, authz.ActionMachineRead); err != nil {
+		return nil, err
+	}
+	if limit <= 0 || limit > InventoryHistoryLimit {
+		limit = InventoryHistoryLimit
+	}
+	return s.changeRepo.GetByMachineID(ctx, machineID, int64(limit))
+}
+
+// VersionConstraint restricts a package query to versions Op Version
+// (e.g. lt 3.0.2), compared by the rules of each package's format.
+type VersionConstraint struct {
+	Op      pkgversion.Op
+	Version string
+}
+
+// PackageQuery selects installed packages by name and version.
+type PackageQuery struct {
+	Name        string
+	Constraints []VersionConstraint // all must hold
+}
+
+// PackageMatch is an installed package that matched a PackageQuery.
+type PackageMatch struct {
+	MachineID   primitive.ObjectID      `json:"machine_id"`
+	MachineName string                  `json:"machine_name"`
+	Package     models.InstalledPackage `json:"package"`
+}
+
+// FindPackages answers fleet-wide questions such as "which machines have
+// openssl older than 3.0.2" over the machines visible to the user that
+// match filter.
+func (s *InventoryService) FindPackages(ctx context.Context, userID primitive.ObjectID, filter MachineFilter, q PackageQuery) ([]PackageMatch, error) {
+	machines, err := s.machines.GetByUserIDFiltered(ctx, userID, filter)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]primitive.ObjectID, 0, len(machines))
+	names := make(map[primitive.ObjectID]string, len(machines))
+	for _, m := range machines {
+		ids = append(ids, m.ID)
+		names[m.ID] = m.Name
+	}
+	found, err := s.inventoryRepo.FindPackage(ctx, ids, q.Name)
+	if err != nil {
+		return nil, err
+	}
+	out := []PackageMatch{}
+	for _, f := range found {
+		for _, p := range f.Packages {
+			if !q.matches(p) {
+				continue
+			}
+			out = append(out, PackageMatch{MachineID: f.MachineID, MachineName: names[f.MachineID], Package: p})
+		}
+	}
+	return out, nil
+}
+
+func (q PackageQuery) matches(p models.InstalledPackage) bool {
+	for _, c := range q.Constraints {
+		if !pkgversion.Match(p.Format, p.Version, c.Op, c.Version) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,373 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"reflect"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	pb "github.com/lute/agent/proto/agent"
+	luteGrpc "github.com/lute/api/grpc"
+	"github.com/lute/api/models"
+	"github.com/lute/api/repository"
+)
+
+const (
+	jobTickInterval   = 5 * time.Second
+	jobAdvanceTimeout = time.Minute
+	// agentActionTimeout is the agent's timeout for actions that set none.
+	agentActionTimeout = 5 * time.Minute
+	// jobTimeoutGrace is allowed on top of an action's timeout before a
+	// machine whose agent sent no result counts as timed out.
+	jobTimeoutGrace = time.Minute
+	// jobCommandLost is how long a queued machine may have no command (the
+	// server stopped between saving the job and creating it).
+	jobCommandLost = time.Minute
+)
+
+const (
+	// agentTimedOut is the error an agent reports for an action it stopped.
+	agentTimedOut = "timed out"
+	jobCancelled  = "the job was stopped before the command was sent"
+)
+
+// JobRunner advances the running jobs: it follows the commands of started
+// machines and starts the next ones as the job's batches, concurrency and
+// failure threshold allow. It works through all running jobs every few
+// seconds and whenever Kick is called. Updates are guarded by the job's
+// revision, so runners of several API instances do not start a machine twice.
+type JobRunner struct {
+	jobRepo     *repository.JobRepository
+	commandRepo *repository.CommandRepository
+	machineRepo *repository.MachineRepository
+	connMgr     *luteGrpc.ConnectionManager
+	dispatcher  *ActionDispatcher
+	kick        chan struct{}
+}
+
+func NewJobRunner(jobRepo *repository.JobRepository, commandRepo *repository.CommandRepository, machineRepo *repository.MachineRepository, connMgr *luteGrpc.ConnectionManager, dispatcher *ActionDispatcher) *JobRunner {
+	return &JobRunner{
+		jobRepo:     jobRepo,
+		commandRepo: commandRepo,
+		machineRepo: machineRepo,
+		connMgr:     connMgr,
+		dispatcher:  dispatcher,
+		kick:        make(chan struct{}, 1),
+	}
+}
+
+// Run advances jobs until ctx is cancelled. Call from a goroutine.
+func (r *JobRunner) Run(ctx context.Context) {
+	ticker := time.NewTicker(jobTickInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-r.kick:
+		}
+		r.advanceAll(ctx)
+	}
+}
+
+// Kick makes the runner advance the jobs now, e.g. after a job was created
+// or a command finished.
+func (r *JobRunner) Kick() {
+	select {
+	case r.kick <- struct{}{}:
+	default:
+	}
+}
+
+func (r *JobRunner) advanceAll(ctx context.Context) {
+	jobs, err := r.jobRepo.GetRunning(ctx)
+	if err != nil {
+		log.Printf("Jobs: failed to load running jobs: %v", err)
+		return
+	}
+	for _, job := range jobs {
+		jctx, cancel := context.WithTimeout(ctx, jobAdvanceTimeout)
+		r.advance(jctx, job)
+		cancel()
+	}
+}
+
+func (r *JobRunner) advance(ctx context.Context, job *models.Job) {
+	now := time.Now().UTC()
+	before, stopReason := slices.Clone(job.Machines), job.StopReason
+	if err := r.refresh(ctx, job, now); err != nil {
+		log.Printf("Jobs: failed to load the commands of job %s: %v", job.ID.Hex(), err)
+		return
+	}
+
+	var start []int
+	summary := summarizeJob(job.Machines)
+	switch {
+	case job.StopReason != "":
+		// Stopped earlier; waiting for the machines already running.
+	case job.CancelRequested:
+		r.halt(ctx, job, "cancelled by a user")
+	case job.StopAfterFailures > 0 && summary.Failed+summary.TimedOut >= job.StopAfterFailures:
+		r.halt(ctx, job, fmt.Sprintf("%d machines failed or timed out", summary.Failed+summary.TimedOut))
+	default:
+		start = r.plan(ctx, job, now)
+	}
+
+	job.Summary = summarizeJob(job.Machines)
+	if job.Summary.Pending+job.Summary.Queued+job.Summary.Running == 0 {
+		switch {
+		case job.CancelRequested:
+			job.Status = models.JobCancelled
+		case job.StopReason != "":
+			job.Status = models.JobStopped
+		default:
+			job.Status = models.JobCompleted
+		}
+		job.FinishedAt = &now
+	}
+	if job.Status == models.JobRunning && job.StopReason == stopReason && reflect.DeepEqual(before, job.Machines) {
+		return
+	}
+	saved, err := r.jobRepo.Save(ctx, job)
+	if err != nil {
+		log.Printf("Jobs: failed to save job %s: %v", job.ID.Hex(), err)
+		return
+	}
+	if !saved {
+		// Advanced elsewhere meanwhile; the next round reloads it.
+		return
+	}
+	for _, i := range start {
+		r.startCommand(ctx, job, &job.Machines[i])
+	}
+	if job.Status != models.JobRunning {
+		s := job.Summary
+		log.Printf("Jobs: job %s %s: %d succeeded, %d failed, %d timed out, %d offline, %d cancelled",
+			job.ID.Hex(), job.Status, s.Succeeded, s.Failed, s.TimedOut, s.Offline, s.Cancelled)
+	}
+}
+
+// refresh updates the started machines of a job from their commands.
+func (r *JobRunner) refresh(ctx context.Context, job *models.Job, now time.Time) error {
+	var ids []primitive.ObjectID
+	for _, m := range job.Machines {
+		if m.Status == models.JobMachineQueued || m.Status == models.JobMachineRunning {
+			ids = append(ids, m.CommandID)
+		}
+	}
+	commands, err := r.commandRepo.GetByIDs(ctx, ids)
+	if err != nil {
+		return err
+	}
+	byID := make(map[primitive.ObjectID]*models.Command, len(commands))
+	for _, cmd := range commands {
+		byID[cmd.ID] = cmd
+	}
+
+	timeout := agentActionTimeout
+	if job.TimeoutSeconds > 0 {
+		timeout = time.Duration(job.TimeoutSeconds) * time.Second
+	}
+	for i := range job.Machines {
+		m := &job.Machines[i]
+		if m.Status != models.JobMachineQueued && m.Status != models.JobMachineRunning {
+			continue
+		}
+		cmd := byID[m.CommandID]
+		switch {
+		case cmd == nil:
+			if m.QueuedAt != nil && now.Sub(*m.QueuedAt) > jobCommandLost {
+				finishJobMachine(m, models.JobMachineFailed, "the command was not created", now)
+			}
+		case cmd.Status == "pending":
+			m.Status = models.JobMachineQueued
+			if m.QueuedAt != nil && now.Sub(*m.QueuedAt) > ActionQueueTTL {
+				cancelled, err := r.commandRepo.CancelPending(ctx, cmd.ID, actionExpired)
+				if err != nil {
+					return err
+				}
+				if cancelled {
+					finishJobMachine(m, models.JobMachineOffline, actionExpired, now)
+				}
+			}
+		case cmd.Status == "running":
+			if m.StartedAt == nil {
+				// Marked running when it was sent.
+				sent := cmd.UpdatedAt.UTC()
+				m.StartedAt = &sent
+			}
+			m.Status = models.JobMachineRunning
+			if now.Sub(*m.StartedAt) > timeout+jobTimeoutGrace {
+				finishJobMachine(m, models.JobMachineTimedOut, fmt.Sprintf("no result within %s", timeout+jobTimeoutGrace), now)
+			}
+		default:
+			m.Status = jobMachineStatus(cmd)
+			m.ExitCode = cmd.ExitCode
+			m.Error = cmd.Error
+			if cmd.StartedAt != nil {
+				m.StartedAt = cmd.StartedAt
+			}
+			m.FinishedAt = cmd.FinishedAt
+			if m.FinishedAt == nil {
+				m.FinishedAt = &now
+			}
+		}
+	}
+	return nil
+}
+
+// halt stops starting machines: those not started are cancelled, as are
+// those whose command was not sent yet.
+func (r *JobRunner) halt(ctx context.Context, job *models.Job, reason string) {
+	job.StopReason = reason
+	now := time.Now().UTC()
+	for i := range job.Machines {
+		m := &job.Machines[i]
+		switch m.Status {
+		case models.JobMachinePending:
+			finishJobMachine(m, models.JobMachineCancelled, "", now)
+		case models.JobMachineQueued:
+			cancelled, err := r.commandRepo.CancelPending(ctx, m.CommandID, jobCancelled)
+			if err != nil {
+				log.Printf("Jobs: failed to cancel command %s: %v", m.CommandID.Hex(), err)
+				continue
+			}
+			if cancelled {
+				finishJobMachine(m, models.JobMachineCancelled, jobCancelled, now)
+			}
+		}
+	}
+	log.Printf("Jobs: job %s stopped: %s", job.ID.Hex(), reason)
+}
+
+// plan picks the machines to start now: pending machines of the first
+// unfinished batch, while fewer than Concurrency machines are queued or
+// running. A command queued for an offline machine takes its place too, as
+// it is delivered whenever the agent connects.
+// Machines that cannot run the action, or are offline in a job that skips
+// those, are finished at once. It returns the indexes of the machines whose
+// commands are to be created.
+func (r *JobRunner) plan(ctx context.Context, job *models.Job, now time.Time) []int {
+	batch, running := -1, 0
+	for _, m := range job.Machines {
+		switch m.Status {
+		case models.JobMachinePending, models.JobMachineQueued, models.JobMachineRunning:
+			if batch < 0 || m.Batch < batch {
+				batch = m.Batch
+			}
+			if m.Status != models.JobMachinePending {
+				running++
+			}
+		}
+	}
+	if batch < 0 {
+		return nil
+	}
+
+	var start []int
+	for i := range job.Machines {
+		m := &job.Machines[i]
+		if m.Batch != batch || m.Status != models.JobMachinePending {
+			continue
+		}
+		if job.Concurrency > 0 && running >= job.Concurrency {
+			break
+		}
+		machine, err := r.machineRepo.GetByID(ctx, m.MachineID)
+		if err != nil {
+			finishJobMachine(m, models.JobMachineFailed, "machine not found", now)
+			continue
+		}
+		conn := r.connMgr.Get(m.MachineID.Hex())
+		online := conn != nil && conn.Supports(pb.Feature_FEATURE_ACTIONS)
+		if !online && job.Offline == models.JobOfflineSkip {
+			finishJobMachine(m, models.JobMachineOffline, "the agent is not connected", now)
+			continue
+		}
+		if err := actionEnabled(machine, job.Action); err != nil {
+			finishJobMachine(m, models.JobMachineFailed, err.Error(), now)
+			continue
+		}
+		m.Status = models.JobMachineQueued
+		m.CommandID = primitive.NewObjectID()
+		m.QueuedAt = &now
+		start = append(start, i)
+		running++
+	}
+	return start
+}
+
+// startCommand creates the command of a machine and sends it if the agent
+// is connected.
+func (r *JobRunner) startCommand(ctx context.Context, job *models.Job, m *models.JobMachine) {
+	description, _ := validateAction(job.Action, ActionInput{Params: job.Params, Args: job.Args, TimeoutSeconds: job.TimeoutSeconds})
+	cmd := &models.Command{
+		MachineID:      m.MachineID,
+		Command:        description,
+		Args:           job.Args,
+		Status:         "pending",
+		Action:         job.Action,
+		Params:         job.Params,
+		TimeoutSeconds: job.TimeoutSeconds,
+		RequestedBy:    job.UserID,
+		JobID:          job.ID,
+	}
+	cmd.ID = m.CommandID
+	if err := r.commandRepo.Create(ctx, cmd); err != nil {
+		log.Printf("Jobs: failed to create the command of job %s for machine %s: %v", job.ID.Hex(), m.MachineID.Hex(), err)
+		return
+	}
+	r.dispatcher.Dispatch(ctx, cmd)
+}
+
+// jobMachineStatus maps a finished command to the state of its machine.
+func jobMachineStatus(cmd *models.Command) string {
+	switch {
+	case cmd.Status == "completed":
+		return models.JobMachineSucceeded
+	case cmd.Error == agentTimedOut:
+		return models.JobMachineTimedOut
+	case cmd.Error == actionExpired:
+		return models.JobMachineOffline
+	case cmd.Error == jobCancelled:
+		return models.JobMachineCancelled
+	default:
+		return models.JobMachineFailed
+	}
+}
+
+func finishJobMachine(m *models.JobMachine, status, errMsg string, now time.Time) {
+	m.Status = status
+	m.Error = errMsg
+	m.FinishedAt = &now
+}
+
+func summarizeJob(machines []models.JobMachine) models.JobSummary {
+	s := models.JobSummary{Total: len(machines)}
+	for _, m := range machines {
+		switch m.Status {
+		case models.JobMachinePending:
+			s.Pending++
+		case models.JobMachineQueued:
+			s.Queued++
+		case models.JobMachineRunning:
+			s.Running++
+		case models.JobMachineSucceeded:
+			s.Succeeded++
+		case models.JobMachineFailed:
+			s.Failed++
+		case models.JobMachineTimedOut:
+			s.TimedOut++
+		case models.JobMachineOffline:
+			s.Offline++
+		case models.JobMachineCancelled:
+			s.Cancelled++
+		}
+	}
+	return s
+}
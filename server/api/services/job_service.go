@@ -0,0 +1,307 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/lute/api/audit"
+	"github.com/lute/api/authz"
+	"github.com/lute/api/labels"
+	"github.com/lute/api/models"
+	"github.com/lute/api/repository"
+)
+
+const (
+	// JobListLimit caps the jobs returned by List.
+	JobListLimit = 100
+	// maxJobMachines caps the machines of one job.
+	maxJobMachines = 5000
+)
+
+var (
+	ErrJobNotFound   = errors.New("job not found")
+	ErrJobNotRunning = errors.New("the job is not running")
+	ErrJobNoMachines = errors.New("no machines match the job's selector and group")
+	ErrInvalidJob    = errors.New("invalid job")
+)
+
+// CreateJobInput describes a job to create.
+type CreateJobInput struct {
+	OrgID             primitive.ObjectID // zero for the creator's personal machines
+	Name              string             // default: the command, e.g. "systemctl restart nginx.service"
+	Action            string
+	Input             ActionInput
+	Selector          string
+	GroupID           primitive.ObjectID // must be the creator's group
+	Concurrency       int
+	BatchSize         int
+	StopAfterFailures int
+	Offline           string // models.JobOfflineQueue (default) or models.JobOfflineSkip
+}
+
+// JobService creates jobs running a typed action on many machines and
+// reports their progress; the JobRunner carries them out.
+type JobService struct {
+	jobRepo     *repository.JobRepository
+	commandRepo *repository.CommandRepository
+	groupRepo   *repository.MachineGroupRepository
+	machines    *MachineService
+	runner      *JobRunner
+	authz       *authz.Authorizer
+	audit       *audit.Recorder
+}
+
+func NewJobService(
+	jobRepo *repository.JobRepository,
+	commandRepo *repository.CommandRepository,
+	groupRepo *repository.MachineGroupRepository,
+	machines *MachineService,
+	runner *JobRunner,
+	authorizer *authz.Authorizer,
+	recorder *audit.Recorder,
+) *JobService {
+	return &JobService{
+		jobRepo:     jobRepo,
+		commandRepo: commandRepo,
+		groupRepo:   groupRepo,
+		machines:    machines,
+		runner:      runner,
+		authz:       authorizer,
+		audit:       recorder,
+	}
+}
+
+// Create starts a job on the owner's machines that match the selector and
+// group now. Machines are run in order of name.
+func (s *JobService) Create(ctx context.Context, userID primitive.ObjectID, in CreateJobInput) (*models.Job, error) {
+	if !slices.Contains(Actions, in.Action) {
+		return nil, ErrUnknownAction
+	}
+	description, err := validateAction(in.Action, in.Input)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateJobInput(&in); err != nil {
+		return nil, err
+	}
+	if err := s.authorize(ctx, userID, in.OrgID); err != nil {
+		return nil, err
+	}
+	filter, err := s.machineFilter(ctx, userID, in)
+	if err != nil {
+		return nil, err
+	}
+
+	matched, err := s.machines.GetByUserIDFiltered(ctx, userID, filter)
+	if err != nil {
+		return nil, err
+	}
+	var machines []*models.Machine
+	for _, m := range matched {
+		if m.OrgID == in.OrgID && (!in.OrgID.IsZero() || m.UserID == userID) {
+			machines = append(machines, m)
+		}
+	}
+	if len(machines) == 0 {
+		return nil, ErrJobNoMachines
+	}
+	if len(machines) > maxJobMachines {
+		return nil, fmt.Errorf("%w: %d machines match, at most %d are allowed", ErrInvalidJob, len(machines), maxJobMachines)
+	}
+	sort.Slice(machines, func(i, j int) bool { return machines[i].Name < machines[j].Name })
+
+	name := strings.TrimSpace(in.Name)
+	if name == "" {
+		name = description
+	}
+	job := &models.Job{
+		UserID:            userID,
+		OrgID:             in.OrgID,
+		Name:              name,
+		Action:            in.Action,
+		Params:            in.Input.Params,
+		Args:              in.Input.Args,
+		TimeoutSeconds:    in.Input.TimeoutSeconds,
+		Selector:          in.Selector,
+		GroupID:           in.GroupID,
+		Concurrency:       in.Concurrency,
+		BatchSize:         in.BatchSize,
+		StopAfterFailures: in.StopAfterFailures,
+		Offline:           in.Offline,
+		Status:            models.JobRunning,
+	}
+	for i, m := range machines {
+		batch := 0
+		if in.BatchSize > 0 {
+			batch = i / in.BatchSize
+		}
+		job.Machines = append(job.Machines, models.JobMachine{
+			MachineID:   m.ID,
+			MachineName: m.Name,
+			Batch:       batch,
+			Status:      models.JobMachinePending,
+		})
+	}
+	job.Summary = summarizeJob(job.Machines)
+	if err := s.jobRepo.Create(ctx, job); err != nil {
+		return nil, err
+	}
+	s.audit.Record(ctx, audit.Entry{
+		Action: audit.ActionJobCreate,
+		Target: audit.JobTarget(job),
+		Details: map[string]interface{}{
+			"action":              job.Action,
+			"params":              job.Params,
+			"args":                job.Args,
+			"selector":            job.Selector,
+			"group_id":            job.GroupID,
+			"machines":            len(job.Machines),
+			"concurrency":         job.Concurrency,
+			"batch_size":          job.BatchSize,
+			"stop_after_failures": job.StopAfterFailures,
+			"offline":             job.Offline,
+		},
+	})
+	s.runner.Kick()
+	return job, nil
+}
+
+// List returns the user's personal jobs and those of orgs where they may
+// run commands, newest first, without their machines.
+func (s *JobService) List(ctx context.Context, userID primitive.ObjectID) ([]*models.Job, error) {
+	if err := authz.CheckScope(ctx, authz.ActionCommandExecute); err != nil {
+		return nil, err
+	}
+	orgIDs, err := s.authz.OrgIDsAllowing(ctx, userID, authz.ActionCommandExecute)
+	if err != nil {
+		return nil, err
+	}
+	return s.jobRepo.GetVisible(ctx, userID, orgIDs, JobListLimit)
+}
+
+// Get returns a job with the output of its machines' commands.
+func (s *JobService) Get(ctx context.Context, userID, id primitive.ObjectID) (*models.Job, error) {
+	job, err := s.getForUser(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+	var ids []primitive.ObjectID
+	for _, m := range job.Machines {
+		if !m.CommandID.IsZero() {
+			ids = append(ids, m.CommandID)
+		}
+	}
+	commands, err := s.commandRepo.GetByIDs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	output := make(map[primitive.ObjectID]string, len(commands))
+	for _, cmd := range commands {
+		output[cmd.ID] = cmd.Output
+	}
+	for i := range job.Machines {
+		job.Machines[i].Output = output[job.Machines[i].CommandID]
+	}
+	return job, nil
+}
+
+// Cancel stops a running job: machines not started yet are cancelled,
+// commands already sent run to the end.
+func (s *JobService) Cancel(ctx context.Context, userID, id primitive.ObjectID) error {
+	job, err := s.getForUser(ctx, userID, id)
+	if err != nil {
+		return err
+	}
+	ok, err := s.jobRepo.RequestCancel(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrJobNotRunning
+	}
+	s.audit.Record(ctx, audit.Entry{
+		Action:  audit.ActionJobCancel,
+		Target:  audit.JobTarget(job),
+		Details: map[string]interface{}{"summary": job.Summary},
+	})
+	s.runner.Kick()
+	return nil
+}
+
+func (s *JobService) getForUser(ctx context.Context, userID, id primitive.ObjectID) (*models.Job, error) {
+	job, err := s.jobRepo.GetByID(ctx, id)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrJobNotFound
+		}
+		return nil, err
+	}
+	if job.OrgID.IsZero() && job.UserID != userID {
+		return nil, ErrJobNotFound
+	}
+	if err := s.authorize(ctx, userID, job.OrgID); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// machineFilter checks the selector and group of a job and returns the
+// filter selecting its machines.
+func (s *JobService) machineFilter(ctx context.Context, userID primitive.ObjectID, in CreateJobInput) (MachineFilter, error) {
+	filter := MachineFilter{GroupID: in.GroupID, OrgID: in.OrgID}
+	if in.Selector != "" {
+		sel, err := labels.ParseSelector(in.Selector)
+		if err != nil {
+			return filter, fmt.Errorf("%w: selector: %v", ErrInvalidJob, err)
+		}
+		filter.Selector = sel
+	}
+	if !in.GroupID.IsZero() {
+		group, err := s.groupRepo.GetByID(ctx, in.GroupID)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				return filter, ErrGroupNotFound
+			}
+			return filter, err
+		}
+		if err := s.authz.AuthorizeGroup(ctx, userID, group, authz.ActionMachineRead); err != nil {
+			return filter, err
+		}
+	}
+	return filter, nil
+}
+
+func (s *JobService) authorize(ctx context.Context, userID, orgID primitive.ObjectID) error {
+	if orgID.IsZero() {
+		return s.authz.AuthorizePersonal(ctx, authz.ActionCommandExecute)
+	}
+	_, err := s.authz.AuthorizeOrg(ctx, userID, orgID, authz.ActionCommandExecute)
+	return err
+}
+
+// validateJobInput checks the orchestration settings of a job.
+func validateJobInput(in *CreateJobInput) error {
+	if in.Selector == "" && in.GroupID.IsZero() {
+		return fmt.Errorf("%w: a selector or group_id is required", ErrInvalidJob)
+	}
+	if in.Concurrency < 0 || in.BatchSize < 0 || in.StopAfterFailures < 0 {
+		return fmt.Errorf("%w: concurrency, batch_size and stop_after_failures must not be negative", ErrInvalidJob)
+	}
+	if in.Offline == "" {
+		in.Offline = models.JobOfflineQueue
+	}
+	if in.Offline != models.JobOfflineQueue && in.Offline != models.JobOfflineSkip {
+		return fmt.Errorf("%w: offline must be %q or %q", ErrInvalidJob, models.JobOfflineQueue, models.JobOfflineSkip)
+	}
+	if len(in.Name) > 200 {
+		return fmt.Errorf("%w: name is longer than 200 characters", ErrInvalidJob)
+	}
+	return nil
+}
@@ -3,35 +3,102 @@ package services
 import (
 	"context"
 	"errors"
+	"log"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 
+	"github.com/lute/api/audit"
+	"github.com/lute/api/authz"
+	luteGrpc "github.com/lute/api/grpc"
+	"github.com/lute/api/labels"
 	"github.com/lute/api/models"
+	"github.com/lute/api/pki"
 	"github.com/lute/api/repository"
 )
 
+// MachineFilter narrows machine listings. The zero value matches everything.
+type MachineFilter struct {
+	Selector *labels.Selector   // label selector, e.g. env=prod,role in (db,cache)
+	GroupID  primitive.ObjectID // only machines in this group
+	OrgID    primitive.ObjectID // only machines owned by this org
+}
+
+// Empty reports whether the filter matches every machine.
+func (f MachineFilter) Empty() bool {
+	return f.Selector.Empty() && f.GroupID.IsZero() && f.OrgID.IsZero()
+}
+
+// Match returns the MongoDB filter for f, or nil when f is empty.
+func (f MachineFilter) Match() bson.M {
+	var clauses []bson.M
+	if sel := f.Selector.Filter("labels"); sel != nil {
+		clauses = append(clauses, sel)
+	}
+	if !f.GroupID.IsZero() {
+		clauses = append(clauses, bson.M{"group_ids": f.GroupID})
+	}
+	if !f.OrgID.IsZero() {
+		clauses = append(clauses, bson.M{"org_id": f.OrgID})
+	}
+	switch len(clauses) {
+	case 0:
+		return nil
+	case 1:
+		return clauses[0]
+	}
+	return bson.M{"$and": clauses}
+}
+
 type MachineService struct {
 	machineRepo *repository.MachineRepository
+	authz       *authz.Authorizer
+	audit       *audit.Recorder
+	certs       *pki.Authority
+	connMgr     *luteGrpc.ConnectionManager
 }
 
-func NewMachineService(machineRepo *repository.MachineRepository) *MachineService {
+func NewMachineService(machineRepo *repository.MachineRepository, authorizer *authz.Authorizer, recorder *audit.Recorder, certAuthority *pki.Authority, connMgr *luteGrpc.ConnectionManager) *MachineService {
 	return &MachineService{
 		machineRepo: machineRepo,
+		authz:       authorizer,
+		audit:       recorder,
+		certs:       certAuthority,
+		connMgr:     connMgr,
 	}
 }
 
-// Create creates a new machine
+// Create creates a new machine. When machine.OrgID is set the user needs
+// machine:create rights in that org; otherwise the machine is personal.
 func (s *MachineService) Create(ctx context.Context, userID primitive.ObjectID, machine *models.Machine) (*models.Machine, error) {
+	if !machine.OrgID.IsZero() {
+		if _, err := s.authz.AuthorizeOrg(ctx, userID, machine.OrgID, authz.ActionMachineCreate); err != nil {
+			return nil, err
+		}
+	} else if err := s.authz.AuthorizePersonal(ctx, authz.ActionMachineCreate); err != nil {
+		return nil, err
+	}
+
 	// Set user ID and default status
 	machine.UserID = userID
 	if machine.Status == "" {
 		machine.Status = "pending"
 	}
+	if err := labels.Validate(machine.Labels); err != nil {
+		return nil, err
+	}
+	// Group membership is managed through the group endpoints
+	machine.GroupIDs = nil
 
 	if err := s.machineRepo.Create(ctx, machine); err != nil {
 		return nil, err
 	}
+	s.audit.Record(ctx, audit.Entry{
+		Action:  audit.ActionMachineCreate,
+		Target:  audit.MachineTarget(machine),
+		Changes: audit.Diff(nil, audit.MachineFields(machine)),
+	})
 
 	return machine, nil
 }
@@ -48,9 +115,43 @@ func (s *MachineService) GetByID(ctx context.Context, id primitive.ObjectID) (*m
 	return machine, nil
 }
 
-// GetByUserID retrieves all machines for a user
+// GetForUser retrieves a machine after checking that the user may perform action on it.
+// Returns authz.ErrForbidden when the user lacks the permission.
+func (s *MachineService) GetForUser(ctx context.Context, id, userID primitive.ObjectID, action authz.Action) (*models.Machine, error) {
+	machine, err := s.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.authz.AuthorizeMachine(ctx, userID, machine, action); err != nil {
+		return nil, err
+	}
+	return machine, nil
+}
+
+// GetByUserID retrieves all machines visible to a user (personal and org machines)
 func (s *MachineService) GetByUserID(ctx context.Context, userID primitive.ObjectID) ([]*models.Machine, error) {
-	return s.machineRepo.GetByUserID(ctx, userID)
+	return s.GetByUserIDFiltered(ctx, userID, MachineFilter{})
+}
+
+// GetByUserIDFiltered retrieves the machines visible to a user matching the filter
+func (s *MachineService) GetByUserIDFiltered(ctx context.Context, userID primitive.ObjectID, filter MachineFilter) ([]*models.Machine, error) {
+	if err := authz.CheckScope(ctx, authz.ActionMachineRead); err != nil {
+		return nil, err
+	}
+	// Org-scoped API tokens only see their organization's machines
+	if grant := authz.TokenGrantFromContext(ctx); grant != nil && !grant.OrgID.IsZero() && filter.OrgID.IsZero() {
+		filter.OrgID = grant.OrgID
+	}
+	orgIDs, err := s.authz.OrgIDs(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !filter.OrgID.IsZero() {
+		if _, err := s.authz.AuthorizeOrg(ctx, userID, filter.OrgID, authz.ActionMachineRead); err != nil {
+			return nil, err
+		}
+	}
+	return s.machineRepo.GetByUserIDMatching(ctx, userID, orgIDs, filter.Match())
 }
 
 // GetPublic retrieves all public machines
@@ -58,51 +159,176 @@ func (s *MachineService) GetPublic(ctx context.Context) ([]*models.Machine, erro
 	return s.machineRepo.GetPublic(ctx)
 }
 
-// Update updates an existing machine
-func (s *MachineService) Update(ctx context.Context, id primitive.ObjectID, userID primitive.ObjectID, machine *models.Machine) (*models.Machine, error) {
-	// Verify machine exists and belongs to user
-	existing, err := s.machineRepo.GetByID(ctx, id)
+// UpdateMachineInput holds the fields of a machine its users edit. Nil
+// Labels keep the current ones.
+type UpdateMachineInput struct {
+	Name        string
+	Description string
+	IsPublic    bool
+	Labels      map[string]string
+}
+
+// Update changes the user-editable fields of an existing machine
+func (s *MachineService) Update(ctx context.Context, id primitive.ObjectID, userID primitive.ObjectID, in UpdateMachineInput) (*models.Machine, error) {
+	existing, err := s.GetForUser(ctx, id, userID, authz.ActionMachineWrite)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			return nil, errors.New("machine not found")
-		}
 		return nil, err
 	}
 
-	// Verify ownership
-	if existing.UserID != userID {
-		return nil, errors.New("unauthorized: machine does not belong to user")
+	if in.Labels != nil {
+		if err := labels.Validate(in.Labels); err != nil {
+			return nil, err
+		}
 	}
 
-	// Preserve user ID and ID
-	machine.UserID = existing.UserID
-	machine.ID = existing.ID
+	if err := s.machineRepo.UpdateDetails(ctx, id, in.Name, in.Description, in.IsPublic, in.Labels); err != nil {
+		return nil, err
+	}
+
+	updated, err := s.machineRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	s.audit.Record(ctx, audit.Entry{
+		Action:  audit.ActionMachineUpdate,
+		Target:  audit.MachineTarget(updated),
+		Changes: audit.Diff(audit.MachineFields(existing), audit.MachineFields(updated)),
+	})
+	// Visibility changes get their own event so they are easy to find
+	if existing.IsPublic != updated.IsPublic {
+		s.audit.Record(ctx, audit.Entry{
+			Action:  audit.ActionMachineVisibility,
+			Target:  audit.MachineTarget(updated),
+			Changes: map[string]models.AuditChange{"is_public": {Before: existing.IsPublic, After: updated.IsPublic}},
+		})
+	}
+	return updated, nil
+}
 
-	if err := s.machineRepo.Update(ctx, id, machine); err != nil {
+// SetLabels replaces the labels of a machine
+func (s *MachineService) SetLabels(ctx context.Context, id primitive.ObjectID, userID primitive.ObjectID, set map[string]string) (*models.Machine, error) {
+	existing, err := s.GetForUser(ctx, id, userID, authz.ActionMachineWrite)
+	if err != nil {
+		return nil, err
+	}
+	if err := labels.Validate(set); err != nil {
+		return nil, err
+	}
+	if err := s.machineRepo.UpdateLabels(ctx, id, set); err != nil {
 		return nil, err
 	}
+	updated, err := s.machineRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	s.audit.Record(ctx, audit.Entry{
+		Action:  audit.ActionMachineLabels,
+		Target:  audit.MachineTarget(updated),
+		Changes: audit.Diff(audit.MachineFields(existing), audit.MachineFields(updated)),
+	})
+	return updated, nil
+}
 
-	// Return updated machine
-	return s.machineRepo.GetByID(ctx, id)
+// TransferToOrg moves a machine into an org, or back to the user's personal
+// machines when orgID is nil. Requires delete rights on the machine and
+// machine:create rights in the target org.
+func (s *MachineService) TransferToOrg(ctx context.Context, id, userID, orgID primitive.ObjectID) (*models.Machine, error) {
+	existing, err := s.GetForUser(ctx, id, userID, authz.ActionMachineDelete)
+	if err != nil {
+		return nil, err
+	}
+	if !orgID.IsZero() {
+		if _, err := s.authz.AuthorizeOrg(ctx, userID, orgID, authz.ActionMachineCreate); err != nil {
+			return nil, err
+		}
+	} else if existing.UserID != userID {
+		// Only the registering user can take a machine back as personal
+		return nil, authz.ErrForbidden
+	} else if err := s.authz.AuthorizePersonal(ctx, authz.ActionMachineCreate); err != nil {
+		return nil, err
+	}
+	if err := s.machineRepo.UpdateOrg(ctx, id, orgID); err != nil {
+		return nil, err
+	}
+	updated, err := s.machineRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	// Record against the new owner; the previous org is kept in the diff
+	s.audit.Record(ctx, audit.Entry{
+		Action:  audit.ActionMachineTransfer,
+		Target:  audit.MachineTarget(updated),
+		Changes: map[string]models.AuditChange{"org_id": {Before: idOrNil(existing.OrgID), After: idOrNil(orgID)}},
+	})
+	return updated, nil
 }
 
 // Delete deletes a machine
 func (s *MachineService) Delete(ctx context.Context, id primitive.ObjectID, userID primitive.ObjectID) error {
-	// Verify machine exists and belongs to user
-	existing, err := s.machineRepo.GetByID(ctx, id)
+	existing, err := s.GetForUser(ctx, id, userID, authz.ActionMachineDelete)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			return errors.New("machine not found")
-		}
 		return err
 	}
 
-	// Verify ownership
-	if existing.UserID != userID {
-		return errors.New("unauthorized: machine does not belong to user")
+	if err := s.machineRepo.Delete(ctx, id); err != nil {
+		return err
 	}
+	// The agent's client certificates must not outlive the machine
+	if err := s.certs.RevokeMachine(ctx, id); err != nil {
+		log.Printf("Failed to revoke certificates of deleted machine %s: %v", id.Hex(), err)
+	}
+	s.audit.Record(ctx, audit.Entry{
+		Action:  audit.ActionMachineDelete,
+		Target:  audit.MachineTarget(existing),
+		Changes: audit.Diff(audit.MachineFields(existing), nil),
+	})
+	return nil
+}
+
+// ReEnable moves a dead machine back to pending so its agent can reconnect.
+func (s *MachineService) ReEnable(ctx context.Context, machine *models.Machine) error {
+	if err := s.machineRepo.UpdateStatus(ctx, machine.ID, "pending"); err != nil {
+		return err
+	}
+	s.audit.Record(ctx, audit.Entry{
+		Action:  audit.ActionMachineReEnable,
+		Target:  audit.MachineTarget(machine),
+		Changes: map[string]models.AuditChange{"status": {Before: machine.Status, After: "pending"}},
+	})
+	return nil
+}
 
-	return s.machineRepo.Delete(ctx, id)
+// RotateAgentToken makes the agent receive a new token. The open stream is
+// closed so the rotation happens on the agent's immediate reconnect.
+func (s *MachineService) RotateAgentToken(ctx context.Context, id, userID primitive.ObjectID) error {
+	machine, err := s.GetForUser(ctx, id, userID, authz.ActionMachineWrite)
+	if err != nil {
+		return err
+	}
+	if err := s.machineRepo.RequestAgentTokenRotation(ctx, id); err != nil {
+		return err
+	}
+	s.connMgr.Disconnect(id.Hex())
+	s.audit.Record(ctx, audit.Entry{Action: audit.ActionAgentTokenRotate, Target: audit.MachineTarget(machine)})
+	return nil
+}
+
+// RevokeAgent invalidates the agent's token and client certificates and drops
+// its stream. The agent has to be registered again to reconnect.
+func (s *MachineService) RevokeAgent(ctx context.Context, id, userID primitive.ObjectID) error {
+	machine, err := s.GetForUser(ctx, id, userID, authz.ActionMachineDelete)
+	if err != nil {
+		return err
+	}
+	if err := s.machineRepo.ClearAgentToken(ctx, id); err != nil {
+		return err
+	}
+	if err := s.certs.RevokeMachine(ctx, id); err != nil {
+		return err
+	}
+	s.connMgr.Disconnect(id.Hex())
+	s.audit.Record(ctx, audit.Entry{Action: audit.ActionAgentRevoke, Target: audit.MachineTarget(machine)})
+	return nil
 }
 
 // UpdateStatus updates the status of a machine
@@ -114,3 +340,11 @@ func (s *MachineService) UpdateStatus(ctx context.Context, id primitive.ObjectID
 func (s *MachineService) FindByAgentID(ctx context.Context, agentID string) (*models.Machine, error) {
 	return s.machineRepo.FindByAgentID(ctx, agentID)
 }
+
+// idOrNil renders an optional ID for audit details, with nil when unset.
+func idOrNil(id primitive.ObjectID) interface{} {
+	if id.IsZero() {
+		return nil
+	}
+	return id.Hex()
+}
@@ -5,12 +5,10 @@ import (
 	"log"
 	"time"
 
+	"github.com/lute/api/models"
 	"github.com/lute/api/repository"
 )
 
-// Canonical metric keys (must match heartbeat_checker and agent).
-var machineSnapshotMetricKeys = []string{"cpu_load", "mem_usage_mb", "disk_used_gb", "disk_total_gb"}
-
 // MachineSnapshotJob runs periodically to record per-machine snapshots (status + canonical metrics).
 type MachineSnapshotJob struct {
 	machineRepo  *repository.MachineRepository
@@ -74,8 +72,8 @@ func (j *MachineSnapshotJob) runOnce(ctx context.Context) {
 
 // canonicalMetricsFrom returns a map with exactly the canonical keys (same shape as Machine.Metrics). Missing keys get 0.
 func canonicalMetricsFrom(m map[string]interface{}) map[string]interface{} {
-	out := make(map[string]interface{}, len(machineSnapshotMetricKeys))
-	for _, k := range machineSnapshotMetricKeys {
+	out := make(map[string]interface{}, len(models.SnapshotMetrics))
+	for _, k := range models.SnapshotMetrics {
 		v := 0.0
 		if m != nil {
 			if x, ok := m[k]; ok && x != nil {
@@ -0,0 +1,60 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"strings"
+
+	"github.com/lute/api/config"
+)
+
+// Mailer sends plain-text emails.
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// NewMailer returns an SMTP mailer when SMTP_HOST is configured, otherwise a
+// mailer that only logs messages (useful in development).
+func NewMailer(cfg config.MailConfig) Mailer {
+	if cfg.SMTPHost == "" {
+		log.Println("Warning: SMTP_HOST not set, emails will be logged instead of sent")
+		return &LogMailer{}
+	}
+	return &SMTPMailer{cfg: cfg}
+}
+
+// SMTPMailer sends email through an SMTP server.
+type SMTPMailer struct {
+	cfg config.MailConfig
+}
+
+func (m *SMTPMailer) Send(to, subject, body string) error {
+	addr := net.JoinHostPort(m.cfg.SMTPHost, m.cfg.SMTPPort)
+	var auth smtp.Auth
+	if m.cfg.Username != "" {
+		auth = smtp.PlainAuth("", m.cfg.Username, m.cfg.Password, m.cfg.SMTPHost)
+	}
+	msg := strings.Join([]string{
+		"From: " + m.cfg.From,
+		"To: " + to,
+		"Subject: " + subject,
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		body,
+	}, "\r\n")
+	if err := smtp.SendMail(addr, auth, m.cfg.From, []string{to}, []byte(msg)); err != nil {
+		return fmt.Errorf("send mail: %w", err)
+	}
+	return nil
+}
+
+// LogMailer writes emails to the log instead of sending them.
+type LogMailer struct{}
+
+func (m *LogMailer) Send(to, subject, body string) error {
+	log.Printf("Mail to %s: %s\n%s", to, subject, body)
+	return nil
+}
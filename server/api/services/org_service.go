@@ -0,0 +1,475 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/lute/api/audit"
+	"github.com/lute/api/authz"
+	"github.com/lute/api/config"
+	"github.com/lute/api/models"
+	"github.com/lute/api/repository"
+	"github.com/lute/api/tokens"
+)
+
+// inviteTTL is how long an organization invite stays valid.
+const inviteTTL = 7 * 24 * time.Hour
+
+var (
+	ErrOrgNotFound      = errors.New("organization not found")
+	ErrOrgNotEmpty      = errors.New("organization still owns machines")
+	ErrMemberNotFound   = errors.New("member not found")
+	ErrLastOwner        = errors.New("an organization must keep at least one owner")
+	ErrInvalidRole      = errors.New("invalid role")
+	ErrInviteNotFound   = errors.New("invite not found")
+	ErrInviteExpired    = errors.New("invite has expired")
+	ErrInviteEmail      = errors.New("invite was sent to a different email address")
+	ErrAlreadyMember    = errors.New("user is already a member of this organization")
+	ErrOrgNameRequired  = errors.New("organization name is required")
+	ErrInviteEmailEmpty = errors.New("email is required")
+)
+
+// OrgWithRole is an organization together with the caller's role in it.
+type OrgWithRole struct {
+	*models.Organization
+	Role authz.Role `json:"role"`
+}
+
+// OrgService manages organizations, their members and email invites.
+type OrgService struct {
+	cfg         *config.Config
+	orgRepo     *repository.OrganizationRepository
+	memberRepo  *repository.OrgMemberRepository
+	inviteRepo  *repository.OrgInviteRepository
+	machineRepo *repository.MachineRepository
+	userRepo    *repository.UserRepository
+	authz       *authz.Authorizer
+	mailer      Mailer
+	audit       *audit.Recorder
+}
+
+func NewOrgService(
+	cfg *config.Config,
+	orgRepo *repository.OrganizationRepository,
+	memberRepo *repository.OrgMemberRepository,
+	inviteRepo *repository.OrgInviteRepository,
+	machineRepo *repository.MachineRepository,
+	userRepo *repository.UserRepository,
+	authorizer *authz.Authorizer,
+	mailer Mailer,
+	recorder *audit.Recorder,
+) *OrgService {
+	return &OrgService{
+		cfg:         cfg,
+		orgRepo:     orgRepo,
+		memberRepo:  memberRepo,
+		inviteRepo:  inviteRepo,
+		machineRepo: machineRepo,
+		userRepo:    userRepo,
+		authz:       authorizer,
+		mailer:      mailer,
+		audit:       recorder,
+	}
+}
+
+// Create creates an organization; the creator becomes its owner.
+func (s *OrgService) Create(ctx context.Context, userID primitive.ObjectID, name string) (*models.Organization, error) {
+	if err := authz.RequireSession(ctx); err != nil {
+		return nil, err
+	}
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, ErrOrgNameRequired
+	}
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	org := &models.Organization{Name: name, CreatedBy: userID}
+	if err := s.orgRepo.Create(ctx, org); err != nil {
+		return nil, err
+	}
+	owner := &models.OrgMember{
+		OrgID:  org.ID,
+		UserID: userID,
+		Email:  user.Email,
+		Role:   string(authz.RoleOwner),
+	}
+	if err := s.memberRepo.Create(ctx, owner); err != nil {
+		_ = s.orgRepo.Delete(ctx, org.ID)
+		return nil, err
+	}
+	s.audit.Record(ctx, audit.Entry{Action: audit.ActionOrgCreate, Target: audit.OrgTarget(org.ID, org.Name)})
+	return org, nil
+}
+
+// List returns the organizations the user belongs to with their role in each.
+func (s *OrgService) List(ctx context.Context, userID primitive.ObjectID) ([]OrgWithRole, error) {
+	if err := authz.CheckScope(ctx, authz.ActionOrgRead); err != nil {
+		return nil, err
+	}
+	grant := authz.TokenGrantFromContext(ctx)
+	members, err := s.memberRepo.GetByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	roles := make(map[primitive.ObjectID]authz.Role, len(members))
+	ids := make([]primitive.ObjectID, 0, len(members))
+	for _, m := range members {
+		if grant != nil && !grant.OrgID.IsZero() && grant.OrgID != m.OrgID {
+			continue
+		}
+		roles[m.OrgID] = authz.Role(m.Role)
+		ids = append(ids, m.OrgID)
+	}
+	orgs, err := s.orgRepo.GetByIDs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]OrgWithRole, 0, len(orgs))
+	for _, org := range orgs {
+		result = append(result, OrgWithRole{Organization: org, Role: roles[org.ID]})
+	}
+	return result, nil
+}
+
+// Get returns an organization the user belongs to.
+func (s *OrgService) Get(ctx context.Context, orgID, userID primitive.ObjectID) (*OrgWithRole, error) {
+	role, err := s.authz.AuthorizeOrg(ctx, userID, orgID, authz.ActionOrgRead)
+	if err != nil {
+		return nil, err
+	}
+	org, err := s.getOrg(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	return &OrgWithRole{Organization: org, Role: role}, nil
+}
+
+// Rename changes the organization name (admin or owner).
+func (s *OrgService) Rename(ctx context.Context, orgID, userID primitive.ObjectID, name string) (*models.Organization, error) {
+	if _, err := s.authz.AuthorizeOrg(ctx, userID, orgID, authz.ActionOrgManage); err != nil {
+		return nil, err
+	}
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, ErrOrgNameRequired
+	}
+	existing, err := s.getOrg(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.orgRepo.UpdateName(ctx, orgID, name); err != nil {
+		return nil, err
+	}
+	s.audit.Record(ctx, audit.Entry{
+		Action:  audit.ActionOrgUpdate,
+		Target:  audit.OrgTarget(orgID, name),
+		Changes: audit.Diff(map[string]interface{}{"name": existing.Name}, map[string]interface{}{"name": name}),
+	})
+	return s.getOrg(ctx, orgID)
+}
+
+// Delete removes an organization (owner only). Machines must be removed or
+// transferred out first.
+func (s *OrgService) Delete(ctx context.Context, orgID, userID primitive.ObjectID) error {
+	if _, err := s.authz.AuthorizeOrg(ctx, userID, orgID, authz.ActionOrgDelete); err != nil {
+		return err
+	}
+	count, err := s.machineRepo.CountByOrgID(ctx, orgID)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrOrgNotEmpty
+	}
+	org, err := s.getOrg(ctx, orgID)
+	if err != nil {
+		return err
+	}
+	if err := s.inviteRepo.DeleteByOrgID(ctx, orgID); err != nil {
+		return err
+	}
+	if err := s.memberRepo.DeleteByOrgID(ctx, orgID); err != nil {
+		return err
+	}
+	if err := s.orgRepo.Delete(ctx, orgID); err != nil {
+		return err
+	}
+	s.audit.Record(ctx, audit.Entry{Action: audit.ActionOrgDelete, Target: audit.OrgTarget(orgID, org.Name)})
+	return nil
+}
+
+// Members lists the members of an organization.
+func (s *OrgService) Members(ctx context.Context, orgID, userID primitive.ObjectID) ([]*models.OrgMember, error) {
+	if _, err := s.authz.AuthorizeOrg(ctx, userID, orgID, authz.ActionOrgRead); err != nil {
+		return nil, err
+	}
+	return s.memberRepo.GetByOrgID(ctx, orgID)
+}
+
+// UpdateMemberRole changes a member's role. Only owners may grant or revoke
+// the owner role, and the last owner cannot be demoted.
+func (s *OrgService) UpdateMemberRole(ctx context.Context, orgID, userID, memberID primitive.ObjectID, role authz.Role) (*models.OrgMember, error) {
+	if !role.Valid() {
+		return nil, ErrInvalidRole
+	}
+	callerRole, err := s.authz.AuthorizeOrg(ctx, userID, orgID, authz.ActionOrgManage)
+	if err != nil {
+		return nil, err
+	}
+	member, err := s.getMember(ctx, orgID, memberID)
+	if err != nil {
+		return nil, err
+	}
+	current := authz.Role(member.Role)
+	if (role == authz.RoleOwner || current == authz.RoleOwner) && callerRole != authz.RoleOwner {
+		return nil, authz.ErrForbidden
+	}
+	if current == authz.RoleOwner && role != authz.RoleOwner {
+		if err := s.ensureAnotherOwner(ctx, orgID); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.memberRepo.UpdateRole(ctx, orgID, memberID, string(role)); err != nil {
+		return nil, err
+	}
+	s.audit.Record(ctx, audit.Entry{
+		Action:  audit.ActionOrgMemberRole,
+		Target:  audit.OrgTarget(orgID, ""),
+		Changes: map[string]models.AuditChange{"role": {Before: member.Role, After: string(role)}},
+		Details: map[string]interface{}{"member_id": memberID.Hex(), "email": member.Email},
+	})
+	member.Role = string(role)
+	return member, nil
+}
+
+// RemoveMember removes a member. Any member may remove themselves; removing
+// others needs org:manage, and only owners can remove owners.
+func (s *OrgService) RemoveMember(ctx context.Context, orgID, userID, memberID primitive.ObjectID) error {
+	if err := authz.RequireSession(ctx); err != nil {
+		return err
+	}
+	member, err := s.getMember(ctx, orgID, memberID)
+	if err != nil {
+		return err
+	}
+	if memberID != userID {
+		callerRole, err := s.authz.AuthorizeOrg(ctx, userID, orgID, authz.ActionOrgManage)
+		if err != nil {
+			return err
+		}
+		if member.Role == string(authz.RoleOwner) && callerRole != authz.RoleOwner {
+			return authz.ErrForbidden
+		}
+	}
+	if member.Role == string(authz.RoleOwner) {
+		if err := s.ensureAnotherOwner(ctx, orgID); err != nil {
+			return err
+		}
+	}
+	if err := s.memberRepo.Delete(ctx, orgID, memberID); err != nil {
+		return err
+	}
+	s.audit.Record(ctx, audit.Entry{
+		Action:  audit.ActionOrgMemberRemove,
+		Target:  audit.OrgTarget(orgID, ""),
+		Details: map[string]interface{}{"member_id": memberID.Hex(), "email": member.Email, "role": member.Role},
+	})
+	return nil
+}
+
+// Invite emails a single-use invite link. Only owners may invite owners.
+func (s *OrgService) Invite(ctx context.Context, orgID, userID primitive.ObjectID, email string, role authz.Role) (*models.OrgInvite, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" {
+		return nil, ErrInviteEmailEmpty
+	}
+	if !role.Valid() {
+		return nil, ErrInvalidRole
+	}
+	callerRole, err := s.authz.AuthorizeOrg(ctx, userID, orgID, authz.ActionOrgManage)
+	if err != nil {
+		return nil, err
+	}
+	if role == authz.RoleOwner && callerRole != authz.RoleOwner {
+		return nil, authz.ErrForbidden
+	}
+	org, err := s.getOrg(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := generateInviteToken()
+	if err != nil {
+		return nil, err
+	}
+	invite := &models.OrgInvite{
+		OrgID:     orgID,
+		Email:     email,
+		Role:      string(role),
+		TokenHash: tokens.Hash(token),
+		InvitedBy: userID,
+		ExpiresAt: time.Now().Add(inviteTTL),
+	}
+	if err := s.inviteRepo.Create(ctx, invite); err != nil {
+		return nil, err
+	}
+
+	link := strings.TrimRight(s.cfg.Server.PublicURL, "/") + "/invites/accept?token=" + url.QueryEscape(token)
+	body := fmt.Sprintf("You have been invited to join %q on Lute as %s.\n\nAccept the invite: %s\n\nThis link expires on %s.",
+		org.Name, role, link, invite.ExpiresAt.UTC().Format(time.RFC1123))
+	if err := s.mailer.Send(email, "Invitation to join "+org.Name+" on Lute", body); err != nil {
+		log.Printf("Failed to send invite email to %s: %v", email, err)
+		_ = s.inviteRepo.Delete(ctx, invite.ID)
+		return nil, err
+	}
+	s.audit.Record(ctx, audit.Entry{
+		Action:  audit.ActionOrgInviteCreate,
+		Target:  audit.OrgTarget(orgID, org.Name),
+		Details: map[string]interface{}{"invite_id": invite.ID.Hex(), "email": email, "role": string(role)},
+	})
+	return invite, nil
+}
+
+// Invites lists the pending invites of an organization.
+func (s *OrgService) Invites(ctx context.Context, orgID, userID primitive.ObjectID) ([]*models.OrgInvite, error) {
+	if _, err := s.authz.AuthorizeOrg(ctx, userID, orgID, authz.ActionOrgManage); err != nil {
+		return nil, err
+	}
+	return s.inviteRepo.GetPendingByOrgID(ctx, orgID)
+}
+
+// RevokeInvite deletes a pending invite.
+func (s *OrgService) RevokeInvite(ctx context.Context, orgID, userID, inviteID primitive.ObjectID) error {
+	if _, err := s.authz.AuthorizeOrg(ctx, userID, orgID, authz.ActionOrgManage); err != nil {
+		return err
+	}
+	invite, err := s.inviteRepo.GetByID(ctx, inviteID)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return ErrInviteNotFound
+		}
+		return err
+	}
+	if invite.OrgID != orgID {
+		return ErrInviteNotFound
+	}
+	if err := s.inviteRepo.Delete(ctx, inviteID); err != nil {
+		return err
+	}
+	s.audit.Record(ctx, audit.Entry{
+		Action:  audit.ActionOrgInviteRevoke,
+		Target:  audit.OrgTarget(orgID, ""),
+		Details: map[string]interface{}{"invite_id": inviteID.Hex(), "email": invite.Email},
+	})
+	return nil
+}
+
+// AcceptInvite adds the user to the invite's organization. The user's email
+// must match the address the invite was sent to.
+func (s *OrgService) AcceptInvite(ctx context.Context, userID primitive.ObjectID, token string) (*models.OrgMember, error) {
+	if err := authz.RequireSession(ctx); err != nil {
+		return nil, err
+	}
+	invite, err := s.inviteRepo.GetByTokenHash(ctx, tokens.Hash(token))
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrInviteNotFound
+		}
+		return nil, err
+	}
+	if invite.AcceptedAt != nil {
+		return nil, ErrInviteNotFound
+	}
+	if time.Now().After(invite.ExpiresAt) {
+		return nil, ErrInviteExpired
+	}
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(strings.TrimSpace(user.Email), invite.Email) {
+		return nil, ErrInviteEmail
+	}
+	if role, err := s.authz.OrgRole(ctx, userID, invite.OrgID); err != nil {
+		return nil, err
+	} else if role != "" {
+		return nil, ErrAlreadyMember
+	}
+	if err := s.inviteRepo.MarkAccepted(ctx, invite.ID); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrInviteNotFound
+		}
+		return nil, err
+	}
+	member := &models.OrgMember{
+		OrgID:  invite.OrgID,
+		UserID: userID,
+		Email:  user.Email,
+		Role:   invite.Role,
+	}
+	if err := s.memberRepo.Create(ctx, member); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrAlreadyMember
+		}
+		return nil, err
+	}
+	s.audit.Record(ctx, audit.Entry{
+		Action:  audit.ActionOrgInviteAccept,
+		Target:  audit.OrgTarget(invite.OrgID, ""),
+		Details: map[string]interface{}{"invite_id": invite.ID.Hex(), "role": invite.Role},
+	})
+	return member, nil
+}
+
+func (s *OrgService) getOrg(ctx context.Context, orgID primitive.ObjectID) (*models.Organization, error) {
+	org, err := s.orgRepo.GetByID(ctx, orgID)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrOrgNotFound
+		}
+		return nil, err
+	}
+	return org, nil
+}
+
+func (s *OrgService) getMember(ctx context.Context, orgID, memberID primitive.ObjectID) (*models.OrgMember, error) {
+	member, err := s.memberRepo.Get(ctx, orgID, memberID)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrMemberNotFound
+		}
+		return nil, err
+	}
+	return member, nil
+}
+
+func (s *OrgService) ensureAnotherOwner(ctx context.Context, orgID primitive.ObjectID) error {
+	owners, err := s.memberRepo.CountByRole(ctx, orgID, string(authz.RoleOwner))
+	if err != nil {
+		return err
+	}
+	if owners <= 1 {
+		return ErrLastOwner
+	}
+	return nil
+}
+
+func generateInviteToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,136 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lute/api/models"
+	"github.com/lute/api/repository"
+)
+
+const (
+	scheduleTickInterval = 15 * time.Second
+	scheduleRunTimeout   = time.Minute
+	// scheduleDueBatch caps the schedules run in one round.
+	scheduleDueBatch = 100
+	// scheduleMissedAfter is how late a run may be before it counts as
+	// missed and the schedule's missed-run policy applies.
+	scheduleMissedAfter = 5 * time.Minute
+	// maxMissedRuns bounds the count of missed runs folded into one.
+	maxMissedRuns = 10000
+)
+
+// ScheduleRunner makes the runs of schedules as they fall due. Every API
+// instance runs one: a run is claimed by moving the schedule's next run on
+// with a conditional update, so exactly one runner makes it. A runner that
+// stops between claiming a run and starting it loses that run.
+type ScheduleRunner struct {
+	scheduleRepo *repository.ScheduleRepository
+	runRepo      *repository.ScheduleRunRepository
+	// Fire creates the command or job of a run and records it in run.
+	Fire func(ctx context.Context, schedule *models.Schedule, run *models.ScheduleRun) error
+}
+
+func NewScheduleRunner(scheduleRepo *repository.ScheduleRepository, runRepo *repository.ScheduleRunRepository) *ScheduleRunner {
+	return &ScheduleRunner{
+		scheduleRepo: scheduleRepo,
+		runRepo:      runRepo,
+	}
+}
+
+// Run makes due runs until ctx is cancelled. Call from a goroutine.
+func (r *ScheduleRunner) Run(ctx context.Context) {
+	ticker := time.NewTicker(scheduleTickInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.runDue(ctx)
+		}
+	}
+}
+
+func (r *ScheduleRunner) runDue(ctx context.Context) {
+	now := time.Now().UTC()
+	schedules, err := r.scheduleRepo.GetDue(ctx, now, scheduleDueBatch)
+	if err != nil {
+		log.Printf("Schedules: failed to load due schedules: %v", err)
+		return
+	}
+	for _, schedule := range schedules {
+		sctx, cancel := context.WithTimeout(ctx, scheduleRunTimeout)
+		r.run(sctx, schedule, now)
+		cancel()
+	}
+}
+
+// run claims the due run of a schedule and makes it, or skips it when it
+// was missed and the schedule skips missed runs. Later runs that fell due
+// by now are folded into it.
+func (r *ScheduleRunner) run(ctx context.Context, schedule *models.Schedule, now time.Time) {
+	due := *schedule.NextRunAt
+	sched, loc, err := parseSchedule(schedule.Cron, schedule.Timezone)
+	if err != nil {
+		// Stored schedules were validated; stop rather than retry every round
+		if _, err := r.scheduleRepo.Claim(ctx, schedule.ID, due, nil); err != nil {
+			log.Printf("Schedules: failed to stop schedule %s: %v", schedule.ID.Hex(), err)
+		}
+		log.Printf("Schedules: stopped schedule %s: %v", schedule.ID.Hex(), err)
+		return
+	}
+	missed := 0
+	t := sched.Next(due.In(loc))
+	for !t.IsZero() && !t.After(now) {
+		if missed++; missed == maxMissedRuns {
+			t = sched.Next(now.In(loc))
+			break
+		}
+		t = sched.Next(t)
+	}
+	var next *time.Time
+	if !t.IsZero() {
+		t = t.UTC()
+		next = &t
+	}
+
+	claimed, err := r.scheduleRepo.Claim(ctx, schedule.ID, due, next)
+	if err != nil {
+		log.Printf("Schedules: failed to claim schedule %s: %v", schedule.ID.Hex(), err)
+		return
+	}
+	if !claimed {
+		// Run by another instance, or changed since it was loaded
+		return
+	}
+
+	run := &models.ScheduleRun{
+		ScheduleID:   schedule.ID,
+		ScheduledFor: due,
+		At:           now,
+		Missed:       missed,
+	}
+	late := now.Sub(due)
+	switch {
+	case late > scheduleMissedAfter && schedule.MissedRuns != models.ScheduleMissedRunOnce:
+		run.Status = models.ScheduleRunSkipped
+		run.Error = fmt.Sprintf("missed by %s", late.Round(time.Second))
+	default:
+		if err := r.Fire(ctx, schedule, run); err != nil {
+			run.Status = models.ScheduleRunFailed
+			run.Error = err.Error()
+			log.Printf("Schedules: run of schedule %s failed: %v", schedule.ID.Hex(), err)
+		} else {
+			run.Status = models.ScheduleRunStarted
+		}
+	}
+	if err := r.runRepo.Create(ctx, run); err != nil {
+		log.Printf("Schedules: failed to record the run of schedule %s: %v", schedule.ID.Hex(), err)
+	}
+	if err := r.scheduleRepo.SetLastRunStatus(ctx, schedule.ID, run.Status); err != nil {
+		log.Printf("Schedules: failed to update schedule %s: %v", schedule.ID.Hex(), err)
+	}
+}
@@ -0,0 +1,422 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/lute/api/audit"
+	"github.com/lute/api/authz"
+	"github.com/lute/api/cron"
+	"github.com/lute/api/models"
+	"github.com/lute/api/repository"
+)
+
+const (
+	// ScheduleRunLimit caps the runs returned by Runs.
+	ScheduleRunLimit = 100
+	// SchedulePreviewMax caps the times returned by Preview.
+	SchedulePreviewMax     = 50
+	schedulePreviewDefault = 5
+)
+
+var (
+	ErrScheduleNotFound = errors.New("schedule not found")
+	ErrInvalidSchedule  = errors.New("invalid schedule")
+)
+
+// ScheduleInput describes a schedule to create, or the new settings of one.
+// A schedule runs its action on MachineID, or else as a job on the machines
+// matching Selector and GroupID.
+type ScheduleInput struct {
+	OrgID             primitive.ObjectID // zero for the creator's personal machines; ignored on update
+	Name              string             // default: the command, e.g. "systemctl restart nginx.service"
+	Cron              string
+	Timezone          string // default "UTC"
+	Enabled           bool
+	MissedRuns        string // models.ScheduleMissedSkip (default) or models.ScheduleMissedRunOnce
+	Action            string
+	Input             ActionInput
+	MachineID         primitive.ObjectID
+	Selector          string
+	GroupID           primitive.ObjectID // must be the creator's group
+	Concurrency       int
+	BatchSize         int
+	StopAfterFailures int
+	Offline           string
+}
+
+// ScheduleService manages schedules and makes their runs for the
+// ScheduleRunner: a command through the ActionService, or a job through the
+// JobService, on behalf of the schedule's creator.
+type ScheduleService struct {
+	scheduleRepo *repository.ScheduleRepository
+	runRepo      *repository.ScheduleRunRepository
+	machines     *MachineService
+	actions      *ActionService
+	jobs         *JobService
+	tokens       *APITokenService
+	authz        *authz.Authorizer
+	audit        *audit.Recorder
+}
+
+func NewScheduleService(
+	scheduleRepo *repository.ScheduleRepository,
+	runRepo *repository.ScheduleRunRepository,
+	machines *MachineService,
+	actions *ActionService,
+	jobs *JobService,
+	tokens *APITokenService,
+	authorizer *authz.Authorizer,
+	recorder *audit.Recorder,
+) *ScheduleService {
+	return &ScheduleService{
+		scheduleRepo: scheduleRepo,
+		runRepo:      runRepo,
+		machines:     machines,
+		actions:      actions,
+		jobs:         jobs,
+		tokens:       tokens,
+		authz:        authorizer,
+		audit:        recorder,
+	}
+}
+
+// Create adds a schedule. It first fires at the next time its cron
+// expression matches.
+func (s *ScheduleService) Create(ctx context.Context, userID primitive.ObjectID, in ScheduleInput) (*models.Schedule, error) {
+	if err := s.authorize(ctx, userID, in.OrgID); err != nil {
+		return nil, err
+	}
+	schedule := &models.Schedule{UserID: userID, OrgID: in.OrgID}
+	if err := s.applyInput(ctx, schedule, in, time.Now()); err != nil {
+		return nil, err
+	}
+	if err := s.scheduleRepo.Create(ctx, schedule); err != nil {
+		return nil, err
+	}
+	s.audit.Record(ctx, audit.Entry{
+		Action:  audit.ActionScheduleCreate,
+		Target:  audit.ScheduleTarget(schedule),
+		Details: scheduleDetails(schedule),
+	})
+	return schedule, nil
+}
+
+// List returns the user's personal schedules and those of orgs where they
+// may run commands, newest first.
+func (s *ScheduleService) List(ctx context.Context, userID primitive.ObjectID) ([]*models.Schedule, error) {
+	if err := authz.CheckScope(ctx, authz.ActionCommandExecute); err != nil {
+		return nil, err
+	}
+	orgIDs, err := s.authz.OrgIDsAllowing(ctx, userID, authz.ActionCommandExecute)
+	if err != nil {
+		return nil, err
+	}
+	schedules, err := s.scheduleRepo.GetVisible(ctx, userID, orgIDs)
+	if err != nil {
+		return nil, err
+	}
+	if schedules == nil {
+		schedules = []*models.Schedule{}
+	}
+	return schedules, nil
+}
+
+// Get returns a schedule the user may see.
+func (s *ScheduleService) Get(ctx context.Context, userID, id primitive.ObjectID) (*models.Schedule, error) {
+	return s.getForUser(ctx, userID, id)
+}
+
+// Update replaces the settings of a schedule. Its next run is worked out
+// again from now, so runs that fell due before are not made.
+func (s *ScheduleService) Update(ctx context.Context, userID, id primitive.ObjectID, in ScheduleInput) (*models.Schedule, error) {
+	schedule, err := s.getForUser(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+	previous := scheduleDetails(schedule)
+	if err := s.applyInput(ctx, schedule, in, time.Now()); err != nil {
+		return nil, err
+	}
+	if err := s.scheduleRepo.Update(ctx, schedule); err != nil {
+		return nil, err
+	}
+	s.audit.Record(ctx, audit.Entry{
+		Action:  audit.ActionScheduleUpdate,
+		Target:  audit.ScheduleTarget(schedule),
+		Changes: audit.Diff(previous, scheduleDetails(schedule)),
+	})
+	return schedule, nil
+}
+
+// Delete removes a schedule and its history. Commands and jobs it started
+// are kept.
+func (s *ScheduleService) Delete(ctx context.Context, userID, id primitive.ObjectID) error {
+	schedule, err := s.getForUser(ctx, userID, id)
+	if err != nil {
+		return err
+	}
+	if err := s.scheduleRepo.Delete(ctx, id); err != nil {
+		return err
+	}
+	if err := s.runRepo.DeleteBySchedule(ctx, id); err != nil {
+		return err
+	}
+	s.audit.Record(ctx, audit.Entry{
+		Action:  audit.ActionScheduleDelete,
+		Target:  audit.ScheduleTarget(schedule),
+		Details: scheduleDetails(schedule),
+	})
+	return nil
+}
+
+// Runs returns the latest runs of a schedule, latest first.
+func (s *ScheduleService) Runs(ctx context.Context, userID, id primitive.ObjectID) ([]*models.ScheduleRun, error) {
+	if _, err := s.getForUser(ctx, userID, id); err != nil {
+		return nil, err
+	}
+	runs, err := s.runRepo.GetBySchedule(ctx, id, ScheduleRunLimit)
+	if err != nil {
+		return nil, err
+	}
+	if runs == nil {
+		runs = []*models.ScheduleRun{}
+	}
+	return runs, nil
+}
+
+// Preview returns the next count times (default 5, at most
+// SchedulePreviewMax) after now at which a cron expression fires in a time
+// zone.
+func (s *ScheduleService) Preview(expr, timezone string, count int) ([]time.Time, error) {
+	if count <= 0 {
+		count = schedulePreviewDefault
+	}
+	if count > SchedulePreviewMax {
+		count = SchedulePreviewMax
+	}
+	sched, loc, err := parseSchedule(expr, timezone)
+	if err != nil {
+		return nil, err
+	}
+	times := sched.NextN(time.Now().In(loc), count)
+	if len(times) == 0 {
+		return nil, fmt.Errorf("%w: the cron expression never fires", ErrInvalidSchedule)
+	}
+	return times, nil
+}
+
+// Fire makes a run of a schedule on behalf of its creator, who must still be
+// allowed to run commands on its machines, and records the command or job
+// created in run. A schedule set up with an API token runs within that
+// token's organization and scopes, and stops running once it is revoked or
+// expired.
+func (s *ScheduleService) Fire(ctx context.Context, schedule *models.Schedule, run *models.ScheduleRun) error {
+	if !schedule.TokenID.IsZero() {
+		grant, err := s.tokens.GrantOf(ctx, schedule.TokenID)
+		if err != nil {
+			return fmt.Errorf("API token of the schedule: %w", err)
+		}
+		ctx = authz.WithTokenGrant(ctx, grant)
+	}
+	input := ActionInput{
+		Params:         schedule.Params,
+		Args:           schedule.Args,
+		TimeoutSeconds: schedule.TimeoutSeconds,
+	}
+	if !schedule.MachineID.IsZero() {
+		cmd, err := s.actions.Request(ctx, schedule.MachineID, schedule.UserID, schedule.Action, input)
+		if err != nil {
+			return err
+		}
+		run.CommandID = cmd.ID
+		return nil
+	}
+	job, err := s.jobs.Create(ctx, schedule.UserID, CreateJobInput{
+		OrgID:             schedule.OrgID,
+		Name:              schedule.Name,
+		Action:            schedule.Action,
+		Input:             input,
+		Selector:          schedule.Selector,
+		GroupID:           schedule.GroupID,
+		Concurrency:       schedule.Concurrency,
+		BatchSize:         schedule.BatchSize,
+		StopAfterFailures: schedule.StopAfterFailures,
+		Offline:           schedule.Offline,
+	})
+	if err != nil {
+		return err
+	}
+	run.JobID = job.ID
+	return nil
+}
+
+func (s *ScheduleService) getForUser(ctx context.Context, userID, id primitive.ObjectID) (*models.Schedule, error) {
+	schedule, err := s.scheduleRepo.GetByID(ctx, id)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrScheduleNotFound
+		}
+		return nil, err
+	}
+	if schedule.OrgID.IsZero() && schedule.UserID != userID {
+		return nil, ErrScheduleNotFound
+	}
+	if err := s.authorize(ctx, userID, schedule.OrgID); err != nil {
+		return nil, err
+	}
+	return schedule, nil
+}
+
+func (s *ScheduleService) authorize(ctx context.Context, userID, orgID primitive.ObjectID) error {
+	if orgID.IsZero() {
+		return s.authz.AuthorizePersonal(ctx, authz.ActionCommandExecute)
+	}
+	_, err := s.authz.AuthorizeOrg(ctx, userID, orgID, authz.ActionCommandExecute)
+	return err
+}
+
+// applyInput validates in and copies it onto schedule, working out its next
+// run after now. The target is checked against the schedule's owner.
+func (s *ScheduleService) applyInput(ctx context.Context, schedule *models.Schedule, in ScheduleInput, now time.Time) error {
+	if !slices.Contains(Actions, in.Action) {
+		return ErrUnknownAction
+	}
+	description, err := validateAction(in.Action, in.Input)
+	if err != nil {
+		return err
+	}
+	if in.Timezone == "" {
+		in.Timezone = "UTC"
+	}
+	sched, loc, err := parseSchedule(in.Cron, in.Timezone)
+	if err != nil {
+		return err
+	}
+	if in.MissedRuns == "" {
+		in.MissedRuns = models.ScheduleMissedSkip
+	}
+	if in.MissedRuns != models.ScheduleMissedSkip && in.MissedRuns != models.ScheduleMissedRunOnce {
+		return fmt.Errorf("%w: missed_runs must be %q or %q", ErrInvalidSchedule, models.ScheduleMissedSkip, models.ScheduleMissedRunOnce)
+	}
+	name := strings.TrimSpace(in.Name)
+	if name == "" {
+		name = description
+	}
+	if len(name) > 200 {
+		return fmt.Errorf("%w: name is longer than 200 characters", ErrInvalidSchedule)
+	}
+
+	if !in.MachineID.IsZero() {
+		if in.Selector != "" || !in.GroupID.IsZero() {
+			return fmt.Errorf("%w: machine_id cannot be combined with a selector or group_id", ErrInvalidSchedule)
+		}
+		machine, err := s.machines.GetForUser(ctx, in.MachineID, schedule.UserID, authz.ActionCommandExecute)
+		if err != nil {
+			return err
+		}
+		if machine.OrgID != schedule.OrgID {
+			return fmt.Errorf("%w: the machine does not belong to the schedule's owner", ErrInvalidSchedule)
+		}
+		in.Concurrency, in.BatchSize, in.StopAfterFailures, in.Offline = 0, 0, 0, ""
+	} else {
+		job := CreateJobInput{
+			OrgID:             schedule.OrgID,
+			Selector:          in.Selector,
+			GroupID:           in.GroupID,
+			Concurrency:       in.Concurrency,
+			BatchSize:         in.BatchSize,
+			StopAfterFailures: in.StopAfterFailures,
+			Offline:           in.Offline,
+		}
+		if job.Selector == "" && job.GroupID.IsZero() {
+			return fmt.Errorf("%w: a machine_id, selector or group_id is required", ErrInvalidSchedule)
+		}
+		if err := validateJobInput(&job); err != nil {
+			return err
+		}
+		if _, err := s.jobs.machineFilter(ctx, schedule.UserID, job); err != nil {
+			return err
+		}
+		in.Offline = job.Offline
+	}
+
+	var next *time.Time
+	if in.Enabled {
+		t := sched.Next(now.In(loc))
+		if t.IsZero() {
+			return fmt.Errorf("%w: the cron expression never fires", ErrInvalidSchedule)
+		}
+		t = t.UTC()
+		next = &t
+	}
+
+	schedule.Name = name
+	schedule.Cron = strings.TrimSpace(in.Cron)
+	schedule.Timezone = loc.String()
+	schedule.Enabled = in.Enabled
+	schedule.MissedRuns = in.MissedRuns
+	schedule.Action = in.Action
+	schedule.Params = in.Input.Params
+	schedule.Args = in.Input.Args
+	schedule.TimeoutSeconds = in.Input.TimeoutSeconds
+	schedule.MachineID = in.MachineID
+	schedule.Selector = in.Selector
+	schedule.GroupID = in.GroupID
+	schedule.Concurrency = in.Concurrency
+	schedule.BatchSize = in.BatchSize
+	schedule.StopAfterFailures = in.StopAfterFailures
+	schedule.Offline = in.Offline
+	schedule.NextRunAt = next
+	// Runs keep the limits of the API token used, even after changes made
+	// in a session
+	if grant := authz.TokenGrantFromContext(ctx); grant != nil {
+		schedule.TokenID = grant.TokenID
+	}
+	return nil
+}
+
+// parseSchedule parses a cron expression and loads its time zone.
+func parseSchedule(expr, timezone string) (*cron.Schedule, *time.Location, error) {
+	sched, err := cron.Parse(expr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidSchedule, err)
+	}
+	if timezone == "" || timezone == "Local" {
+		return nil, nil, fmt.Errorf("%w: timezone must be an IANA time zone such as \"Europe/Berlin\" or \"UTC\"", ErrInvalidSchedule)
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: unknown timezone %q", ErrInvalidSchedule, timezone)
+	}
+	return sched, loc, nil
+}
+
+// scheduleDetails returns the settings of a schedule for the audit log.
+func scheduleDetails(schedule *models.Schedule) map[string]interface{} {
+	return map[string]interface{}{
+		"name":                schedule.Name,
+		"cron":                schedule.Cron,
+		"timezone":            schedule.Timezone,
+		"enabled":             schedule.Enabled,
+		"missed_runs":         schedule.MissedRuns,
+		"action":              schedule.Action,
+		"params":              schedule.Params,
+		"args":                schedule.Args,
+		"timeout_seconds":     schedule.TimeoutSeconds,
+		"machine_id":          schedule.MachineID,
+		"selector":            schedule.Selector,
+		"group_id":            schedule.GroupID,
+		"concurrency":         schedule.Concurrency,
+		"batch_size":          schedule.BatchSize,
+		"stop_after_failures": schedule.StopAfterFailures,
+		"offline":             schedule.Offline,
+	}
+}
@@ -0,0 +1,100 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/lute/api/repository"
+)
+
+const (
+	// rollupInterval is how often the rollups are brought up to date.
+	rollupInterval = time.Minute
+	// rollupChunk is the longest period one aggregation covers when the
+	// job catches up, e.g. on its first run.
+	rollupChunk = 24 * time.Hour
+)
+
+// SnapshotRollupJob downsamples machine snapshots into per-minute rollups,
+// and those into per-hour ones, so charts of longer periods need not read
+// every snapshot and outlive the snapshots' retention.
+type SnapshotRollupJob struct {
+	snapshotRepo    *repository.MachineSnapshotRepository
+	rawRetention    time.Duration
+	minuteRetention time.Duration
+}
+
+// NewSnapshotRollupJob creates a new SnapshotRollupJob. rawRetention and
+// minuteRetention are how long snapshots and per-minute rollups are kept,
+// which bounds how far back the first run starts.
+func NewSnapshotRollupJob(snapshotRepo *repository.MachineSnapshotRepository, rawRetention, minuteRetention time.Duration) *SnapshotRollupJob {
+	return &SnapshotRollupJob{
+		snapshotRepo:    snapshotRepo,
+		rawRetention:    rawRetention,
+		minuteRetention: minuteRetention,
+	}
+}
+
+// Run runs the job in a loop until ctx is cancelled. Call from a goroutine.
+func (j *SnapshotRollupJob) Run(ctx context.Context) {
+	ticker := time.NewTicker(rollupInterval)
+	defer ticker.Stop()
+
+	j.runOnce(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			j.runOnce(ctx)
+		}
+	}
+}
+
+// runOnce computes the rollups from the newest one of each resolution,
+// which may have been computed before its period ended, up to now.
+func (j *SnapshotRollupJob) runOnce(ctx context.Context) {
+	now := time.Now()
+	for _, r := range []struct {
+		res       string
+		retention time.Duration // of the rollups' source
+	}{
+		{repository.ResolutionMinute, j.rawRetention},
+		{repository.ResolutionHour, j.minuteRetention},
+	} {
+		from, err := j.snapshotRepo.LatestRollup(ctx, r.res)
+		if err != nil {
+			log.Printf("snapshot rollup: find latest %s rollup: %v", r.res, err)
+			return
+		}
+		if oldest := now.Add(-r.retention); from.Before(oldest) {
+			from = oldest
+		}
+		for !from.After(now) {
+			to := from.Add(rollupChunk)
+			if to.After(now) {
+				to = now
+			}
+			if err := j.snapshotRepo.Rollup(ctx, r.res, from, to); err != nil {
+				log.Printf("snapshot rollup: compute %s rollups from %s: %v", r.res, from.Format(time.RFC3339), err)
+				return
+			}
+			from = to.Add(time.Nanosecond)
+		}
+	}
+}
+
+// RollupRange recomputes the rollups of a machine for the periods from
+// from to to, e.g. after snapshots sampled by its agent while offline were
+// stored.
+func (j *SnapshotRollupJob) RollupRange(ctx context.Context, machineID primitive.ObjectID, from, to time.Time) error {
+	for _, res := range []string{repository.ResolutionMinute, repository.ResolutionHour} {
+		if err := j.snapshotRepo.Rollup(ctx, res, from, to, machineID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
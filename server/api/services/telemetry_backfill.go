@@ -0,0 +1,111 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	pb "github.com/lute/agent/proto/agent"
+	"github.com/lute/api/models"
+	"github.com/lute/api/repository"
+)
+
+const (
+	backfillWriteTimeout = 30 * time.Second
+	// backfillClockSkew is how far in the future a sample may be stamped.
+	backfillClockSkew = 5 * time.Minute
+)
+
+// TelemetryBackfill stores metric samples an agent buffered while it could
+// not reach the server, so the machine's history has no gap for the outage,
+// and updates the rollups covering them.
+type TelemetryBackfill struct {
+	snapshotRepo *repository.MachineSnapshotRepository
+	rollups      *SnapshotRollupJob
+	// maxAge is the snapshots' retention; older samples would be expired
+	// right away.
+	maxAge time.Duration
+}
+
+func NewTelemetryBackfill(snapshotRepo *repository.MachineSnapshotRepository, rollups *SnapshotRollupJob, maxAge time.Duration) *TelemetryBackfill {
+	return &TelemetryBackfill{snapshotRepo: snapshotRepo, rollups: rollups, maxAge: maxAge}
+}
+
+// HandleMessage handles agent messages that are not replies to the server;
+// it answers a Backfill with a BackfillAck once the samples are stored. No
+// ack is sent when storing fails, so the agent keeps the samples and sends
+// them again on its next connection.
+func (b *TelemetryBackfill) HandleMessage(machineID string, msg *pb.AgentMessage) *pb.ServerMessage {
+	backfill := msg.GetBackfill()
+	if backfill == nil {
+		return nil
+	}
+	mid, err := primitive.ObjectIDFromHex(machineID)
+	if err != nil {
+		return nil
+	}
+	lastSeq, err := b.store(mid, backfill.GetSamples())
+	if err != nil {
+		log.Printf("TelemetryBackfill: failed to store samples of machine %s: %v", machineID, err)
+		return nil
+	}
+	return &pb.ServerMessage{
+		Payload: &pb.ServerMessage_BackfillAck{
+			BackfillAck: &pb.BackfillAck{LastSeq: lastSeq},
+		},
+	}
+}
+
+// store writes the usable samples, recomputes the rollups they fall in and
+// returns the highest sequence number of the batch. Samples with timestamps
+// out of range are acknowledged but dropped, since sending them again would
+// not help.
+func (b *TelemetryBackfill) store(machineID primitive.ObjectID, samples []*pb.Sample) (uint64, error) {
+	now := time.Now()
+	var lastSeq uint64
+	snapshots := make([]*models.MachineSnapshot, 0, len(samples))
+	for _, s := range samples {
+		if s.GetSeq() > lastSeq {
+			lastSeq = s.GetSeq()
+		}
+		at := time.Unix(s.GetTimestamp(), 0).UTC()
+		if at.After(now.Add(backfillClockSkew)) || at.Before(now.Add(-b.maxAge)) {
+			continue
+		}
+		metrics := metricValueMapToInterface(s.GetMetrics())
+		if metrics == nil {
+			continue
+		}
+		snapshots = append(snapshots, &models.MachineSnapshot{
+			MachineID: machineID,
+			At:        at,
+			Metrics:   canonicalMetricsFrom(metrics),
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), backfillWriteTimeout)
+	defer cancel()
+	if err := b.snapshotRepo.InsertBackfill(ctx, snapshots); err != nil {
+		return 0, err
+	}
+	if len(snapshots) > 0 {
+		from, to := snapshots[0].At, snapshots[0].At
+		for _, s := range snapshots[1:] {
+			if s.At.Before(from) {
+				from = s.At
+			}
+			if s.At.After(to) {
+				to = s.At
+			}
+		}
+		if err := b.rollups.RollupRange(ctx, machineID, from, to); err != nil {
+			return 0, err
+		}
+	}
+	if len(samples) > 0 {
+		log.Printf("TelemetryBackfill: stored %d of %d buffered samples from machine %s", len(snapshots), len(samples), machineID.Hex())
+	}
+	return lastSeq, nil
+}
@@ -0,0 +1,75 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	pb "github.com/lute/agent/proto/agent"
+	"github.com/lute/api/models"
+	"github.com/lute/api/repository"
+)
+
+const unitWriteTimeout = 30 * time.Second
+
+// UnitRecorder stores the systemd unit reports agents send.
+type UnitRecorder struct {
+	unitsRepo *repository.MachineUnitsRepository
+
+	// OnStored, if set, is called after a report was stored.
+	OnStored func(units *models.MachineUnits)
+}
+
+func NewUnitRecorder(unitsRepo *repository.MachineUnitsRepository) *UnitRecorder {
+	return &UnitRecorder{unitsRepo: unitsRepo}
+}
+
+// HandleMessage stores a UnitReport. It never replies.
+func (r *UnitRecorder) HandleMessage(machineID string, msg *pb.AgentMessage) *pb.ServerMessage {
+	report := msg.GetUnits()
+	if report == nil {
+		return nil
+	}
+	mid, err := primitive.ObjectIDFromHex(machineID)
+	if err != nil {
+		return nil
+	}
+	units := &models.MachineUnits{
+		MachineID:   mid,
+		Hash:        report.GetHash(),
+		Systemd:     report.GetSystemd(),
+		CollectedAt: time.Unix(report.GetCollectedAt(), 0).UTC(),
+		ReceivedAt:  time.Now().UTC(),
+		Units:       make([]models.SystemdUnit, 0, len(report.GetUnits())),
+	}
+	for _, u := range report.GetUnits() {
+		unit := models.SystemdUnit{
+			Name:        u.GetName(),
+			Description: u.GetDescription(),
+			LoadState:   u.GetLoadState(),
+			ActiveState: u.GetActiveState(),
+			SubState:    u.GetSubState(),
+			Result:      u.GetResult(),
+			Restarts:    u.GetRestarts(),
+			MainPID:     u.GetMainPid(),
+		}
+		if ts := u.GetStateChangedAt(); ts > 0 {
+			t := time.Unix(ts, 0).UTC()
+			unit.StateChangedAt = &t
+		}
+		units.Units = append(units.Units, unit)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), unitWriteTimeout)
+	defer cancel()
+	if err := r.unitsRepo.Replace(ctx, units); err != nil {
+		log.Printf("Units: failed to store units of machine %s: %v", machineID, err)
+		return nil
+	}
+	if r.OnStored != nil {
+		r.OnStored(units)
+	}
+	return nil
+}
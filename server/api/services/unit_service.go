@@ -0,0 +1,77 @@
+package services
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/lute/api/authz"
+	"github.com/lute/api/models"
+	"github.com/lute/api/repository"
+)
+
+var ErrUnitsNotFound = errors.New("machine has not reported its units")
+
+// UnitService answers questions about the systemd units agents reported
+// (see UnitRecorder).
+type UnitService struct {
+	unitsRepo *repository.MachineUnitsRepository
+	machines  *MachineService
+}
+
+func NewUnitService(unitsRepo *repository.MachineUnitsRepository, machines *MachineService) *UnitService {
+	return &UnitService{unitsRepo: unitsRepo, machines: machines}
+}
+
+// FailedUnit is a failed unit with its machine.
+type FailedUnit struct {
+	MachineID   primitive.ObjectID `json:"machine_id"`
+	MachineName string             `json:"machine_name"`
+	models.SystemdUnit
+}
+
+// ForMachine returns the units of a machine the user may read.
+func (s *UnitService) ForMachine(ctx context.Context, machineID, userID primitive.ObjectID) (*models.MachineUnits, error) {
+	if _, err := s.machines.GetForUser(ctx, machineID, userID, authz.ActionMachineRead); err != nil {
+		return nil, err
+	}
+	units, err := s.unitsRepo.Get(ctx, machineID)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrUnitsNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if units.Units == nil {
+		units.Units = []models.SystemdUnit{}
+	}
+	return units, nil
+}
+
+// Failed returns the failed units of the machines visible to the user that
+// match filter.
+func (s *UnitService) Failed(ctx context.Context, userID primitive.ObjectID, filter MachineFilter) ([]FailedUnit, error) {
+	machines, err := s.machines.GetByUserIDFiltered(ctx, userID, filter)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]primitive.ObjectID, 0, len(machines))
+	names := make(map[primitive.ObjectID]string, len(machines))
+	for _, m := range machines {
+		ids = append(ids, m.ID)
+		names[m.ID] = m.Name
+	}
+	matches, err := s.unitsRepo.FindFailed(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	out := []FailedUnit{}
+	for _, m := range matches {
+		for _, u := range m.Units {
+			out = append(out, FailedUnit{MachineID: m.MachineID, MachineName: names[m.MachineID], SystemdUnit: u})
+		}
+	}
+	return out, nil
+}
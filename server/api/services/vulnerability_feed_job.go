@@ -0,0 +1,122 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/lute/api/advisory"
+	"github.com/lute/api/models"
+	"github.com/lute/api/repository"
+)
+
+// advisoryBatchSize is the number of advisories written per bulk write.
+const advisoryBatchSize = 500
+
+// VulnerabilityFeedJob imports the offline advisory feed when its files
+// change, then rescans every machine.
+type VulnerabilityFeedJob struct {
+	paths        []string
+	interval     time.Duration
+	advisoryRepo *repository.AdvisoryRepository
+	scanner      *VulnerabilityScanner
+}
+
+// NewVulnerabilityFeedJob creates a job importing the OSV files or
+// directories in paths. interval is how often they are checked for changes.
+func NewVulnerabilityFeedJob(paths []string, interval time.Duration, advisoryRepo *repository.AdvisoryRepository, scanner *VulnerabilityScanner) *VulnerabilityFeedJob {
+	return &VulnerabilityFeedJob{
+		paths:        paths,
+		interval:     interval,
+		advisoryRepo: advisoryRepo,
+		scanner:      scanner,
+	}
+}
+
+// Run checks the feed until ctx is cancelled. Call from a goroutine.
+func (j *VulnerabilityFeedJob) Run(ctx context.Context) {
+	if len(j.paths) == 0 {
+		log.Printf("Vulnerabilities: no advisory feed configured (VULN_FEED_PATHS)")
+		return
+	}
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	j.runOnce(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			j.runOnce(ctx)
+		}
+	}
+}
+
+// runOnce imports the feed if a file changed since the last import, which
+// also covers the first start.
+func (j *VulnerabilityFeedJob) runOnce(ctx context.Context) {
+	last, err := j.advisoryRepo.LastImport(ctx)
+	if err != nil {
+		log.Printf("Vulnerabilities: failed to read last import time: %v", err)
+		return
+	}
+	changed := false
+	for _, p := range j.paths {
+		mod, err := advisory.ModTime(p)
+		if err != nil {
+			log.Printf("Vulnerabilities: advisory feed %s: %v", p, err)
+			return
+		}
+		if mod.After(last) {
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+	if err := j.importFeed(ctx); err != nil {
+		log.Printf("Vulnerabilities: import failed: %v", err)
+		return
+	}
+	if err := j.scanner.ScanAll(ctx); err != nil {
+		log.Printf("Vulnerabilities: rescan failed: %v", err)
+	}
+}
+
+// importFeed loads every advisory in the feed and removes those no longer
+// in it. Nothing is removed when a file fails to load, so a truncated
+// download does not hide vulnerabilities.
+func (j *VulnerabilityFeedJob) importFeed(ctx context.Context) error {
+	importedAt := time.Now().UTC()
+	var batch []*models.Advisory
+	flush := func() error {
+		err := j.advisoryRepo.Upsert(ctx, batch)
+		batch = batch[:0]
+		return err
+	}
+	total := 0
+	for _, p := range j.paths {
+		err := advisory.Load(p, func(a *models.Advisory) error {
+			a.ImportedAt = importedAt
+			batch = append(batch, a)
+			total++
+			if len(batch) >= advisoryBatchSize {
+				return flush()
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	removed, err := j.advisoryRepo.DeleteImportedBefore(ctx, importedAt)
+	if err != nil {
+		return err
+	}
+	log.Printf("Vulnerabilities: imported %d advisories, removed %d (%s)", total, removed, time.Since(importedAt).Round(time.Second))
+	return nil
+}
@@ -0,0 +1,80 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/lute/api/advisory"
+	"github.com/lute/api/models"
+	"github.com/lute/api/repository"
+)
+
+// VulnerabilityScanner matches machine inventories against the imported
+// advisories and stores the findings.
+type VulnerabilityScanner struct {
+	advisoryRepo  *repository.AdvisoryRepository
+	findingRepo   *repository.VulnerabilityFindingRepository
+	inventoryRepo *repository.MachineInventoryRepository
+}
+
+func NewVulnerabilityScanner(advisoryRepo *repository.AdvisoryRepository, findingRepo *repository.VulnerabilityFindingRepository, inventoryRepo *repository.MachineInventoryRepository) *VulnerabilityScanner {
+	return &VulnerabilityScanner{
+		advisoryRepo:  advisoryRepo,
+		findingRepo:   findingRepo,
+		inventoryRepo: inventoryRepo,
+	}
+}
+
+// ScanInventory replaces the findings of the machine inv belongs to. Machines
+// running a distribution no feed covers have none.
+func (s *VulnerabilityScanner) ScanInventory(ctx context.Context, inv *models.MachineInventory) error {
+	distro := advisory.Distro(inv.OSID, inv.OSVersionID)
+	advisories, err := s.advisoryRepo.FindAffecting(ctx, distro, advisory.PackageNames(inv.Packages))
+	if err != nil {
+		return err
+	}
+	findings := advisory.Match(distro, inv.Packages, advisories)
+	now := time.Now().UTC()
+	for _, f := range findings {
+		f.MachineID = inv.MachineID
+		f.DetectedAt = now
+	}
+	return s.findingRepo.ReplaceForMachine(ctx, inv.MachineID, findings)
+}
+
+// ScanMachine rescans the stored inventory of a machine.
+func (s *VulnerabilityScanner) ScanMachine(ctx context.Context, machineID primitive.ObjectID) error {
+	inv, err := s.inventoryRepo.Get(ctx, machineID)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return s.ScanInventory(ctx, inv)
+}
+
+// ScanAll rescans every machine that reported an inventory, after the
+// advisories changed.
+func (s *VulnerabilityScanner) ScanAll(ctx context.Context) error {
+	ids, err := s.inventoryRepo.MachineIDs(ctx)
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := s.ScanMachine(ctx, id); err != nil {
+			log.Printf("Vulnerabilities: failed to scan machine %s: %v", id.Hex(), err)
+			failed++
+		}
+	}
+	log.Printf("Vulnerabilities: scanned %d machines (%d failed)", len(ids)-failed, failed)
+	return nil
+}
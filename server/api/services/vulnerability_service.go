@@ -0,0 +1,245 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/lute/api/advisory"
+	"github.com/lute/api/authz"
+	"github.com/lute/api/models"
+	"github.com/lute/api/repository"
+)
+
+var ErrVulnerabilityNotFound = errors.New("no visible machine is affected by this vulnerability")
+
+// VulnerabilityService answers questions about the findings of
+// VulnerabilityScanner: per machine and fleet-wide, by CVE.
+type VulnerabilityService struct {
+	findingRepo  *repository.VulnerabilityFindingRepository
+	advisoryRepo *repository.AdvisoryRepository
+	machines     *MachineService
+}
+
+func NewVulnerabilityService(findingRepo *repository.VulnerabilityFindingRepository, advisoryRepo *repository.AdvisoryRepository, machines *MachineService) *VulnerabilityService {
+	return &VulnerabilityService{
+		findingRepo:  findingRepo,
+		advisoryRepo: advisoryRepo,
+		machines:     machines,
+	}
+}
+
+// AffectedMachine is a machine a vulnerability was found on.
+type AffectedMachine struct {
+	MachineID   primitive.ObjectID `json:"machine_id"`
+	MachineName string             `json:"machine_name"`
+}
+
+// VulnerabilitySummary is a CVE found on the fleet.
+type VulnerabilitySummary struct {
+	ID           string            `json:"id"` // CVE ID, or advisory ID when it names no CVE
+	Severity     string            `json:"severity"`
+	Score        float64           `json:"score,omitempty"`
+	Summary      string            `json:"summary,omitempty"`
+	Advisories   []string          `json:"advisories"`
+	Packages     []string          `json:"packages"`
+	Fixable      bool              `json:"fixable"` // a fixed version is known
+	MachineCount int               `json:"machine_count"`
+	Machines     []AffectedMachine `json:"machines"`
+}
+
+// VulnerabilityDetail is a CVE with the affected packages of every machine.
+type VulnerabilityDetail struct {
+	VulnerabilitySummary
+	Findings []*models.VulnerabilityFinding `json:"findings"`
+}
+
+// VulnerabilityFeedStatus describes the imported advisory database.
+type VulnerabilityFeedStatus struct {
+	Advisories int64      `json:"advisories"`
+	ImportedAt *time.Time `json:"imported_at,omitempty"`
+}
+
+// severityFilter returns the severities at least as severe as min, or nil
+// (no filter) when min is empty.
+func severityFilter(min string) []string {
+	if min == "" {
+		return nil
+	}
+	return advisory.SeveritiesAtLeast(min)
+}
+
+// ForMachine returns the findings of a machine the user may read, most
+// severe first.
+func (s *VulnerabilityService) ForMachine(ctx context.Context, machineID, userID primitive.ObjectID, minSeverity string) ([]*models.VulnerabilityFinding, error) {
+	if _, err := s.machines.GetForUser(ctx, machineID, userID, authz.ActionMachineRead); err != nil {
+		return nil, err
+	}
+	findings, err := s.findingRepo.GetByMachineIDs(ctx, []primitive.ObjectID{machineID}, severityFilter(minSeverity))
+	if err != nil {
+		return nil, err
+	}
+	sortFindings(findings)
+	if findings == nil {
+		findings = []*models.VulnerabilityFinding{}
+	}
+	return findings, nil
+}
+
+// Fleet returns the CVEs found on the machines visible to the user that
+// match filter, most severe and widespread first.
+func (s *VulnerabilityService) Fleet(ctx context.Context, userID primitive.ObjectID, filter MachineFilter, minSeverity string) ([]VulnerabilitySummary, error) {
+	ids, names, err := s.visibleMachines(ctx, userID, filter)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := s.findingRepo.SummarizeByCVE(ctx, ids, severityFilter(minSeverity))
+	if err != nil {
+		return nil, err
+	}
+	out := make([]VulnerabilitySummary, 0, len(groups))
+	for _, g := range groups {
+		out = append(out, summarize(g, names))
+	}
+	sort.Slice(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		if ra, rb := advisory.SeverityRank(a.Severity), advisory.SeverityRank(b.Severity); ra != rb {
+			return ra > rb
+		}
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if a.MachineCount != b.MachineCount {
+			return a.MachineCount > b.MachineCount
+		}
+		return a.ID < b.ID
+	})
+	return out, nil
+}
+
+// Get returns a CVE with its findings on the machines visible to the user
+// that match filter.
+func (s *VulnerabilityService) Get(ctx context.Context, userID primitive.ObjectID, filter MachineFilter, id string) (*VulnerabilityDetail, error) {
+	ids, names, err := s.visibleMachines(ctx, userID, filter)
+	if err != nil {
+		return nil, err
+	}
+	findings, err := s.findingRepo.GetByCVE(ctx, ids, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(findings) == 0 {
+		return nil, ErrVulnerabilityNotFound
+	}
+	sortFindings(findings)
+
+	g := &repository.CVEFindings{CVE: id, Summary: findings[0].Summary}
+	advisories := make(map[string]bool)
+	packages := make(map[string]bool)
+	machines := make(map[primitive.ObjectID]bool)
+	severities := make(map[string]bool)
+	for _, f := range findings {
+		g.Score = max(g.Score, f.Score)
+		g.Fixable = g.Fixable || f.FixedVersion != ""
+		if !severities[f.Severity] {
+			severities[f.Severity] = true
+			g.Severities = append(g.Severities, f.Severity)
+		}
+		if !advisories[f.AdvisoryID] {
+			advisories[f.AdvisoryID] = true
+			g.Advisories = append(g.Advisories, f.AdvisoryID)
+		}
+		if !packages[f.Package.Name] {
+			packages[f.Package.Name] = true
+			g.Packages = append(g.Packages, f.Package.Name)
+		}
+		if !machines[f.MachineID] {
+			machines[f.MachineID] = true
+			g.MachineIDs = append(g.MachineIDs, f.MachineID)
+		}
+	}
+	return &VulnerabilityDetail{VulnerabilitySummary: summarize(g, names), Findings: findings}, nil
+}
+
+// FeedStatus reports how many advisories are stored and when they were
+// imported.
+func (s *VulnerabilityService) FeedStatus(ctx context.Context) (*VulnerabilityFeedStatus, error) {
+	count, err := s.advisoryRepo.Count(ctx)
+	if err != nil {
+		return nil, err
+	}
+	last, err := s.advisoryRepo.LastImport(ctx)
+	if err != nil {
+		return nil, err
+	}
+	status := &VulnerabilityFeedStatus{Advisories: count}
+	if !last.IsZero() {
+		status.ImportedAt = &last
+	}
+	return status, nil
+}
+
+// visibleMachines returns the IDs and names of the machines visible to the
+// user that match filter.
+func (s *VulnerabilityService) visibleMachines(ctx context.Context, userID primitive.ObjectID, filter MachineFilter) ([]primitive.ObjectID, map[primitive.ObjectID]string, error) {
+	machines, err := s.machines.GetByUserIDFiltered(ctx, userID, filter)
+	if err != nil {
+		return nil, nil, err
+	}
+	ids := make([]primitive.ObjectID, 0, len(machines))
+	names := make(map[primitive.ObjectID]string, len(machines))
+	for _, m := range machines {
+		ids = append(ids, m.ID)
+		names[m.ID] = m.Name
+	}
+	return ids, names, nil
+}
+
+// summarize turns grouped findings into a summary. Advisories may rate the
+// same CVE differently (e.g. Debian and Ubuntu); the highest rating is used.
+func summarize(g *repository.CVEFindings, names map[primitive.ObjectID]string) VulnerabilitySummary {
+	severity := advisory.SeverityUnknown
+	for _, s := range g.Severities {
+		if advisory.SeverityRank(s) > advisory.SeverityRank(severity) {
+			severity = s
+		}
+	}
+	sort.Strings(g.Advisories)
+	sort.Strings(g.Packages)
+	machines := make([]AffectedMachine, 0, len(g.MachineIDs))
+	for _, id := range g.MachineIDs {
+		machines = append(machines, AffectedMachine{MachineID: id, MachineName: names[id]})
+	}
+	sort.Slice(machines, func(i, j int) bool { return machines[i].MachineName < machines[j].MachineName })
+	return VulnerabilitySummary{
+		ID:           g.CVE,
+		Severity:     severity,
+		Score:        g.Score,
+		Summary:      g.Summary,
+		Advisories:   g.Advisories,
+		Packages:     g.Packages,
+		Fixable:      g.Fixable,
+		MachineCount: len(machines),
+		Machines:     machines,
+	}
+}
+
+// sortFindings orders findings most severe first, then by package.
+func sortFindings(findings []*models.VulnerabilityFinding) {
+	sort.Slice(findings, func(i, j int) bool {
+		a, b := findings[i], findings[j]
+		if ra, rb := advisory.SeverityRank(a.Severity), advisory.SeverityRank(b.Severity); ra != rb {
+			return ra > rb
+		}
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if a.Package.Name != b.Package.Name {
+			return a.Package.Name < b.Package.Name
+		}
+		return a.AdvisoryID < b.AdvisoryID
+	})
+}
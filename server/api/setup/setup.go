@@ -2,24 +2,54 @@ package setup
 
 import (
 	"context"
+	"crypto/ed25519"
+	"fmt"
 	"log"
 	"time"
 
+	"github.com/lute/api/agentbin"
+	"github.com/lute/api/auth"
 	"github.com/lute/api/config"
 	"github.com/lute/api/database"
 	"github.com/lute/api/middleware"
+	"github.com/lute/api/pki"
 	"github.com/lute/api/repository"
 )
 
 // Dependencies holds all initialized dependencies
 type Dependencies struct {
-	Config               *config.Config
-	Database             *database.MongoDB
-	MachineRepo          *repository.MachineRepository
-	UserRepo             *repository.UserRepository
-	CommandRepo          *repository.CommandRepository
-	UptimeSnapshotRepo   *repository.UptimeSnapshotRepository
-	MachineSnapshotRepo  *repository.MachineSnapshotRepository
+	Config              *config.Config
+	Database            *database.MongoDB
+	Authenticator       auth.Authenticator
+	CertAuthority       *pki.Authority
+	AgentBinaries       *agentbin.Index
+	MachineRepo         *repository.MachineRepository
+	UserRepo            *repository.UserRepository
+	CommandRepo         *repository.CommandRepository
+	UptimeSnapshotRepo  *repository.UptimeSnapshotRepository
+	MachineSnapshotRepo *repository.MachineSnapshotRepository
+	MachineGroupRepo    *repository.MachineGroupRepository
+	OrganizationRepo    *repository.OrganizationRepository
+	OrgMemberRepo       *repository.OrgMemberRepository
+	OrgInviteRepo       *repository.OrgInviteRepository
+	APITokenRepo        *repository.APITokenRepository
+	AuditRepo           *repository.AuditRepository
+	EnrollmentTokenRepo *repository.EnrollmentTokenRepository
+	AgentRolloutRepo    *repository.AgentRolloutRepository
+	InventoryRepo       *repository.MachineInventoryRepository
+	InventoryChangeRepo *repository.InventoryChangeRepository
+	AdvisoryRepo        *repository.AdvisoryRepository
+	VulnFindingRepo     *repository.VulnerabilityFindingRepository
+	FileEventRepo       *repository.FileEventRepository
+	UnitsRepo           *repository.MachineUnitsRepository
+	AlertRuleRepo       *repository.AlertRuleRepository
+	AlertRepo           *repository.AlertRepository
+	FileTransferRepo    *repository.FileTransferRepository
+	FileChunkRepo       *repository.FileChunkRepository
+	JobRepo             *repository.JobRepository
+	ScheduleRepo        *repository.ScheduleRepository
+	ScheduleRunRepo     *repository.ScheduleRunRepository
+	MachineConfigRepo   *repository.MachineConfigRepository
 }
 
 // Initialize loads configuration and initializes all dependencies
@@ -29,25 +59,61 @@ func Initialize() (*Dependencies, error) {
 		return nil, err
 	}
 
-	if err := initializeFirebase(cfg); err != nil {
+	db, err := initializeDatabase(cfg)
+	if err != nil {
 		return nil, err
 	}
 
-	db, err := initializeDatabase(cfg)
+	repos := initializeRepositories(db)
+
+	authenticator, err := initializeAuth(cfg, repos)
 	if err != nil {
 		return nil, err
 	}
 
-	repos := initializeRepositories(db)
+	authority, err := pki.NewAuthority(cfg.GRPC.TLS, repos.AgentCertRepo)
+	if err != nil {
+		return nil, err
+	}
+
+	binaries, err := initializeAgentBinaries(cfg)
+	if err != nil {
+		return nil, err
+	}
 
 	return &Dependencies{
 		Config:              cfg,
 		Database:            db,
+		Authenticator:       authenticator,
+		CertAuthority:       authority,
+		AgentBinaries:       binaries,
 		MachineRepo:         repos.MachineRepo,
 		UserRepo:            repos.UserRepo,
 		CommandRepo:         repos.CommandRepo,
 		UptimeSnapshotRepo:  repos.UptimeSnapshotRepo,
 		MachineSnapshotRepo: repos.MachineSnapshotRepo,
+		MachineGroupRepo:    repos.MachineGroupRepo,
+		OrganizationRepo:    repos.OrganizationRepo,
+		OrgMemberRepo:       repos.OrgMemberRepo,
+		OrgInviteRepo:       repos.OrgInviteRepo,
+		APITokenRepo:        repos.APITokenRepo,
+		AuditRepo:           repos.AuditRepo,
+		EnrollmentTokenRepo: repos.EnrollmentTokenRepo,
+		AgentRolloutRepo:    repos.AgentRolloutRepo,
+		InventoryRepo:       repos.InventoryRepo,
+		InventoryChangeRepo: repos.InventoryChangeRepo,
+		AdvisoryRepo:        repos.AdvisoryRepo,
+		VulnFindingRepo:     repos.VulnFindingRepo,
+		FileEventRepo:       repos.FileEventRepo,
+		UnitsRepo:           repos.UnitsRepo,
+		AlertRuleRepo:       repos.AlertRuleRepo,
+		AlertRepo:           repos.AlertRepo,
+		FileTransferRepo:    repos.FileTransferRepo,
+		FileChunkRepo:       repos.FileChunkRepo,
+		JobRepo:             repos.JobRepo,
+		ScheduleRepo:        repos.ScheduleRepo,
+		ScheduleRunRepo:     repos.ScheduleRunRepo,
+		MachineConfigRepo:   repos.MachineConfigRepo,
 	}, nil
 }
 
@@ -60,6 +126,23 @@ func (d *Dependencies) Close() {
 	}
 }
 
+// initializeAgentBinaries indexes the signed agent binaries served to
+// installers and offered to agents by rollouts
+func initializeAgentBinaries(cfg *config.Config) (*agentbin.Index, error) {
+	var publicKey ed25519.PublicKey
+	if cfg.AgentBinary.SigningPublicKey != "" {
+		key, err := agentbin.ParsePublicKey(cfg.AgentBinary.SigningPublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("AGENT_SIGNING_PUBLIC_KEY: %w", err)
+		}
+		publicKey = key
+	}
+	if cfg.AgentBinary.AllowUnsigned {
+		log.Println("Warning: AGENT_ALLOW_UNSIGNED is set; unsigned agent binaries will be served")
+	}
+	return agentbin.New(cfg.AgentBinary.Dir, publicKey, cfg.AgentBinary.AllowUnsigned), nil
+}
+
 // loadConfig loads application configuration
 func loadConfig() (*config.Config, error) {
 	cfg, err := config.Load()
@@ -69,19 +152,16 @@ func loadConfig() (*config.Config, error) {
 	return cfg, nil
 }
 
-// initializeFirebase initializes Firebase authentication
-func initializeFirebase(cfg *config.Config) error {
-	if cfg.Firebase.ProjectID == "" {
-		log.Println("Warning: FIREBASE_PROJECT_ID not set, Firebase authentication will not work")
-		return nil
-	}
-
-	if err := middleware.InitFirebase(cfg.Firebase.ProjectID); err != nil {
-		return err
+// initializeAuth creates the authentication provider selected by AUTH_PROVIDER
+// (firebase, oidc or local) and installs it in the auth middleware
+func initializeAuth(cfg *config.Config, repos *Repositories) (auth.Authenticator, error) {
+	a, err := auth.New(context.Background(), cfg, repos.UserRepo, repos.SessionRepo)
+	if err != nil {
+		return nil, err
 	}
-
-	log.Println("Firebase initialized successfully")
-	return nil
+	middleware.SetAuthenticator(a)
+	log.Printf("Authentication provider: %s", a.Name())
+	return a, nil
 }
 
 // initializeDatabase connects to MongoDB
@@ -95,11 +175,35 @@ func initializeDatabase(cfg *config.Config) (*database.MongoDB, error) {
 
 // Repositories holds all repository instances
 type Repositories struct {
-	MachineRepo        *repository.MachineRepository
-	UserRepo           *repository.UserRepository
-	CommandRepo        *repository.CommandRepository
-	UptimeSnapshotRepo *repository.UptimeSnapshotRepository
+	MachineRepo         *repository.MachineRepository
+	UserRepo            *repository.UserRepository
+	CommandRepo         *repository.CommandRepository
+	UptimeSnapshotRepo  *repository.UptimeSnapshotRepository
 	MachineSnapshotRepo *repository.MachineSnapshotRepository
+	MachineGroupRepo    *repository.MachineGroupRepository
+	OrganizationRepo    *repository.OrganizationRepository
+	OrgMemberRepo       *repository.OrgMemberRepository
+	OrgInviteRepo       *repository.OrgInviteRepository
+	APITokenRepo        *repository.APITokenRepository
+	AuditRepo           *repository.AuditRepository
+	EnrollmentTokenRepo *repository.EnrollmentTokenRepository
+	AgentRolloutRepo    *repository.AgentRolloutRepository
+	InventoryRepo       *repository.MachineInventoryRepository
+	InventoryChangeRepo *repository.InventoryChangeRepository
+	AdvisoryRepo        *repository.AdvisoryRepository
+	VulnFindingRepo     *repository.VulnerabilityFindingRepository
+	FileEventRepo       *repository.FileEventRepository
+	UnitsRepo           *repository.MachineUnitsRepository
+	AlertRuleRepo       *repository.AlertRuleRepository
+	AlertRepo           *repository.AlertRepository
+	FileTransferRepo    *repository.FileTransferRepository
+	FileChunkRepo       *repository.FileChunkRepository
+	JobRepo             *repository.JobRepository
+	ScheduleRepo        *repository.ScheduleRepository
+	ScheduleRunRepo     *repository.ScheduleRunRepository
+	MachineConfigRepo   *repository.MachineConfigRepository
+	SessionRepo         *repository.SessionRepository
+	AgentCertRepo       *repository.AgentCertificateRepository
 }
 
 // initializeRepositories creates all repository instances
@@ -110,5 +214,29 @@ func initializeRepositories(db *database.MongoDB) *Repositories {
 		CommandRepo:         repository.NewCommandRepository(db.Database),
 		UptimeSnapshotRepo:  repository.NewUptimeSnapshotRepository(db.Database),
 		MachineSnapshotRepo: repository.NewMachineSnapshotRepository(db.Database),
+		MachineGroupRepo:    repository.NewMachineGroupRepository(db.Database),
+		OrganizationRepo:    repository.NewOrganizationRepository(db.Database),
+		OrgMemberRepo:       repository.NewOrgMemberRepository(db.Database),
+		OrgInviteRepo:       repository.NewOrgInviteRepository(db.Database),
+		APITokenRepo:        repository.NewAPITokenRepository(db.Database),
+		AuditRepo:           repository.NewAuditRepository(db.Database),
+		EnrollmentTokenRepo: repository.NewEnrollmentTokenRepository(db.Database),
+		AgentRolloutRepo:    repository.NewAgentRolloutRepository(db.Database),
+		InventoryRepo:       repository.NewMachineInventoryRepository(db.Database),
+		InventoryChangeRepo: repository.NewInventoryChangeRepository(db.Database),
+		AdvisoryRepo:        repository.NewAdvisoryRepository(db.Database),
+		VulnFindingRepo:     repository.NewVulnerabilityFindingRepository(db.Database),
+		FileEventRepo:       repository.NewFileEventRepository(db.Database),
+		UnitsRepo:           repository.NewMachineUnitsRepository(db.Database),
+		AlertRuleRepo:       repository.NewAlertRuleRepository(db.Database),
+		AlertRepo:           repository.NewAlertRepository(db.Database),
+		FileTransferRepo:    repository.NewFileTransferRepository(db.Database),
+		FileChunkRepo:       repository.NewFileChunkRepository(db.Database),
+		JobRepo:             repository.NewJobRepository(db.Database),
+		ScheduleRepo:        repository.NewScheduleRepository(db.Database),
+		ScheduleRunRepo:     repository.NewScheduleRunRepository(db.Database),
+		MachineConfigRepo:   repository.NewMachineConfigRepository(db.Database),
+		SessionRepo:         repository.NewSessionRepository(db.Database),
+		AgentCertRepo:       repository.NewAgentCertificateRepository(db.Database),
 	}
 }
@@ -0,0 +1,15 @@
+// Package tokens holds what the secrets the server hands out have in
+// common: login sessions, API tokens, org invites, enrollment tokens and
+// agent tokens are only stored as their hash.
+package tokens
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// Hash returns the SHA-256 hex of a token, as stored in place of the token.
+func Hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -56,12 +56,9 @@ const AddMachineDialog = ({ open, onClose }: AddMachineDialogProps) => {
       .finally(() => setClaimLoading(false));
   }, [open]);
 
-  const installCommand = `curl -sSL ${API_URL}/api/v1/agent/install.sh | bash`;
-  const setupCommand = `lute-agent --setup --api ${API_URL}`;
+  const installCommand = `curl -sSL ${API_URL}/api/v1/agent/install.sh | sudo bash -s --`;
   const fullCommand =
-    claimCode != null
-      ? `${installCommand} && ${setupCommand} --claim-code ${claimCode}`
-      : '';
+    claimCode != null ? `${installCommand} --claim-code ${claimCode}` : '';
 
   const handleCopy = async () => {
     if (!fullCommand) return;
@@ -182,8 +179,8 @@ const AddMachineDialog = ({ open, onClose }: AddMachineDialogProps) => {
         )}
 
         <Typography variant="body2" color="text.secondary" sx={{ mt: 2 }}>
-          The agent will prompt for a service name, collect system info, then
-          start in the background and send heartbeats to the server.
+          On Linux the installer registers the machine and runs the agent as a
+          system service (systemd, OpenRC or SysV) that starts on boot.
         </Typography>
       </DialogContent>
 
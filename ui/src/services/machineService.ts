@@ -9,11 +9,10 @@ export interface CreateMachineRequest {
 }
 
 export interface UpdateMachineRequest {
-    name?: string;
+    name: string;
     description?: string;
-    status?: string;
-    isPublic?: boolean;
-    metadata?: Record<string, unknown>;
+    is_public?: boolean;
+    labels?: Record<string, string>;
 }
 
 export const machineService = {
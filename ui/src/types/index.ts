@@ -9,6 +9,7 @@ export interface User {
 export interface Machine {
   id: string;
   user_id: string;
+  org_id?: string;
   name: string;
   description?: string;
   status: 'running' | 'stopped' | 'paused' | 'pending' | 'alive' | 'dead';
@@ -17,12 +18,31 @@ export interface Machine {
   agent_version?: string;
   last_seen?: string;
   metadata?: Record<string, unknown>;
+  /** Key/value labels used by label selectors (e.g. env=prod). */
+  labels?: Record<string, string>;
+  /** IDs of the user-defined groups this machine belongs to. */
+  group_ids?: string[];
   /** Canonical keys: cpu_load, mem_usage_mb, disk_used_gb, disk_total_gb (numbers). */
   metrics?: Record<string, string | number>;
+  /** Reported by the agent each time it connects. */
+  host?: HostInfo;
   created_at: string;
   updated_at: string;
 }
 
+export interface HostInfo {
+  os?: string;
+  arch?: string;
+  os_release?: string;
+  kernel?: string;
+  ip_addresses?: string[];
+  boot_id?: string;
+  booted_at?: string;
+  protocol_version: number;
+  /** Protocol features negotiated with the agent, e.g. "self_update". */
+  features?: string[];
+}
+
 // Legacy VM interface for backward compatibility (can be removed later)
 export interface VM {
   id: string;